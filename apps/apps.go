@@ -0,0 +1,86 @@
+// Package apps keeps the applications sending notifications, each with its
+// own app token and default priority, like the applications of a Gotify
+// server. They are kept in the storage backend, or in a JSON file when it
+// is not durable, which the import-gotify command fills from an existing
+// Gotify server.
+package apps
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
+)
+
+// Application is a sender of notifications
+type Application struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Token       string `json:"token"`
+
+	// DefaultPriority is the priority of notifications sent with the token
+	// without one; 0 keeps the usual default
+	DefaultPriority int `json:"default_priority,omitempty"`
+}
+
+// TokenField is the field keying applications in the collection
+// storing them
+const TokenField = "token"
+
+// List reads the applications stored in c
+func List(c storage.Collection) ([]Application, error) {
+	records, err := c.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applications: %w", err)
+	}
+
+	applications := make([]Application, 0, len(records))
+	for _, record := range records {
+		var app Application
+		if err := json.Unmarshal(record.Value, &app); err != nil {
+			return nil, fmt.Errorf("failed to parse application %s: %w", record.Key, err)
+		}
+		if app.Token == "" {
+			return nil, fmt.Errorf("application %s has no token", app.Name)
+		}
+		applications = append(applications, app)
+	}
+	return applications, nil
+}
+
+// Import stores imported applications in c. Applications with the token of
+// a stored one replace it, so imports can be repeated. With dryRun the
+// changes are only counted.
+func Import(c storage.Collection, imported []Application, dryRun bool) (added, updated int, err error) {
+	existing, err := List(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	stored := make(map[string]Application, len(existing))
+	for _, app := range existing {
+		stored[app.Token] = app
+	}
+
+	for _, app := range imported {
+		previous, ok := stored[app.Token]
+		if ok && previous == app {
+			continue
+		}
+		if !dryRun {
+			data, err := json.Marshal(app)
+			if err != nil {
+				return added, updated, err
+			}
+			if err := c.Put(app.Token, data); err != nil {
+				return added, updated, fmt.Errorf("failed to store application %s: %w", app.Name, err)
+			}
+		}
+		stored[app.Token] = app
+		if ok {
+			updated++
+		} else {
+			added++
+		}
+	}
+	return added, updated, nil
+}
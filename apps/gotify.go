@@ -0,0 +1,136 @@
+package apps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/sqlite"
+)
+
+// gotifyTimeout bounds requests to the REST API of a Gotify server
+const gotifyTimeout = 30 * time.Second
+
+// GotifyApplication is an application of a Gotify server
+type GotifyApplication struct {
+	ID              int64  `json:"id"`
+	Token           string `json:"token"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	DefaultPriority int    `json:"defaultPriority"`
+
+	// Internal applications belong to Gotify plugins
+	Internal bool `json:"internal"`
+}
+
+// Application converts a Gotify application, keeping its token so senders
+// need no change
+func (g GotifyApplication) Application() Application {
+	return Application{
+		Name:            g.Name,
+		Description:     g.Description,
+		Token:           g.Token,
+		DefaultPriority: g.DefaultPriority,
+	}
+}
+
+// GotifyCredentials authenticate to the REST API of a Gotify server with a
+// client token, or the username and password of a user
+type GotifyCredentials struct {
+	ClientToken string
+	Username    string
+	Password    string
+}
+
+// FromGotifyAPI lists the applications of the user of credentials through
+// the REST API of the Gotify server at baseURL
+func FromGotifyAPI(ctx context.Context, baseURL string, credentials GotifyCredentials) ([]GotifyApplication, error) {
+	ctx, cancel := context.WithTimeout(ctx, gotifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(baseURL, "/")+"/application", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if credentials.ClientToken != "" {
+		req.Header.Set("X-Gotify-Key", credentials.ClientToken)
+	} else {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Gotify: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("Gotify answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var applications []GotifyApplication
+	if err := json.NewDecoder(resp.Body).Decode(&applications); err != nil {
+		return nil, fmt.Errorf("failed to parse Gotify applications: %w", err)
+	}
+	return applications, nil
+}
+
+// FromGotifyDatabase lists the applications of all users in the SQLite
+// database of a Gotify server, e.g. data/gotify.db
+func FromGotifyDatabase(path string) ([]GotifyApplication, error) {
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Rows("applications")
+	if err != nil {
+		return nil, err
+	}
+
+	applications := make([]GotifyApplication, 0, len(rows))
+	for _, row := range rows {
+		app := GotifyApplication{
+			ID:              intValue(row["id"]),
+			Token:           stringValue(row["token"]),
+			Name:            stringValue(row["name"]),
+			Description:     stringValue(row["description"]),
+			DefaultPriority: int(intValue(row["default_priority"])),
+			Internal:        intValue(row["internal"]) != 0 || strings.EqualFold(stringValue(row["internal"]), "true"),
+		}
+		if app.Token == "" {
+			continue
+		}
+		applications = append(applications, app)
+	}
+	return applications, nil
+}
+
+// stringValue returns a text or blob value of a row
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
+
+// intValue returns an integer value of a row
+func intValue(v interface{}) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,72 @@
+// Package assets embeds the files the forwarder ships with: default message
+// templates, the route defaults, the status dashboard, HTML pages and the
+// OpenAPI document.
+// Everything is compiled into the binary, so a single static executable is
+// all a deployment needs.
+package assets
+
+import (
+	"embed"
+	"io/fs"
+	"strings"
+)
+
+//go:embed templates config ui pages openapi
+var files embed.FS
+
+// Template returns the built-in template with the given name, e.g.
+// "alertmanager"; it panics if the template does not exist
+func Template(name string) string {
+	data, err := files.ReadFile("templates/" + name + ".tmpl")
+	if err != nil {
+		panic("assets: missing template " + name)
+	}
+	return strings.TrimRight(string(data), "\n")
+}
+
+// RouteDefaults returns the defaults of the built-in routes, in the format
+// of the routes section of a configuration file
+func RouteDefaults() []byte {
+	data, err := files.ReadFile("config/routes.yaml")
+	if err != nil {
+		panic("assets: missing route defaults")
+	}
+	return data
+}
+
+// UI returns the files of the status dashboard
+func UI() fs.FS {
+	ui, err := fs.Sub(files, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return ui
+}
+
+// Page returns the HTML template of the page with the given name, e.g.
+// "alerts"; it panics if the page does not exist
+func Page(name string) string {
+	data, err := files.ReadFile("pages/" + name + ".html")
+	if err != nil {
+		panic("assets: missing page " + name)
+	}
+	return string(data)
+}
+
+// OpenAPI returns the OpenAPI document describing the HTTP API
+func OpenAPI() []byte {
+	data, err := files.ReadFile("openapi/openapi.json")
+	if err != nil {
+		panic("assets: missing OpenAPI document")
+	}
+	return data
+}
+
+// SwaggerUI returns the self-contained page rendering the OpenAPI document
+func SwaggerUI() []byte {
+	data, err := files.ReadFile("openapi/swagger.html")
+	if err != nil {
+		panic("assets: missing Swagger UI page")
+	}
+	return data
+}
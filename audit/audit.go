@@ -0,0 +1,176 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Delivery statuses recorded in the audit log
+const (
+	StatusSent   = "sent"
+	StatusQueued = "queued"
+	StatusFailed = "failed"
+
+	// StatusSkipped is the outcome of a sink that does not take the
+	// notification, e.g. a route without a chat, or of the targets of a
+	// delivery chain after the one that took it
+	StatusSkipped = "skipped"
+)
+
+// Record describes one forwarding attempt of a notification
+type Record struct {
+	Time     time.Time `json:"time"`
+	ID       string    `json:"id,omitempty"`
+	Route    string    `json:"route,omitempty"`
+	DialogID string    `json:"dialog_id,omitempty"`
+	Priority int       `json:"priority"`
+	Text     string    `json:"text"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+
+	// Template is the variant of the route's template that rendered the
+	// text, "current" or "candidate", for routes with a candidate template
+	Template string `json:"template,omitempty"`
+
+	// Sinks holds the outcome of each sink the notification was handed to
+	Sinks []SinkOutcome `json:"sinks,omitempty"`
+
+	// Signature covers the canonical JSON of all other fields
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+// SinkOutcome is what became of a notification at one sink, or at Mizito
+// in a delivery chain
+type SinkOutcome struct {
+	Sink     string `json:"sink"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Signature is the signature of a record
+type Signature struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Value     string `json:"value"`
+}
+
+// Canonical returns the JSON encoding of the record without its signature,
+// which is what signatures are computed over
+func (r *Record) Canonical() ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = nil
+	unsigned.Time = unsigned.Time.UTC().Round(0)
+	return json.Marshal(&unsigned)
+}
+
+// Log is an append-only audit log with one JSON record per line
+type Log struct {
+	path   string
+	signer Signer
+	mutex  sync.Mutex
+}
+
+// New creates an audit log writing to path. The signer is optional.
+func New(path string, signer Signer) (*Log, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+		}
+	}
+	return &Log{path: path, signer: signer}, nil
+}
+
+// Append signs a record when a signer is configured and appends it to the log
+func (l *Log) Append(rec *Record) error {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	rec.Time = rec.Time.UTC().Round(0)
+
+	if l.signer != nil {
+		canonical, err := rec.Canonical()
+		if err != nil {
+			return fmt.Errorf("failed to encode audit record: %w", err)
+		}
+		rec.Signature = l.signer.Sign(canonical)
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyResult is the outcome of verifying one audit log line
+type VerifyResult struct {
+	Line   int
+	Record *Record
+	Err    error
+}
+
+// Verify checks the signature of every record read from r and calls fn with
+// the result of each line. It returns the number of invalid lines.
+func Verify(r io.Reader, verifier Verifier, fn func(VerifyResult)) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+
+	invalid := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		result := VerifyResult{Line: line}
+
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			result.Err = fmt.Errorf("invalid record: %w", err)
+		} else {
+			result.Record = &rec
+			result.Err = verifyRecord(&rec, verifier)
+		}
+
+		if result.Err != nil {
+			invalid++
+		}
+		fn(result)
+	}
+
+	return invalid, scanner.Err()
+}
+
+// verifyRecord checks the signature of a single record
+func verifyRecord(rec *Record, verifier Verifier) error {
+	if rec.Signature == nil {
+		return fmt.Errorf("record is not signed")
+	}
+
+	canonical, err := rec.Canonical()
+	if err != nil {
+		return err
+	}
+
+	return verifier.Verify(canonical, rec.Signature)
+}
@@ -0,0 +1,179 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Signature algorithms
+const (
+	AlgorithmHMAC    = "HS256"
+	AlgorithmEd25519 = "Ed25519"
+)
+
+// Signer signs canonical audit records
+type Signer interface {
+	Sign(data []byte) *Signature
+}
+
+// Verifier checks signatures of canonical audit records
+type Verifier interface {
+	Verify(data []byte, sig *Signature) error
+}
+
+// errBadSignature is returned for records whose signature does not match
+var errBadSignature = errors.New("signature mismatch: record was modified or signed with another key")
+
+// HMACKey signs and verifies records with HMAC-SHA256
+type HMACKey struct {
+	key []byte
+}
+
+// NewHMACKey creates an HMAC-SHA256 signer and verifier from a shared secret
+func NewHMACKey(secret string) *HMACKey {
+	return &HMACKey{key: []byte(secret)}
+}
+
+// Sign computes the HMAC of data
+func (k *HMACKey) Sign(data []byte) *Signature {
+	return &Signature{
+		Algorithm: AlgorithmHMAC,
+		KeyID:     k.keyID(),
+		Value:     base64.StdEncoding.EncodeToString(k.mac(data)),
+	}
+}
+
+// Verify checks the HMAC of data
+func (k *HMACKey) Verify(data []byte, sig *Signature) error {
+	if sig.Algorithm != AlgorithmHMAC {
+		return fmt.Errorf("unexpected signature algorithm %q", sig.Algorithm)
+	}
+
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	if !hmac.Equal(value, k.mac(data)) {
+		return errBadSignature
+	}
+	return nil
+}
+
+func (k *HMACKey) mac(data []byte) []byte {
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// keyID identifies the key without revealing it
+func (k *HMACKey) keyID() string {
+	return hex.EncodeToString(k.mac([]byte("mizito-forwarder audit key id"))[:4])
+}
+
+// Ed25519Signer signs records with an Ed25519 private key
+type Ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// Sign computes the Ed25519 signature of data
+func (s *Ed25519Signer) Sign(data []byte) *Signature {
+	return &Signature{
+		Algorithm: AlgorithmEd25519,
+		KeyID:     ed25519KeyID(s.key.Public().(ed25519.PublicKey)),
+		Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data)),
+	}
+}
+
+// Ed25519Verifier verifies records with an Ed25519 public key
+type Ed25519Verifier struct {
+	key ed25519.PublicKey
+}
+
+// Verify checks the Ed25519 signature of data
+func (v *Ed25519Verifier) Verify(data []byte, sig *Signature) error {
+	if sig.Algorithm != AlgorithmEd25519 {
+		return fmt.Errorf("unexpected signature algorithm %q", sig.Algorithm)
+	}
+
+	value, err := base64.StdEncoding.DecodeString(sig.Value)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	if !ed25519.Verify(v.key, data, value) {
+		return errBadSignature
+	}
+	return nil
+}
+
+// ed25519KeyID identifies a key pair by its public key
+func ed25519KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// LoadEd25519Signer reads a PEM encoded PKCS #8 Ed25519 private key, as
+// created by "openssl genpkey -algorithm ed25519"
+func LoadEd25519Signer(path string) (*Ed25519Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", path)
+	}
+
+	return &Ed25519Signer{key: privateKey}, nil
+}
+
+// LoadEd25519Verifier reads a PEM encoded Ed25519 public key, as created
+// by "openssl pkey -pubout"
+func LoadEd25519Verifier(path string) (*Ed25519Verifier, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 public key", path)
+	}
+
+	return &Ed25519Verifier{key: publicKey}, nil
+}
+
+// readPEM reads the first PEM block of a file
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded key", path)
+	}
+
+	return block, nil
+}
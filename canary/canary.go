@@ -0,0 +1,292 @@
+// Package canary implements black-box monitoring of the whole delivery path:
+// a tagged test message is periodically posted to the forwarder's own intake
+// and its arrival in the canary dialog is verified through the Mizito API.
+package canary
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+)
+
+// Canary run results
+const (
+	ResultSuccess      = "success"
+	ResultSendFailed   = "send_failed"
+	ResultNotDelivered = "not_delivered"
+
+	// ResultUnverified is the result of runs without CANARY_VERIFY_PATH:
+	// the message was accepted, but its delivery was not checked
+	ResultUnverified = "unverified"
+)
+
+// verifyPollInterval is the time between checks of the canary dialog
+const verifyPollInterval = 5 * time.Second
+
+// maxVerifyBodySize caps how much of a dialog response is searched for the tag
+const maxVerifyBodySize = 4 << 20
+
+// End-to-end delivery metrics
+var (
+	canarySuccess = metrics.NewGauge("mizito_canary_success",
+		"Whether the last canary message was delivered end to end (1) or not (0).")
+	canaryLatency = metrics.NewGauge("mizito_canary_latency_seconds",
+		"Time from posting the last canary message until it was seen in the canary dialog.")
+	canaryLastSuccess = metrics.NewGauge("mizito_canary_last_success_timestamp_seconds",
+		"Unix time of the last successful canary delivery.")
+	canaryRuns = metrics.NewCounter("mizito_canary_runs_total",
+		"Canary runs by result.", "result")
+)
+
+// Canary periodically sends and verifies tagged test messages
+type Canary struct {
+	config *config.Config
+	auth   *mizito.AuthService
+	logger *logger.Logger
+	client *http.Client
+
+	// intake posts to the forwarder's own listener
+	intake *http.Client
+}
+
+// New creates a new canary
+func New(config *config.Config, auth *mizito.AuthService, logger *logger.Logger) *Canary {
+	return &Canary{
+		config: config,
+		auth:   auth,
+		logger: logger,
+		client: &http.Client{Timeout: 30 * time.Second},
+		intake: newIntakeClient(config),
+	}
+}
+
+// newIntakeClient creates the client for the local listener. With TLS the
+// listener is reached on a loopback address its certificate is not issued
+// for, so instead of the host name the client checks that the listener
+// presents the certificate of SERVER_TLS_CERT.
+func newIntakeClient(cfg *config.Config) *http.Client {
+	if !cfg.TLSEnabled() {
+		return &http.Client{Timeout: 30 * time.Second}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		// Replaced by VerifyPeerCertificate, which pins the own certificate
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyOwnCertificate(cfg.ServerTLSCert, rawCerts)
+		},
+	}
+	return &http.Client{Timeout: 30 * time.Second, Transport: transport}
+}
+
+// verifyOwnCertificate reports whether the leaf certificate presented by
+// the listener is the one in certFile. The file is read on every handshake,
+// so renewed certificates are picked up like by the listener.
+func verifyOwnCertificate(certFile string, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("listener presented no certificate")
+	}
+
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("no certificate found in %s", certFile)
+	}
+	if !bytes.Equal(block.Bytes, rawCerts[0]) {
+		return fmt.Errorf("listener does not present the certificate of %s", certFile)
+	}
+	return nil
+}
+
+// Run sends a canary message every CanaryInterval until ctx is cancelled
+func (c *Canary) Run(ctx context.Context) {
+	c.logger.Info("Canary started",
+		"dialog", c.config.CanaryDialogID,
+		"interval", c.config.CanaryInterval,
+		"verify", c.config.CanaryVerifyURL != "")
+	defer c.logger.Info("Canary stopped")
+
+	ticker := time.NewTicker(c.config.CanaryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		result := c.RunOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		canaryRuns.Inc(result)
+		canarySuccess.Set(metrics.BoolValue(result == ResultSuccess))
+	}
+}
+
+// RunOnce posts one canary message and waits for it to arrive, returning
+// the result of the run
+func (c *Canary) RunOnce(ctx context.Context) string {
+	tag := newTag()
+	start := time.Now()
+
+	if err := c.post(ctx, tag); err != nil {
+		c.logger.Warn("Canary message could not be sent", "tag", tag, "error", err)
+		return ResultSendFailed
+	}
+
+	// Without a verify endpoint the delivery cannot be confirmed
+	if c.config.CanaryVerifyURL == "" {
+		c.logger.Debug("Canary message accepted, delivery not verified", "tag", tag)
+		return ResultUnverified
+	}
+	if err := c.verify(ctx, tag); err != nil {
+		c.logger.Warn("Canary message did not arrive", "tag", tag, "timeout", c.config.CanaryTimeout, "error", err)
+		return ResultNotDelivered
+	}
+
+	latency := time.Since(start)
+	canaryLatency.Set(latency.Seconds())
+	canaryLastSuccess.Set(float64(time.Now().Unix()))
+	c.logger.Debug("Canary message delivered", "tag", tag, "latency", latency)
+	return ResultSuccess
+}
+
+// post sends a canary message through the forwarder's own intake, so the
+// run covers authentication, rendering, queueing and sending
+func (c *Canary) post(ctx context.Context, tag string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    "Canary",
+		"message":  "End-to-end delivery check " + tag,
+		"priority": 0,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.intakeURL(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(c.config.AppTokens) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.config.AppTokens[0])
+	}
+
+	resp, err := c.intake.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("intake returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// intakeURL returns the URL of the canary dialog on the local listener,
+// over HTTPS when the listener serves TLS
+func (c *Canary) intakeURL() string {
+	host, port, err := net.SplitHostPort(c.config.ServerPort)
+	if err != nil {
+		host, port = "", strings.TrimPrefix(c.config.ServerPort, ":")
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	scheme := "http"
+	if c.config.TLSEnabled() {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s%s/api/v1/messages/%s",
+		scheme, net.JoinHostPort(host, port), c.config.BasePath, c.config.CanaryDialogID)
+}
+
+// verify polls the canary dialog until the tag shows up or CanaryTimeout passes
+func (c *Canary) verify(ctx context.Context, tag string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.CanaryTimeout)
+	defer cancel()
+
+	for {
+		found, err := c.dialogContains(ctx, tag)
+		if found {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return err
+			}
+			return ctx.Err()
+		case <-time.After(verifyPollInterval):
+		}
+	}
+}
+
+// dialogContains fetches the canary dialog and reports whether it mentions tag
+func (c *Canary) dialogContains(ctx context.Context, tag string) (bool, error) {
+	token, err := c.auth.GetToken(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	url := strings.ReplaceAll(c.config.CanaryVerifyURL, "{dialog}", c.config.CanaryDialogID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	mizito.SetHeaders(req, c.config)
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("x-token", token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("verify request returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVerifyBodySize))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Contains(body, []byte(tag)), nil
+}
+
+// newTag generates a unique tag identifying one canary message
+func newTag() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "canary-" + hex.EncodeToString(b)
+}
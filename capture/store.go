@@ -0,0 +1,261 @@
+package capture
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// redacted replaces sensitive header and query values in stored records
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders lists headers whose values are never written to disk
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+	"X-Gotify-Key":        true,
+	"X-Token":             true,
+}
+
+// sensitiveParams lists query parameters whose values are never written to disk
+var sensitiveParams = []string{"token"}
+
+// Record represents a captured inbound request
+type Record struct {
+	ID         string              `json:"id"`
+	Route      string              `json:"route"`
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Query      string              `json:"query,omitempty"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body,omitempty"`
+	BodyBase64 string              `json:"body_base64,omitempty"`
+	ReceivedAt time.Time           `json:"received_at"`
+}
+
+// NewRecord builds a redacted record from an inbound request and its body
+func NewRecord(route string, r *http.Request, body []byte) *Record {
+	headers := make(map[string][]string, len(r.Header))
+	for name, values := range r.Header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			headers[name] = []string{redacted}
+			continue
+		}
+		headers[name] = append([]string(nil), values...)
+	}
+
+	query := r.URL.Query()
+	for _, param := range sensitiveParams {
+		if query.Has(param) {
+			query.Set(param, redacted)
+		}
+	}
+
+	rec := &Record{
+		ID:         newID(),
+		Route:      route,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      query.Encode(),
+		Headers:    headers,
+		ReceivedAt: time.Now(),
+	}
+
+	// Keep text bodies readable, fall back to base64 for binary payloads
+	if utf8.Valid(body) {
+		rec.Body = string(body)
+	} else {
+		rec.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	return rec
+}
+
+// RawBody returns the original request body of the record
+func (r *Record) RawBody() ([]byte, error) {
+	if r.BodyBase64 != "" {
+		return base64.StdEncoding.DecodeString(r.BodyBase64)
+	}
+	return []byte(r.Body), nil
+}
+
+// Store persists captured records as JSON files in a directory. Once it
+// holds maxRecords records, the oldest are pruned as new ones are saved.
+type Store struct {
+	dir        string
+	maxRecords int
+	mutex      sync.Mutex
+	logger     *logger.Logger
+}
+
+// NewStore creates a new capture store writing to dir and keeping up to
+// maxRecords records, all of them for 0
+func NewStore(dir string, maxRecords int, logger *logger.Logger) *Store {
+	return &Store{
+		dir:        dir,
+		maxRecords: maxRecords,
+		logger:     logger,
+	}
+}
+
+// Save writes a record to disk
+func (s *Store) Save(rec *Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create capture directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal capture record: %w", err)
+	}
+
+	if err := os.WriteFile(s.path(rec.ID), data, 0600); err != nil {
+		return fmt.Errorf("failed to write capture record: %w", err)
+	}
+
+	s.logger.Debug("Captured inbound payload", "id", rec.ID, "route", rec.Route)
+	s.prune()
+	return nil
+}
+
+// prune removes the oldest records beyond maxRecords; the caller holds the
+// mutex
+func (s *Store) prune() {
+	if s.maxRecords <= 0 {
+		return
+	}
+
+	ids, err := s.ids()
+	if err != nil {
+		s.logger.Warn("Failed to prune captured payloads", "error", err)
+		return
+	}
+	for len(ids) > s.maxRecords {
+		if err := os.Remove(s.path(ids[0])); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn("Failed to prune captured payload", "id", ids[0], "error", err)
+		}
+		ids = ids[1:]
+	}
+}
+
+// ids returns the IDs of the stored records, oldest first, as IDs start
+// with the time of capture
+func (s *Store) ids() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read capture directory: %w", err)
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		if id := strings.TrimSuffix(entry.Name(), ".json"); validID(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ti, tj := idTime(ids[i]), idTime(ids[j]); ti != tj {
+			return ti < tj
+		}
+		return ids[i] < ids[j]
+	})
+	return ids, nil
+}
+
+// idTime returns the capture time in nanoseconds an ID starts with
+func idTime(id string) int64 {
+	nanos, _ := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return nanos
+}
+
+// Get reads a single record by ID
+func (s *Store) Get(id string) (*Record, error) {
+	if !validID(id) {
+		return nil, os.ErrNotExist
+	}
+
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to parse capture record: %w", err)
+	}
+
+	return &rec, nil
+}
+
+// List returns a page of the stored records, newest first: up to limit
+// records after skipping offset, and the number of records stored. Only the
+// records of the page are read.
+func (s *Store) List(offset, limit int) ([]*Record, int, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(ids)
+
+	records := make([]*Record, 0, limit)
+	for i := total - 1 - offset; i >= 0 && len(records) < limit; i-- {
+		rec, err := s.Get(ids[i])
+		if err != nil {
+			// Pruned since it was listed, or unreadable
+			if !os.IsNotExist(err) {
+				s.logger.Warn("Skipping unreadable capture record", "id", ids[i], "error", err)
+			}
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	return records, total, nil
+}
+
+// path returns the file path for a record ID
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// newID generates a sortable, unique record ID
+func newID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
+
+// validID guards against path traversal through user supplied IDs
+func validID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
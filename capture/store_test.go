@@ -0,0 +1,83 @@
+package capture
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// newTestStore creates a store in a temporary directory holding records
+// captured a second apart, the last one newest
+func newTestStore(t *testing.T, maxRecords, records int) (*Store, []string) {
+	t.Helper()
+	log, err := logger.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStore(t.TempDir(), maxRecords, log)
+
+	start := time.Now()
+	var ids []string
+	for i := 0; i < records; i++ {
+		rec := NewRecord("message", httptest.NewRequest("POST", "/message", nil), []byte(fmt.Sprint(i)))
+		rec.ID = fmt.Sprintf("%d-%08x", start.Add(time.Duration(i)*time.Second).UnixNano(), i)
+		if err := s.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, rec.ID)
+	}
+	return s, ids
+}
+
+func TestSavePrunesOldest(t *testing.T) {
+	s, ids := newTestStore(t, 3, 5)
+
+	records, total, err := s.List(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(records) != 3 {
+		t.Fatalf("List = %d records of %d, want 3 of 3", len(records), total)
+	}
+	for i, want := range []string{ids[4], ids[3], ids[2]} {
+		if records[i].ID != want {
+			t.Errorf("record %d = %s, want %s", i, records[i].ID, want)
+		}
+	}
+	if _, err := s.Get(ids[0]); err == nil {
+		t.Errorf("oldest record %s kept", ids[0])
+	}
+}
+
+func TestListPages(t *testing.T) {
+	s, ids := newTestStore(t, 0, 5)
+
+	tests := []struct {
+		offset, limit int
+		want          []string
+	}{
+		{0, 2, []string{ids[4], ids[3]}},
+		{2, 2, []string{ids[2], ids[1]}},
+		{4, 2, []string{ids[0]}},
+		{5, 2, nil},
+	}
+	for _, tt := range tests {
+		records, total, err := s.List(tt.offset, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 5 {
+			t.Errorf("List(%d, %d) total = %d, want 5", tt.offset, tt.limit, total)
+		}
+		var got []string
+		for _, rec := range records {
+			got = append(got, rec.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("List(%d, %d) = %v, want %v", tt.offset, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Package client sends notifications to a Mizito Forwarder from Go programs.
+//
+//	c := client.New("https://forwarder.example.com", "app_token")
+//	res, err := c.Send(ctx, client.Notification{
+//		Title:    "Deploy finished",
+//		Message:  "api v1.4.2 is live",
+//		Priority: 5,
+//	})
+//
+// Failed requests are retried with exponential backoff when the forwarder
+// did not take the notification: 429 Too Many Requests and 503 Service
+// Unavailable responses, and network errors before the request was written.
+// Other failures are returned, since the notification may have been
+// delivered and a retry would send it twice.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Default retry settings of clients created with New
+const (
+	DefaultRetries    = 3
+	DefaultBackoff    = 500 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// messagePath is the API v1 endpoint accepting Gotify-style notifications
+const messagePath = "/api/v1/message"
+
+// maxResponseSize caps the response bodies read from the forwarder
+const maxResponseSize = 1 << 20
+
+// Notification is a message to forward to Mizito
+type Notification struct {
+	Title    string                 `json:"title,omitempty"`
+	Message  string                 `json:"message,omitempty"`
+	Priority int                    `json:"priority,omitempty"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+
+	// Dialog optionally targets a specific Mizito dialog; it must be
+	// allowlisted on the forwarder
+	Dialog string `json:"dialog,omitempty"`
+}
+
+// Result is the response of the forwarder to an accepted notification
+type Result struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+
+	// ID identifies the job of a queued notification
+	ID string `json:"id,omitempty"`
+
+	// Queued reports that the notification was accepted into the forwarder's
+	// queue rather than delivered; JobURL is its status URL
+	Queued bool   `json:"-"`
+	JobURL string `json:"-"`
+}
+
+// Error is returned for notifications rejected by the forwarder
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("forwarder responded with status %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed when retried. Only the
+// statuses that the forwarder answers before delivering anything count, so
+// that retries never duplicate notifications.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+}
+
+// notSentError is a network error of a request that was not written, so the
+// forwarder cannot have received it
+type notSentError struct {
+	err error
+}
+
+func (e *notSentError) Error() string { return e.err.Error() }
+func (e *notSentError) Unwrap() error { return e.err }
+
+// Client sends notifications to a forwarder. Its fields may be changed
+// before the first Send.
+type Client struct {
+	// URL is the forwarder URL, including BASE_PATH when one is set
+	URL string
+
+	// Token is the app token; empty when the forwarder has no APP_TOKEN
+	Token string
+
+	// HTTPClient performs the requests
+	HTTPClient *http.Client
+
+	// Retries is the number of retries of temporary failures; the delay
+	// doubles from Backoff up to MaxBackoff, unless the forwarder asks for
+	// a delay with Retry-After
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// New creates a client for the forwarder at url, authenticated with token
+func New(url, token string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(url, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Retries:    DefaultRetries,
+		Backoff:    DefaultBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+}
+
+// Send forwards a notification, retrying temporary failures until the
+// retries are exhausted or ctx is done
+func (c *Client) Send(ctx context.Context, n Notification) (*Result, error) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		res, wait, err := c.send(ctx, body)
+		if err == nil {
+			return res, nil
+		}
+
+		if !retryable(err) || attempt >= c.Retries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		if wait <= 0 {
+			wait = backoff
+			backoff = min(backoff*2, c.MaxBackoff)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether a failed request may be sent again without the
+// risk of delivering the notification twice
+func retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var notSent *notSentError
+	return errors.As(err, &notSent)
+}
+
+// send performs a single request. On failure it returns the delay requested
+// by a Retry-After header, if any.
+func (c *Client) send(ctx context.Context, body []byte) (*Result, time.Duration, error) {
+	// Network errors are only safe to retry if the request never left
+	var wrote atomic.Bool
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) { wrote.Store(true) },
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+messagePath, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		err = fmt.Errorf("failed to send notification: %w", err)
+		if !wrote.Load() {
+			err = &notSentError{err}
+		}
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return nil, retryAfter(resp), &Error{StatusCode: resp.StatusCode, Message: errorMessage(data)}
+	}
+
+	var res Result
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		res.Queued = true
+		if location := resp.Header.Get("Location"); location != "" {
+			if u, err := resp.Request.URL.Parse(location); err == nil {
+				res.JobURL = u.String()
+			}
+		}
+	}
+
+	return &res, 0, nil
+}
+
+// errorMessage extracts the message of an error response, which is either
+// a JSON response or plain text
+func errorMessage(data []byte) string {
+	var res Result
+	if err := json.Unmarshal(data, &res); err == nil && res.Message != "" {
+		return res.Message
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// retryAfter returns the delay of a Retry-After header in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
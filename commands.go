@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/apps"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/audit"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/jwt"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
+	"golang.org/x/term"
+)
+
+// runCommand runs a subcommand and returns the process exit code
+func runCommand(name string, args []string) int {
+	switch name {
+	case "init":
+		return initCommand(args)
+	case "verify-audit":
+		return verifyAuditCommand(args)
+	case "import-token":
+		return importTokenCommand(args)
+	case "import-gotify":
+		return importGotifyCommand(args)
+	case "help", "-h", "-help", "--help":
+		printUsage()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage()
+		return 2
+	}
+}
+
+// printUsage lists the available subcommands
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [command]
+
+Without a command the forwarder server is started.
+
+Commands:
+  init           interactively create a configuration file
+  verify-audit   verify the signatures of an audit log
+  import-token   store an x-token copied from a browser session
+  import-gotify  import the applications and tokens of a Gotify server
+  help           show this help
+`, os.Args[0])
+}
+
+// verifyAuditCommand checks every record of an audit log against an HMAC key
+// or an Ed25519 public key and reports records that fail verification
+func verifyAuditCommand(args []string) int {
+	// Pick up AUDIT_HMAC_KEY and AUDIT_LOG_FILE like the server does
+	if err := config.LoadSettings(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fs := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	hmacKey := fs.String("hmac-key", config.Setting("AUDIT_HMAC_KEY"), "HMAC key the log was signed with (default $AUDIT_HMAC_KEY)")
+	publicKey := fs.String("public-key", "", "PEM file with the Ed25519 public key matching AUDIT_SIGNING_KEY_FILE")
+	verbose := fs.Bool("v", false, "print every record, not only failures")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s verify-audit [flags] [audit log, default $AUDIT_LOG_FILE]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	path := fs.Arg(0)
+	if path == "" {
+		path = config.Setting("AUDIT_LOG_FILE")
+	}
+	if path == "" {
+		fs.Usage()
+		return 2
+	}
+
+	var verifier audit.Verifier
+	switch {
+	case *publicKey != "":
+		v, err := audit.LoadEd25519Verifier(*publicKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		verifier = v
+	case *hmacKey != "":
+		verifier = audit.NewHMACKey(*hmacKey)
+	default:
+		fmt.Fprintln(os.Stderr, "either -hmac-key or -public-key is required")
+		return 2
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	total := 0
+	invalid, err := audit.Verify(f, verifier, func(result audit.VerifyResult) {
+		total++
+		switch {
+		case result.Err != nil:
+			fmt.Printf("line %d: INVALID: %v\n", result.Line, result.Err)
+		case *verbose:
+			rec := result.Record
+			fmt.Printf("line %d: OK %s %s %s %s\n", result.Line, rec.Time.Format("2006-01-02T15:04:05Z07:00"), rec.Status, rec.Route, rec.ID)
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%d records checked, %d valid, %d invalid\n", total, total-invalid, invalid)
+	if invalid > 0 {
+		return 1
+	}
+	return 0
+}
+
+// importTokenCommand stores an x-token copied from a logged in browser
+// session in the token file, for accounts whose login requires an
+// interactive CAPTCHA. The token is read from stdin unless given as argument.
+func importTokenCommand(args []string) int {
+	// Pick up JWT_TOKEN_FILE like the server does
+	if err := config.LoadSettings(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	cfg := config.DefaultConfig()
+	if file := config.Setting("JWT_TOKEN_FILE"); file != "" {
+		cfg.JWTTokenFile = file
+	}
+
+	fs := flag.NewFlagSet("import-token", flag.ContinueOnError)
+	fs.StringVar(&cfg.JWTTokenFile, "file", cfg.JWTTokenFile, "token file to write (default $JWT_TOKEN_FILE)")
+	uid := fs.String("uid", "", "last login UID of the session, if known")
+	expires := fs.String("expires", "", "token expiry as RFC 3339 time or duration from now, e.g. 72h (default: exp claim of the token)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import-token [flags] [token, default read from stdin]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var expiresAt time.Time
+	if *expires != "" {
+		var err error
+		if expiresAt, err = jwt.ParseExpiry(*expires); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	token := fs.Arg(0)
+	if token == "" {
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprint(os.Stderr, "Paste the x-token header value: ")
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr, "no token given")
+			return 2
+		}
+		token = line
+	}
+
+	log, _ := logger.NewLogger("warn")
+	if err := jwt.NewManager(cfg, log).ImportToken(token, *uid, expiresAt); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("Token written to %s. Restart the server or use POST /api/v1/token to apply it to a running instance.\n", cfg.JWTTokenFile)
+	return 0
+}
+
+// importGotifyCommand imports the applications of a Gotify server, with
+// their tokens and default priorities, into the storage backend, or
+// APPLICATIONS_FILE when it is not durable, so senders keep their tokens
+// when moving to the forwarder. They are read from the
+// SQLite database of the server or through its REST API.
+func importGotifyCommand(args []string) int {
+	// Pick up APPLICATIONS_FILE, JWT_TOKEN_FILE and STORAGE_* like the server
+	if err := config.LoadSettings(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	file := config.Setting("APPLICATIONS_FILE")
+	if file == "" {
+		tokenFile := config.DefaultConfig().JWTTokenFile
+		if env := config.Setting("JWT_TOKEN_FILE"); env != "" {
+			tokenFile = env
+		}
+		file = filepath.Join(filepath.Dir(tokenFile), "applications.json")
+	}
+
+	fs := flag.NewFlagSet("import-gotify", flag.ContinueOnError)
+	fs.StringVar(&file, "file", file, "applications file to write unless the storage backend is durable (default $APPLICATIONS_FILE)")
+	database := fs.String("db", "", "SQLite database of the Gotify server, e.g. data/gotify.db")
+	baseURL := fs.String("url", "", "URL of the Gotify server, to import through its REST API instead")
+	clientToken := fs.String("client-token", config.Setting("GOTIFY_CLIENT_TOKEN"), "client token for the REST API (default $GOTIFY_CLIENT_TOKEN)")
+	username := fs.String("user", "", "Gotify user for the REST API, instead of a client token")
+	password := fs.String("password", config.Setting("GOTIFY_PASSWORD"), "password of -user (default $GOTIFY_PASSWORD, prompted if empty)")
+	internal := fs.Bool("internal", false, "also import the internal applications of Gotify plugins")
+	dryRun := fs.Bool("dry-run", false, "list the applications without writing the file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import-gotify -db <gotify.db> | -url <url> [flags]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var gotifyApps []apps.GotifyApplication
+	var err error
+	switch {
+	case (*database == "") == (*baseURL == ""):
+		fmt.Fprintln(os.Stderr, "either -db or -url is required")
+		return 2
+	case *database != "":
+		// Changes still in the write-ahead log are not in the database file
+		if info, err := os.Stat(*database + "-wal"); err == nil && info.Size() > 0 {
+			fmt.Fprintln(os.Stderr, "Warning: the database has a write-ahead log; stop Gotify first to import its latest changes")
+		}
+		gotifyApps, err = apps.FromGotifyDatabase(*database)
+	default:
+		credentials := apps.GotifyCredentials{ClientToken: *clientToken, Username: *username, Password: *password}
+		if credentials.ClientToken == "" && credentials.Username == "" {
+			fmt.Fprintln(os.Stderr, "-client-token or -user is required with -url")
+			return 2
+		}
+		if credentials.ClientToken == "" && credentials.Password == "" && term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprintf(os.Stderr, "Gotify password of %s: ", credentials.Username)
+			data, _ := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			credentials.Password = string(data)
+		}
+		gotifyApps, err = apps.FromGotifyAPI(context.Background(), *baseURL, credentials)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var imported []apps.Application
+	for _, app := range gotifyApps {
+		if app.Internal && !*internal {
+			fmt.Printf("  skipped %s (internal application of a plugin)\n", app.Name)
+			continue
+		}
+		fmt.Printf("  %s (default priority %d)\n", app.Name, app.DefaultPriority)
+		imported = append(imported, app.Application())
+	}
+
+	backend := config.Setting("STORAGE_BACKEND")
+	if backend == "" {
+		backend = config.DefaultConfig().StorageBackend
+	}
+	store, err := storage.Open(backend, config.Setting("STORAGE_URL"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer store.Close()
+
+	destination := file
+	if store.Durable() {
+		destination = "the " + backend + " storage backend"
+	}
+	added, updated, err := apps.Import(storage.Durable(store, storage.Applications, storage.NewFile(file, apps.TokenField)), imported, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%d applications imported: %d added, %d updated, %d unchanged\n", len(imported), added, updated, len(imported)-added-updated)
+
+	if *dryRun || added+updated == 0 {
+		return 0
+	}
+	fmt.Printf("Applications written to %s. Reload the configuration or restart the server to accept their tokens.\n", destination)
+	return 0
+}
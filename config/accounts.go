@@ -0,0 +1,183 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultAccount names the Mizito account configured through MIZITO_USERNAME
+// and the related environment variables
+const DefaultAccount = "default"
+
+// Account is an additional Mizito account, with its own credentials, dialogs
+// and token, read from MIZITO_ACCOUNTS_FILE or the accounts section of
+// CONFIG_FILE. Settings left empty are taken from the default account.
+type Account struct {
+	// BaseURL is the Mizito host of the account, for workspaces on another
+	// tenant; the endpoint paths are those of the default account
+	BaseURL string `json:"base_url" yaml:"base_url"`
+
+	Username   string `json:"username" yaml:"username"`
+	Password   string `json:"password" yaml:"password"`
+	LoginCode  string `json:"login_code" yaml:"login_code"`
+	RegID      string `json:"reg_id" yaml:"reg_id"`
+	DialogID   string `json:"dialog_id" yaml:"dialog_id"`
+	FromUserID string `json:"from_user_id" yaml:"from_user_id"`
+
+	// DialogRoutes uses the syntax of MIZITO_DIALOG_ROUTES
+	DialogRoutes    string   `json:"dialog_routes" yaml:"dialog_routes"`
+	DialogAllowlist []string `json:"dialog_allowlist" yaml:"dialog_allowlist"`
+
+	// TokenFile, LoginLockoutFile and DialogMapFile default to
+	// token-<name>.json, login-lockout-<name>.json and dialogs-<name>.json
+	// next to JWT_TOKEN_FILE
+	TokenFile        string `json:"token_file" yaml:"token_file"`
+	LoginLockoutFile string `json:"login_lockout_file" yaml:"login_lockout_file"`
+	DialogMapFile    string `json:"dialog_map_file" yaml:"dialog_map_file"`
+}
+
+// accountsFile is the layout of MIZITO_ACCOUNTS_FILE
+type accountsFile struct {
+	Accounts map[string]*Account `json:"accounts" yaml:"accounts"`
+}
+
+// accountNamePattern restricts account names to what is safe in file names
+// and headers
+var accountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// loadAccounts reads an accounts file, as YAML when its extension is .yaml
+// or .yml and as JSON otherwise, and returns the configuration of each
+// account derived from the default configuration c
+func (c *Config) loadAccounts(path string) (map[string]*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var file accountsFile
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return c.accountConfigs(file.Accounts)
+}
+
+// accountConfigs returns the configuration of each account derived from the
+// default configuration c
+func (c *Config) accountConfigs(file map[string]*Account) (map[string]*Config, error) {
+	accounts := make(map[string]*Config, len(file))
+	for name, account := range file {
+		if name == DefaultAccount || !accountNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid account name %q: expected lower-case letters, digits, - and _, other than %q", name, DefaultAccount)
+		}
+		if account == nil {
+			return nil, fmt.Errorf("account %s has no settings", name)
+		}
+
+		accountConfig, err := c.withAccount(name, account)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", name, err)
+		}
+		accounts[name] = accountConfig
+	}
+
+	return accounts, nil
+}
+
+// withAccount returns a copy of the configuration using the credentials,
+// dialogs and token of an account
+func (c *Config) withAccount(name string, account *Account) (*Config, error) {
+	if account.Username == "" || account.Password == "" {
+		return nil, fmt.Errorf("username and password are required")
+	}
+
+	ac := *c
+	ac.Accounts = nil
+
+	if account.BaseURL != "" {
+		if u, err := url.Parse(account.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("base_url must be an absolute http(s) URL")
+		}
+		ac.MizitoBaseURL = account.BaseURL
+		ac.resolveMizitoURLs()
+	}
+
+	ac.MizitoUsername = account.Username
+	ac.MizitoPassword = account.Password
+	ac.MizitoLoginCode = account.LoginCode
+	ac.MizitoRegID = account.RegID
+
+	if account.DialogID != "" {
+		ac.MizitoDialogID = account.DialogID
+	}
+	if account.FromUserID != "" {
+		ac.MizitoFromUserID = account.FromUserID
+	}
+
+	// Dialogs of the default account are not shared with other accounts,
+	// which may not be members of them
+	ac.DialogRoutes = nil
+	if account.DialogRoutes != "" {
+		routes, err := parseDialogRoutes(account.DialogRoutes)
+		if err != nil {
+			return nil, fmt.Errorf("dialog_routes: %w", err)
+		}
+		ac.DialogRoutes = routes
+	}
+	ac.DialogAllowlist = account.DialogAllowlist
+
+	dir := filepath.Dir(c.JWTTokenFile)
+	ac.JWTTokenFile = account.TokenFile
+	if ac.JWTTokenFile == "" {
+		ac.JWTTokenFile = filepath.Join(dir, "token-"+name+".json")
+	}
+	ac.LoginLockoutFile = account.LoginLockoutFile
+	if ac.LoginLockoutFile == "" {
+		ac.LoginLockoutFile = filepath.Join(dir, "login-lockout-"+name+".json")
+	}
+	ac.DialogMapFile = account.DialogMapFile
+	if ac.DialogMapFile == "" {
+		ac.DialogMapFile = filepath.Join(dir, "dialogs-"+name+".json")
+	}
+
+	return &ac, nil
+}
+
+// AccountNames returns the names of all accounts, the default account first
+func (c *Config) AccountNames() []string {
+	names := make([]string, 0, len(c.Accounts)+1)
+	for name := range c.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultAccount}, names...)
+}
+
+// Account returns the configuration of a named account; the default account
+// is the configuration itself
+func (c *Config) Account(name string) (*Config, bool) {
+	if name == "" || name == DefaultAccount {
+		return c, true
+	}
+	ac, ok := c.Accounts[name]
+	return ac, ok
+}
@@ -2,21 +2,72 @@ package config
 
 import (
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/apps"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/holidays"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
 )
 
 // Config holds all configuration settings
 type Config struct {
+	// ConfigFile is the YAML or JSON file the settings were read from in
+	// addition to the environment, if any
+	ConfigFile string
+
 	// Server configuration
 	ServerPort string
 
-	// Mizito API configuration
+	// AdminPort binds admin routes (captures, ...) to a separate internal-only
+	// listener; when empty they are served on ServerPort
+	AdminPort string
+
+	// BasePath prefixes all routes, e.g. "/mizito" when reverse-proxied under /mizito/
+	BasePath string
+
+	// ShutdownTimeout bounds how long shutdown waits for outstanding requests
+	// and pending messages
+	ShutdownTimeout time.Duration
+
+	// ServerTLSCert and ServerTLSKey make the listeners serve HTTPS; the files
+	// are checked for renewed certificates every ServerTLSReloadInterval
+	ServerTLSCert           string
+	ServerTLSKey            string
+	ServerTLSReloadInterval time.Duration
+
+	// Mizito API configuration.
+	// Endpoint URLs are derived from MizitoBaseURL and the endpoint paths
+	// unless set explicitly, so a tenant-specific host only needs one setting.
 	MizitoBaseURL    string
+	MizitoLoginPath  string
+	MizitoChatPath   string
+	MizitoProbePath  string
+	MizitoUploadPath string
 	MizitoLoginURL   string
 	MizitoChatAPIURL string
+	MizitoProbeURL   string
+	MizitoUploadURL  string
+
+	// MizitoDialogCreatePath is the endpoint creating group dialogs for
+	// routes with DIALOG_ID=auto
+	MizitoDialogCreatePath string
+	MizitoDialogCreateURL  string
+
+	// MizitoDialogListPath is the endpoint listing the dialogs of the
+	// account, searched for a dialog whose creation may have succeeded
+	// before it is created again
+	MizitoDialogListPath string
+	MizitoDialogListURL  string
+
+	// MizitoUserListPath is the endpoint listing the users of the
+	// organization for the user directory
+	MizitoUserListPath string
+	MizitoUserListURL  string
+
 	MizitoUsername   string
 	MizitoPassword   string
 	MizitoLoginCode  string
@@ -24,133 +75,1723 @@ type Config struct {
 	MizitoDialogID   string
 	MizitoFromUserID string
 
+	// MizitoHeaders are sent with every request to Mizito, keyed by
+	// canonical header name: the headers of the browser running the Mizito
+	// web app unless configured otherwise
+	MizitoHeaders map[string]string
+
+	// MizitoProxyURL is the http(s) or socks5 proxy used for Mizito traffic,
+	// with optional credentials; when empty HTTPS_PROXY, HTTP_PROXY and
+	// NO_PROXY apply
+	MizitoProxyURL string
+
+	// Attachments are uploaded as the MizitoUploadField part of a multipart
+	// request to MizitoUploadURL; uploads larger than MaxAttachmentSize
+	// bytes are rejected
+	MizitoUploadField string
+	MaxAttachmentSize int
+
+	// DialogRoutes maps priority ranges to dialogs; notifications matching
+	// no route go to MizitoDialogID
+	DialogRoutes []DialogRoute
+
+	// SeverityMap overrides the priorities of source severities, keyed by
+	// severity scale (syslog, zabbix, ...) and severity
+	SeverityMap map[string]map[string]int
+
+	// Dialog auto-creation: routes with DIALOG_ID=auto, or all routes
+	// without a dialog when DialogAutoCreate is set, get a group dialog
+	// named DialogAutoName ({route} replaced) on first use, with
+	// DialogAutoMembers as members. The created dialogs are kept in
+	// DialogMapFile.
+	DialogAutoCreate  bool
+	DialogAutoName    string
+	DialogAutoMembers []string
+	DialogMapFile     string
+
+	// User directory: with UserDirectorySync the users of the organization
+	// are fetched every UserDirectoryInterval and kept in UserDirectoryFile,
+	// for looking users up by email or username in templates. Mentions are
+	// written per UserMentionFormat.
+	UserDirectorySync     bool
+	UserDirectoryInterval time.Duration
+	UserDirectoryFile     string
+	UserMentionFormat     string
+
+	// DialogAllowlist lists the dialogs requests may target explicitly, in
+	// addition to MizitoDialogID and the dialogs of DialogRoutes
+	DialogAllowlist []string
+
+	// Accounts holds the configuration of each additional Mizito account
+	// read from MizitoAccountsFile, keyed by name. The default account is
+	// configured through the environment.
+	MizitoAccountsFile string
+	Accounts           map[string]*Config
+
 	// JWT token configuration
 	JWTTokenFile string
 
+	// TokenDefaultLifetime is assumed for tokens without an exp claim
+	TokenDefaultLifetime time.Duration
+
+	// Tokens are treated as expired this long before their ExpiresAt, and a
+	// warning is logged when the local clock differs from the token issue
+	// time by more than the warning threshold
+	TokenExpirySkew        time.Duration
+	ClockSkewWarnThreshold time.Duration
+
+	// Proactive token refresh: renew the token TokenRefreshLead (minus a
+	// random jitter) before it expires, retrying failures with backoff
+	TokenRefreshEnabled    bool
+	TokenRefreshLead       time.Duration
+	TokenRefreshJitter     time.Duration
+	TokenRefreshBackoff    time.Duration
+	TokenRefreshMaxBackoff time.Duration
+
+	// LoginChallengeCooldown pauses automated logins after the login
+	// endpoint answered with a CAPTCHA or anti-bot challenge
+	LoginChallengeCooldown time.Duration
+
+	// Login lockout protection: logins rejected by Mizito are retried no
+	// sooner than LoginBackoff, doubling up to LoginMaxBackoff, and after
+	// LoginMaxFailures consecutive rejections automated logins stop until
+	// reset through the admin API. The state survives restarts in
+	// LoginLockoutFile.
+	LoginMaxFailures int
+	LoginBackoff     time.Duration
+	LoginMaxBackoff  time.Duration
+	LoginLockoutFile string
+
+	// Upstream health probe: check the token and fetch MizitoProbeURL every
+	// ProbeInterval, exporting the results on /metrics
+	ProbeEnabled  bool
+	ProbeInterval time.Duration
+	ProbeTimeout  time.Duration
+
+	// Readiness check of /readyz: results are reused for ReadinessCacheTTL,
+	// each check is bounded by ReadinessTimeout, and more than
+	// ReadinessQueueLimit queued jobs (0 for no limit) mean not ready
+	ReadinessTimeout    time.Duration
+	ReadinessCacheTTL   time.Duration
+	ReadinessQueueLimit int
+
+	// Canary: post a tagged test message to CanaryDialogID through the local
+	// intake every CanaryInterval and, when CanaryVerifyURL is set, poll it
+	// until the message shows up or CanaryTimeout passes
+	CanaryEnabled    bool
+	CanaryDialogID   string
+	CanaryInterval   time.Duration
+	CanaryTimeout    time.Duration
+	CanaryVerifyPath string
+	CanaryVerifyURL  string
+
+	// Dead man's switch: batch jobs report their runs to /api/v1/annotate;
+	// a job of DeadmanJobs that has not reported within its interval is
+	// announced with DeadmanPriority, checked every DeadmanCheckInterval
+	DeadmanJobs          map[string]time.Duration
+	DeadmanCheckInterval time.Duration
+	DeadmanPriority      int
+
+	// DeadmanMaxJobs caps the jobs outside DeadmanJobs whose runs are kept
+	// and exported as metrics, so reported job names cannot grow them
+	// without bound
+	DeadmanMaxJobs int
+
+	// Windows Event Log input: events of EventLogLevels logged to
+	// EventLogChannels are forwarded (Windows only)
+	EventLogEnabled  bool
+	EventLogChannels []string
+	EventLogLevels   []string
+
+	// SMTP input: emails received on SMTPPort are forwarded with
+	// SMTPPriority unless they set one. With SMTPUsername, clients must
+	// authenticate. SMTPDomain is the name the listener greets with.
+	SMTPEnabled  bool
+	SMTPPort     string
+	SMTPDomain   string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPPriority int
+
+	// Syslog input: messages received on SyslogPort over SyslogProtocols are
+	// forwarded when their severity is SyslogSeverity or more severe and
+	// their facility is listed in SyslogFacilities (empty for all), at most
+	// SyslogMaxPerMinute per minute (0 for no cap)
+	SyslogEnabled      bool
+	SyslogPort         string
+	SyslogProtocols    []string
+	SyslogSeverity     string
+	SyslogFacilities   []string
+	SyslogMaxPerMinute int
+
+	// gRPC API: the Forwarder service of proto/mizitoforwarder/v1 is
+	// served on GRPCPort, with TLS when TLS_CERT_FILE is set
+	GRPCEnabled bool
+	GRPCPort    string
+
+	// Startup login: authenticate before accepting requests, retrying with
+	// exponential backoff, or exit on the first failure when fail-fast is set
+	StartupLogin           bool
+	StartupLoginRetries    int
+	StartupLoginBackoff    time.Duration
+	StartupLoginMaxBackoff time.Duration
+	StartupLoginFailFast   bool
+
 	// App token for API authentication (optional but recommended)
 	AppToken string
 
+	// AppTokens holds every accepted app token: AppToken plus those listed in
+	// APP_TOKENS, so each client can be given (and revoked) its own token
+	AppTokens []string
+
+	// AdminToken authenticates the administrative routes, which do not
+	// accept app tokens
+	AdminToken string
+
+	// Applications are the senders imported from a Gotify server with
+	// import-gotify, read by LoadApplications. Their tokens are in
+	// AppTokens. They are kept in ApplicationsFile unless the storage
+	// backend is durable.
+	ApplicationsFile string
+	Applications     []apps.Application
+
+	// StorageBackend keeps the queue, delivery history, dedup windows and
+	// applications; StorageURL locates backends using a database
+	StorageBackend string
+	StorageURL     string
+
 	// Logging configuration
 	LogLevel string
+
+	// LogFormat is the format of stdout and file logs: text, json or console
+	LogFormat string
+
+	// LogOutputs lists log targets: stdout, file:<path>, syslog://host:port,
+	// syslog+tcp://host:port, gelf://host:port, gelf+tcp://host:port
+	LogOutputs string
+
+	// LogDebugSampleRate logs one in every N debug messages of the same kind
+	LogDebugSampleRate int
+
+	// MessageTemplate is a text/template rendering the message text of every
+	// route without its own template; empty keeps "Title: Message"
+	MessageTemplate string
+
+	// ResponseEcho adds the rendered text, dialog and template to successful
+	// notification responses, like the echo=true query parameter
+	ResponseEcho bool
+
+	// ResponseRetryAfter is the wait suggested in the Retry-After header of
+	// 503 Service Unavailable responses, when Mizito or the forwarder cannot
+	// take notifications
+	ResponseRetryAfter time.Duration
+
+	// Text normalization (NFC, control characters, mixed LTR/RTL runs)
+	NormalizeText bool
+	NormalizeBidi bool
+
+	// IP address enrichment: addresses in messages are annotated with their
+	// reverse DNS name and the country from the GeoIPDatabase MaxMind DB,
+	// looked up within EnrichTimeout
+	EnrichIPs     bool
+	EnrichRDNS    bool
+	GeoIPDatabase string
+	EnrichTimeout time.Duration
+
+	// Content policy: titles and messages longer than the limits are
+	// truncated (0 disables a limit); well-known secrets and the patterns in
+	// PolicyPatternsFile are masked before forwarding
+	PolicyMaxTitleLength   int
+	PolicyMaxMessageLength int
+	PolicyScrubSecrets     bool
+	PolicyPatternsFile     string
+
+	// PolicyMaskPII lists personal data categories masked before sending and
+	// storing: email, phone, iban, national_id or all
+	PolicyMaskPII []string
+
+	// RulesFile holds routing rules matching notifications by route, title,
+	// priority and payload fields, which drop or rate limit them or choose
+	// their dialog and template
+	RulesFile string
+
+	// Outgoing rate limit: at most MaxMessagesPerMinute messages per minute on
+	// average (0 disables it) with bursts of RateLimitBurst. Messages over the
+	// limit wait for a free slot or are rejected, per RateLimitMode.
+	MaxMessagesPerMinute int
+	RateLimitBurst       int
+	RateLimitMode        string
+
+	// Retries of requests to Mizito failing transiently, e.g. on network
+	// errors or 5xx responses: up to MizitoSendRetries more attempts, waiting
+	// from MizitoSendBackoff, doubled per retry up to MizitoSendMaxBackoff
+	MizitoSendRetries    int
+	MizitoSendBackoff    time.Duration
+	MizitoSendMaxBackoff time.Duration
+
+	// Persistent outbound queue: notifications are accepted immediately and
+	// delivered by a background worker that retries with exponential backoff.
+	// Jobs failing QueueMaxAttempts times move to the dead-letter store; 0
+	// retries them forever.
+	QueueEnabled     bool
+	QueueDir         string
+	QueueBackoff     time.Duration
+	QueueMaxBackoff  time.Duration
+	QueueMaxAttempts int
+
+	// Async send: notifications are answered with 202 Accepted and handed
+	// to AsyncWorkers workers through a buffer of AsyncBuffer messages,
+	// instead of being delivered before the response. Requests opt in or
+	// out with ?async=.
+	AsyncSend    bool
+	AsyncWorkers int
+	AsyncBuffer  int
+
+	// File sink: rendered notifications are also appended to FileSinkPath,
+	// a file or FIFO, as JSON lines or text per FileSinkFormat
+	FileSinkPath   string
+	FileSinkFormat string
+
+	// Exec sink: ExecSinkCommand (or the EXEC_COMMAND of the route) is run
+	// through the shell per notification with the message as JSON on stdin,
+	// at most ExecSinkConcurrency at a time, each within ExecSinkTimeout.
+	// Of the forwarder's environment, commands only get the variables named
+	// in ExecSinkEnv besides a few basic ones such as PATH.
+	ExecSinkCommand     string
+	ExecSinkTimeout     time.Duration
+	ExecSinkConcurrency int
+	ExecSinkEnv         []string
+
+	// MQTT sink: notifications are also published as JSON to MQTTSinkTopic
+	// ({route} is replaced by the route) on the broker at MQTTSinkURL
+	MQTTSinkURL      string
+	MQTTSinkTopic    string
+	MQTTSinkQoS      int
+	MQTTSinkRetain   bool
+	MQTTSinkClientID string
+	MQTTSinkTimeout  time.Duration
+
+	// SMS sink: notifications of at least SMSMinPriority are also sent as
+	// SMS to SMSRecipients through Kavenegar or Twilio (SMSProvider), at
+	// most SMSMaxPerHour per hour
+	SMSProvider      string
+	SMSRecipients    []string
+	SMSSender        string
+	SMSMinPriority   int
+	SMSMaxLength     int
+	SMSMaxPerHour    int
+	SMSTimeout       time.Duration
+	SMSAPIURL        string
+	KavenegarAPIKey  string
+	TwilioAccountSID string
+	TwilioAuthToken  string
+
+	// Delivery policy: DeliveryAll or DeliveryFirstSuccess, the latter trying
+	// the targets of DeliveryChain in order; routes may override both.
+	// SinkRetries holds the retries of each sink by name, SinkRetryBackoff
+	// the wait between them.
+	DeliveryPolicy   string
+	DeliveryChain    []string
+	SinkRetries      map[string]int
+	SinkRetryBackoff time.Duration
+
+	// Deduplication: notifications repeating one within DedupWindow are
+	// suppressed; with DedupCount they are counted and announced with the
+	// count once the window closes. Routes may override the window.
+	DedupWindow time.Duration
+	DedupMode   string
+
+	// Delivery SLOs: with SLOTarget set, the share of successful deliveries
+	// of each route, and of successful deliveries faster than SLOLatency
+	// (objective SLOLatencyTarget), are measured over SLOWindow every
+	// SLOCheckInterval. A route burning its error budget SLOAlertBurnRate
+	// times as fast as allowed, with at least SLOMinDeliveries in the
+	// window, is announced with SLOPriority. Routes may override the target.
+	SLOTarget        float64
+	SLOLatency       time.Duration
+	SLOLatencyTarget float64
+	SLOWindow        time.Duration
+	SLOCheckInterval time.Duration
+	SLOAlertBurnRate float64
+	SLOMinDeliveries int
+	SLOPriority      int
+
+	// Timezone of business hours and holidays. Holidays holds the official
+	// holidays of HOLIDAY_CALENDAR with the days of HOLIDAYS added and those
+	// of HOLIDAYS_EXCLUDE removed.
+	Timezone *time.Location
+	Holidays *holidays.Calendar
+
+	// Business hours: notifications received outside BusinessHours on
+	// BusinessDays, or on holidays, go to OffHoursDialogID and mention
+	// OffHoursMentions; routes may override both and their template. Without
+	// BusinessHours it is always business hours.
+	BusinessHours    []TimeRange
+	BusinessDays     []time.Weekday
+	OffHoursDialogID string
+	OffHoursMentions []string
+
+	// Scheduled delivery: notifications delayed with X-Delay, and the
+	// recurring messages of /api/v1/schedule, are kept in ScheduleFile until
+	// due. Delays may not exceed ScheduleMaxDelay.
+	ScheduleFile     string
+	ScheduleMaxDelay time.Duration
+
+	// Moderation: notifications of moderated routes are held in
+	// ModerationFile until a moderator approves or rejects them, and are
+	// dropped after ModerationTimeout. Approval requests are sent to
+	// ModerationDialogID; routes may override it.
+	ModerationDialogID string
+	ModerationTimeout  time.Duration
+	ModerationFile     string
+
+	// Quiet hours: do-not-disturb windows of dialogs, keyed by name; see
+	// QuietHours. Notifications buffered during them are kept in
+	// QuietFile until their digest is delivered.
+	QuietHours map[string]*QuietHours
+	QuietFile  string
+
+	// Messenger sinks: Telegram, Bale and Eitaa bots, keyed by platform;
+	// see Messengers
+	Messengers map[string]*MessengerConfig
+
+	// Phone escalation: notifications of at least PhoneMinPriority that are
+	// not acknowledged within PhoneEscalationDelay trigger a voice call to
+	// PhoneRecipients through Twilio or an Asterisk ARI (PhoneProvider)
+	PhoneProvider        string
+	PhoneRecipients      []string
+	PhoneCallerID        string
+	PhoneMinPriority     int
+	PhoneEscalationDelay time.Duration
+	PhoneLanguage        string
+	PhoneTimeout         time.Duration
+	PhoneAPIURL          string
+	AsteriskARIUsername  string
+	AsteriskARIPassword  string
+	AsteriskContext      string
+	AsteriskExtension    string
+
+	// Audit log of forwarded notifications, one JSON record per line.
+	// Records are signed with HMAC-SHA256 when AuditHMACKey is set, or with
+	// Ed25519 when AuditSigningKeyFile points to a private key.
+	AuditLogFile        string
+	AuditHMACKey        string
+	AuditSigningKeyFile string
+
+	// Sentry error reporting, enabled when SentryDSN is set
+	SentryDSN         string
+	SentryEnvironment string
+	SentryRelease     string
+
+	// AlertmanagerTemplateFile is a text/template file rendering Alertmanager
+	// notification groups; a built-in template is used when empty
+	AlertmanagerTemplateFile string
+
+	// AlertmanagerMaxAlerts caps the alerts of a group rendered into the
+	// message; the others are counted as "… and N more" (0 renders all)
+	AlertmanagerMaxAlerts int
+
+	// GitHubWebhookSecret verifies the X-Hub-Signature-256 of GitHub webhook
+	// deliveries; GitHubEvents lists the event types that are forwarded
+	GitHubWebhookSecret string
+	GitHubEvents        []string
+
+	// JenkinsPhases lists the build phases of Jenkins Notification Plugin
+	// deliveries that are forwarded
+	JenkinsPhases []string
+
+	// Directory where captured inbound payloads are stored
+	CaptureDir string
+
+	// CaptureMaxRecords caps the captured payloads kept; the oldest are
+	// pruned as new ones are captured. 0 keeps all.
+	CaptureMaxRecords int
+
+	// Per-route settings keyed by route name (e.g. "message")
+	Routes map[string]*RouteConfig
+
+	// Formatting profiles keyed by profile name (e.g. "ops"), applied to
+	// the notifications sent to their dialogs
+	Profiles map[string]*Profile
 }
 
 // DefaultConfig returns a Config with default values
 func DefaultConfig() *Config {
 	return &Config{
-		ServerPort:       ":8080",
-		MizitoBaseURL:    "https://app.mizito.ir",
-		MizitoLoginURL:   "https://app.mizito.ir/capi/session/create",
-		MizitoChatAPIURL: "https://app.mizito.ir/api/chat/send",
-		JWTTokenFile:     "token.json",
-		LogLevel:         "info",
-		MizitoLoginCode:  "null",
-		MizitoRegID:      "null",
+		ServerPort:        ":8080",
+		MizitoBaseURL:     "https://app.mizito.ir",
+		MizitoLoginURL:    "https://app.mizito.ir/capi/session/create",
+		MizitoChatAPIURL:  "https://app.mizito.ir/api/chat/send",
+		MizitoLoginPath:   "/capi/session/create",
+		MizitoChatPath:    "/api/chat/send",
+		MizitoProbePath:   "/api/dialog/{dialog}/messages",
+		MizitoUploadPath:  "/api/chat/upload",
+		MizitoHeaders:     defaultMizitoHeaders(),
+		JWTTokenFile:      "token.json",
+		LogLevel:          "info",
+		MizitoLoginCode:   "null",
+		MizitoRegID:       "null",
+		NormalizeText:     true,
+		NormalizeBidi:     true,
+		EnrichRDNS:        true,
+		CaptureDir:        "captures",
+		CaptureMaxRecords: 1000,
+		Routes:            map[string]*RouteConfig{},
+
+		ShutdownTimeout:         30 * time.Second,
+		MizitoDialogCreatePath:  "/api/dialog/create",
+		MizitoDialogListPath:    "/api/dialog/list",
+		DialogAutoName:          "{route}",
+		MizitoUserListPath:      "/api/user/list",
+		UserDirectoryInterval:   time.Hour,
+		UserMentionFormat:       "@{username}",
+		ServerTLSReloadInterval: time.Minute,
+		TokenExpirySkew:         time.Minute,
+		TokenDefaultLifetime:    24 * time.Hour,
+		ClockSkewWarnThreshold:  5 * time.Minute,
+		StartupLoginRetries:     5,
+		StartupLoginBackoff:     2 * time.Second,
+		StartupLoginMaxBackoff:  time.Minute,
+		LogDebugSampleRate:      1,
+		QueueDir:                "queue",
+		AlertmanagerMaxAlerts:   25,
+		QueueBackoff:            time.Second,
+		QueueMaxBackoff:         5 * time.Minute,
+		AsyncWorkers:            4,
+		AsyncBuffer:             1000,
+		FileSinkFormat:          "jsonl",
+		ExecSinkTimeout:         10 * time.Second,
+		ExecSinkConcurrency:     4,
+		MQTTSinkTopic:           "mizito/notifications/{route}",
+		MQTTSinkTimeout:         10 * time.Second,
+		SMSMinPriority:          8,
+		SMSMaxLength:            160,
+		SMSMaxPerHour:           20,
+		SMSTimeout:              10 * time.Second,
+		PhoneMinPriority:        10,
+		PhoneEscalationDelay:    15 * time.Minute,
+		PhoneTimeout:            10 * time.Second,
+		AsteriskContext:         "alerts",
+		AsteriskExtension:       "s",
+		LogOutputs:              "stdout",
+		LogFormat:               "text",
+		PolicyScrubSecrets:      true,
+		TokenRefreshEnabled:     true,
+		TokenRefreshLead:        10 * time.Minute,
+		TokenRefreshJitter:      time.Minute,
+		TokenRefreshBackoff:     30 * time.Second,
+		TokenRefreshMaxBackoff:  10 * time.Minute,
+		LoginChallengeCooldown:  time.Hour,
+		LoginMaxFailures:        3,
+		LoginBackoff:            time.Minute,
+		LoginMaxBackoff:         30 * time.Minute,
+		MizitoUploadField:       "file",
+		MaxAttachmentSize:       10 << 20,
+		ProbeInterval:           time.Minute,
+		ProbeTimeout:            10 * time.Second,
+		MizitoSendRetries:       2,
+		DeliveryPolicy:          DeliveryAll,
+		DedupMode:               DedupSuppress,
+		SinkRetryBackoff:        time.Second,
+		MizitoSendBackoff:       time.Second,
+		MizitoSendMaxBackoff:    10 * time.Second,
+		ReadinessTimeout:        5 * time.Second,
+		ResponseRetryAfter:      30 * time.Second,
+		ReadinessCacheTTL:       10 * time.Second,
+		ReadinessQueueLimit:     1000,
+		CanaryInterval:          5 * time.Minute,
+		CanaryTimeout:           time.Minute,
+		DeadmanCheckInterval:    time.Minute,
+		EnrichTimeout:           2 * time.Second,
+		DeadmanPriority:         8,
+		DeadmanMaxJobs:          100,
+		RateLimitMode:           "wait",
+		GitHubEvents:            []string{"push", "pull_request", "issues", "release"},
+		JenkinsPhases:           []string{"completed"},
+		SLOLatency:              10 * time.Second,
+		SLOLatencyTarget:        0.95,
+		SLOWindow:               time.Hour,
+		SLOCheckInterval:        time.Minute,
+		SLOAlertBurnRate:        2,
+		SLOMinDeliveries:        10,
+		SLOPriority:             8,
+		ScheduleMaxDelay:        30 * 24 * time.Hour,
+		ModerationTimeout:       24 * time.Hour,
+		BusinessDays:            []time.Weekday{time.Saturday, time.Sunday, time.Monday, time.Tuesday, time.Wednesday},
+		EventLogChannels:        []string{"Application", "System"},
+		EventLogLevels:          []string{"critical", "error"},
+		SMTPPort:                ":2525",
+		SMTPDomain:              "mizito-forwarder",
+		SMTPPriority:            5,
+		SyslogPort:              ":5514",
+		SyslogProtocols:         []string{"udp", "tcp"},
+		SyslogSeverity:          "warning",
+		SyslogMaxPerMinute:      60,
+		GRPCPort:                ":50051",
+		StorageBackend:          storage.MemoryBackend,
 	}
 }
 
 // Load loads configuration from environment variables
 func Load() (*Config, error) {
 	// Load .env file if it exists
-	if err := godotenv.Load(); err != nil {
+	if err := loadDotenv(); err != nil {
 		log.Printf("Warning: No .env file found or error loading it: %v", err)
 	}
 
+	// Settings of the configuration file apply where no environment
+	// variable is set
+	fileSettings, fileAccounts = nil, nil
+	readSettings = make(map[string]bool)
+	defer func() { readSettings = nil }()
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile != "" {
+		var err error
+		if fileSettings, fileAccounts, err = loadFile(configFile); err != nil {
+			return nil, ConfigError("CONFIG_FILE: " + err.Error())
+		}
+	}
+
 	config := DefaultConfig()
+	config.ConfigFile = configFile
 
 	// Server configuration
-	if port := os.Getenv("SERVER_PORT"); port != "" {
+	if port := getenv("SERVER_PORT"); port != "" {
 		config.ServerPort = port
 	}
 
+	if adminPort := getenv("ADMIN_PORT"); adminPort != "" {
+		config.AdminPort = adminPort
+	}
+
+	if basePath := getenv("BASE_PATH"); basePath != "" {
+		config.BasePath = normalizeBasePath(basePath)
+	}
+
+	if err := envDuration("SHUTDOWN_TIMEOUT", &config.ShutdownTimeout); err != nil {
+		return nil, err
+	}
+
+	if cert := getenv("SERVER_TLS_CERT"); cert != "" {
+		config.ServerTLSCert = cert
+	}
+
+	if key := getenv("SERVER_TLS_KEY"); key != "" {
+		config.ServerTLSKey = key
+	}
+
+	if err := envDuration("SERVER_TLS_RELOAD_INTERVAL", &config.ServerTLSReloadInterval); err != nil {
+		return nil, err
+	}
+
 	// Mizito configuration
-	if baseURL := os.Getenv("MIZITO_BASE_URL"); baseURL != "" {
+	if baseURL := getenv("MIZITO_BASE_URL"); baseURL != "" {
 		config.MizitoBaseURL = baseURL
 	}
 
-	if loginURL := os.Getenv("MIZITO_LOGIN_URL"); loginURL != "" {
+	if loginPath := getenv("MIZITO_LOGIN_PATH"); loginPath != "" {
+		config.MizitoLoginPath = loginPath
+	}
+
+	if chatPath := getenv("MIZITO_CHAT_PATH"); chatPath != "" {
+		config.MizitoChatPath = chatPath
+	}
+
+	if probePath := getenv("MIZITO_PROBE_PATH"); probePath != "" {
+		config.MizitoProbePath = probePath
+	}
+
+	if uploadPath := getenv("MIZITO_UPLOAD_PATH"); uploadPath != "" {
+		config.MizitoUploadPath = uploadPath
+	}
+
+	if dialogCreatePath := getenv("MIZITO_DIALOG_CREATE_PATH"); dialogCreatePath != "" {
+		config.MizitoDialogCreatePath = dialogCreatePath
+	}
+
+	if dialogListPath := getenv("MIZITO_DIALOG_LIST_PATH"); dialogListPath != "" {
+		config.MizitoDialogListPath = dialogListPath
+	}
+
+	if userListPath := getenv("MIZITO_USER_LIST_PATH"); userListPath != "" {
+		config.MizitoUserListPath = userListPath
+	}
+
+	// Derive endpoint URLs from the base URL; explicit URLs take precedence
+	config.resolveMizitoURLs()
+
+	if loginURL := getenv("MIZITO_LOGIN_URL"); loginURL != "" {
 		config.MizitoLoginURL = loginURL
 	}
 
-	if chatURL := os.Getenv("MIZITO_CHAT_API_URL"); chatURL != "" {
+	if chatURL := getenv("MIZITO_CHAT_API_URL"); chatURL != "" {
 		config.MizitoChatAPIURL = chatURL
 	}
 
-	if username := os.Getenv("MIZITO_USERNAME"); username != "" {
+	if probeURL := getenv("MIZITO_PROBE_URL"); probeURL != "" {
+		config.MizitoProbeURL = probeURL
+	}
+
+	if uploadURL := getenv("MIZITO_UPLOAD_URL"); uploadURL != "" {
+		config.MizitoUploadURL = uploadURL
+	}
+
+	if dialogCreateURL := getenv("MIZITO_DIALOG_CREATE_URL"); dialogCreateURL != "" {
+		config.MizitoDialogCreateURL = dialogCreateURL
+	}
+
+	if dialogListURL := getenv("MIZITO_DIALOG_LIST_URL"); dialogListURL != "" {
+		config.MizitoDialogListURL = dialogListURL
+	}
+
+	if userListURL := getenv("MIZITO_USER_LIST_URL"); userListURL != "" {
+		config.MizitoUserListURL = userListURL
+	}
+
+	if uploadField := getenv("MIZITO_UPLOAD_FIELD"); uploadField != "" {
+		config.MizitoUploadField = uploadField
+	}
+
+	if proxyURL := getenv("MIZITO_PROXY_URL"); proxyURL != "" {
+		config.MizitoProxyURL = proxyURL
+	}
+
+	if err := config.loadMizitoHeaders(); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("MAX_ATTACHMENT_SIZE", &config.MaxAttachmentSize); err != nil {
+		return nil, err
+	}
+
+	if username := getenv("MIZITO_USERNAME"); username != "" {
 		config.MizitoUsername = username
 	}
 
-	if password := os.Getenv("MIZITO_PASSWORD"); password != "" {
+	if password := getenv("MIZITO_PASSWORD"); password != "" {
 		config.MizitoPassword = password
 	}
 
-	if loginCode := os.Getenv("MIZITO_LOGIN_CODE"); loginCode != "" {
+	if loginCode := getenv("MIZITO_LOGIN_CODE"); loginCode != "" {
 		config.MizitoLoginCode = loginCode
 	}
 
-	if regID := os.Getenv("MIZITO_REG_ID"); regID != "" {
+	if regID := getenv("MIZITO_REG_ID"); regID != "" {
 		config.MizitoRegID = regID
 	}
 
-	if dialogID := os.Getenv("MIZITO_DIALOG_ID"); dialogID != "" {
+	if dialogID := getenv("MIZITO_DIALOG_ID"); dialogID != "" {
 		config.MizitoDialogID = dialogID
 	}
 
-	if fromUserID := os.Getenv("MIZITO_FROM_USER_ID"); fromUserID != "" {
+	if dialogRoutes := getenv("MIZITO_DIALOG_ROUTES"); dialogRoutes != "" {
+		routes, err := parseDialogRoutes(dialogRoutes)
+		if err != nil {
+			return nil, ConfigError("MIZITO_DIALOG_ROUTES: " + err.Error())
+		}
+		config.DialogRoutes = routes
+	}
+
+	if severityMap := getenv("SEVERITY_MAP"); severityMap != "" {
+		mappings, err := parseSeverityMap(severityMap)
+		if err != nil {
+			return nil, ConfigError("SEVERITY_MAP: " + err.Error())
+		}
+		config.SeverityMap = mappings
+	}
+
+	if allowlist := getenv("MIZITO_DIALOG_ALLOWLIST"); allowlist != "" {
+		for _, dialogID := range strings.Split(allowlist, ",") {
+			if dialogID = strings.TrimSpace(dialogID); dialogID != "" {
+				config.DialogAllowlist = append(config.DialogAllowlist, dialogID)
+			}
+		}
+	}
+
+	if fromUserID := getenv("MIZITO_FROM_USER_ID"); fromUserID != "" {
 		config.MizitoFromUserID = fromUserID
 	}
 
 	// JWT configuration
-	if tokenFile := os.Getenv("JWT_TOKEN_FILE"); tokenFile != "" {
+	if tokenFile := getenv("JWT_TOKEN_FILE"); tokenFile != "" {
 		config.JWTTokenFile = tokenFile
 	}
 
-	// App token for API authentication
-	if appToken := os.Getenv("APP_TOKEN"); appToken != "" {
-		config.AppToken = appToken
+	if err := envDuration("TOKEN_EXPIRY_SKEW", &config.TokenExpirySkew); err != nil {
+		return nil, err
 	}
 
-	// Logging configuration
-	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
-		config.LogLevel = strings.ToLower(logLevel)
+	if err := envDuration("TOKEN_DEFAULT_LIFETIME", &config.TokenDefaultLifetime); err != nil {
+		return nil, err
 	}
 
-	// Validate required configuration
-	if err := config.validate(); err != nil {
+	if err := envDuration("CLOCK_SKEW_WARN_THRESHOLD", &config.ClockSkewWarnThreshold); err != nil {
 		return nil, err
 	}
 
-	return config, nil
-}
+	// Token refresh configuration
+	if err := envBool("TOKEN_REFRESH_ENABLED", &config.TokenRefreshEnabled); err != nil {
+		return nil, err
+	}
 
-// validate checks if required configuration values are present
-func (c *Config) validate() error {
-	if c.MizitoUsername == "" {
-		return ConfigError("MIZITO_USERNAME is required")
+	if err := envDuration("TOKEN_REFRESH_LEAD", &config.TokenRefreshLead); err != nil {
+		return nil, err
 	}
 
-	if c.MizitoPassword == "" {
-		return ConfigError("MIZITO_PASSWORD is required")
+	if err := envDuration("TOKEN_REFRESH_JITTER", &config.TokenRefreshJitter); err != nil {
+		return nil, err
 	}
 
-	if c.MizitoDialogID == "" {
-		return ConfigError("MIZITO_DIALOG_ID is required")
+	if err := envDuration("TOKEN_REFRESH_BACKOFF", &config.TokenRefreshBackoff); err != nil {
+		return nil, err
 	}
 
-	if c.MizitoFromUserID == "" {
-		return ConfigError("MIZITO_FROM_USER_ID is required")
+	if err := envDuration("TOKEN_REFRESH_MAX_BACKOFF", &config.TokenRefreshMaxBackoff); err != nil {
+		return nil, err
 	}
 
-	return nil
-}
+	if err := envDuration("LOGIN_CHALLENGE_COOLDOWN", &config.LoginChallengeCooldown); err != nil {
+		return nil, err
+	}
 
-// ConfigError is a custom error type for configuration errors
-type ConfigError string
+	if err := envInt("LOGIN_MAX_FAILURES", &config.LoginMaxFailures); err != nil {
+		return nil, err
+	}
 
-func (e ConfigError) Error() string {
-	return string(e)
-}
+	if err := envDuration("LOGIN_BACKOFF", &config.LoginBackoff); err != nil {
+		return nil, err
+	}
 
-// GetLogLevel returns the log level for logging
-func (c *Config) GetLogLevel() string {
-	return c.LogLevel
+	if err := envDuration("LOGIN_MAX_BACKOFF", &config.LoginMaxBackoff); err != nil {
+		return nil, err
+	}
+
+	// The lockout state is kept next to the token unless set explicitly
+	config.LoginLockoutFile = filepath.Join(filepath.Dir(config.JWTTokenFile), "login-lockout.json")
+	if lockoutFile := getenv("LOGIN_LOCKOUT_FILE"); lockoutFile != "" {
+		config.LoginLockoutFile = lockoutFile
+	}
+
+	// Dialog auto-creation configuration
+	if err := config.loadDialogAutoCreate(); err != nil {
+		return nil, err
+	}
+
+	// User directory configuration
+	if err := config.loadUserDirectory(); err != nil {
+		return nil, err
+	}
+
+	// Upstream health probe configuration
+	if err := envBool("PROBE_ENABLED", &config.ProbeEnabled); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("PROBE_INTERVAL", &config.ProbeInterval); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("PROBE_TIMEOUT", &config.ProbeTimeout); err != nil {
+		return nil, err
+	}
+
+	// Readiness check configuration
+	if err := envDuration("READINESS_TIMEOUT", &config.ReadinessTimeout); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("READINESS_CACHE_TTL", &config.ReadinessCacheTTL); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("READINESS_QUEUE_LIMIT", &config.ReadinessQueueLimit); err != nil {
+		return nil, err
+	}
+
+	// Canary configuration
+	if err := envBool("CANARY_ENABLED", &config.CanaryEnabled); err != nil {
+		return nil, err
+	}
+
+	if canaryDialog := getenv("CANARY_DIALOG_ID"); canaryDialog != "" {
+		config.CanaryDialogID = canaryDialog
+	}
+
+	if err := envDuration("CANARY_INTERVAL", &config.CanaryInterval); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("CANARY_TIMEOUT", &config.CanaryTimeout); err != nil {
+		return nil, err
+	}
+
+	if verifyPath := getenv("CANARY_VERIFY_PATH"); verifyPath != "" {
+		config.CanaryVerifyPath = verifyPath
+		config.CanaryVerifyURL = ResolveURL(config.MizitoBaseURL, verifyPath)
+	}
+
+	if verifyURL := getenv("CANARY_VERIFY_URL"); verifyURL != "" {
+		config.CanaryVerifyURL = verifyURL
+	}
+
+	// Dead man's switch configuration
+	if deadmanJobs := getenv("DEADMAN_JOBS"); deadmanJobs != "" {
+		jobs, err := parseDeadmanJobs(deadmanJobs)
+		if err != nil {
+			return nil, ConfigError("DEADMAN_JOBS: " + err.Error())
+		}
+		config.DeadmanJobs = jobs
+	}
+
+	if err := envDuration("DEADMAN_CHECK_INTERVAL", &config.DeadmanCheckInterval); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("DEADMAN_PRIORITY", &config.DeadmanPriority); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("DEADMAN_MAX_JOBS", &config.DeadmanMaxJobs); err != nil {
+		return nil, err
+	}
+
+	// Windows Event Log configuration
+	if err := envBool("EVENTLOG_ENABLED", &config.EventLogEnabled); err != nil {
+		return nil, err
+	}
+
+	if channels := getenv("EVENTLOG_CHANNELS"); channels != "" {
+		config.EventLogChannels = nil
+		for _, channel := range strings.Split(channels, ",") {
+			if channel = strings.TrimSpace(channel); channel != "" {
+				config.EventLogChannels = append(config.EventLogChannels, channel)
+			}
+		}
+	}
+
+	if levels := getenv("EVENTLOG_LEVELS"); levels != "" {
+		config.EventLogLevels = nil
+		for _, level := range strings.Split(levels, ",") {
+			if level = strings.ToLower(strings.TrimSpace(level)); level != "" {
+				config.EventLogLevels = append(config.EventLogLevels, level)
+			}
+		}
+	}
+
+	// SMTP input configuration
+	if err := config.loadSMTP(); err != nil {
+		return nil, err
+	}
+
+	// Syslog input configuration
+	if err := config.loadSyslog(); err != nil {
+		return nil, err
+	}
+
+	// gRPC API configuration
+	if err := config.loadGRPC(); err != nil {
+		return nil, err
+	}
+
+	// Startup login configuration
+	if err := envBool("STARTUP_LOGIN", &config.StartupLogin); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("STARTUP_LOGIN_RETRIES", &config.StartupLoginRetries); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("STARTUP_LOGIN_BACKOFF", &config.StartupLoginBackoff); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("STARTUP_LOGIN_MAX_BACKOFF", &config.StartupLoginMaxBackoff); err != nil {
+		return nil, err
+	}
+
+	if err := envBool("STARTUP_LOGIN_FAIL_FAST", &config.StartupLoginFailFast); err != nil {
+		return nil, err
+	}
+
+	// App token for API authentication
+	if appToken := getenv("APP_TOKEN"); appToken != "" {
+		config.AppToken = appToken
+		config.AppTokens = append(config.AppTokens, appToken)
+	}
+
+	if appTokens := getenv("APP_TOKENS"); appTokens != "" {
+		for _, token := range strings.Split(appTokens, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				config.AppTokens = append(config.AppTokens, token)
+			}
+		}
+	}
+
+	if adminToken := getenv("ADMIN_TOKEN"); adminToken != "" {
+		config.AdminToken = adminToken
+	}
+
+	config.loadStorage()
+
+	// Logging configuration
+	if logLevel := getenv("LOG_LEVEL"); logLevel != "" {
+		config.LogLevel = strings.ToLower(logLevel)
+	}
+
+	if logFormat := getenv("LOG_FORMAT"); logFormat != "" {
+		config.LogFormat = strings.ToLower(logFormat)
+	}
+
+	if logOutputs := getenv("LOG_OUTPUTS"); logOutputs != "" {
+		config.LogOutputs = logOutputs
+	}
+
+	if err := envInt("LOG_DEBUG_SAMPLE_RATE", &config.LogDebugSampleRate); err != nil {
+		return nil, err
+	}
+
+	// Message template configuration
+	if messageTemplate := getenv("MESSAGE_TEMPLATE"); messageTemplate != "" {
+		config.MessageTemplate = messageTemplate
+	}
+
+	if err := envBool("RESPONSE_ECHO", &config.ResponseEcho); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("RESPONSE_RETRY_AFTER", &config.ResponseRetryAfter); err != nil {
+		return nil, err
+	}
+
+	// Text normalization configuration
+	if err := envBool("NORMALIZE_TEXT", &config.NormalizeText); err != nil {
+		return nil, err
+	}
+
+	if err := envBool("NORMALIZE_BIDI", &config.NormalizeBidi); err != nil {
+		return nil, err
+	}
+
+	// IP address enrichment configuration
+	if err := envBool("ENRICH_IPS", &config.EnrichIPs); err != nil {
+		return nil, err
+	}
+
+	if err := envBool("ENRICH_RDNS", &config.EnrichRDNS); err != nil {
+		return nil, err
+	}
+
+	if geoIPDatabase := getenv("GEOIP_DATABASE"); geoIPDatabase != "" {
+		config.GeoIPDatabase = geoIPDatabase
+	}
+
+	if err := envDuration("ENRICH_TIMEOUT", &config.EnrichTimeout); err != nil {
+		return nil, err
+	}
+
+	// Content policy configuration
+	if err := envInt("POLICY_MAX_TITLE_LENGTH", &config.PolicyMaxTitleLength); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("POLICY_MAX_MESSAGE_LENGTH", &config.PolicyMaxMessageLength); err != nil {
+		return nil, err
+	}
+
+	if err := envBool("POLICY_SCRUB_SECRETS", &config.PolicyScrubSecrets); err != nil {
+		return nil, err
+	}
+
+	if patternsFile := getenv("POLICY_PATTERNS_FILE"); patternsFile != "" {
+		config.PolicyPatternsFile = patternsFile
+	}
+
+	if maskPII := getenv("POLICY_MASK_PII"); maskPII != "" {
+		for _, category := range strings.Split(maskPII, ",") {
+			if category = strings.ToLower(strings.TrimSpace(category)); category != "" {
+				config.PolicyMaskPII = append(config.PolicyMaskPII, category)
+			}
+		}
+	}
+
+	if rulesFile := getenv("RULES_FILE"); rulesFile != "" {
+		config.RulesFile = rulesFile
+	}
+
+	// Outgoing rate limit configuration
+	if err := envInt("MAX_MESSAGES_PER_MINUTE", &config.MaxMessagesPerMinute); err != nil {
+		return nil, err
+	}
+
+	config.RateLimitBurst = config.MaxMessagesPerMinute
+	if err := envInt("RATE_LIMIT_BURST", &config.RateLimitBurst); err != nil {
+		return nil, err
+	}
+
+	if mode := getenv("RATE_LIMIT_MODE"); mode != "" {
+		config.RateLimitMode = strings.ToLower(mode)
+	}
+
+	// Retries of requests to Mizito
+	if err := envInt("MIZITO_SEND_RETRIES", &config.MizitoSendRetries); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("MIZITO_SEND_BACKOFF", &config.MizitoSendBackoff); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("MIZITO_SEND_MAX_BACKOFF", &config.MizitoSendMaxBackoff); err != nil {
+		return nil, err
+	}
+
+	// Outbound queue configuration
+	if err := envBool("QUEUE_ENABLED", &config.QueueEnabled); err != nil {
+		return nil, err
+	}
+
+	if queueDir := getenv("QUEUE_DIR"); queueDir != "" {
+		config.QueueDir = queueDir
+	}
+
+	if err := envDuration("QUEUE_BACKOFF", &config.QueueBackoff); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("QUEUE_MAX_BACKOFF", &config.QueueMaxBackoff); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("QUEUE_MAX_ATTEMPTS", &config.QueueMaxAttempts); err != nil {
+		return nil, err
+	}
+
+	// Async send configuration
+	if err := envBool("ASYNC_SEND", &config.AsyncSend); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("ASYNC_WORKERS", &config.AsyncWorkers); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("ASYNC_BUFFER", &config.AsyncBuffer); err != nil {
+		return nil, err
+	}
+
+	// File sink configuration
+	if path := getenv("FILE_SINK_PATH"); path != "" {
+		config.FileSinkPath = path
+	}
+
+	if format := getenv("FILE_SINK_FORMAT"); format != "" {
+		config.FileSinkFormat = strings.ToLower(format)
+	}
+
+	// Exec sink configuration
+	if command := getenv("EXEC_SINK_COMMAND"); command != "" {
+		config.ExecSinkCommand = command
+	}
+
+	if err := envDuration("EXEC_SINK_TIMEOUT", &config.ExecSinkTimeout); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("EXEC_SINK_CONCURRENCY", &config.ExecSinkConcurrency); err != nil {
+		return nil, err
+	}
+
+	if names := getenv("EXEC_SINK_ENV"); names != "" {
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config.ExecSinkEnv = append(config.ExecSinkEnv, name)
+			}
+		}
+	}
+
+	// MQTT sink configuration
+	if brokerURL := getenv("MQTT_SINK_URL"); brokerURL != "" {
+		config.MQTTSinkURL = brokerURL
+	}
+
+	if topic := getenv("MQTT_SINK_TOPIC"); topic != "" {
+		config.MQTTSinkTopic = topic
+	}
+
+	if err := envInt("MQTT_SINK_QOS", &config.MQTTSinkQoS); err != nil {
+		return nil, err
+	}
+
+	if err := envBool("MQTT_SINK_RETAIN", &config.MQTTSinkRetain); err != nil {
+		return nil, err
+	}
+
+	if clientID := getenv("MQTT_SINK_CLIENT_ID"); clientID != "" {
+		config.MQTTSinkClientID = clientID
+	}
+
+	if err := envDuration("MQTT_SINK_TIMEOUT", &config.MQTTSinkTimeout); err != nil {
+		return nil, err
+	}
+
+	// SMS sink configuration
+	if provider := getenv("SMS_PROVIDER"); provider != "" {
+		config.SMSProvider = strings.ToLower(provider)
+	}
+
+	if recipients := getenv("SMS_RECIPIENTS"); recipients != "" {
+		for _, recipient := range strings.Split(recipients, ",") {
+			if recipient = strings.TrimSpace(recipient); recipient != "" {
+				config.SMSRecipients = append(config.SMSRecipients, recipient)
+			}
+		}
+	}
+
+	if sender := getenv("SMS_SENDER"); sender != "" {
+		config.SMSSender = sender
+	}
+
+	if err := envInt("SMS_MIN_PRIORITY", &config.SMSMinPriority); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("SMS_MAX_LENGTH", &config.SMSMaxLength); err != nil {
+		return nil, err
+	}
+
+	if err := envInt("SMS_MAX_PER_HOUR", &config.SMSMaxPerHour); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("SMS_TIMEOUT", &config.SMSTimeout); err != nil {
+		return nil, err
+	}
+
+	if apiURL := getenv("SMS_API_URL"); apiURL != "" {
+		config.SMSAPIURL = apiURL
+	}
+
+	if apiKey := getenv("KAVENEGAR_API_KEY"); apiKey != "" {
+		config.KavenegarAPIKey = apiKey
+	}
+
+	if accountSID := getenv("TWILIO_ACCOUNT_SID"); accountSID != "" {
+		config.TwilioAccountSID = accountSID
+	}
+
+	if authToken := getenv("TWILIO_AUTH_TOKEN"); authToken != "" {
+		config.TwilioAuthToken = authToken
+	}
+
+	// Delivery policy configuration
+	if err := config.loadDelivery(); err != nil {
+		return nil, err
+	}
+
+	// Deduplication configuration
+	if err := config.loadDedup(); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadSLO(); err != nil {
+		return nil, err
+	}
+
+	// Holiday calendar and business hours configuration
+	if err := config.loadHolidays(); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadBusinessHours(); err != nil {
+		return nil, err
+	}
+
+	// Scheduled delivery configuration
+	if err := config.loadSchedule(); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadModeration(); err != nil {
+		return nil, err
+	}
+
+	if err := config.loadQuietHours(); err != nil {
+		return nil, err
+	}
+
+	// Messenger sinks configuration
+	if err := config.loadMessengers(); err != nil {
+		return nil, err
+	}
+
+	// Phone escalation configuration
+	if provider := getenv("PHONE_PROVIDER"); provider != "" {
+		config.PhoneProvider = strings.ToLower(provider)
+	}
+
+	if recipients := getenv("PHONE_RECIPIENTS"); recipients != "" {
+		for _, recipient := range strings.Split(recipients, ",") {
+			if recipient = strings.TrimSpace(recipient); recipient != "" {
+				config.PhoneRecipients = append(config.PhoneRecipients, recipient)
+			}
+		}
+	}
+
+	if callerID := getenv("PHONE_CALLER_ID"); callerID != "" {
+		config.PhoneCallerID = callerID
+	}
+
+	if err := envInt("PHONE_MIN_PRIORITY", &config.PhoneMinPriority); err != nil {
+		return nil, err
+	}
+
+	if err := envDuration("PHONE_ESCALATION_DELAY", &config.PhoneEscalationDelay); err != nil {
+		return nil, err
+	}
+
+	if language := getenv("PHONE_LANGUAGE"); language != "" {
+		config.PhoneLanguage = language
+	}
+
+	if err := envDuration("PHONE_TIMEOUT", &config.PhoneTimeout); err != nil {
+		return nil, err
+	}
+
+	if apiURL := getenv("PHONE_API_URL"); apiURL != "" {
+		config.PhoneAPIURL = apiURL
+	}
+
+	if username := getenv("ASTERISK_ARI_USERNAME"); username != "" {
+		config.AsteriskARIUsername = username
+	}
+
+	if password := getenv("ASTERISK_ARI_PASSWORD"); password != "" {
+		config.AsteriskARIPassword = password
+	}
+
+	if context := getenv("ASTERISK_CONTEXT"); context != "" {
+		config.AsteriskContext = context
+	}
+
+	if extension := getenv("ASTERISK_EXTENSION"); extension != "" {
+		config.AsteriskExtension = extension
+	}
+
+	// Alertmanager configuration
+	if tmplFile := getenv("ALERTMANAGER_TEMPLATE_FILE"); tmplFile != "" {
+		config.AlertmanagerTemplateFile = tmplFile
+	}
+	if err := envInt("ALERTMANAGER_MAX_ALERTS", &config.AlertmanagerMaxAlerts); err != nil {
+		return nil, err
+	}
+
+	// GitHub webhook configuration
+	if secret := getenv("GITHUB_WEBHOOK_SECRET"); secret != "" {
+		config.GitHubWebhookSecret = secret
+	}
+
+	if events := getenv("GITHUB_EVENTS"); events != "" {
+		config.GitHubEvents = nil
+		for _, event := range strings.Split(events, ",") {
+			if event = strings.ToLower(strings.TrimSpace(event)); event != "" {
+				config.GitHubEvents = append(config.GitHubEvents, event)
+			}
+		}
+	}
+
+	// Jenkins webhook configuration
+	if phases := getenv("JENKINS_PHASES"); phases != "" {
+		config.JenkinsPhases = nil
+		for _, phase := range strings.Split(phases, ",") {
+			if phase = strings.ToLower(strings.TrimSpace(phase)); phase != "" {
+				config.JenkinsPhases = append(config.JenkinsPhases, phase)
+			}
+		}
+	}
+
+	// Audit log configuration
+	if auditLogFile := getenv("AUDIT_LOG_FILE"); auditLogFile != "" {
+		config.AuditLogFile = auditLogFile
+	}
+
+	if auditHMACKey := getenv("AUDIT_HMAC_KEY"); auditHMACKey != "" {
+		config.AuditHMACKey = auditHMACKey
+	}
+
+	if auditSigningKeyFile := getenv("AUDIT_SIGNING_KEY_FILE"); auditSigningKeyFile != "" {
+		config.AuditSigningKeyFile = auditSigningKeyFile
+	}
+
+	// Sentry configuration
+	if sentryDSN := getenv("SENTRY_DSN"); sentryDSN != "" {
+		config.SentryDSN = sentryDSN
+	}
+
+	if sentryEnvironment := getenv("SENTRY_ENVIRONMENT"); sentryEnvironment != "" {
+		config.SentryEnvironment = sentryEnvironment
+	}
+
+	if sentryRelease := getenv("SENTRY_RELEASE"); sentryRelease != "" {
+		config.SentryRelease = sentryRelease
+	}
+
+	// Payload capture configuration
+	if captureDir := getenv("CAPTURE_DIR"); captureDir != "" {
+		config.CaptureDir = captureDir
+	}
+
+	if err := envInt("CAPTURE_MAX_RECORDS", &config.CaptureMaxRecords); err != nil {
+		return nil, err
+	}
+
+	// Per-route configuration
+	routes, err := loadRoutes()
+	if err != nil {
+		return nil, err
+	}
+	config.Routes = routes
+
+	// Per-dialog formatting profiles
+	profiles, err := loadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	config.Profiles = profiles
+
+	// Additional Mizito accounts inherit the settings loaded so far
+	if accountsFile := getenv("MIZITO_ACCOUNTS_FILE"); accountsFile != "" {
+		config.MizitoAccountsFile = accountsFile
+		accounts, err := config.loadAccounts(accountsFile)
+		if err != nil {
+			return nil, ConfigError("MIZITO_ACCOUNTS_FILE: " + err.Error())
+		}
+		config.Accounts = accounts
+	} else if len(fileAccounts) > 0 {
+		accounts, err := config.accountConfigs(fileAccounts)
+		if err != nil {
+			return nil, ConfigError("CONFIG_FILE: " + err.Error())
+		}
+		config.Accounts = accounts
+	}
+
+	if unknown := unknownFileSettings(); len(unknown) > 0 {
+		return nil, ConfigError("CONFIG_FILE: unknown settings " + strings.Join(unknown, ", "))
+	}
+
+	// Validate required configuration
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// validate checks if required configuration values are present
+func (c *Config) validate() error {
+	if c.MizitoUsername == "" {
+		return ConfigError("MIZITO_USERNAME is required")
+	}
+
+	if c.MizitoPassword == "" {
+		return ConfigError("MIZITO_PASSWORD is required")
+	}
+
+	if c.MizitoDialogID == "" {
+		return ConfigError("MIZITO_DIALOG_ID is required")
+	}
+
+	if c.MizitoFromUserID == "" {
+		return ConfigError("MIZITO_FROM_USER_ID is required")
+	}
+
+	if c.AdminPort != "" && c.AdminPort == c.ServerPort {
+		return ConfigError("ADMIN_PORT must differ from SERVER_PORT")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		return ConfigError("SHUTDOWN_TIMEOUT must be positive")
+	}
+
+	if (c.ServerTLSCert == "") != (c.ServerTLSKey == "") {
+		return ConfigError("SERVER_TLS_CERT and SERVER_TLS_KEY must be set together")
+	}
+
+	if c.TLSEnabled() && c.ServerTLSReloadInterval <= 0 {
+		return ConfigError("SERVER_TLS_RELOAD_INTERVAL must be positive")
+	}
+
+	if u, err := url.Parse(c.MizitoBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ConfigError("MIZITO_BASE_URL must be an absolute http(s) URL")
+	}
+
+	if c.MizitoProxyURL != "" {
+		u, err := url.Parse(c.MizitoProxyURL)
+		if err != nil || u.Host == "" {
+			return ConfigError("MIZITO_PROXY_URL must be a proxy URL such as http://proxy:3128")
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return ConfigError("MIZITO_PROXY_URL scheme must be http, https, socks5 or socks5h")
+		}
+	}
+
+	if c.QueueEnabled && (c.QueueBackoff <= 0 || c.QueueMaxBackoff < c.QueueBackoff) {
+		return ConfigError("QUEUE_BACKOFF must be positive and not exceed QUEUE_MAX_BACKOFF")
+	}
+
+	for name, rc := range c.Routes {
+		if _, ok := c.Account(rc.Account); !ok {
+			return ConfigError("ROUTE_" + strings.ToUpper(name) + "_ACCOUNT: unknown account " + rc.Account)
+		}
+	}
+
+	if err := c.validateMessengers(); err != nil {
+		return err
+	}
+
+	if err := c.validateDelivery(); err != nil {
+		return err
+	}
+
+	if err := c.validateDedup(); err != nil {
+		return err
+	}
+
+	if err := c.validateProfiles(); err != nil {
+		return err
+	}
+
+	if err := c.validateQuietHours(); err != nil {
+		return err
+	}
+
+	if err := c.validateCandidateTemplates(); err != nil {
+		return err
+	}
+
+	if err := c.validateSLO(); err != nil {
+		return err
+	}
+
+	if err := c.validateBusinessHours(); err != nil {
+		return err
+	}
+
+	if c.ScheduleMaxDelay <= 0 {
+		return ConfigError("SCHEDULE_MAX_DELAY must be positive")
+	}
+
+	if c.ModerationTimeout <= 0 {
+		return ConfigError("MODERATION_TIMEOUT must be positive")
+	}
+
+	if c.QueueMaxAttempts < 0 {
+		return ConfigError("QUEUE_MAX_ATTEMPTS must not be negative")
+	}
+
+	if c.CaptureMaxRecords < 0 {
+		return ConfigError("CAPTURE_MAX_RECORDS must not be negative")
+	}
+
+	if c.AlertmanagerMaxAlerts < 0 {
+		return ConfigError("ALERTMANAGER_MAX_ALERTS must not be negative")
+	}
+
+	if c.ResponseRetryAfter < time.Second {
+		return ConfigError("RESPONSE_RETRY_AFTER must be at least 1s")
+	}
+
+	if c.UserDirectorySync && c.UserDirectoryInterval < time.Minute {
+		return ConfigError("USER_DIRECTORY_INTERVAL must be at least 1m")
+	}
+
+	if c.AsyncWorkers <= 0 || c.AsyncBuffer <= 0 {
+		return ConfigError("ASYNC_WORKERS and ASYNC_BUFFER must be positive")
+	}
+
+	if c.FileSinkPath != "" && c.FileSinkFormat != "jsonl" && c.FileSinkFormat != "text" {
+		return ConfigError("FILE_SINK_FORMAT must be jsonl or text")
+	}
+
+	if c.ExecSinkTimeout <= 0 || c.ExecSinkConcurrency <= 0 {
+		return ConfigError("EXEC_SINK_TIMEOUT and EXEC_SINK_CONCURRENCY must be positive")
+	}
+
+	if c.MQTTSinkQoS < 0 || c.MQTTSinkQoS > 2 {
+		return ConfigError("MQTT_SINK_QOS must be 0, 1 or 2")
+	}
+
+	if c.MQTTSinkURL != "" && c.MQTTSinkTimeout <= 0 {
+		return ConfigError("MQTT_SINK_TIMEOUT must be positive")
+	}
+
+	if c.SMSProvider != "" {
+		switch {
+		case c.SMSProvider != "kavenegar" && c.SMSProvider != "twilio":
+			return ConfigError("SMS_PROVIDER must be kavenegar or twilio")
+		case len(c.SMSRecipients) == 0:
+			return ConfigError("SMS_RECIPIENTS is required with SMS_PROVIDER")
+		case c.SMSProvider == "kavenegar" && c.KavenegarAPIKey == "":
+			return ConfigError("KAVENEGAR_API_KEY is required with SMS_PROVIDER=kavenegar")
+		case c.SMSProvider == "twilio" && (c.TwilioAccountSID == "" || c.TwilioAuthToken == "" || c.SMSSender == ""):
+			return ConfigError("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and SMS_SENDER are required with SMS_PROVIDER=twilio")
+		case c.SMSTimeout <= 0 || c.SMSMaxLength < 0 || c.SMSMaxPerHour < 0:
+			return ConfigError("SMS_TIMEOUT must be positive, SMS_MAX_LENGTH and SMS_MAX_PER_HOUR not negative")
+		}
+	}
+
+	if c.PhoneProvider != "" {
+		switch {
+		case c.PhoneProvider != "twilio" && c.PhoneProvider != "asterisk":
+			return ConfigError("PHONE_PROVIDER must be twilio or asterisk")
+		case len(c.PhoneRecipients) == 0:
+			return ConfigError("PHONE_RECIPIENTS is required with PHONE_PROVIDER")
+		case c.PhoneProvider == "twilio" && (c.TwilioAccountSID == "" || c.TwilioAuthToken == "" || c.PhoneCallerID == ""):
+			return ConfigError("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and PHONE_CALLER_ID are required with PHONE_PROVIDER=twilio")
+		case c.PhoneProvider == "asterisk" && (c.PhoneAPIURL == "" || c.AsteriskARIUsername == "" || c.AsteriskARIPassword == ""):
+			return ConfigError("PHONE_API_URL, ASTERISK_ARI_USERNAME and ASTERISK_ARI_PASSWORD are required with PHONE_PROVIDER=asterisk")
+		case c.PhoneTimeout <= 0 || c.PhoneEscalationDelay < 0:
+			return ConfigError("PHONE_TIMEOUT must be positive and PHONE_ESCALATION_DELAY not negative")
+		}
+	}
+
+	if c.TokenRefreshEnabled && (c.TokenRefreshBackoff <= 0 || c.TokenRefreshMaxBackoff < c.TokenRefreshBackoff) {
+		return ConfigError("TOKEN_REFRESH_BACKOFF must be positive and not exceed TOKEN_REFRESH_MAX_BACKOFF")
+	}
+
+	if c.LoginChallengeCooldown <= 0 {
+		return ConfigError("LOGIN_CHALLENGE_COOLDOWN must be positive")
+	}
+
+	if c.MaxAttachmentSize <= 0 {
+		return ConfigError("MAX_ATTACHMENT_SIZE must be positive")
+	}
+
+	if c.LoginMaxFailures < 0 {
+		return ConfigError("LOGIN_MAX_FAILURES must not be negative")
+	}
+
+	if c.LoginBackoff <= 0 || c.LoginMaxBackoff < c.LoginBackoff {
+		return ConfigError("LOGIN_BACKOFF must be positive and not exceed LOGIN_MAX_BACKOFF")
+	}
+
+	if c.ProbeEnabled && (c.ProbeInterval <= 0 || c.ProbeTimeout <= 0) {
+		return ConfigError("PROBE_INTERVAL and PROBE_TIMEOUT must be positive")
+	}
+
+	if c.ReadinessTimeout <= 0 || c.ReadinessCacheTTL < 0 || c.ReadinessQueueLimit < 0 {
+		return ConfigError("READINESS_TIMEOUT must be positive, READINESS_CACHE_TTL and READINESS_QUEUE_LIMIT must not be negative")
+	}
+
+	if c.MaxMessagesPerMinute < 0 {
+		return ConfigError("MAX_MESSAGES_PER_MINUTE must not be negative")
+	}
+
+	if c.RateLimitMode != "wait" && c.RateLimitMode != "reject" {
+		return ConfigError("RATE_LIMIT_MODE must be wait or reject")
+	}
+
+	if c.MizitoSendRetries < 0 {
+		return ConfigError("MIZITO_SEND_RETRIES must not be negative")
+	}
+
+	if c.MizitoSendBackoff <= 0 || c.MizitoSendMaxBackoff < c.MizitoSendBackoff {
+		return ConfigError("MIZITO_SEND_BACKOFF must be positive and not exceed MIZITO_SEND_MAX_BACKOFF")
+	}
+
+	if c.CanaryEnabled && c.CanaryDialogID == "" {
+		return ConfigError("CANARY_DIALOG_ID is required when CANARY_ENABLED is set")
+	}
+
+	if c.CanaryEnabled && (c.CanaryInterval <= 0 || c.CanaryTimeout <= 0) {
+		return ConfigError("CANARY_INTERVAL and CANARY_TIMEOUT must be positive")
+	}
+
+	if c.EventLogEnabled && (len(c.EventLogChannels) == 0 || len(c.EventLogLevels) == 0) {
+		return ConfigError("EVENTLOG_CHANNELS and EVENTLOG_LEVELS must not be empty")
+	}
+
+	if err := c.validateSMTP(); err != nil {
+		return err
+	}
+
+	if err := c.validateSyslog(); err != nil {
+		return err
+	}
+
+	if err := c.validateGRPC(); err != nil {
+		return err
+	}
+
+	if err := c.validateStorage(); err != nil {
+		return err
+	}
+
+	if c.EnrichIPs && c.EnrichTimeout <= 0 {
+		return ConfigError("ENRICH_TIMEOUT must be positive")
+	}
+
+	if len(c.DeadmanJobs) > 0 && c.DeadmanCheckInterval <= 0 {
+		return ConfigError("DEADMAN_CHECK_INTERVAL must be positive")
+	}
+
+	if c.DeadmanMaxJobs < 0 {
+		return ConfigError("DEADMAN_MAX_JOBS must not be negative")
+	}
+
+	if c.AuditHMACKey != "" && c.AuditSigningKeyFile != "" {
+		return ConfigError("set only one of AUDIT_HMAC_KEY and AUDIT_SIGNING_KEY_FILE")
+	}
+
+	if c.StartupLoginRetries < 1 {
+		return ConfigError("STARTUP_LOGIN_RETRIES must be at least 1")
+	}
+
+	return nil
+}
+
+// normalizeBasePath turns "mizito/", "/mizito" or "/mizito/" into "/mizito".
+// The root path "/" yields an empty base path.
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// ResolveURL joins a base URL (e.g. a tenant host) and an endpoint path
+func ResolveURL(base, path string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// ConfigError is a custom error type for configuration errors
+type ConfigError string
+
+func (e ConfigError) Error() string {
+	return string(e)
+}
+
+// GetLogLevel returns the log level for logging
+func (c *Config) GetLogLevel() string {
+	return c.LogLevel
+}
+
+// resolveMizitoURLs derives the endpoint URLs from MizitoBaseURL and the
+// endpoint paths
+func (c *Config) resolveMizitoURLs() {
+	c.MizitoLoginURL = ResolveURL(c.MizitoBaseURL, c.MizitoLoginPath)
+	c.MizitoChatAPIURL = ResolveURL(c.MizitoBaseURL, c.MizitoChatPath)
+	c.MizitoProbeURL = ResolveURL(c.MizitoBaseURL, c.MizitoProbePath)
+	c.MizitoUploadURL = ResolveURL(c.MizitoBaseURL, c.MizitoUploadPath)
+	c.MizitoDialogCreateURL = ResolveURL(c.MizitoBaseURL, c.MizitoDialogCreatePath)
+	c.MizitoDialogListURL = ResolveURL(c.MizitoBaseURL, c.MizitoDialogListPath)
+	c.MizitoUserListURL = ResolveURL(c.MizitoBaseURL, c.MizitoUserListPath)
+}
+
+// TLSEnabled reports whether the listeners serve HTTPS
+func (c *Config) TLSEnabled() bool {
+	return c.ServerTLSCert != ""
 }
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parseDeadmanJobs parses the expected run intervals of batch jobs, such as
+// "backup:25h,report:1h"
+func parseDeadmanJobs(value string) (map[string]time.Duration, error) {
+	jobs := make(map[string]time.Duration)
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		job, interval, ok := strings.Cut(entry, ":")
+		job = strings.TrimSpace(job)
+		if !ok || job == "" {
+			return nil, fmt.Errorf("invalid job %q: expected <job>:<interval>", entry)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid job %q: interval must be a positive duration such as 25h", entry)
+		}
+
+		jobs[job] = d
+	}
+
+	return jobs, nil
+}
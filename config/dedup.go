@@ -0,0 +1,54 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// Deduplication modes
+const (
+	// DedupSuppress drops notifications repeating one within the window
+	DedupSuppress = "suppress"
+
+	// DedupCount drops them as well, and once the window closes sends the
+	// notification again with the number of times it arrived
+	DedupCount = "count"
+)
+
+// loadDedup reads the deduplication window and mode
+func (c *Config) loadDedup() error {
+	if err := envDuration("DEDUP_WINDOW", &c.DedupWindow); err != nil {
+		return err
+	}
+
+	if mode := getenv("DEDUP_MODE"); mode != "" {
+		c.DedupMode = strings.ToLower(mode)
+	}
+	return nil
+}
+
+// validateDedup checks the deduplication settings of the configuration and
+// its routes
+func (c *Config) validateDedup() error {
+	if c.DedupMode != DedupSuppress && c.DedupMode != DedupCount {
+		return ConfigError("DEDUP_MODE must be suppress or count")
+	}
+	if c.DedupWindow < 0 {
+		return ConfigError("DEDUP_WINDOW must not be negative")
+	}
+	for name, rc := range c.Routes {
+		if rc.DedupWindow != nil && *rc.DedupWindow < 0 {
+			return ConfigError("ROUTE_" + strings.ToUpper(name) + "_DEDUP_WINDOW must not be negative")
+		}
+	}
+	return nil
+}
+
+// RouteDedupWindow returns the deduplication window of a route, zero when
+// its notifications are not deduplicated
+func (c *Config) RouteDedupWindow(name string) time.Duration {
+	if rc, ok := c.Routes[name]; ok && rc.DedupWindow != nil {
+		return *rc.DedupWindow
+	}
+	return c.DedupWindow
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Delivery policies
+const (
+	// DeliveryAll sends a notification to Mizito and every mirroring sink,
+	// and to the failover sinks when Mizito fails
+	DeliveryAll = "all"
+
+	// DeliveryFirstSuccess tries the targets of the delivery chain in order
+	// and stops at the first that takes the notification
+	DeliveryFirstSuccess = "first-success"
+)
+
+// DeliveryMizito names Mizito in a delivery chain
+const DeliveryMizito = "mizito"
+
+// loadDelivery reads the delivery policy and the retry budgets of sinks
+func (c *Config) loadDelivery() error {
+	if policy := getenv("DELIVERY_POLICY"); policy != "" {
+		c.DeliveryPolicy = strings.ToLower(policy)
+	}
+
+	if chain := getenv("DELIVERY_CHAIN"); chain != "" {
+		c.DeliveryChain = parseDeliveryChain(chain)
+	}
+
+	if retries := getenv("SINK_RETRIES"); retries != "" {
+		budgets, err := parseSinkRetries(retries)
+		if err != nil {
+			return ConfigError("invalid value for SINK_RETRIES: " + err.Error())
+		}
+		c.SinkRetries = budgets
+	}
+
+	return envDuration("SINK_RETRY_BACKOFF", &c.SinkRetryBackoff)
+}
+
+// validateDelivery checks the delivery policies of the configuration and its
+// routes
+func (c *Config) validateDelivery() error {
+	if !validDeliveryPolicy(c.DeliveryPolicy) {
+		return ConfigError("DELIVERY_POLICY must be all or first-success")
+	}
+	for name, rc := range c.Routes {
+		if rc.DeliveryPolicy != "" && !validDeliveryPolicy(rc.DeliveryPolicy) {
+			return ConfigError("ROUTE_" + strings.ToUpper(name) + "_DELIVERY_POLICY must be all or first-success")
+		}
+	}
+
+	if _, ok := c.SinkRetries[DeliveryMizito]; ok {
+		return ConfigError("SINK_RETRIES: retries of Mizito are set by MIZITO_SEND_RETRIES")
+	}
+	if c.SinkRetryBackoff <= 0 {
+		return ConfigError("SINK_RETRY_BACKOFF must be positive")
+	}
+	return nil
+}
+
+// RouteDelivery returns the delivery policy and chain of a route: its own,
+// or else those of DELIVERY_POLICY and DELIVERY_CHAIN. An empty chain means
+// Mizito followed by the failover sinks.
+func (c *Config) RouteDelivery(name string) (string, []string) {
+	policy, chain := c.DeliveryPolicy, c.DeliveryChain
+	if rc, ok := c.Routes[name]; ok {
+		if rc.DeliveryPolicy != "" {
+			policy = rc.DeliveryPolicy
+		}
+		if len(rc.DeliveryChain) > 0 {
+			chain = rc.DeliveryChain
+		}
+	}
+	return policy, chain
+}
+
+func validDeliveryPolicy(policy string) bool {
+	return policy == DeliveryAll || policy == DeliveryFirstSuccess
+}
+
+// parseDeliveryChain parses a comma-separated list of targets
+func parseDeliveryChain(value string) []string {
+	var chain []string
+	for _, target := range strings.Split(value, ",") {
+		if target = strings.ToLower(strings.TrimSpace(target)); target != "" {
+			chain = append(chain, target)
+		}
+	}
+	return chain
+}
+
+// parseSinkRetries parses "<sink>:<retries>,..."
+func parseSinkRetries(value string) (map[string]int, error) {
+	budgets := make(map[string]int)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, count, ok := strings.Cut(entry, ":")
+		retries, err := strconv.Atoi(strings.TrimSpace(count))
+		if !ok || err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid entry %q: expected <sink>:<retries>", entry)
+		}
+		budgets[strings.ToLower(strings.TrimSpace(name))] = retries
+	}
+	return budgets, nil
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DialogRoute sends notifications within a priority range to a dialog
+type DialogRoute struct {
+	MinPriority int
+	MaxPriority int
+	DialogID    string
+}
+
+// Matches reports whether a priority falls within the route's range
+func (r DialogRoute) Matches(priority int) bool {
+	return priority >= r.MinPriority && priority <= r.MaxPriority
+}
+
+// DialogAuto as the dialog of a route creates a group dialog for the route
+// on first use
+const DialogAuto = "auto"
+
+// loadDialogAutoCreate reads the settings of dialogs created for routes
+func (c *Config) loadDialogAutoCreate() error {
+	if err := envBool("DIALOG_AUTO_CREATE", &c.DialogAutoCreate); err != nil {
+		return err
+	}
+
+	if name := getenv("DIALOG_AUTO_NAME"); name != "" {
+		c.DialogAutoName = name
+	}
+
+	if members := getenv("DIALOG_AUTO_MEMBERS"); members != "" {
+		for _, member := range strings.Split(members, ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				c.DialogAutoMembers = append(c.DialogAutoMembers, member)
+			}
+		}
+	}
+
+	// The created dialogs are kept next to the token unless set explicitly
+	c.DialogMapFile = filepath.Join(filepath.Dir(c.JWTTokenFile), "dialogs.json")
+	if mapFile := getenv("DIALOG_MAP_FILE"); mapFile != "" {
+		c.DialogMapFile = mapFile
+	}
+	return nil
+}
+
+// RouteDialog returns the dialog of a route: its DIALOG_ID, DialogAuto when
+// its dialog is created on first use, or empty for priority routing
+func (c *Config) RouteDialog(name string) string {
+	if dialogID := c.Route(name).DialogID; dialogID != "" {
+		return dialogID
+	}
+	if c.DialogAutoCreate {
+		return DialogAuto
+	}
+	return ""
+}
+
+// DialogName returns the name of the dialog created for a route
+func (c *Config) DialogName(route string) string {
+	return strings.ReplaceAll(c.DialogAutoName, "{route}", route)
+}
+
+// DialogAllowed reports whether requests may target a dialog explicitly:
+// the default dialog, routed dialogs, the dialogs of routes, the off-hours
+// dialogs, the canary dialog and those in the allowlist are allowed
+func (c *Config) DialogAllowed(dialogID string) bool {
+	if dialogID == c.MizitoDialogID {
+		return true
+	}
+
+	if c.CanaryEnabled && dialogID == c.CanaryDialogID {
+		return true
+	}
+
+	for _, route := range c.DialogRoutes {
+		if route.DialogID == dialogID {
+			return true
+		}
+	}
+
+	if c.OffHoursDialogID != "" && dialogID == c.OffHoursDialogID {
+		return true
+	}
+
+	for _, rc := range c.Routes {
+		if rc.DialogID == dialogID && dialogID != DialogAuto {
+			return true
+		}
+		if rc.OffHoursDialogID != "" && rc.OffHoursDialogID == dialogID {
+			return true
+		}
+	}
+
+	for _, allowed := range c.DialogAllowlist {
+		if allowed == dialogID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseDialogRoutes parses a routing table such as "8-10:oncall,0-7:general".
+// Ranges may be written as "min-max", "min+" (open ended) or a single value.
+// Rules are evaluated in order; the first match wins.
+func parseDialogRoutes(value string) ([]DialogRoute, error) {
+	var routes []DialogRoute
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rangeSpec, dialogID, ok := strings.Cut(entry, ":")
+		dialogID = strings.TrimSpace(dialogID)
+		if !ok || dialogID == "" {
+			return nil, fmt.Errorf("invalid dialog route %q: expected <range>:<dialog_id>", entry)
+		}
+
+		min, max, err := parsePriorityRange(strings.TrimSpace(rangeSpec))
+		if err != nil {
+			return nil, fmt.Errorf("invalid dialog route %q: %w", entry, err)
+		}
+
+		routes = append(routes, DialogRoute{MinPriority: min, MaxPriority: max, DialogID: dialogID})
+	}
+
+	return routes, nil
+}
+
+// parsePriorityRange parses "min-max", "min+" or a single priority
+func parsePriorityRange(spec string) (int, int, error) {
+	if strings.HasSuffix(spec, "+") {
+		min, err := strconv.Atoi(strings.TrimSuffix(spec, "+"))
+		return min, int(^uint(0) >> 1), err
+	}
+
+	if from, to, ok := strings.Cut(spec, "-"); ok {
+		min, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return 0, 0, err
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil {
+			return 0, 0, err
+		}
+		if min > max {
+			return 0, 0, fmt.Errorf("range start %d is greater than end %d", min, max)
+		}
+		return min, max, nil
+	}
+
+	p, err := strconv.Atoi(spec)
+	return p, p, err
+}
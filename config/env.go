@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// dotenv holds the variables set from the .env file by the last load, so
+// a reload picks up changed and removed entries without overriding
+// variables of the real environment
+var dotenv map[string]string
+
+// loadDotenv sets the variables of the .env file that are not set in the
+// environment
+func loadDotenv() error {
+	values, err := godotenv.Read()
+
+	for name, value := range dotenv {
+		if current, ok := os.LookupEnv(name); ok && current == value {
+			if _, kept := values[name]; !kept {
+				os.Unsetenv(name)
+			}
+		}
+	}
+
+	loaded := make(map[string]string, len(values))
+	for name, value := range values {
+		current, ok := os.LookupEnv(name)
+		if previous, set := dotenv[name]; ok && (!set || current != previous) {
+			continue
+		}
+		os.Setenv(name, value)
+		loaded[name] = value
+	}
+	dotenv = loaded
+
+	return err
+}
+
+// envBool sets *dst from a boolean environment variable when it is set
+func envBool(name string, dst *bool) error {
+	value := getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return ConfigError(fmt.Sprintf("%s must be a boolean, got %q", name, value))
+	}
+
+	*dst = v
+	return nil
+}
+
+// envInt sets *dst from an integer environment variable when it is set
+func envInt(name string, dst *int) error {
+	value := getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return ConfigError(fmt.Sprintf("%s must be an integer, got %q", name, value))
+	}
+
+	*dst = v
+	return nil
+}
+
+// envFloat sets *dst from a decimal environment variable when it is set
+func envFloat(name string, dst *float64) error {
+	value := getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return ConfigError(fmt.Sprintf("%s must be a number, got %q", name, value))
+	}
+
+	*dst = v
+	return nil
+}
+
+// envDuration sets *dst from a duration environment variable (e.g. "30s") when it is set
+func envDuration(name string, dst *time.Duration) error {
+	value := getenv(name)
+	if value == "" {
+		return nil
+	}
+
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return ConfigError(fmt.Sprintf("%s must be a duration like 30s or 5m, got %q", name, value))
+	}
+
+	*dst = v
+	return nil
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/assets"
+	"gopkg.in/yaml.v3"
+)
+
+// fileSettings holds the settings of CONFIG_FILE under the names of the
+// environment variables they stand for; see getenv
+var fileSettings map[string]string
+
+// fileAccounts holds the accounts section of CONFIG_FILE
+var fileAccounts map[string]*Account
+
+// commandSettings are read by the subcommands only, through Setting; the
+// server accepts them in CONFIG_FILE without reading them
+var commandSettings = map[string]bool{
+	"GOTIFY_CLIENT_TOKEN": true,
+	"GOTIFY_PASSWORD":     true,
+}
+
+// readSettings holds the names read through getenv by the current load, so
+// that settings of CONFIG_FILE no option reads are reported as unknown
+var readSettings map[string]bool
+
+// defaultSettings holds the built-in route defaults, see
+// assets.RouteDefaults
+var defaultSettings = mustParseDefaults()
+
+// getenv returns an environment variable, or the setting of the same name
+// in CONFIG_FILE when the variable is not set, or else its built-in default
+func getenv(name string) string {
+	if readSettings != nil {
+		readSettings[name] = true
+	}
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	if value := fileSettings[name]; value != "" {
+		return value
+	}
+	return defaultSettings[name]
+}
+
+// LoadSettings reads .env and CONFIG_FILE for Setting. The subcommands use
+// it to read the few settings they need like the server, without loading a
+// complete configuration.
+func LoadSettings() error {
+	// A missing .env is not an error, as for the server
+	loadDotenv()
+
+	fileSettings, fileAccounts = nil, nil
+	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
+		var err error
+		if fileSettings, fileAccounts, err = loadFile(configFile); err != nil {
+			return ConfigError("CONFIG_FILE: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Setting returns a setting read by LoadSettings: the environment variable,
+// or else the setting of CONFIG_FILE or its built-in default
+func Setting(name string) string {
+	return getenv(name)
+}
+
+// environ returns the environment variables merged with the settings of
+// CONFIG_FILE and the built-in defaults, as "NAME=value" pairs
+func environ() []string {
+	env := os.Environ()
+	for name, value := range fileSettings {
+		if os.Getenv(name) == "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	for name, value := range defaultSettings {
+		if os.Getenv(name) == "" && fileSettings[name] == "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// mustParseDefaults flattens the built-in route defaults; they are part of
+// the binary, so failing to parse them is a bug
+func mustParseDefaults() map[string]string {
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(assets.RouteDefaults(), &document); err != nil {
+		panic("config: invalid route defaults: " + err.Error())
+	}
+
+	settings := make(map[string]string)
+	if err := flattenSettings("", document, settings); err != nil {
+		panic("config: invalid route defaults: " + err.Error())
+	}
+	return settings
+}
+
+// loadFile reads a YAML or JSON configuration file. Sections are flattened
+// into the names of environment variables: nested keys are joined with
+// underscores, so server.port stands for SERVER_PORT and
+// routes.grafana.template for ROUTE_GRAFANA_TEMPLATE. Lists become
+// comma-separated values, with single-entry maps written as key:value, e.g.
+// for dialog routes. The accounts section holds additional Mizito accounts
+// like MIZITO_ACCOUNTS_FILE.
+func loadFile(path string) (map[string]string, map[string]*Account, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// YAML is a superset of JSON, so one parser reads both
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	// Accounts are decoded like MIZITO_ACCOUNTS_FILE, rejecting unknown
+	// fields; the other sections are left to flattenSettings
+	var sections struct {
+		Accounts map[string]*Account    `yaml:"accounts"`
+		Settings map[string]interface{} `yaml:",inline"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&sections); err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("failed to parse accounts of %s: %w", path, err)
+	}
+	delete(document, "accounts")
+
+	settings := make(map[string]string)
+	if err := flattenSettings("", document, settings); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return settings, sections.Accounts, nil
+}
+
+// unknownFileSettings returns the settings of CONFIG_FILE that the load did
+// not read, sorted, under the names of the environment variables. Route,
+// profile and quiet hours options and Mizito headers are read by prefix, so
+// they are checked against their options instead.
+func unknownFileSettings() []string {
+	var unknown []string
+	for name := range fileSettings {
+		if readSettings[name] || commandSettings[name] || strings.HasPrefix(name, headerPrefix) {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(name, "ROUTE_"); ok {
+			if _, _, ok := splitRouteKey(rest); ok {
+				continue
+			}
+		}
+		if rest, ok := strings.CutPrefix(name, "PROFILE_"); ok {
+			if _, _, ok := splitProfileKey(rest); ok {
+				continue
+			}
+		}
+		if rest, ok := strings.CutPrefix(name, "QUIET_"); ok {
+			if _, _, ok := splitQuietKey(rest); ok {
+				continue
+			}
+		}
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// flattenSettings adds a configuration value to settings under the
+// environment variable name made of prefix and its keys
+func flattenSettings(name string, value interface{}, settings map[string]string) error {
+	if m, ok := stringMap(value); ok {
+		value = m
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+			if name != "" {
+				child = name + "_" + child
+			}
+			// Route options are ROUTE_<NAME>_<OPTION>, in a routes section,
+			// and profile options PROFILE_<NAME>_<OPTION>, in a profiles section
+			switch child {
+			case "ROUTES":
+				child = "ROUTE"
+			case "PROFILES":
+				child = "PROFILE"
+			}
+			if err := flattenSettings(child, v[key], settings); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if m, ok := stringMap(item); ok {
+				if len(m) != 1 {
+					return fmt.Errorf("%s: list entries must be values or single key: value pairs", strings.ToLower(name))
+				}
+				for key, value := range m {
+					items = append(items, key+":"+fmt.Sprint(value))
+				}
+				continue
+			}
+			if _, ok := item.([]interface{}); ok {
+				return fmt.Errorf("%s: nested lists are not supported", strings.ToLower(name))
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		settings[name] = strings.Join(items, ",")
+		return nil
+
+	default:
+		if name == "" {
+			return fmt.Errorf("expected a map of settings")
+		}
+		settings[name] = fmt.Sprint(v)
+		return nil
+	}
+}
+
+// stringMap converts a YAML map to a map with string keys; maps with keys
+// such as numbers are decoded with interface{} keys
+func stringMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = value
+		}
+		return m, true
+	default:
+		return nil, false
+	}
+}
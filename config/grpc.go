@@ -0,0 +1,27 @@
+package config
+
+// loadGRPC reads the settings of the gRPC API
+func (c *Config) loadGRPC() error {
+	if err := envBool("GRPC_ENABLED", &c.GRPCEnabled); err != nil {
+		return err
+	}
+
+	if port := getenv("GRPC_PORT"); port != "" {
+		c.GRPCPort = port
+	}
+	return nil
+}
+
+// validateGRPC checks that the port of the gRPC API is not taken by another
+// listener
+func (c *Config) validateGRPC() error {
+	if !c.GRPCEnabled {
+		return nil
+	}
+
+	if c.GRPCPort == c.ServerPort || (c.AdminPort != "" && c.GRPCPort == c.AdminPort) ||
+		(c.SMTPEnabled && c.GRPCPort == c.SMTPPort) || (c.SyslogEnabled && c.SyslogTCP() && c.GRPCPort == c.SyslogPort) {
+		return ConfigError("GRPC_PORT must differ from SERVER_PORT, ADMIN_PORT, SMTP_PORT and SYSLOG_PORT")
+	}
+	return nil
+}
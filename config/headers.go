@@ -0,0 +1,85 @@
+package config
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerPrefix starts the variables adding or replacing a header of the
+// requests to Mizito, e.g. MIZITO_HEADER_SEC_CH_UA for sec-ch-ua
+const headerPrefix = "MIZITO_HEADER_"
+
+// HeaderNone as the value of a MIZITO_HEADER_<NAME> variable stops the
+// header from being sent
+const HeaderNone = "none"
+
+// DefaultMizitoOrigin is the web app Mizito expects requests to come from
+const DefaultMizitoOrigin = "https://office.mizito.ir"
+
+// defaultMizitoHeaders returns the headers of the browser the Mizito web app
+// runs in, which Mizito expects of its clients
+func defaultMizitoHeaders() map[string]string {
+	return map[string]string{
+		"Accept-Language":    "en-US,en;q=0.8",
+		"Connection":         "keep-alive",
+		"Origin":             DefaultMizitoOrigin,
+		"Referer":            DefaultMizitoOrigin + "/",
+		"Sec-Fetch-Dest":     "empty",
+		"Sec-Fetch-Mode":     "cors",
+		"Sec-Fetch-Site":     "same-site",
+		"Sec-Gpc":            "1",
+		"User-Agent":         "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36",
+		"Sec-Ch-Ua":          `"Chromium";v="142", "Brave";v="142", "Not_A Brand";v="99"`,
+		"Sec-Ch-Ua-Mobile":   "?0",
+		"Sec-Ch-Ua-Platform": `"Windows"`,
+	}
+}
+
+// loadMizitoHeaders reads the headers sent to Mizito: the browser headers,
+// with MIZITO_ORIGIN and MIZITO_USER_AGENT applied, then changed by
+// MIZITO_HEADER_<NAME> variables, whose underscores become dashes
+func (c *Config) loadMizitoHeaders() error {
+	c.MizitoHeaders = defaultMizitoHeaders()
+
+	if origin := getenv("MIZITO_ORIGIN"); origin != "" {
+		origin = strings.TrimSuffix(origin, "/")
+		c.MizitoHeaders["Origin"] = origin
+		c.MizitoHeaders["Referer"] = origin + "/"
+	}
+
+	if userAgent := getenv("MIZITO_USER_AGENT"); userAgent != "" {
+		c.MizitoHeaders["User-Agent"] = userAgent
+	}
+
+	for _, env := range environ() {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(key, headerPrefix) || value == "" {
+			continue
+		}
+
+		name := http.CanonicalHeaderKey(strings.ReplaceAll(strings.TrimPrefix(key, headerPrefix), "_", "-"))
+		if name == "" {
+			continue
+		}
+		if reservedHeader(name) {
+			return ConfigError(key + " cannot be set: " + name + " is set per request")
+		}
+
+		if strings.EqualFold(value, HeaderNone) {
+			delete(c.MizitoHeaders, name)
+			continue
+		}
+		c.MizitoHeaders[name] = value
+	}
+	return nil
+}
+
+// reservedHeader reports whether a header is set per request and cannot
+// be configured
+func reservedHeader(name string) bool {
+	switch name {
+	case "Accept", "Content-Type", "Content-Length", "Host", "X-Token":
+		return true
+	}
+	return false
+}
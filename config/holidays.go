@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// The runtime image has no timezone database
+	_ "time/tzdata"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/holidays"
+)
+
+// loadHolidays reads the timezone and the holiday calendar kept in it
+func (c *Config) loadHolidays() error {
+	timezone := "Asia/Tehran"
+	if tz := getenv("TIMEZONE"); tz != "" {
+		timezone = tz
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return ConfigError(fmt.Sprintf("invalid value for TIMEZONE: %v", err))
+	}
+	c.Timezone = location
+
+	calendar := holidays.CalendarIran
+	if name := getenv("HOLIDAY_CALENDAR"); name != "" {
+		calendar = strings.ToLower(name)
+	}
+
+	var added, excluded []holidays.Date
+	for name, dst := range map[string]*[]holidays.Date{
+		"HOLIDAYS":         &added,
+		"HOLIDAYS_EXCLUDE": &excluded,
+	} {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		dates, err := holidays.ParseDates(value)
+		if err != nil {
+			return ConfigError(fmt.Sprintf("invalid value for %s: %v", name, err))
+		}
+		*dst = dates
+	}
+
+	c.Holidays, err = holidays.New(calendar, added, excluded, location)
+	if err != nil {
+		return ConfigError(fmt.Sprintf("invalid value for HOLIDAY_CALENDAR: %v", err))
+	}
+	return nil
+}
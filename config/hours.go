@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeRange is a range of the day, as offsets from midnight
+type TimeRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Contains reports whether the time of day of t falls within the range
+func (r TimeRange) Contains(t time.Time) bool {
+	offset := sinceMidnight(t)
+	return offset >= r.Start && offset < r.End
+}
+
+// weekdays maps the names of weekdays, full and abbreviated, to weekdays
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// loadBusinessHours reads the business hours and the off-hours routing
+func (c *Config) loadBusinessHours() error {
+	if hours := getenv("BUSINESS_HOURS"); hours != "" {
+		ranges, err := parseTimeRanges(hours)
+		if err != nil {
+			return ConfigError(fmt.Sprintf("invalid value for BUSINESS_HOURS: %v", err))
+		}
+		c.BusinessHours = ranges
+	}
+
+	if days := getenv("BUSINESS_DAYS"); days != "" {
+		parsed, err := parseWeekdays(days)
+		if err != nil {
+			return ConfigError(fmt.Sprintf("invalid value for BUSINESS_DAYS: %v", err))
+		}
+		c.BusinessDays = parsed
+	}
+
+	if dialogID := getenv("OFF_HOURS_DIALOG_ID"); dialogID != "" {
+		c.OffHoursDialogID = dialogID
+	}
+
+	if mentions := getenv("OFF_HOURS_MENTIONS"); mentions != "" {
+		c.OffHoursMentions = parseMentions(mentions)
+	}
+	return nil
+}
+
+// validateBusinessHours checks that off-hours routing has business hours to
+// tell off hours by
+func (c *Config) validateBusinessHours() error {
+	if len(c.BusinessHours) > 0 {
+		return nil
+	}
+
+	if c.OffHoursDialogID != "" || len(c.OffHoursMentions) > 0 {
+		return ConfigError("OFF_HOURS_DIALOG_ID and OFF_HOURS_MENTIONS require BUSINESS_HOURS")
+	}
+	for name, rc := range c.Routes {
+		if rc.OffHoursDialogID != "" || rc.OffHoursTemplate != "" || len(rc.OffHoursMentions) > 0 {
+			return ConfigError("ROUTE_" + strings.ToUpper(name) + "_OFF_HOURS_* settings require BUSINESS_HOURS")
+		}
+	}
+	return nil
+}
+
+// parseTimeRanges parses ranges of the day such as "08:00-12:00,13:00-17:00"
+func parseTimeRanges(value string) ([]TimeRange, error) {
+	var ranges []TimeRange
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		from, to, ok := strings.Cut(entry, "-")
+		if !ok {
+			return nil, fmt.Errorf("expected HH:MM-HH:MM, got %q", entry)
+		}
+		start, err := parseTimeOfDay(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(to)
+		if err != nil {
+			return nil, err
+		}
+		if start >= end {
+			return nil, fmt.Errorf("range %q ends before it starts", entry)
+		}
+		ranges = append(ranges, TimeRange{Start: start, End: end})
+	}
+	return ranges, nil
+}
+
+// parseTimeOfDay parses "HH:MM", up to 24:00 for the end of the day
+func parseTimeOfDay(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	hour, minute, ok := strings.Cut(value, ":")
+	h, errH := strconv.Atoi(hour)
+	m, errM := strconv.Atoi(minute)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseWeekdays parses weekdays such as "sat,sun,mon" or ranges such as
+// "sat-wed", which wrap around the end of the week
+func parseWeekdays(value string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry == "" {
+			continue
+		}
+
+		from, to, isRange := strings.Cut(entry, "-")
+		first, ok := weekdays[strings.TrimSpace(from)]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", from)
+		}
+		if !isRange {
+			days = append(days, first)
+			continue
+		}
+
+		last, ok := weekdays[strings.TrimSpace(to)]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", to)
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseMentions parses a comma-separated list of users to mention
+func parseMentions(value string) []string {
+	var mentions []string
+	for _, user := range strings.Split(value, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			mentions = append(mentions, user)
+		}
+	}
+	return mentions
+}
+
+// OffHours reports whether t is outside the business hours: on a day that
+// is not a business day or is a holiday, or outside BUSINESS_HOURS in
+// TIMEZONE. It is always false without BUSINESS_HOURS.
+func (c *Config) OffHours(t time.Time) bool {
+	if len(c.BusinessHours) == 0 {
+		return false
+	}
+
+	t = t.In(c.Timezone)
+	if _, ok := c.Holidays.Holiday(t); ok {
+		return true
+	}
+
+	businessDay := false
+	for _, day := range c.BusinessDays {
+		if t.Weekday() == day {
+			businessDay = true
+		}
+	}
+	if !businessDay {
+		return true
+	}
+
+	for _, r := range c.BusinessHours {
+		if r.Contains(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// RouteOffHoursDialog returns the dialog of a route outside business hours,
+// empty when it keeps its dialog
+func (c *Config) RouteOffHoursDialog(name string) string {
+	if dialogID := c.Route(name).OffHoursDialogID; dialogID != "" {
+		return dialogID
+	}
+	return c.OffHoursDialogID
+}
+
+// RouteOffHoursMentions returns the users mentioned in notifications of a
+// route outside business hours
+func (c *Config) RouteOffHoursMentions(name string) []string {
+	if mentions := c.Route(name).OffHoursMentions; len(mentions) > 0 {
+		return mentions
+	}
+	return c.OffHoursMentions
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// Messengers lists the messenger platforms notifications can be sent to
+// through a bot, each configured by variables prefixed with its name, e.g.
+// BALE_BOT_TOKEN
+var Messengers = []string{"telegram", "bale", "eitaa"}
+
+// Messenger modes
+const (
+	// MessengerMirror sends every notification alongside Mizito
+	MessengerMirror = "mirror"
+
+	// MessengerFailover sends only the notifications Mizito failed to take
+	MessengerFailover = "failover"
+)
+
+// MessengerConfig holds the settings of a messenger bot: notifications are
+// sent to ChatID, or the chat of their route
+type MessengerConfig struct {
+	BotToken string
+	ChatID   string
+	Mode     string
+	Timeout  time.Duration
+	APIURL   string
+}
+
+// The chat of each messenger is a route option, e.g. ROUTE_<NAME>_BALE_CHAT_ID
+func init() {
+	for _, platform := range Messengers {
+		platform := platform
+		routeOptions[strings.ToUpper(platform)+"_CHAT_ID"] = func(rc *RouteConfig, value string) error {
+			if rc.MessengerChats == nil {
+				rc.MessengerChats = make(map[string]string)
+			}
+			rc.MessengerChats[platform] = value
+			return nil
+		}
+	}
+}
+
+// loadMessengers reads the settings of each messenger platform
+func (c *Config) loadMessengers() error {
+	c.Messengers = make(map[string]*MessengerConfig, len(Messengers))
+	for _, platform := range Messengers {
+		prefix := strings.ToUpper(platform) + "_"
+		mc := &MessengerConfig{
+			BotToken: getenv(prefix + "BOT_TOKEN"),
+			ChatID:   getenv(prefix + "CHAT_ID"),
+			Mode:     MessengerMirror,
+			Timeout:  10 * time.Second,
+			APIURL:   getenv(prefix + "API_URL"),
+		}
+		if mode := getenv(prefix + "MODE"); mode != "" {
+			mc.Mode = strings.ToLower(mode)
+		}
+		if err := envDuration(prefix+"TIMEOUT", &mc.Timeout); err != nil {
+			return err
+		}
+		c.Messengers[platform] = mc
+	}
+	return nil
+}
+
+// validateMessengers checks the messenger settings and the chats of routes
+func (c *Config) validateMessengers() error {
+	for _, platform := range Messengers {
+		prefix := strings.ToUpper(platform) + "_"
+		mc := c.Messengers[platform]
+		if mc == nil {
+			continue
+		}
+		if mc.ChatID != "" && mc.BotToken == "" {
+			return ConfigError(prefix + "CHAT_ID requires " + prefix + "BOT_TOKEN")
+		}
+		if mc.Mode != MessengerMirror && mc.Mode != MessengerFailover {
+			return ConfigError(prefix + "MODE must be mirror or failover")
+		}
+		if mc.BotToken != "" && mc.Timeout <= 0 {
+			return ConfigError(prefix + "TIMEOUT must be positive")
+		}
+
+		for name, rc := range c.Routes {
+			if rc.MessengerChats[platform] != "" && mc.BotToken == "" {
+				return ConfigError("ROUTE_" + strings.ToUpper(name) + "_" + prefix + "CHAT_ID requires " + prefix + "BOT_TOKEN")
+			}
+		}
+	}
+	return nil
+}
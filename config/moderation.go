@@ -0,0 +1,30 @@
+package config
+
+import "path/filepath"
+
+// loadModeration reads the settings of moderated routes
+func (c *Config) loadModeration() error {
+	if dialogID := getenv("MODERATION_DIALOG_ID"); dialogID != "" {
+		c.ModerationDialogID = dialogID
+	}
+
+	if err := envDuration("MODERATION_TIMEOUT", &c.ModerationTimeout); err != nil {
+		return err
+	}
+
+	// Held messages are kept next to the token unless set explicitly
+	c.ModerationFile = filepath.Join(filepath.Dir(c.JWTTokenFile), "moderation.json")
+	if file := getenv("MODERATION_FILE"); file != "" {
+		c.ModerationFile = file
+	}
+	return nil
+}
+
+// RouteModerationDialog returns the dialog receiving the approval requests
+// of a moderated route, empty when they are only listed for moderators
+func (c *Config) RouteModerationDialog(name string) string {
+	if dialogID := c.Route(name).ModerationDialogID; dialogID != "" {
+		return dialogID
+	}
+	return c.ModerationDialogID
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile is a formatting profile, rendering the notifications sent to its
+// dialogs the way their readers need, e.g. tersely in an ops channel and in
+// full in an audit channel. Profiles are configured through environment
+// variables of the form PROFILE_<NAME>_<OPTION>.
+type Profile struct {
+	// Dialogs lists the dialogs the profile applies to
+	Dialogs []string
+
+	// Template renders the message text instead of MESSAGE_TEMPLATE
+	Template string
+
+	// Emoji prefixes the text with an emoji by severity (high, normal,
+	// low); nil for none
+	Emoji map[string]string
+
+	// MaxLength caps the text in characters (0 = unlimited)
+	MaxLength int
+
+	// Language of the text: LanguageEnglish leaves it as rendered,
+	// LanguagePersian writes the numbers templates pass to .Number with
+	// Persian digits
+	Language string
+}
+
+// Profile languages
+const (
+	LanguageEnglish = "en"
+	LanguagePersian = "fa"
+)
+
+// defaultEmoji is the emoji set of EMOJI=default
+var defaultEmoji = map[string]string{
+	"high":   "🔴",
+	"normal": "🟡",
+	"low":    "🔵",
+}
+
+// profileOptions maps PROFILE_<NAME>_<OPTION> suffixes to setters
+var profileOptions = map[string]func(p *Profile, value string) error{
+	"DIALOGS": func(p *Profile, value string) error {
+		for _, dialogID := range strings.Split(value, ",") {
+			if dialogID = strings.TrimSpace(dialogID); dialogID != "" {
+				p.Dialogs = append(p.Dialogs, dialogID)
+			}
+		}
+		return nil
+	},
+	"TEMPLATE": func(p *Profile, value string) error {
+		p.Template = value
+		return nil
+	},
+	"EMOJI": func(p *Profile, value string) error {
+		emoji, err := parseEmoji(value)
+		if err != nil {
+			return err
+		}
+		p.Emoji = emoji
+		return nil
+	},
+	"MAX_LENGTH": func(p *Profile, value string) error {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		p.MaxLength = v
+		return nil
+	},
+	"LANGUAGE": func(p *Profile, value string) error {
+		p.Language = strings.ToLower(value)
+		return nil
+	},
+}
+
+// parseEmoji parses an emoji set: "default", "none" or severity:emoji
+// pairs such as "high:🔥,normal:⚠️"
+func parseEmoji(value string) (map[string]string, error) {
+	switch strings.ToLower(value) {
+	case "none":
+		return nil, nil
+	case "default":
+		return defaultEmoji, nil
+	}
+
+	emoji := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		severity, symbol, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		severity = strings.ToLower(strings.TrimSpace(severity))
+		if !ok || symbol == "" {
+			return nil, fmt.Errorf("expected severity:emoji, got %q", entry)
+		}
+		if _, known := defaultEmoji[severity]; !known {
+			return nil, fmt.Errorf("unknown severity %q, expected high, normal or low", severity)
+		}
+		emoji[severity] = strings.TrimSpace(symbol)
+	}
+	return emoji, nil
+}
+
+// loadProfiles reads all PROFILE_<NAME>_<OPTION> environment variables
+func loadProfiles() (map[string]*Profile, error) {
+	profiles := make(map[string]*Profile)
+
+	for _, env := range environ() {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(key, "PROFILE_") || value == "" {
+			continue
+		}
+
+		name, option, ok := splitProfileKey(strings.TrimPrefix(key, "PROFILE_"))
+		if !ok {
+			continue
+		}
+
+		p, exists := profiles[name]
+		if !exists {
+			p = &Profile{Language: LanguageEnglish}
+			profiles[name] = p
+		}
+
+		if err := profileOptions[option](p, value); err != nil {
+			return nil, ConfigError(fmt.Sprintf("invalid value for %s: %v", key, err))
+		}
+	}
+
+	return profiles, nil
+}
+
+// splitProfileKey splits "<NAME>_<OPTION>" into a lower-case profile name
+// and a known option, the longest matching option suffix winning
+func splitProfileKey(key string) (string, string, bool) {
+	var name, option string
+	for opt := range profileOptions {
+		if !strings.HasSuffix(key, "_"+opt) || len(opt) <= len(option) {
+			continue
+		}
+		if n := strings.TrimSuffix(key, "_"+opt); n != "" {
+			name, option = n, opt
+		}
+	}
+	return strings.ToLower(name), option, option != ""
+}
+
+// validateProfiles checks the formatting profiles: each dialog may have one
+// profile only
+func (c *Config) validateProfiles() error {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	owners := make(map[string]string)
+	for _, name := range names {
+		p := c.Profiles[name]
+		setting := "PROFILE_" + strings.ToUpper(name)
+
+		if len(p.Dialogs) == 0 {
+			return ConfigError(setting + "_DIALOGS must name the dialogs of the profile")
+		}
+		if p.MaxLength < 0 {
+			return ConfigError(setting + "_MAX_LENGTH must not be negative")
+		}
+		if p.Language != LanguageEnglish && p.Language != LanguagePersian {
+			return ConfigError(setting + "_LANGUAGE must be en or fa")
+		}
+
+		for _, dialogID := range p.Dialogs {
+			if owner, ok := owners[dialogID]; ok {
+				return ConfigError("dialog " + dialogID + " is in the profiles " + owner + " and " + name)
+			}
+			owners[dialogID] = name
+		}
+	}
+	return nil
+}
+
+// DialogProfile returns the formatting profile of a dialog and its name,
+// or nil when it has none
+func (c *Config) DialogProfile(dialogID string) (string, *Profile) {
+	for name, p := range c.Profiles {
+		for _, id := range p.Dialogs {
+			if id == dialogID {
+				return name, p
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QuietHours is a do-not-disturb window of dialogs. During the window,
+// notifications below PassPriority are buffered and delivered as a digest
+// when it ends; those at PassPriority and above pass through. Windows are
+// configured through environment variables of the form
+// QUIET_<NAME>_<OPTION>.
+type QuietHours struct {
+	// Dialogs lists the dialogs the window applies to
+	Dialogs []string
+
+	// Start and End are the window as offsets from midnight; a window
+	// starting after it ends, such as 22:00-07:00, spans midnight
+	Start time.Duration
+	End   time.Duration
+
+	// Timezone of the window, TIMEZONE unless set
+	Timezone *time.Location
+
+	// PassPriority is the lowest priority delivered during the window
+	PassPriority int
+}
+
+// defaultQuietPassPriority lets high-priority notifications, 8 and above on
+// the Gotify scale, through quiet hours
+const defaultQuietPassPriority = 8
+
+// quietOptions maps QUIET_<NAME>_<OPTION> suffixes to setters
+var quietOptions = map[string]func(q *QuietHours, value string) error{
+	"DIALOGS": func(q *QuietHours, value string) error {
+		for _, dialogID := range strings.Split(value, ",") {
+			if dialogID = strings.TrimSpace(dialogID); dialogID != "" {
+				q.Dialogs = append(q.Dialogs, dialogID)
+			}
+		}
+		return nil
+	},
+	"HOURS": func(q *QuietHours, value string) error {
+		from, to, ok := strings.Cut(value, "-")
+		if !ok {
+			return fmt.Errorf("expected HH:MM-HH:MM, got %q", value)
+		}
+		start, err := parseTimeOfDay(from)
+		if err != nil {
+			return err
+		}
+		end, err := parseTimeOfDay(to)
+		if err != nil {
+			return err
+		}
+		if start == end {
+			return fmt.Errorf("window %q is empty", value)
+		}
+		q.Start, q.End = start, end
+		return nil
+	},
+	"TIMEZONE": func(q *QuietHours, value string) error {
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			return err
+		}
+		q.Timezone = location
+		return nil
+	},
+	"PASS_PRIORITY": func(q *QuietHours, value string) error {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		q.PassPriority = v
+		return nil
+	},
+}
+
+// loadQuietHours reads all QUIET_<NAME>_<OPTION> environment variables and
+// the file buffering notifications during quiet hours. Windows without a
+// timezone keep TIMEZONE, so it is read first.
+func (c *Config) loadQuietHours() error {
+	c.QuietHours = make(map[string]*QuietHours)
+
+	for _, env := range environ() {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(key, "QUIET_") || value == "" {
+			continue
+		}
+
+		name, option, ok := splitQuietKey(strings.TrimPrefix(key, "QUIET_"))
+		if !ok {
+			continue
+		}
+
+		q, exists := c.QuietHours[name]
+		if !exists {
+			q = &QuietHours{Timezone: c.Timezone, PassPriority: defaultQuietPassPriority}
+			c.QuietHours[name] = q
+		}
+
+		if err := quietOptions[option](q, value); err != nil {
+			return ConfigError(fmt.Sprintf("invalid value for %s: %v", key, err))
+		}
+	}
+
+	// Buffered notifications are kept next to the token unless set
+	// explicitly
+	c.QuietFile = filepath.Join(filepath.Dir(c.JWTTokenFile), "quiet.json")
+	if file := getenv("QUIET_FILE"); file != "" {
+		c.QuietFile = file
+	}
+	return nil
+}
+
+// splitQuietKey splits "<NAME>_<OPTION>" into a lower-case window name and
+// a known option, the longest matching option suffix winning
+func splitQuietKey(key string) (string, string, bool) {
+	var name, option string
+	for opt := range quietOptions {
+		if !strings.HasSuffix(key, "_"+opt) || len(opt) <= len(option) {
+			continue
+		}
+		if n := strings.TrimSuffix(key, "_"+opt); n != "" {
+			name, option = n, opt
+		}
+	}
+	return strings.ToLower(name), option, option != ""
+}
+
+// validateQuietHours checks the quiet hours: each window needs dialogs and
+// hours, and each dialog may be in one window only
+func (c *Config) validateQuietHours() error {
+	names := make([]string, 0, len(c.QuietHours))
+	for name := range c.QuietHours {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	owners := make(map[string]string)
+	for _, name := range names {
+		q := c.QuietHours[name]
+		setting := "QUIET_" + strings.ToUpper(name)
+
+		if len(q.Dialogs) == 0 {
+			return ConfigError(setting + "_DIALOGS must name the dialogs of the quiet hours")
+		}
+		if q.Start == q.End {
+			return ConfigError(setting + "_HOURS must set the quiet hours, e.g. 22:00-07:00")
+		}
+
+		for _, dialogID := range q.Dialogs {
+			if owner, ok := owners[dialogID]; ok {
+				return ConfigError("dialog " + dialogID + " is in the quiet hours " + owner + " and " + name)
+			}
+			owners[dialogID] = name
+		}
+	}
+	return nil
+}
+
+// DialogQuietHours returns the quiet hours of a dialog and their name, or
+// nil when it has none
+func (c *Config) DialogQuietHours(dialogID string) (string, *QuietHours) {
+	for name, q := range c.QuietHours {
+		for _, id := range q.Dialogs {
+			if id == dialogID {
+				return name, q
+			}
+		}
+	}
+	return "", nil
+}
+
+// Quiet reports whether t falls within the window
+func (q *QuietHours) Quiet(t time.Time) bool {
+	offset := sinceMidnight(t.In(q.Timezone))
+	if q.Start < q.End {
+		return offset >= q.Start && offset < q.End
+	}
+	return offset >= q.Start || offset < q.End
+}
+
+// Ends returns the end of the window containing t, or of the next one
+func (q *QuietHours) Ends(t time.Time) time.Time {
+	t = t.In(q.Timezone)
+	end := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, q.Timezone).Add(q.End)
+	if !end.After(t) {
+		end = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, q.Timezone).Add(q.End)
+	}
+	return end
+}
+
+// sinceMidnight returns the time of day of t as an offset from midnight
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
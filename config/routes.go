@@ -0,0 +1,273 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RouteConfig holds per-route settings.
+// Routes are configured through environment variables of the form
+// ROUTE_<NAME>_<OPTION>, e.g. ROUTE_MESSAGE_CAPTURE=true.
+type RouteConfig struct {
+	// Capture stores raw inbound requests for later inspection and replay
+	Capture bool
+
+	// Schema is the path of a JSON Schema file inbound payloads must satisfy
+	Schema string
+
+	// Summary is a template for the first message line, which Mizito uses
+	// for chat previews and push notifications (e.g. "[{{.Severity}}] {{.Title}}")
+	Summary string
+
+	// Template renders the message text of this route instead of
+	// MESSAGE_TEMPLATE or "Title: Message"
+	Template string
+
+	// CandidateTemplate renders CandidatePercent percent of the
+	// notifications of this route instead of the current template, so a
+	// template change is tried on live traffic before it replaces Template
+	CandidateTemplate string
+	CandidatePercent  float64
+
+	// PreviewLength caps the summary line length in characters (0 = unlimited)
+	PreviewLength int
+
+	// LogLevel overrides LOG_LEVEL for this route; at debug level inbound
+	// requests and responses are logged in full
+	LogLevel string
+
+	// FromUserID overrides MIZITO_FROM_USER_ID, so messages of this route
+	// appear to come from another identity the account may send as
+	FromUserID string
+
+	// ExecCommand overrides EXEC_SINK_COMMAND for notifications of this route
+	ExecCommand string
+
+	// MessengerChats overrides the chat of each messenger platform, e.g.
+	// BALE_CHAT_ID, for notifications of this route; "none" stops them from
+	// being sent to that messenger
+	MessengerChats map[string]string
+
+	// DeliveryPolicy and DeliveryChain override DELIVERY_POLICY and
+	// DELIVERY_CHAIN for notifications of this route
+	DeliveryPolicy string
+	DeliveryChain  []string
+
+	// DedupWindow overrides DEDUP_WINDOW for notifications of this route
+	// when set; zero disables deduplication
+	DedupWindow *time.Duration
+
+	// SLOTarget overrides SLO_TARGET for deliveries of this route when set
+	SLOTarget *float64
+
+	// DialogID sends notifications of this route to a dialog instead of
+	// routing them by priority; DialogAuto creates a group dialog for the
+	// route on first use
+	DialogID string
+
+	// OffHoursDialogID, OffHoursTemplate and OffHoursMentions replace the
+	// dialog, template and OFF_HOURS_MENTIONS of this route outside
+	// business hours
+	OffHoursDialogID string
+	OffHoursTemplate string
+	OffHoursMentions []string
+
+	// Account names the Mizito account delivering notifications of this
+	// route, unless a request selects one; empty means the default account
+	Account string
+
+	// Moderated holds notifications of this route until a moderator
+	// approves them; ModerationDialogID overrides MODERATION_DIALOG_ID
+	Moderated          bool
+	ModerationDialogID string
+}
+
+// routeOptions maps a route option name to the function applying its value
+var routeOptions = map[string]func(rc *RouteConfig, value string) error{
+	"CAPTURE": func(rc *RouteConfig, value string) error {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		rc.Capture = v
+		return nil
+	},
+	"SCHEMA": func(rc *RouteConfig, value string) error {
+		rc.Schema = value
+		return nil
+	},
+	"SUMMARY": func(rc *RouteConfig, value string) error {
+		rc.Summary = value
+		return nil
+	},
+	"TEMPLATE": func(rc *RouteConfig, value string) error {
+		rc.Template = value
+		return nil
+	},
+	"FROM_USER_ID": func(rc *RouteConfig, value string) error {
+		rc.FromUserID = value
+		return nil
+	},
+	"EXEC_COMMAND": func(rc *RouteConfig, value string) error {
+		rc.ExecCommand = value
+		return nil
+	},
+	"DELIVERY_POLICY": func(rc *RouteConfig, value string) error {
+		rc.DeliveryPolicy = strings.ToLower(value)
+		return nil
+	},
+	"DELIVERY_CHAIN": func(rc *RouteConfig, value string) error {
+		rc.DeliveryChain = parseDeliveryChain(value)
+		return nil
+	},
+	"DEDUP_WINDOW": func(rc *RouteConfig, value string) error {
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		rc.DedupWindow = &v
+		return nil
+	},
+	"CANDIDATE_TEMPLATE": func(rc *RouteConfig, value string) error {
+		rc.CandidateTemplate = value
+		return nil
+	},
+	"CANDIDATE_PERCENT": func(rc *RouteConfig, value string) error {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return err
+		}
+		rc.CandidatePercent = v
+		return nil
+	},
+	"SLO_TARGET": func(rc *RouteConfig, value string) error {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		rc.SLOTarget = &v
+		return nil
+	},
+	"DIALOG_ID": func(rc *RouteConfig, value string) error {
+		rc.DialogID = value
+		return nil
+	},
+	"OFF_HOURS_DIALOG_ID": func(rc *RouteConfig, value string) error {
+		rc.OffHoursDialogID = value
+		return nil
+	},
+	"OFF_HOURS_TEMPLATE": func(rc *RouteConfig, value string) error {
+		rc.OffHoursTemplate = value
+		return nil
+	},
+	"OFF_HOURS_MENTIONS": func(rc *RouteConfig, value string) error {
+		rc.OffHoursMentions = parseMentions(value)
+		return nil
+	},
+	"MODERATED": func(rc *RouteConfig, value string) error {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		rc.Moderated = v
+		return nil
+	},
+	"MODERATION_DIALOG_ID": func(rc *RouteConfig, value string) error {
+		rc.ModerationDialogID = value
+		return nil
+	},
+	"ACCOUNT": func(rc *RouteConfig, value string) error {
+		rc.Account = strings.ToLower(value)
+		return nil
+	},
+	"LOG_LEVEL": func(rc *RouteConfig, value string) error {
+		rc.LogLevel = strings.ToLower(value)
+		return nil
+	},
+	"PREVIEW_LENGTH": func(rc *RouteConfig, value string) error {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		rc.PreviewLength = v
+		return nil
+	},
+}
+
+// loadRoutes reads all ROUTE_<NAME>_<OPTION> environment variables. A
+// variable ending in no known option is refused, so a typo such as
+// ROUTE_GRAFANA_DIALGO does not leave the route on its defaults unnoticed.
+func loadRoutes() (map[string]*RouteConfig, error) {
+	routes := make(map[string]*RouteConfig)
+
+	for _, env := range environ() {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(key, "ROUTE_") || value == "" {
+			continue
+		}
+
+		name, option, ok := splitRouteKey(strings.TrimPrefix(key, "ROUTE_"))
+		if !ok {
+			return nil, ConfigError(key + ": unknown route option, expected ROUTE_<NAME>_<OPTION>")
+		}
+
+		rc, exists := routes[name]
+		if !exists {
+			rc = &RouteConfig{}
+			routes[name] = rc
+		}
+
+		if err := routeOptions[option](rc, value); err != nil {
+			return nil, ConfigError(fmt.Sprintf("invalid value for %s: %v", key, err))
+		}
+	}
+
+	return routes, nil
+}
+
+// splitRouteKey splits "<NAME>_<OPTION>" into a lower-case route name and a
+// known option. Option names may themselves contain underscores, so the
+// longest matching option suffix wins.
+func splitRouteKey(key string) (string, string, bool) {
+	var name, option string
+	for opt := range routeOptions {
+		if !strings.HasSuffix(key, "_"+opt) || len(opt) <= len(option) {
+			continue
+		}
+		if n := strings.TrimSuffix(key, "_"+opt); n != "" {
+			name, option = n, opt
+		}
+	}
+
+	if option == "" {
+		return "", "", false
+	}
+
+	return strings.ToLower(name), option, true
+}
+
+// Route returns the settings for the named route.
+// Routes without explicit settings get the defaults.
+func (c *Config) Route(name string) *RouteConfig {
+	if rc, ok := c.Routes[name]; ok {
+		return rc
+	}
+	return &RouteConfig{}
+}
+
+// validateCandidateTemplates checks the candidate templates of routes: the
+// share of the traffic they render is a percentage
+func (c *Config) validateCandidateTemplates() error {
+	for name, rc := range c.Routes {
+		setting := "ROUTE_" + strings.ToUpper(name)
+		if rc.CandidatePercent < 0 || rc.CandidatePercent > 100 {
+			return ConfigError(setting + "_CANDIDATE_PERCENT must be between 0 and 100")
+		}
+		if rc.CandidatePercent > 0 && rc.CandidateTemplate == "" {
+			return ConfigError(setting + "_CANDIDATE_PERCENT requires " + setting + "_CANDIDATE_TEMPLATE")
+		}
+	}
+	return nil
+}
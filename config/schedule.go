@@ -0,0 +1,14 @@
+package config
+
+import "path/filepath"
+
+// loadSchedule reads the settings of scheduled delivery
+func (c *Config) loadSchedule() error {
+	// The schedule is kept next to the token unless set explicitly
+	c.ScheduleFile = filepath.Join(filepath.Dir(c.JWTTokenFile), "schedule.json")
+	if file := getenv("SCHEDULE_FILE"); file != "" {
+		c.ScheduleFile = file
+	}
+
+	return envDuration("SCHEDULE_MAX_DELAY", &c.ScheduleMaxDelay)
+}
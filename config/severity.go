@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+)
+
+// parseSeverityMap parses priority overrides of source severities, such as
+// "syslog:notice=5,zabbix:average=7", into priorities keyed by scale and
+// severity
+func parseSeverityMap(value string) (map[string]map[string]int, error) {
+	mappings := make(map[string]map[string]int)
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, priority, ok := strings.Cut(entry, "=")
+		scale, level, hasScale := strings.Cut(strings.TrimSpace(key), ":")
+		scale = strings.ToLower(strings.TrimSpace(scale))
+		level = strings.TrimSpace(level)
+		if !ok || !hasScale || level == "" {
+			return nil, fmt.Errorf("invalid mapping %q: expected <scale>:<severity>=<priority>", entry)
+		}
+		if !slices.Contains(severity.Scales(), scale) {
+			return nil, fmt.Errorf("invalid mapping %q: scale must be one of %s", entry, strings.Join(severity.Scales(), ", "))
+		}
+
+		p, err := strconv.Atoi(strings.TrimSpace(priority))
+		if err != nil || p < severity.MinPriority || p > severity.MaxPriority {
+			return nil, fmt.Errorf("invalid mapping %q: priority must be %d-%d", entry, severity.MinPriority, severity.MaxPriority)
+		}
+
+		if mappings[scale] == nil {
+			mappings[scale] = make(map[string]int)
+		}
+		mappings[scale][level] = p
+	}
+
+	return mappings, nil
+}
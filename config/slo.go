@@ -0,0 +1,93 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// loadSLO reads the delivery objectives
+func (c *Config) loadSLO() error {
+	for name, dst := range map[string]*float64{
+		"SLO_TARGET":          &c.SLOTarget,
+		"SLO_LATENCY_TARGET":  &c.SLOLatencyTarget,
+		"SLO_ALERT_BURN_RATE": &c.SLOAlertBurnRate,
+	} {
+		if err := envFloat(name, dst); err != nil {
+			return err
+		}
+	}
+
+	for name, dst := range map[string]*time.Duration{
+		"SLO_LATENCY":        &c.SLOLatency,
+		"SLO_WINDOW":         &c.SLOWindow,
+		"SLO_CHECK_INTERVAL": &c.SLOCheckInterval,
+	} {
+		if err := envDuration(name, dst); err != nil {
+			return err
+		}
+	}
+
+	if err := envInt("SLO_MIN_DELIVERIES", &c.SLOMinDeliveries); err != nil {
+		return err
+	}
+	return envInt("SLO_PRIORITY", &c.SLOPriority)
+}
+
+// validateSLO checks the delivery objectives of the configuration and its
+// routes. Objectives are ratios below 1, as no error budget is left at 1.
+func (c *Config) validateSLO() error {
+	if c.SLOTarget < 0 || c.SLOTarget >= 1 {
+		return ConfigError("SLO_TARGET must be at least 0 and below 1, e.g. 0.99")
+	}
+	for name, rc := range c.Routes {
+		if rc.SLOTarget != nil && (*rc.SLOTarget < 0 || *rc.SLOTarget >= 1) {
+			return ConfigError("ROUTE_" + strings.ToUpper(name) + "_SLO_TARGET must be at least 0 and below 1")
+		}
+	}
+	if !c.SLOEnabled() {
+		return nil
+	}
+
+	if c.SLOLatencyTarget < 0 || c.SLOLatencyTarget >= 1 {
+		return ConfigError("SLO_LATENCY_TARGET must be at least 0 and below 1, e.g. 0.95")
+	}
+	if c.SLOLatency <= 0 {
+		return ConfigError("SLO_LATENCY must be positive")
+	}
+	if c.SLOWindow < time.Minute {
+		return ConfigError("SLO_WINDOW must be at least 1m")
+	}
+	if c.SLOCheckInterval <= 0 {
+		return ConfigError("SLO_CHECK_INTERVAL must be positive")
+	}
+	if c.SLOAlertBurnRate <= 0 {
+		return ConfigError("SLO_ALERT_BURN_RATE must be positive")
+	}
+	if c.SLOMinDeliveries < 1 {
+		return ConfigError("SLO_MIN_DELIVERIES must be at least 1")
+	}
+	return nil
+}
+
+// SLOEnabled reports whether delivery objectives are tracked, for all
+// routes or some
+func (c *Config) SLOEnabled() bool {
+	if c.SLOTarget > 0 {
+		return true
+	}
+	for _, rc := range c.Routes {
+		if rc.SLOTarget != nil && *rc.SLOTarget > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteSLOTarget returns the delivery success objective of a route, zero
+// when its deliveries have none
+func (c *Config) RouteSLOTarget(name string) float64 {
+	if rc, ok := c.Routes[name]; ok && rc.SLOTarget != nil {
+		return *rc.SLOTarget
+	}
+	return c.SLOTarget
+}
@@ -0,0 +1,37 @@
+package config
+
+// loadSMTP reads the settings of the SMTP listener forwarding emails
+func (c *Config) loadSMTP() error {
+	if err := envBool("SMTP_ENABLED", &c.SMTPEnabled); err != nil {
+		return err
+	}
+
+	if port := getenv("SMTP_PORT"); port != "" {
+		c.SMTPPort = port
+	}
+
+	if domain := getenv("SMTP_DOMAIN"); domain != "" {
+		c.SMTPDomain = domain
+	}
+
+	c.SMTPUsername = getenv("SMTP_USERNAME")
+	c.SMTPPassword = getenv("SMTP_PASSWORD")
+
+	return envInt("SMTP_PRIORITY", &c.SMTPPriority)
+}
+
+// validateSMTP checks the SMTP listener: it needs a port of its own, and
+// credentials come in pairs
+func (c *Config) validateSMTP() error {
+	if !c.SMTPEnabled {
+		return nil
+	}
+
+	if c.SMTPPort == c.ServerPort || (c.AdminPort != "" && c.SMTPPort == c.AdminPort) {
+		return ConfigError("SMTP_PORT must differ from SERVER_PORT and ADMIN_PORT")
+	}
+	if (c.SMTPUsername == "") != (c.SMTPPassword == "") {
+		return ConfigError("SMTP_USERNAME and SMTP_PASSWORD must be set together")
+	}
+	return nil
+}
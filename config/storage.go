@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/apps"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
+)
+
+// loadStorage reads the storage backend and the file keeping the
+// applications when it is not durable
+func (c *Config) loadStorage() {
+	if backend := getenv("STORAGE_BACKEND"); backend != "" {
+		c.StorageBackend = backend
+	}
+	c.StorageURL = getenv("STORAGE_URL")
+
+	// Applications are kept next to the token unless set explicitly
+	c.ApplicationsFile = filepath.Join(filepath.Dir(c.JWTTokenFile), "applications.json")
+	if file := getenv("APPLICATIONS_FILE"); file != "" {
+		c.ApplicationsFile = file
+	}
+}
+
+// validateStorage checks that the storage backend is available in this
+// build
+func (c *Config) validateStorage() error {
+	for _, backend := range storage.Backends() {
+		if c.StorageBackend == backend {
+			return nil
+		}
+	}
+	return ConfigError(fmt.Sprintf("invalid value for STORAGE_BACKEND: %q is not available, expected one of %s", c.StorageBackend, strings.Join(storage.Backends(), ", ")))
+}
+
+// LoadApplications reads the applications stored in c, whose tokens are
+// accepted like those of APP_TOKENS
+func (c *Config) LoadApplications(store storage.Collection) error {
+	applications, err := apps.List(store)
+	if err != nil {
+		return err
+	}
+
+	c.Applications = applications
+	for _, app := range applications {
+		c.AppTokens = append(c.AppTokens, app.Token)
+	}
+	return nil
+}
+
+// ApplicationPriorities returns the default priorities of the applications
+// having one, keyed by token
+func (c *Config) ApplicationPriorities() map[string]int {
+	priorities := make(map[string]int)
+	for _, app := range c.Applications {
+		if app.DefaultPriority > 0 {
+			priorities[app.Token] = app.DefaultPriority
+		}
+	}
+	return priorities
+}
@@ -0,0 +1,84 @@
+package config
+
+import "strings"
+
+// loadSyslog reads the settings of the syslog listener forwarding log lines
+func (c *Config) loadSyslog() error {
+	if err := envBool("SYSLOG_ENABLED", &c.SyslogEnabled); err != nil {
+		return err
+	}
+
+	if port := getenv("SYSLOG_PORT"); port != "" {
+		c.SyslogPort = port
+	}
+
+	for name, dst := range map[string]*[]string{
+		"SYSLOG_PROTOCOLS":  &c.SyslogProtocols,
+		"SYSLOG_FACILITIES": &c.SyslogFacilities,
+	} {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		*dst = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+				*dst = append(*dst, item)
+			}
+		}
+	}
+
+	if threshold := getenv("SYSLOG_SEVERITY"); threshold != "" {
+		c.SyslogSeverity = strings.ToLower(threshold)
+	}
+
+	return envInt("SYSLOG_MAX_PER_MINUTE", &c.SyslogMaxPerMinute)
+}
+
+// validateSyslog checks the syslog listener: it takes UDP and TCP, and its
+// TCP port must not be taken by another listener. Severity and facility
+// names are checked by the listener.
+func (c *Config) validateSyslog() error {
+	if !c.SyslogEnabled {
+		return nil
+	}
+
+	if len(c.SyslogProtocols) == 0 {
+		return ConfigError("SYSLOG_PROTOCOLS must not be empty")
+	}
+	for _, protocol := range c.SyslogProtocols {
+		if protocol != "udp" && protocol != "tcp" {
+			return ConfigError("SYSLOG_PROTOCOLS may only list udp and tcp, got " + protocol)
+		}
+	}
+
+	if c.SyslogTCP() {
+		if c.SyslogPort == c.ServerPort || (c.AdminPort != "" && c.SyslogPort == c.AdminPort) || (c.SMTPEnabled && c.SyslogPort == c.SMTPPort) {
+			return ConfigError("SYSLOG_PORT must differ from SERVER_PORT, ADMIN_PORT and SMTP_PORT")
+		}
+	}
+	if c.SyslogMaxPerMinute < 0 {
+		return ConfigError("SYSLOG_MAX_PER_MINUTE must not be negative")
+	}
+	return nil
+}
+
+// SyslogUDP reports whether the syslog listener takes UDP
+func (c *Config) SyslogUDP() bool {
+	return c.syslogProtocol("udp")
+}
+
+// SyslogTCP reports whether the syslog listener takes TCP
+func (c *Config) SyslogTCP() bool {
+	return c.syslogProtocol("tcp")
+}
+
+// syslogProtocol reports whether SYSLOG_PROTOCOLS lists a protocol
+func (c *Config) syslogProtocol(name string) bool {
+	for _, protocol := range c.SyslogProtocols {
+		if protocol == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package config
+
+import "path/filepath"
+
+// loadUserDirectory reads the settings of the user directory
+func (c *Config) loadUserDirectory() error {
+	if err := envBool("USER_DIRECTORY_SYNC", &c.UserDirectorySync); err != nil {
+		return err
+	}
+
+	if err := envDuration("USER_DIRECTORY_INTERVAL", &c.UserDirectoryInterval); err != nil {
+		return err
+	}
+
+	// The directory is kept next to the token unless set explicitly
+	c.UserDirectoryFile = filepath.Join(filepath.Dir(c.JWTTokenFile), "users.json")
+	if file := getenv("USER_DIRECTORY_FILE"); file != "" {
+		c.UserDirectoryFile = file
+	}
+
+	if format := getenv("USER_MENTION_FORMAT"); format != "" {
+		c.UserMentionFormat = format
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Package correlation tracks the alerts currently firing across the
+// notifications of alerting systems, correlated by their fingerprint, so
+// they can be listed and acknowledged in one place.
+package correlation
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// StaleAfter is how long a firing alert is kept without being notified
+// again, for alerts whose resolution never arrived
+const StaleAfter = 24 * time.Hour
+
+// Alert is an alert that is firing
+type Alert struct {
+	Fingerprint string            `json:"fingerprint"`
+	Name        string            `json:"name"`
+	Route       string            `json:"route"`
+	Severity    string            `json:"severity,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	// Since is when the alert started firing
+	Since time.Time `json:"since"`
+
+	// LastSeen is when the alert was last notified
+	LastSeen time.Time `json:"last_seen"`
+
+	// Count is the number of notifications of the alert while firing
+	Count int `json:"count"`
+
+	AckedBy string     `json:"acked_by,omitempty"`
+	AckedAt *time.Time `json:"acked_at,omitempty"`
+}
+
+// Store holds the firing alerts in memory, by fingerprint
+type Store struct {
+	mutex  sync.Mutex
+	alerts map[string]*Alert
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{alerts: make(map[string]*Alert)}
+}
+
+// Fire records a notification of a firing alert. since is when the alerting
+// system saw it start, zero if unknown.
+func (s *Store) Fire(route, fingerprint string, labels map[string]string, since time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	alert, ok := s.alerts[fingerprint]
+	if !ok {
+		if since.IsZero() {
+			since = now
+		}
+		alert = &Alert{Fingerprint: fingerprint, Since: since}
+		s.alerts[fingerprint] = alert
+	}
+
+	alert.Name = labels["alertname"]
+	alert.Route = route
+	alert.Severity = labels["severity"]
+	alert.Labels = labels
+	alert.LastSeen = now
+	alert.Count++
+}
+
+// Resolve forgets a resolved alert
+func (s *Store) Resolve(fingerprint string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.alerts, fingerprint)
+}
+
+// Ack records who acknowledged a firing alert; ok is false for alerts that
+// are not firing
+func (s *Store) Ack(fingerprint, by string) (alert Alert, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.alerts[fingerprint]
+	if !ok {
+		return Alert{}, false
+	}
+
+	now := time.Now()
+	stored.AckedBy = by
+	stored.AckedAt = &now
+	return *stored, true
+}
+
+// Firing returns copies of the firing alerts, the longest firing first.
+// Alerts not notified within StaleAfter are dropped.
+func (s *Store) Firing() []Alert {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	alerts := make([]Alert, 0, len(s.alerts))
+	for fingerprint, alert := range s.alerts {
+		if time.Since(alert.LastSeen) > StaleAfter {
+			delete(s.alerts, fingerprint)
+			continue
+		}
+		alerts = append(alerts, *alert)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].Since.Equal(alerts[j].Since) {
+			return alerts[i].Since.Before(alerts[j].Since)
+		}
+		return alerts[i].Fingerprint < alerts[j].Fingerprint
+	})
+	return alerts
+}
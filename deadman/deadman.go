@@ -0,0 +1,202 @@
+// Package deadman records the runs of cron and batch jobs and announces jobs
+// that stopped reporting: a job configured with an expected interval that
+// has not reported a run within it is considered missed.
+package deadman
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+)
+
+// Batch job metrics, in the style of jobs pushed to a Prometheus Pushgateway
+var (
+	jobLastRun = metrics.NewGauge("batch_job_last_run_timestamp_seconds",
+		"Unix time of the last reported run of a batch job.", "job")
+	jobLastSuccess = metrics.NewGauge("batch_job_last_success_timestamp_seconds",
+		"Unix time of the last successful run of a batch job.", "job")
+	jobDuration = metrics.NewGauge("batch_job_duration_seconds",
+		"Duration of the last reported run of a batch job.", "job")
+	jobExitStatus = metrics.NewGauge("batch_job_exit_status",
+		"Exit status of the last reported run of a batch job.", "job")
+	jobRuns = metrics.NewCounter("batch_job_runs_total",
+		"Reported batch job runs by result.", "job", "result")
+	jobOverdue = metrics.NewGauge("batch_job_overdue",
+		"Whether a batch job has missed its expected interval (1) or not (0).", "job")
+	jobRunsUntracked = metrics.NewCounter("batch_job_runs_untracked_total",
+		"Reported runs of jobs not kept because DEADMAN_MAX_JOBS unlisted jobs are tracked.")
+)
+
+// Run is a reported run of a batch job
+type Run struct {
+	Job        string
+	ExitStatus int
+	Duration   time.Duration
+	Time       time.Time
+}
+
+// Succeeded reports whether the run exited with status 0
+func (r *Run) Succeeded() bool {
+	return r.ExitStatus == 0
+}
+
+// Switch keeps the last run of each job and announces missed runs
+type Switch struct {
+	config   *config.Config
+	messages *mizito.MessageService
+	logger   *logger.Logger
+
+	// started is the baseline of jobs that have not reported since the start
+	started time.Time
+
+	mutex   sync.Mutex
+	last    map[string]Run
+	overdue map[string]bool
+
+	// unlisted counts the tracked jobs outside DeadmanJobs
+	unlisted int
+}
+
+// New creates a dead man's switch for the jobs of DeadmanJobs
+func New(config *config.Config, messages *mizito.MessageService, logger *logger.Logger) *Switch {
+	s := &Switch{
+		config:   config,
+		messages: messages,
+		logger:   logger,
+		started:  time.Now(),
+		last:     make(map[string]Run),
+		overdue:  make(map[string]bool),
+	}
+	for job := range config.DeadmanJobs {
+		jobOverdue.Set(0, job)
+	}
+	return s
+}
+
+// Record stores a run of a job and updates its metrics. A run of a job that
+// was missed re-arms its switch. Jobs outside DeadmanJobs are tracked up to
+// DeadmanMaxJobs; Record reports false for runs of further jobs, which are
+// not kept.
+func (s *Switch) Record(run Run) bool {
+	_, listed := s.config.DeadmanJobs[run.Job]
+
+	s.mutex.Lock()
+	if _, known := s.last[run.Job]; !known && !listed {
+		if s.unlisted >= s.config.DeadmanMaxJobs {
+			s.mutex.Unlock()
+			jobRunsUntracked.Inc()
+			return false
+		}
+		s.unlisted++
+	}
+	s.last[run.Job] = run
+	wasOverdue := s.overdue[run.Job]
+	delete(s.overdue, run.Job)
+	s.mutex.Unlock()
+
+	result := "success"
+	if run.Succeeded() {
+		jobLastSuccess.Set(float64(run.Time.Unix()), run.Job)
+	} else {
+		result = "failure"
+	}
+	jobLastRun.Set(float64(run.Time.Unix()), run.Job)
+	jobDuration.Set(run.Duration.Seconds(), run.Job)
+	jobExitStatus.Set(float64(run.ExitStatus), run.Job)
+	jobRuns.Inc(run.Job, result)
+	if listed {
+		jobOverdue.Set(0, run.Job)
+	}
+
+	if wasOverdue {
+		s.logger.Info("Missed batch job reported again", "job", run.Job)
+	}
+	return true
+}
+
+// Last returns the last recorded run of a job
+func (s *Switch) Last(job string) (Run, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	run, ok := s.last[job]
+	return run, ok
+}
+
+// Run checks the jobs every DeadmanCheckInterval until ctx is cancelled
+func (s *Switch) Run(ctx context.Context) {
+	s.logger.Info("Dead man's switch started", "jobs", len(s.config.DeadmanJobs), "interval", s.config.DeadmanCheckInterval)
+	defer s.logger.Info("Dead man's switch stopped")
+
+	ticker := time.NewTicker(s.config.DeadmanCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.check(ctx, time.Now())
+	}
+}
+
+// check announces the jobs that became overdue. Each missed job is
+// announced once until it reports again.
+func (s *Switch) check(ctx context.Context, now time.Time) {
+	jobs := make([]string, 0, len(s.config.DeadmanJobs))
+	for job := range s.config.DeadmanJobs {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+
+	for _, job := range jobs {
+		interval := s.config.DeadmanJobs[job]
+
+		s.mutex.Lock()
+		run, reported := s.last[job]
+		since := s.started
+		if reported {
+			since = run.Time
+		}
+		missed := now.Sub(since) > interval && !s.overdue[job]
+		if missed {
+			s.overdue[job] = true
+		}
+		s.mutex.Unlock()
+
+		if !missed {
+			continue
+		}
+
+		jobOverdue.Set(1, job)
+		s.logger.Warn("Batch job missed its expected run", "job", job, "interval", interval, "reported", reported)
+
+		text := fmt.Sprintf("⏰ %s has not reported a run for %s\n", job, interval)
+		if reported {
+			text += fmt.Sprintf("Last run: %s, exit status %d", run.Time.Format(time.RFC3339), run.ExitStatus)
+		} else {
+			text += fmt.Sprintf("No run reported since %s", s.started.Format(time.RFC3339))
+		}
+
+		if err := s.messages.Send(ctx, &mizito.Message{
+			Text:     text,
+			Priority: s.config.DeadmanPriority,
+			DialogID: s.messages.DialogForPriority(s.config.DeadmanPriority),
+		}); err != nil {
+			s.logger.Error("Failed to announce missed batch job", "job", job, "error", err)
+			// Retry on the next check
+			s.mutex.Lock()
+			delete(s.overdue, job)
+			s.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package dedup recognizes notifications repeating one seen shortly before,
+// so alert storms reach the chat once instead of flooding it.
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
+)
+
+// Store counts the notifications seen per key within their window. The
+// counts are kept in counters, which instances sharing a durable backend
+// share; the instance that opened a window closes it.
+type Store struct {
+	counters storage.Counters
+
+	mutex   sync.Mutex
+	windows map[string]*window
+}
+
+// window is a window opened by this instance
+type window struct {
+	timer  *time.Timer
+	closed func(count int)
+}
+
+// NewStore creates a store counting in counters
+func NewStore(counters storage.Counters) *Store {
+	return &Store{counters: counters, windows: make(map[string]*window)}
+}
+
+// Seen records a notification and returns how often its key was seen in
+// the current window, 1 for the first. The window opens with the first
+// notification and is not extended by duplicates. When it closes, closed is
+// called with the final count if the key was seen more than once. A
+// notification that cannot be counted is seen as the first, so it is not
+// lost.
+func (s *Store) Seen(key string, window time.Duration, closed func(count int)) int {
+	count, err := s.counters.Increment(key, window)
+	if err != nil {
+		return 1
+	}
+	if count == 1 {
+		s.open(key, window, closed)
+	}
+	return count
+}
+
+// open closes the window of a key after its duration
+func (s *Store) open(key string, duration time.Duration, closed func(count int)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	w := &window{closed: closed}
+	w.timer = time.AfterFunc(duration, func() {
+		s.mutex.Lock()
+		delete(s.windows, key)
+		s.mutex.Unlock()
+		s.close(key, w)
+	})
+	s.windows[key] = w
+}
+
+// close takes the count of a key and calls the callback of its window if
+// the key was seen more than once
+func (s *Store) close(key string, w *window) {
+	count, err := s.counters.Take(key)
+	if err == nil && count > 1 && w.closed != nil {
+		w.closed(count)
+	}
+}
+
+// Stop closes all windows early on shutdown, so the counts of duplicates
+// are not lost
+func (s *Store) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	open := make(map[string]*window)
+	for key, w := range s.windows {
+		if w.timer.Stop() {
+			open[key] = w
+		}
+		delete(s.windows, key)
+	}
+	s.mutex.Unlock()
+
+	for key, w := range open {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.close(key, w)
+	}
+	return nil
+}
+
+// Key derives a key from the parts identifying a notification
+func Key(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
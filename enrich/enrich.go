@@ -0,0 +1,243 @@
+// Package enrich annotates IP addresses in outgoing messages with their
+// reverse DNS name and GeoIP country, e.g. "203.0.113.7 [mail.example.com, DE]",
+// so firewall and syslog alerts can be read without looking addresses up.
+package enrich
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/geoip"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// maxAddresses caps the addresses looked up per message
+const maxAddresses = 16
+
+// Lookup results are cached for cacheTTL, up to maxCacheEntries addresses
+const (
+	cacheTTL        = 10 * time.Minute
+	maxCacheEntries = 4096
+)
+
+// addressPattern matches IPv4 addresses and candidate IPv6 addresses, which
+// are validated with net.ParseIP
+var addressPattern = regexp.MustCompile(`\d{1,3}(?:\.\d{1,3}){3}|[0-9A-Fa-f]{0,4}:[0-9A-Fa-f:.]*:[0-9A-Fa-f.]*`)
+
+// Config holds the enrichment settings
+type Config struct {
+	// ReverseDNS looks up the host name of addresses
+	ReverseDNS bool
+
+	// GeoIPDatabase is the path of a MaxMind DB file with country data;
+	// countries are not looked up when empty
+	GeoIPDatabase string
+
+	// Timeout bounds the lookups of a message
+	Timeout time.Duration
+}
+
+// Enricher annotates the addresses of messages
+type Enricher struct {
+	config   Config
+	geo      *geoip.Reader
+	resolver *net.Resolver
+	logger   *logger.Logger
+
+	mutex sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// cacheEntry is the cached annotation of an address
+type cacheEntry struct {
+	annotation string
+	expires    time.Time
+}
+
+// New creates an enricher, loading the GeoIP database when configured
+func New(config Config, logger *logger.Logger) (*Enricher, error) {
+	e := &Enricher{
+		config:   config,
+		resolver: net.DefaultResolver,
+		logger:   logger,
+		cache:    make(map[string]cacheEntry),
+	}
+
+	if config.GeoIPDatabase != "" {
+		geo, err := geoip.Open(config.GeoIPDatabase)
+		if err != nil {
+			return nil, err
+		}
+		e.geo = geo
+		logger.Info("GeoIP database loaded", "file", config.GeoIPDatabase, "type", geo.DatabaseType)
+	}
+
+	return e, nil
+}
+
+// Enrich appends the host name and country of each address in text, in
+// brackets after the address. Addresses without any information are left
+// as they are.
+func (e *Enricher) Enrich(ctx context.Context, text string) string {
+	matches := findAddresses(text)
+	if len(matches) == 0 {
+		return text
+	}
+
+	// Look the distinct addresses up concurrently, each into its own slot
+	var addresses []string
+	seen := make(map[string]bool)
+	for _, m := range matches {
+		if len(addresses) == maxAddresses {
+			break
+		}
+		if address := text[m[0]:m[1]]; !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	results := make([]string, len(addresses))
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = e.annotate(ctx, address)
+		}()
+	}
+	wg.Wait()
+
+	annotations := make(map[string]string, len(addresses))
+	for i, address := range addresses {
+		annotations[address] = results[i]
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		annotation := annotations[text[m[0]:m[1]]]
+		end := skipPort(text, m[1])
+		// Skip addresses annotated before, e.g. by a previous forwarder
+		if annotation == "" || strings.HasPrefix(text[end:], " [") {
+			continue
+		}
+		b.WriteString(text[last:end])
+		b.WriteString(" [" + annotation + "]")
+		last = end
+	}
+	b.WriteString(text[last:])
+
+	return b.String()
+}
+
+// annotate returns the annotation of an address, from the cache when
+// possible
+func (e *Enricher) annotate(ctx context.Context, address string) string {
+	e.mutex.Lock()
+	entry, ok := e.cache[address]
+	e.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.annotation
+	}
+
+	ip := net.ParseIP(address)
+	var parts []string
+
+	if e.config.ReverseDNS {
+		names, err := e.resolver.LookupAddr(ctx, address)
+		if err == nil && len(names) > 0 {
+			parts = append(parts, strings.TrimSuffix(names[0], "."))
+		} else if err != nil && ctx.Err() != nil {
+			// Timed out: do not cache the missing name
+			e.logger.Debug("Reverse DNS lookup timed out", "address", address)
+			return strings.Join(append(parts, e.country(ip)...), ", ")
+		}
+	}
+
+	parts = append(parts, e.country(ip)...)
+	annotation := strings.Join(parts, ", ")
+
+	e.mutex.Lock()
+	if len(e.cache) >= maxCacheEntries {
+		e.cache = make(map[string]cacheEntry)
+	}
+	e.cache[address] = cacheEntry{annotation: annotation, expires: time.Now().Add(cacheTTL)}
+	e.mutex.Unlock()
+
+	return annotation
+}
+
+// country returns the country code of a public address as a one-element
+// slice, or nil when unknown
+func (e *Enricher) country(ip net.IP) []string {
+	if e.geo == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return nil
+	}
+
+	code, err := e.geo.Country(ip)
+	if err != nil {
+		e.logger.Warn("GeoIP lookup failed", "address", ip.String(), "error", err)
+		return nil
+	}
+	if code == "" {
+		return nil
+	}
+	return []string{code}
+}
+
+// findAddresses returns the positions of the IP addresses in text worth
+// annotating. Matches that are part of a longer token, such as a version
+// number, and unspecified, loopback and multicast addresses are skipped.
+func findAddresses(text string) [][]int {
+	var found [][]int
+	for _, m := range addressPattern.FindAllStringIndex(text, -1) {
+		if m[0] > 0 && isAddressChar(text[m[0]-1]) {
+			continue
+		}
+		if m[1] < len(text) && (isAddressChar(text[m[1]]) ||
+			text[m[1]] == '.' && m[1]+1 < len(text) && isDigit(text[m[1]+1])) {
+			continue
+		}
+
+		ip := net.ParseIP(text[m[0]:m[1]])
+		if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() {
+			continue
+		}
+		found = append(found, m)
+	}
+	return found
+}
+
+// skipPort returns the end of the ":port" following an address at i, or i
+func skipPort(text string, i int) int {
+	if i >= len(text) || text[i] != ':' {
+		return i
+	}
+	end := i + 1
+	for end < len(text) && isDigit(text[end]) {
+		end++
+	}
+	if end == i+1 {
+		return i
+	}
+	return end
+}
+
+// isAddressChar reports whether c belongs to a word or number around a
+// match; a colon is not one, so ports such as 203.0.113.7:443 are kept
+func isAddressChar(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+// isDigit reports whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,119 @@
+// Package eventlog forwards events of the Windows Event Log. It subscribes to
+// the configured channels, such as Application and System, and submits the
+// events of the selected levels as notifications. The subscription is only
+// available on Windows; elsewhere New fails with ErrUnsupported.
+package eventlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+)
+
+// RouteName is the route of forwarded events, e.g. for ROUTE_EVENTLOG_TEMPLATE
+const RouteName = "eventlog"
+
+// ErrUnsupported is returned by New on systems without the Windows Event Log
+var ErrUnsupported = errors.New("the Windows Event Log is only available on Windows")
+
+// Submitter delivers a notification, like handler.Handler.Submit
+type Submitter func(ctx context.Context, n *render.Notification) error
+
+// level describes a Windows event level
+type level struct {
+	value  int
+	name   string
+	icon   string
+	syslog string
+}
+
+// levels maps level names to the Windows event levels. Their priorities
+// are those of the corresponding syslog levels.
+var levels = map[string]level{
+	"critical":    {value: 1, name: "Critical", icon: "🔴", syslog: "crit"},
+	"error":       {value: 2, name: "Error", icon: "🟠", syslog: "err"},
+	"warning":     {value: 3, name: "Warning", icon: "🟡", syslog: "warning"},
+	"information": {value: 4, name: "Information", icon: "🔵", syslog: "info"},
+	"verbose":     {value: 5, name: "Verbose", icon: "⚪", syslog: "debug"},
+}
+
+// levelOf returns the level of an event; level 0 (LogAlways) is shown as
+// information
+func levelOf(value int) level {
+	for _, l := range levels {
+		if l.value == value {
+			return l
+		}
+	}
+	return levels["information"]
+}
+
+// Event is an event read from the Event Log
+type Event struct {
+	Channel  string
+	Provider string
+	EventID  int
+	Level    int
+	Time     time.Time
+	Computer string
+	Message  string
+}
+
+// notification converts an event into a notification of RouteName
+func (e *Event) notification() *render.Notification {
+	l := levelOf(e.Level)
+	return &render.Notification{
+		Route:    RouteName,
+		Title:    fmt.Sprintf("%s %s %d on %s", l.icon, e.Provider, e.EventID, e.Computer),
+		Message:  e.Message,
+		Priority: severity.Default.Priority(severity.Syslog, l.syslog, 0),
+		Time:     e.Time,
+		Source:   RouteName,
+		Extras: map[string]interface{}{
+			"channel":  e.Channel,
+			"provider": e.Provider,
+			"eventId":  e.EventID,
+			"level":    strings.ToLower(l.name),
+			"computer": e.Computer,
+		},
+	}
+}
+
+// checkLevels validates level names such as "critical" and "error"
+func checkLevels(names []string) error {
+	for _, name := range names {
+		if _, ok := levels[name]; !ok {
+			return fmt.Errorf("unknown level %q, expected one of %s", name, strings.Join(levelNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// levelNames returns the names of the event levels, most severe first
+func levelNames() []string {
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return levels[names[i]].value < levels[names[j]].value })
+	return names
+}
+
+// query returns the XPath query selecting events of the given levels
+func query(names []string) string {
+	conditions := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		conditions = append(conditions, fmt.Sprintf("Level=%d", levels[name].value))
+		// Events logged with LogAlways count as information
+		if name == "information" {
+			conditions = append(conditions, "Level=0")
+		}
+	}
+	return fmt.Sprintf("*[System[(%s)]]", strings.Join(conditions, " or "))
+}
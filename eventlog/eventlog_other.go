@@ -0,0 +1,21 @@
+//go:build !windows
+
+package eventlog
+
+import (
+	"context"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// Input subscribes to the Windows Event Log
+type Input struct{}
+
+// New fails on systems without the Windows Event Log
+func New(config *config.Config, submit Submitter, logger *logger.Logger) (*Input, error) {
+	return nil, ErrUnsupported
+}
+
+// Run returns immediately
+func (in *Input) Run(ctx context.Context) {}
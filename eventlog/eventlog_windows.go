@@ -0,0 +1,295 @@
+//go:build windows
+
+package eventlog
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// Windows Event Log API (wevtapi.dll)
+var (
+	wevtapi                      = windows.NewLazySystemDLL("wevtapi.dll")
+	procEvtSubscribe             = wevtapi.NewProc("EvtSubscribe")
+	procEvtNext                  = wevtapi.NewProc("EvtNext")
+	procEvtRender                = wevtapi.NewProc("EvtRender")
+	procEvtFormatMessage         = wevtapi.NewProc("EvtFormatMessage")
+	procEvtOpenPublisherMetadata = wevtapi.NewProc("EvtOpenPublisherMetadata")
+	procEvtClose                 = wevtapi.NewProc("EvtClose")
+)
+
+// Event Log API flags and errors
+const (
+	evtSubscribeToFutureEvents = 1
+	evtRenderEventXML          = 1
+	evtFormatMessageEvent      = 1
+
+	errorEvtUnresolvedValueInsert = windows.Errno(15029)
+	errorEvtMessageNotFound       = windows.Errno(15027)
+)
+
+// batchSize is the number of events read at once
+const batchSize = 16
+
+// pollInterval bounds how long a subscription waits before checking for shutdown
+const pollInterval = time.Second
+
+// Input subscribes to the Windows Event Log
+type Input struct {
+	channels []string
+	query    string
+	submit   Submitter
+	logger   *logger.Logger
+
+	// publishers caches the message tables of event providers
+	mutex      sync.Mutex
+	publishers map[string]uintptr
+}
+
+// New creates an input for the configured channels and levels
+func New(config *config.Config, submit Submitter, logger *logger.Logger) (*Input, error) {
+	if err := checkLevels(config.EventLogLevels); err != nil {
+		return nil, fmt.Errorf("EVENTLOG_LEVELS: %w", err)
+	}
+	if err := wevtapi.Load(); err != nil {
+		return nil, err
+	}
+
+	return &Input{
+		channels:   config.EventLogChannels,
+		query:      query(config.EventLogLevels),
+		submit:     submit,
+		logger:     logger,
+		publishers: make(map[string]uintptr),
+	}, nil
+}
+
+// Run forwards new events of all channels until ctx is cancelled
+func (in *Input) Run(ctx context.Context) {
+	in.logger.Info("Event Log input started", "channels", strings.Join(in.channels, ","), "query", in.query)
+	defer in.logger.Info("Event Log input stopped")
+
+	var wg sync.WaitGroup
+	for _, channel := range in.channels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := in.subscribe(ctx, channel); err != nil {
+				in.logger.Error("Event Log subscription failed", "channel", channel, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	in.mutex.Lock()
+	for _, h := range in.publishers {
+		evtClose(h)
+	}
+	in.mutex.Unlock()
+}
+
+// subscribe reads the events of a channel as they are logged
+func (in *Input) subscribe(ctx context.Context, channel string) error {
+	signal, err := windows.CreateEvent(nil, 1, 1, nil)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(signal)
+
+	channelPtr, err := windows.UTF16PtrFromString(channel)
+	if err != nil {
+		return err
+	}
+	queryPtr, err := windows.UTF16PtrFromString(in.query)
+	if err != nil {
+		return err
+	}
+
+	subscription, _, err := procEvtSubscribe.Call(0, uintptr(signal),
+		uintptr(unsafe.Pointer(channelPtr)), uintptr(unsafe.Pointer(queryPtr)),
+		0, 0, 0, evtSubscribeToFutureEvents)
+	if subscription == 0 {
+		return err
+	}
+	defer evtClose(subscription)
+
+	for ctx.Err() == nil {
+		event, err := windows.WaitForSingleObject(signal, uint32(pollInterval/time.Millisecond))
+		if err != nil {
+			return err
+		}
+		if event != windows.WAIT_OBJECT_0 {
+			continue
+		}
+
+		if err := in.drain(ctx, channel, subscription); err != nil {
+			return err
+		}
+		windows.ResetEvent(signal)
+	}
+
+	return nil
+}
+
+// drain submits the pending events of a subscription
+func (in *Input) drain(ctx context.Context, channel string, subscription uintptr) error {
+	handles := make([]uintptr, batchSize)
+	for {
+		var returned uint32
+		ok, _, err := procEvtNext.Call(subscription, batchSize, uintptr(unsafe.Pointer(&handles[0])),
+			0, 0, uintptr(unsafe.Pointer(&returned)))
+		if ok == 0 {
+			if errors.Is(err, windows.ERROR_NO_MORE_ITEMS) {
+				return nil
+			}
+			return err
+		}
+
+		for _, h := range handles[:returned] {
+			event, err := in.read(h)
+			evtClose(h)
+			if err != nil {
+				in.logger.Warn("Failed to read event", "channel", channel, "error", err)
+				continue
+			}
+			if event.Channel == "" {
+				event.Channel = channel
+			}
+
+			if err := in.submit(ctx, event.notification()); err != nil {
+				in.logger.Error("Failed to forward event", "channel", channel, "provider", event.Provider, "event_id", event.EventID, "error", err)
+			}
+		}
+	}
+}
+
+// systemXML holds the System section of an event rendered as XML
+type systemXML struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID     int `xml:"EventID"`
+		Level       int `xml:"Level"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		Channel  string `xml:"Channel"`
+		Computer string `xml:"Computer"`
+	} `xml:"System"`
+	EventData struct {
+		Data []string `xml:"Data"`
+	} `xml:"EventData"`
+}
+
+// read renders an event and formats its message
+func (in *Input) read(h uintptr) (*Event, error) {
+	raw, err := evtRender(h)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc systemXML
+	if err := xml.Unmarshal([]byte(raw), &doc); err != nil {
+		return nil, err
+	}
+
+	event := &Event{
+		Channel:  doc.System.Channel,
+		Provider: doc.System.Provider.Name,
+		EventID:  doc.System.EventID,
+		Level:    doc.System.Level,
+		Computer: doc.System.Computer,
+		Time:     time.Now(),
+	}
+	if t, err := time.Parse(time.RFC3339Nano, doc.System.TimeCreated.SystemTime); err == nil {
+		event.Time = t
+	}
+
+	// Without the provider's message table, show the event data instead
+	event.Message, err = in.format(h, event.Provider)
+	if err != nil || event.Message == "" {
+		event.Message = strings.Join(doc.EventData.Data, "\n")
+	}
+	event.Message = strings.TrimSpace(event.Message)
+
+	return event, nil
+}
+
+// format returns the message of an event from its provider's message table
+func (in *Input) format(h uintptr, provider string) (string, error) {
+	publisher, err := in.publisher(provider)
+	if err != nil {
+		return "", err
+	}
+
+	var used uint32
+	procEvtFormatMessage.Call(publisher, h, 0, 0, 0, evtFormatMessageEvent, 0, 0, uintptr(unsafe.Pointer(&used)))
+	if used == 0 {
+		return "", errorEvtMessageNotFound
+	}
+
+	buf := make([]uint16, used)
+	ok, _, err := procEvtFormatMessage.Call(publisher, h, 0, 0, 0, evtFormatMessageEvent,
+		uintptr(used), uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&used)))
+	// Messages with unresolved inserts are still useful
+	if ok == 0 && !errors.Is(err, errorEvtUnresolvedValueInsert) {
+		return "", err
+	}
+	return windows.UTF16ToString(buf), nil
+}
+
+// publisher returns the cached metadata handle of an event provider
+func (in *Input) publisher(provider string) (uintptr, error) {
+	in.mutex.Lock()
+	defer in.mutex.Unlock()
+
+	if h, ok := in.publishers[provider]; ok {
+		return h, nil
+	}
+
+	providerPtr, err := windows.UTF16PtrFromString(provider)
+	if err != nil {
+		return 0, err
+	}
+	h, _, err := procEvtOpenPublisherMetadata.Call(0, uintptr(unsafe.Pointer(providerPtr)), 0, 0, 0)
+	if h == 0 {
+		return 0, err
+	}
+	in.publishers[provider] = h
+	return h, nil
+}
+
+// evtRender renders an event as XML
+func evtRender(h uintptr) (string, error) {
+	var used, count uint32
+	procEvtRender.Call(0, h, evtRenderEventXML, 0, 0, uintptr(unsafe.Pointer(&used)), uintptr(unsafe.Pointer(&count)))
+	if used == 0 {
+		return "", errors.New("empty event")
+	}
+
+	// The buffer size is given in bytes
+	buf := make([]uint16, (used+1)/2)
+	ok, _, err := procEvtRender.Call(0, h, evtRenderEventXML, uintptr(used),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&used)), uintptr(unsafe.Pointer(&count)))
+	if ok == 0 {
+		return "", err
+	}
+	return windows.UTF16ToString(buf), nil
+}
+
+// evtClose releases an Event Log handle
+func evtClose(h uintptr) {
+	procEvtClose.Call(h)
+}
@@ -0,0 +1,376 @@
+// Package geoip looks up the country of IP addresses in a local MaxMind DB
+// (MMDB) file, such as GeoLite2-Country, GeoLite2-City or DB-IP Lite.
+//
+// Only the parts of the MaxMind DB format needed for lookups are
+// implemented: the binary search tree with 24, 28 and 32 bit records and the
+// data section types.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker starts the metadata section at the end of the file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// dataSectionSeparator is the size of the zero bytes between the search
+// tree and the data section
+const dataSectionSeparator = 16
+
+// maxDepth limits the nesting of decoded data, guarding against corrupt files
+const maxDepth = 32
+
+// ErrInvalidDatabase is returned for files that are not valid MaxMind DBs
+var ErrInvalidDatabase = errors.New("invalid MaxMind DB")
+
+// Data section types
+const (
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEnd       = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// Reader looks up addresses in a MaxMind DB loaded into memory
+type Reader struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+
+	// DatabaseType is the type named in the metadata, e.g. "GeoLite2-Country"
+	DatabaseType string
+
+	// ipv4Start is the node of ::/96 in IPv6 trees, where IPv4 lookups start
+	ipv4Start uint
+}
+
+// Open reads a MaxMind DB file
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(buf)
+}
+
+// New parses a MaxMind DB from its contents
+func New(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start < 0 {
+		return nil, fmt.Errorf("%w: metadata not found", ErrInvalidDatabase)
+	}
+
+	meta := decoder{buf: buf[start+len(metadataMarker):]}
+	value, _, err := meta.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	r := &Reader{
+		nodeCount:  uintField(metadata, "node_count"),
+		recordSize: uintField(metadata, "record_size"),
+		ipVersion:  uintField(metadata, "ip_version"),
+	}
+	r.DatabaseType, _ = metadata["database_type"].(string)
+
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported IP version %d", ErrInvalidDatabase, r.ipVersion)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(start) {
+		return nil, fmt.Errorf("%w: search tree exceeds file", ErrInvalidDatabase)
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+dataSectionSeparator : start]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// Lookup returns the data record of the network containing ip, or nil when
+// the address is not in the database
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	bits := ip.To4()
+	if bits != nil && r.ipVersion == 6 {
+		node = r.ipv4Start
+	} else if bits == nil {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		bits = ip.To16()
+		if bits == nil {
+			return nil, fmt.Errorf("invalid IP address")
+		}
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+
+	switch {
+	case node == r.nodeCount:
+		return nil, nil
+	case node < r.nodeCount:
+		return nil, fmt.Errorf("%w: search tree too deep", ErrInvalidDatabase)
+	}
+
+	if node-r.nodeCount < dataSectionSeparator {
+		return nil, fmt.Errorf("%w: record points into the separator", ErrInvalidDatabase)
+	}
+	offset := node - r.nodeCount - dataSectionSeparator
+	d := decoder{buf: r.data}
+	value, _, err := d.decode(offset, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	return value, nil
+}
+
+// Country returns the ISO 3166-1 code of the country of ip, falling back to
+// the country the network is registered in. It is empty when unknown.
+func (r *Reader) Country(ip net.IP) (string, error) {
+	record, err := r.Lookup(ip)
+	if err != nil {
+		return "", err
+	}
+
+	fields, _ := record.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := fields[key].(map[string]interface{})
+		if code, _ := country["iso_code"].(string); code != "" {
+			return code, nil
+		}
+	}
+	return "", nil
+}
+
+// record reads the left (0) or right (1) record of a search tree node; the
+// caller makes sure node is within the tree
+func (r *Reader) record(node, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.tree[node*8+bit*4:]))
+	}
+}
+
+// uintField returns an unsigned integer field of the metadata
+func uintField(m map[string]interface{}, key string) uint {
+	v, _ := m[key].(uint64)
+	return uint(v)
+}
+
+// decoder decodes values of a data section
+type decoder struct {
+	buf []byte
+}
+
+// errTruncated is returned for values extending past the data section
+var errTruncated = errors.New("unexpected end of data")
+
+// decode decodes the value at offset, returning it and the offset following it
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+
+	kind, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if kind == typePointer {
+		value, _, err := d.decode(size, depth+1)
+		return value, offset, err
+	}
+
+	end := offset + size
+	switch kind {
+	case typeMap:
+		m := make(map[string]interface{}, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			if key, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEnd:
+		return nil, offset, nil
+	}
+
+	if end > uint(len(d.buf)) || end < offset {
+		return nil, 0, errTruncated
+	}
+	b := d.buf[offset:end]
+
+	switch kind {
+	case typeString:
+		return string(b), end, nil
+	case typeBytes:
+		return append([]byte(nil), b...), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), end, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, end, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errors.New("invalid integer size")
+		}
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), end, nil
+	case typeUint128:
+		// Too large for an integer type, kept as big-endian bytes
+		return append([]byte(nil), b...), end, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown data type %d", kind)
+}
+
+// control reads the control byte of the value at offset and returns its
+// type, its size (the target offset for pointers) and the offset of its payload
+func (d *decoder) control(offset uint) (kind, size, next uint, err error) {
+	read := func(n uint) ([]byte, error) {
+		if offset+n > uint(len(d.buf)) {
+			return nil, errTruncated
+		}
+		b := d.buf[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	b, err := read(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	ctrl := uint(b[0])
+	kind = ctrl >> 5
+
+	if kind == typePointer {
+		n := (ctrl>>3)&0x3 + 1
+		b, err := read(n)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		v := uint(0)
+		if n < 4 {
+			v = ctrl & 0x7
+		}
+		for _, c := range b {
+			v = v<<8 | uint(c)
+		}
+		switch n {
+		case 2:
+			v += 2048
+		case 3:
+			v += 526336
+		}
+		return typePointer, v, offset, nil
+	}
+
+	if kind == 0 {
+		b, err := read(1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		kind = 7 + uint(b[0])
+	}
+
+	size = ctrl & 0x1f
+	if size >= 29 {
+		n := size - 28
+		b, err := read(n)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		v := uint(0)
+		for _, c := range b {
+			v = v<<8 | uint(c)
+		}
+		size = [...]uint{29, 285, 65821}[n-1] + v
+	}
+
+	return kind, size, offset, nil
+}
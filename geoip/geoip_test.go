@@ -0,0 +1,425 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// The tests build their MaxMind DB files in memory with mmdbWriter, which
+// implements the writing side of the parts of the format the reader supports
+
+// pointerTo marks a data value written as a pointer to the value of another
+// network, which must be written first
+type pointerTo string
+
+// trieNode is a node of the search tree being built; leaves hold the data
+// offset of their network
+type trieNode struct {
+	children [2]*trieNode
+	leaf     bool
+	offset   uint
+}
+
+// mmdbWriter builds a MaxMind DB
+type mmdbWriter struct {
+	ipVersion  uint
+	recordSize uint
+	root       trieNode
+	data       bytes.Buffer
+	offsets    map[string]uint
+}
+
+func newWriter(ipVersion, recordSize uint) *mmdbWriter {
+	return &mmdbWriter{ipVersion: ipVersion, recordSize: recordSize, offsets: make(map[string]uint)}
+}
+
+// insert adds a network with its data; IPv4 networks of IPv6 databases are
+// stored under ::/96, where the reader looks IPv4 addresses up
+func (w *mmdbWriter) insert(t *testing.T, cidr string, value interface{}) {
+	t.Helper()
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ones, _ := network.Mask.Size()
+	bits := []byte(network.IP)
+	if w.ipVersion == 6 {
+		if ip.To4() != nil {
+			ones += 96
+			bits = append(make([]byte, 12), network.IP.To4()...)
+		} else {
+			bits = network.IP.To16()
+		}
+	}
+
+	offset := uint(w.data.Len())
+	if p, ok := value.(pointerTo); ok {
+		encodePointer(&w.data, w.offsets[string(p)])
+	} else {
+		encode(&w.data, value)
+	}
+	w.offsets[cidr] = offset
+
+	node := &w.root
+	for i := 0; i < ones; i++ {
+		bit := bits[i/8] >> (7 - uint(i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &trieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.leaf, node.offset = true, offset
+}
+
+// bytes returns the database: search tree, separator, data and metadata
+func (w *mmdbWriter) bytes(metadata map[string]interface{}) []byte {
+	// Number the inner nodes breadth first, the root being 0
+	var nodes []*trieNode
+	numbers := make(map[*trieNode]uint)
+	for queue := []*trieNode{&w.root}; len(queue) > 0; queue = queue[1:] {
+		node := queue[0]
+		numbers[node] = uint(len(nodes))
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil && !child.leaf {
+				queue = append(queue, child)
+			}
+		}
+	}
+	nodeCount := uint(len(nodes))
+
+	record := func(child *trieNode) uint {
+		switch {
+		case child == nil:
+			return nodeCount
+		case child.leaf:
+			return nodeCount + dataSectionSeparator + child.offset
+		default:
+			return numbers[child]
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		left, right := record(node.children[0]), record(node.children[1])
+		switch w.recordSize {
+		case 24:
+			buf.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			buf.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left),
+				byte(left>>24)<<4 | byte(right>>24)&0x0f,
+				byte(right >> 16), byte(right >> 8), byte(right)})
+		case 32:
+			binary.Write(&buf, binary.BigEndian, [2]uint32{uint32(left), uint32(right)})
+		}
+	}
+	buf.Write(make([]byte, dataSectionSeparator))
+	buf.Write(w.data.Bytes())
+
+	meta := map[string]interface{}{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint16(w.recordSize),
+		"ip_version":    uint16(w.ipVersion),
+		"database_type": "Test-Country",
+	}
+	for key, value := range metadata {
+		meta[key] = value
+	}
+	buf.Write(metadataMarker)
+	encode(&buf, meta)
+	return buf.Bytes()
+}
+
+// encode writes a value of the data section
+func encode(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		writeControl(buf, typeString, uint(len(v)))
+		buf.WriteString(v)
+	case []byte:
+		writeControl(buf, typeBytes, uint(len(v)))
+		buf.Write(v)
+	case float64:
+		writeControl(buf, typeDouble, 8)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case float32:
+		writeControl(buf, typeFloat, 4)
+		binary.Write(buf, binary.BigEndian, math.Float32bits(v))
+	case uint16:
+		writeUint(buf, typeUint16, uint64(v))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint64:
+		writeUint(buf, typeUint64, v)
+	case int32:
+		writeControl(buf, typeInt32, 4)
+		binary.Write(buf, binary.BigEndian, v)
+	case bool:
+		size := uint(0)
+		if v {
+			size = 1
+		}
+		writeControl(buf, typeBool, size)
+	case []interface{}:
+		writeControl(buf, typeArray, uint(len(v)))
+		for _, item := range v {
+			encode(buf, item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeControl(buf, typeMap, uint(len(v)))
+		for _, key := range keys {
+			encode(buf, key)
+			encode(buf, v[key])
+		}
+	default:
+		panic("unsupported test value")
+	}
+}
+
+// writeUint writes an unsigned integer in as few bytes as possible
+func writeUint(buf *bytes.Buffer, kind uint, v uint64) {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	writeControl(buf, kind, uint(len(b)))
+	buf.Write(b)
+}
+
+// writeControl writes the control byte of a value with sizes up to 284
+func writeControl(buf *bytes.Buffer, kind, size uint) {
+	ctrl := byte(0)
+	if kind <= 7 {
+		ctrl = byte(kind << 5)
+	}
+	if size < 29 {
+		ctrl |= byte(size)
+	} else {
+		ctrl |= 29
+	}
+	buf.WriteByte(ctrl)
+	if kind > 7 {
+		buf.WriteByte(byte(kind - 7))
+	}
+	if size >= 29 {
+		buf.WriteByte(byte(size - 29))
+	}
+}
+
+// encodePointer writes a pointer to offset with a 1 or 2 byte payload
+func encodePointer(buf *bytes.Buffer, offset uint) {
+	if offset < 2048 {
+		buf.Write([]byte{byte(typePointer<<5 | offset>>8), byte(offset)})
+		return
+	}
+	offset -= 2048
+	buf.Write([]byte{byte(typePointer<<5 | 1<<3 | offset>>16), byte(offset >> 8), byte(offset)})
+}
+
+// country is the data record of a network in a country database
+func country(code string) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{
+			"iso_code":   code,
+			"geoname_id": uint32(2921044),
+			"names":      map[string]interface{}{"en": "Country " + code},
+		},
+	}
+}
+
+func TestCountry(t *testing.T) {
+	for _, ipVersion := range []uint{4, 6} {
+		for _, recordSize := range []uint{24, 28, 32} {
+			w := newWriter(ipVersion, recordSize)
+			w.insert(t, "203.0.113.0/24", country("DE"))
+			w.insert(t, "198.51.100.0/25", map[string]interface{}{
+				"registered_country": map[string]interface{}{"iso_code": "US"},
+			})
+			w.insert(t, "198.51.100.128/25", pointerTo("203.0.113.0/24"))
+			if ipVersion == 6 {
+				w.insert(t, "2001:db8::/32", country("FR"))
+			}
+
+			r, err := New(w.bytes(nil))
+			if err != nil {
+				t.Fatalf("IPv%d, %d bit records: %v", ipVersion, recordSize, err)
+			}
+			if r.DatabaseType != "Test-Country" {
+				t.Errorf("DatabaseType = %q, want Test-Country", r.DatabaseType)
+			}
+
+			want := map[string]string{
+				"203.0.113.7":    "DE",
+				"203.0.113.255":  "DE",
+				"198.51.100.1":   "US",
+				"198.51.100.200": "DE",
+				"192.0.2.1":      "",
+				"203.0.114.1":    "",
+				"2001:db8::1":    "",
+				"2001:db9::1":    "",
+			}
+			if ipVersion == 6 {
+				want["2001:db8::1"] = "FR"
+				want["2001:db8:ffff::1"] = "FR"
+			}
+
+			for address, code := range want {
+				got, err := r.Country(net.ParseIP(address))
+				if err != nil {
+					t.Errorf("IPv%d, %d bit records: Country(%s): %v", ipVersion, recordSize, address, err)
+				} else if got != code {
+					t.Errorf("IPv%d, %d bit records: Country(%s) = %q, want %q", ipVersion, recordSize, address, got, code)
+				}
+			}
+		}
+	}
+}
+
+func TestLookupTypes(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 100))
+	record := map[string]interface{}{
+		"string":  "Deutschland",
+		"long":    long,
+		"bytes":   []byte{1, 2, 3},
+		"double":  52.5,
+		"float":   float32(13.25),
+		"uint16":  uint16(443),
+		"uint32":  uint32(1 << 31),
+		"uint64":  uint64(1 << 40),
+		"int32":   int32(-12),
+		"true":    true,
+		"false":   false,
+		"array":   []interface{}{"a", uint16(1)},
+		"nested":  map[string]interface{}{"en": "Germany"},
+		"zero":    uint32(0),
+		"empty":   "",
+		"unicode": "آلمان",
+	}
+
+	w := newWriter(4, 24)
+	w.insert(t, "203.0.113.0/24", record)
+	r, err := New(w.bytes(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Lookup(net.ParseIP("203.0.113.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"string":  "Deutschland",
+		"long":    long,
+		"bytes":   []byte{1, 2, 3},
+		"double":  52.5,
+		"float":   13.25,
+		"uint16":  uint64(443),
+		"uint32":  uint64(1 << 31),
+		"uint64":  uint64(1 << 40),
+		"int32":   int64(-12),
+		"true":    true,
+		"false":   false,
+		"array":   []interface{}{"a", uint64(1)},
+		"nested":  map[string]interface{}{"en": "Germany"},
+		"zero":    uint64(0),
+		"empty":   "",
+		"unicode": "آلمان",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup = %#v, want %#v", got, want)
+	}
+}
+
+func TestLookupIPv6InIPv4Database(t *testing.T) {
+	w := newWriter(4, 24)
+	w.insert(t, "203.0.113.0/24", country("DE"))
+	r, err := New(w.bytes(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Lookup(net.ParseIP("2001:db8::1"))
+	if got != nil || err != nil {
+		t.Errorf("Lookup of an IPv6 address = %v, %v, want nil, nil", got, err)
+	}
+}
+
+func TestInvalidDatabases(t *testing.T) {
+	w := newWriter(4, 24)
+	w.insert(t, "203.0.113.0/24", country("DE"))
+	valid := w.bytes(nil)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"no metadata", valid[:bytes.LastIndex(valid, metadataMarker)]},
+		{"truncated metadata", valid[:len(valid)-3]},
+		{"record size", newWriter(4, 24).bytes(map[string]interface{}{"record_size": uint16(16)})},
+		{"IP version", newWriter(4, 24).bytes(map[string]interface{}{"ip_version": uint16(5)})},
+		{"node count", newWriter(4, 24).bytes(map[string]interface{}{"node_count": uint32(1 << 20)})},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.data); !errors.Is(err, ErrInvalidDatabase) {
+			t.Errorf("%s: New = %v, want ErrInvalidDatabase", tt.name, err)
+		}
+	}
+}
+
+func TestCorruptData(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(db []byte, data int)
+	}{
+		// The string claims more bytes than the data section holds
+		{"truncated string", func(db []byte, data int) { db[data+1] = 2<<5 | 28 }},
+		{"unknown type", func(db []byte, data int) { db[data+1] = 0; db[data+2] = 200 }},
+	}
+
+	for _, tt := range tests {
+		w := newWriter(4, 24)
+		w.insert(t, "203.0.113.0/24", map[string]interface{}{"a": "b"})
+		db := w.bytes(nil)
+		// One inner node per prefix bit, then the separator
+		data := 24*6 + dataSectionSeparator
+		tt.corrupt(db, data)
+
+		r, err := New(db)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, err := r.Lookup(net.ParseIP("203.0.113.1")); !errors.Is(err, ErrInvalidDatabase) {
+			t.Errorf("%s: Lookup = %v, want ErrInvalidDatabase", tt.name, err)
+		}
+	}
+}
+
+func TestPointerLoop(t *testing.T) {
+	w := newWriter(4, 24)
+	w.insert(t, "203.0.113.0/24", country("DE"))
+	// A pointer to itself must not recurse forever
+	offset := uint(w.data.Len())
+	encodePointer(&w.data, offset)
+	w.offsets["loop"] = offset
+	w.insert(t, "198.51.100.0/24", pointerTo("loop"))
+
+	r, err := New(w.bytes(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Lookup(net.ParseIP("198.51.100.1")); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("Lookup = %v, want ErrInvalidDatabase", err)
+	}
+}
@@ -3,6 +3,11 @@ module github.com/ebrahimkhodadadi/MizitoForwarder
 go 1.24.4
 
 require (
+	github.com/getsentry/sentry-go v0.45.0
 	github.com/gorilla/mux v1.8.1
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/sys v0.41.0
+	golang.org/x/term v0.40.0
+	golang.org/x/text v0.34.0
+	gopkg.in/yaml.v3 v3.0.1
 )
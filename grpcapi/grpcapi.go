@@ -0,0 +1,469 @@
+// Package grpcapi serves the gRPC API of proto/mizitoforwarder/v1, for
+// internal services preferring gRPC with deadlines and streaming over
+// webhooks. Notifications take the same pipeline as those received over
+// HTTP.
+//
+// The gRPC protocol is served by net/http over HTTP/2, with or without TLS,
+// and messages are encoded by hand, so no gRPC or protobuf module is
+// needed. Compressed messages are not supported.
+package grpcapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/handler"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/tracking"
+)
+
+// RouteName is the route of notifications not naming one, e.g. for
+// ROUTE_GRPC_TEMPLATE
+const RouteName = "grpc"
+
+// servicePath prefixes the paths of the methods of the service
+const servicePath = "/mizitoforwarder.v1.Forwarder/"
+
+// maxMessageSize caps request messages, as gRPC does by default
+const maxMessageSize = 4 << 20
+
+// watchInterval is how often a watched status is checked for changes
+const watchInterval = 500 * time.Millisecond
+
+// Status codes of gRPC
+const (
+	codeOK                = 0
+	codeCanceled          = 1
+	codeUnknown           = 2
+	codeInvalidArgument   = 3
+	codeDeadlineExceeded  = 4
+	codeNotFound          = 5
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+var calls = metrics.NewCounter("grpc_calls_total",
+	"gRPC calls by method and status code.", "method", "code")
+
+// Backend is what the API needs of the HTTP handler
+type Backend interface {
+	// Authorize checks the app token of a call and returns the default
+	// priority of its application
+	Authorize(r *http.Request) (priority int, ok bool)
+
+	// SubmitResponse delivers a notification, see handler.Handler
+	SubmitResponse(ctx context.Context, n *render.Notification) (int, *handler.NotificationResponse, error)
+
+	// MessageStatus returns the delivery state of a notification
+	MessageStatus(id string) (*tracking.Status, bool)
+}
+
+// statusError is a call failing with a gRPC status
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// errorf returns a statusError
+func errorf(code int, format string, args ...interface{}) error {
+	return &statusError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// Server is the gRPC listener
+type Server struct {
+	backend         Backend
+	logger          *logger.Logger
+	listener        net.Listener
+	server          *http.Server
+	shutdownTimeout time.Duration
+
+	// stopping is closed on shutdown, ending the watches of statuses
+	stopping chan struct{}
+}
+
+// New listens on GRPC_PORT, with TLS when tlsConfig is set
+func New(cfg *config.Config, backend Backend, tlsConfig *tls.Config, logger *logger.Logger) (*Server, error) {
+	listener, err := net.Listen("tcp", cfg.GRPCPort)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		backend:         backend,
+		logger:          logger.With("component", "grpc"),
+		listener:        listener,
+		shutdownTimeout: cfg.ShutdownTimeout,
+		stopping:        make(chan struct{}),
+	}
+
+	// Streams may stay open as long as their deadline, so only reading
+	// the headers is timed
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	s.server = &http.Server{
+		Handler:           s,
+		Protocols:         &protocols,
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+	if tlsConfig != nil {
+		s.server.TLSConfig = tlsConfig.Clone()
+	}
+	return s, nil
+}
+
+// Run serves calls until ctx is cancelled, then waits for the calls in
+// progress up to SHUTDOWN_TIMEOUT
+func (s *Server) Run(ctx context.Context) {
+	s.logger.Info("gRPC listener started", "address", s.listener.Addr().String(), "tls", s.server.TLSConfig != nil)
+	defer s.logger.Info("gRPC listener stopped")
+
+	go func() {
+		<-ctx.Done()
+		close(s.stopping)
+
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.shutdownTimeout)
+		defer cancel()
+		if err := s.server.Shutdown(shutdownCtx); err != nil {
+			s.logger.Warn("gRPC calls still in progress at shutdown", "error", err)
+			s.server.Close()
+		}
+	}()
+
+	var err error
+	if s.server.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate
+		err = s.server.ServeTLS(s.listener, "", "")
+	} else {
+		err = s.server.Serve(s.listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		s.logger.Error("gRPC listener failed", "error", err)
+	}
+}
+
+// ServeHTTP serves a gRPC call
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "Not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	method := strings.TrimPrefix(r.URL.Path, servicePath)
+	requestID := r.Header.Get("X-Request-Id")
+	if requestID == "" || len(requestID) > 128 {
+		requestID = newID()
+	}
+	ctx := logger.WithRequestID(r.Context(), requestID)
+	log := s.logger.WithContext(ctx).With("method", method)
+
+	// The deadline of the call bounds its delivery
+	if timeout, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	c := &call{w: w, r: r.WithContext(ctx)}
+
+	var err error
+	if priority, ok := s.backend.Authorize(r); !ok {
+		log.Warn("Unauthorized gRPC call – invalid or missing app token", "remote_addr", r.RemoteAddr)
+		err = errorf(codeUnauthenticated, "valid app token required in the authorization (Bearer) or x-gotify-key metadata")
+	} else {
+		switch method {
+		case "SendMessage", "SendToDialog":
+			err = s.send(ctx, c, method == "SendToDialog", priority, requestID)
+		case "GetStatus":
+			err = s.getStatus(ctx, c)
+		default:
+			method = "unknown"
+			err = errorf(codeUnimplemented, "unknown method %s", r.URL.Path)
+		}
+	}
+
+	code, message := codeOK, ""
+	if err != nil {
+		code, message = codeUnknown, err.Error()
+		var status *statusError
+		switch {
+		case errors.As(err, &status):
+			code = status.code
+		case errors.Is(err, context.DeadlineExceeded):
+			code = codeDeadlineExceeded
+		case errors.Is(err, context.Canceled):
+			code = codeCanceled
+		}
+		log.Debug("gRPC call failed", "code", code, "error", message)
+	}
+
+	calls.Inc(method, strconv.Itoa(code))
+	c.finish(code, message)
+}
+
+// send serves SendMessage and SendToDialog
+func (s *Server) send(ctx context.Context, c *call, toDialog bool, appPriority int, requestID string) error {
+	data, err := c.receive()
+	if err != nil {
+		return err
+	}
+	req, err := parseSendRequest(data)
+	if err != nil {
+		return errorf(codeInvalidArgument, "invalid request: %v", err)
+	}
+
+	if req.Title == "" && req.Message == "" {
+		return errorf(codeInvalidArgument, "title or message is required")
+	}
+	if toDialog && req.DialogID == "" {
+		return errorf(codeInvalidArgument, "dialog_id is required")
+	}
+	if !toDialog {
+		req.DialogID = ""
+	}
+	if req.Route == "" {
+		req.Route = RouteName
+	}
+	if req.Priority == 0 {
+		req.Priority = appPriority
+	}
+
+	if req.Account != "" {
+		ctx = handler.WithAccount(ctx, req.Account)
+	}
+	if req.Async != nil {
+		ctx = handler.WithAsync(ctx, *req.Async)
+	}
+
+	var extras map[string]interface{}
+	if len(req.Extras) > 0 {
+		extras = make(map[string]interface{}, len(req.Extras))
+		for key, value := range req.Extras {
+			extras[key] = value
+		}
+	}
+
+	status, response, err := s.backend.SubmitResponse(ctx, &render.Notification{
+		Route:    req.Route,
+		Title:    req.Title,
+		Message:  req.Message,
+		Priority: req.Priority,
+		Time:     time.Now(),
+		Source:   RouteName,
+		DialogID: req.DialogID,
+		Extras:   extras,
+	})
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if status != http.StatusOK && status != http.StatusAccepted {
+		return errorf(statusCode(status), "%s", response.Message)
+	}
+
+	if response.RequestID == "" {
+		response.RequestID = requestID
+	}
+	return c.send(encodeSendResponse(response, status == http.StatusAccepted))
+}
+
+// getStatus serves GetStatus, streaming the changes of the status when
+// watched
+func (s *Server) getStatus(ctx context.Context, c *call) error {
+	data, err := c.receive()
+	if err != nil {
+		return err
+	}
+	req, err := parseGetStatusRequest(data)
+	if err != nil {
+		return errorf(codeInvalidArgument, "invalid request: %v", err)
+	}
+	if req.ID == "" {
+		return errorf(codeInvalidArgument, "id is required")
+	}
+
+	status, ok := s.backend.MessageStatus(req.ID)
+	if !ok {
+		return errorf(codeNotFound, "message %s is unknown or was accepted too long ago", req.ID)
+	}
+	last := encodeStatus(status)
+	if err := c.send(last); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for req.Watch && status.State == tracking.StateQueued {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.stopping:
+			return errorf(codeUnavailable, "server is shutting down")
+		case <-ticker.C:
+		}
+
+		if status, ok = s.backend.MessageStatus(req.ID); !ok {
+			return nil
+		}
+		if current := encodeStatus(status); string(current) != string(last) {
+			if err := c.send(current); err != nil {
+				return err
+			}
+			last = current
+		}
+	}
+	return nil
+}
+
+// statusCode maps the HTTP status of a rejected notification to a gRPC
+// status code
+func statusCode(status int) int {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return codeInvalidArgument
+	case http.StatusUnauthorized:
+		return codeUnauthenticated
+	case http.StatusForbidden:
+		return codePermissionDenied
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusTooManyRequests:
+		return codeResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codeUnavailable
+	default:
+		return codeUnknown
+	}
+}
+
+// call reads the messages of a call and writes its response
+type call struct {
+	w http.ResponseWriter
+	r *http.Request
+
+	// sent is set once a message was written, after which the status goes
+	// to the trailers
+	sent bool
+}
+
+// receive reads the request message, prefixed by its compression flag and
+// length
+func (c *call) receive() ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(c.r.Body, prefix[:]); err != nil {
+		return nil, errorf(codeInvalidArgument, "missing request message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errorf(codeUnimplemented, "compressed messages are not supported")
+	}
+
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > maxMessageSize {
+		return nil, errorf(codeResourceExhausted, "request message of %d bytes exceeds %d bytes", length, maxMessageSize)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r.Body, data); err != nil {
+		return nil, errorf(codeInvalidArgument, "truncated request message: %v", err)
+	}
+	return data, nil
+}
+
+// send writes a response message
+func (c *call) send(data []byte) error {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+	if _, err := c.w.Write(append(prefix[:], data...)); err != nil {
+		return err
+	}
+	c.sent = true
+	return http.NewResponseController(c.w).Flush()
+}
+
+// finish ends the call with its status: in the headers of a response
+// without messages, in the trailers otherwise
+func (c *call) finish(code int, message string) {
+	prefix := ""
+	if c.sent {
+		prefix = http.TrailerPrefix
+	}
+	c.w.Header().Set(prefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		c.w.Header().Set(prefix+"Grpc-Message", encodeMessage(message))
+	}
+	if !c.sent {
+		c.w.WriteHeader(http.StatusOK)
+	}
+}
+
+// encodeMessage percent-encodes a status message for the grpc-message
+// header
+func encodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseTimeout reads a grpc-timeout header, such as 500m for 500
+// milliseconds
+func parseTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// newID returns a random request ID for a call
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
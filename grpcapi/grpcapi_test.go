@@ -0,0 +1,251 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/handler"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/tracking"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"1H", time.Hour, true},
+		{"2M", 2 * time.Minute, true},
+		{"30S", 30 * time.Second, true},
+		{"500m", 500 * time.Millisecond, true},
+		{"250u", 250 * time.Microsecond, true},
+		{"99999999n", 99999999 * time.Nanosecond, true},
+		{"0S", 0, true},
+		{"", 0, false},
+		{"S", 0, false},
+		{"5", 0, false},
+		{"5s", 0, false},
+		{"-5S", 0, false},
+		{"1.5S", 0, false},
+		// At most eight digits
+		{"123456789S", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTimeout(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseTimeout(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	if got := encodeMessage("dialog 50% full – retry\n"); got != "dialog 50%25 full %E2%80%93 retry%0A" {
+		t.Errorf("encodeMessage = %q", got)
+	}
+}
+
+// backend answers calls with the app token "secret": notifications titled
+// "rejected" are refused, the others accepted as message "m1"
+type backend struct{}
+
+func (backend) Authorize(r *http.Request) (int, bool) {
+	return 5, r.Header.Get("Authorization") == "Bearer secret"
+}
+
+func (backend) SubmitResponse(ctx context.Context, n *render.Notification) (int, *handler.NotificationResponse, error) {
+	if n.Title == "rejected" {
+		return http.StatusForbidden, &handler.NotificationResponse{Message: "Dialog is not allowed: ops – night"}, nil
+	}
+	return http.StatusOK, &handler.NotificationResponse{Success: true, Message: "Notification sent successfully", ID: "m1"}, nil
+}
+
+func (backend) MessageStatus(id string) (*tracking.Status, bool) {
+	if id != "m1" {
+		return nil, false
+	}
+	return &tracking.Status{ID: id, State: tracking.StateSent, Attempts: 1, AcceptedAt: time.Unix(1736412345, 0)}, true
+}
+
+// newTestServer serves the API over HTTP/2 without TLS and returns a client
+// speaking it
+func newTestServer(t *testing.T) (*httptest.Server, *http.Client) {
+	t.Helper()
+	log, err := logger.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{backend: backend{}, logger: log, stopping: make(chan struct{})}
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+
+	server := httptest.NewUnstartedServer(s)
+	server.Config.Protocols = &protocols
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return server, &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+}
+
+// frame prefixes a message with its compression flag and length
+func frame(data []byte) []byte {
+	prefix := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+	return append(prefix, data...)
+}
+
+// messages splits a response body into its messages
+func messages(t *testing.T, body []byte) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for len(body) > 0 {
+		if len(body) < 5 {
+			t.Fatalf("truncated message prefix %v", body)
+		}
+		length := int(binary.BigEndian.Uint32(body[1:5]))
+		if len(body) < 5+length {
+			t.Fatalf("truncated message of %d bytes", length)
+		}
+		out = append(out, body[5:5+length])
+		body = body[5+length:]
+	}
+	return out
+}
+
+func TestServeCalls(t *testing.T) {
+	server, client := newTestServer(t)
+
+	var sendRequest, rejectedRequest, statusRequest, unknownStatusRequest encoder
+	sendRequest.string(1, "Disk almost full")
+	rejectedRequest.string(1, "rejected")
+	statusRequest.string(1, "m1")
+	unknownStatusRequest.string(1, "m2")
+
+	tests := []struct {
+		name    string
+		method  string
+		token   string
+		request []byte
+
+		// messages is the number of response messages; without any, the
+		// status is in the headers of a trailers-only response
+		messages int
+		status   string
+		message  string
+	}{
+		{"send", "SendMessage", "secret", frame(sendRequest), 1, "0", ""},
+		{"status", "GetStatus", "secret", frame(statusRequest), 1, "0", ""},
+		{"unauthenticated", "SendMessage", "wrong", frame(sendRequest), 0, "16",
+			"valid app token required in the authorization (Bearer) or x-gotify-key metadata"},
+		{"rejected", "SendMessage", "secret", frame(rejectedRequest), 0, "7", "Dialog is not allowed: ops %E2%80%93 night"},
+		{"unknown status", "GetStatus", "secret", frame(unknownStatusRequest), 0, "5", "message m2 is unknown or was accepted too long ago"},
+		{"missing title", "SendMessage", "secret", frame(nil), 0, "3", "title or message is required"},
+		{"invalid request", "SendMessage", "secret", frame([]byte{0x08, 0x01}), 0, "3", ""},
+		{"truncated message", "SendMessage", "secret", frame(sendRequest)[:8], 0, "3", ""},
+		{"compressed", "SendMessage", "secret", append([]byte{1}, frame(sendRequest)[1:]...), 0, "12", "compressed messages are not supported"},
+		{"unknown method", "Subscribe", "secret", frame(nil), 0, "12", ""},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodPost, server.URL+servicePath+tt.method, bytes.NewReader(tt.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		req.Header.Set("Grpc-Timeout", "5S")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: %s %d, want HTTP/2 200", tt.name, resp.Proto, resp.StatusCode)
+		}
+		if got := len(messages(t, body)); got != tt.messages {
+			t.Errorf("%s: %d response messages, want %d", tt.name, got, tt.messages)
+		}
+
+		status := resp.Header
+		if tt.messages > 0 {
+			status = resp.Trailer
+			if resp.Header.Get("Grpc-Status") != "" {
+				t.Errorf("%s: status in the headers of a response with messages", tt.name)
+			}
+		}
+		if got := status.Get("Grpc-Status"); got != tt.status {
+			t.Errorf("%s: grpc-status %q, want %q", tt.name, got, tt.status)
+		}
+		if got := status.Get("Grpc-Message"); tt.message != "" && got != tt.message {
+			t.Errorf("%s: grpc-message %q, want %q", tt.name, got, tt.message)
+		}
+	}
+}
+
+func TestServeSendResponse(t *testing.T) {
+	server, client := newTestServer(t)
+
+	var request encoder
+	request.string(1, "Disk almost full")
+	req, err := http.NewRequest(http.MethodPost, server.URL+servicePath+"SendMessage", bytes.NewReader(frame(request)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Request-Id", "req-1")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	msgs := messages(t, body)
+	if len(msgs) != 1 {
+		t.Fatalf("%d response messages, want 1", len(msgs))
+	}
+	fields, err := decodeFields(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// id, message and request_id; queued is false and left out
+	got := map[int]string{}
+	for _, f := range fields {
+		got[f.number] = string(f.bytes)
+	}
+	if len(fields) != 3 || got[1] != "m1" || got[3] != "Notification sent successfully" || got[4] != "req-1" {
+		t.Errorf("response fields = %v", got)
+	}
+}
+
+func TestServeRequiresHTTP2(t *testing.T) {
+	log, err := logger.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{backend: backend{}, logger: log, stopping: make(chan struct{})}
+
+	r := httptest.NewRequest(http.MethodPost, servicePath+"SendMessage", nil)
+	r.Header.Set("Content-Type", "application/grpc")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusHTTPVersionNotSupported {
+		t.Errorf("HTTP/1.1 call answered %d, want 505", w.Code)
+	}
+}
@@ -0,0 +1,160 @@
+package grpcapi
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/handler"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/tracking"
+)
+
+// sendRequest is a SendMessageRequest or SendToDialogRequest, which share
+// their field numbers; DialogID is only set by the latter
+type sendRequest struct {
+	Title    string
+	Message  string
+	Priority int
+	Route    string
+	Extras   map[string]string
+	Async    *bool
+	Account  string
+	DialogID string
+}
+
+// parseSendRequest decodes a SendMessageRequest or SendToDialogRequest
+func parseSendRequest(data []byte) (*sendRequest, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &sendRequest{}
+	for _, f := range fields {
+		switch f.number {
+		case 1:
+			err = decodeString(f, &req.Title)
+		case 2:
+			err = decodeString(f, &req.Message)
+		case 3:
+			err = expectWire(f, wireVarint)
+			req.Priority = int(int32(f.varint))
+		case 4:
+			err = decodeString(f, &req.Route)
+		case 5:
+			if req.Extras == nil {
+				req.Extras = make(map[string]string)
+			}
+			err = decodeMapEntry(f, req.Extras)
+		case 6:
+			err = expectWire(f, wireVarint)
+			async := f.varint != 0
+			req.Async = &async
+		case 7:
+			err = decodeString(f, &req.Account)
+		case 8:
+			err = decodeString(f, &req.DialogID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// getStatusRequest is a GetStatusRequest
+type getStatusRequest struct {
+	ID    string
+	Watch bool
+}
+
+// parseGetStatusRequest decodes a GetStatusRequest
+func parseGetStatusRequest(data []byte) (*getStatusRequest, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &getStatusRequest{}
+	for _, f := range fields {
+		switch f.number {
+		case 1:
+			err = decodeString(f, &req.ID)
+		case 2:
+			err = expectWire(f, wireVarint)
+			req.Watch = f.varint != 0
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// encodeSendResponse encodes the SendMessageResponse of a notification
+func encodeSendResponse(response *handler.NotificationResponse, queued bool) []byte {
+	var e encoder
+	e.string(1, response.ID)
+	e.bool(2, queued)
+	e.string(3, response.Message)
+	e.string(4, response.RequestID)
+	return e
+}
+
+// encodeStatus encodes the MessageStatus of a notification
+func encodeStatus(status *tracking.Status) []byte {
+	var e encoder
+	e.string(1, status.ID)
+	e.string(2, status.State)
+	e.int(3, int64(status.Attempts))
+	e.string(4, status.Error)
+	e.timestamp(5, &status.AcceptedAt)
+	e.timestamp(6, status.SentAt)
+	e.timestamp(7, status.FailedAt)
+	return e
+}
+
+// expectWire checks the wire type of a field
+func expectWire(f field, wire int) error {
+	if f.wire != wire {
+		return fmt.Errorf("field %d has wire type %d, expected %d", f.number, f.wire, wire)
+	}
+	return nil
+}
+
+// decodeString decodes a string field, which must be valid UTF-8
+func decodeString(f field, dst *string) error {
+	if err := expectWire(f, wireBytes); err != nil {
+		return err
+	}
+	if !utf8.Valid(f.bytes) {
+		return fmt.Errorf("field %d is not valid UTF-8", f.number)
+	}
+	*dst = string(f.bytes)
+	return nil
+}
+
+// decodeMapEntry decodes an entry of a map<string, string> field into m
+func decodeMapEntry(f field, m map[string]string) error {
+	if err := expectWire(f, wireBytes); err != nil {
+		return err
+	}
+	fields, err := decodeFields(f.bytes)
+	if err != nil {
+		return err
+	}
+
+	var key, value string
+	for _, entry := range fields {
+		switch entry.number {
+		case 1:
+			err = decodeString(entry, &key)
+		case 2:
+			err = decodeString(entry, &value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	m[key] = value
+	return nil
+}
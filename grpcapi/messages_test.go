@@ -0,0 +1,108 @@
+package grpcapi
+
+import (
+	"strings"
+	"testing"
+)
+
+// sendRequestData encodes a SendToDialogRequest with every field set
+func sendRequestData() []byte {
+	var entry encoder
+	entry.string(1, "host")
+	entry.string(2, "db1")
+
+	var e encoder
+	e.string(1, "Disk almost full")
+	e.string(2, "92% used")
+	e.int(3, 8)
+	e.string(4, "backup")
+	e.bytes(5, entry)
+	e.bool(6, true)
+	e.string(7, "ops")
+	e.string(8, "oncall")
+	return e
+}
+
+func TestParseSendRequest(t *testing.T) {
+	req, err := parseSendRequest(sendRequestData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Title != "Disk almost full" || req.Message != "92% used" || req.Priority != 8 ||
+		req.Route != "backup" || req.Account != "ops" || req.DialogID != "oncall" {
+		t.Errorf("request = %+v", req)
+	}
+	if req.Async == nil || !*req.Async {
+		t.Errorf("async = %v, want true", req.Async)
+	}
+	if len(req.Extras) != 1 || req.Extras["host"] != "db1" {
+		t.Errorf("extras = %v", req.Extras)
+	}
+
+	// Negative int32 values take ten bytes
+	var e encoder
+	e.int(3, -2)
+	if req, err := parseSendRequest(e); err != nil || req.Priority != -2 {
+		t.Errorf("negative priority = %+v, %v", req, err)
+	}
+}
+
+func TestParseSendRequestSkipsUnknownFields(t *testing.T) {
+	var e encoder
+	e.int(99, 5)
+	e.string(1, "title")
+	e.string(50, "from a newer client")
+	e = append(e, 0x9d, 0x06, 1, 2, 3, 4)             // field 99, fixed32
+	e = append(e, 0x99, 0x06, 1, 2, 3, 4, 5, 6, 7, 8) // field 99, fixed64
+
+	req, err := parseSendRequest(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Title != "title" || req.Message != "" || req.Async != nil {
+		t.Errorf("request = %+v", req)
+	}
+}
+
+func TestParseSendRequestInvalid(t *testing.T) {
+	var badEntry encoder
+	badEntry.int(1, 1)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"title as varint", []byte{0x08, 0x01}, "wire type"},
+		{"priority as bytes", []byte{0x1a, 1, '8'}, "wire type"},
+		{"async as bytes", []byte{0x32, 1, 1}, "wire type"},
+		{"extras as varint", []byte{0x28, 0x01}, "wire type"},
+		{"extras key as varint", append([]byte{0x2a, byte(len(badEntry))}, badEntry...), "wire type"},
+		{"truncated extras entry", []byte{0x2a, 2, 0x0a, 5}, "truncated"},
+		{"invalid UTF-8", []byte{0x0a, 2, 0xc3, 0x28}, "UTF-8"},
+		{"truncated title", []byte{0x0a, 10, 'a'}, "truncated"},
+		{"field number 0", []byte{0x02, 0x00}, "field number"},
+	}
+	for _, tt := range tests {
+		_, err := parseSendRequest(tt.data)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: %v, want an error about %s", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestParseGetStatusRequest(t *testing.T) {
+	var e encoder
+	e.string(1, "5f0c9e2a7b1d4c38")
+	e.bool(2, true)
+	e.int(3, 1)
+
+	req, err := parseGetStatusRequest(e)
+	if err != nil || req.ID != "5f0c9e2a7b1d4c38" || !req.Watch {
+		t.Errorf("request = %+v, %v", req, err)
+	}
+
+	if _, err := parseGetStatusRequest([]byte{0x10, 0x80}); err == nil {
+		t.Error("truncated watch decoded without error")
+	}
+}
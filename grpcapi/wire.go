@@ -0,0 +1,121 @@
+package grpcapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Wire types of the protocol buffers encoding
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// maxFieldNumber is the largest field number of the protocol buffers
+// encoding
+const maxFieldNumber = 1<<29 - 1
+
+// errTruncated is returned for messages ending within a field
+var errTruncated = errors.New("truncated message")
+
+// field is a field of a decoded message. Fields of the varint and fixed
+// wire types hold their value in varint, the others in bytes.
+type field struct {
+	number int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+// decodeFields splits a message into its fields, in the order encoded
+func decodeFields(data []byte) ([]field, error) {
+	var fields []field
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		data = data[n:]
+
+		if key>>3 == 0 || key>>3 > maxFieldNumber {
+			return nil, fmt.Errorf("invalid field number %d", key>>3)
+		}
+		f := field{number: int(key >> 3), wire: int(key & 7)}
+
+		switch f.wire {
+		case wireVarint:
+			if f.varint, n = binary.Uvarint(data); n <= 0 {
+				return nil, errTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, errTruncated
+			}
+			f.varint, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, errTruncated
+			}
+			f.varint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, errTruncated
+			}
+			f.bytes, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d of field %d", f.wire, f.number)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// encoder appends the fields of a message. Fields holding their zero value
+// are left out, as proto3 does.
+type encoder []byte
+
+func (e *encoder) tag(number, wire int) {
+	*e = binary.AppendUvarint(*e, uint64(number)<<3|uint64(wire))
+}
+
+func (e *encoder) bytes(number int, value []byte) {
+	e.tag(number, wireBytes)
+	*e = binary.AppendUvarint(*e, uint64(len(value)))
+	*e = append(*e, value...)
+}
+
+func (e *encoder) string(number int, value string) {
+	if value != "" {
+		e.bytes(number, []byte(value))
+	}
+}
+
+func (e *encoder) int(number int, value int64) {
+	if value != 0 {
+		e.tag(number, wireVarint)
+		*e = binary.AppendUvarint(*e, uint64(value))
+	}
+}
+
+func (e *encoder) bool(number int, value bool) {
+	if value {
+		e.int(number, 1)
+	}
+}
+
+// timestamp appends a google.protobuf.Timestamp, unless t is nil
+func (e *encoder) timestamp(number int, t *time.Time) {
+	if t == nil {
+		return
+	}
+	var ts encoder
+	ts.int(1, t.Unix())
+	ts.int(2, int64(t.Nanosecond()))
+	e.bytes(number, ts)
+}
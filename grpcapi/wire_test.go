@@ -0,0 +1,104 @@
+package grpcapi
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecodeFields(t *testing.T) {
+	data := []byte{
+		0x08, 0x96, 0x01, // field 1, varint 150
+		0x11, 1, 2, 3, 4, 5, 6, 7, 8, // field 2, fixed64
+		0x1a, 3, 'a', 'b', 'c', // field 3, bytes "abc"
+		0x25, 1, 0, 0, 0, // field 4, fixed32 1
+		0x80, 0x01, 0x00, // field 16, varint 0, with a two-byte key
+	}
+
+	fields, err := decodeFields(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []field{
+		{number: 1, wire: wireVarint, varint: 150},
+		{number: 2, wire: wireFixed64, varint: 0x0807060504030201},
+		{number: 3, wire: wireBytes, bytes: []byte("abc")},
+		{number: 4, wire: wireFixed32, varint: 1},
+		{number: 16, wire: wireVarint},
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("decoded %d fields, want %d", len(fields), len(want))
+	}
+	for i, f := range fields {
+		w := want[i]
+		if f.number != w.number || f.wire != w.wire || f.varint != w.varint || !bytes.Equal(f.bytes, w.bytes) {
+			t.Errorf("field %d = %+v, want %+v", i, f, w)
+		}
+	}
+
+	if fields, err := decodeFields(nil); err != nil || len(fields) != 0 {
+		t.Errorf("empty message = %v, %v", fields, err)
+	}
+}
+
+func TestDecodeFieldsInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		truncated bool
+	}{
+		{"truncated key", []byte{0x80}, true},
+		{"truncated varint", []byte{0x08, 0x96}, true},
+		{"missing varint", []byte{0x08}, true},
+		{"truncated fixed64", []byte{0x11, 1, 2, 3}, true},
+		{"truncated fixed32", []byte{0x25, 1, 2}, true},
+		{"truncated length", []byte{0x1a, 0x80}, true},
+		{"length beyond message", []byte{0x1a, 5, 'a', 'b'}, true},
+		{"huge length", []byte{0x1a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, true},
+		{"field number 0", []byte{0x00, 0x01}, false},
+		{"field number beyond range", []byte{0x80, 0x80, 0x80, 0x80, 0x10, 0x01}, false},
+		{"group wire type", []byte{0x0b, 0x0c}, false},
+		{"invalid wire type", []byte{0x0e, 0x01}, false},
+	}
+	for _, tt := range tests {
+		_, err := decodeFields(tt.data)
+		if err == nil {
+			t.Errorf("%s: decoded without error", tt.name)
+			continue
+		}
+		if errors.Is(err, errTruncated) != tt.truncated {
+			t.Errorf("%s: %v, truncated %v", tt.name, err, tt.truncated)
+		}
+	}
+}
+
+func TestEncoderRoundTrip(t *testing.T) {
+	at := time.Unix(1736412345, 500)
+
+	var e encoder
+	e.string(1, "id")
+	e.string(2, "")
+	e.int(3, 7)
+	e.bool(4, true)
+	e.bool(5, false)
+	e.timestamp(6, &at)
+	e.timestamp(7, nil)
+
+	fields, err := decodeFields(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Zero values are left out
+	if len(fields) != 4 {
+		t.Fatalf("encoded %d fields, want 4: %+v", len(fields), fields)
+	}
+	if string(fields[0].bytes) != "id" || fields[1].varint != 7 || fields[2].varint != 1 {
+		t.Errorf("fields = %+v", fields)
+	}
+
+	ts, err := decodeFields(fields[3].bytes)
+	if err != nil || len(ts) != 2 || ts[0].varint != 1736412345 || ts[1].varint != 500 {
+		t.Errorf("timestamp = %+v, %v", ts, err)
+	}
+}
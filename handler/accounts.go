@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+)
+
+// accountHeader selects the Mizito account delivering a notification
+const accountHeader = "X-Mizito-Account"
+
+// accountContextKey is the context key holding the account of a submitted
+// notification
+type accountContextKey struct{}
+
+// WithAccount makes Submit deliver a notification through the named
+// account instead of the account of its route
+func WithAccount(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, accountContextKey{}, name)
+}
+
+// defaultAccount returns the account configured through the environment
+func (h *Handler) defaultAccount() *mizito.Account {
+	return h.accounts[config.DefaultAccount]
+}
+
+// accountConfig returns the configuration of an account as loaded with the
+// handler, which is newer than that of the account after a reload
+func (h *Handler) accountConfig(account *mizito.Account) *config.Config {
+	if accountConfig, ok := h.config.Account(account.Name); ok {
+		return accountConfig
+	}
+	return account.Config
+}
+
+// requestedAccount returns the account a request selects with the
+// X-Mizito-Account header or the account query parameter, falling back to
+// the ACCOUNT option of the route and then the default account. It returns
+// nil and the requested name for unknown accounts.
+func (h *Handler) requestedAccount(r *http.Request, route string) (*mizito.Account, string) {
+	name := r.Header.Get(accountHeader)
+	if name == "" {
+		name = r.URL.Query().Get("account")
+	}
+	return h.account(name, route)
+}
+
+// account returns the named account, or else the ACCOUNT option of the
+// route or the default account. It returns nil and the name for unknown
+// accounts.
+func (h *Handler) account(name, route string) (*mizito.Account, string) {
+	if name == "" {
+		name = h.config.Route(route).Account
+	}
+	if name == "" {
+		return h.defaultAccount(), config.DefaultAccount
+	}
+
+	name = strings.ToLower(name)
+	return h.accounts[name], name
+}
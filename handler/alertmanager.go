@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/assets"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+)
+
+// defaultAlertPriority is used for alerts without a known severity label
+const defaultAlertPriority = 5
+
+// AlertmanagerWebhook is the payload of the Prometheus Alertmanager webhook receiver
+type AlertmanagerWebhook struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            Alerts            `json:"alerts"`
+
+	// Omitted are the alerts beyond ALERTMANAGER_MAX_ALERTS. They are not
+	// rendered, but count towards the priority and the firing alerts.
+	Omitted Alerts `json:"-"`
+}
+
+// FiringCount returns the number of firing alerts, rendered or not
+func (w *AlertmanagerWebhook) FiringCount() int {
+	return len(w.Alerts.Firing()) + len(w.Omitted.Firing())
+}
+
+// More returns the number of alerts that are not rendered: those omitted
+// and those Alertmanager truncated itself
+func (w *AlertmanagerWebhook) More() int {
+	return len(w.Omitted) + w.TruncatedAlerts
+}
+
+// all returns the rendered and omitted alerts
+func (w *AlertmanagerWebhook) all() Alerts {
+	return append(w.Alerts[:len(w.Alerts):len(w.Alerts)], w.Omitted...)
+}
+
+// Alert is a single alert of an Alertmanager notification group
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// omittedAlert is an alert beyond ALERTMANAGER_MAX_ALERTS, decoded without
+// its annotations, which make up most of large payloads
+type omittedAlert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	StartsAt    time.Time         `json:"startsAt"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+// Alerts is a list of alerts, with filters usable from templates
+type Alerts []Alert
+
+// Firing returns the alerts that are firing
+func (a Alerts) Firing() Alerts {
+	return a.withStatus("firing")
+}
+
+// Resolved returns the alerts that are resolved
+func (a Alerts) Resolved() Alerts {
+	return a.withStatus("resolved")
+}
+
+func (a Alerts) withStatus(status string) Alerts {
+	var alerts Alerts
+	for _, alert := range a {
+		if alert.Status == status {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// Priority returns the highest priority among the alerts' severity labels,
+// mapped by the alert severity scale so severities route to dialogs like
+// priorities do
+func (a Alerts) Priority() int {
+	priority := -1
+	for _, alert := range a {
+		p := severity.Default.Priority(severity.Alert, alert.Labels["severity"], defaultAlertPriority)
+		if p > priority {
+			priority = p
+		}
+	}
+	if priority < 0 {
+		return defaultAlertPriority
+	}
+	return priority
+}
+
+// loadAlertmanagerTemplate compiles the Alertmanager message template, read
+// from ALERTMANAGER_TEMPLATE_FILE when configured
+func (h *Handler) loadAlertmanagerTemplate() error {
+	text := assets.Template("alertmanager")
+	if path := h.config.AlertmanagerTemplateFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read Alertmanager template: %w", err)
+		}
+		text = string(data)
+	}
+
+	tmpl, err := render.Parse("alertmanager", text, h.userFuncs())
+	if err != nil {
+		return err
+	}
+
+	h.alertmanagerTemplate = tmpl
+	return nil
+}
+
+// HandleAlertmanagerNotification handles POST requests from the Alertmanager webhook receiver
+func (h *Handler) HandleAlertmanagerNotification(w http.ResponseWriter, r *http.Request) {
+	log := h.routeLogger(r, routeName(r))
+	log.Info("Received Alertmanager notification request")
+
+	req, err := decodeAlertmanagerWebhook(r.Body, h.config.AlertmanagerMaxAlerts)
+	if err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	log.Debug("Parsed Alertmanager notification", "status", req.Status, "alerts", len(req.Alerts), "omitted", len(req.Omitted), "group_key", req.GroupKey)
+
+	if len(req.Alerts) == 0 {
+		log.Warn("Alertmanager notification without alerts")
+		http.Error(w, "At least one alert is required", http.StatusBadRequest)
+		return
+	}
+
+	all := req.all()
+	firing := h.correlate(routeName(r), all)
+
+	text, err := render.Execute(h.alertmanagerTemplate, req)
+	if err != nil {
+		log.Error("Failed to render Alertmanager notification", "error", err)
+		writeJSON(w, http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: "Failed to render notification: " + err.Error(),
+		})
+		return
+	}
+
+	h.deliver(w, r, &render.Notification{
+		Route:    routeName(r),
+		Message:  strings.TrimSpace(text),
+		Priority: all.Priority(),
+		Time:     time.Now(),
+		Source:   "alertmanager",
+		DialogID: requestedDialog(r, ""),
+
+		Fingerprints: firing,
+		Extras: map[string]interface{}{
+			"status":            req.Status,
+			"receiver":          req.Receiver,
+			"groupLabels":       req.GroupLabels,
+			"commonLabels":      req.CommonLabels,
+			"commonAnnotations": req.CommonAnnotations,
+			"externalURL":       req.ExternalURL,
+		},
+	})
+}
+
+// decodeAlertmanagerWebhook reads a webhook payload as a stream, alert by
+// alert, so groups of thousands of alerts are not held in memory as a
+// whole. Alerts beyond maxAlerts go to Omitted without their annotations;
+// maxAlerts of 0 keeps all.
+func decodeAlertmanagerWebhook(body io.Reader, maxAlerts int) (*AlertmanagerWebhook, error) {
+	dec := json.NewDecoder(body)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	// Fields other than the alerts are small; they are decoded together
+	// once the object is read
+	fields := make(map[string]json.RawMessage)
+	req := &AlertmanagerWebhook{}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := token.(string)
+
+		if !strings.EqualFold(key, "alerts") {
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return nil, err
+			}
+			fields[key] = value
+			continue
+		}
+
+		if err := decodeAlerts(dec, req, maxAlerts); err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// decodeAlerts reads the alerts array of a webhook payload, or null
+func decodeAlerts(dec *json.Decoder, req *AlertmanagerWebhook, maxAlerts int) error {
+	token, err := dec.Token()
+	if err != nil || token == nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("alerts must be an array, got %v", token)
+	}
+
+	for dec.More() {
+		if maxAlerts == 0 || len(req.Alerts) < maxAlerts {
+			var alert Alert
+			if err := dec.Decode(&alert); err != nil {
+				return err
+			}
+			req.Alerts = append(req.Alerts, alert)
+			continue
+		}
+
+		var alert omittedAlert
+		if err := dec.Decode(&alert); err != nil {
+			return err
+		}
+		req.Omitted = append(req.Omitted, Alert{
+			Status:      alert.Status,
+			Labels:      alert.Labels,
+			StartsAt:    alert.StartsAt,
+			Fingerprint: alert.Fingerprint,
+		})
+	}
+	return expectDelim(dec, ']')
+}
+
+// expectDelim reads a delimiter of a JSON stream
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != want {
+		return fmt.Errorf("expected %v, got %v", want, token)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/assets"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/dedup"
+	"github.com/gorilla/mux"
+)
+
+// alertsPage renders the firing alerts as HTML
+var alertsPage = template.Must(template.New("alerts").Parse(assets.Page("alerts")))
+
+// correlate records the alerts of an alerting system's notification in the
+// correlation store: firing ones are tracked, resolved ones forgotten. It
+// returns the fingerprints of the firing alerts.
+func (h *Handler) correlate(route string, alerts []Alert) []string {
+	var firing []string
+	for _, alert := range alerts {
+		fingerprint := alert.Fingerprint
+		if fingerprint == "" {
+			fingerprint = labelsFingerprint(alert.Labels)
+		}
+
+		switch alert.Status {
+		case "firing":
+			h.alerts.Fire(route, fingerprint, alert.Labels, alert.StartsAt)
+			firing = append(firing, fingerprint)
+		case "resolved":
+			h.alerts.Resolve(fingerprint)
+		}
+	}
+	return firing
+}
+
+// labelsFingerprint derives a fingerprint from the labels of an alert
+// without one
+func labelsFingerprint(labels map[string]string) string {
+	parts := make([]string, 0, len(labels))
+	for name, value := range labels {
+		parts = append(parts, name+"="+value)
+	}
+	sort.Strings(parts)
+	return dedup.Key(parts...)[:16]
+}
+
+// ListAlerts handles GET requests to /alerts and /api/v1/alerts. It lists
+// the firing alerts as an HTML page, or as JSON for /api/v1/alerts and
+// requests accepting application/json.
+func (h *Handler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	alerts := h.alerts.Firing()
+
+	if strings.HasSuffix(r.URL.Path, "/api/v1/alerts") || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, alerts)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := alertsPage.Execute(w, alerts); err != nil {
+		h.logger.Error("Failed to render alerts page", "error", err)
+	}
+}
+
+// AcknowledgeAlert handles POST requests to /api/v1/alerts/{fingerprint}/ack,
+// recording who acknowledged a firing alert, named by the by query
+// parameter. The phone calls about the alert are cancelled.
+func (h *Handler) AcknowledgeAlert(w http.ResponseWriter, r *http.Request) {
+	fingerprint := mux.Vars(r)["fingerprint"]
+
+	by := strings.TrimSpace(r.URL.Query().Get("by"))
+	if by == "" {
+		http.Error(w, "The by query parameter must name who acknowledges the alert", http.StatusBadRequest)
+		return
+	}
+
+	alert, ok := h.alerts.Ack(fingerprint, by)
+	if !ok {
+		http.Error(w, "Alert not firing", http.StatusNotFound)
+		return
+	}
+
+	escalations := h.escalations.AcknowledgeAlert(fingerprint)
+	h.logger.WithContext(r.Context()).Info("Alert acknowledged", "fingerprint", fingerprint, "alert", alert.Name, "by", by, "escalations", escalations)
+	writeJSON(w, http.StatusOK, alert)
+}
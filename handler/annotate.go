@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/deadman"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// maxAnnotationSize caps the key=value bodies of batch job annotations
+const maxAnnotationSize = 64 << 10
+
+// Priorities of batch job runs
+const (
+	annotationSuccessPriority = 2
+	annotationFailurePriority = 8
+)
+
+// annotationKeys are the fields of an annotation that are not shown as
+// additional fields
+var annotationKeys = map[string]bool{
+	"job": true, "status": true, "exit_status": true, "exit_code": true,
+	"duration": true, "message": true,
+}
+
+// HandleAnnotation handles POST requests to /api/v1/annotate, the run results
+// of cron and batch jobs given as key=value pairs:
+//
+//	job=backup status=0 duration=12.5s host=db1
+//
+// Pairs are read from the query string and the body, separated by
+// whitespace or "&", with URL-encoded values. The run is recorded for the
+// dead man's switch and forwarded as a message.
+func (h *Handler) HandleAnnotation(w http.ResponseWriter, r *http.Request) {
+	log := h.routeLogger(r, routeName(r))
+	log.Info("Received batch job annotation")
+
+	fields, err := readAnnotation(w, r)
+	if err != nil {
+		log.Error("Failed to parse annotation", "error", err)
+		if errors.Is(err, errTooLarge) {
+			http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", maxAnnotationSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid annotation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	run, err := annotationRun(fields)
+	if err != nil {
+		log.Warn("Rejected annotation", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if h.deadman.Record(run) {
+		log.Debug("Recorded batch job run", "job", run.Job, "exit_status", run.ExitStatus, "duration", run.Duration)
+	} else {
+		log.Warn("Batch job run not recorded, too many jobs are tracked", "job", run.Job, "max_jobs", h.config.DeadmanMaxJobs)
+	}
+
+	priority := annotationSuccessPriority
+	title := fmt.Sprintf("✅ %s succeeded", run.Job)
+	if !run.Succeeded() {
+		priority = annotationFailurePriority
+		title = fmt.Sprintf("❌ %s failed with exit status %d", run.Job, run.ExitStatus)
+	}
+	if run.Duration > 0 && run.Succeeded() {
+		title += " in " + run.Duration.String()
+	} else if run.Duration > 0 {
+		title += " after " + run.Duration.String()
+	}
+
+	extras := map[string]interface{}{
+		"job":        run.Job,
+		"exitStatus": run.ExitStatus,
+		"duration":   run.Duration.Seconds(),
+	}
+
+	// Further fields, such as the host, are listed below the message
+	var lines []string
+	if fields.Get("message") != "" {
+		lines = append(lines, fields.Get("message"))
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if !annotationKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		lines = append(lines, key+": "+fields.Get(key))
+		extras[key] = fields.Get(key)
+	}
+
+	h.deliver(w, r, &render.Notification{
+		Route:    routeName(r),
+		Title:    title,
+		Message:  strings.Join(lines, "\n"),
+		Priority: priority,
+		Time:     run.Time,
+		Source:   "annotate",
+		DialogID: requestedDialog(r, ""),
+		Extras:   extras,
+	})
+}
+
+// readAnnotation collects the key=value pairs of the query string and body
+func readAnnotation(w http.ResponseWriter, r *http.Request) (url.Values, error) {
+	fields := r.URL.Query()
+	// Not fields of the annotation
+	fields.Del("token")
+	fields.Del("dialog")
+	fields.Del("echo")
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAnnotationSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errTooLarge
+		}
+		return nil, err
+	}
+
+	pairs := strings.FieldsFunc(string(data), func(c rune) bool {
+		return c == '&' || c == ' ' || c == '\t' || c == '\n' || c == '\r'
+	})
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		value, err := url.QueryUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %w", key, err)
+		}
+		fields.Set(strings.ToLower(key), value)
+	}
+
+	return fields, nil
+}
+
+// annotationRun builds the run described by an annotation
+func annotationRun(fields url.Values) (deadman.Run, error) {
+	run := deadman.Run{Job: strings.TrimSpace(fields.Get("job")), Time: time.Now()}
+	if run.Job == "" {
+		return run, fmt.Errorf("job is required")
+	}
+
+	for _, key := range []string{"status", "exit_status", "exit_code"} {
+		if value := fields.Get(key); value != "" {
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return run, fmt.Errorf("%s must be an integer", key)
+			}
+			run.ExitStatus = status
+			break
+		}
+	}
+
+	// Durations are Go durations such as 1m30s, or seconds
+	if value := fields.Get("duration"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			seconds, serr := strconv.ParseFloat(value, 64)
+			if serr != nil || seconds < 0 {
+				return run, fmt.Errorf("duration must be a duration such as 1m30s or seconds")
+			}
+			d = time.Duration(seconds * float64(time.Second))
+		}
+		run.Duration = d
+	}
+
+	return run, nil
+}
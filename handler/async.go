@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/audit"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var (
+	asyncMessages = metrics.NewCounter("async_send_messages_total",
+		"Notifications accepted with async send, by result: sent, failed or rejected when the buffer was full.", "result")
+	asyncPending = metrics.NewGauge("async_send_pending",
+		"Notifications accepted with async send and not delivered yet.")
+)
+
+// asyncPool delivers notifications accepted with 202 Accepted in the
+// background. It is bounded: when its buffer is full, notifications are
+// turned away rather than piling up in memory.
+type asyncPool struct {
+	jobs    chan func()
+	workers sync.WaitGroup
+
+	// mutex guards jobs against submits after stop
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// newAsyncPool starts workers taking jobs from a buffer of the given size
+func newAsyncPool(workers, buffer int) *asyncPool {
+	p := &asyncPool{jobs: make(chan func(), buffer)}
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go func() {
+			defer p.workers.Done()
+			for job := range p.jobs {
+				job()
+				asyncPending.Add(-1)
+			}
+		}()
+	}
+	return p
+}
+
+// submit hands a job to the workers. It returns false when the buffer is
+// full or the pool was stopped.
+func (p *asyncPool) submit(job func()) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.closed {
+		return false
+	}
+
+	select {
+	case p.jobs <- job:
+		asyncPending.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+// stop delivers the buffered jobs
+func (p *asyncPool) stop(ctx context.Context) error {
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deliverAsync answers a notification with 202 Accepted and a message ID,
+// and leaves its delivery to the workers, so senders with short timeouts do
+// not wait for Mizito. queued is its audit record until it is delivered.
+// When the buffer is full the notification is turned away with 503 Service
+// Unavailable.
+func (h *Handler) deliverAsync(w http.ResponseWriter, r *http.Request, log *logger.Logger, queued *audit.Record, deliverNow func(ctx context.Context, id string) (int, NotificationResponse), echo *DeliveryEcho) {
+	id := contextMessageID(r.Context())
+	queued.ID = id
+	h.recordDelivery(queued)
+	h.messages.Accept(id)
+
+	// The delivery outlives the request; its context keeps the request ID
+	ctx := context.WithoutCancel(r.Context())
+	accepted := h.async.submit(func() {
+		status, response := deliverNow(ctx, id)
+		if !response.Success {
+			asyncMessages.Inc("failed")
+			h.messages.Failed(id, response.Message)
+			log.Warn("Notification accepted with async send was not delivered", "id", id, "status", status, "error", response.Message)
+			return
+		}
+		asyncMessages.Inc("sent")
+		h.messages.Sent(id)
+	})
+	if !accepted {
+		asyncMessages.Inc("rejected")
+		log.Warn("Rejected notification, async send buffer is full", "buffer", h.config.AsyncBuffer)
+		queued.Status = audit.StatusFailed
+		queued.Error = "async send buffer full"
+		h.recordDelivery(queued)
+		h.messages.Failed(id, queued.Error)
+		writeNotification(w, http.StatusServiceUnavailable, NotificationResponse{
+			Success:    false,
+			Message:    "Too many notifications waiting for delivery, try again later",
+			RetryAfter: h.retryAfter(),
+		})
+		return
+	}
+
+	log.Info("Notification accepted for delivery", "id", id)
+	w.Header().Set("Location", h.messagePath(id))
+	writeJSON(w, http.StatusAccepted, NotificationResponse{
+		Success: true,
+		Message: "Notification accepted for delivery",
+		ID:      id,
+		Echo:    echo,
+	})
+}
+
+// StopAsync delivers the notifications accepted with async send that are
+// still waiting. The sinks must be stopped after it, as the notifications
+// are passed to them as well.
+func (h *Handler) StopAsync(ctx context.Context) error {
+	return h.async.stop(ctx)
+}
+
+// asyncRequested reports whether a notification is answered before it is
+// delivered: as asked with ?async=, or else per ASYNC_SEND
+func (h *Handler) asyncRequested(r *http.Request) bool {
+	if async, err := strconv.ParseBool(r.URL.Query().Get("async")); err == nil {
+		return async
+	}
+	return h.config.AsyncSend
+}
+
+// newMessageID returns the message ID of a notification
+func newMessageID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// maxMultipartMemory is how much of a multipart form is buffered in memory;
+// larger files are spooled to temporary files
+const maxMultipartMemory = 8 << 20
+
+// errTooLarge is returned for uploads exceeding MAX_ATTACHMENT_SIZE
+var errTooLarge = errors.New("request body too large")
+
+// isMultipart reports whether a request carries a multipart form
+func isMultipart(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// parseMultipartForm parses a multipart form of at most MAX_ATTACHMENT_SIZE
+// bytes of files; the caller removes its temporary files
+func (h *Handler) parseMultipartForm(w http.ResponseWriter, r *http.Request) error {
+	// Leave room for the text fields next to the files
+	limit := int64(h.config.MaxAttachmentSize) + 1<<20
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return errTooLarge
+		}
+		return err
+	}
+	return nil
+}
+
+// parseMultipartNotification reads a Gotify-style notification from the
+// title, message, priority, dialog and extras (JSON) fields of a multipart
+// form, and every file in it as an attachment
+func (h *Handler) parseMultipartNotification(w http.ResponseWriter, r *http.Request) (*GotifyNotificationRequest, []render.Attachment, error) {
+	if err := h.parseMultipartForm(w, r); err != nil {
+		return nil, nil, err
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	req := &GotifyNotificationRequest{
+		Title:   r.FormValue("title"),
+		Message: r.FormValue("message"),
+		Dialog:  r.FormValue("dialog"),
+		Delay:   Delay(r.FormValue("delay")),
+	}
+	if priority := r.FormValue("priority"); priority != "" {
+		p, err := strconv.Atoi(priority)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid priority %q", priority)
+		}
+		req.Priority = p
+	}
+	if extras := r.FormValue("extras"); extras != "" {
+		if err := json.Unmarshal([]byte(extras), &req.Extras); err != nil {
+			return nil, nil, fmt.Errorf("invalid extras: %w", err)
+		}
+	}
+
+	attachments, err := h.formAttachments(r.MultipartForm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return req, attachments, nil
+}
+
+// formAttachments reads every file of a multipart form as an attachment
+func (h *Handler) formAttachments(form *multipart.Form) ([]render.Attachment, error) {
+	// Attach files in a stable order: by field name, then as sent
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var attachments []render.Attachment
+	size := 0
+	for _, field := range fields {
+		for _, fh := range form.File[field] {
+			f, err := fh.Open()
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			size += len(data)
+			if size > h.config.MaxAttachmentSize {
+				return nil, errTooLarge
+			}
+
+			attachments = append(attachments, render.Attachment{
+				Name:        fh.Filename,
+				ContentType: fh.Header.Get("Content-Type"),
+				Data:        data,
+			})
+		}
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"math/rand"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+// Variants of the template of a route with a candidate template, as
+// recorded in the audit log
+const (
+	TemplateCurrent   = "current"
+	TemplateCandidate = "candidate"
+)
+
+var (
+	candidateRenders = metrics.NewCounter("template_variant_renders_total",
+		"Notifications of routes with a candidate template by route and the variant rendering them: current or candidate.", "route", "variant")
+	candidateErrors = metrics.NewCounter("candidate_template_errors_total",
+		"Candidate templates failing to render, replaced by the current template, by route.", "route")
+)
+
+// templateVariantContextKey is the context key holding the template variant
+// that rendered a notification
+type templateVariantContextKey struct{}
+
+// withTemplateVariant stores the template variant in the context, so the
+// records of the delivery tell which variant rendered the text
+func withTemplateVariant(ctx context.Context, variant string) context.Context {
+	if variant == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, templateVariantContextKey{}, variant)
+}
+
+// templateVariant returns the template variant that rendered the
+// notification delivered with ctx, empty for routes without a candidate
+func templateVariant(ctx context.Context) string {
+	variant, _ := ctx.Value(templateVariantContextKey{}).(string)
+	return variant
+}
+
+// chooseVariant picks the template variant of a notification of a route:
+// the candidate for CANDIDATE_PERCENT percent of them, the current template
+// for the others, or none for routes without a candidate
+func (h *Handler) chooseVariant(route string) string {
+	if _, ok := h.candidateTemplates[route]; !ok {
+		return ""
+	}
+	if rand.Float64()*100 < h.config.Route(route).CandidatePercent {
+		return TemplateCandidate
+	}
+	return TemplateCurrent
+}
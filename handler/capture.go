@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/capture"
+	"github.com/gorilla/mux"
+)
+
+// maxBodySize is the largest inbound body a route accepts: a notification
+// or a multipart form of attachments. Middlewares reading whole bodies
+// refuse larger ones.
+func (h *Handler) maxBodySize() int64 {
+	if limit := int64(h.config.MaxAttachmentSize) + 1<<20; limit > maxNotificationBodySize {
+		return limit
+	}
+	return maxNotificationBodySize
+}
+
+// readBody reads a request body of at most limit bytes, failing with
+// errTooLarge for larger ones rather than truncating them
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	r.Body.Close()
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errTooLarge
+		}
+		return nil, err
+	}
+	return body, nil
+}
+
+// writeBodyError answers a request whose body could not be read by
+// readBody
+func writeBodyError(w http.ResponseWriter, err error, limit int64) {
+	if errors.Is(err, errTooLarge) {
+		http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Failed to read request body", http.StatusBadRequest)
+}
+
+// captureMiddleware stores the raw inbound request when capture is enabled for the route
+func (h *Handler) captureMiddleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.config.Route(route).Capture {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := h.maxBodySize()
+		body, err := readBody(w, r, limit)
+		if err != nil {
+			h.logger.WithContext(r.Context()).Error("Failed to read request body for capture", "route", route, "error", err)
+			writeBodyError(w, err, limit)
+			return
+		}
+
+		// Restore the body for the actual route handler
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if err := h.captures.Save(capture.NewRecord(route, r, body)); err != nil {
+			h.logger.WithContext(r.Context()).Error("Failed to store captured payload", "route", route, "error", err)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Pages of captured payloads listed by ListCaptures
+const (
+	defaultCapturePage = 50
+	maxCapturePage     = 500
+)
+
+// ListCaptures handles GET requests to /api/v1/captures. It lists a page of
+// captured payloads, newest first, chosen by the limit and offset query
+// parameters; X-Total-Count tells how many are stored.
+func (h *Handler) ListCaptures(w http.ResponseWriter, r *http.Request) {
+	limit, offset := defaultCapturePage, 0
+	query := r.URL.Query()
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxCapturePage {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxCapturePage), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must not be negative", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	records, total, err := h.captures.List(offset, limit)
+	if err != nil {
+		h.logger.WithContext(r.Context()).Error("Failed to list captured payloads", "error", err)
+		http.Error(w, "Failed to list captures", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, records)
+}
+
+// GetCapture handles GET requests to /api/v1/captures/{id}
+func (h *Handler) GetCapture(w http.ResponseWriter, r *http.Request) {
+	rec, ok := h.loadCapture(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// ReplayCapture handles POST requests to /api/v1/captures/{id}/replay.
+// The stored request is dispatched to its route handler again, bypassing
+// authentication and capturing, and the route's response is returned as is.
+func (h *Handler) ReplayCapture(w http.ResponseWriter, r *http.Request) {
+	rec, ok := h.loadCapture(w, r)
+	if !ok {
+		return
+	}
+
+	routeHandler, exists := h.handlers[rec.Route]
+	if !exists {
+		http.Error(w, "Route no longer exists: "+rec.Route, http.StatusUnprocessableEntity)
+		return
+	}
+
+	body, err := rec.RawBody()
+	if err != nil {
+		http.Error(w, "Captured body is corrupt", http.StatusUnprocessableEntity)
+		return
+	}
+
+	target := rec.Path
+	if rec.Query != "" {
+		target += "?" + rec.Query
+	}
+
+	replay, err := http.NewRequestWithContext(r.Context(), rec.Method, target, bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, "Captured request is invalid", http.StatusUnprocessableEntity)
+		return
+	}
+	replay.RemoteAddr = r.RemoteAddr
+
+	// Restore the target dialog of /api/v1/messages/{dialog} requests
+	if _, dialogID, ok := strings.Cut(rec.Path, "/api/v1/messages/"); ok {
+		replay = mux.SetURLVars(replay, map[string]string{"dialog": dialogID})
+	} else {
+		replay = mux.SetURLVars(replay, nil)
+	}
+	for name, values := range rec.Headers {
+		for _, value := range values {
+			replay.Header.Add(name, value)
+		}
+	}
+
+	h.logger.WithContext(r.Context()).Info("Replaying captured payload", "id", rec.ID, "route", rec.Route)
+	routeHandler.ServeHTTP(w, replay)
+}
+
+// loadCapture reads the capture referenced by the {id} path variable
+func (h *Handler) loadCapture(w http.ResponseWriter, r *http.Request) (*capture.Record, bool) {
+	id := mux.Vars(r)["id"]
+
+	rec, err := h.captures.Get(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Capture not found", http.StatusNotFound)
+			return nil, false
+		}
+		h.logger.WithContext(r.Context()).Error("Failed to read captured payload", "id", id, "error", err)
+		http.Error(w, "Failed to read capture", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return rec, true
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	// Notification responses carry the request ID set by the logging middleware
+	if res, ok := v.(NotificationResponse); ok && res.RequestID == "" {
+		res.RequestID = w.Header().Get("X-Request-ID")
+		v = res
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/queue"
+	"github.com/gorilla/mux"
+)
+
+// ListDeadLetters handles GET requests to /api/v1/deadletter. It lists the
+// queued notifications that exhausted their delivery attempts.
+func (h *Handler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := h.queue.DeadLetters()
+	if err != nil {
+		h.logger.WithContext(r.Context()).Error("Failed to list dead letters", "error", err)
+		http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, letters)
+}
+
+// GetDeadLetter handles GET requests to /api/v1/deadletter/{id}
+func (h *Handler) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	dead, ok := h.loadDeadLetter(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dead)
+}
+
+// ResendDeadLetter handles POST requests to /api/v1/deadletter/{id}/resend.
+// The notification is queued again with its attempts reset.
+func (h *Handler) ResendDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	job, err := h.queue.Resend(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		h.logger.WithContext(r.Context()).Error("Failed to resend dead letter", "id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: "Failed to queue notification: " + err.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Location", h.jobPath(job.ID))
+	writeJSON(w, http.StatusAccepted, NotificationResponse{
+		Success: true,
+		Message: "Notification queued for delivery",
+		ID:      job.ID,
+	})
+}
+
+// PurgeDeadLetter handles DELETE requests to /api/v1/deadletter/{id}
+func (h *Handler) PurgeDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := h.queue.Purge(id); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		h.logger.WithContext(r.Context()).Error("Failed to purge dead letter", "id", id, "error", err)
+		http.Error(w, "Failed to purge dead letter", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NotificationResponse{
+		Success: true,
+		Message: "Dead letter purged",
+		ID:      id,
+	})
+}
+
+// PurgeDeadLetters handles DELETE requests to /api/v1/deadletter and
+// purges all dead letters
+func (h *Handler) PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	purged, err := h.queue.PurgeAll()
+	if err != nil {
+		h.logger.WithContext(r.Context()).Error("Failed to purge dead letters", "purged", purged, "error", err)
+		http.Error(w, "Failed to purge dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NotificationResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d dead letters purged", purged),
+	})
+}
+
+// loadDeadLetter reads the dead letter named by the id route variable,
+// writing an error response if it cannot be read
+func (h *Handler) loadDeadLetter(w http.ResponseWriter, r *http.Request) (*queue.DeadLetter, bool) {
+	id := mux.Vars(r)["id"]
+
+	dead, err := h.queue.DeadLetter(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return nil, false
+		}
+		h.logger.WithContext(r.Context()).Error("Failed to read dead letter", "id", id, "error", err)
+		http.Error(w, "Failed to read dead letter", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return dead, true
+}
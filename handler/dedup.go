@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/dedup"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+var duplicates = metrics.NewCounter("dedup_notifications_suppressed_total",
+	"Notifications dropped as duplicates within the dedup window, by route.", "route")
+
+// dedupHeader names the key identifying duplicates of a notification,
+// instead of its title and message
+const dedupHeader = "X-Dedup-Key"
+
+// requestDedupKey returns the dedup key named by the sender, if any
+func requestDedupKey(r *http.Request) string {
+	if key := r.Header.Get(dedupHeader); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("dedup_key")
+}
+
+// duplicate reports how often a notification arrived within the dedup
+// window of its route, 1 for the first one, which is delivered. With
+// DEDUP_MODE=count, the notification is delivered again with the count
+// when the window closes, if it arrived more than once. rule names the
+// routing rule whose template rendered it, if any.
+func (h *Handler) duplicate(r *http.Request, log *logger.Logger, account *mizito.Account, n *render.Notification, msg *mizito.Message, rule string) int {
+	window := h.config.RouteDedupWindow(n.Route)
+	if window <= 0 {
+		return 1
+	}
+
+	key := requestDedupKey(r)
+	if key != "" {
+		key = dedup.Key(account.Name, n.Route, msg.DialogID, key)
+	} else {
+		key = dedup.Key(account.Name, n.Route, msg.DialogID, n.Title, n.Message)
+	}
+
+	var closed func(count int)
+	if h.config.DedupMode == config.DedupCount {
+		// The repeat outlives the request, and possibly its configuration,
+		// so it is delivered by the handler current when the window closes;
+		// its context keeps the request ID
+		ctx := context.WithoutCancel(r.Context())
+		dialogID := msg.DialogID
+		closed = func(count int) {
+			current, done := h.currentHandler()
+			defer done()
+			current.deliverRepeated(ctx, log, account, n, dialogID, rule, count)
+		}
+	}
+
+	count := h.duplicates.Seen(key, window, closed)
+	if count > 1 {
+		duplicates.Inc(n.Route)
+		log.Info("Suppressed duplicate notification", "route", n.Route, "count", count, "window", window)
+	}
+	return count
+}
+
+// deliverRepeated renders a notification again with the templates of this
+// handler and delivers it to dialogID with the number of times it arrived
+// within the dedup window appended, without its attachments
+func (h *Handler) deliverRepeated(ctx context.Context, log *logger.Logger, account *mizito.Account, n *render.Notification, dialogID, rule string, count int) {
+	// The repeat is a notification of its own, received now
+	repeatedN := *n
+	repeatedN.Time = time.Now()
+	repeatedN.Attachments = nil
+
+	text, _, _, err := h.renderText(ctx, &repeatedN, dialogID, rule)
+	if err != nil {
+		log.Error("Failed to render count of duplicate notifications", "route", n.Route, "error", err)
+		return
+	}
+
+	repeated := &mizito.Message{
+		Text:       fmt.Sprintf("%s (x%d)", text, count),
+		Priority:   n.Priority,
+		DialogID:   dialogID,
+		FromUserID: h.config.Route(n.Route).FromUserID,
+	}
+	repeatedSink := sinkMessage(ctx, &repeatedN, repeated.Text, dialogID)
+
+	log.Info("Delivering count of duplicate notifications", "route", n.Route, "count", count)
+
+	policy, chain := h.deliveryChain(n.Route)
+	switch {
+	case policy == config.DeliveryFirstSuccess:
+		h.deliverChain(ctx, log, "", account, &repeatedN, repeated, repeatedSink, chain)
+	case h.queue != nil:
+		if _, err := h.enqueue(ctx, log, account, &repeatedN, repeated, repeatedSink); err != nil {
+			log.Error("Failed to queue count of duplicate notifications", "error", err)
+		}
+	default:
+		h.send(ctx, log, "", account, &repeatedN, repeated, repeatedSink)
+	}
+}
@@ -0,0 +1,580 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/audit"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/queue"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/reporting"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/sink"
+	"github.com/gorilla/mux"
+)
+
+// routeContextKey is the context key holding the name of the matched route
+type routeContextKey struct{}
+
+// withRoute stores the route name in the request context
+func withRoute(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeContextKey{}, name)))
+	})
+}
+
+// routeName returns the name of the route serving the request
+func routeName(r *http.Request) string {
+	name, _ := r.Context().Value(routeContextKey{}).(string)
+	return name
+}
+
+// requestedDialog returns the dialog a request targets explicitly: the
+// {dialog} path variable, the dialog query parameter or the given body field
+func requestedDialog(r *http.Request, body string) string {
+	if dialogID := mux.Vars(r)["dialog"]; dialogID != "" {
+		return dialogID
+	}
+	if dialogID := r.URL.Query().Get("dialog"); dialogID != "" {
+		return dialogID
+	}
+	return body
+}
+
+// routeDialog returns the dialog of a notification not targeting one
+// explicitly: the DIALOG_ID of its route, the dialog created for the route,
+// or the dialog routed by priority
+func (h *Handler) routeDialog(ctx context.Context, account *mizito.Account, n *render.Notification) (string, error) {
+	switch dialogID := h.config.RouteDialog(n.Route); dialogID {
+	case "":
+		return account.Messages.DialogForPriority(n.Priority), nil
+	case config.DialogAuto:
+		return account.Messages.RouteDialog(ctx, n.Route, h.config.DialogName(n.Route))
+	default:
+		return dialogID, nil
+	}
+}
+
+// loadSummaries compiles the summary templates configured for routes
+func (h *Handler) loadSummaries() error {
+	for name, rc := range h.config.Routes {
+		if rc.Summary == "" {
+			continue
+		}
+
+		tmpl, err := render.Parse(name+" summary", rc.Summary, h.userFuncs())
+		if err != nil {
+			return fmt.Errorf("route %s: %w", name, err)
+		}
+
+		h.summaries[name] = tmpl
+	}
+
+	return nil
+}
+
+// loadTemplates compiles MESSAGE_TEMPLATE and the message, candidate and
+// off-hours templates configured for routes
+func (h *Handler) loadTemplates() error {
+	if h.config.MessageTemplate != "" {
+		tmpl, err := render.Parse("message", h.config.MessageTemplate, h.userFuncs())
+		if err != nil {
+			return fmt.Errorf("MESSAGE_TEMPLATE: %w", err)
+		}
+		h.defaultTemplate = tmpl
+	}
+
+	h.offHoursTemplates = make(map[string]*template.Template)
+	h.candidateTemplates = make(map[string]*template.Template)
+	for name, rc := range h.config.Routes {
+		if rc.Template != "" {
+			tmpl, err := render.Parse(name+" message", rc.Template, h.userFuncs())
+			if err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+			h.templates[name] = tmpl
+		}
+
+		if rc.OffHoursTemplate != "" {
+			tmpl, err := render.Parse(name+" off-hours message", rc.OffHoursTemplate, h.userFuncs())
+			if err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+			h.offHoursTemplates[name] = tmpl
+		}
+
+		if rc.CandidateTemplate != "" {
+			tmpl, err := render.Parse(name+" candidate message", rc.CandidateTemplate, h.userFuncs())
+			if err != nil {
+				return fmt.Errorf("route %s: %w", name, err)
+			}
+			h.candidateTemplates[name] = tmpl
+		}
+	}
+
+	return nil
+}
+
+// currentTemplate returns the template of a route's notifications sent to
+// a dialog of a profile: the template of the profile, so each dialog gets
+// the detail its readers want, the message template of the route, or
+// MESSAGE_TEMPLATE. source names its setting, empty for the plain text.
+func (h *Handler) currentTemplate(route, profileName string) (tmpl *template.Template, source string) {
+	if tmpl, ok := h.profileTemplates[profileName]; ok {
+		return tmpl, "PROFILE_" + strings.ToUpper(profileName) + "_TEMPLATE"
+	}
+	if tmpl, ok := h.templates[route]; ok {
+		return tmpl, "ROUTE_" + strings.ToUpper(route) + "_TEMPLATE"
+	}
+	if h.defaultTemplate != nil {
+		return h.defaultTemplate, "MESSAGE_TEMPLATE"
+	}
+	return nil, ""
+}
+
+// renderText builds the final message text for a notification sent to
+// dialogID. rule names the routing rule whose template applies, if any.
+// source names the setting of the template used, empty for the plain text.
+// variant tells whether the current or the candidate template of the route
+// rendered it, empty when the route has no candidate or another template
+// applied.
+func (h *Handler) renderText(ctx context.Context, n *render.Notification, dialogID, rule string) (text, source, variant string, err error) {
+	profileName, profile := h.config.DialogProfile(dialogID)
+	offHours := h.config.OffHours(n.Time)
+
+	// Templates write numbers in the language of the dialog's profile
+	localized := *n
+	localized.Language = ""
+	if profile != nil {
+		localized.Language = profile.Language
+	}
+	n = &localized
+
+	// Render the template of the routing rule, the off-hours template of
+	// the route, its candidate template for its share of the traffic unless
+	// the dialog's profile has a template, or the current template
+	tmpl, ok := h.ruleTemplates[rule]
+	source = "RULES_FILE:" + rule
+	if !ok && offHours {
+		tmpl, ok = h.offHoursTemplates[n.Route]
+		source = "ROUTE_" + strings.ToUpper(n.Route) + "_OFF_HOURS_TEMPLATE"
+	}
+	if _, profiled := h.profileTemplates[profileName]; !ok && !profiled {
+		variant = h.chooseVariant(n.Route)
+		if variant == TemplateCandidate {
+			tmpl, ok = h.candidateTemplates[n.Route], true
+			source = "ROUTE_" + strings.ToUpper(n.Route) + "_CANDIDATE_TEMPLATE"
+		}
+	}
+	if !ok {
+		tmpl, source = h.currentTemplate(n.Route, profileName)
+	}
+
+	text, err = executeTemplate(tmpl, n)
+	if err != nil && variant == TemplateCandidate {
+		// A failing candidate does not cost the notification
+		h.logger.WithContext(ctx).Warn("Candidate template failed, rendering with the current one", "route", n.Route, "error", err)
+		candidateErrors.Inc(n.Route)
+		variant = TemplateCurrent
+		tmpl, source = h.currentTemplate(n.Route, profileName)
+		text, err = executeTemplate(tmpl, n)
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	if variant != "" {
+		candidateRenders.Inc(n.Route, variant)
+	}
+
+	// Prepend the summary line used for previews
+	if tmpl, ok := h.summaries[n.Route]; ok {
+		summary, err := render.Summary(tmpl, n, h.config.Route(n.Route).PreviewLength)
+		if err != nil {
+			return "", "", "", err
+		}
+		if summary != "" {
+			text = summary + "\n" + text
+		}
+	}
+
+	// Annotate IP addresses with their host name and country
+	if h.enricher != nil {
+		text = h.enricher.Enrich(ctx, text)
+	}
+
+	// Normalize Unicode and mixed-direction text
+	if h.config.NormalizeText {
+		text = render.Normalize(text, h.config.NormalizeBidi)
+	}
+
+	if profile != nil {
+		text = applyProfile(profile, n, text)
+	}
+
+	// Off hours, the people on call are mentioned
+	if offHours {
+		var mentions []string
+		for _, user := range h.config.RouteOffHoursMentions(n.Route) {
+			mentions = append(mentions, h.mention(user))
+		}
+		if len(mentions) > 0 {
+			text += "\n" + strings.Join(mentions, " ")
+		}
+	}
+
+	return text, source, variant, nil
+}
+
+// executeTemplate renders the text of a notification with a template, or
+// as "Title: Message" without one
+func executeTemplate(tmpl *template.Template, n *render.Notification) (string, error) {
+	if tmpl == nil {
+		return n.Text(), nil
+	}
+
+	rendered, err := render.Execute(tmpl, n)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(rendered), nil
+}
+
+// echo describes the delivered message in the response when the sender
+// asked for it with echo=true, or RESPONSE_ECHO is enabled
+func (h *Handler) echo(r *http.Request, n *render.Notification, text, dialogID, source string) *DeliveryEcho {
+	requested, _ := strconv.ParseBool(r.URL.Query().Get("echo"))
+	if !requested && !h.config.ResponseEcho {
+		return nil
+	}
+
+	return &DeliveryEcho{
+		Route:    n.Route,
+		Text:     text,
+		DialogID: dialogID,
+		Priority: n.Priority,
+		Template: source,
+	}
+}
+
+// deliver renders a notification, forwards it to Mizito and writes the response
+func (h *Handler) deliver(w http.ResponseWriter, r *http.Request, n *render.Notification) {
+	log := h.routeLogger(r, n.Route)
+
+	// A priority header overrides the priority of the payload, but for the
+	// RFC 9218 urgency a browser may have added, which only applies to
+	// notifications without a priority of their own
+	priority, urgency, ok, err := headerPriority(r)
+	if err != nil {
+		log.Warn("Rejected notification with invalid priority header", "route", n.Route, "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ok && urgency && n.Priority != 0 {
+		log.Debug("Urgency of priority header ignored for notification with a priority", "route", n.Route, "priority", n.Priority)
+	} else if ok {
+		log.Debug("Priority set by request header", "route", n.Route, "payload_priority", n.Priority, "priority", priority)
+		n.Priority = priority
+	}
+
+	account, accountName := h.requestedAccount(r, n.Route)
+	if account == nil {
+		log.Warn("Rejected notification for unknown account", "route", n.Route, "account", accountName)
+		writeJSON(w, http.StatusBadRequest, NotificationResponse{
+			Success: false,
+			Message: "Unknown account: " + accountName,
+		})
+		return
+	}
+
+	// Only allowlisted dialogs of the account may be targeted explicitly
+	if n.DialogID != "" && !h.accountConfig(account).DialogAllowed(n.DialogID) {
+		log.Warn("Rejected notification for dialog not in allowlist", "route", n.Route, "dialog", n.DialogID)
+		writeJSON(w, http.StatusForbidden, NotificationResponse{
+			Success: false,
+			Message: "Dialog is not allowed: " + n.DialogID,
+		})
+		return
+	}
+
+	// Delayed notifications go through the rest of the pipeline when due
+	deliverAt, delayed, err := h.requestedDelay(r)
+	if err != nil {
+		log.Warn("Rejected notification with invalid delay", "route", n.Route, "error", err)
+		writeJSON(w, http.StatusBadRequest, NotificationResponse{Success: false, Message: err.Error()})
+		return
+	}
+	if delayed {
+		// Only masked content is kept on the schedule
+		h.applyPolicy(log, n)
+		h.delay(w, r, log, account, n, deliverAt)
+		return
+	}
+
+	// Routing rules may drop the notification or choose its dialog and template
+	decision := h.applyRules(r, log, n)
+	if decision.Drop {
+		message := "Notification dropped by rule " + decision.DroppedBy
+		if decision.RateLimited {
+			message = "Notification dropped by rate limit of rule " + decision.DroppedBy
+		}
+		writeJSON(w, http.StatusOK, NotificationResponse{Success: true, Message: message})
+		return
+	}
+
+	// Enforce the content policy before anything is rendered or stored
+	h.applyPolicy(log, n)
+
+	// The dialog is resolved first, as its formatting profile shapes the
+	// text: the dialog of the request, of a routing rule, of off hours, or
+	// of the route
+	dialogID := n.DialogID
+	if dialogID == "" {
+		dialogID = decision.Dialog
+	}
+	if dialogID == "" && h.config.OffHours(n.Time) {
+		dialogID = h.config.RouteOffHoursDialog(n.Route)
+	}
+	if dialogID == "" {
+		if dialogID, err = h.routeDialog(r.Context(), account, n); err != nil {
+			log.Error("Failed to resolve dialog of route", "route", n.Route, "error", err)
+			status, response := h.sendFailure(account, err)
+			writeNotification(w, status, response)
+			return
+		}
+	}
+
+	notificationText, templateSource, variant, err := h.renderText(r.Context(), n, dialogID, decision.TemplateRule)
+	if err != nil {
+		log.Error("Failed to render notification", "route", n.Route, "error", err)
+		writeJSON(w, http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: "Failed to render notification: " + err.Error(),
+		})
+		return
+	}
+
+	// The records of the delivery tell which template variant rendered it
+	r = r.WithContext(withTemplateVariant(r.Context(), variant))
+
+	// Send message to Mizito
+	log.Info("Sending notification to Mizito", "combined_message", notificationText)
+
+	sinkMsg := sinkMessage(r.Context(), n, notificationText, dialogID)
+	msg := &mizito.Message{
+		Text:        notificationText,
+		Priority:    n.Priority,
+		DialogID:    dialogID,
+		FromUserID:  h.config.Route(n.Route).FromUserID,
+		Attachments: n.Attachments,
+	}
+
+	echo := h.echo(r, n, notificationText, dialogID, templateSource)
+	if echo != nil {
+		echo.Rules = decision.Rules
+		echo.OffHours = h.config.OffHours(n.Time)
+	}
+
+	// Re-fires of a snoozed alert are held back
+	alertKey := snoozeKey(r, account, n, dialogID)
+	if until, ok := h.snoozedUntil(log, alertKey, n.Route); ok {
+		writeJSON(w, http.StatusOK, NotificationResponse{
+			Success:      true,
+			Message:      "Notification of snoozed alert held back",
+			SnoozedUntil: &until,
+		})
+		return
+	}
+
+	// Repeats of a notification within the dedup window are dropped
+	if count := h.duplicate(r, log, account, n, msg, decision.TemplateRule); count > 1 {
+		writeJSON(w, http.StatusOK, NotificationResponse{
+			Success:    true,
+			Message:    "Duplicate notification suppressed",
+			Duplicates: count,
+		})
+		return
+	}
+
+	// The message ID generated here answers the sender and names the
+	// notification to the status and snooze endpoints, whichever way it is
+	// delivered
+	id := newMessageID()
+	r = r.WithContext(withMessageID(r.Context(), id))
+	h.rememberSnoozable(r.Context(), alertKey, account, n, dialogID)
+
+	// Notifications of moderated routes wait for a moderator's approval
+	if h.config.Route(n.Route).Moderated {
+		status, response := h.hold(r.Context(), log, account, n, msg)
+		writeJSON(w, status, response)
+		return
+	}
+
+	// Low-priority notifications to dialogs in quiet hours wait for a digest
+	if window, quiet := h.quietHours(log, n, dialogID); quiet != nil {
+		status, response := h.buffer(r.Context(), log, window, quiet, account, n, msg)
+		writeJSON(w, status, response)
+		return
+	}
+
+	// Notifications not handed to the queue are delivered right away: through
+	// the delivery chain on first-success routes, or else to the sinks and
+	// Mizito
+	policy, chain := h.deliveryChain(n.Route)
+	if policy == config.DeliveryFirstSuccess || h.queue == nil {
+		deliverNow := func(ctx context.Context, id string) (int, NotificationResponse) {
+			if policy == config.DeliveryFirstSuccess {
+				return h.deliverChain(ctx, log, id, account, n, msg, sinkMsg, chain)
+			}
+			return h.send(ctx, log, id, account, n, msg, sinkMsg)
+		}
+
+		if h.asyncRequested(r) {
+			h.deliverAsync(w, r, log, &audit.Record{
+				Route:    n.Route,
+				DialogID: dialogID,
+				Priority: n.Priority,
+				Text:     notificationText,
+				Template: variant,
+				Status:   audit.StatusQueued,
+			}, deliverNow, echo)
+			return
+		}
+
+		h.messages.Accept(id)
+		status, response := deliverNow(r.Context(), id)
+		h.track(id, response)
+		response.ID = id
+		if response.Success {
+			response.Echo = echo
+		}
+		writeNotification(w, status, response)
+		return
+	}
+
+	id, err = h.enqueue(r.Context(), log, account, n, msg, sinkMsg)
+	if err != nil {
+		log.Error("Failed to queue message", "error", err)
+		writeJSON(w, http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: "Failed to queue notification: " + err.Error(),
+		})
+		return
+	}
+
+	// Accepted but not delivered yet; the job URL tells when it is
+	w.Header().Set("Location", h.jobPath(id))
+	writeJSON(w, http.StatusAccepted, NotificationResponse{
+		Success: true,
+		Message: "Notification queued for delivery",
+		ID:      id,
+		Echo:    echo,
+	})
+}
+
+// applyPolicy enforces the content policy on a notification
+func (h *Handler) applyPolicy(log *logger.Logger, n *render.Notification) {
+	if masked := h.policy.Apply(n); masked > 0 {
+		log.Warn("Masked sensitive content in notification", "route", n.Route, "occurrences", masked)
+	}
+}
+
+// enqueue hands a notification to the sinks and the persistent queue and
+// returns the ID of its job, the message ID of the notification when it has
+// one
+func (h *Handler) enqueue(ctx context.Context, log *logger.Logger, account *mizito.Account, n *render.Notification, msg *mizito.Message, sinkMsg *sink.Message) (string, error) {
+	// Local consumers get the notification whatever becomes of it in Mizito
+	outcomes := h.fanOut(ctx, log, sinkMsg, nil)
+
+	job := &queue.Job{
+		ID:          contextMessageID(ctx),
+		Text:        msg.Text,
+		Priority:    msg.Priority,
+		DialogID:    msg.DialogID,
+		Route:       n.Route,
+		Account:     account.Name,
+		FromUserID:  msg.FromUserID,
+		RequestID:   logger.RequestID(ctx),
+		Template:    templateVariant(ctx),
+		Attachments: msg.Attachments,
+	}
+	if err := h.queue.Enqueue(job); err != nil {
+		return "", err
+	}
+
+	log.Info("Notification queued for delivery", "id", job.ID)
+	h.recordDelivery(&audit.Record{
+		ID:       job.ID,
+		Route:    n.Route,
+		DialogID: msg.DialogID,
+		Priority: n.Priority,
+		Text:     msg.Text,
+		Template: job.Template,
+		Status:   audit.StatusQueued,
+		Sinks:    outcomes,
+	})
+	return job.ID, nil
+}
+
+// send delivers a notification to the sinks and Mizito, handing it to the
+// failover sinks when Mizito fails, and returns the response. id is the
+// message ID of the notification, empty for digests.
+func (h *Handler) send(ctx context.Context, log *logger.Logger, id string, account *mizito.Account, n *render.Notification, msg *mizito.Message, sinkMsg *sink.Message) (int, NotificationResponse) {
+	// Local consumers get the notification whatever becomes of it in Mizito
+	outcomes := h.fanOut(ctx, log, sinkMsg, nil)
+
+	record := &audit.Record{
+		ID:       id,
+		Route:    n.Route,
+		DialogID: msg.DialogID,
+		Priority: n.Priority,
+		Text:     msg.Text,
+		Template: templateVariant(ctx),
+		Status:   audit.StatusSent,
+		Sinks:    outcomes,
+	}
+
+	err := account.Messages.Send(ctx, msg)
+	h.slo.Record(n.Route, err == nil, time.Since(n.Time))
+	if err != nil {
+		record.Status = audit.StatusFailed
+		record.Error = err.Error()
+
+		if errors.Is(err, mizito.ErrRateLimited) {
+			h.recordDelivery(record)
+			return h.sendFailure(account, err)
+		}
+
+		log.Error("Failed to send message to Mizito", "error", err)
+		record.Sinks = append(record.Sinks, h.failOver(ctx, log, sinkMsg)...)
+		h.recordDelivery(record)
+		reporting.CaptureError(ctx, err, map[string]string{"operation": "send", "route": n.Route})
+		return h.sendFailure(account, err)
+	}
+
+	h.recordDelivery(record)
+	h.rememberSent(ctx, msg)
+	log.Info("Notification processed successfully")
+
+	return http.StatusOK, NotificationResponse{
+		Success: true,
+		Message: "Notification sent successfully",
+	}
+}
+
+// recordDelivery appends a record to the audit log when it is enabled
+func (h *Handler) recordDelivery(rec *audit.Record) {
+	if h.audit == nil {
+		return
+	}
+	if err := h.audit.Append(rec); err != nil {
+		h.logger.Error("Failed to write audit record", "error", err)
+	}
+}
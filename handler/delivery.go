@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/audit"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/reporting"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/sink"
+)
+
+var chainDeliveries = metrics.NewCounter("delivery_chain_notifications_total",
+	"Notifications of first-success routes, by route and the target that took them, or none.", "route", "target")
+
+// checkDelivery makes sure delivery chains and retry budgets only name
+// Mizito and configured sinks. The phone sink escalates on its own and
+// cannot be part of a chain.
+func (h *Handler) checkDelivery() error {
+	known := map[string]bool{config.DeliveryMizito: true}
+	for _, s := range h.sinks {
+		_, phone := s.(*sink.Phone)
+		known[s.Name()] = !phone
+	}
+
+	check := func(setting string, chain []string) error {
+		for _, target := range chain {
+			if !known[target] {
+				return fmt.Errorf("%s: %q is not Mizito or a configured sink", setting, target)
+			}
+		}
+		return nil
+	}
+	if err := check("DELIVERY_CHAIN", h.config.DeliveryChain); err != nil {
+		return err
+	}
+	for name, rc := range h.config.Routes {
+		if err := check("ROUTE_"+strings.ToUpper(name)+"_DELIVERY_CHAIN", rc.DeliveryChain); err != nil {
+			return err
+		}
+	}
+
+	for name := range h.config.SinkRetries {
+		if _, ok := known[name]; !ok {
+			return fmt.Errorf("SINK_RETRIES: %q is not a configured sink", name)
+		}
+	}
+	return nil
+}
+
+// deliveryChain returns the delivery policy of a route and, for
+// first-success routes, the targets to try in order: the configured chain,
+// or Mizito followed by the failover sinks
+func (h *Handler) deliveryChain(route string) (string, []string) {
+	policy, chain := h.config.RouteDelivery(route)
+	if policy != config.DeliveryFirstSuccess || len(chain) > 0 {
+		return policy, chain
+	}
+
+	chain = []string{config.DeliveryMizito}
+	for _, s := range h.sinks {
+		if isFailover(s) {
+			chain = append(chain, s.Name())
+		}
+	}
+	return policy, chain
+}
+
+// deliverChain delivers a notification of a first-success route and returns
+// the response: the targets of its chain are tried in order until one takes
+// it, and the others are skipped. Sinks outside the chain mirror it as
+// usual. The queue is not used, as the chain itself is the fallback. id is
+// the message ID of notifications accepted with async send.
+func (h *Handler) deliverChain(ctx context.Context, log *logger.Logger, id string, account *mizito.Account, n *render.Notification, msg *mizito.Message, sinkMsg *sink.Message, chain []string) (int, NotificationResponse) {
+	inChain := make(map[string]bool, len(chain))
+	for _, target := range chain {
+		inChain[target] = true
+	}
+	outcomes := h.fanOut(ctx, log, sinkMsg, inChain)
+
+	var deliveredBy string
+	var failures []string
+	for _, target := range chain {
+		if deliveredBy != "" {
+			outcomes = append(outcomes, audit.SinkOutcome{Sink: target, Status: audit.StatusSkipped})
+			continue
+		}
+
+		var outcome audit.SinkOutcome
+		if target == config.DeliveryMizito {
+			outcome = h.sendToMizito(ctx, log, account, msg)
+		} else {
+			outcome = h.sendToSink(ctx, log, h.sink(target), sinkMsg, true)
+		}
+		outcomes = append(outcomes, outcome)
+
+		switch outcome.Status {
+		case audit.StatusSent:
+			deliveredBy = target
+		case audit.StatusFailed:
+			failures = append(failures, target+": "+outcome.Error)
+		}
+	}
+
+	record := &audit.Record{
+		ID:       id,
+		Route:    n.Route,
+		DialogID: msg.DialogID,
+		Priority: n.Priority,
+		Text:     sinkMsg.Text,
+		Template: templateVariant(ctx),
+		Status:   audit.StatusSent,
+		Sinks:    outcomes,
+	}
+
+	h.slo.Record(n.Route, deliveredBy != "", time.Since(n.Time))
+
+	if deliveredBy == "" {
+		chainDeliveries.Inc(n.Route, "none")
+		err := errors.New("no target took the notification")
+		if len(failures) > 0 {
+			err = errors.New(strings.Join(failures, "; "))
+		}
+		record.Status = audit.StatusFailed
+		record.Error = err.Error()
+		h.recordDelivery(record)
+
+		log.Error("Failed to deliver notification through its delivery chain", "chain", strings.Join(chain, ","), "error", err)
+		reporting.CaptureError(ctx, err, map[string]string{"operation": "deliver", "route": n.Route})
+		return http.StatusServiceUnavailable, NotificationResponse{
+			Success:    false,
+			Message:    "Failed to deliver notification: " + err.Error(),
+			RetryAfter: h.retryAfter(),
+		}
+	}
+
+	chainDeliveries.Inc(n.Route, deliveredBy)
+	h.recordDelivery(record)
+
+	log.Info("Notification processed successfully", "delivered_by", deliveredBy)
+
+	return http.StatusOK, NotificationResponse{
+		Success:     true,
+		Message:     "Notification delivered by " + deliveredBy,
+		DeliveredBy: deliveredBy,
+	}
+}
+
+// sendToMizito sends a message of a delivery chain to Mizito
+func (h *Handler) sendToMizito(ctx context.Context, log *logger.Logger, account *mizito.Account, msg *mizito.Message) audit.SinkOutcome {
+	outcome := audit.SinkOutcome{Sink: config.DeliveryMizito, Status: audit.StatusSent, Attempts: 1}
+	if err := account.Messages.Send(ctx, msg); err != nil {
+		log.Warn("Failed to send message to Mizito, trying the next target", "error", err)
+		outcome.Status = audit.StatusFailed
+		outcome.Error = err.Error()
+		return outcome
+	}
+	h.rememberSent(ctx, msg)
+	return outcome
+}
+
+// sink returns a configured sink by name
+func (h *Handler) sink(name string) sink.Sink {
+	for _, s := range h.sinks {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// defaultDiscordPriority is used for messages without a colored embed
+const defaultDiscordPriority = 5
+
+// DiscordWebhook is the payload of a Discord webhook execution. Tools post
+// it as JSON, or as a multipart form with a payload_json field and files.
+type DiscordWebhook struct {
+	Content   string         `json:"content"`
+	Username  string         `json:"username"`
+	AvatarURL string         `json:"avatar_url"`
+	Embeds    []DiscordEmbed `json:"embeds"`
+}
+
+// DiscordEmbed is a rich embed of a Discord message
+type DiscordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	URL         string              `json:"url"`
+	Color       int                 `json:"color"`
+	Timestamp   string              `json:"timestamp"`
+	Author      *DiscordEmbedAuthor `json:"author"`
+	Fields      []DiscordEmbedField `json:"fields"`
+	Footer      *DiscordEmbedFooter `json:"footer"`
+	Image       *DiscordEmbedImage  `json:"image"`
+}
+
+// DiscordEmbedAuthor is the author of an embed
+type DiscordEmbedAuthor struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// DiscordEmbedField is a field of an embed
+type DiscordEmbedField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// DiscordEmbedFooter is the footer of an embed
+type DiscordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+// DiscordEmbedImage is the image of an embed
+type DiscordEmbedImage struct {
+	URL string `json:"url"`
+}
+
+// HandleDiscordNotification handles POST requests with Discord webhook
+// payloads, so existing Discord integrations can target the forwarder
+func (h *Handler) HandleDiscordNotification(w http.ResponseWriter, r *http.Request) {
+	log := h.routeLogger(r, routeName(r))
+	log.Info("Received Discord webhook request")
+
+	var req DiscordWebhook
+	var attachments []render.Attachment
+	if isMultipart(r) {
+		if err := h.parseMultipartForm(w, r); err != nil {
+			log.Error("Failed to parse multipart request", "error", err)
+			if errors.Is(err, errTooLarge) {
+				http.Error(w, fmt.Sprintf("Attachments exceed %d bytes", h.config.MaxAttachmentSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid multipart form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		if payload := r.FormValue("payload_json"); payload != "" {
+			if err := json.Unmarshal([]byte(payload), &req); err != nil {
+				log.Error("Failed to parse payload_json", "error", err)
+				http.Error(w, "Invalid JSON in payload_json", http.StatusBadRequest)
+				return
+			}
+		} else {
+			req.Content = r.FormValue("content")
+			req.Username = r.FormValue("username")
+		}
+
+		files, err := h.formAttachments(r.MultipartForm)
+		if err != nil {
+			log.Error("Failed to read attachments", "error", err)
+			if errors.Is(err, errTooLarge) {
+				http.Error(w, fmt.Sprintf("Attachments exceed %d bytes", h.config.MaxAttachmentSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Failed to read attachments", http.StatusBadRequest)
+			return
+		}
+		attachments = files
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	title, message := req.title(), req.text()
+	if title == "" && message == "" && len(attachments) == 0 {
+		log.Warn("Empty Discord webhook request")
+		http.Error(w, "Content, embeds or files are required", http.StatusBadRequest)
+		return
+	}
+
+	extras := map[string]interface{}{}
+	if req.Username != "" {
+		extras["username"] = req.Username
+	}
+	if len(req.Embeds) > 0 && req.Embeds[0].URL != "" {
+		extras["url"] = req.Embeds[0].URL
+	}
+
+	h.deliver(w, r, &render.Notification{
+		Route:       routeName(r),
+		Title:       title,
+		Message:     message,
+		Priority:    req.priority(),
+		Time:        time.Now(),
+		Source:      "discord",
+		DialogID:    requestedDialog(r, ""),
+		Extras:      extras,
+		Attachments: attachments,
+	})
+}
+
+// title returns the title of the first embed when the message has no
+// content of its own, which would otherwise lead the message
+func (d *DiscordWebhook) title() string {
+	if d.Content != "" || len(d.Embeds) == 0 {
+		return ""
+	}
+	return discordText(d.Embeds[0].Title)
+}
+
+// text renders the content followed by the embeds, separated by blank lines
+func (d *DiscordWebhook) text() string {
+	var parts []string
+	if content := discordText(d.Content); content != "" {
+		parts = append(parts, content)
+	}
+
+	for i, embed := range d.Embeds {
+		// The title of the first embed is the notification title
+		withTitle := i > 0 || d.Content != ""
+		if text := embed.render(withTitle); text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	return strings.Join(parts, "\n\n")
+}
+
+// priority derives the priority from the most severe embed color
+func (d *DiscordWebhook) priority() int {
+	priority := -1
+	for _, embed := range d.Embeds {
+		if p, ok := colorPriority(embed.Color); ok && p > priority {
+			priority = p
+		}
+	}
+	if priority < 0 {
+		return defaultDiscordPriority
+	}
+	return priority
+}
+
+// render flattens an embed into lines of text
+func (e *DiscordEmbed) render(withTitle bool) string {
+	var lines []string
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			lines = append(lines, s)
+		}
+	}
+
+	if e.Author != nil {
+		add(e.Author.Name)
+	}
+	if withTitle {
+		add(discordText(e.Title))
+	}
+	if e.URL != "" {
+		add(e.URL)
+	}
+	add(discordText(e.Description))
+	for _, f := range e.Fields {
+		name, value := discordText(f.Name), discordText(f.Value)
+		switch {
+		case name != "" && value != "":
+			add(fmt.Sprintf("%s: %s", name, value))
+		default:
+			add(name + value)
+		}
+	}
+	if e.Image != nil {
+		add(e.Image.URL)
+	}
+
+	var footer []string
+	if e.Footer != nil && e.Footer.Text != "" {
+		footer = append(footer, discordText(e.Footer.Text))
+	}
+	if e.Timestamp != "" {
+		footer = append(footer, e.Timestamp)
+	}
+	add(strings.Join(footer, " • "))
+
+	return strings.Join(lines, "\n")
+}
+
+// colorPriority maps an RGB color such as an embed color to a priority:
+// reds are severe, oranges and yellows warnings and greens good news.
+// ok is false for colors of no particular meaning.
+func colorPriority(color int) (priority int, ok bool) {
+	if color <= 0 {
+		return 0, false
+	}
+	r, g, b := color>>16&0xff, color>>8&0xff, color&0xff
+
+	switch {
+	case r >= 0xa0 && g < 0x60 && b < 0x60:
+		return 8, true
+	case r >= 0xa0 && g >= 0x60 && b < 0x60:
+		return 5, true
+	case g >= 0xa0 && r < 0x80 && b < 0xa0:
+		return 2, true
+	default:
+		return 0, false
+	}
+}
+
+// discordMarkupPattern matches mentions, custom emojis, timestamps and masked
+// links of Discord markdown
+var discordMarkupPattern = regexp.MustCompile(`<(@[!&]?|#)(\d+)>|<a?(:\w+:)\d+>|<t:(-?\d+)(?::[tTdDfFR])?>|\[([^\[\]]+)\]\((https?://[^\s)]+)\)`)
+
+// discordText converts Discord markdown to plain text: masked links become
+// "label (url)", timestamps are formatted and mentions lose their markup
+func discordText(text string) string {
+	text = discordMarkupPattern.ReplaceAllStringFunc(text, func(match string) string {
+		m := discordMarkupPattern.FindStringSubmatch(match)
+		switch {
+		case m[1] == "#":
+			return "#" + m[2]
+		case m[1] != "":
+			return "@" + m[2]
+		case m[3] != "":
+			return m[3]
+		case m[4] != "":
+			seconds, err := strconv.ParseInt(m[4], 10, 64)
+			if err != nil {
+				return match
+			}
+			return time.Unix(seconds, 0).UTC().Format("2006-01-02 15:04 UTC")
+		default:
+			return fmt.Sprintf("%s (%s)", m[5], m[6])
+		}
+	})
+	return strings.TrimSpace(text)
+}
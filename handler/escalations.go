@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ListEscalations handles GET requests to /api/v1/escalations. It lists the
+// notifications that escalate to a phone call unless acknowledged.
+func (h *Handler) ListEscalations(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.escalations.Pending())
+}
+
+// AcknowledgeEscalation handles POST requests to
+// /api/v1/escalations/{id}/ack, where id is the request ID of the
+// notification. The phone call is cancelled.
+func (h *Handler) AcknowledgeEscalation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if !h.escalations.Acknowledge(id) {
+		http.Error(w, "Escalation not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NotificationResponse{
+		Success: true,
+		Message: "Escalation acknowledged",
+		ID:      id,
+	})
+}
+
+// AcknowledgeEscalations handles DELETE requests to /api/v1/escalations,
+// acknowledging the escalations of the route query parameter, or all of them
+func (h *Handler) AcknowledgeEscalations(w http.ResponseWriter, r *http.Request) {
+	count := h.escalations.AcknowledgeAll(r.URL.Query().Get("route"))
+
+	writeJSON(w, http.StatusOK, NotificationResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d escalation(s) acknowledged", count),
+	})
+}
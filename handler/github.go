@@ -0,0 +1,365 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// maxGitHubBodySize caps GitHub webhook payloads, which GitHub limits to 25 MB
+const maxGitHubBodySize = 25 << 20
+
+// maxPushCommits is how many commits of a push are listed in the message
+const maxPushCommits = 10
+
+// githubFormatter renders a GitHub event; ok is false for actions that are
+// not forwarded, such as labels being added to a pull request
+type githubFormatter func(event *GitHubEvent) (title, text string, ok bool)
+
+// githubEvents maps the supported event types to their formatter and priority
+var githubEvents = map[string]struct {
+	format   githubFormatter
+	priority int
+}{
+	"push":         {format: formatGitHubPush, priority: 3},
+	"pull_request": {format: formatGitHubPullRequest, priority: 4},
+	"issues":       {format: formatGitHubIssue, priority: 5},
+	"release":      {format: formatGitHubRelease, priority: 6},
+}
+
+// GitHubEvent holds the fields of the supported GitHub webhook events
+type GitHubEvent struct {
+	Action     string            `json:"action"`
+	Repository *GitHubRepository `json:"repository"`
+	Sender     *GitHubUser       `json:"sender"`
+
+	// push
+	Ref     string         `json:"ref"`
+	Created bool           `json:"created"`
+	Deleted bool           `json:"deleted"`
+	Forced  bool           `json:"forced"`
+	Compare string         `json:"compare"`
+	Commits []GitHubCommit `json:"commits"`
+
+	PullRequest *GitHubPullRequest `json:"pull_request"`
+	Issue       *GitHubIssue       `json:"issue"`
+	Release     *GitHubRelease     `json:"release"`
+}
+
+// GitHubRepository is the repository an event belongs to
+type GitHubRepository struct {
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+}
+
+// GitHubUser is a GitHub account
+type GitHubUser struct {
+	Login string `json:"login"`
+}
+
+// GitHubCommit is a commit of a push
+type GitHubCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Author  struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"author"`
+}
+
+// GitHubPullRequest is the pull request of a pull_request event
+type GitHubPullRequest struct {
+	Number  int         `json:"number"`
+	Title   string      `json:"title"`
+	HTMLURL string      `json:"html_url"`
+	Merged  bool        `json:"merged"`
+	Draft   bool        `json:"draft"`
+	User    *GitHubUser `json:"user"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+// GitHubIssue is the issue of an issues event
+type GitHubIssue struct {
+	Number  int         `json:"number"`
+	Title   string      `json:"title"`
+	HTMLURL string      `json:"html_url"`
+	User    *GitHubUser `json:"user"`
+	Labels  []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+}
+
+// GitHubRelease is the release of a release event
+type GitHubRelease struct {
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	HTMLURL    string `json:"html_url"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// HandleGitHubNotification handles POST requests from GitHub webhooks. The
+// X-Hub-Signature-256 header is verified when GITHUB_WEBHOOK_SECRET is set,
+// and only the event types in GITHUB_EVENTS are forwarded.
+func (h *Handler) HandleGitHubNotification(w http.ResponseWriter, r *http.Request) {
+	log := h.routeLogger(r, routeName(r))
+	eventType := r.Header.Get("X-GitHub-Event")
+	log.Info("Received GitHub notification request", "event", eventType, "delivery", r.Header.Get("X-GitHub-Delivery"))
+
+	body, err := readBody(w, r, maxGitHubBodySize)
+	if err != nil {
+		log.Error("Failed to read request body", "error", err)
+		writeBodyError(w, err, maxGitHubBodySize)
+		return
+	}
+
+	if secret := h.config.GitHubWebhookSecret; secret != "" && !validGitHubSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		log.Warn("Rejected GitHub delivery with invalid signature", "remote_addr", r.RemoteAddr)
+		writeJSON(w, http.StatusUnauthorized, NotificationResponse{
+			Success: false,
+			Message: "Invalid or missing X-Hub-Signature-256",
+		})
+		return
+	}
+
+	// GitHub pings a new webhook to check it is reachable
+	if eventType == "ping" {
+		writeJSON(w, http.StatusOK, NotificationResponse{Success: true, Message: "pong"})
+		return
+	}
+
+	spec, supported := githubEvents[eventType]
+	if !supported || !h.githubEventEnabled(eventType) {
+		log.Debug("Ignoring GitHub event", "event", eventType)
+		writeJSON(w, http.StatusOK, NotificationResponse{Success: true, Message: "Event ignored: " + eventType})
+		return
+	}
+
+	var event GitHubEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	title, text, ok := spec.format(&event)
+	if !ok {
+		log.Debug("Ignoring GitHub event action", "event", eventType, "action", event.Action)
+		writeJSON(w, http.StatusOK, NotificationResponse{Success: true, Message: "Event ignored: " + eventType + " " + event.Action})
+		return
+	}
+
+	extras := map[string]interface{}{
+		"event":  eventType,
+		"action": event.Action,
+	}
+	if event.Repository != nil {
+		extras["repository"] = event.Repository.FullName
+	}
+	if event.Sender != nil {
+		extras["sender"] = event.Sender.Login
+	}
+	if url := event.url(); url != "" {
+		extras["url"] = url
+	}
+
+	h.deliver(w, r, &render.Notification{
+		Route:    routeName(r),
+		Title:    title,
+		Message:  strings.TrimSpace(text),
+		Priority: spec.priority,
+		Time:     time.Now(),
+		Source:   "github",
+		DialogID: requestedDialog(r, ""),
+		Extras:   extras,
+	})
+}
+
+// githubEventEnabled reports whether an event type is listed in GITHUB_EVENTS
+func (h *Handler) githubEventEnabled(eventType string) bool {
+	for _, enabled := range h.config.GitHubEvents {
+		if enabled == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// checkGitHubEvents rejects unsupported event types in GITHUB_EVENTS
+func (h *Handler) checkGitHubEvents() error {
+	for _, eventType := range h.config.GitHubEvents {
+		if _, ok := githubEvents[eventType]; !ok {
+			return fmt.Errorf("GITHUB_EVENTS: unsupported event %q (supported: push, pull_request, issues, release)", eventType)
+		}
+	}
+	return nil
+}
+
+// validGitHubSignature checks the "sha256=<hex>" HMAC of the payload
+func validGitHubSignature(secret string, body []byte, signature string) bool {
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sum, mac.Sum(nil))
+}
+
+// formatGitHubPush renders e.g. "[owner/repo] alice pushed 3 commits to
+// main", followed by the compare link and the commit subjects
+func formatGitHubPush(e *GitHubEvent) (string, string, bool) {
+	kind, name := "branch", strings.TrimPrefix(e.Ref, "refs/heads/")
+	if strings.HasPrefix(e.Ref, "refs/tags/") {
+		kind, name = "tag", strings.TrimPrefix(e.Ref, "refs/tags/")
+	}
+
+	switch {
+	case e.Deleted:
+		return fmt.Sprintf("🗑️ [%s] %s deleted %s %s", e.repo(), e.actor(), kind, name), "", true
+	case kind == "tag":
+		return fmt.Sprintf("🏷️ [%s] %s pushed tag %s", e.repo(), e.actor(), name), e.Compare, true
+	case len(e.Commits) == 0 && e.Created:
+		return fmt.Sprintf("🌱 [%s] %s created branch %s", e.repo(), e.actor(), name), "", true
+	case len(e.Commits) == 0:
+		// e.g. a push rewinding the branch
+		return fmt.Sprintf("📦 [%s] %s updated %s", e.repo(), e.actor(), name), e.Compare, true
+	}
+
+	noun := "commits"
+	if len(e.Commits) == 1 {
+		noun = "commit"
+	}
+	title := fmt.Sprintf("📦 [%s] %s pushed %d %s to %s", e.repo(), e.actor(), len(e.Commits), noun, name)
+	if e.Forced {
+		title += " (force-push)"
+	}
+
+	var b strings.Builder
+	b.WriteString(e.Compare)
+	for i, commit := range e.Commits {
+		if i == maxPushCommits {
+			fmt.Fprintf(&b, "\n… and %d more", len(e.Commits)-maxPushCommits)
+			break
+		}
+		subject, _, _ := strings.Cut(commit.Message, "\n")
+		author := commit.Author.Username
+		if author == "" {
+			author = commit.Author.Name
+		}
+		fmt.Fprintf(&b, "\n- %.7s %s (%s)", commit.ID, subject, author)
+	}
+	return title, b.String(), true
+}
+
+// formatGitHubPullRequest renders opened, closed, merged, reopened and
+// ready-for-review pull requests
+func formatGitHubPullRequest(e *GitHubEvent) (string, string, bool) {
+	pr := e.PullRequest
+	if pr == nil {
+		return "", "", false
+	}
+
+	var action string
+	switch {
+	case e.Action == "closed" && pr.Merged:
+		action = "merged PR"
+	case e.Action == "opened" && pr.Draft:
+		action = "opened draft PR"
+	case e.Action == "opened" || e.Action == "closed" || e.Action == "reopened":
+		action = e.Action + " PR"
+	case e.Action == "ready_for_review":
+		action = "marked ready for review PR"
+	default:
+		return "", "", false
+	}
+
+	title := fmt.Sprintf("🔀 [%s] %s %s #%d %q", e.repo(), e.actor(), action, pr.Number, pr.Title)
+	text := fmt.Sprintf("%s\n%s → %s", pr.HTMLURL, pr.Head.Ref, pr.Base.Ref)
+	return title, text, true
+}
+
+// formatGitHubIssue renders opened, closed and reopened issues
+func formatGitHubIssue(e *GitHubEvent) (string, string, bool) {
+	issue := e.Issue
+	if issue == nil || (e.Action != "opened" && e.Action != "closed" && e.Action != "reopened") {
+		return "", "", false
+	}
+
+	title := fmt.Sprintf("🐛 [%s] %s %s issue #%d %q", e.repo(), e.actor(), e.Action, issue.Number, issue.Title)
+
+	text := issue.HTMLURL
+	if len(issue.Labels) > 0 {
+		labels := make([]string, len(issue.Labels))
+		for i, label := range issue.Labels {
+			labels[i] = label.Name
+		}
+		text += "\nLabels: " + strings.Join(labels, ", ")
+	}
+	return title, text, true
+}
+
+// formatGitHubRelease renders published releases
+func formatGitHubRelease(e *GitHubEvent) (string, string, bool) {
+	release := e.Release
+	if release == nil || e.Action != "published" {
+		return "", "", false
+	}
+
+	kind := "release"
+	if release.Prerelease {
+		kind = "pre-release"
+	}
+	title := fmt.Sprintf("🚀 [%s] %s published %s %s", e.repo(), e.actor(), kind, release.TagName)
+
+	text := release.HTMLURL
+	if release.Name != "" && release.Name != release.TagName {
+		text += "\n" + release.Name
+	}
+	return title, text, true
+}
+
+// repo returns the full name of the event's repository
+func (e *GitHubEvent) repo() string {
+	if e.Repository == nil {
+		return "GitHub"
+	}
+	return e.Repository.FullName
+}
+
+// actor names the account that triggered the event
+func (e *GitHubEvent) actor() string {
+	if e.Sender == nil || e.Sender.Login == "" {
+		return "someone"
+	}
+	return e.Sender.Login
+}
+
+// url returns the page of the event's subject
+func (e *GitHubEvent) url() string {
+	switch {
+	case e.PullRequest != nil:
+		return e.PullRequest.HTMLURL
+	case e.Issue != nil:
+		return e.Issue.HTMLURL
+	case e.Release != nil:
+		return e.Release.HTMLURL
+	case e.Compare != "":
+		return e.Compare
+	case e.Repository != nil:
+		return e.Repository.HTMLURL
+	}
+	return ""
+}
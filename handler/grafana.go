@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+)
+
+// GrafanaWebhook is the payload of a Grafana webhook contact point. Unified
+// alerting fills Alerts; legacy dashboard alerts use RuleName and EvalMatches.
+type GrafanaWebhook struct {
+	Title   string `json:"title"`
+	State   string `json:"state"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+
+	// Unified alerting
+	Receiver     string            `json:"receiver"`
+	OrgID        int64             `json:"orgId"`
+	GroupKey     string            `json:"groupKey"`
+	CommonLabels map[string]string `json:"commonLabels"`
+	ExternalURL  string            `json:"externalURL"`
+	Alerts       []GrafanaAlert    `json:"alerts"`
+
+	// Legacy alerting
+	RuleID      int64              `json:"ruleId"`
+	RuleName    string             `json:"ruleName"`
+	RuleURL     string             `json:"ruleUrl"`
+	ImageURL    string             `json:"imageUrl"`
+	EvalMatches []GrafanaEvalMatch `json:"evalMatches"`
+}
+
+// GrafanaAlert is an alert of a unified alerting notification
+type GrafanaAlert struct {
+	Alert
+	SilenceURL   string `json:"silenceURL"`
+	DashboardURL string `json:"dashboardURL"`
+	PanelURL     string `json:"panelURL"`
+	ValueString  string `json:"valueString"`
+}
+
+// GrafanaEvalMatch is a series that matched a legacy alert rule
+type GrafanaEvalMatch struct {
+	Metric string            `json:"metric"`
+	Value  float64           `json:"value"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// HandleGrafanaNotification handles POST requests from Grafana webhook contact points
+func (h *Handler) HandleGrafanaNotification(w http.ResponseWriter, r *http.Request) {
+	log := h.routeLogger(r, routeName(r))
+	log.Info("Received Grafana notification request")
+
+	var req GrafanaWebhook
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	log.Debug("Parsed Grafana notification", "title", req.Title, "state", req.state(), "alerts", len(req.Alerts))
+
+	if req.Title == "" && req.RuleName == "" && len(req.Alerts) == 0 {
+		log.Warn("Empty Grafana notification request")
+		http.Error(w, "Title, rule name or alerts are required", http.StatusBadRequest)
+		return
+	}
+
+	alerts := make([]Alert, 0, len(req.Alerts))
+	for _, alert := range req.Alerts {
+		alerts = append(alerts, alert.Alert)
+	}
+	firing := h.correlate(routeName(r), alerts)
+
+	title := req.Title
+	if title == "" {
+		title = req.RuleName
+	}
+
+	h.deliver(w, r, &render.Notification{
+		Route:    routeName(r),
+		Title:    title,
+		Message:  req.text(),
+		Priority: req.priority(),
+		Time:     time.Now(),
+		Source:   "grafana",
+		DialogID: requestedDialog(r, ""),
+
+		Fingerprints: firing,
+		Extras: map[string]interface{}{
+			"state":        req.state(),
+			"orgId":        req.OrgID,
+			"commonLabels": req.CommonLabels,
+			"externalURL":  req.ExternalURL,
+			"ruleUrl":      req.RuleURL,
+			"imageUrl":     req.ImageURL,
+		},
+	})
+}
+
+// state returns the overall state of the notification
+func (g *GrafanaWebhook) state() string {
+	if g.Status != "" {
+		return strings.ToLower(g.Status)
+	}
+	return strings.ToLower(g.State)
+}
+
+// priority derives the priority from severity labels, or from the state
+// when no alert carries a severity
+func (g *GrafanaWebhook) priority() int {
+	priority := -1
+	for _, alert := range g.Alerts {
+		label, ok := alert.Labels["severity"]
+		if !ok || alert.Status != "firing" {
+			continue
+		}
+		if p, ok := severity.Default.Lookup(severity.Alert, label); ok && p > priority {
+			priority = p
+		}
+	}
+	if priority >= 0 {
+		return priority
+	}
+
+	return severity.Default.Priority(severity.Grafana, g.state(), defaultAlertPriority)
+}
+
+// text renders the body of the Mizito message, including panel links
+func (g *GrafanaWebhook) text() string {
+	var b strings.Builder
+
+	if len(g.Alerts) > 0 {
+		for _, alert := range g.Alerts {
+			icon := "🔥"
+			if alert.Status == "resolved" {
+				icon = "✅"
+			}
+			fmt.Fprintf(&b, "\n%s %s", icon, alert.Labels["alertname"])
+			if summary := alert.Annotations["summary"]; summary != "" {
+				fmt.Fprintf(&b, "\n%s", summary)
+			}
+			if description := alert.Annotations["description"]; description != "" {
+				fmt.Fprintf(&b, "\n%s", description)
+			}
+			if alert.ValueString != "" {
+				fmt.Fprintf(&b, "\nValues: %s", alert.ValueString)
+			}
+			if alert.PanelURL != "" {
+				fmt.Fprintf(&b, "\nPanel: %s", alert.PanelURL)
+			} else if alert.DashboardURL != "" {
+				fmt.Fprintf(&b, "\nDashboard: %s", alert.DashboardURL)
+			}
+			b.WriteByte('\n')
+		}
+		return strings.TrimSpace(b.String())
+	}
+
+	// Legacy alerting
+	if g.Message != "" {
+		b.WriteString(g.Message)
+		b.WriteByte('\n')
+	}
+	for _, match := range g.EvalMatches {
+		fmt.Fprintf(&b, "\n%s: %g", match.Metric, match.Value)
+	}
+	if g.RuleURL != "" {
+		fmt.Fprintf(&b, "\n\nPanel: %s", g.RuleURL)
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/persian"
+)
+
+// ListHolidays handles GET requests to /api/v1/holidays. It lists the
+// holidays of the Jalali year in the year query parameter, or of the
+// current one, as business hours see them.
+func (h *Handler) ListHolidays(w http.ResponseWriter, r *http.Request) {
+	year, _, _ := persian.ToJalali(time.Now().In(h.config.Timezone))
+	if value := r.URL.Query().Get("year"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1300 || parsed > 1600 {
+			http.Error(w, "Invalid year, expected a Jalali year such as 1404", http.StatusBadRequest)
+			return
+		}
+		year = parsed
+	}
+
+	writeJSON(w, http.StatusOK, h.config.Holidays.Year(year))
+}
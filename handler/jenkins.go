@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+)
+
+// jenkinsResult describes how a build result is presented; its priority
+// comes from the jenkins severity scale
+type jenkinsResult struct {
+	icon  string
+	color string
+}
+
+// jenkinsResults maps Jenkins build results to their presentation
+var jenkinsResults = map[string]jenkinsResult{
+	"SUCCESS":   {icon: "✅", color: "green"},
+	"UNSTABLE":  {icon: "⚠️", color: "yellow"},
+	"FAILURE":   {icon: "❌", color: "red"},
+	"ABORTED":   {icon: "⏹️", color: "grey"},
+	"NOT_BUILT": {icon: "⚪", color: "grey"},
+}
+
+// jenkinsPhases are the build phases reported by the Notification Plugin
+var jenkinsPhases = map[string]bool{
+	"queued":    true,
+	"started":   true,
+	"completed": true,
+	"finalized": true,
+}
+
+// JenkinsWebhook is the payload of a Jenkins build notification: the
+// Notification Plugin nests the build, while payloads posted from pipelines
+// (e.g. with httpRequest) may use the flat fields instead
+type JenkinsWebhook struct {
+	// Notification Plugin
+	Name        string        `json:"name"`
+	DisplayName string        `json:"display_name"`
+	URL         string        `json:"url"`
+	Build       *JenkinsBuild `json:"build"`
+
+	// Flat payloads
+	JobName     string `json:"job_name"`
+	BuildNumber int64  `json:"build_number"`
+	Result      string `json:"result"`
+	Status      string `json:"status"`
+	BuildURL    string `json:"build_url"`
+	Message     string `json:"message"`
+}
+
+// JenkinsBuild is the build a Notification Plugin notification is about
+type JenkinsBuild struct {
+	FullURL  string      `json:"full_url"`
+	Number   int64       `json:"number"`
+	Phase    string      `json:"phase"`
+	Status   string      `json:"status"`
+	URL      string      `json:"url"`
+	Duration int64       `json:"duration"`
+	Notes    string      `json:"notes"`
+	SCM      *JenkinsSCM `json:"scm"`
+}
+
+// JenkinsSCM is the source revision of a build
+type JenkinsSCM struct {
+	URL    string `json:"url"`
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+}
+
+// HandleJenkinsNotification handles POST requests from the Jenkins
+// Notification Plugin and generic webhooks of pipelines. Only the build
+// phases in JENKINS_PHASES are forwarded.
+func (h *Handler) HandleJenkinsNotification(w http.ResponseWriter, r *http.Request) {
+	log := h.routeLogger(r, routeName(r))
+	log.Info("Received Jenkins notification request")
+
+	var req JenkinsWebhook
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.job() == "" {
+		log.Warn("Jenkins notification without job name")
+		http.Error(w, "Job name is required", http.StatusBadRequest)
+		return
+	}
+
+	phase := req.phase()
+	log.Debug("Parsed Jenkins notification", "job", req.job(), "number", req.number(), "phase", phase, "result", req.result())
+
+	if phase != "" && !h.jenkinsPhaseEnabled(phase) {
+		log.Debug("Ignoring Jenkins build phase", "phase", phase)
+		writeJSON(w, http.StatusOK, NotificationResponse{Success: true, Message: "Phase ignored: " + phase})
+		return
+	}
+
+	result, _ := req.presentation()
+	extras := map[string]interface{}{
+		"job":    req.job(),
+		"number": req.number(),
+		"result": req.result(),
+		"phase":  phase,
+		"color":  result.color,
+	}
+	if url := req.buildURL(); url != "" {
+		extras["url"] = url
+	}
+
+	h.deliver(w, r, &render.Notification{
+		Route:    routeName(r),
+		Title:    req.title(),
+		Message:  req.text(),
+		Priority: severity.Default.Priority(severity.Jenkins, req.result(), defaultAlertPriority),
+		Time:     time.Now(),
+		Source:   "jenkins",
+		DialogID: requestedDialog(r, ""),
+		Extras:   extras,
+	})
+}
+
+// jenkinsPhaseEnabled reports whether a build phase is listed in
+// JENKINS_PHASES
+func (h *Handler) jenkinsPhaseEnabled(phase string) bool {
+	for _, enabled := range h.config.JenkinsPhases {
+		if enabled == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// checkJenkinsPhases rejects unknown build phases in JENKINS_PHASES
+func (h *Handler) checkJenkinsPhases() error {
+	for _, phase := range h.config.JenkinsPhases {
+		if !jenkinsPhases[phase] {
+			return fmt.Errorf("JENKINS_PHASES: unknown phase %q (supported: queued, started, completed, finalized)", phase)
+		}
+	}
+	return nil
+}
+
+// job returns the name of the job
+func (j *JenkinsWebhook) job() string {
+	for _, name := range []string{j.DisplayName, j.Name, j.JobName} {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// number returns the build number, 0 if unknown
+func (j *JenkinsWebhook) number() int64 {
+	if j.Build != nil && j.Build.Number != 0 {
+		return j.Build.Number
+	}
+	return j.BuildNumber
+}
+
+// phase returns the lower-case build phase, empty for flat payloads
+func (j *JenkinsWebhook) phase() string {
+	if j.Build == nil {
+		return ""
+	}
+	return strings.ToLower(j.Build.Phase)
+}
+
+// result returns the upper-case build result, empty while it is running
+func (j *JenkinsWebhook) result() string {
+	result := j.Result
+	if j.Build != nil && j.Build.Status != "" {
+		result = j.Build.Status
+	} else if result == "" {
+		result = j.Status
+	}
+	return strings.ToUpper(strings.TrimSpace(result))
+}
+
+// buildURL returns the absolute URL of the build, if known
+func (j *JenkinsWebhook) buildURL() string {
+	if j.BuildURL != "" {
+		return j.BuildURL
+	}
+	if j.Build != nil {
+		return j.Build.FullURL
+	}
+	return ""
+}
+
+// presentation returns the presentation of the build result; ok is false
+// for running builds and unknown results
+func (j *JenkinsWebhook) presentation() (jenkinsResult, bool) {
+	result, ok := jenkinsResults[j.result()]
+	if !ok {
+		return jenkinsResult{icon: "🔨", color: "blue"}, false
+	}
+	return result, true
+}
+
+// title renders e.g. "❌ deploy-api #42 FAILURE", or "🔨 deploy-api #42
+// STARTED" for a build without result
+func (j *JenkinsWebhook) title() string {
+	result, _ := j.presentation()
+
+	state := j.result()
+	if state == "" {
+		state = strings.ToUpper(j.phase())
+	}
+
+	title := result.icon + " " + j.job()
+	if number := j.number(); number != 0 {
+		title += fmt.Sprintf(" #%d", number)
+	}
+	if state != "" {
+		title += " " + state
+	}
+	return title
+}
+
+// text renders the body of the Mizito message
+func (j *JenkinsWebhook) text() string {
+	var b strings.Builder
+
+	if j.Message != "" {
+		b.WriteString(j.Message)
+		b.WriteByte('\n')
+	}
+
+	if build := j.Build; build != nil {
+		if build.Notes != "" {
+			b.WriteString(build.Notes)
+			b.WriteByte('\n')
+		}
+		if scm := build.SCM; scm != nil {
+			if scm.Branch != "" {
+				fmt.Fprintf(&b, "\nBranch: %s", scm.Branch)
+			}
+			if scm.Commit != "" {
+				fmt.Fprintf(&b, "\nCommit: %s", shortCommit(scm.Commit))
+			}
+		}
+		if build.Duration > 0 {
+			fmt.Fprintf(&b, "\nDuration: %s", (time.Duration(build.Duration) * time.Millisecond).Round(time.Second))
+		}
+	}
+
+	if url := j.buildURL(); url != "" {
+		fmt.Fprintf(&b, "\nBuild: %s", url)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// shortCommit abbreviates a commit hash to 8 characters
+func shortCommit(commit string) string {
+	if len(commit) > 8 {
+		return commit[:8]
+	}
+	return commit
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// jobPath returns the status URL of a queued job, sent as Location header
+// of 202 Accepted responses
+func (h *Handler) jobPath(id string) string {
+	return h.config.BasePath + "/api/v1/jobs/" + id
+}
+
+// GetJob handles GET requests to /api/v1/jobs/{id}. It reports whether a
+// queued notification is still waiting or has been delivered, so senders
+// can follow up on a 202 Accepted response.
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	status, ok := h.queue.Status(mux.Vars(r)["id"])
+	if !ok {
+		writeJSON(w, http.StatusNotFound, NotificationResponse{
+			Success: false,
+			Message: "Job not found; it is unknown or was delivered too long ago",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
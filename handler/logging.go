@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// maxLoggedBodySize caps how much of a request or response body is logged
+const maxLoggedBodySize = 4 << 10
+
+// loadRouteLoggers creates loggers for routes with their own log level
+func (h *Handler) loadRouteLoggers() {
+	h.routeLoggers = make(map[string]*logger.Logger)
+	for name, rc := range h.config.Routes {
+		if rc.LogLevel != "" {
+			h.routeLoggers[name] = h.logger.WithLevel(rc.LogLevel)
+		}
+	}
+}
+
+// routeLogger returns the logger of a route, falling back to the global one,
+// with the request-scoped fields of r such as the request ID
+func (h *Handler) routeLogger(r *http.Request, route string) *logger.Logger {
+	if l, ok := h.routeLoggers[route]; ok {
+		return l.WithContext(r.Context())
+	}
+	return h.logger.WithContext(r.Context())
+}
+
+// requestLoggingMiddleware logs inbound requests and their responses in
+// full when the route logs at debug level
+func (h *Handler) requestLoggingMiddleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := h.routeLogger(r, route)
+		if !log.Enabled(logger.DEBUG) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Only the logged head of the body is read ahead; the rest streams
+		// through to the route
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxLoggedBodySize+1))
+		if err != nil {
+			r.Body.Close()
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+		log.Debug("Inbound request",
+			"route", route,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"headers", redactHeaders(r.Header),
+			"body", truncateBody(body))
+
+		rec := &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		log.Debug("Outbound response",
+			"route", route,
+			"status", rec.status,
+			"body", truncateBody(rec.body.Bytes()))
+	})
+}
+
+// bodyRecorder captures the status code and the beginning of the response body
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader captures the status code
+func (b *bodyRecorder) WriteHeader(status int) {
+	b.status = status
+	b.ResponseWriter.WriteHeader(status)
+}
+
+// Write captures up to maxLoggedBodySize bytes of the response body
+func (b *bodyRecorder) Write(p []byte) (int, error) {
+	if remaining := maxLoggedBodySize + 1 - b.body.Len(); remaining > 0 {
+		if len(p) < remaining {
+			remaining = len(p)
+		}
+		b.body.Write(p[:remaining])
+	}
+	return b.ResponseWriter.Write(p)
+}
+
+// redactHeaders copies headers, masking credentials
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range []string{"Authorization", "X-Gotify-Key", "Cookie", "Proxy-Authorization"} {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "[REDACTED]")
+		}
+	}
+	return redacted
+}
+
+// truncateBody renders a body for logging, capped at maxLoggedBodySize
+func truncateBody(body []byte) string {
+	if len(body) > maxLoggedBodySize {
+		return string(body[:maxLoggedBodySize]) + "...(truncated)"
+	}
+	return string(body)
+}
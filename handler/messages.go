@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/queue"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/tracking"
+	"github.com/gorilla/mux"
+)
+
+// messageIDContextKey is the context key holding the message ID of the
+// notification being delivered
+type messageIDContextKey struct{}
+
+// withMessageID returns a context carrying the message ID of a notification
+func withMessageID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, messageIDContextKey{}, id)
+}
+
+// contextMessageID returns the message ID of the notification delivered
+// with ctx, empty for deliveries without one such as digests
+func contextMessageID(ctx context.Context) string {
+	id, _ := ctx.Value(messageIDContextKey{}).(string)
+	return id
+}
+
+// track records the outcome of a delivery under the message ID of the
+// notification
+func (h *Handler) track(id string, response NotificationResponse) {
+	if id == "" {
+		return
+	}
+	if response.Success {
+		h.messages.Sent(id)
+		return
+	}
+	h.messages.Failed(id, response.Message)
+}
+
+// messagePath returns the status URL of a notification, sent as Location
+// header of 202 Accepted responses
+func (h *Handler) messagePath(id string) string {
+	return h.config.BasePath + "/api/v1/messages/" + id + "/status"
+}
+
+// GetMessageStatus handles GET requests to /api/v1/messages/{id}/status,
+// where id is the message ID of the response to a notification. It reports
+// whether the notification is still waiting, was sent or failed, so senders
+// can confirm its delivery.
+func (h *Handler) GetMessageStatus(w http.ResponseWriter, r *http.Request) {
+	if status, ok := h.MessageStatus(mux.Vars(r)["id"]); ok {
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+
+	writeJSON(w, http.StatusNotFound, NotificationResponse{
+		Success: false,
+		Message: "Message not found; it is unknown or was accepted too long ago",
+	})
+}
+
+// MessageStatus returns the delivery state of a notification; ok is false
+// for unknown or forgotten notifications
+func (h *Handler) MessageStatus(id string) (status *tracking.Status, ok bool) {
+	// A held notification approved into the queue keeps its message ID, so
+	// its job tells how far it got
+	if h.queue != nil {
+		if job, ok := h.queue.Status(id); ok {
+			return jobMessageStatus(job), true
+		}
+	}
+
+	if status, ok := h.messages.Get(id); ok {
+		return status, true
+	}
+	return nil, false
+}
+
+// jobMessageStatus returns the status of a queued notification
+func jobMessageStatus(job *queue.JobStatus) *tracking.Status {
+	status := &tracking.Status{
+		ID:         job.ID,
+		State:      tracking.StateQueued,
+		Attempts:   job.Attempts,
+		Error:      job.LastError,
+		AcceptedAt: job.CreatedAt,
+	}
+
+	switch job.State {
+	case queue.StateDelivered:
+		status.State = tracking.StateSent
+		status.SentAt = job.DeliveredAt
+	case queue.StateDead:
+		status.State = tracking.StateFailed
+		status.FailedAt = job.FailedAt
+	}
+	return status
+}
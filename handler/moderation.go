@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/assets"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/moderation"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/gorilla/mux"
+)
+
+// moderationPage renders the held messages as HTML
+var moderationPage = template.Must(template.New("moderation").Parse(assets.Page("moderation")))
+
+// approvalPreviewLength caps the preview of a held message in the approval
+// request sent to the moderators
+const approvalPreviewLength = 300
+
+// hold keeps the rendered message of a moderated route until a moderator
+// decides on it, and asks the moderators for approval
+func (h *Handler) hold(ctx context.Context, log *logger.Logger, account *mizito.Account, n *render.Notification, msg *mizito.Message) (int, NotificationResponse) {
+	m := &moderation.Message{
+		ID:          contextMessageID(ctx),
+		Route:       n.Route,
+		Account:     account.Name,
+		Title:       n.Title,
+		Message:     n.Message,
+		Priority:    n.Priority,
+		DialogID:    msg.DialogID,
+		Text:        msg.Text,
+		FromUserID:  msg.FromUserID,
+		RequestID:   logger.RequestID(ctx),
+		Template:    templateVariant(ctx),
+		Attachments: msg.Attachments,
+	}
+	if err := h.moderation.Hold(m); err != nil {
+		log.Error("Failed to hold notification for approval", "route", n.Route, "error", err)
+		return http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: "Failed to hold notification for approval: " + err.Error(),
+		}
+	}
+
+	log.Info("Notification held for approval", "route", n.Route, "id", m.ID)
+	h.messages.Accept(m.ID)
+	h.requestApproval(ctx, log, account, m)
+
+	return http.StatusAccepted, NotificationResponse{
+		Success: true,
+		Message: "Notification held for approval",
+		ID:      m.ID,
+	}
+}
+
+// requestApproval sends a compact approval request to the moderator dialog
+// of the route, if any. The message stays held if it cannot be sent, and
+// is decided on the moderation page instead.
+func (h *Handler) requestApproval(ctx context.Context, log *logger.Logger, account *mizito.Account, m *moderation.Message) {
+	dialogID := h.config.RouteModerationDialog(m.Route)
+	if dialogID == "" {
+		return
+	}
+
+	path := h.config.BasePath + "/api/v1/moderation/" + m.ID
+	msg := &mizito.Message{
+		Text: fmt.Sprintf("🛡️ Approval needed: %s → %s\n%s\nApprove: POST %s/approve\nReject: POST %s/reject",
+			m.Route, m.DialogID, render.Truncate(m.Text, approvalPreviewLength), path, path),
+		DialogID: dialogID,
+	}
+	if err := account.Messages.Send(ctx, msg); err != nil {
+		log.Warn("Failed to request approval in moderator dialog", "dialog", dialogID, "id", m.ID, "error", err)
+	}
+}
+
+// moderationData is the data of the moderation page
+type moderationData struct {
+	Messages []*moderation.Message
+	BasePath string
+	Token    string
+	Timeout  time.Duration
+}
+
+// ListModeration handles GET requests to /moderation and
+// /api/v1/moderation. It lists the held messages as an HTML page with
+// approve and reject buttons, or as JSON for /api/v1/moderation and
+// requests accepting application/json.
+func (h *Handler) ListModeration(w http.ResponseWriter, r *http.Request) {
+	messages := h.moderation.Pending()
+
+	if strings.HasSuffix(r.URL.Path, "/api/v1/moderation") || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, messages)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := moderationPage.Execute(w, moderationData{
+		Messages: messages,
+		BasePath: h.config.BasePath,
+		Token:    r.URL.Query().Get("token"),
+		Timeout:  h.config.ModerationTimeout,
+	})
+	if err != nil {
+		h.logger.Error("Failed to render moderation page", "error", err)
+	}
+}
+
+// GetModeration handles GET requests to /api/v1/moderation/{id}
+func (h *Handler) GetModeration(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.moderation.Get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Held message not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, m)
+}
+
+// ApproveModeration handles POST requests to /api/v1/moderation/{id}/approve
+// and the approve button of the moderation page. The held message is
+// delivered like any other notification of its route.
+func (h *Handler) ApproveModeration(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, true)
+}
+
+// RejectModeration handles POST requests to /api/v1/moderation/{id}/reject
+// and the reject button of the moderation page. The held message is
+// dropped.
+func (h *Handler) RejectModeration(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, false)
+}
+
+// decide approves or rejects a held message, named by the optional by
+// query parameter or form field. Decisions made on the moderation page
+// redirect back to it.
+func (h *Handler) decide(w http.ResponseWriter, r *http.Request, approved bool) {
+	id := mux.Vars(r)["id"]
+	by := strings.TrimSpace(r.FormValue("by"))
+	log := h.logger.WithContext(r.Context())
+
+	m, ok, err := h.moderation.Decide(id, approved)
+	if err != nil {
+		log.Error("Failed to decide on held message", "id", id, "error", err)
+		http.Error(w, "Failed to decide on held message", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Held message not found; it expired or was decided already", http.StatusNotFound)
+		return
+	}
+
+	status, response := http.StatusOK, NotificationResponse{
+		Success: true,
+		Message: "Notification rejected",
+		ID:      id,
+	}
+	if approved {
+		log.Info("Held notification approved", "route", m.Route, "id", id, "by", by)
+		status, response = h.release(r.Context(), log, m)
+		if response.ID == "" {
+			response.ID = id
+		}
+	} else {
+		log.Info("Held notification rejected", "route", m.Route, "id", id, "by", by)
+		h.messages.Failed(id, "Notification rejected by moderator")
+	}
+
+	if !strings.Contains(r.URL.Path, "/api/v1/") {
+		location := h.config.BasePath + "/moderation"
+		if token := r.URL.Query().Get("token"); token != "" {
+			location += "?token=" + url.QueryEscape(token)
+		}
+		http.Redirect(w, r, location, http.StatusSeeOther)
+		return
+	}
+	writeNotification(w, status, response)
+}
+
+// release delivers an approved message
+func (h *Handler) release(ctx context.Context, log *logger.Logger, m *moderation.Message) (int, NotificationResponse) {
+	account, ok := h.accounts[m.Account]
+	if !ok {
+		log.Error("Held notification of unknown account dropped", "route", m.Route, "id", m.ID, "account", m.Account)
+		return http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: "Unknown account: " + m.Account,
+		}
+	}
+
+	if m.RequestID != "" {
+		ctx = logger.WithRequestID(ctx, m.RequestID)
+	}
+	ctx = withTemplateVariant(withMessageID(ctx, m.ID), m.Template)
+
+	// Delivery latency counts from the approval, not from the hold
+	n := &render.Notification{
+		Route:       m.Route,
+		Title:       m.Title,
+		Message:     m.Message,
+		Priority:    m.Priority,
+		Time:        time.Now(),
+		DialogID:    m.DialogID,
+		Attachments: m.Attachments,
+	}
+	msg := &mizito.Message{
+		Text:        m.Text,
+		Priority:    m.Priority,
+		DialogID:    m.DialogID,
+		FromUserID:  m.FromUserID,
+		Attachments: m.Attachments,
+	}
+	status, response := h.deliverRendered(ctx, log, account, n, msg)
+
+	// A queued notification tells its state through its job
+	if status != http.StatusAccepted {
+		h.track(m.ID, response)
+	}
+	return status, response
+}
+
+// deliverRendered delivers a message rendered earlier, such as an approved
+// or buffered one, like deliver does: through the delivery chain on
+// first-success routes, through the queue when enabled, or else to the
+// sinks and Mizito
+func (h *Handler) deliverRendered(ctx context.Context, log *logger.Logger, account *mizito.Account, n *render.Notification, msg *mizito.Message) (int, NotificationResponse) {
+	sinkMsg := sinkMessage(ctx, n, msg.Text, msg.DialogID)
+
+	id := contextMessageID(ctx)
+	policy, chain := h.deliveryChain(n.Route)
+	switch {
+	case policy == config.DeliveryFirstSuccess:
+		return h.deliverChain(ctx, log, id, account, n, msg, sinkMsg, chain)
+	case h.queue != nil:
+		id, err := h.enqueue(ctx, log, account, n, msg, sinkMsg)
+		if err != nil {
+			log.Error("Failed to queue message", "error", err)
+			return http.StatusInternalServerError, NotificationResponse{
+				Success: false,
+				Message: "Failed to queue notification: " + err.Error(),
+			}
+		}
+		return http.StatusAccepted, NotificationResponse{
+			Success: true,
+			Message: "Notification queued for delivery",
+			ID:      id,
+		}
+	default:
+		return h.send(ctx, log, id, account, n, msg, sinkMsg)
+	}
+}
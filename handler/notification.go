@@ -1,47 +1,331 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
-
+	"text/template"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/assets"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/audit"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/capture"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/correlation"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/deadman"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/dedup"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/enrich"
 	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
 	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/moderation"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/policy"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/queue"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/quiet"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/rules"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/schedule"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/schema"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/sink"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/slo"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/snooze"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/tracking"
 	"github.com/gorilla/mux"
 )
 
 // GotifyNotificationRequest represents the request structure for Gotify notifications
 type GotifyNotificationRequest struct {
-	Title    string `json:"title"`
-	Message  string `json:"message"`
-	Priority int    `json:"priority"`
-	Extras   struct {
-		ClientDisplay struct {
-			ContentType string `json:"contentType"`
-		} `json:"client::display"`
-	} `json:"extras"`
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	Priority int                    `json:"priority"`
+	Extras   map[string]interface{} `json:"extras"`
+
+	// Dialog optionally targets a specific Mizito dialog
+	Dialog string `json:"dialog,omitempty"`
+
+	// Delay optionally holds the notification back, like X-Delay
+	Delay Delay `json:"delay,omitempty"`
+}
+
+// ContentType returns the content type from the client::display extras
+func (req *GotifyNotificationRequest) ContentType() string {
+	display, _ := req.Extras["client::display"].(map[string]interface{})
+	contentType, _ := display["contentType"].(string)
+	return contentType
 }
 
 // NotificationResponse represents the response structure
 type NotificationResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+	ID      string `json:"id,omitempty"`
+
+	// DeliveredBy names the target of the delivery chain that took the
+	// notification, for routes delivered first-success
+	DeliveredBy string `json:"delivered_by,omitempty"`
+
+	// Duplicates counts the notifications with the same dedup key within
+	// the window, this one included, when it was suppressed
+	Duplicates int `json:"duplicates,omitempty"`
+
+	// SnoozedUntil is when the snooze of the notification's alert ends,
+	// when it was snoozed or held back by the snooze
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+
+	// ScheduledAt is when a delayed notification is delivered
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+
+	// RetryAfter is the number of seconds to wait before sending a rejected
+	// notification again, also sent as Retry-After header
+	RetryAfter int `json:"retry_after,omitempty"`
+
+	// RequestID identifies the request in the logs
+	RequestID string `json:"request_id,omitempty"`
+
+	// Echo describes the delivered message when requested with echo=true
+	Echo *DeliveryEcho `json:"echo,omitempty"`
+}
+
+// DeliveryEcho is the message as forwarded to Mizito, for senders iterating
+// on routing and templates
+type DeliveryEcho struct {
+	Route    string `json:"route"`
+	Text     string `json:"text"`
+	DialogID string `json:"dialog_id"`
+	Priority int    `json:"priority"`
+
+	// Template names the setting of the template that rendered the text,
+	// empty for the default "Title: Message"
+	Template string `json:"template,omitempty"`
+
+	// Rules names the routing rules the notification matched
+	Rules []string `json:"rules,omitempty"`
+
+	// OffHours is set when the notification arrived outside business hours
+	OffHours bool `json:"off_hours,omitempty"`
 }
 
 // Handler handles HTTP requests
 type Handler struct {
-	messageService *mizito.MessageService
-	logger         *logger.Logger
-	appToken       string
+	config   *config.Config
+	captures *capture.Store
+	queue    *queue.Queue
+	audit    *audit.Log
+
+	deadman   *deadman.Switch
+	slo       *slo.Tracker
+	logger    *logger.Logger
+	appTokens []string
+
+	// appPriorities holds the default priority of the applications having
+	// one, keyed by app token
+	appPriorities map[string]int
+
+	// accounts holds the Mizito accounts by name, including the default one
+	accounts map[string]*mizito.Account
+
+	// handlers holds the handler of each named route, without authentication
+	// and capturing, as used for replaying captured payloads
+	handlers map[string]http.Handler
+
+	// schemas holds the compiled JSON Schema of each route that has one
+	schemas map[string]*schema.Schema
+
+	// summaries holds the compiled summary template of each route that has one
+	summaries map[string]*template.Template
+
+	// templates holds the compiled message template of each route that has
+	// one; defaultTemplate (MESSAGE_TEMPLATE) applies to the other routes
+	templates       map[string]*template.Template
+	defaultTemplate *template.Template
+
+	// offHoursTemplates holds the compiled off-hours template of each route
+	// that has one
+	offHoursTemplates map[string]*template.Template
+
+	// candidateTemplates holds the compiled candidate template of each
+	// route that has one
+	candidateTemplates map[string]*template.Template
+
+	// profileTemplates holds the compiled message template of each
+	// formatting profile that has one
+	profileTemplates map[string]*template.Template
+
+	// rules decides the fate, dialog and template of notifications by the
+	// rules of RULES_FILE; nil without one. ruleTemplates holds the compiled
+	// template of each rule that has one.
+	rules         *rules.Engine
+	ruleTemplates map[string]*template.Template
+
+	// routeLoggers holds the loggers of routes with their own log level
+	routeLoggers map[string]*logger.Logger
+
+	// policy masks secrets and enforces size limits on notifications
+	policy *policy.Policy
+
+	// enricher annotates IP addresses in messages; nil when disabled
+	enricher *enrich.Enricher
+
+	// messages tracks the delivery of notifications by message ID, for the
+	// message status endpoint
+	messages *tracking.Store
+
+	// duplicates counts repeated notifications within the dedup window
+	duplicates *dedup.Store
+
+	// snoozes holds back re-fires of alerts snoozed through the API
+	snoozes *snooze.Store
+
+	// alerts tracks the firing alerts notified by alerting systems
+	alerts *correlation.Store
+
+	// escalations holds the notifications escalating to phone calls unless
+	// acknowledged
+	escalations *sink.Escalations
+
+	// scheduler holds delayed notifications and recurring messages until
+	// they are due
+	scheduler *schedule.Scheduler
+
+	// moderation holds the notifications of moderated routes until a
+	// moderator decides on them
+	moderation *moderation.Store
+
+	// quiet buffers low-priority notifications to dialogs in quiet hours
+	// until their digest
+	quiet *quiet.Buffer
+
+	// sinks receive rendered notifications alongside Mizito
+	sinks []sink.Sink
+
+	// phone escalates unacknowledged notifications to calls; nil when
+	// disabled
+	phone *sink.Phone
+
+	// alertmanagerTemplate renders Alertmanager notification groups
+	alertmanagerTemplate *template.Template
+
+	// openAPI is the OpenAPI document served at /openapi.json
+	openAPI []byte
+
+	// readiness caches the last check of /readyz
+	readiness readiness
+
+	// async delivers the notifications accepted with async send
+	async *asyncPool
+
+	// reload reloads the configuration for /api/v1/admin/reload; nil
+	// disables the endpoint
+	reload func() error
+
+	// current returns the handler of the current configuration; nil when
+	// configurations are not reloaded
+	current func() (*Handler, func())
 }
 
 // NewHandler creates a new HTTP handler
-func NewHandler(messageService *mizito.MessageService, logger *logger.Logger, appToken string) *Handler {
-	return &Handler{
-		messageService: messageService,
-		logger:         logger,
-		appToken:       appToken,
+// The queue is optional; when nil notifications are delivered synchronously.
+// The audit log is optional as well. accounts must hold the default account.
+func NewHandler(config *config.Config, accounts map[string]*mizito.Account, captures *capture.Store, queue *queue.Queue, messages *tracking.Store, duplicates *dedup.Store, snoozes *snooze.Store, alerts *correlation.Store, escalations *sink.Escalations, scheduler *schedule.Scheduler, moderationStore *moderation.Store, quietBuffer *quiet.Buffer, auditLog *audit.Log, deadmanSwitch *deadman.Switch, deliverySLO *slo.Tracker, logger *logger.Logger) (*Handler, error) {
+	h := &Handler{
+		config:     config,
+		accounts:   accounts,
+		captures:   captures,
+		queue:      queue,
+		messages:   messages,
+		duplicates: duplicates,
+		snoozes:    snoozes,
+		alerts:     alerts,
+		scheduler:  scheduler,
+		moderation: moderationStore,
+		quiet:      quietBuffer,
+		audit:      auditLog,
+		deadman:    deadmanSwitch,
+		slo:        deliverySLO,
+		logger:     logger,
+		appTokens:  config.AppTokens,
+		handlers:   make(map[string]http.Handler),
+		schemas:    make(map[string]*schema.Schema),
+		summaries:  make(map[string]*template.Template),
+		templates:  make(map[string]*template.Template),
+
+		appPriorities: config.ApplicationPriorities(),
+		escalations:   escalations,
+	}
+
+	h.loadRouteLoggers()
+
+	contentPolicy, err := policy.New(policy.Config{
+		MaxTitleLength:   config.PolicyMaxTitleLength,
+		MaxMessageLength: config.PolicyMaxMessageLength,
+		ScrubSecrets:     config.PolicyScrubSecrets,
+		PatternsFile:     config.PolicyPatternsFile,
+		MaskPII:          config.PolicyMaskPII,
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.policy = contentPolicy
+
+	if config.EnrichIPs {
+		h.enricher, err = enrich.New(enrich.Config{
+			ReverseDNS:    config.EnrichRDNS,
+			GeoIPDatabase: config.GeoIPDatabase,
+			Timeout:       config.EnrichTimeout,
+		}, logger)
+		if err != nil {
+			return nil, fmt.Errorf("GEOIP_DATABASE: %w", err)
+		}
+	}
+
+	if err := h.loadSinks(); err != nil {
+		return nil, err
+	}
+
+	if err := h.loadSchemas(); err != nil {
+		return nil, err
+	}
+
+	if err := h.loadSummaries(); err != nil {
+		return nil, err
+	}
+
+	if err := h.loadTemplates(); err != nil {
+		return nil, err
+	}
+
+	if err := h.loadProfileTemplates(); err != nil {
+		return nil, err
 	}
+
+	if err := h.loadRules(); err != nil {
+		return nil, err
+	}
+
+	if err := h.checkGitHubEvents(); err != nil {
+		return nil, err
+	}
+
+	if err := h.checkJenkinsPhases(); err != nil {
+		return nil, err
+	}
+
+	if h.openAPI, err = h.buildOpenAPI(); err != nil {
+		return nil, err
+	}
+
+	if err := h.loadAlertmanagerTemplate(); err != nil {
+		return nil, err
+	}
+
+	h.async = newAsyncPool(config.AsyncWorkers, config.AsyncBuffer)
+
+	return h, nil
 }
 
 // AppTokenMiddleware validates the APP_TOKEN on protected routes.
@@ -50,44 +334,59 @@ func NewHandler(messageService *mizito.MessageService, logger *logger.Logger, ap
 //   - Authorization header:     Authorization: Bearer <token>
 //   - Gotify-compatible header: X-Gotify-Key: <token>
 //
-// When APP_TOKEN is not configured the middleware is skipped (open access).
+// Any of the tokens in APP_TOKEN and APP_TOKENS and of the stored
+// applications is accepted.
+// When no token is configured the middleware is skipped (open access).
 func (h *Handler) AppTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// If no token is configured, allow all requests
-		if h.appToken == "" {
-			next.ServeHTTP(w, r)
+		if _, ok := h.Authorize(r); !ok {
+			h.logger.WithContext(r.Context()).Warn("Unauthorized request – invalid or missing app token",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"remote_addr", r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":   "Unauthorized",
+				"message": "Valid app token required. Pass it via ?token=, Authorization: Bearer, or X-Gotify-Key header.",
+			})
 			return
 		}
 
-		var provided string
-
-		// 1. Query parameter: ?token=<token>
-		if t := r.URL.Query().Get("token"); t != "" {
-			provided = t
-		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		// 2. Authorization: Bearer <token>
-		if provided == "" {
-			if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
-				provided = strings.TrimPrefix(auth, "Bearer ")
-			}
+// AdminTokenMiddleware requires ADMIN_TOKEN on the administrative routes.
+// App tokens only allow sending, so a sender cannot replace the Mizito
+// token, reload the configuration or purge dead letters. Without
+// ADMIN_TOKEN the routes are refused, unless no app token is configured
+// either and the server is open.
+func (h *Handler) AdminTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminToken := h.config.AdminToken
+		if adminToken == "" && len(h.appTokens) == 0 {
+			next.ServeHTTP(w, r)
+			return
 		}
 
-		// 3. X-Gotify-Key: <token> (Gotify client compatibility)
-		if provided == "" {
-			provided = r.Header.Get("X-Gotify-Key")
+		status, message := 0, ""
+		switch provided := providedAppToken(r); {
+		case adminToken == "":
+			status, message = http.StatusForbidden, "The admin routes are disabled until ADMIN_TOKEN is configured."
+		case subtle.ConstantTimeCompare([]byte(provided), []byte(adminToken)) != 1:
+			status, message = http.StatusUnauthorized, "Valid admin token required. Pass it via ?token=, Authorization: Bearer, or X-Gotify-Key header."
 		}
-
-		if provided != h.appToken {
-			h.logger.Warn("Unauthorized request – invalid or missing app token",
+		if status != 0 {
+			h.logger.WithContext(r.Context()).Warn("Unauthorized request – invalid or missing admin token",
 				"method", r.Method,
 				"path", r.URL.Path,
 				"remote_addr", r.RemoteAddr)
 			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
+			w.WriteHeader(status)
 			json.NewEncoder(w).Encode(map[string]string{
-				"error":   "Unauthorized",
-				"message": "Valid app token required. Pass it via ?token=, Authorization: Bearer, or X-Gotify-Key header.",
+				"error":   http.StatusText(status),
+				"message": message,
 			})
 			return
 		}
@@ -96,89 +395,265 @@ func (h *Handler) AppTokenMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// Authorize checks the app token of a request, for inputs served outside
+// the routes of the handler such as gRPC, and returns the default priority
+// of the application of the token, 0 for other tokens. All requests are
+// authorized when no app token is configured.
+func (h *Handler) Authorize(r *http.Request) (priority int, ok bool) {
+	if len(h.appTokens) == 0 {
+		return 0, true
+	}
+	if !h.validAppToken(providedAppToken(r)) {
+		return 0, false
+	}
+	return h.applicationPriority(r), true
+}
+
+// providedAppToken returns the app token of a request, from the first of:
+//  1. Query parameter: ?token=<token>
+//  2. Authorization: Bearer <token>
+//  3. X-Gotify-Key: <token> (Gotify client compatibility)
+func providedAppToken(r *http.Request) string {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return t
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-Gotify-Key")
+}
+
+// applicationPriority returns the default priority of the application whose
+// token authenticated a request, 0 for other tokens
+func (h *Handler) applicationPriority(r *http.Request) int {
+	if len(h.appPriorities) == 0 {
+		return 0
+	}
+	return h.appPriorities[providedAppToken(r)]
+}
+
+// validAppToken reports whether provided matches a configured app token.
+// Tokens are compared in constant time and all of them are checked, so
+// response timing reveals nothing about the configured tokens.
+func (h *Handler) validAppToken(provided string) bool {
+	if provided == "" {
+		return false
+	}
+
+	valid := 0
+	for _, token := range h.appTokens {
+		valid |= subtle.ConstantTimeCompare([]byte(provided), []byte(token))
+	}
+	return valid == 1
+}
+
 // HandleGotifyNotification handles POST requests to /notification/gotify
 func (h *Handler) HandleGotifyNotification(w http.ResponseWriter, r *http.Request) {
-	h.logger.Info("Received Gotify notification request")
+	log := h.routeLogger(r, routeName(r))
+	log.Info("Received Gotify notification request")
 
-	// Parse request body
+	// Parse request body: JSON, plain text, or a multipart form with attachments
 	var req GotifyNotificationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.logger.Error("Failed to parse request body", "error", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	var attachments []render.Attachment
+	if isMultipart(r) {
+		form, files, err := h.parseMultipartNotification(w, r)
+		if err != nil {
+			log.Error("Failed to parse multipart request", "error", err)
+			if errors.Is(err, errTooLarge) {
+				http.Error(w, fmt.Sprintf("Attachments exceed %d bytes", h.config.MaxAttachmentSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid multipart form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, attachments = *form, files
+	} else if plain, err := decodeNotification(w, r, &req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		switch {
+		case errors.Is(err, errTooLarge):
+			http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", maxNotificationBodySize), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, errInvalidJSON):
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		}
 		return
+	} else if plain {
+		log.Debug("Request body taken as plain text message")
 	}
 
-	h.logger.Debug("Parsed request", "title", req.Title, "message", req.Message, "priority", req.Priority)
+	// Like Gotify, notifications without a priority take the default
+	// priority of their application
+	if req.Priority == 0 {
+		if priority := h.applicationPriority(r); priority > 0 {
+			log.Debug("Default priority of the application applied", "priority", priority)
+			req.Priority = priority
+		}
+	}
+
+	log.Debug("Parsed request", "title", req.Title, "message", req.Message, "priority", req.Priority, "attachments", len(attachments))
 
 	// Validate required fields
-	if req.Title == "" && req.Message == "" {
-		h.logger.Warn("Empty notification request")
-		http.Error(w, "Title or message is required", http.StatusBadRequest)
+	if req.Title == "" && req.Message == "" && len(attachments) == 0 {
+		log.Warn("Empty notification request")
+		http.Error(w, "Title, message or attachment is required", http.StatusBadRequest)
 		return
 	}
 
-	// Combine title and message
-	notificationText := ""
-	if req.Title != "" {
-		notificationText += req.Title
-		if req.Message != "" {
-			notificationText += ": "
-		}
-	}
-	if req.Message != "" {
-		notificationText += req.Message
-	}
+	h.deliver(w, withDelay(r, req.Delay), &render.Notification{
+		Route:    routeName(r),
+		Title:    req.Title,
+		Message:  req.Message,
+		Priority: severity.Default.Priority(severity.Gotify, strconv.Itoa(req.Priority), req.Priority),
+		Time:     time.Now(),
+		Source:   "gotify",
+		Extras:   req.Extras,
+		DialogID: requestedDialog(r, req.Dialog),
+
+		Attachments: attachments,
+	})
+}
 
-	// Send message to Mizito
-	h.logger.Info("Sending notification to Mizito", "combined_message", notificationText)
+// HealthResponse represents the health check response
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
 
-	if err := h.messageService.SendMessage(notificationText); err != nil {
-		h.logger.Error("Failed to send message to Mizito", "error", err)
+	// Auth describes the authentication of the default account; it is only
+	// reported on the admin status route
+	Auth *AuthHealth `json:"auth,omitempty"`
 
-		response := NotificationResponse{
-			Success: false,
-			Message: "Failed to send notification: " + err.Error(),
-		}
+	// Accounts describes the authentication of the accounts besides the
+	// default one, keyed by name
+	Accounts map[string]*AuthHealth `json:"accounts,omitempty"`
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
+// AuthHealth describes the state of Mizito authentication
+type AuthHealth struct {
+	TokenPresent     bool       `json:"token_present"`
+	TokenValid       bool       `json:"token_valid"`
+	TokenAgeSeconds  *int64     `json:"token_age_seconds,omitempty"`
+	ExpiresInSeconds *int64     `json:"expires_in_seconds,omitempty"`
+	TokenExpiresAt   *time.Time `json:"token_expires_at,omitempty"`
+	LoginSuccesses   int64      `json:"login_successes"`
+	LoginFailures    int64      `json:"login_failures"`
+	LastLoginAt      *time.Time `json:"last_login_at,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+	LastErrorAt      *time.Time `json:"last_error_at,omitempty"`
+
+	// ManualLoginRequired is set while automated logins are paused by a
+	// CAPTCHA or anti-bot challenge
+	ManualLoginRequired bool       `json:"manual_login_required"`
+	ChallengeReason     string     `json:"challenge_reason,omitempty"`
+	ChallengeAt         *time.Time `json:"challenge_at,omitempty"`
+
+	// Logins rejected by Mizito, the backoff before the next attempt and the
+	// lockout opened after too many rejections
+	ConsecutiveRejections int        `json:"consecutive_login_rejections"`
+	NextLoginAt           *time.Time `json:"next_login_at,omitempty"`
+	LoginLocked           bool       `json:"login_locked"`
+	LockedAt              *time.Time `json:"locked_at,omitempty"`
+}
+
+// HealthCheck handles GET requests to /health. Being public, it only tells
+// that the service runs; the authentication state is left to AuthStatus.
+func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
+	h.logger.WithContext(r.Context()).Debug("Health check requested")
 
-	// Success response
-	response := NotificationResponse{
-		Success: true,
-		Message: "Notification sent successfully",
+	writeJSON(w, http.StatusOK, HealthResponse{
+		Status:  "healthy",
+		Message: "Mizito Forwarder is running",
+	})
+}
+
+// AuthStatus handles GET requests to /api/v1/status, reporting the health
+// check with the state of Mizito authentication of every account
+func (h *Handler) AuthStatus(w http.ResponseWriter, r *http.Request) {
+	response := HealthResponse{
+		Status:  "healthy",
+		Message: "Mizito Forwarder is running",
+		Auth:    authHealth(h.defaultAccount().Auth),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	for name, account := range h.accounts {
+		if name == config.DefaultAccount {
+			continue
+		}
+		if response.Accounts == nil {
+			response.Accounts = make(map[string]*AuthHealth)
+		}
+		response.Accounts[name] = authHealth(account.Auth)
+	}
 
-	h.logger.Info("Notification processed successfully")
+	writeJSON(w, http.StatusOK, response)
 }
 
-// HealthCheck handles GET requests to /health
-func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	h.logger.Debug("Health check requested")
+// authHealth collects token timing and login statistics of an account
+func authHealth(auth *mizito.AuthService) *AuthHealth {
+	stats := auth.Stats()
+	health := &AuthHealth{
+		LoginSuccesses: stats.LoginSuccesses,
+		LoginFailures:  stats.LoginFailures,
+		LastError:      stats.LastError,
+		LastLoginAt:    optionalTime(stats.LastLoginAt),
+		LastErrorAt:    optionalTime(stats.LastErrorAt),
+
+		ManualLoginRequired: !stats.ChallengeAt.IsZero(),
+		ChallengeReason:     stats.ChallengeReason,
+		ChallengeAt:         optionalTime(stats.ChallengeAt),
+
+		ConsecutiveRejections: stats.ConsecutiveRejections,
+		LoginLocked:           !stats.LockedAt.IsZero(),
+		LockedAt:              optionalTime(stats.LockedAt),
+	}
+	if stats.NextLoginAt.After(time.Now()) {
+		health.NextLoginAt = &stats.NextLoginAt
+	}
+
+	if updatedAt, expiresAt, ok := auth.TokenInfo(); ok {
+		now := time.Now()
+		age := int64(now.Sub(updatedAt).Seconds())
+		expiresIn := int64(expiresAt.Sub(now).Seconds())
+		health.TokenPresent = true
+		health.TokenValid = auth.HasValidToken()
+		health.TokenAgeSeconds = &age
+		health.ExpiresInSeconds = &expiresIn
+		health.TokenExpiresAt = &expiresAt
+	}
+
+	return health
+}
 
-	response := map[string]string{
-		"status":  "healthy",
-		"message": "Mizito Forwarder is running",
+// optionalTime returns nil for the zero time so it is omitted from JSON
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
 	}
+	return &t
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+// route wraps the handler of a named route with authentication and the
+// middleware enabled for it in the route configuration
+func (h *Handler) route(name string, handler http.HandlerFunc) http.Handler {
+	validated := withRoute(name, h.requestLoggingMiddleware(name, h.schemaMiddleware(name, h.rulePayloadMiddleware(name, handler))))
+	h.handlers[name] = validated
+	return h.AppTokenMiddleware(h.captureMiddleware(name, validated))
 }
 
-// RegisterRoutes registers all HTTP routes
+// RegisterRoutes registers the public notification intake HTTP routes
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	auth := h.AppTokenMiddleware
+	message := h.route("message", h.HandleGotifyNotification)
+	alertmanager := h.route("alertmanager", h.HandleAlertmanagerNotification)
+	grafana := h.route("grafana", h.HandleGrafanaNotification)
+	uptimeKuma := h.route("uptimekuma", h.HandleUptimeKumaNotification)
+	github := h.route("github", h.HandleGitHubNotification)
+	slack := h.route("slack", h.HandleSlackNotification)
+	discord := h.route("discord", h.HandleDiscordNotification)
+	jenkins := h.route("jenkins", h.HandleJenkinsNotification)
+	annotate := h.route("annotate", h.HandleAnnotation)
 
 	// Public routes (no auth required)
-	router.HandleFunc("/health", h.HealthCheck).Methods(http.MethodGet)
+	h.RegisterHealthRoutes(router)
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		response := map[string]string{
 			"service": "Mizito Forwarder",
@@ -190,15 +665,132 @@ func (h *Handler) RegisterRoutes(router *mux.Router) {
 		json.NewEncoder(w).Encode(response)
 	}).Methods(http.MethodGet)
 
+	// API description
+	router.HandleFunc("/openapi.json", h.OpenAPI).Methods(http.MethodGet)
+	router.Handle("/swagger", http.RedirectHandler(h.config.BasePath+"/swagger/", http.StatusMovedPermanently)).Methods(http.MethodGet)
+	router.HandleFunc("/swagger/", h.SwaggerUI).Methods(http.MethodGet)
+
 	// Protected routes – app token middleware applied directly to each handler
-	router.Handle("/message",
-		auth(http.HandlerFunc(h.HandleGotifyNotification)),
-	).Methods(http.MethodPost)
+	router.Handle("/message", message).Methods(http.MethodPost)
+	router.Handle("/notification/alertmanager", alertmanager).Methods(http.MethodPost)
+	router.Handle("/notification/grafana", grafana).Methods(http.MethodPost)
+	router.Handle("/notification/uptimekuma", uptimeKuma).Methods(http.MethodPost)
+	router.Handle("/notification/github", github).Methods(http.MethodPost)
+	router.Handle("/notification/slack", slack).Methods(http.MethodPost)
+	router.Handle("/notification/discord", discord).Methods(http.MethodPost)
+	router.Handle("/notification/jenkins", jenkins).Methods(http.MethodPost)
 
 	// API v1 routes
 	api := router.PathPrefix("/api/v1").Subrouter()
-	api.HandleFunc("/health", h.HealthCheck).Methods(http.MethodGet)
-	api.Handle("/message",
-		auth(http.HandlerFunc(h.HandleGotifyNotification)),
-	).Methods(http.MethodPost)
+	api.Handle("/message", message).Methods(http.MethodPost)
+	api.Handle("/messages/{dialog}", message).Methods(http.MethodPost)
+	api.Handle("/notification/alertmanager", alertmanager).Methods(http.MethodPost)
+	api.Handle("/notification/grafana", grafana).Methods(http.MethodPost)
+	api.Handle("/notification/uptimekuma", uptimeKuma).Methods(http.MethodPost)
+	api.Handle("/notification/github", github).Methods(http.MethodPost)
+	api.Handle("/notification/slack", slack).Methods(http.MethodPost)
+	api.Handle("/notification/discord", discord).Methods(http.MethodPost)
+	api.Handle("/notification/jenkins", jenkins).Methods(http.MethodPost)
+	api.Handle("/annotate", annotate).Methods(http.MethodPost)
+
+	// Status of queued notifications, linked from 202 Accepted responses
+	if h.queue != nil {
+		api.Handle("/jobs/{id}", h.AppTokenMiddleware(http.HandlerFunc(h.GetJob))).Methods(http.MethodGet)
+	}
+
+	// Status of notifications accepted with async send or queued
+	api.Handle("/messages/{id}/status", h.AppTokenMiddleware(http.HandlerFunc(h.GetMessageStatus))).Methods(http.MethodGet)
+	api.Handle("/messages/{id}/snooze", h.AppTokenMiddleware(http.HandlerFunc(h.SnoozeMessage))).Methods(http.MethodPost)
+
+	// Recurring messages; the schedule is listed and managed on the admin routes
+	api.Handle("/schedule", h.AppTokenMiddleware(http.HandlerFunc(h.CreateSchedule))).Methods(http.MethodPost)
+}
+
+// RegisterHealthRoutes registers the public health check routes
+func (h *Handler) RegisterHealthRoutes(router *mux.Router) {
+	router.HandleFunc("/health", h.HealthCheck).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/health", h.HealthCheck).Methods(http.MethodGet)
+	router.HandleFunc("/healthz", h.Liveness).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", h.Readiness).Methods(http.MethodGet)
+}
+
+// RegisterAdminRoutes registers the administrative HTTP routes. They are
+// served on the main listener, or on a separate internal-only listener when
+// ADMIN_PORT is configured.
+func (h *Handler) RegisterAdminRoutes(router *mux.Router) {
+	auth := h.AdminTokenMiddleware
+	api := router.PathPrefix("/api/v1").Subrouter()
+
+	// Captured payload inspection and replay
+	api.Handle("/captures", auth(http.HandlerFunc(h.ListCaptures))).Methods(http.MethodGet)
+	api.Handle("/captures/{id}", auth(http.HandlerFunc(h.GetCapture))).Methods(http.MethodGet)
+	api.Handle("/captures/{id}/replay", auth(http.HandlerFunc(h.ReplayCapture))).Methods(http.MethodPost)
+
+	// Dead letters: queued notifications that exhausted their attempts
+	if h.queue != nil {
+		api.Handle("/deadletter", auth(http.HandlerFunc(h.ListDeadLetters))).Methods(http.MethodGet)
+		api.Handle("/deadletter", auth(http.HandlerFunc(h.PurgeDeadLetters))).Methods(http.MethodDelete)
+		api.Handle("/deadletter/{id}", auth(http.HandlerFunc(h.GetDeadLetter))).Methods(http.MethodGet)
+		api.Handle("/deadletter/{id}", auth(http.HandlerFunc(h.PurgeDeadLetter))).Methods(http.MethodDelete)
+		api.Handle("/deadletter/{id}/resend", auth(http.HandlerFunc(h.ResendDeadLetter))).Methods(http.MethodPost)
+	}
+
+	// Acknowledgement of notifications escalating to phone calls
+	if h.phone != nil {
+		api.Handle("/escalations", auth(http.HandlerFunc(h.ListEscalations))).Methods(http.MethodGet)
+		api.Handle("/escalations", auth(http.HandlerFunc(h.AcknowledgeEscalations))).Methods(http.MethodDelete)
+		api.Handle("/escalations/{id}/ack", auth(http.HandlerFunc(h.AcknowledgeEscalation))).Methods(http.MethodPost)
+	}
+
+	// Authentication state and the status dashboard built on it
+	api.Handle("/status", auth(http.HandlerFunc(h.AuthStatus))).Methods(http.MethodGet)
+	ui := http.StripPrefix(h.config.BasePath+"/ui/", http.FileServer(http.FS(assets.UI())))
+	router.Handle("/ui", auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The token of a browser is kept for the page and the data it loads
+		target := h.config.BasePath + "/ui/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	}))).Methods(http.MethodGet)
+	router.PathPrefix("/ui/").Handler(auth(ui)).Methods(http.MethodGet)
+
+	// Delayed notifications and recurring messages of every sender
+	api.Handle("/schedule", auth(http.HandlerFunc(h.ListSchedule))).Methods(http.MethodGet)
+	api.Handle("/schedule/{id}", auth(http.HandlerFunc(h.GetSchedule))).Methods(http.MethodGet)
+	api.Handle("/schedule/{id}", auth(http.HandlerFunc(h.DeleteSchedule))).Methods(http.MethodDelete)
+
+	// Holiday calendar of business hours
+	api.Handle("/holidays", auth(http.HandlerFunc(h.ListHolidays))).Methods(http.MethodGet)
+
+	// Console of the alerts firing in the alerting systems
+	router.Handle("/alerts", auth(http.HandlerFunc(h.ListAlerts))).Methods(http.MethodGet)
+	api.Handle("/alerts", auth(http.HandlerFunc(h.ListAlerts))).Methods(http.MethodGet)
+	api.Handle("/alerts/{fingerprint}/ack", auth(http.HandlerFunc(h.AcknowledgeAlert))).Methods(http.MethodPost)
+
+	// Held messages of moderated routes waiting for approval
+	router.Handle("/moderation", auth(http.HandlerFunc(h.ListModeration))).Methods(http.MethodGet)
+	router.Handle("/moderation/{id}/approve", auth(http.HandlerFunc(h.ApproveModeration))).Methods(http.MethodPost)
+	router.Handle("/moderation/{id}/reject", auth(http.HandlerFunc(h.RejectModeration))).Methods(http.MethodPost)
+	api.Handle("/moderation", auth(http.HandlerFunc(h.ListModeration))).Methods(http.MethodGet)
+	api.Handle("/moderation/{id}", auth(http.HandlerFunc(h.GetModeration))).Methods(http.MethodGet)
+	api.Handle("/moderation/{id}/approve", auth(http.HandlerFunc(h.ApproveModeration))).Methods(http.MethodPost)
+	api.Handle("/moderation/{id}/reject", auth(http.HandlerFunc(h.RejectModeration))).Methods(http.MethodPost)
+
+	// Token import from a browser session and login lockout reset
+	api.Handle("/token", auth(http.HandlerFunc(h.ImportToken))).Methods(http.MethodPost)
+	api.Handle("/auth/reset", auth(http.HandlerFunc(h.ResetLogin))).Methods(http.MethodPost)
+
+	// Token inspection, forced re-login and removal
+	api.Handle("/admin/token", auth(http.HandlerFunc(h.GetToken))).Methods(http.MethodGet)
+	api.Handle("/admin/token", auth(http.HandlerFunc(h.ClearToken))).Methods(http.MethodDelete)
+	api.Handle("/admin/token/refresh", auth(http.HandlerFunc(h.RefreshToken))).Methods(http.MethodPost)
+
+	// Configuration reload, like SIGHUP
+	if h.reload != nil {
+		api.Handle("/admin/reload", auth(http.HandlerFunc(h.Reload))).Methods(http.MethodPost)
+	}
+
+	// Prometheus metrics
+	router.Handle("/metrics", auth(metrics.Default.Handler())).Methods(http.MethodGet)
 }
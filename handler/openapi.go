@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/assets"
+)
+
+// openAPIAliases maps the unversioned notification paths to the API v1
+// paths they mirror
+var openAPIAliases = map[string]string{
+	"/message":                   "/api/v1/message",
+	"/notification/alertmanager": "/api/v1/notification/alertmanager",
+	"/notification/grafana":      "/api/v1/notification/grafana",
+	"/notification/uptimekuma":   "/api/v1/notification/uptimekuma",
+	"/notification/github":       "/api/v1/notification/github",
+	"/notification/slack":        "/api/v1/notification/slack",
+	"/notification/discord":      "/api/v1/notification/discord",
+	"/notification/jenkins":      "/api/v1/notification/jenkins",
+}
+
+// openAPIRoutePaths lists the API v1 paths served by each named route, whose
+// request body is described by the route's JSON Schema when one is attached
+var openAPIRoutePaths = map[string][]string{
+	"message":      {"/api/v1/message", "/api/v1/messages/{dialog}"},
+	"alertmanager": {"/api/v1/notification/alertmanager"},
+	"grafana":      {"/api/v1/notification/grafana"},
+	"uptimekuma":   {"/api/v1/notification/uptimekuma"},
+	"github":       {"/api/v1/notification/github"},
+	"slack":        {"/api/v1/notification/slack"},
+	"discord":      {"/api/v1/notification/discord"},
+	"jenkins":      {"/api/v1/notification/jenkins"},
+}
+
+// notificationResponsesRef marks operations sharing the responses of the
+// notification routes; it is expanded because OpenAPI does not allow a
+// reference for a whole responses object
+const notificationResponsesRef = "#/components/x-notificationResponses"
+
+// buildOpenAPI generates the OpenAPI document of this deployment from the
+// built-in document: the base path becomes the server URL, unversioned
+// aliases are added and route JSON Schemas replace the generic payloads
+func (h *Handler) buildOpenAPI() ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(assets.OpenAPI(), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+
+	serverURL := h.config.BasePath
+	if serverURL == "" {
+		serverURL = "/"
+	}
+	doc["servers"] = []interface{}{map[string]interface{}{"url": serverURL}}
+
+	components := doc["components"].(map[string]interface{})
+	paths := doc["paths"].(map[string]interface{})
+
+	// Attach route schemas as components, referenced by the route's operations
+	schemas := components["schemas"].(map[string]interface{})
+	for route, s := range h.schemas {
+		name := "Route" + strings.ToUpper(route[:1]) + route[1:] + "Payload"
+		payload, err := copyJSON(s.Document())
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", route, err)
+		}
+		schemas[name] = rebaseRefs(payload, "#/components/schemas/"+name)
+
+		for _, path := range openAPIRoutePaths[route] {
+			op := paths[path].(map[string]interface{})["post"].(map[string]interface{})
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/" + name},
+					},
+				},
+			}
+		}
+	}
+
+	// Expand the shared notification responses
+	shared := components[strings.TrimPrefix(notificationResponsesRef, "#/components/")]
+	delete(components, strings.TrimPrefix(notificationResponsesRef, "#/components/"))
+	for _, item := range paths {
+		for _, op := range item.(map[string]interface{}) {
+			op := op.(map[string]interface{})
+			if ref, _ := op["responses"].(map[string]interface{}); ref["$ref"] == notificationResponsesRef {
+				op["responses"] = shared
+			}
+		}
+	}
+
+	// Document the unversioned aliases, with unique operation IDs
+	aliases := make([]string, 0, len(openAPIAliases))
+	for alias := range openAPIAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		item, err := copyJSON(paths[openAPIAliases[alias]])
+		if err != nil {
+			return nil, err
+		}
+		for _, op := range item.(map[string]interface{}) {
+			op := op.(map[string]interface{})
+			op["operationId"] = fmt.Sprint(op["operationId"]) + "Unversioned"
+		}
+		paths[alias] = item
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// copyJSON deep-copies a decoded JSON value
+func copyJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var c interface{}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// rebaseRefs rewrites the local "#/..." references of a JSON Schema embedded
+// at prefix, so they resolve inside the OpenAPI document
+func rebaseRefs(v interface{}, prefix string) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, value := range node {
+			if ref, ok := value.(string); ok && key == "$ref" && strings.HasPrefix(ref, "#") {
+				node[key] = prefix + strings.TrimPrefix(ref, "#")
+				continue
+			}
+			node[key] = rebaseRefs(value, prefix)
+		}
+	case []interface{}:
+		for i, value := range node {
+			node[i] = rebaseRefs(value, prefix)
+		}
+	}
+	return v
+}
+
+// OpenAPI handles GET requests to /openapi.json
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.openAPI)
+}
+
+// SwaggerUI handles GET requests to /swagger/, an interactive view of the
+// OpenAPI document
+func (h *Handler) SwaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(assets.SwaggerUI())
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxNotificationBodySize bounds JSON and plain text notification bodies
+const maxNotificationBodySize = 10 << 20
+
+// errInvalidJSON is returned for bodies declared as JSON that do not parse
+var errInvalidJSON = errors.New("invalid JSON")
+
+// titleHeaders name the headers that title a plain text notification, as
+// understood by ntfy
+var titleHeaders = []string{"X-Title", "Title"}
+
+// decodeNotification reads a Gotify notification from the request body.
+// Bodies sent as text/plain, or that are not JSON without being declared as
+// such, become the message, so `curl -d "disk full"` just works; the title
+// then comes from the X-Title or Title header or the title query parameter.
+// plain reports whether the body was taken as plain text.
+func decodeNotification(w http.ResponseWriter, r *http.Request, req *GotifyNotificationRequest) (plain bool, err error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxNotificationBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return false, errTooLarge
+		}
+		return false, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "text/plain" {
+		err := json.Unmarshal(body, req)
+		if err == nil {
+			return false, nil
+		}
+		if declaredJSON(mediaType) {
+			return false, errInvalidJSON
+		}
+	}
+
+	*req = GotifyNotificationRequest{
+		Title:   plainTextTitle(r),
+		Message: strings.TrimSpace(string(body)),
+	}
+	return true, nil
+}
+
+// declaredJSON reports whether a media type declares a JSON body
+func declaredJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// plainTextTitle returns the title given for a plain text notification
+func plainTextTitle(r *http.Request) string {
+	for _, name := range titleHeaders {
+		if title := r.Header.Get(name); title != "" {
+			return title
+		}
+	}
+	return r.URL.Query().Get("title")
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+)
+
+// priorityHeaders are the request headers that override the priority of a
+// notification, in order of precedence. X-Priority, Priority and their
+// shorthands follow ntfy; Priority also accepts the RFC 9218 urgency form.
+var priorityHeaders = []string{"X-Priority", "Priority", "X-Prio", "Prio"}
+
+// headerPriority returns the priority set by a request header, so senders
+// that can set headers but not reshape their body can still prioritize.
+// ok is false when no priority header is present. urgency is set for the
+// RFC 9218 form, which browsers send on their own with fetch requests, so
+// it must not override a priority the sender gave explicitly.
+func headerPriority(r *http.Request) (priority int, urgency, ok bool, err error) {
+	for _, name := range priorityHeaders {
+		value := strings.TrimSpace(r.Header.Get(name))
+		if value == "" {
+			continue
+		}
+
+		if p, known := severity.Default.Lookup(severity.Ntfy, value); known {
+			return p, false, true, nil
+		}
+		priority, ok, err = parseUrgency(name, value)
+		return priority, ok, ok, err
+	}
+
+	return 0, false, false, nil
+}
+
+// parseUrgency reads the urgency parameter of an RFC 9218 priority field
+// such as "u=1, i". A field without urgency, like "i", sets no priority.
+// As RFC 9218 requires, the Priority header ignores members it does not
+// know, so future parameters do not fail requests; the other headers only
+// take ntfy priorities or urgency.
+func parseUrgency(name, value string) (int, bool, error) {
+	priority, ok := 0, false
+	for _, item := range strings.Split(value, ",") {
+		member, _, _ := strings.Cut(item, ";")
+		key, param, _ := strings.Cut(strings.TrimSpace(member), "=")
+		switch key {
+		case "u":
+			p, known := severity.Default.Lookup(severity.Urgency, param)
+			if !known {
+				return 0, false, fmt.Errorf("invalid %s header %q: urgency must be 0-7", name, value)
+			}
+			priority, ok = p, true
+		case "i":
+		default:
+			if name == "Priority" {
+				continue
+			}
+			return 0, false, fmt.Errorf("invalid %s header %q: use 1-5, min, low, default, high, max or u=0-7", name, value)
+		}
+	}
+
+	return priority, ok, nil
+}
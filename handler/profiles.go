@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"fmt"
+	"text/template"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// loadProfileTemplates compiles the message templates configured for
+// formatting profiles
+func (h *Handler) loadProfileTemplates() error {
+	h.profileTemplates = make(map[string]*template.Template)
+
+	for name, p := range h.config.Profiles {
+		if p.Template == "" {
+			continue
+		}
+
+		tmpl, err := render.Parse(name+" profile", p.Template, h.userFuncs())
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+
+		h.profileTemplates[name] = tmpl
+	}
+
+	return nil
+}
+
+// applyProfile formats the rendered text of a notification as the profile
+// of its dialog asks: emoji first, then cut to its maximum length. Numbers
+// are written in the profile language by templates, through Number.
+func applyProfile(p *config.Profile, n *render.Notification, text string) string {
+	if emoji := p.Emoji[n.Severity()]; emoji != "" {
+		text = emoji + " " + text
+	}
+
+	return render.Truncate(text, p.MaxLength)
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/quiet"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+var quietNotifications = metrics.NewCounter("quiet_hours_notifications_total",
+	"Notifications sent during quiet hours by window and result: buffered, passed or digested.", "window", "result")
+
+// quietHours returns the quiet hours holding back a notification to a
+// dialog: the dialog is in its quiet hours and the priority is below their
+// pass priority. Notifications passing through are counted.
+func (h *Handler) quietHours(log *logger.Logger, n *render.Notification, dialogID string) (string, *config.QuietHours) {
+	name, q := h.config.DialogQuietHours(dialogID)
+	if q == nil || !q.Quiet(n.Time) {
+		return "", nil
+	}
+
+	if n.Priority >= q.PassPriority {
+		log.Debug("Notification passes quiet hours", "window", name, "dialog", dialogID, "priority", n.Priority)
+		quietNotifications.Inc(name, "passed")
+		return "", nil
+	}
+	return name, q
+}
+
+// buffer holds back a notification to a dialog in its quiet hours until
+// its digest is delivered at the end of the quiet hours
+func (h *Handler) buffer(ctx context.Context, log *logger.Logger, window string, q *config.QuietHours, account *mizito.Account, n *render.Notification, msg *mizito.Message) (int, NotificationResponse) {
+	m := &quiet.Message{
+		ID:          contextMessageID(ctx),
+		Window:      window,
+		Route:       n.Route,
+		Account:     account.Name,
+		DialogID:    msg.DialogID,
+		Text:        msg.Text,
+		Priority:    n.Priority,
+		FromUserID:  msg.FromUserID,
+		RequestID:   logger.RequestID(ctx),
+		Attachments: msg.Attachments,
+	}
+	if err := h.quiet.Add(m); err != nil {
+		log.Error("Failed to buffer notification during quiet hours", "route", n.Route, "error", err)
+		return http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: "Failed to buffer notification during quiet hours: " + err.Error(),
+		}
+	}
+
+	digestAt := q.Ends(n.Time)
+	h.messages.Accept(m.ID)
+	quietNotifications.Inc(window, "buffered")
+	log.Info("Notification buffered during quiet hours", "route", n.Route, "window", window, "dialog", msg.DialogID, "digest_at", digestAt)
+
+	return http.StatusAccepted, NotificationResponse{
+		Success:     true,
+		Message:     "Notification buffered for the digest after quiet hours",
+		ID:          m.ID,
+		ScheduledAt: &digestAt,
+	}
+}
+
+// FlushDigests delivers a digest of the buffered notifications of each
+// dialog whose quiet hours ended. Dialogs no longer having quiet hours are
+// due as well.
+func (h *Handler) FlushDigests(ctx context.Context) {
+	now := time.Now()
+	digests, err := h.quiet.Take(func(dialogID string) bool {
+		_, q := h.config.DialogQuietHours(dialogID)
+		return q == nil || !q.Quiet(now)
+	})
+	if err != nil {
+		h.logger.Error("Failed to take digests of quiet hours", "error", err)
+		return
+	}
+
+	for _, d := range digests {
+		h.deliverDigest(ctx, d, now)
+	}
+}
+
+// deliverDigest delivers a digest of buffered notifications on the digest
+// route, through the account that buffered them
+func (h *Handler) deliverDigest(ctx context.Context, d *quiet.Digest, now time.Time) {
+	log := h.logger.WithContext(ctx)
+
+	account, ok := h.accounts[d.Account]
+	if !ok {
+		log.Error("Digest of unknown account dropped", "account", d.Account, "dialog", d.DialogID, "notifications", len(d.Messages))
+		return
+	}
+
+	loc := h.config.Timezone
+	if _, q := h.config.DialogQuietHours(d.DialogID); q != nil {
+		loc = q.Timezone
+	}
+
+	n := &render.Notification{
+		Route:       quiet.RouteName,
+		Title:       "Quiet hours digest",
+		Priority:    d.Priority(),
+		Time:        now,
+		DialogID:    d.DialogID,
+		Attachments: d.Attachments(),
+	}
+	msg := &mizito.Message{
+		Text:        d.Text(loc),
+		Priority:    n.Priority,
+		DialogID:    d.DialogID,
+		FromUserID:  h.config.Route(quiet.RouteName).FromUserID,
+		Attachments: n.Attachments,
+	}
+
+	// The buffered notifications are done once their digest is delivered or
+	// queued
+	status, response := h.deliverRendered(ctx, log, account, n, msg)
+	for _, m := range d.Messages {
+		h.track(m.ID, response)
+	}
+	if !response.Success {
+		log.Error("Failed to deliver digest of quiet hours", "dialog", d.DialogID, "notifications", len(d.Messages), "status", status, "error", response.Message)
+		return
+	}
+
+	quietNotifications.Add(float64(len(d.Messages)), d.Window, "digested")
+	log.Info("Digest of quiet hours delivered", "window", d.Window, "dialog", d.DialogID, "notifications", len(d.Messages))
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Component states of a readiness check
+const (
+	ComponentOK       = "ok"
+	ComponentFailing  = "failing"
+	ComponentDisabled = "disabled"
+)
+
+// LivenessResponse is the response of /healthz
+type LivenessResponse struct {
+	Status string `json:"status"`
+}
+
+// ReadinessResponse is the response of /readyz
+type ReadinessResponse struct {
+	// Status is "ready" when every component is ok or disabled
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+
+	// Components holds the state of each component: the Mizito host and
+	// the token of each account as mizito:<account> and token:<account>,
+	// and queue
+	Components map[string]*ComponentStatus `json:"components"`
+}
+
+// ComponentStatus is the state of one component of a readiness check
+type ComponentStatus struct {
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// readiness caches the last readiness check for READINESS_CACHE_TTL, so
+// frequent probes do not each contact Mizito
+type readiness struct {
+	mutex sync.Mutex
+	last  *ReadinessResponse
+}
+
+// Liveness handles GET /healthz. It only tells that the process serves
+// requests, so orchestrators restart it when it does not.
+func (h *Handler) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, LivenessResponse{Status: "alive"})
+}
+
+// Readiness handles GET /readyz. It answers 503 Service Unavailable when
+// Mizito is unreachable, an account has no valid token and cannot get one,
+// or the queue holds more than READINESS_QUEUE_LIMIT jobs, so orchestrators
+// stop routing notifications to an instance that cannot deliver them.
+func (h *Handler) Readiness(w http.ResponseWriter, r *http.Request) {
+	h.readiness.mutex.Lock()
+	response := h.readiness.last
+	if response == nil || time.Since(response.CheckedAt) >= h.config.ReadinessCacheTTL {
+		response = h.checkReadiness(r.Context())
+		h.readiness.last = response
+	}
+	h.readiness.mutex.Unlock()
+
+	status := http.StatusOK
+	if response.Status != "ready" {
+		status = http.StatusServiceUnavailable
+		h.logger.WithContext(r.Context()).Warn("Readiness check failed", "components", failingComponents(response))
+	}
+	writeJSON(w, status, response)
+}
+
+// checkReadiness checks every component
+func (h *Handler) checkReadiness(ctx context.Context) *ReadinessResponse {
+	ctx, cancel := context.WithTimeout(ctx, h.config.ReadinessTimeout)
+	defer cancel()
+
+	response := &ReadinessResponse{
+		Status:     "ready",
+		CheckedAt:  time.Now(),
+		Components: make(map[string]*ComponentStatus),
+	}
+
+	// Each account may use its own Mizito host
+	for _, name := range h.config.AccountNames() {
+		account, ok := h.accounts[name]
+		if !ok {
+			continue
+		}
+		response.Components["mizito:"+name] = checkComponent(func() error {
+			return account.Auth.Ping(ctx)
+		}, account.Auth.DisplayError)
+		response.Components["token:"+name] = checkComponent(func() error {
+			return account.Auth.EnsureValidToken(ctx)
+		}, account.Auth.DisplayError)
+	}
+
+	response.Components["queue"] = h.queueReadiness()
+
+	for _, component := range response.Components {
+		if component.Status == ComponentFailing {
+			response.Status = "not_ready"
+		}
+	}
+	return response
+}
+
+// checkComponent runs a check, timing it; display renders its error
+func checkComponent(check func() error, display func(error) string) *ComponentStatus {
+	start := time.Now()
+	err := check()
+	component := &ComponentStatus{
+		Status:   ComponentOK,
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		component.Status = ComponentFailing
+		component.Message = display(err)
+	}
+	return component
+}
+
+// queueReadiness compares the number of queued jobs to the limit
+func (h *Handler) queueReadiness() *ComponentStatus {
+	if h.queue == nil {
+		return &ComponentStatus{Status: ComponentDisabled}
+	}
+
+	queued := h.queue.Len()
+	component := &ComponentStatus{
+		Status:  ComponentOK,
+		Message: strconv.Itoa(queued) + " jobs queued",
+	}
+	if limit := h.config.ReadinessQueueLimit; limit > 0 && queued > limit {
+		component.Status = ComponentFailing
+		component.Message += ", more than the limit of " + strconv.Itoa(limit)
+	}
+	return component
+}
+
+// failingComponents returns the names of the failing components, for logs
+func failingComponents(response *ReadinessResponse) []string {
+	var names []string
+	for name, component := range response.Components {
+		if component.Status == ComponentFailing {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
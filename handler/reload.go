@@ -0,0 +1,43 @@
+package handler
+
+import "net/http"
+
+// SetReloader enables /api/v1/admin/reload, reloading the configuration
+// with reload. It must be called before the admin routes are registered.
+func (h *Handler) SetReloader(reload func() error) {
+	h.reload = reload
+}
+
+// SetCurrent lets work outliving its request, such as the count of
+// duplicates delivered when a dedup window closes, run with the handler of
+// the configuration current by then. current returns that handler and a
+// function to call once done with it.
+func (h *Handler) SetCurrent(current func() (*Handler, func())) {
+	h.current = current
+}
+
+// currentHandler returns the handler of the current configuration, or h
+// when configurations are not reloaded
+func (h *Handler) currentHandler() (*Handler, func()) {
+	if h.current == nil {
+		return h, func() {}
+	}
+	return h.current()
+}
+
+// Reload handles POST /api/v1/admin/reload, reloading the configuration
+// like SIGHUP. Requests in flight finish with the previous configuration.
+func (h *Handler) Reload(w http.ResponseWriter, r *http.Request) {
+	if err := h.reload(); err != nil {
+		writeJSON(w, http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: "Configuration not reloaded: " + err.Error(),
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NotificationResponse{
+		Success: true,
+		Message: "Configuration reloaded",
+	})
+}
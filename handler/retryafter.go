@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+)
+
+// sendFailure returns the response to a notification Mizito did not take,
+// telling senders whether and when to retry it:
+//   - 429 Too Many Requests when the outgoing rate limit turned it away,
+//     retried once the limit has a free slot
+//   - 422 Unprocessable Entity when Mizito refused it; retrying fails the
+//     same way
+//   - 503 Service Unavailable when Mizito is unreachable, failing or cannot
+//     be logged in to, retried after RESPONSE_RETRY_AFTER
+func (h *Handler) sendFailure(account *mizito.Account, err error) (int, NotificationResponse) {
+	switch {
+	case errors.Is(err, mizito.ErrRateLimited):
+		return http.StatusTooManyRequests, NotificationResponse{
+			Success:    false,
+			Message:    "Outgoing message rate limit exceeded, try again later",
+			RetryAfter: h.rateLimitRetryAfter(account),
+		}
+	case mizito.Rejected(err):
+		return http.StatusUnprocessableEntity, NotificationResponse{
+			Success: false,
+			Message: "Mizito rejected the notification: " + err.Error(),
+		}
+	default:
+		return http.StatusServiceUnavailable, NotificationResponse{
+			Success:    false,
+			Message:    "Failed to send notification: " + err.Error(),
+			RetryAfter: h.retryAfter(),
+		}
+	}
+}
+
+// retryAfter returns RESPONSE_RETRY_AFTER in seconds
+func (h *Handler) retryAfter() int {
+	return int(math.Ceil(h.config.ResponseRetryAfter.Seconds()))
+}
+
+// rateLimitRetryAfter returns the seconds until the outgoing rate limit of
+// an account frees a slot
+func (h *Handler) rateLimitRetryAfter(account *mizito.Account) int {
+	perMinute := h.accountConfig(account).MaxMessagesPerMinute
+	if perMinute <= 0 {
+		return 1
+	}
+	return int(math.Ceil(60 / float64(perMinute)))
+}
+
+// writeNotification writes a notification response, with a Retry-After
+// header when it asks the sender to retry
+func writeNotification(w http.ResponseWriter, status int, response NotificationResponse) {
+	if response.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(response.RetryAfter))
+	}
+	writeJSON(w, status, response)
+}
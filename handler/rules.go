@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/rules"
+)
+
+var (
+	ruleMatches = metrics.NewCounter("rule_matches_total",
+		"Notifications matched by routing rules, by rule.", "rule")
+	ruleDrops = metrics.NewCounter("rule_dropped_notifications_total",
+		"Notifications dropped by routing rules, by rule and reason: drop or rate_limit.", "rule", "reason")
+)
+
+// payloadContextKey is the context key holding the raw inbound body
+type payloadContextKey struct{}
+
+// loadRules compiles the routing rules of RULES_FILE and their templates
+func (h *Handler) loadRules() error {
+	h.ruleTemplates = make(map[string]*template.Template)
+	if h.config.RulesFile == "" {
+		return nil
+	}
+
+	engine, err := rules.Load(h.config.RulesFile)
+	if err != nil {
+		return fmt.Errorf("RULES_FILE: %w", err)
+	}
+
+	for name, text := range engine.Templates() {
+		tmpl, err := render.Parse(name+" rule", text, h.userFuncs())
+		if err != nil {
+			return fmt.Errorf("RULES_FILE: rule %s: %w", name, err)
+		}
+		h.ruleTemplates[name] = tmpl
+	}
+
+	h.rules = engine
+	h.logger.Info("Routing rules loaded", "file", h.config.RulesFile, "rules", engine.Rules())
+	return nil
+}
+
+// rulePayloadMiddleware keeps the raw inbound body in the request context
+// when routing rules match fields of the payload
+func (h *Handler) rulePayloadMiddleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.rules == nil || !h.rules.NeedsPayload() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := h.maxBodySize()
+		body, err := readBody(w, r, limit)
+		if err != nil {
+			h.logger.WithContext(r.Context()).Error("Failed to read request body for routing rules", "route", route, "error", err)
+			writeBodyError(w, err, limit)
+			return
+		}
+
+		// Restore the body for the actual route handler
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), payloadContextKey{}, body)))
+	})
+}
+
+// applyRules evaluates the routing rules for a notification; the returned
+// decision is empty without RULES_FILE
+func (h *Handler) applyRules(r *http.Request, log *logger.Logger, n *render.Notification) rules.Decision {
+	if h.rules == nil {
+		return rules.Decision{}
+	}
+
+	payload, _ := r.Context().Value(payloadContextKey{}).([]byte)
+	decision := h.rules.Evaluate(n, payload)
+	for _, name := range decision.Rules {
+		ruleMatches.Inc(name)
+	}
+
+	switch {
+	case decision.RateLimited:
+		ruleDrops.Inc(decision.DroppedBy, "rate_limit")
+		log.Info("Notification dropped by rate limit of rule", "route", n.Route, "rule", decision.DroppedBy)
+	case decision.Drop:
+		ruleDrops.Inc(decision.DroppedBy, "drop")
+		log.Info("Notification dropped by rule", "route", n.Route, "rule", decision.DroppedBy)
+	case len(decision.Rules) > 0:
+		log.Debug("Notification matched routing rules", "route", n.Route, "rules", decision.Rules)
+	}
+	return decision
+}
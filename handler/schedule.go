@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/schedule"
+	"github.com/gorilla/mux"
+)
+
+// delayHeader holds a notification back for later delivery
+const delayHeader = "X-Delay"
+
+// maxScheduleBodySize caps the body of a recurring message
+const maxScheduleBodySize = 1 << 20
+
+// delayContextKey is the context key holding the delay of a payload field
+type delayContextKey struct{}
+
+// Delay is a delivery delay in a payload: a duration such as "30m", a
+// number of seconds, or an RFC 3339 time to deliver at
+type Delay string
+
+// UnmarshalJSON accepts the delay as a string or a number of seconds
+func (d *Delay) UnmarshalJSON(data []byte) error {
+	var seconds json.Number
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Delay(seconds)
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("delay must be a duration, a number of seconds or a time")
+	}
+	*d = Delay(value)
+	return nil
+}
+
+// withDelay keeps the delay of a payload field in the request context
+func withDelay(r *http.Request, delay Delay) *http.Request {
+	if delay == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), delayContextKey{}, string(delay)))
+}
+
+// requestedDelay returns when a notification is to be delivered, as asked
+// with the X-Delay header, the delay query parameter or the delay field of
+// the payload; ok is false for notifications delivered right away
+func (h *Handler) requestedDelay(r *http.Request) (at time.Time, ok bool, err error) {
+	value := r.Header.Get(delayHeader)
+	if value == "" {
+		value = r.URL.Query().Get("delay")
+	}
+	if value == "" {
+		value, _ = r.Context().Value(delayContextKey{}).(string)
+	}
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+
+	now := time.Now()
+	if seconds, err := strconv.Atoi(value); err == nil {
+		at = now.Add(time.Duration(seconds) * time.Second)
+	} else if d, err := time.ParseDuration(value); err == nil {
+		at = now.Add(d)
+	} else if at, err = time.Parse(time.RFC3339, value); err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid delay %q, expected a duration such as 30m, a number of seconds or an RFC 3339 time", value)
+	}
+
+	switch {
+	case !at.After(now):
+		return time.Time{}, false, fmt.Errorf("delay %q is not in the future", value)
+	case at.Sub(now) > h.config.ScheduleMaxDelay:
+		return time.Time{}, false, fmt.Errorf("delay %q exceeds SCHEDULE_MAX_DELAY of %s", value, h.config.ScheduleMaxDelay)
+	}
+	return at, true, nil
+}
+
+// delay hands a notification to the scheduler, which submits it through
+// the rest of the pipeline at the given time
+func (h *Handler) delay(w http.ResponseWriter, r *http.Request, log *logger.Logger, account *mizito.Account, n *render.Notification, at time.Time) {
+	entry, err := h.scheduler.Delay(r.Context(), n, account.Name, at)
+	if err != nil {
+		log.Error("Failed to schedule notification", "route", n.Route, "error", err)
+		writeJSON(w, http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: "Failed to schedule notification: " + err.Error(),
+		})
+		return
+	}
+
+	log.Info("Notification delayed", "route", n.Route, "id", entry.ID, "deliver_at", entry.Next)
+	w.Header().Set("Location", h.schedulePath(entry.ID))
+	writeJSON(w, http.StatusAccepted, NotificationResponse{
+		Success:     true,
+		Message:     "Notification scheduled for delivery",
+		ID:          entry.ID,
+		ScheduledAt: &entry.Next,
+	})
+}
+
+// schedulePath returns the URL path of a scheduled message
+func (h *Handler) schedulePath(id string) string {
+	return h.config.BasePath + "/api/v1/schedule/" + id
+}
+
+// ScheduleRequest is a recurring message created through /api/v1/schedule
+type ScheduleRequest struct {
+	// Cron is the schedule in TIMEZONE, e.g. "30 9 * * sat-wed"
+	Cron     string `json:"cron"`
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+	Dialog   string `json:"dialog,omitempty"`
+
+	// Route borrows the dialog and templates of a route; "schedule" when
+	// empty
+	Route   string `json:"route,omitempty"`
+	Account string `json:"account,omitempty"`
+
+	// SkipHolidays skips the runs falling on holidays
+	SkipHolidays bool `json:"skip_holidays,omitempty"`
+}
+
+// ListSchedule handles GET requests to /api/v1/schedule. It lists the
+// delayed notifications and recurring messages, the next due first.
+func (h *Handler) ListSchedule(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.scheduler.List())
+}
+
+// GetSchedule handles GET requests to /api/v1/schedule/{id}
+func (h *Handler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.scheduler.Get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Scheduled message not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// CreateSchedule handles POST requests to /api/v1/schedule. It schedules a
+// recurring message by a cron expression evaluated in TIMEZONE.
+func (h *Handler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	log := h.logger.WithContext(r.Context())
+
+	var req ScheduleRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxScheduleBodySize)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	reject := func(status int, message string) {
+		writeJSON(w, status, NotificationResponse{Success: false, Message: message})
+	}
+	if req.Cron == "" {
+		reject(http.StatusBadRequest, "cron is required")
+		return
+	}
+	if req.Title == "" && req.Message == "" {
+		reject(http.StatusBadRequest, "Title or message is required")
+		return
+	}
+
+	route := req.Route
+	if route == "" {
+		route = schedule.RouteName
+	}
+	account, accountName := h.account(req.Account, route)
+	if account == nil {
+		reject(http.StatusBadRequest, "Unknown account: "+accountName)
+		return
+	}
+	if req.Dialog != "" && !h.accountConfig(account).DialogAllowed(req.Dialog) {
+		reject(http.StatusForbidden, "Dialog is not allowed: "+req.Dialog)
+		return
+	}
+
+	entry, err := h.scheduler.Add(&schedule.Entry{
+		Cron:         req.Cron,
+		SkipHolidays: req.SkipHolidays,
+		Route:        route,
+		Title:        req.Title,
+		Message:      req.Message,
+		Priority:     req.Priority,
+		DialogID:     req.Dialog,
+		Account:      strings.ToLower(req.Account),
+		RequestID:    logger.RequestID(r.Context()),
+	})
+	if err != nil {
+		log.Warn("Rejected recurring message", "cron", req.Cron, "error", err)
+		reject(http.StatusBadRequest, "Invalid schedule: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Location", h.schedulePath(entry.ID))
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+// DeleteSchedule handles DELETE requests to /api/v1/schedule/{id}. It
+// cancels a delayed notification or a recurring message.
+func (h *Handler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	ok, err := h.scheduler.Remove(id)
+	switch {
+	case err != nil:
+		h.logger.WithContext(r.Context()).Error("Failed to cancel scheduled message", "id", id, "error", err)
+		http.Error(w, "Failed to cancel scheduled message", http.StatusInternalServerError)
+	case !ok:
+		http.Error(w, "Scheduled message not found", http.StatusNotFound)
+	default:
+		writeJSON(w, http.StatusOK, NotificationResponse{
+			Success: true,
+			Message: "Scheduled message cancelled",
+			ID:      id,
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/schema"
+)
+
+// ValidationErrorResponse is returned when a payload violates the route schema
+type ValidationErrorResponse struct {
+	Error      string             `json:"error"`
+	Message    string             `json:"message"`
+	Violations []schema.Violation `json:"violations"`
+}
+
+// loadSchemas compiles the JSON Schemas attached to routes in the configuration
+func (h *Handler) loadSchemas() error {
+	for name, rc := range h.config.Routes {
+		if rc.Schema == "" {
+			continue
+		}
+
+		s, err := schema.Load(rc.Schema)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", name, err)
+		}
+
+		h.schemas[name] = s
+		h.logger.Info("JSON schema attached to route", "route", name, "schema", rc.Schema)
+	}
+
+	return nil
+}
+
+// schemaMiddleware rejects JSON payloads that violate the JSON Schema
+// attached to the route. Plain text and multipart bodies, which the routes
+// take as well, are not JSON and pass unchecked.
+func (h *Handler) schemaMiddleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := h.schemas[route]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "text/plain" || mediaType == "multipart/form-data" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit := h.maxBodySize()
+		body, err := readBody(w, r, limit)
+		if err != nil {
+			h.logger.WithContext(r.Context()).Error("Failed to read request body for validation", "route", route, "error", err)
+			writeBodyError(w, err, limit)
+			return
+		}
+
+		// Restore the body for the actual route handler
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		violations, err := s.ValidateJSON(body)
+		if err != nil {
+			// Bodies not declared as JSON that do not parse are plain text
+			if !declaredJSON(mediaType) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.logger.WithContext(r.Context()).Warn("Payload is not valid JSON", "route", route, "error", err)
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if len(violations) > 0 {
+			h.logger.WithContext(r.Context()).Warn("Payload rejected by route schema", "route", route, "violations", len(violations))
+			writeJSON(w, http.StatusUnprocessableEntity, ValidationErrorResponse{
+				Error:      "Unprocessable Entity",
+				Message:    "Payload does not match the schema configured for this route",
+				Violations: violations,
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/audit"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/queue"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/sink"
+)
+
+var (
+	sinkMessages = metrics.NewCounter("sink_messages_total",
+		"Notifications handed to sinks besides Mizito, by sink and result: success, failure or skipped.", "sink", "result")
+	sinkRetries = metrics.NewCounter("sink_retries_total",
+		"Notifications handed to a sink again after it failed to take them, by sink.", "sink")
+)
+
+// loadSinks creates the configured sinks
+func (h *Handler) loadSinks() error {
+	if h.config.FileSinkPath != "" {
+		file, err := sink.NewFile(h.config.FileSinkPath, h.config.FileSinkFormat)
+		if err != nil {
+			return err
+		}
+		h.sinks = append(h.sinks, file)
+	}
+
+	routeCommands := make(map[string]string)
+	for name, rc := range h.config.Routes {
+		if rc.ExecCommand != "" {
+			routeCommands[name] = rc.ExecCommand
+		}
+	}
+	if h.config.ExecSinkCommand != "" || len(routeCommands) > 0 {
+		h.sinks = append(h.sinks, sink.NewExec(sink.ExecConfig{
+			Command:       h.config.ExecSinkCommand,
+			RouteCommands: routeCommands,
+			Timeout:       h.config.ExecSinkTimeout,
+			Concurrency:   h.config.ExecSinkConcurrency,
+			Env:           h.config.ExecSinkEnv,
+		}, h.logger))
+	}
+
+	if h.config.MQTTSinkURL != "" {
+		mqtt, err := sink.NewMQTT(sink.MQTTConfig{
+			URL:      h.config.MQTTSinkURL,
+			Topic:    h.config.MQTTSinkTopic,
+			QoS:      h.config.MQTTSinkQoS,
+			Retain:   h.config.MQTTSinkRetain,
+			ClientID: h.config.MQTTSinkClientID,
+			Timeout:  h.config.MQTTSinkTimeout,
+		}, h.logger)
+		if err != nil {
+			return fmt.Errorf("MQTT_SINK_URL: %w", err)
+		}
+		h.sinks = append(h.sinks, mqtt)
+	}
+
+	if h.config.SMSProvider != "" {
+		sms, err := sink.NewSMS(sink.SMSConfig{
+			Provider:    h.config.SMSProvider,
+			Recipients:  h.config.SMSRecipients,
+			Sender:      h.config.SMSSender,
+			MinPriority: h.config.SMSMinPriority,
+			MaxLength:   h.config.SMSMaxLength,
+			MaxPerHour:  h.config.SMSMaxPerHour,
+			Timeout:     h.config.SMSTimeout,
+			APIURL:      h.config.SMSAPIURL,
+			APIKey:      h.config.KavenegarAPIKey,
+			AccountSID:  h.config.TwilioAccountSID,
+			AuthToken:   h.config.TwilioAuthToken,
+		}, h.logger)
+		if err != nil {
+			return fmt.Errorf("SMS_PROVIDER: %w", err)
+		}
+		h.sinks = append(h.sinks, sms)
+	}
+
+	for _, platform := range config.Messengers {
+		mc := h.config.Messengers[platform]
+		if mc == nil || mc.BotToken == "" {
+			continue
+		}
+		routeChats := make(map[string]string)
+		for name, rc := range h.config.Routes {
+			if chatID := rc.MessengerChats[platform]; chatID != "" {
+				routeChats[name] = chatID
+			}
+		}
+		messenger, err := sink.NewMessenger(sink.MessengerConfig{
+			Platform:   platform,
+			BotToken:   mc.BotToken,
+			ChatID:     mc.ChatID,
+			RouteChats: routeChats,
+			Failover:   mc.Mode == config.MessengerFailover,
+			Timeout:    mc.Timeout,
+			APIURL:     mc.APIURL,
+		}, h.logger)
+		if err != nil {
+			return fmt.Errorf("%s_BOT_TOKEN: %w", strings.ToUpper(platform), err)
+		}
+		h.sinks = append(h.sinks, messenger)
+	}
+
+	if h.config.PhoneProvider != "" {
+		phone, err := sink.NewPhone(sink.PhoneConfig{
+			Provider:    h.config.PhoneProvider,
+			Recipients:  h.config.PhoneRecipients,
+			CallerID:    h.config.PhoneCallerID,
+			MinPriority: h.config.PhoneMinPriority,
+			Delay:       h.config.PhoneEscalationDelay,
+			Language:    h.config.PhoneLanguage,
+			Timeout:     h.config.PhoneTimeout,
+			APIURL:      h.config.PhoneAPIURL,
+			AccountSID:  h.config.TwilioAccountSID,
+			AuthToken:   h.config.TwilioAuthToken,
+			Username:    h.config.AsteriskARIUsername,
+			Password:    h.config.AsteriskARIPassword,
+			Context:     h.config.AsteriskContext,
+			Extension:   h.config.AsteriskExtension,
+		}, h.escalations, h.logger)
+		if err != nil {
+			return fmt.Errorf("PHONE_PROVIDER: %w", err)
+		}
+		h.phone = phone
+		h.sinks = append(h.sinks, phone)
+	}
+
+	return h.checkDelivery()
+}
+
+// Sinks returns the configured sinks, so they can be stopped on shutdown
+func (h *Handler) Sinks() []sink.Sink {
+	return h.sinks
+}
+
+// sinkMessage returns a rendered notification as handed to sinks
+func sinkMessage(ctx context.Context, n *render.Notification, text, dialogID string) *sink.Message {
+	msg := &sink.Message{
+		Time:      n.Time,
+		Route:     n.Route,
+		Title:     n.Title,
+		Message:   n.Message,
+		Priority:  n.Priority,
+		DialogID:  dialogID,
+		RequestID: logger.RequestID(ctx),
+		Text:      text,
+
+		Fingerprints: n.Fingerprints,
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	return msg
+}
+
+// fanOut hands a rendered notification to the sinks, except those standing
+// in for Mizito and those in skip, the targets of a delivery chain. Failures
+// are logged and counted but do not affect delivery to Mizito.
+func (h *Handler) fanOut(ctx context.Context, log *logger.Logger, msg *sink.Message, skip map[string]bool) []audit.SinkOutcome {
+	var outcomes []audit.SinkOutcome
+	for _, s := range h.sinks {
+		if isFailover(s) || skip[s.Name()] {
+			continue
+		}
+		outcomes = append(outcomes, h.sendToSink(ctx, log, s, msg, false))
+	}
+	return outcomes
+}
+
+// failOver hands a notification Mizito failed to take to the failover sinks
+func (h *Handler) failOver(ctx context.Context, log *logger.Logger, msg *sink.Message) []audit.SinkOutcome {
+	var outcomes []audit.SinkOutcome
+	for _, s := range h.sinks {
+		if !isFailover(s) {
+			continue
+		}
+		log.Info("Handing notification to failover sink", "sink", s.Name(), "route", msg.Route)
+		outcomes = append(outcomes, h.sendToSink(ctx, log, s, msg, false))
+	}
+	return outcomes
+}
+
+// FailOverJob hands a queued notification whose first delivery attempt
+// failed to the failover sinks; the queue keeps retrying Mizito
+func (h *Handler) FailOverJob(ctx context.Context, job *queue.Job) {
+	if job.RequestID != "" {
+		ctx = logger.WithRequestID(ctx, job.RequestID)
+	}
+	h.failOver(ctx, h.logger.WithContext(ctx), &sink.Message{
+		Time:      job.CreatedAt,
+		Route:     job.Route,
+		Priority:  job.Priority,
+		DialogID:  job.DialogID,
+		RequestID: job.RequestID,
+		Text:      job.Text,
+	})
+}
+
+// sendToSink hands a message to a sink, retrying up to the sink's
+// SINK_RETRIES budget, and logs and counts the outcome. With wait, sinks
+// sending in the background deliver the message before returning, as a
+// delivery chain needs to know whether it was taken.
+func (h *Handler) sendToSink(ctx context.Context, log *logger.Logger, s sink.Sink, msg *sink.Message, wait bool) audit.SinkOutcome {
+	outcome := audit.SinkOutcome{Sink: s.Name()}
+	deliverer, async := s.(sink.Deliverer)
+	retries := h.config.SinkRetries[s.Name()]
+
+	for {
+		outcome.Attempts++
+
+		var err error
+		if wait && async {
+			err = deliverer.Deliver(ctx, msg)
+		} else {
+			err = s.Send(ctx, msg)
+		}
+
+		switch {
+		case errors.Is(err, sink.ErrSkipped):
+			outcome.Status = audit.StatusSkipped
+			sinkMessages.Inc(s.Name(), "skipped")
+			return outcome
+		case err == nil:
+			// Sinks sending in the background have only queued the message
+			outcome.Status = audit.StatusSent
+			if async && !wait {
+				outcome.Status = audit.StatusQueued
+			}
+			sinkMessages.Inc(s.Name(), "success")
+			return outcome
+		case outcome.Attempts > retries || ctx.Err() != nil:
+			log.Warn("Failed to hand notification to sink", "sink", s.Name(), "attempts", outcome.Attempts, "error", err)
+			sinkMessages.Inc(s.Name(), "failure")
+			outcome.Status = audit.StatusFailed
+			outcome.Error = err.Error()
+			return outcome
+		}
+
+		sinkRetries.Inc(s.Name())
+		log.Info("Sink failed to take notification, retrying", "sink", s.Name(), "attempt", outcome.Attempts, "retry_in", h.config.SinkRetryBackoff, "error", err)
+		select {
+		case <-time.After(h.config.SinkRetryBackoff):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// isFailover reports whether a sink only stands in for Mizito
+func isFailover(s sink.Sink) bool {
+	f, ok := s.(sink.Failover)
+	return ok && f.Failover()
+}
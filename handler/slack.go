@@ -0,0 +1,355 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+)
+
+// defaultSlackPriority is used for messages without an attachment of a
+// color of the slack severity scale
+const defaultSlackPriority = 5
+
+// SlackWebhook is the payload of a Slack incoming webhook. Tools post it as
+// JSON, or form-encoded in a payload field.
+type SlackWebhook struct {
+	Text        string            `json:"text"`
+	Blocks      []SlackBlock      `json:"blocks"`
+	Attachments []SlackAttachment `json:"attachments"`
+	Username    string            `json:"username"`
+	Channel     string            `json:"channel"`
+	IconEmoji   string            `json:"icon_emoji"`
+}
+
+// SlackBlock is a Block Kit layout block. Only blocks carrying text are
+// rendered; interactive blocks are dropped.
+type SlackBlock struct {
+	Type     string            `json:"type"`
+	Text     *SlackText        `json:"text"`
+	Fields   []SlackText       `json:"fields"`
+	Elements []json.RawMessage `json:"elements"`
+	AltText  string            `json:"alt_text"`
+	ImageURL string            `json:"image_url"`
+}
+
+// SlackText is a Block Kit text object
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SlackAttachment is a legacy secondary attachment, still used by many tools
+type SlackAttachment struct {
+	Fallback   string       `json:"fallback"`
+	Color      string       `json:"color"`
+	Pretext    string       `json:"pretext"`
+	AuthorName string       `json:"author_name"`
+	Title      string       `json:"title"`
+	TitleLink  string       `json:"title_link"`
+	Text       string       `json:"text"`
+	Fields     []SlackField `json:"fields"`
+	Footer     string       `json:"footer"`
+	Blocks     []SlackBlock `json:"blocks"`
+}
+
+// SlackField is a field of a legacy attachment
+type SlackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// HandleSlackNotification handles POST requests with Slack incoming-webhook
+// payloads, so tools that only support Slack can target the forwarder
+func (h *Handler) HandleSlackNotification(w http.ResponseWriter, r *http.Request) {
+	log := h.routeLogger(r, routeName(r))
+	log.Info("Received Slack webhook request")
+
+	req, err := parseSlackWebhook(r)
+	if err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	title, message := req.title(), req.text()
+	if title == "" && message == "" {
+		log.Warn("Empty Slack webhook request")
+		http.Error(w, "Text, blocks or attachments are required", http.StatusBadRequest)
+		return
+	}
+
+	extras := map[string]interface{}{}
+	if req.Channel != "" {
+		extras["channel"] = req.Channel
+	}
+	if req.Username != "" {
+		extras["username"] = req.Username
+	}
+	if color := req.color(); color != "" {
+		extras["color"] = color
+	}
+
+	h.deliver(w, r, &render.Notification{
+		Route:    routeName(r),
+		Title:    title,
+		Message:  message,
+		Priority: req.priority(),
+		Time:     time.Now(),
+		Source:   "slack",
+		DialogID: requestedDialog(r, ""),
+		Extras:   extras,
+	})
+}
+
+// parseSlackWebhook reads a JSON payload, or a form with a payload field.
+// Forms without payload field are read as JSON, since some tools post JSON
+// with the form content type.
+func parseSlackWebhook(r *http.Request) (*SlackWebhook, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		if form, err := url.ParseQuery(string(body)); err == nil && form.Has("payload") {
+			body = []byte(form.Get("payload"))
+		}
+	}
+
+	var req SlackWebhook
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid JSON")
+	}
+	return &req, nil
+}
+
+// title returns the text of the first header block, if any
+func (s *SlackWebhook) title() string {
+	for _, block := range s.Blocks {
+		if block.Type == "header" && block.Text != nil {
+			return slackText(block.Text.Text)
+		}
+	}
+	return ""
+}
+
+// text renders the blocks, or the text when there are none, followed by
+// the attachments
+func (s *SlackWebhook) text() string {
+	var parts []string
+
+	// Slack shows blocks instead of the text, which is their fallback
+	body := renderSlackBlocks(s.Blocks, true)
+	if body == "" {
+		body = slackText(s.Text)
+	}
+	if body != "" {
+		parts = append(parts, body)
+	}
+
+	for _, a := range s.Attachments {
+		if text := a.render(); text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	return strings.Join(parts, "\n\n")
+}
+
+// color returns the color of the first colored attachment
+func (s *SlackWebhook) color() string {
+	for _, a := range s.Attachments {
+		if a.Color != "" {
+			return a.Color
+		}
+	}
+	return ""
+}
+
+// priority derives the priority from the most severe attachment color
+func (s *SlackWebhook) priority() int {
+	priority := -1
+	for _, a := range s.Attachments {
+		if p, ok := severity.Default.Lookup(severity.Slack, a.Color); ok && p > priority {
+			priority = p
+		}
+	}
+	if priority < 0 {
+		return defaultSlackPriority
+	}
+	return priority
+}
+
+// render renders a legacy attachment
+func (a *SlackAttachment) render() string {
+	var lines []string
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			lines = append(lines, s)
+		}
+	}
+
+	add(slackText(a.Pretext))
+	add(a.AuthorName)
+	switch {
+	case a.Title != "" && a.TitleLink != "":
+		add(fmt.Sprintf("%s (%s)", slackText(a.Title), a.TitleLink))
+	default:
+		add(slackText(a.Title))
+	}
+	add(slackText(a.Text))
+	for _, f := range a.Fields {
+		switch {
+		case f.Title != "" && f.Value != "":
+			add(fmt.Sprintf("%s: %s", slackText(f.Title), slackText(f.Value)))
+		default:
+			add(slackText(f.Title + f.Value))
+		}
+	}
+	add(renderSlackBlocks(a.Blocks, false))
+	add(slackText(a.Footer))
+
+	if len(lines) == 0 {
+		return slackText(a.Fallback)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderSlackBlocks renders the text of Block Kit blocks, one block per
+// line. skipHeader leaves out the header block shown as title.
+func renderSlackBlocks(blocks []SlackBlock, skipHeader bool) string {
+	var lines []string
+	headerSkipped := !skipHeader
+
+	for _, block := range blocks {
+		var text string
+		switch block.Type {
+		case "header":
+			if !headerSkipped && block.Text != nil {
+				headerSkipped = true
+				continue
+			}
+			if block.Text != nil {
+				text = slackText(block.Text.Text)
+			}
+		case "section":
+			var parts []string
+			if block.Text != nil {
+				parts = append(parts, slackText(block.Text.Text))
+			}
+			for _, f := range block.Fields {
+				parts = append(parts, slackText(f.Text))
+			}
+			text = strings.Join(parts, "\n")
+		case "context":
+			var parts []string
+			for _, raw := range block.Elements {
+				var element SlackText
+				if json.Unmarshal(raw, &element) == nil && element.Text != "" {
+					parts = append(parts, slackText(element.Text))
+				}
+			}
+			text = strings.Join(parts, " ")
+		case "rich_text":
+			var b strings.Builder
+			for _, raw := range block.Elements {
+				richText(&b, raw)
+			}
+			text = slackText(b.String())
+		case "image":
+			text = block.AltText
+			if block.ImageURL != "" {
+				text = strings.TrimSpace(text + " " + block.ImageURL)
+			}
+		case "divider":
+			text = "---"
+		}
+
+		if text = strings.TrimSpace(text); text != "" {
+			lines = append(lines, text)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// richText writes the text of a rich text element and its children
+func richText(b *strings.Builder, raw json.RawMessage) {
+	var element struct {
+		Type     string            `json:"type"`
+		Text     string            `json:"text"`
+		URL      string            `json:"url"`
+		Name     string            `json:"name"`
+		UserID   string            `json:"user_id"`
+		Elements []json.RawMessage `json:"elements"`
+	}
+	if json.Unmarshal(raw, &element) != nil {
+		return
+	}
+
+	switch element.Type {
+	case "text":
+		b.WriteString(element.Text)
+	case "link":
+		if element.Text != "" {
+			fmt.Fprintf(b, "%s (%s)", element.Text, element.URL)
+		} else {
+			b.WriteString(element.URL)
+		}
+	case "emoji":
+		fmt.Fprintf(b, ":%s:", element.Name)
+	case "user":
+		b.WriteString("@" + element.UserID)
+	}
+
+	for _, child := range element.Elements {
+		richText(b, child)
+	}
+	if element.Type == "rich_text_section" || element.Type == "rich_text_preformatted" || element.Type == "rich_text_quote" {
+		b.WriteByte('\n')
+	}
+}
+
+// slackLinkPattern matches the angle-bracket links, mentions and commands
+// of Slack mrkdwn, such as <https://example.com|Example> or <!here>
+var slackLinkPattern = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]*))?>`)
+
+// slackText converts Slack mrkdwn to plain text: links become
+// "label (url)", mentions lose their markup and entities are unescaped
+func slackText(text string) string {
+	text = slackLinkPattern.ReplaceAllStringFunc(text, func(match string) string {
+		m := slackLinkPattern.FindStringSubmatch(match)
+		target, label := m[1], m[2]
+
+		switch {
+		case strings.HasPrefix(target, "@"), strings.HasPrefix(target, "#"):
+			if label != "" {
+				return target[:1] + label
+			}
+			return target
+		case strings.HasPrefix(target, "!"):
+			// Special mentions such as <!here> and <!subteam^ID|@team>
+			if label != "" {
+				return label
+			}
+			return "@" + strings.SplitN(target[1:], "^", 2)[0]
+		case label != "" && label != target:
+			return fmt.Sprintf("%s (%s)", label, target)
+		default:
+			return target
+		}
+	})
+
+	return strings.TrimSpace(html.UnescapeString(text))
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/dedup"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/queue"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/snooze"
+	"github.com/gorilla/mux"
+)
+
+var snoozed = metrics.NewCounter("snoozed_notifications_total",
+	"Notifications held back because their alert was snoozed, by route.", "route")
+
+// snoozeKey identifies the re-fires of a notification: the same route,
+// account and dialog, and the dedup key named by the sender or else the
+// title, as the message of a re-fire often differs in details
+func snoozeKey(r *http.Request, account *mizito.Account, n *render.Notification, dialogID string) string {
+	key := requestDedupKey(r)
+	if key == "" {
+		key = n.Title
+	}
+	return dedup.Key(account.Name, n.Route, dialogID, key)
+}
+
+// snoozedUntil reports until when the alert of a notification is snoozed,
+// if it is
+func (h *Handler) snoozedUntil(log *logger.Logger, key, route string) (time.Time, bool) {
+	until, ok := h.snoozes.Snoozed(key)
+	if ok {
+		snoozed.Inc(route)
+		log.Info("Held back notification of snoozed alert", "route", route, "until", until)
+	}
+	return until, ok
+}
+
+// rememberSnoozable records a notification under its message ID, so its
+// alert can be snoozed through the API
+func (h *Handler) rememberSnoozable(ctx context.Context, key string, account *mizito.Account, n *render.Notification, dialogID string) {
+	id := contextMessageID(ctx)
+	if id == "" {
+		return
+	}
+
+	h.snoozes.Remember(id, snooze.Notification{
+		Route:    n.Route,
+		Account:  account.Name,
+		DialogID: dialogID,
+		Title:    n.Title,
+		Key:      key,
+	})
+}
+
+// rememberSent records the ID Mizito gave the delivered message of a
+// snoozable notification, so a snooze is noted in its thread
+func (h *Handler) rememberSent(ctx context.Context, msg *mizito.Message) {
+	id := contextMessageID(ctx)
+	if id == "" || msg.ID == "" {
+		return
+	}
+	h.snoozes.Sent(id, msg.ID)
+}
+
+// RememberSentJob records the ID Mizito gave the message of a queued
+// notification once the queue delivered it; the job has the message ID of
+// the notification
+func (h *Handler) RememberSentJob(job *queue.Job, messageID string) {
+	if messageID == "" {
+		return
+	}
+	h.snoozes.Sent(job.ID, messageID)
+}
+
+// SnoozeMessage handles POST requests to /api/v1/messages/{id}/snooze, where
+// id is the message ID of the response to a notification, as for its status. Re-fires of its alert
+// are held back for the duration of the for query parameter, e.g. 2h, and
+// the snooze is noted in its dialog, as a reply to the notification when
+// Mizito told its message ID.
+func (h *Handler) SnoozeMessage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	log := h.logger.WithContext(r.Context())
+
+	duration, err := time.ParseDuration(r.URL.Query().Get("for"))
+	if err != nil || duration <= 0 {
+		writeJSON(w, http.StatusBadRequest, NotificationResponse{
+			Success: false,
+			Message: "The for query parameter must be a positive duration, e.g. 2h",
+		})
+		return
+	}
+
+	n, until, ok := h.snoozes.Snooze(id, duration)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, NotificationResponse{
+			Success: false,
+			Message: "Message not found; it is unknown or was delivered too long ago",
+		})
+		return
+	}
+	log.Info("Snoozed alert", "id", id, "route", n.Route, "title", n.Title, "until", until)
+
+	h.noteSnooze(r.Context(), log, n, r.URL.Query().Get("for"))
+
+	writeJSON(w, http.StatusOK, NotificationResponse{
+		Success:      true,
+		Message:      "Alert snoozed",
+		ID:           id,
+		SnoozedUntil: &until,
+	})
+}
+
+// noteSnooze tells the dialog of a snoozed notification about the snooze, in
+// the thread of the notification when its message ID is known.
+// The snooze holds even if the note cannot be sent.
+func (h *Handler) noteSnooze(ctx context.Context, log *logger.Logger, n snooze.Notification, period string) {
+	account, ok := h.accounts[n.Account]
+	if !ok {
+		return
+	}
+
+	subject := n.Title
+	if subject == "" {
+		subject = n.Route
+	}
+
+	msg := &mizito.Message{
+		Text:       fmt.Sprintf("🔕 Snoozed for %s: %s", period, subject),
+		DialogID:   n.DialogID,
+		FromUserID: h.config.Route(n.Route).FromUserID,
+		ReplyTo:    n.MessageID,
+	}
+	if err := account.Messages.Send(ctx, msg); err != nil {
+		log.Warn("Failed to note snooze in dialog", "dialog", n.DialogID, "error", err)
+	}
+}
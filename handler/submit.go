@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// submitResponse records the response of a notification submitted by an
+// input other than HTTP
+type submitResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (s *submitResponse) Header() http.Header { return s.header }
+
+func (s *submitResponse) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.body.Write(b)
+}
+
+func (s *submitResponse) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+}
+
+// asyncContextKey is the context key holding whether a submitted
+// notification is answered before it is delivered
+type asyncContextKey struct{}
+
+// WithAsync makes Submit accept a notification for delivery in the
+// background, or deliver it before returning, instead of following
+// ASYNC_SEND
+func WithAsync(ctx context.Context, async bool) context.Context {
+	return context.WithValue(ctx, asyncContextKey{}, async)
+}
+
+// Submit delivers a notification received by a non-HTTP input, such as the
+// Windows Event Log, through the same pipeline as HTTP notifications: route
+// templates, content policy, dialog routing and the queue. The account of
+// the route delivers it, unless ctx names another with WithAccount. An error
+// is returned when the notification was not sent or queued.
+func (h *Handler) Submit(ctx context.Context, n *render.Notification) error {
+	status, body, err := h.SubmitResponse(ctx, n)
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusOK || status == http.StatusAccepted {
+		return nil
+	}
+	return fmt.Errorf("notification rejected with status %d: %s", status, body.Message)
+}
+
+// SubmitResponse is Submit for inputs answering their senders, such as
+// gRPC: it returns the HTTP status and response a notification would have
+// got over HTTP, with the ID of notifications accepted for later delivery
+func (h *Handler) SubmitResponse(ctx context.Context, n *render.Notification) (int, *NotificationResponse, error) {
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, routeContextKey{}, n.Route), http.MethodPost, "/", nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if account, _ := ctx.Value(accountContextKey{}).(string); account != "" {
+		req.Header.Set(accountHeader, account)
+	}
+	if async, ok := ctx.Value(asyncContextKey{}).(bool); ok {
+		req.URL.RawQuery = "async=" + strconv.FormatBool(async)
+	}
+
+	res := &submitResponse{header: make(http.Header)}
+	h.deliver(res, req, n)
+
+	var body NotificationResponse
+	if json.Unmarshal(res.body.Bytes(), &body) != nil || body.Message == "" {
+		body.Message = strings.TrimSpace(res.body.String())
+	}
+	return res.status, &body, nil
+}
@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/jwt"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+)
+
+// maxTokenBodySize caps the size of a token import request
+const maxTokenBodySize = 64 << 10
+
+// TokenImportRequest is the body of a token import
+type TokenImportRequest struct {
+	// Token is the x-token header value of a logged in browser session
+	Token string `json:"token"`
+	// LastLoginUID is the session's last login UID, if known
+	LastLoginUID string `json:"last_login_uid,omitempty"`
+	// Expires is an RFC 3339 time or a duration from now such as "72h".
+	// When empty the exp claim of the token is used.
+	Expires string `json:"expires,omitempty"`
+}
+
+// TokenImportResponse reports the stored token expiry
+type TokenImportResponse struct {
+	Success   bool       `json:"success"`
+	Message   string     `json:"message"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ImportToken handles POST requests to /api/v1/token. It stores an x-token
+// copied from a browser session, for accounts whose login requires an
+// interactive CAPTCHA the automated login cannot perform. The account
+// query parameter or X-Mizito-Account header selects the account.
+func (h *Handler) ImportToken(w http.ResponseWriter, r *http.Request) {
+	log := h.logger.WithContext(r.Context())
+
+	account, accountName := h.requestedAccount(r, "")
+	if account == nil {
+		writeJSON(w, http.StatusNotFound, TokenImportResponse{
+			Success: false,
+			Message: "Unknown account: " + accountName,
+		})
+		return
+	}
+
+	var req TokenImportRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxTokenBodySize)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, TokenImportResponse{
+			Success: false,
+			Message: "Invalid JSON payload: " + err.Error(),
+		})
+		return
+	}
+
+	var expiresAt time.Time
+	if req.Expires != "" {
+		var err error
+		if expiresAt, err = jwt.ParseExpiry(req.Expires); err != nil {
+			writeJSON(w, http.StatusBadRequest, TokenImportResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := account.Auth.ImportToken(req.Token, req.LastLoginUID, expiresAt); err != nil {
+		log.Warn("Rejected token import", "account", account.Name, "error", err)
+		writeJSON(w, http.StatusUnprocessableEntity, TokenImportResponse{
+			Success: false,
+			Message: "Failed to import token: " + err.Error(),
+		})
+		return
+	}
+
+	_, stored, _ := account.Auth.TokenInfo()
+	log.Info("Token imported through the API", "account", account.Name, "expires_at", stored.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, TokenImportResponse{
+		Success:   true,
+		Message:   "Token imported",
+		ExpiresAt: &stored,
+	})
+}
+
+// ResetLogin handles POST requests to /api/v1/auth/reset. It closes the
+// login lockout opened after repeated rejected credentials and clears any
+// login backoff, so the next login is attempted immediately. The account
+// query parameter or X-Mizito-Account header selects the account.
+func (h *Handler) ResetLogin(w http.ResponseWriter, r *http.Request) {
+	account, accountName := h.requestedAccount(r, "")
+	if account == nil {
+		writeJSON(w, http.StatusNotFound, NotificationResponse{
+			Success: false,
+			Message: "Unknown account: " + accountName,
+		})
+		return
+	}
+
+	if err := account.Auth.ResetLogin(); err != nil {
+		h.logger.WithContext(r.Context()).Error("Failed to reset login lockout", "error", err)
+		writeJSON(w, http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NotificationResponse{
+		Success: true,
+		Message: "Login lockout reset",
+	})
+}
+
+// TokenStatus describes the token and login state of an account
+type TokenStatus struct {
+	Account string `json:"account"`
+
+	// RefreshedAt is when the token was obtained by a login or import
+	RefreshedAt *time.Time `json:"refreshed_at,omitempty"`
+
+	*AuthHealth
+}
+
+// tokenStatus returns the token status of an account
+func tokenStatus(account *mizito.Account) *TokenStatus {
+	status := &TokenStatus{
+		Account:    account.Name,
+		AuthHealth: authHealth(account.Auth),
+	}
+	if updatedAt, _, ok := account.Auth.TokenInfo(); ok {
+		status.RefreshedAt = &updatedAt
+	}
+	return status
+}
+
+// GetToken handles GET requests to /api/v1/admin/token. It reports when the
+// token of an account was obtained and expires, with its login statistics.
+// The account query parameter or X-Mizito-Account header selects the
+// account.
+func (h *Handler) GetToken(w http.ResponseWriter, r *http.Request) {
+	account, accountName := h.requestedAccount(r, "")
+	if account == nil {
+		writeJSON(w, http.StatusNotFound, NotificationResponse{
+			Success: false,
+			Message: "Unknown account: " + accountName,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenStatus(account))
+}
+
+// RefreshToken handles POST requests to /api/v1/admin/token/refresh. It logs
+// in again even though the token may still be valid, e.g. after it was
+// revoked in Mizito. The current token is kept when the login fails.
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	log := h.logger.WithContext(r.Context())
+
+	account, accountName := h.requestedAccount(r, "")
+	if account == nil {
+		writeJSON(w, http.StatusNotFound, NotificationResponse{
+			Success: false,
+			Message: "Unknown account: " + accountName,
+		})
+		return
+	}
+
+	if err := account.Auth.Login(r.Context()); err != nil {
+		log.Warn("Forced login failed", "account", account.Name, "error", err)
+
+		// Paused logins need a token import or lockout reset first
+		status := http.StatusBadGateway
+		if errors.Is(err, mizito.ErrLoginChallenge) || errors.Is(err, mizito.ErrLoginLocked) || errors.Is(err, mizito.ErrLoginBackoff) {
+			status = http.StatusConflict
+		}
+		writeJSON(w, status, NotificationResponse{
+			Success: false,
+			Message: "Login failed: " + account.Auth.DisplayError(err),
+		})
+		return
+	}
+
+	log.Info("Token refreshed through the API", "account", account.Name)
+	writeJSON(w, http.StatusOK, tokenStatus(account))
+}
+
+// ClearToken handles DELETE requests to /api/v1/admin/token. It removes the
+// stored token of an account, so the next message logs in again.
+func (h *Handler) ClearToken(w http.ResponseWriter, r *http.Request) {
+	account, accountName := h.requestedAccount(r, "")
+	if account == nil {
+		writeJSON(w, http.StatusNotFound, NotificationResponse{
+			Success: false,
+			Message: "Unknown account: " + accountName,
+		})
+		return
+	}
+
+	if err := account.Tokens.ClearToken(); err != nil {
+		h.logger.WithContext(r.Context()).Error("Failed to clear token", "account", account.Name, "error", err)
+		writeJSON(w, http.StatusInternalServerError, NotificationResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NotificationResponse{
+		Success: true,
+		Message: "Token cleared",
+	})
+}
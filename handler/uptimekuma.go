@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+)
+
+// Uptime Kuma heartbeat statuses
+const (
+	kumaDown        = 0
+	kumaUp          = 1
+	kumaPending     = 2
+	kumaMaintenance = 3
+)
+
+// kumaStatus describes how a heartbeat status is presented; its priority
+// comes from the uptimekuma severity scale
+type kumaStatus struct {
+	name string
+	icon string
+}
+
+// kumaStatuses maps Uptime Kuma heartbeat statuses to their presentation
+var kumaStatuses = map[int]kumaStatus{
+	kumaDown:        {name: "DOWN", icon: "🔴"},
+	kumaUp:          {name: "UP", icon: "🟢"},
+	kumaPending:     {name: "PENDING", icon: "🟡"},
+	kumaMaintenance: {name: "MAINTENANCE", icon: "🔵"},
+}
+
+// UptimeKumaWebhook is the payload of an Uptime Kuma webhook notification.
+// Heartbeat and Monitor are null for test notifications.
+type UptimeKumaWebhook struct {
+	Heartbeat *UptimeKumaHeartbeat `json:"heartbeat"`
+	Monitor   *UptimeKumaMonitor   `json:"monitor"`
+	Msg       string               `json:"msg"`
+}
+
+// UptimeKumaHeartbeat is the check result that triggered the notification
+type UptimeKumaHeartbeat struct {
+	MonitorID     int64    `json:"monitorID"`
+	Status        int      `json:"status"`
+	Time          string   `json:"time"`
+	Msg           string   `json:"msg"`
+	Ping          *float64 `json:"ping"`
+	Important     bool     `json:"important"`
+	Duration      int64    `json:"duration"`
+	Timezone      string   `json:"timezone"`
+	LocalDateTime string   `json:"localDateTime"`
+}
+
+// UptimeKumaMonitor is the monitor a notification is about
+type UptimeKumaMonitor struct {
+	ID       int64   `json:"id"`
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	URL      string  `json:"url"`
+	Hostname *string `json:"hostname"`
+	Port     *int    `json:"port"`
+}
+
+// HandleUptimeKumaNotification handles POST requests from Uptime Kuma webhook notifications
+func (h *Handler) HandleUptimeKumaNotification(w http.ResponseWriter, r *http.Request) {
+	log := h.routeLogger(r, routeName(r))
+	log.Info("Received Uptime Kuma notification request")
+
+	var req UptimeKumaWebhook
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Error("Failed to parse request body", "error", err)
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Heartbeat == nil && req.Msg == "" {
+		log.Warn("Empty Uptime Kuma notification request")
+		http.Error(w, "Heartbeat or msg is required", http.StatusBadRequest)
+		return
+	}
+
+	status, ok := req.status()
+	log.Debug("Parsed Uptime Kuma notification", "monitor", req.monitorName(), "status", status.name)
+
+	extras := map[string]interface{}{}
+	if ok {
+		extras["status"] = strings.ToLower(status.name)
+	}
+	if req.Monitor != nil {
+		extras["monitorId"] = req.Monitor.ID
+		extras["monitorType"] = req.Monitor.Type
+		extras["url"] = req.Monitor.target()
+	}
+
+	h.deliver(w, r, &render.Notification{
+		Route:    routeName(r),
+		Title:    req.title(),
+		Message:  req.text(),
+		Priority: req.priority(),
+		Time:     time.Now(),
+		Source:   "uptimekuma",
+		DialogID: requestedDialog(r, ""),
+		Extras:   extras,
+	})
+}
+
+// status returns the presentation of the heartbeat status; ok is false for
+// test notifications and unknown statuses
+func (k *UptimeKumaWebhook) status() (kumaStatus, bool) {
+	if k.Heartbeat == nil {
+		return kumaStatus{}, false
+	}
+	status, ok := kumaStatuses[k.Heartbeat.Status]
+	if !ok {
+		return kumaStatus{name: fmt.Sprint(k.Heartbeat.Status), icon: "⚪"}, false
+	}
+	return status, true
+}
+
+// priority returns the priority of the heartbeat status; test notifications
+// are rated like an up monitor
+func (k *UptimeKumaWebhook) priority() int {
+	status := strconv.Itoa(kumaUp)
+	if k.Heartbeat != nil {
+		status = strconv.Itoa(k.Heartbeat.Status)
+	}
+	return severity.Default.Priority(severity.UptimeKuma, status, defaultAlertPriority)
+}
+
+// monitorName returns the name of the monitor, or a placeholder
+func (k *UptimeKumaWebhook) monitorName() string {
+	if k.Monitor != nil && k.Monitor.Name != "" {
+		return k.Monitor.Name
+	}
+	return "Uptime Kuma"
+}
+
+// title renders e.g. "🔴 Website is DOWN"
+func (k *UptimeKumaWebhook) title() string {
+	if k.Heartbeat == nil {
+		return k.monitorName()
+	}
+	status, _ := k.status()
+	return fmt.Sprintf("%s %s is %s", status.icon, k.monitorName(), status.name)
+}
+
+// text renders the body of the Mizito message
+func (k *UptimeKumaWebhook) text() string {
+	if k.Heartbeat == nil {
+		return k.Msg
+	}
+
+	var b strings.Builder
+	hb := k.Heartbeat
+
+	// The top-level msg repeats monitor and status, which the title shows
+	if hb.Msg != "" {
+		b.WriteString(hb.Msg)
+		b.WriteByte('\n')
+	}
+	if k.Monitor != nil {
+		if target := k.Monitor.target(); target != "" {
+			fmt.Fprintf(&b, "\nTarget: %s", target)
+		}
+	}
+	if hb.Status == kumaUp && hb.Ping != nil {
+		fmt.Fprintf(&b, "\nPing: %g ms", *hb.Ping)
+	}
+	if t := hb.LocalDateTime; t != "" {
+		fmt.Fprintf(&b, "\nTime: %s", t)
+		if hb.Timezone != "" {
+			fmt.Fprintf(&b, " (%s)", hb.Timezone)
+		}
+	} else if hb.Time != "" {
+		fmt.Fprintf(&b, "\nTime: %s", hb.Time)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// target returns what the monitor checks: its URL, or host and port
+func (m *UptimeKumaMonitor) target() string {
+	if m.URL != "" && m.URL != "https://" {
+		return m.URL
+	}
+	if m.Hostname == nil || *m.Hostname == "" {
+		return ""
+	}
+	if m.Port != nil && *m.Port != 0 {
+		return fmt.Sprintf("%s:%d", *m.Hostname, *m.Port)
+	}
+	return *m.Hostname
+}
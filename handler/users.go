@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+)
+
+// userFuncs returns the template functions looking people up in the user
+// directory of the default account, by email or username:
+//
+//	user     the user, with .ID, .Username, .Email and .Name; nil if unknown
+//	mention  a mention of the user per USER_MENTION_FORMAT, or @key if unknown
+func (h *Handler) userFuncs() template.FuncMap {
+	users := h.accounts[config.DefaultAccount].Messages.Users()
+
+	return template.FuncMap{
+		"user": func(key string) *mizito.User {
+			user, _ := users.Lookup(key)
+			return user
+		},
+		"mention": h.mention,
+	}
+}
+
+// mention returns a mention of the user with the given email or username
+// per USER_MENTION_FORMAT, or @key if the user directory does not know it
+func (h *Handler) mention(key string) string {
+	user, ok := h.accounts[config.DefaultAccount].Messages.Users().Lookup(key)
+	if !ok {
+		return "@" + strings.TrimPrefix(key, "@")
+	}
+	return strings.NewReplacer(
+		"{id}", user.ID,
+		"{username}", user.Username,
+		"{email}", user.Email,
+		"{name}", user.Name,
+	).Replace(h.config.UserMentionFormat)
+}
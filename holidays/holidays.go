@@ -0,0 +1,206 @@
+// Package holidays tells the days off: the official holidays of Iran, which
+// are embedded, and days added or excluded in the configuration.
+package holidays
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/persian"
+)
+
+// Calendars
+const (
+	// CalendarIran holds the official holidays of Iran
+	CalendarIran = "iran"
+
+	// CalendarNone holds the configured days only
+	CalendarNone = "none"
+)
+
+// monthDay is a day of a month
+type monthDay struct {
+	month int
+	day   int
+}
+
+// solarHolidays are the official holidays of the Jalali calendar
+var solarHolidays = map[monthDay]string{
+	{1, 1}:   "Nowruz",
+	{1, 2}:   "Nowruz",
+	{1, 3}:   "Nowruz",
+	{1, 4}:   "Nowruz",
+	{1, 12}:  "Islamic Republic Day",
+	{1, 13}:  "Nature Day",
+	{3, 14}:  "Demise of Imam Khomeini",
+	{3, 15}:  "Uprising of 15 Khordad",
+	{11, 22}: "Victory of the Islamic Revolution",
+	{12, 29}: "Nationalization of the Oil Industry",
+}
+
+// lunarHolidays are the official holidays of the lunar Hijri calendar
+var lunarHolidays = map[monthDay]string{
+	{1, 9}:   "Tasua",
+	{1, 10}:  "Ashura",
+	{2, 20}:  "Arbaeen",
+	{2, 28}:  "Demise of the Prophet and Martyrdom of Imam Hasan",
+	{2, 29}:  "Martyrdom of Imam Reza",
+	{3, 8}:   "Martyrdom of Imam Hasan Askari",
+	{3, 17}:  "Birth of the Prophet and Imam Sadiq",
+	{6, 3}:   "Martyrdom of Fatimah",
+	{7, 13}:  "Birth of Imam Ali",
+	{7, 27}:  "Mab'ath",
+	{8, 15}:  "Birth of Imam Mahdi",
+	{9, 21}:  "Martyrdom of Imam Ali",
+	{10, 1}:  "Eid al-Fitr",
+	{10, 2}:  "Eid al-Fitr",
+	{10, 25}: "Martyrdom of Imam Sadiq",
+	{12, 10}: "Eid al-Adha",
+	{12, 18}: "Eid al-Ghadir",
+}
+
+// Date is a date of the Jalali or the Gregorian calendar. Jalali dates
+// without a year recur every year, like Nowruz.
+type Date struct {
+	Year   int
+	Month  int
+	Day    int
+	Jalali bool
+}
+
+// On reports whether the date of t, in its own location, is the date
+func (d Date) On(t time.Time) bool {
+	year, month, day := t.Year(), int(t.Month()), t.Day()
+	if d.Jalali {
+		year, month, day = persian.ToJalali(t)
+	}
+	return (d.Year == 0 || d.Year == year) && d.Month == month && d.Day == day
+}
+
+// ParseDate parses a date as YYYY-MM-DD or YYYY/MM/DD, Jalali for years
+// before 1700 and Gregorian otherwise, or a recurring Jalali MM-DD
+func ParseDate(value string) (Date, error) {
+	parts := strings.FieldsFunc(value, func(r rune) bool { return r == '-' || r == '/' })
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return Date{}, fmt.Errorf("invalid date %q", value)
+		}
+		numbers[i] = n
+	}
+
+	var d Date
+	switch len(numbers) {
+	case 2:
+		d = Date{Month: numbers[0], Day: numbers[1], Jalali: true}
+	case 3:
+		d = Date{Year: numbers[0], Month: numbers[1], Day: numbers[2], Jalali: numbers[0] < 1700}
+	default:
+		return Date{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or a Jalali MM-DD", value)
+	}
+
+	if d.Month < 1 || d.Month > 12 || d.Day < 1 || d.Day > 31 {
+		return Date{}, fmt.Errorf("invalid date %q", value)
+	}
+	return d, nil
+}
+
+// ParseDates parses a comma-separated list of dates
+func ParseDates(value string) ([]Date, error) {
+	var dates []Date
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		d, err := ParseDate(entry)
+		if err != nil {
+			return nil, err
+		}
+		dates = append(dates, d)
+	}
+	return dates, nil
+}
+
+// Holiday is a day off
+type Holiday struct {
+	Date       string `json:"date"`
+	JalaliDate string `json:"jalali_date"`
+	Name       string `json:"name"`
+}
+
+// Calendar tells the days off in a location: the official holidays of its
+// calendar and the added days, except the excluded ones
+type Calendar struct {
+	official bool
+	added    []Date
+	excluded []Date
+	location *time.Location
+}
+
+// New creates a calendar of CalendarIran or CalendarNone in a location
+func New(calendar string, added, excluded []Date, location *time.Location) (*Calendar, error) {
+	switch calendar {
+	case CalendarIran, CalendarNone:
+	default:
+		return nil, fmt.Errorf("unknown holiday calendar %q, expected iran or none", calendar)
+	}
+
+	return &Calendar{
+		official: calendar == CalendarIran,
+		added:    added,
+		excluded: excluded,
+		location: location,
+	}, nil
+}
+
+// Holiday returns the name of the holiday t falls on, in the location of
+// the calendar; ok is false on other days
+func (c *Calendar) Holiday(t time.Time) (name string, ok bool) {
+	t = t.In(c.location)
+
+	for _, d := range c.excluded {
+		if d.On(t) {
+			return "", false
+		}
+	}
+
+	if c.official {
+		_, month, day := persian.ToJalali(t)
+		if name, ok := solarHolidays[monthDay{month, day}]; ok {
+			return name, true
+		}
+		_, month, day = persian.ToHijri(t)
+		if name, ok := lunarHolidays[monthDay{month, day}]; ok {
+			return name, true
+		}
+	}
+
+	for _, d := range c.added {
+		if d.On(t) {
+			return "Holiday", true
+		}
+	}
+	return "", false
+}
+
+// Year lists the holidays of a Jalali year
+func (c *Calendar) Year(year int) []Holiday {
+	var days []Holiday
+	end := persian.FromJalali(year+1, 1, 1, c.location)
+	for t := persian.FromJalali(year, 1, 1, c.location); t.Before(end); t = t.AddDate(0, 0, 1) {
+		name, ok := c.Holiday(t)
+		if !ok {
+			continue
+		}
+		_, month, day := persian.ToJalali(t)
+		days = append(days, Holiday{
+			Date:       t.Format("2006-01-02"),
+			JalaliDate: fmt.Sprintf("%04d/%02d/%02d", year, month, day),
+			Name:       name,
+		})
+	}
+	return days
+}
@@ -0,0 +1,57 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Claims holds the registered JWT claims the forwarder cares about. Times
+// are NumericDates: seconds since the epoch, which may be fractional.
+type Claims struct {
+	IssuedAt  float64 `json:"iat"`
+	ExpiresAt float64 `json:"exp"`
+}
+
+// IssuedTime returns the iat claim as time, or the zero time when absent
+func (c *Claims) IssuedTime() time.Time {
+	return numericTime(c.IssuedAt)
+}
+
+// ExpiryTime returns the exp claim as time, or the zero time when absent
+func (c *Claims) ExpiryTime() time.Time {
+	return numericTime(c.ExpiresAt)
+}
+
+// numericTime converts a NumericDate to time, 0 to the zero time
+func numericTime(date float64) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	seconds, fraction := math.Modf(date)
+	return time.Unix(int64(seconds), int64(fraction*float64(time.Second)))
+}
+
+// ParseClaims decodes the payload of a JWT without verifying its signature.
+// The token is issued by Mizito and only inspected for timing information.
+func ParseClaims(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token is not a JWT: expected 3 parts, got %d", len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT payload: %w", err)
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse JWT claims: %w", err)
+	}
+
+	return &claims, nil
+}
@@ -0,0 +1,48 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// token builds an unsigned JWT with the given claims
+func token(claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(claims)) + ".sig"
+}
+
+func TestParseClaims(t *testing.T) {
+	tests := []struct {
+		claims         string
+		issued, expiry time.Time
+	}{
+		{`{"iat":1700000000,"exp":1700086400}`, time.Unix(1700000000, 0), time.Unix(1700086400, 0)},
+		// RFC 7519 NumericDates may be fractional
+		{`{"iat":1700000000.25,"exp":1700000000.5}`, time.Unix(1700000000, 250000000), time.Unix(1700000000, 500000000)},
+		{`{"exp":1.7000864e9}`, time.Time{}, time.Unix(1700086400, 0)},
+		{`{"sub":"user"}`, time.Time{}, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		claims, err := ParseClaims(token(tt.claims))
+		if err != nil {
+			t.Errorf("ParseClaims(%s): %v", tt.claims, err)
+			continue
+		}
+		if got := claims.IssuedTime(); !got.Equal(tt.issued) {
+			t.Errorf("IssuedTime of %s = %v, want %v", tt.claims, got, tt.issued)
+		}
+		if got := claims.ExpiryTime(); !got.Equal(tt.expiry) {
+			t.Errorf("ExpiryTime of %s = %v, want %v", tt.claims, got, tt.expiry)
+		}
+	}
+}
+
+func TestParseClaimsInvalid(t *testing.T) {
+	for _, tok := range []string{"not-a-jwt", "a.!!!.c", token(`{"exp":"tomorrow"}`)} {
+		if _, err := ParseClaims(tok); err == nil {
+			t.Errorf("ParseClaims(%q) succeeded", tok)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -56,6 +57,11 @@ func (m *Manager) LoadToken() error {
 		return fmt.Errorf("failed to parse token data: %w", err)
 	}
 
+	// Token files written before expiry parsing hold an assumed expiry
+	if expiresAt, ok := tokenExpiry(tokenData.Token); ok {
+		tokenData.ExpiresAt = expiresAt
+	}
+
 	m.tokenData = &tokenData
 	m.logger.Info("JWT token loaded successfully",
 		"expires_at", tokenData.ExpiresAt.Format(time.RFC3339),
@@ -69,10 +75,78 @@ func (m *Manager) SaveToken(token, lastLoginUID string) error {
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
 
+	expiresAt, ok := tokenExpiry(token)
+	if !ok {
+		expiresAt = time.Now().Add(m.config.TokenDefaultLifetime)
+		m.logger.Warn("Token has no expiry claim, assuming the default lifetime",
+			"lifetime", m.config.TokenDefaultLifetime)
+	}
+
+	if err := m.store(token, lastLoginUID, expiresAt); err != nil {
+		return err
+	}
+
+	m.checkClockSkew(token)
+
+	return nil
+}
+
+// ImportToken stores a token obtained outside the login flow, such as an
+// x-token copied from a browser session of an account that requires an
+// interactive CAPTCHA login. A zero expiresAt takes the expiry from the exp
+// claim of the token, or assumes the default lifetime.
+func (m *Manager) ImportToken(token, lastLoginUID string, expiresAt time.Time) error {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return fmt.Errorf("token is empty")
+	}
+
+	if expiresAt.IsZero() {
+		var ok bool
+		if expiresAt, ok = tokenExpiry(token); !ok {
+			expiresAt = time.Now().Add(m.config.TokenDefaultLifetime)
+		}
+	}
+	if !expiresAt.After(time.Now()) {
+		return fmt.Errorf("token expired at %s", expiresAt.Format(time.RFC3339))
+	}
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	if err := m.store(token, lastLoginUID, expiresAt); err != nil {
+		return err
+	}
+
+	m.logger.Info("Imported JWT token", "expires_at", expiresAt.Format(time.RFC3339))
+
+	return nil
+}
+
+// ParseExpiry parses a token expiry given either as an RFC 3339 time or as
+// a duration from now, e.g. "72h"
+func ParseExpiry(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("expiry duration must be positive")
+		}
+		return time.Now().Add(d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expiry must be an RFC 3339 time or a duration: %q", value)
+	}
+	return t, nil
+}
+
+// store writes the token data to file and makes it current. Callers must
+// hold the mutex.
+func (m *Manager) store(token, lastLoginUID string, expiresAt time.Time) error {
 	tokenData := &TokenData{
 		Token:        token,
 		LastLoginUID: lastLoginUID,
-		ExpiresAt:    time.Now().Add(24 * time.Hour), // Assuming 24 hour expiry
+		ExpiresAt:    expiresAt,
 		UpdatedAt:    time.Now(),
 	}
 
@@ -99,6 +173,47 @@ func (m *Manager) SaveToken(token, lastLoginUID string) error {
 	return nil
 }
 
+// tokenExpiry returns the expiry from the exp claim of a JWT
+func tokenExpiry(token string) (time.Time, bool) {
+	claims, err := ParseClaims(token)
+	if err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}, false
+	}
+	return claims.ExpiryTime(), true
+}
+
+// checkClockSkew warns when the local clock differs noticeably from the
+// issue time of a freshly obtained token. A skewed clock makes tokens look
+// expired (or valid) at the wrong time and causes confusing auth loops.
+func (m *Manager) checkClockSkew(token string) {
+	if m.config.ClockSkewWarnThreshold <= 0 {
+		return
+	}
+
+	claims, err := ParseClaims(token)
+	if err != nil || claims.IssuedAt == 0 {
+		return
+	}
+
+	skew := time.Since(claims.IssuedTime())
+	if skew < 0 {
+		skew = -skew
+	}
+
+	if skew > m.config.ClockSkewWarnThreshold {
+		m.logger.Warn("System clock appears to be skewed compared to the Mizito token issue time",
+			"local_time", time.Now().Format(time.RFC3339),
+			"token_issued_at", claims.IssuedTime().Format(time.RFC3339),
+			"skew", skew.Round(time.Second))
+	}
+}
+
+// expired reports whether the token data is expired, treating tokens as
+// expired TokenExpirySkew early. Callers must hold the mutex.
+func (m *Manager) expired() bool {
+	return !time.Now().Before(m.tokenData.ExpiresAt.Add(-m.config.TokenExpirySkew))
+}
+
 // GetToken returns the current JWT token
 func (m *Manager) GetToken() (string, bool) {
 	m.Mutex.RLock()
@@ -123,6 +238,19 @@ func (m *Manager) GetTokenWithUID() (string, string, bool) {
 	return m.tokenData.Token, m.tokenData.LastLoginUID, true
 }
 
+// TokenInfo returns when the current token was obtained and when it expires.
+// ok is false when no token is loaded.
+func (m *Manager) TokenInfo() (updatedAt, expiresAt time.Time, ok bool) {
+	m.Mutex.RLock()
+	defer m.Mutex.RUnlock()
+
+	if m.tokenData == nil || m.tokenData.Token == "" {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return m.tokenData.UpdatedAt, m.tokenData.ExpiresAt, true
+}
+
 // IsTokenExpired checks if the current token is expired
 func (m *Manager) IsTokenExpired() bool {
 	m.Mutex.RLock()
@@ -132,7 +260,7 @@ func (m *Manager) IsTokenExpired() bool {
 		return true
 	}
 
-	return time.Now().After(m.tokenData.ExpiresAt)
+	return m.expired()
 }
 
 // ClearToken removes the stored JWT token
@@ -159,5 +287,5 @@ func (m *Manager) HasValidToken() bool {
 		return false
 	}
 
-	return time.Now().Before(m.tokenData.ExpiresAt)
+	return !m.expired()
 }
@@ -0,0 +1,105 @@
+// Package lifecycle coordinates background workers and the orderly shutdown
+// of components holding pending work, so messages in flight are delivered
+// (or safely kept) before the process exits.
+package lifecycle
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// Stopper is a component that flushes its pending work on shutdown.
+// Stop returns once the work is done or ctx expires.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// component is a registered Stopper
+type component struct {
+	name    string
+	stopper Stopper
+}
+
+// Manager runs background workers and stops components on shutdown
+type Manager struct {
+	logger *logger.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mutex      sync.Mutex
+	components []component
+}
+
+// New creates a lifecycle manager
+func New(logger *logger.Logger) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Go runs a background worker. Its context is cancelled on shutdown after
+// all components have been stopped.
+func (m *Manager) Go(name string, run func(ctx context.Context)) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		run(m.ctx)
+		m.logger.Debug("Background worker exited", "worker", name)
+	}()
+}
+
+// Register adds a component to stop on shutdown. Components are stopped in
+// reverse order of registration, so a component is stopped before those it
+// depends on (e.g. the queue before the message service it sends through).
+func (m *Manager) Register(name string, stopper Stopper) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.components = append(m.components, component{name: name, stopper: stopper})
+}
+
+// Shutdown stops all components, then cancels the background workers and
+// waits for them to exit. It gives up when ctx expires.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mutex.Lock()
+	components := m.components
+	m.components = nil
+	m.mutex.Unlock()
+
+	var firstErr error
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+		m.logger.Info("Stopping component", "component", c.name)
+		if err := c.stopper.Stop(ctx); err != nil {
+			m.logger.Warn("Component did not stop cleanly", "component", c.name, "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		m.logger.Warn("Background workers did not exit in time")
+		if firstErr == nil {
+			firstErr = ctx.Err()
+		}
+	}
+
+	return firstErr
+}
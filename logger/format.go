@@ -0,0 +1,230 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats
+const (
+	// FormatText writes logfmt lines: time=... level=INFO msg=... key=value
+	FormatText = "text"
+	// FormatJSON writes one JSON object per line, for log collectors
+	FormatJSON = "json"
+	// FormatConsole is a colorized, aligned format for local development
+	FormatConsole = "console"
+)
+
+// ANSI colors used by the console format
+const (
+	colorReset   = "\x1b[0m"
+	colorDim     = "\x1b[2m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+)
+
+// consoleLevels holds the aligned level label and color of each level
+var consoleLevels = map[string][2]string{
+	"DEBUG": {"DBG", colorCyan},
+	"INFO":  {"INF", colorGreen},
+	"WARN":  {"WRN", colorYellow},
+	"ERROR": {"ERR", colorRed},
+	"FATAL": {"FTL", colorMagenta},
+}
+
+// consoleMessageWidth pads messages so fields of consecutive lines line up
+const consoleMessageWidth = 40
+
+// SetFormat selects how records are written to stdout and files:
+// FormatText, FormatJSON or FormatConsole. Call it after ConfigureOutputs.
+func (l *Logger) SetFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON:
+	case FormatConsole:
+		// Colors only when writing to a terminal, never into files
+		l.sink.colors = os.Getenv("NO_COLOR") == "" && l.sink.writer == io.Writer(os.Stdout) && isTerminal(os.Stdout)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	l.sink.format = format
+	l.sink.handler = l.sink.newHandler()
+	return nil
+}
+
+// newHandler creates the slog handler of the sink's format and writer
+func (s *sink) newHandler() slog.Handler {
+	switch s.format {
+	case FormatJSON:
+		return slog.NewJSONHandler(s.writer, &slog.HandlerOptions{
+			Level:       slog.LevelDebug,
+			ReplaceAttr: replaceAttr,
+		})
+	case FormatConsole:
+		return &consoleHandler{writer: s.writer, mutex: &sync.Mutex{}, colors: s.colors}
+	default:
+		return slog.NewTextHandler(s.writer, &slog.HandlerOptions{
+			AddSource:   true,
+			Level:       slog.LevelDebug,
+			ReplaceAttr: replaceAttr,
+		})
+	}
+}
+
+// replaceAttr renders levels by name, sources as file:line and field values
+// the same way in every format
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+
+	switch a.Key {
+	case slog.LevelKey:
+		if level, ok := a.Value.Any().(slog.Level); ok {
+			return slog.String(slog.LevelKey, levelName(level))
+		}
+	case slog.SourceKey:
+		if source, ok := a.Value.Any().(*slog.Source); ok {
+			return slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", filepath.Base(source.File), source.Line))
+		}
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindDuration:
+		return slog.String(a.Key, a.Value.Duration().String())
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, v.Error())
+		case fmt.Stringer:
+			return slog.String(a.Key, v.String())
+		}
+	}
+
+	return a
+}
+
+// consoleHandler is a slog handler rendering records as
+// "15:04:05.000 INF message  key=value"
+type consoleHandler struct {
+	writer io.Writer
+	mutex  *sync.Mutex
+	colors bool
+	attrs  []slog.Attr
+}
+
+// Enabled always returns true; levels are filtered by the Logger
+func (h *consoleHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// WithAttrs returns a handler adding attrs to every record
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)
+	return &clone
+}
+
+// WithGroup returns h; the console format does not nest fields
+func (h *consoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// Handle writes a record as a single line
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	args := make([]interface{}, 0, 2*(len(h.attrs)+r.NumAttrs()))
+	for _, a := range h.attrs {
+		args = append(args, a.Key, a.Value.Any())
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		args = append(args, a.Key, a.Value.Any())
+		return true
+	})
+
+	line := h.format(&record{time: r.Time, level: levelName(r.Level), msg: r.Message, args: args})
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := io.WriteString(h.writer, line+"\n")
+	return err
+}
+
+// format renders a record as "15:04:05.000 INF message  key=value"
+func (h *consoleHandler) format(rec *record) string {
+	level, ok := consoleLevels[rec.level]
+	if !ok {
+		level = [2]string{rec.level, ""}
+	}
+
+	var b strings.Builder
+	b.WriteString(h.colorize(colorDim, rec.time.Format("15:04:05.000")))
+	b.WriteByte(' ')
+	b.WriteString(h.colorize(level[1], level[0]))
+	b.WriteByte(' ')
+	b.WriteString(rec.msg)
+
+	if len(rec.args) > 0 {
+		if pad := consoleMessageWidth - len([]rune(rec.msg)); pad > 0 {
+			b.WriteString(strings.Repeat(" ", pad))
+		}
+		for i := 0; i < len(rec.args); i += 2 {
+			b.WriteByte(' ')
+			if i+1 >= len(rec.args) {
+				fmt.Fprint(&b, rec.args[i])
+				break
+			}
+			b.WriteString(h.colorize(colorDim, fmt.Sprint(rec.args[i])+"="))
+			value := fmt.Sprint(fieldValue(rec.args[i+1]))
+			if strings.ContainsAny(value, " \t\n\"=") || value == "" {
+				value = fmt.Sprintf("%q", value)
+			}
+			if rec.args[i] == "error" {
+				value = h.colorize(colorRed, value)
+			}
+			b.WriteString(value)
+		}
+	}
+
+	return b.String()
+}
+
+// colorize wraps s in an ANSI color when colors are enabled
+func (h *consoleHandler) colorize(color, s string) string {
+	if !h.colors || color == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// fieldValue converts a field value to a form that renders well in text and JSON
+func fieldValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case error:
+		return val.Error()
+	case time.Duration:
+		return val.String()
+	case fmt.Stringer:
+		return val.String()
+	default:
+		return val
+	}
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -1,10 +1,15 @@
 package logger
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,6 +23,9 @@ const (
 	ERROR
 )
 
+// levelFatal is the slog level of fatal messages, above slog.LevelError
+const levelFatal = slog.Level(12)
+
 // String returns the string representation of the log level
 func (l Level) String() string {
 	switch l {
@@ -50,106 +58,277 @@ func ParseLevel(levelStr string) Level {
 	}
 }
 
-// Logger provides logging functionality
+// levelName returns the name of a slog level as used in log lines
+func levelName(level slog.Level) string {
+	switch {
+	case level >= levelFatal:
+		return "FATAL"
+	case level >= slog.LevelError:
+		return "ERROR"
+	case level >= slog.LevelWarn:
+		return "WARN"
+	case level >= slog.LevelInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
+
+// Logger provides leveled, structured logging on top of log/slog.
+// Messages take alternating key/value pairs: log.Info("Sent", "dialog", id).
 type Logger struct {
-	level    Level
-	logger   *log.Logger
-	logFile  *os.File
+	// level overrides the level of the sink for loggers created with
+	// WithLevel; nil follows it
+	level  *Level
+	sink   *sink
+	fields []interface{}
 }
 
-// NewLogger creates a new Logger instance
-func NewLogger(levelStr string) (*Logger, error) {
-	level := ParseLevel(levelStr)
-	
-	// Create a logger that outputs to both stdout and optionally to a file
-	flags := log.LstdFlags | log.Lshortfile
-	
-	// For now, we'll use stdout only. In production, you might want to also log to a file
-	l := &Logger{
-		level:  level,
-		logger: log.New(os.Stdout, "", flags),
+// sink is the destination shared by a logger and the loggers derived from
+// it with WithLevel, With and WithContext
+type sink struct {
+	// level is the minimum Level of messages logged, read at log time so
+	// that SetLevel reaches every derived logger
+	level   atomic.Int32
+	writer  io.Writer
+	handler slog.Handler
+	logFile *os.File
+	sampler *sampler
+	outputs []output
+	format  string
+	colors  bool
+}
+
+// sampler lets through one in every rate debug messages, counted per message
+// text, so high-volume diagnostics such as request bodies stay affordable
+type sampler struct {
+	mutex  sync.Mutex
+	rate   uint64
+	counts map[string]uint64
+}
+
+// allow reports whether the next occurrence of msg should be logged
+func (s *sampler) allow(msg string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.rate <= 1 {
+		return true
 	}
 
-	return l, nil
+	n := s.counts[msg]
+	s.counts[msg] = n + 1
+	return n%s.rate == 0
 }
 
-// NewFileLogger creates a new Logger that also writes to a file
+// newSink creates a sink writing text-formatted records of the given level
+// to w
+func newSink(w io.Writer, level Level) *sink {
+	s := &sink{
+		writer:  w,
+		sampler: &sampler{counts: make(map[string]uint64)},
+		format:  FormatText,
+	}
+	s.level.Store(int32(level))
+	s.handler = s.newHandler()
+	return s
+}
+
+// NewLogger creates a new Logger instance writing to stdout
+func NewLogger(levelStr string) (*Logger, error) {
+	return &Logger{
+		sink: newSink(os.Stdout, ParseLevel(levelStr)),
+	}, nil
+}
+
+// NewFileLogger creates a new Logger that writes to a file
 func NewFileLogger(levelStr string, logFilePath string) (*Logger, error) {
-	level := ParseLevel(levelStr)
-	
-	// Open log file
 	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open log file: %w", err)
 	}
 
-	// Create a multi writer that writes to both stdout and file
-	multiWriter := os.Stdout
-	if logFile != nil {
-		multiWriter = logFile
-	}
-
-	flags := log.LstdFlags | log.Lshortfile
 	l := &Logger{
-		level:   level,
-		logger:  log.New(multiWriter, "", flags),
-		logFile: logFile,
+		sink: newSink(logFile, ParseLevel(levelStr)),
 	}
+	l.sink.logFile = logFile
 
 	return l, nil
 }
 
+// SetLevel changes the minimum level of messages that are logged by the
+// logger and every logger derived from it, but for those created with
+// WithLevel. It is safe to call while other goroutines log.
+func (l *Logger) SetLevel(levelStr string) {
+	l.sink.level.Store(int32(ParseLevel(levelStr)))
+}
+
+// Enabled reports whether messages of the given level are logged
+func (l *Logger) Enabled(level Level) bool {
+	if l.level != nil {
+		return *l.level <= level
+	}
+	return Level(l.sink.level.Load()) <= level
+}
+
+// SetDebugSampleRate logs only one in every n debug messages with the same
+// text. Messages of other levels are never sampled. n <= 1 disables sampling.
+func (l *Logger) SetDebugSampleRate(n int) {
+	l.sink.sampler.mutex.Lock()
+	defer l.sink.sampler.mutex.Unlock()
+
+	if n < 1 {
+		n = 1
+	}
+	l.sink.sampler.rate = uint64(n)
+}
+
+// WithLevel returns a logger writing to the same output with a level of its
+// own, which SetLevel leaves alone
+func (l *Logger) WithLevel(levelStr string) *Logger {
+	level := ParseLevel(levelStr)
+	return &Logger{
+		level:  &level,
+		sink:   l.sink,
+		fields: l.fields,
+	}
+}
+
+// With returns a logger that adds the given key/value pairs to every message
+func (l *Logger) With(args ...interface{}) *Logger {
+	if len(args) == 0 {
+		return l
+	}
+
+	fields := make([]interface{}, 0, len(l.fields)+len(args))
+	fields = append(fields, l.fields...)
+	fields = append(fields, args...)
+
+	return &Logger{
+		level:  l.level,
+		sink:   l.sink,
+		fields: fields,
+	}
+}
+
+// WithFields returns a new logger with additional fields
+func (l *Logger) WithFields(fields map[string]interface{}) Logger {
+	args := make([]interface{}, 0, 2*len(fields))
+	for key, value := range fields {
+		args = append(args, key, value)
+	}
+	return *l.With(args...)
+}
+
+// contextKey is the context key holding request-scoped log fields
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying additional log fields, such as
+// the request ID, picked up by loggers through WithContext
+func NewContext(ctx context.Context, args ...interface{}) context.Context {
+	fields := FieldsFromContext(ctx)
+	fields = append(fields[:len(fields):len(fields)], args...)
+	return context.WithValue(ctx, contextKey{}, fields)
+}
+
+// FieldsFromContext returns the log fields stored in ctx
+func FieldsFromContext(ctx context.Context) []interface{} {
+	fields, _ := ctx.Value(contextKey{}).([]interface{})
+	return fields
+}
+
+// requestIDKey is the log field of the request ID
+const requestIDKey = "request_id"
+
+// WithRequestID returns a copy of ctx carrying the request ID, logged as
+// request_id by loggers created with WithContext
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return NewContext(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID stored in ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	fields := FieldsFromContext(ctx)
+	for i := len(fields) - 2; i >= 0; i -= 2 {
+		if fields[i] == requestIDKey {
+			id, _ := fields[i+1].(string)
+			return id
+		}
+	}
+	return ""
+}
+
+// WithContext returns a logger that adds the log fields stored in ctx to
+// every message
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	return l.With(FieldsFromContext(ctx)...)
+}
+
 // Debug logs a debug message
 func (l *Logger) Debug(msg string, args ...interface{}) {
-	if l.level <= DEBUG {
-		l.log("DEBUG", msg, args...)
+	if l.Enabled(DEBUG) && l.sink.sampler.allow(msg) {
+		l.log(slog.LevelDebug, msg, args...)
 	}
 }
 
 // Info logs an info message
 func (l *Logger) Info(msg string, args ...interface{}) {
-	if l.level <= INFO {
-		l.log("INFO", msg, args...)
+	if l.Enabled(INFO) {
+		l.log(slog.LevelInfo, msg, args...)
 	}
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(msg string, args ...interface{}) {
-	if l.level <= WARN {
-		l.log("WARN", msg, args...)
+	if l.Enabled(WARN) {
+		l.log(slog.LevelWarn, msg, args...)
 	}
 }
 
 // Error logs an error message
 func (l *Logger) Error(msg string, args ...interface{}) {
-	if l.level <= ERROR {
-		l.log("ERROR", msg, args...)
+	if l.Enabled(ERROR) {
+		l.log(slog.LevelError, msg, args...)
 	}
 }
 
 // Fatal logs a fatal message and exits
 func (l *Logger) Fatal(msg string, args ...interface{}) {
-	l.log("FATAL", msg, args...)
+	l.log(levelFatal, msg, args...)
+	l.Close()
 	os.Exit(1)
 }
 
-// log handles the actual logging
-func (l *Logger) log(level, msg string, args ...interface{}) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	formattedMsg := fmt.Sprintf(msg, args...)
-	l.logger.Printf("[%s] [%s] %s", timestamp, level, formattedMsg)
+// log hands a message to the slog handler and the remote outputs
+func (l *Logger) log(level slog.Level, msg string, args ...interface{}) {
+	if len(l.fields) > 0 {
+		args = append(l.fields[:len(l.fields):len(l.fields)], args...)
+	}
+
+	// Skip runtime.Callers, log and the level method to reach the caller
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.Add(args...)
+	l.sink.handler.Handle(context.Background(), r)
+
+	if len(l.sink.outputs) > 0 {
+		rec := &record{time: r.Time, level: levelName(level), msg: msg, args: args}
+		for _, out := range l.sink.outputs {
+			out.write(rec)
+		}
+	}
 }
 
-// Close closes the logger and any open files
+// Close flushes remote outputs and closes the logger and any open files
 func (l *Logger) Close() {
-	if l.logFile != nil {
-		l.logFile.Close()
+	for _, out := range l.sink.outputs {
+		out.close()
 	}
-}
+	l.sink.outputs = nil
 
-// WithFields returns a new logger with additional fields (placeholder for structured logging)
-func (l *Logger) WithFields(fields map[string]interface{}) Logger {
-	// For simplicity, we'll just append the fields to the message
-	// In a real implementation, you might want to use structured logging
-	return *l
-}
\ No newline at end of file
+	if l.sink.logFile != nil {
+		l.sink.logFile.Close()
+	}
+}
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestSetLevelReachesDerivedLoggers(t *testing.T) {
+	out := &lockedBuffer{}
+	base := &Logger{sink: newSink(out, INFO)}
+	derived := base.With("account", "ops")
+	route := base.WithLevel("warn").With("route", "grafana")
+
+	base.SetLevel("debug")
+
+	derived.Debug("derived debug")
+	route.Info("route info")
+	route.Warn("route warn")
+
+	logged := out.String()
+	if !strings.Contains(logged, "derived debug") {
+		t.Errorf("logger derived before SetLevel kept the old level:\n%s", logged)
+	}
+	if strings.Contains(logged, "route info") || !strings.Contains(logged, "route warn") {
+		t.Errorf("logger with a level of its own followed SetLevel:\n%s", logged)
+	}
+}
+
+func TestSetLevelWhileLogging(t *testing.T) {
+	base := &Logger{sink: newSink(&lockedBuffer{}, INFO)}
+	derived := base.With("account", "ops")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			derived.Debug("debug")
+			derived.Info("info")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			base.SetLevel([]string{"debug", "info"}[i%2])
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,412 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputBufferSize is the number of records buffered per remote output;
+// records are dropped rather than blocking the caller when it is full
+const outputBufferSize = 1024
+
+// record is a single log entry passed to outputs
+type record struct {
+	time  time.Time
+	level string
+	msg   string
+	args  []interface{}
+}
+
+// output ships log records to a remote destination
+type output interface {
+	write(rec *record) error
+	close() error
+}
+
+// ConfigureOutputs sets where log records are written from a comma-separated
+// list of targets:
+//
+//	stdout                     standard output (the default)
+//	file:/var/log/fwd.log      append to a file
+//	syslog://host:514          RFC 5424 syslog over UDP
+//	syslog+tcp://host:514      RFC 5424 syslog over TCP
+//	gelf://host:12201          Graylog GELF over UDP
+//	gelf+tcp://host:12201      Graylog GELF over TCP
+//
+// Syslog targets accept a facility query parameter, e.g. ?facility=local0.
+func (l *Logger) ConfigureOutputs(spec string) error {
+	var writers []io.Writer
+	var outputs []output
+
+	for _, target := range strings.Split(spec, ",") {
+		target = strings.TrimSpace(target)
+		switch {
+		case target == "":
+			continue
+		case target == "stdout":
+			writers = append(writers, os.Stdout)
+		case strings.HasPrefix(target, "file:"):
+			f, err := os.OpenFile(strings.TrimPrefix(target, "file:"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+			if err != nil {
+				return fmt.Errorf("failed to open log file: %w", err)
+			}
+			writers = append(writers, f)
+			l.sink.logFile = f
+		default:
+			out, err := newRemoteOutput(target)
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, newAsyncOutput(out))
+		}
+	}
+
+	if len(writers) == 0 && len(outputs) == 0 {
+		writers = append(writers, os.Stdout)
+	}
+
+	switch len(writers) {
+	case 0:
+		l.sink.writer = io.Discard
+	case 1:
+		l.sink.writer = writers[0]
+	default:
+		l.sink.writer = io.MultiWriter(writers...)
+	}
+	l.sink.handler = l.sink.newHandler()
+	l.sink.outputs = outputs
+
+	return nil
+}
+
+// newRemoteOutput creates a syslog or GELF output from a target URL
+func newRemoteOutput(target string) (output, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid log output %q", target)
+	}
+
+	hostname, _ := os.Hostname()
+
+	switch u.Scheme {
+	case "syslog", "syslog+udp", "syslog+tcp":
+		facility, err := parseFacility(u.Query().Get("facility"))
+		if err != nil {
+			return nil, err
+		}
+		network := "udp"
+		if u.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+		return &syslogOutput{conn: conn{network: network, addr: u.Host}, facility: facility, hostname: hostname}, nil
+
+	case "gelf", "gelf+udp", "gelf+tcp":
+		network := "udp"
+		if u.Scheme == "gelf+tcp" {
+			network = "tcp"
+		}
+		return &gelfOutput{conn: conn{network: network, addr: u.Host}, hostname: hostname}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported log output %q", target)
+	}
+}
+
+// asyncOutput decouples callers from slow or unreachable log servers
+type asyncOutput struct {
+	out     output
+	records chan *record
+	done    chan struct{}
+
+	// mutex guards records against writes after close
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// newAsyncOutput starts a goroutine shipping records to out
+func newAsyncOutput(out output) *asyncOutput {
+	a := &asyncOutput{
+		out:     out,
+		records: make(chan *record, outputBufferSize),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(a.done)
+		for rec := range a.records {
+			if err := a.out.write(rec); err != nil {
+				fmt.Fprintf(os.Stderr, "log output error: %v\n", err)
+			}
+		}
+	}()
+
+	return a
+}
+
+// write queues a record, dropping it when the buffer is full
+func (a *asyncOutput) write(rec *record) error {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if a.closed {
+		return nil
+	}
+
+	select {
+	case a.records <- rec:
+	default:
+	}
+	return nil
+}
+
+// close flushes buffered records and closes the connection
+func (a *asyncOutput) close() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.records)
+	a.mutex.Unlock()
+
+	<-a.done
+	return a.out.close()
+}
+
+// conn is a lazily (re)connecting network connection
+type conn struct {
+	network string
+	addr    string
+	mutex   sync.Mutex
+	c       net.Conn
+}
+
+// send writes data, reconnecting once if the connection was lost
+func (c *conn) send(data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.c == nil {
+			nc, err := net.DialTimeout(c.network, c.addr, 5*time.Second)
+			if err != nil {
+				return err
+			}
+			c.c = nc
+		}
+
+		c.c.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := c.c.Write(data); err == nil {
+			return nil
+		}
+
+		c.c.Close()
+		c.c = nil
+	}
+
+	return fmt.Errorf("failed to write to %s://%s", c.network, c.addr)
+}
+
+// close closes the underlying connection
+func (c *conn) close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.c == nil {
+		return nil
+	}
+	err := c.c.Close()
+	c.c = nil
+	return err
+}
+
+// syslogSeverity maps level names to syslog severities
+var syslogSeverity = map[string]int{
+	"DEBUG": 7,
+	"INFO":  6,
+	"WARN":  4,
+	"ERROR": 3,
+	"FATAL": 2,
+}
+
+// syslogFacilities maps facility names to codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// parseFacility resolves a syslog facility name, defaulting to "user"
+func parseFacility(name string) (int, error) {
+	if name == "" {
+		return syslogFacilities["user"], nil
+	}
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return facility, nil
+}
+
+// syslogOutput sends RFC 5424 formatted records
+type syslogOutput struct {
+	conn     conn
+	facility int
+	hostname string
+}
+
+// write formats and sends a record
+func (s *syslogOutput) write(rec *record) error {
+	pri := s.facility*8 + syslogSeverity[rec.level]
+
+	msg := rec.msg
+	if fields := formatFields(rec.args); fields != "" {
+		msg += " " + fields
+	}
+
+	line := fmt.Sprintf("<%d>1 %s %s mizito-forwarder %d - - %s",
+		pri, rec.time.Format(time.RFC3339Nano), s.hostname, os.Getpid(), msg)
+
+	if s.conn.network == "tcp" {
+		// Octet-counting framing (RFC 6587)
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	return s.conn.send([]byte(line))
+}
+
+func (s *syslogOutput) close() error {
+	return s.conn.close()
+}
+
+// GELF chunking parameters
+const (
+	gelfChunkSize = 8192
+	gelfMaxChunks = 128
+)
+
+// gelfOutput sends Graylog Extended Log Format messages
+type gelfOutput struct {
+	conn     conn
+	hostname string
+}
+
+// write formats and sends a record
+func (g *gelfOutput) write(rec *record) error {
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          g.hostname,
+		"short_message": rec.msg,
+		"timestamp":     float64(rec.time.UnixNano()) / float64(time.Second),
+		"level":         syslogSeverity[rec.level],
+		"_level_name":   rec.level,
+		"_facility":     "mizito-forwarder",
+	}
+	for i := 0; i+1 < len(rec.args); i += 2 {
+		key := fmt.Sprint(rec.args[i])
+		if key == "id" {
+			// "_id" is reserved by GELF
+			key = "record_id"
+		}
+		msg["_"+key] = gelfValue(rec.args[i+1])
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if g.conn.network == "tcp" {
+		// TCP frames are terminated by a null byte
+		return g.conn.send(append(data, 0))
+	}
+
+	if len(data) <= gelfChunkSize {
+		return g.conn.send(data)
+	}
+
+	return g.sendChunked(data)
+}
+
+// sendChunked splits a large message into GELF UDP chunks
+func (g *gelfOutput) sendChunked(data []byte) error {
+	const headerSize = 12
+	payloadSize := gelfChunkSize - headerSize
+	count := (len(data) + payloadSize - 1) / payloadSize
+	if count > gelfMaxChunks {
+		return fmt.Errorf("GELF message too large: %d bytes", len(data))
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * payloadSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		var chunk bytes.Buffer
+		chunk.Write([]byte{0x1e, 0x0f})
+		chunk.Write(id)
+		chunk.WriteByte(byte(i))
+		chunk.WriteByte(byte(count))
+		chunk.Write(data[i*payloadSize : end])
+
+		if err := g.conn.send(chunk.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *gelfOutput) close() error {
+	return g.conn.close()
+}
+
+// gelfValue converts a field value to a JSON-friendly GELF value
+func gelfValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case error:
+		return val.Error()
+	case time.Duration:
+		return val.String()
+	case fmt.Stringer:
+		return val.String()
+	case string, bool, int, int64, float64:
+		return val
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// formatFields renders key/value pairs as "key=value key2=value2"
+func formatFields(args []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(args); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		if i+1 >= len(args) {
+			fmt.Fprintf(&b, "%v", args[i])
+			break
+		}
+		value := fmt.Sprint(args[i+1])
+		if strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, "%v=%s", args[i], value)
+	}
+	return b.String()
+}
@@ -2,22 +2,58 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/ebrahimkhodadadi/MizitoForwarder/apps"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/audit"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/canary"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/capture"
 	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/correlation"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/deadman"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/dedup"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/eventlog"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/grpcapi"
 	"github.com/ebrahimkhodadadi/MizitoForwarder/handler"
-	"github.com/ebrahimkhodadadi/MizitoForwarder/jwt"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/lifecycle"
 	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
 	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/moderation"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/queue"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/quiet"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/reporting"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/schedule"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/sink"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/slo"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/smtpd"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/snooze"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/syslogd"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/tlsreload"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/tracking"
 	"github.com/gorilla/mux"
 )
 
 func main() {
+	// Subcommands such as verify-audit run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	// Initialize logger
 	log, err := logger.NewLogger("info")
 	if err != nil {
@@ -34,52 +70,301 @@ func main() {
 		log.Fatal("Failed to load configuration", "error", err)
 	}
 
-	log.Info("Configuration loaded successfully", "server_port", cfg.ServerPort)
+	if err := log.ConfigureOutputs(cfg.LogOutputs); err != nil {
+		log.Fatal("Failed to configure log outputs", "error", err)
+	}
+	if err := log.SetFormat(cfg.LogFormat); err != nil {
+		log.Fatal("Failed to configure log format", "error", err)
+	}
+	log.SetLevel(cfg.LogLevel)
+	log.SetDebugSampleRate(cfg.LogDebugSampleRate)
+	log.Info("Configuration loaded successfully", "server_port", cfg.ServerPort, "base_path", cfg.BasePath, "config_file", cfg.ConfigFile)
 
-	// Initialize JWT manager
-	jwtMgr := jwt.NewManager(cfg, log)
+	// Report errors and panics to Sentry when configured
+	if err := reporting.Init(cfg, log); err != nil {
+		log.Fatal("Failed to initialize error reporting", "error", err)
+	}
+	defer reporting.Flush()
 
-	// Initialize Mizito authentication service
-	authService := mizito.NewAuthService(cfg, jwtMgr, log)
+	// Source severities map to priorities through one shared table
+	if err := severity.Default.Override(cfg.SeverityMap); err != nil {
+		log.Fatal("Failed to configure severity mapping", "error", err)
+	}
 
-	// Initialize Mizito message service
-	messageService := mizito.NewMessageService(cfg, authService, log)
+	// State is kept in the storage backend; unless it is durable, the queue
+	// and the applications stay in their files
+	store, err := storage.Open(cfg.StorageBackend, cfg.StorageURL)
+	if err != nil {
+		log.Fatal("Failed to open storage", "error", err)
+	}
+	defer store.Close()
+	applications := storage.Durable(store, storage.Applications, storage.NewFile(cfg.ApplicationsFile, apps.TokenField))
+	if err := cfg.LoadApplications(applications); err != nil {
+		log.Fatal("Failed to load applications", "error", err)
+	}
 
-	// Initialize HTTP handler
-	httpHandler := handler.NewHandler(messageService, log, cfg.AppToken)
+	if len(cfg.AppTokens) == 0 {
+		log.Warn("No APP_TOKEN configured; anyone who can reach the server can send messages")
+	}
+	if cfg.AdminToken == "" && len(cfg.AppTokens) > 0 {
+		log.Warn("No ADMIN_TOKEN configured; the admin routes are disabled")
+	}
 
-	// Setup HTTP router
-	router := mux.NewRouter()
+	// Initialize the token manager and services of each Mizito account
+	accounts := mizito.NewAccounts(cfg, log)
+	defaultAccount := accounts[config.DefaultAccount]
+	authService := defaultAccount.Auth
+	messageService := defaultAccount.Messages
 
-	// Add middleware for logging
-	router.Use(loggingMiddleware(log))
+	// Initialize payload capture store
+	captureStore := capture.NewStore(cfg.CaptureDir, cfg.CaptureMaxRecords, log)
 
-	// Register routes
-	httpHandler.RegisterRoutes(router)
+	// Background workers and pending messages are drained on shutdown
+	lc := lifecycle.New(log)
+	for _, name := range cfg.AccountNames() {
+		lc.Register(serviceName("message service", name), accounts[name].Messages)
+	}
 
-	// Create HTTP server
-	server := &http.Server{
-		Addr:         cfg.ServerPort,
-		Handler:      router,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	// Delivery outcomes are measured against the objectives of the routes
+	deliverySLO := slo.New(cfg, messageService, log)
+	if cfg.SLOEnabled() {
+		lc.Go("delivery SLO", deliverySLO.Run)
 	}
 
-	// Load existing JWT token on startup if available
-	log.Info("Loading existing JWT token...")
-	if err := jwtMgr.LoadToken(); err != nil {
-		log.Warn("Failed to load existing JWT token on startup", "error", err)
-		// Continue startup, token will be obtained when needed
-	} else {
-		log.Info("Existing JWT token loaded successfully")
+	// Initialize the audit log
+	var auditLog *audit.Log
+	if cfg.AuditLogFile != "" {
+		auditLog, err = newAuditLog(cfg)
+		if err != nil {
+			log.Fatal("Failed to initialize audit log", "error", err)
+		}
+	}
+
+	// Initialize the persistent outbound queue. Its worker starts once the
+	// HTTP handler, which owns the failover sinks, is in place.
+	var outboundQueue *queue.Queue
+	var httpHandler *reloader
+	if cfg.QueueEnabled {
+		jobs := storage.Durable(store, storage.Queue, storage.NewDirectory(cfg.QueueDir))
+		deadLetters := storage.Durable(store, storage.DeadLetters, storage.NewDirectory(filepath.Join(cfg.QueueDir, queue.DeadLetterDir)))
+		outboundQueue = queue.New(jobs, deadLetters, func(ctx context.Context, job *queue.Job) error {
+			if job.RequestID != "" {
+				ctx = logger.WithRequestID(ctx, job.RequestID)
+			}
+			account := defaultAccount
+			if job.Account != "" {
+				var ok bool
+				if account, ok = accounts[job.Account]; !ok {
+					return queue.Permanent(fmt.Errorf("unknown account: %s", job.Account))
+				}
+			}
+			msg := &mizito.Message{
+				Text:        job.Text,
+				Priority:    job.Priority,
+				DialogID:    job.DialogID,
+				FromUserID:  job.FromUserID,
+				Attachments: job.Attachments,
+			}
+			err := account.Messages.Send(ctx, msg)
+			if err != nil && job.Attempts == 1 && !errors.Is(err, mizito.ErrRateLimited) {
+				httpHandler.FailOverJob(ctx, job)
+			}
+			if err == nil {
+				httpHandler.RememberSentJob(job, msg.ID)
+				deliverySLO.Record(job.Route, true, time.Since(job.CreatedAt))
+			} else if mizito.Rejected(err) || (cfg.QueueMaxAttempts > 0 && job.Attempts >= cfg.QueueMaxAttempts) {
+				deliverySLO.Record(job.Route, false, time.Since(job.CreatedAt))
+			}
+			if err == nil && auditLog != nil {
+				if err := auditLog.Append(&audit.Record{
+					ID:       job.ID,
+					Route:    job.Route,
+					DialogID: job.DialogID,
+					Priority: job.Priority,
+					Text:     job.Text,
+					Template: job.Template,
+					Status:   audit.StatusSent,
+				}); err != nil {
+					log.Error("Failed to write audit record", "error", err)
+				}
+			}
+			// Messages Mizito refused fail the same way on every attempt
+			if mizito.Rejected(err) {
+				return queue.Permanent(err)
+			}
+			return err
+		}, cfg.QueueBackoff, cfg.QueueMaxBackoff, cfg.QueueMaxAttempts, log)
+		lc.Register("queue", outboundQueue)
+	}
+
+	// Batch job runs reported to /api/v1/annotate arm the dead man's switch
+	deadmanSwitch := deadman.New(cfg, messageService, log)
+	if len(cfg.DeadmanJobs) > 0 {
+		lc.Go("dead man's switch", deadmanSwitch.Run)
+	}
+
+	// Delayed notifications and recurring messages go through the
+	// notification pipeline when due
+	scheduler, err := schedule.New(cfg, func(ctx context.Context, n *render.Notification, account string) error {
+		if account != "" {
+			ctx = handler.WithAccount(ctx, account)
+		}
+		return httpHandler.Submit(ctx, n)
+	}, log)
+	if err != nil {
+		log.Fatal("Failed to load schedule", "error", err)
+	}
+
+	// Notifications of moderated routes wait for a moderator
+	moderationStore, err := moderation.NewStore(cfg.ModerationFile, cfg.ModerationTimeout)
+	if err != nil {
+		log.Fatal("Failed to load held messages", "error", err)
+	}
+
+	// Low-priority notifications to dialogs in quiet hours wait for a digest
+	quietBuffer, err := quiet.NewBuffer(cfg.QuietFile)
+	if err != nil {
+		log.Fatal("Failed to load notifications buffered during quiet hours", "error", err)
+	}
+
+	// Initialize HTTP handler and routes, rebuilt when the configuration is
+	// reloaded
+	duplicates := dedup.NewStore(store.Counters(storage.Dedup))
+	httpHandler, err = newReloader(cfg, applications, accounts, captureStore, outboundQueue, tracking.NewStore(store.Collection(storage.History), tracking.DefaultLimit), duplicates, snooze.NewStore(snooze.DefaultLimit), correlation.NewStore(), sink.NewEscalations(log), scheduler, moderationStore, quietBuffer, auditLog, deadmanSwitch, deliverySLO, log)
+	if err != nil {
+		log.Fatal("Failed to initialize HTTP handler", "error", err)
+	}
+	if outboundQueue != nil {
+		lc.Go("queue", outboundQueue.Run)
+	}
+	lc.Go("scheduler", scheduler.Run)
+	lc.Go("quiet hours digests", httpHandler.RunDigests)
+
+	// Sinks running work in the background finish it on shutdown
+	lc.Register("sinks", httpHandler)
+
+	// Counts of duplicates are delivered before the sinks and queue stop
+	lc.Register("deduplication", duplicates)
+
+	// Forward Windows Event Log events through the notification pipeline
+	if cfg.EventLogEnabled {
+		input, err := eventlog.New(cfg, httpHandler.Submit, log)
+		if err != nil {
+			log.Fatal("Failed to initialize Event Log input", "error", err)
+		}
+		lc.Go("event log", input.Run)
+	}
+
+	// Forward the syslog messages of network devices
+	if cfg.SyslogEnabled {
+		listener, err := syslogd.New(cfg, httpHandler.Submit, log)
+		if err != nil {
+			log.Fatal("Failed to start syslog listener", "address", cfg.SyslogPort, "error", err)
+		}
+		lc.Go("syslog", listener.Run)
+	}
+
+	// Create HTTP server, and the admin listener when configured
+	servers := []*http.Server{newServer(cfg.ServerPort, httpHandler.listener(0))}
+	if cfg.AdminPort != "" {
+		servers = append(servers, newServer(cfg.AdminPort, httpHandler.listener(1)))
+	}
+
+	for _, name := range cfg.AccountNames() {
+		account := accounts[name]
+		log := log
+		if name != config.DefaultAccount {
+			log = log.With("account", name)
+		}
+
+		// Load existing JWT token on startup if available
+		log.Info("Loading existing JWT token...")
+		if err := account.Tokens.LoadToken(); err != nil {
+			log.Warn("Failed to load existing JWT token on startup", "error", err)
+			// Continue startup, token will be obtained when needed
+		} else {
+			log.Info("Existing JWT token loaded successfully")
+		}
+
+		// Optionally authenticate before accepting requests
+		if cfg.StartupLogin {
+			startupLogin(cfg, account.Auth, log)
+		}
+
+		// Renew the token in the background before it expires
+		if cfg.TokenRefreshEnabled {
+			lc.Go(serviceName("token refresher", name), account.Auth.RunRefresher)
+		}
+
+		// Probe the Mizito API independently of notification traffic
+		if cfg.ProbeEnabled {
+			lc.Go(serviceName("probe", name), mizito.NewProber(account, log).Run)
+		}
+	}
+
+	// Send canary messages through the whole delivery path
+	if cfg.CanaryEnabled {
+		lc.Go("canary", canary.New(cfg, authService, log).Run)
+	}
+
+	// Keep the user directory of the organization up to date for templates
+	if cfg.UserDirectorySync {
+		lc.Go("user directory", messageService.RunUserSync)
+	}
+
+	// Terminate HTTPS on all listeners, reloading renewed certificates
+	var tlsConfig *tls.Config
+	if cfg.TLSEnabled() {
+		reloader, err := tlsreload.New(cfg.ServerTLSCert, cfg.ServerTLSKey, cfg.ServerTLSReloadInterval, log)
+		if err != nil {
+			log.Fatal("Failed to load TLS certificate", "error", err)
+		}
+		for _, server := range servers {
+			server.TLSConfig = reloader.TLSConfig()
+		}
+		tlsConfig = reloader.TLSConfig()
+		lc.Go("certificate reloader", reloader.Run)
+	}
+
+	// Forward emails of appliances that can only alert by email, offering
+	// STARTTLS with the certificate of the HTTPS listeners
+	if cfg.SMTPEnabled {
+		listener, err := smtpd.New(cfg, httpHandler.Submit, tlsConfig, log)
+		if err != nil {
+			log.Fatal("Failed to start SMTP listener", "address", cfg.SMTPPort, "error", err)
+		}
+		lc.Go("smtp", listener.Run)
 	}
 
-	// Start server in a goroutine
+	// Serve the gRPC API to internal services, over TLS like the HTTPS
+	// listeners
+	if cfg.GRPCEnabled {
+		server, err := grpcapi.New(cfg, httpHandler, tlsConfig, log)
+		if err != nil {
+			log.Fatal("Failed to start gRPC listener", "address", cfg.GRPCPort, "error", err)
+		}
+		lc.Go("grpc", server.Run)
+	}
+
+	logStartupSummary(cfg, store, servers, httpHandler.routers(), authService, log)
+
+	// Start servers in goroutines
+	for _, server := range servers {
+		go func(server *http.Server) {
+			log.Info("Server starting", "address", server.Addr, "tls", server.TLSConfig != nil)
+			if err := listen(server); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Server failed to start", "address", server.Addr, "error", err)
+			}
+		}(server)
+	}
+
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		log.Info("Server starting", "address", cfg.ServerPort)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal("Server failed to start", "error", err)
+		for range hup {
+			log.Info("Received SIGHUP, reloading configuration")
+			httpHandler.Reload()
 		}
 	}()
 
@@ -90,23 +375,215 @@ func main() {
 
 	log.Info("Shutting down server...")
 
-	// Give outstanding requests time to complete
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Give outstanding requests and pending messages time to complete
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown", "error", err)
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Fatal("Server forced to shutdown", "address", server.Addr, "error", err)
+		}
+	}
+
+	// Flush queued messages and wait for sends in flight
+	if err := lc.Shutdown(ctx); err != nil {
+		log.Warn("Shutdown did not complete cleanly", "error", err)
 	}
 
 	log.Info("Server exited")
 }
 
+// logStartupSummary logs the effective configuration, so the first log lines
+// confirm the deployment is wired correctly
+func logStartupSummary(cfg *config.Config, store storage.Storage, servers []*http.Server, routers []*mux.Router, authService *mizito.AuthService, log *logger.Logger) {
+	queueBackend := "disabled"
+	if cfg.QueueEnabled {
+		queueBackend = "disk:" + cfg.QueueDir
+		if store.Durable() {
+			queueBackend = cfg.StorageBackend
+		}
+	}
+
+	sinks := "mizito"
+	if cfg.FileSinkPath != "" {
+		sinks += ",file:" + cfg.FileSinkPath
+	}
+	if cfg.ExecSinkCommand != "" {
+		sinks += ",exec"
+	}
+	if cfg.MQTTSinkURL != "" {
+		// Credentials in the broker URL are masked
+		broker := "invalid"
+		if u, err := url.Parse(cfg.MQTTSinkURL); err == nil {
+			broker = u.Redacted()
+		}
+		sinks += ",mqtt:" + broker
+	}
+	if cfg.SMSProvider != "" {
+		sinks += fmt.Sprintf(",sms:%s(priority>=%d)", cfg.SMSProvider, cfg.SMSMinPriority)
+	}
+	for _, platform := range config.Messengers {
+		if mc := cfg.Messengers[platform]; mc != nil && mc.BotToken != "" {
+			sinks += "," + platform + ":" + mc.Mode
+		}
+	}
+	if cfg.PhoneProvider != "" {
+		sinks += fmt.Sprintf(",phone:%s(priority>=%d,after %s)", cfg.PhoneProvider, cfg.PhoneMinPriority, cfg.PhoneEscalationDelay)
+	}
+
+	tokenState := "missing"
+	if _, expiresAt, ok := authService.TokenInfo(); ok {
+		tokenState = "expired"
+		if authService.HasValidToken() {
+			tokenState = "valid until " + expiresAt.Format(time.RFC3339)
+		}
+	}
+
+	appAuth := "disabled"
+	if len(cfg.AppTokens) > 0 {
+		appAuth = fmt.Sprintf("%d token(s)", len(cfg.AppTokens))
+	}
+
+	log.Info("Startup summary",
+		"listen", cfg.ServerPort,
+		"tls", cfg.TLSEnabled(),
+		"admin_listen", cfg.AdminPort,
+		"base_path", cfg.BasePath,
+		"mizito", cfg.MizitoBaseURL,
+		"mizito_proxy", mizito.ProxyDescription(cfg),
+		"dialog", cfg.MizitoDialogID,
+		"dialog_routes", len(cfg.DialogRoutes),
+		"accounts", strings.Join(cfg.AccountNames(), ","),
+		"sinks", sinks,
+		"queue", queueBackend,
+		"storage", cfg.StorageBackend,
+		"async_send", cfg.AsyncSend,
+		"user_directory", cfg.UserDirectorySync,
+		"token", tokenState,
+		"app_auth", appAuth,
+		"error_reporting", cfg.SentryDSN != "",
+		"log_level", cfg.LogLevel)
+
+	for i, server := range servers {
+		router := routers[i]
+		router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			path, err := route.GetPathTemplate()
+			if err != nil || route.GetHandler() == nil {
+				return nil
+			}
+			methods, _ := route.GetMethods()
+			log.Info("Route registered", "address", server.Addr, "methods", strings.Join(methods, ","), "path", path)
+			return nil
+		})
+	}
+}
+
+// serviceName names a service of an account in lifecycle logs; services of
+// the default account keep their plain name
+func serviceName(service, account string) string {
+	if account == config.DefaultAccount {
+		return service
+	}
+	return service + " (" + account + ")"
+}
+
+// newAuditLog opens the audit log with the configured signing key
+func newAuditLog(cfg *config.Config) (*audit.Log, error) {
+	var signer audit.Signer
+	switch {
+	case cfg.AuditHMACKey != "":
+		signer = audit.NewHMACKey(cfg.AuditHMACKey)
+	case cfg.AuditSigningKeyFile != "":
+		ed25519Signer, err := audit.LoadEd25519Signer(cfg.AuditSigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer = ed25519Signer
+	}
+
+	return audit.New(cfg.AuditLogFile, signer)
+}
+
+// newServer creates an HTTP server with the standard timeouts
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+}
+
+// listen serves HTTPS when the server has a TLS configuration, HTTP otherwise
+func listen(server *http.Server) error {
+	if server.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// withBasePath returns a subrouter for the base path, or the router itself
+// when no base path is configured
+func withBasePath(router *mux.Router, basePath string) *mux.Router {
+	if basePath == "" {
+		return router
+	}
+	return router.PathPrefix(basePath).Subrouter()
+}
+
+// startupLogin makes sure a valid token is available before the server starts.
+// In fail-fast mode a single failed attempt terminates the process; otherwise
+// the login is retried with exponential backoff and the service starts anyway
+// if all attempts fail.
+func startupLogin(cfg *config.Config, authService *mizito.AuthService, log *logger.Logger) {
+	log.Info("Performing startup login", "fail_fast", cfg.StartupLoginFailFast)
+
+	if cfg.StartupLoginFailFast {
+		if err := authService.EnsureValidToken(context.Background()); err != nil {
+			log.Fatal("Startup login failed", "error", err)
+		}
+		log.Info("Startup login succeeded")
+		return
+	}
+
+	// Allow the retry loop to be interrupted by a shutdown signal
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	err := authService.EnsureValidTokenWithRetry(ctx,
+		cfg.StartupLoginRetries,
+		cfg.StartupLoginBackoff,
+		cfg.StartupLoginMaxBackoff)
+	switch {
+	case err == nil:
+		log.Info("Startup login succeeded")
+	case ctx.Err() != nil:
+		log.Info("Startup login interrupted, exiting")
+		os.Exit(0)
+	default:
+		log.Warn("Startup login failed, continuing without a token", "error", err)
+	}
+}
+
 // loggingMiddleware adds request logging to all HTTP requests
 func loggingMiddleware(log *logger.Logger) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 
+			// Attach a request ID to every log line written for the request,
+			// keeping the one of an upstream proxy or client
+			requestID := r.Header.Get(requestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = newRequestID()
+			}
+			w.Header().Set(requestIDHeader, requestID)
+			ctx := logger.WithRequestID(r.Context(), requestID)
+			r = r.WithContext(ctx)
+			log := log.WithContext(ctx)
+
 			// Log request
 			log.Debug("HTTP request",
 				"method", r.Method,
@@ -134,6 +611,33 @@ func loggingMiddleware(log *logger.Logger) mux.MiddlewareFunc {
 	}
 }
 
+// requestIDHeader carries the request ID in requests and responses
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength caps request IDs taken from requests
+const maxRequestIDLength = 128
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether a request ID from a request may be used:
+// printable ASCII without spaces, so it cannot break log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 // loggingResponseWriter wraps http.ResponseWriter to capture status code
 type loggingResponseWriter struct {
 	http.ResponseWriter
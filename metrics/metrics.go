@@ -0,0 +1,297 @@
+// Package metrics is a minimal Prometheus metrics registry: counters, gauges
+// and scrape-time gauge functions with optional labels, exposed in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of metrics
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is a named metric family that can write its samples
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Default is the registry metrics are created in by the package functions
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds a metric, panicking on duplicate names like a programming error
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.names[m.name()] {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics in the Prometheus text format
+func (r *Registry) Write(w io.Writer) {
+	r.mutex.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mutex.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// vec stores the values of a metric family by label values
+type vec struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mutex  sync.Mutex
+	values map[string]*sample
+}
+
+// sample is the value of one label combination
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		values:     make(map[string]*sample),
+	}
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+// update applies fn to the value of the given label values
+func (v *vec) update(labelValues []string, fn func(float64) float64) {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metricName, len(v.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	s, ok := v.values[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		v.values[key] = s
+	}
+	s.value = fn(s.value)
+}
+
+// get returns the value of the given label values
+func (v *vec) get(labelValues []string) float64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if s, ok := v.values[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (v *vec) write(w io.Writer) {
+	writeHeader(w, v.metricName, v.help, v.kind)
+
+	v.mutex.Lock()
+	samples := make([]*sample, 0, len(v.values))
+	for _, s := range v.values {
+		samples = append(samples, s)
+	}
+	v.mutex.Unlock()
+
+	// Metrics without labels are exported as 0 before their first update
+	if len(samples) == 0 && len(v.labels) == 0 {
+		samples = append(samples, &sample{})
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].labelValues, "\xff") < strings.Join(samples[j].labelValues, "\xff")
+	})
+
+	for _, s := range samples {
+		writeSample(w, v.metricName, v.labels, s.labelValues, s.value)
+	}
+}
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	*vec
+}
+
+// NewCounter creates and registers a counter in r
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newVec(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// NewCounter creates and registers a counter in the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// Inc increments the counter for the given label values by 1
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for the given label values by delta (>= 0)
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// Value returns the current value for the given label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.get(labelValues)
+}
+
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	*vec
+}
+
+// NewGauge creates and registers a gauge in r
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newVec(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// NewGauge creates and registers a gauge in the default registry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// Set sets the gauge for the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.update(labelValues, func(float64) float64 { return value })
+}
+
+// Add adds delta (which may be negative) to the gauge for the given label values
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.update(labelValues, func(v float64) float64 { return v + delta })
+}
+
+// Value returns the current value for the given label values
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.get(labelValues)
+}
+
+// gaugeFunc is a gauge whose value is computed at scrape time
+type gaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge in r whose value is computed by fn on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{metricName: name, help: help, fn: fn})
+}
+
+// NewGaugeFunc registers a gauge function in the default registry
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.NewGaugeFunc(name, help, fn)
+}
+
+func (g *gaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	writeSample(w, g.metricName, nil, nil, g.fn())
+}
+
+// BoolValue converts a boolean to a gauge value
+func BoolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric family
+func writeHeader(w io.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeSample writes one sample line
+func writeSample(w io.Writer, name string, labels, labelValues []string, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			b.WriteString(`="`)
+			b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labelValues[i]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	b.WriteByte('\n')
+	io.WriteString(w, b.String())
+}
+
+// formatValue renders a sample value
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,45 @@
+package mizito
+
+import (
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/jwt"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// Account bundles the token and services of one Mizito account
+type Account struct {
+	Name     string
+	Config   *config.Config
+	Tokens   *jwt.Manager
+	Auth     *AuthService
+	Messages *MessageService
+}
+
+// NewAccount creates the token manager and services of an account
+func NewAccount(name string, config *config.Config, logger *logger.Logger) *Account {
+	if name != "" && name != "default" {
+		logger = logger.With("account", name)
+	}
+
+	tokens := jwt.NewManager(config, logger)
+	auth := NewAuthService(config, tokens, logger)
+
+	return &Account{
+		Name:     name,
+		Config:   config,
+		Tokens:   tokens,
+		Auth:     auth,
+		Messages: NewMessageService(config, auth, logger),
+	}
+}
+
+// NewAccounts creates the default account and the accounts of the accounts
+// file, keyed by name
+func NewAccounts(cfg *config.Config, logger *logger.Logger) map[string]*Account {
+	accounts := make(map[string]*Account, len(cfg.Accounts)+1)
+	for _, name := range cfg.AccountNames() {
+		accountConfig, _ := cfg.Account(name)
+		accounts[name] = NewAccount(name, accountConfig, logger)
+	}
+	return accounts
+}
@@ -2,15 +2,22 @@ package mizito
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
 	"github.com/ebrahimkhodadadi/MizitoForwarder/jwt"
 	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/reporting"
 )
 
 // LoginRequest represents the login request structure
@@ -34,34 +41,174 @@ type ErrorResponse struct {
 	Message string `json:"message,omitempty"`
 }
 
+// AuthStats summarizes authentication activity for health reporting
+type AuthStats struct {
+	LoginSuccesses int64
+	LoginFailures  int64
+	LastLoginAt    time.Time
+	LastError      string
+	LastErrorAt    time.Time
+
+	// ChallengeAt is set while automated logins are paused because the
+	// login endpoint answered with a CAPTCHA or anti-bot challenge
+	ChallengeAt     time.Time
+	ChallengeReason string
+
+	// ConsecutiveRejections counts logins rejected by Mizito since the last
+	// success; NextLoginAt delays the next attempt, and LockedAt is set once
+	// automated logins stop until reset
+	ConsecutiveRejections int
+	NextLoginAt           time.Time
+	LockedAt              time.Time
+}
+
+// maxErrorLength caps the length of error strings exposed in AuthStats
+const maxErrorLength = 300
+
+// credentialPattern matches credentials spelled out in error messages, such
+// as "password":"..." or Authorization: Bearer ...; the first group is kept
+var credentialPattern = regexp.MustCompile(`(?i)((?:password|passwd|token|jwt|authorization)"?\s*[:=]\s*"?(?:bearer\s+)?)[^\s"',&}]+`)
+
 // AuthService handles Mizito authentication
 type AuthService struct {
 	config *config.Config
 	jwtMgr *jwt.Manager
 	logger *logger.Logger
 	client *http.Client
+
+	statsMutex sync.Mutex
+	stats      AuthStats
 }
 
 // NewAuthService creates a new authentication service
 func NewAuthService(config *config.Config, jwtMgr *jwt.Manager, logger *logger.Logger) *AuthService {
-	return &AuthService{
+	a := &AuthService{
 		config: config,
 		jwtMgr: jwtMgr,
 		logger: logger,
 		client: &http.Client{
-			Timeout: 30 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConns:       10,
-				IdleConnTimeout:    90 * time.Second,
-				DisableCompression: false,
-			},
+			Timeout:   30 * time.Second,
+			Transport: newTransport(config),
 		},
 	}
+	a.loadLockout()
+	return a
+}
+
+// Login performs authentication with Mizito API and records the outcome.
+// While logins are paused after a CAPTCHA or anti-bot challenge, or after
+// rejected credentials, it fails without contacting Mizito.
+func (a *AuthService) Login(ctx context.Context) error {
+	a.statsMutex.Lock()
+	_, blocked := a.loginBlocked()
+	a.statsMutex.Unlock()
+	if blocked != nil {
+		return blocked
+	}
+
+	err := a.login(ctx)
+
+	a.statsMutex.Lock()
+	defer a.statsMutex.Unlock()
+
+	if err != nil {
+		a.stats.LoginFailures++
+		a.stats.LastError = a.sanitizeError(err)
+		a.stats.LastErrorAt = time.Now()
+		if errors.Is(err, ErrLoginChallenge) {
+			a.recordChallenge(err)
+			return err
+		}
+		if errors.Is(err, ErrLoginRejected) {
+			a.recordRejection(err)
+		}
+		reporting.CaptureError(ctx, errors.New(a.stats.LastError), map[string]string{"operation": "login"})
+		return err
+	}
+
+	a.clearChallenge()
+	a.clearRejections()
+	a.stats.LoginSuccesses++
+	a.stats.LastLoginAt = time.Now()
+	return nil
 }
 
-// Login performs authentication with Mizito API
-func (a *AuthService) Login() error {
-	a.logger.Info("Attempting to authenticate with Mizito API")
+// Stats returns a snapshot of the authentication statistics
+func (a *AuthService) Stats() AuthStats {
+	a.statsMutex.Lock()
+	defer a.statsMutex.Unlock()
+
+	return a.stats
+}
+
+// sanitizeError renders an error for display outside the process, removing
+// credentials and tokens and capping its length. The rest of the message is
+// kept as is.
+func (a *AuthService) sanitizeError(err error) string {
+	msg := credentialPattern.ReplaceAllString(err.Error(), "${1}***")
+
+	secrets := []string{a.config.MizitoPassword}
+	if token, ok := a.jwtMgr.GetToken(); ok {
+		secrets = append(secrets, token)
+	}
+	for _, secret := range secrets {
+		msg = redactSecret(msg, secret)
+	}
+
+	if len(msg) > maxErrorLength {
+		cut := maxErrorLength
+		for cut > 0 && !utf8.RuneStart(msg[cut]) {
+			cut--
+		}
+		msg = msg[:cut] + "..."
+	}
+
+	return msg
+}
+
+// redactSecret replaces the occurrences of secret standing on their own in
+// msg. Occurrences within longer words are kept, so that a short password
+// does not blank out every word containing it.
+func redactSecret(msg, secret string) string {
+	if secret == "" {
+		return msg
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(msg, secret)
+		if i < 0 {
+			b.WriteString(msg)
+			return b.String()
+		}
+
+		end := i + len(secret)
+		if (i > 0 && isWordByte(msg[i-1])) || (end < len(msg) && isWordByte(msg[end])) {
+			b.WriteString(msg[:end])
+		} else {
+			b.WriteString(msg[:i])
+			b.WriteString("***")
+		}
+		msg = msg[end:]
+	}
+}
+
+// isWordByte reports whether c may belong to a password or token
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.' || c >= utf8.RuneSelf
+}
+
+// DisplayError renders a login error for API responses, removing
+// credentials and tokens
+func (a *AuthService) DisplayError(err error) string {
+	return a.sanitizeError(err)
+}
+
+// login performs the actual authentication request
+func (a *AuthService) login(ctx context.Context) error {
+	log := a.logger.WithContext(ctx)
+	log.Info("Attempting to authenticate with Mizito API")
 
 	// Prepare login request
 	loginReq := LoginRequest{
@@ -86,29 +233,19 @@ func (a *AuthService) Login() error {
 		return fmt.Errorf("failed to marshal login request: %w", err)
 	}
 
-	// Create request
-	req, err := http.NewRequest("POST", a.config.MizitoLoginURL, bytes.NewBuffer(jsonData))
+	// Create request; the login is shared by all waiting senders, so the one
+	// that triggered it going away does not abort it
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), "POST", a.config.MizitoLoginURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create login request: %w", err)
 	}
 
 	// Set headers
+	SetHeaders(req, a.config)
 	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
 	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.8")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Origin", "https://office.mizito.ir")
-	req.Header.Set("Referer", "https://office.mizito.ir/")
-	req.Header.Set("Sec-Fetch-Dest", "empty")
-	req.Header.Set("Sec-Fetch-Mode", "cors")
-	req.Header.Set("Sec-Fetch-Site", "same-site")
-	req.Header.Set("Sec-GPC", "1")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36")
-	req.Header.Set("sec-ch-ua", "\"Chromium\";v=\"142\", \"Brave\";v=\"142\", \"Not_A Brand\";v=\"99\"")
-	req.Header.Set("sec-ch-ua-mobile", "?0")
-	req.Header.Set("sec-ch-ua-platform", "\"Windows\"")
-
-	a.logger.Debug("Login request headers", "headers", req.Header)
+
+	log.Debug("Login request headers", "headers", redactHeaders(req.Header))
 
 	// Make request
 	resp, err := a.client.Do(req)
@@ -123,11 +260,20 @@ func (a *AuthService) Login() error {
 		return fmt.Errorf("failed to read login response: %w", err)
 	}
 
-	a.logger.Debug("Login response status", "status", resp.StatusCode)
-	a.logger.Debug("Login response body", "body", string(body))
+	log.Debug("Login response status", "status", resp.StatusCode)
+	log.Debug("Login response body", "body", credentialPattern.ReplaceAllString(string(body), "${1}***"))
+
+	// A CAPTCHA or anti-bot page cannot be passed by retrying
+	if reason := detectChallenge(resp, body); reason != "" {
+		return fmt.Errorf("%w: login endpoint returned a challenge (%s)", ErrLoginChallenge, reason)
+	}
 
-	// Check HTTP status
-	if resp.StatusCode != http.StatusOK {
+	// Check HTTP status; 401 and 403 mean the credentials were refused
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: login request failed with status: %d", ErrLoginRejected, resp.StatusCode)
+	default:
 		return fmt.Errorf("login request failed with status: %d", resp.StatusCode)
 	}
 
@@ -142,9 +288,9 @@ func (a *AuthService) Login() error {
 		// Try to get error message
 		var errorResp ErrorResponse
 		if err := json.Unmarshal(body, &errorResp); err == nil {
-			return fmt.Errorf("login failed with status %d: %s", loginResp.Status, errorResp.Message)
+			return fmt.Errorf("%w: login failed with status %d: %s", ErrLoginRejected, loginResp.Status, errorResp.Message)
 		}
-		return fmt.Errorf("login failed with status: %d", loginResp.Status)
+		return fmt.Errorf("%w: login failed with status: %d", ErrLoginRejected, loginResp.Status)
 	}
 
 	// Save token
@@ -152,50 +298,117 @@ func (a *AuthService) Login() error {
 		return fmt.Errorf("failed to save JWT token: %w", err)
 	}
 
-	a.logger.Info("Successfully authenticated with Mizito API")
+	log.Info("Successfully authenticated with Mizito API")
 	return nil
 }
 
 // EnsureValidToken ensures there's a valid JWT token, authenticating if needed
-func (a *AuthService) EnsureValidToken() error {
+func (a *AuthService) EnsureValidToken(ctx context.Context) error {
+	log := a.logger.WithContext(ctx)
+
 	// Check if we have a valid token
 	if a.jwtMgr.HasValidToken() {
-		a.logger.Debug("JWT token is still valid")
+		log.Debug("JWT token is still valid")
 		return nil
 	}
 
 	// Try to load existing token
 	if err := a.jwtMgr.LoadToken(); err != nil {
-		a.logger.Warn("Failed to load existing token", "error", err)
+		log.Warn("Failed to load existing token", "error", err)
 	}
 
 	// Check again if token is now available and valid
 	if a.jwtMgr.HasValidToken() {
-		a.logger.Info("Loaded existing JWT token")
+		log.Info("Loaded existing JWT token")
 		return nil
 	}
 
 	// Need to authenticate
-	a.logger.Info("No valid JWT token found, authenticating")
-	return a.Login()
+	log.Info("No valid JWT token found, authenticating")
+	return a.Login(ctx)
+}
+
+// EnsureValidTokenWithRetry calls EnsureValidToken up to attempts times,
+// doubling the wait between attempts from backoff up to maxBackoff.
+// It gives up early when ctx is cancelled.
+func (a *AuthService) EnsureValidTokenWithRetry(ctx context.Context, attempts int, backoff, maxBackoff time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = a.EnsureValidToken(ctx); err == nil {
+			return nil
+		}
+
+		// Retrying cannot pass a CAPTCHA or a lockout
+		if errors.Is(err, ErrLoginChallenge) || errors.Is(err, ErrLoginLocked) {
+			return err
+		}
+
+		if attempt == attempts {
+			break
+		}
+
+		a.logger.Warn("Authentication attempt failed, retrying",
+			"attempt", attempt,
+			"max_attempts", attempts,
+			"retry_in", backoff,
+			"error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	return fmt.Errorf("authentication failed after %d attempts: %w", attempts, err)
 }
 
 // RefreshToken refreshes the JWT token by authenticating again
-func (a *AuthService) RefreshToken() error {
-	a.logger.Info("Refreshing JWT token")
+func (a *AuthService) RefreshToken(ctx context.Context) error {
+	log := a.logger.WithContext(ctx)
+	log.Info("Refreshing JWT token")
 
 	// Clear existing token
 	if err := a.jwtMgr.ClearToken(); err != nil {
-		a.logger.Warn("Failed to clear existing token", "error", err)
+		log.Warn("Failed to clear existing token", "error", err)
 	}
 
 	// Authenticate again
-	return a.Login()
+	return a.Login(ctx)
+}
+
+// ImportToken stores a token copied from an existing browser session, for
+// accounts whose login requires an interactive CAPTCHA
+func (a *AuthService) ImportToken(token, lastLoginUID string, expiresAt time.Time) error {
+	if err := a.jwtMgr.ImportToken(token, lastLoginUID, expiresAt); err != nil {
+		return err
+	}
+
+	a.statsMutex.Lock()
+	defer a.statsMutex.Unlock()
+
+	a.clearChallenge()
+	return nil
+}
+
+// HasValidToken reports whether a non-expired token is currently loaded
+func (a *AuthService) HasValidToken() bool {
+	return a.jwtMgr.HasValidToken()
+}
+
+// TokenInfo returns the timing of the currently stored token
+func (a *AuthService) TokenInfo() (updatedAt, expiresAt time.Time, ok bool) {
+	return a.jwtMgr.TokenInfo()
 }
 
 // GetToken returns the current JWT token, ensuring it's valid first
-func (a *AuthService) GetToken() (string, error) {
-	if err := a.EnsureValidToken(); err != nil {
+func (a *AuthService) GetToken(ctx context.Context) (string, error) {
+	if err := a.EnsureValidToken(ctx); err != nil {
 		return "", fmt.Errorf("failed to ensure valid token: %w", err)
 	}
 
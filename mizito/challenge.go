@@ -0,0 +1,111 @@
+package mizito
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/reporting"
+)
+
+// ErrLoginChallenge is returned by Login when the login endpoint answers
+// with a CAPTCHA or anti-bot challenge instead of JSON. Automated logins
+// cannot pass it; import a token from a browser session instead.
+var ErrLoginChallenge = errors.New("manual login required")
+
+var (
+	loginChallengeActive = metrics.NewGauge("mizito_login_challenge",
+		"1 while automated login is blocked by a CAPTCHA or anti-bot challenge and a manual login is required.")
+	loginChallenges = metrics.NewCounter("mizito_login_challenges_total",
+		"Login attempts answered with a CAPTCHA or anti-bot challenge.")
+)
+
+// challengeMarkers are case-insensitive fragments only CAPTCHA and anti-bot
+// pages contain: the widgets of reCAPTCHA, hCaptcha and Turnstile and the
+// interstitial pages of Cloudflare
+var challengeMarkers = [][]byte{
+	[]byte("g-recaptcha"),
+	[]byte("google.com/recaptcha"),
+	[]byte("h-captcha"),
+	[]byte("hcaptcha.com"),
+	[]byte("cf-turnstile"),
+	[]byte("challenges.cloudflare.com"),
+	[]byte("cf-challenge"),
+	[]byte("cf_chl_"),
+	[]byte("challenge-platform"),
+}
+
+// detectChallenge reports why a login response looks like a challenge rather
+// than an answer of the login API, or "" when it does not: a page carrying
+// one of the challengeMarkers, or an API error asking for a CAPTCHA. Other
+// pages, such as the error pages of a proxy in front of Mizito, are left to
+// the regular error handling, so they do not pause logins.
+func detectChallenge(resp *http.Response, body []byte) string {
+	lower := bytes.ToLower(body)
+
+	if json.Valid(body) {
+		if bytes.Contains(lower, []byte("captcha")) {
+			return fmt.Sprintf("API asks for a CAPTCHA with status %d", resp.StatusCode)
+		}
+		return ""
+	}
+
+	for _, marker := range challengeMarkers {
+		if bytes.Contains(lower, marker) {
+			return fmt.Sprintf("response contains %q with status %d", marker, resp.StatusCode)
+		}
+	}
+	return ""
+}
+
+// challengeRemaining returns how long automated logins stay paused after a
+// challenge; zero when they are not paused. Callers must hold statsMutex.
+func (a *AuthService) challengeRemaining() time.Duration {
+	if a.stats.ChallengeAt.IsZero() {
+		return 0
+	}
+	remaining := time.Until(a.stats.ChallengeAt.Add(a.config.LoginChallengeCooldown))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// recordChallenge pauses automated logins and alerts the operator. Callers
+// must hold statsMutex.
+func (a *AuthService) recordChallenge(err error) {
+	first := a.stats.ChallengeAt.IsZero()
+	a.stats.ChallengeAt = time.Now()
+	a.stats.ChallengeReason = a.sanitizeError(err)
+
+	loginChallenges.Inc()
+	loginChallengeActive.Set(1)
+
+	if first {
+		a.logger.Error("Manual login required: Mizito answered the login with a CAPTCHA or anti-bot challenge. "+
+			"Log in with a browser and import the session token (import-token or POST /api/v1/token).",
+			"reason", a.stats.ChallengeReason,
+			"retry_in", a.config.LoginChallengeCooldown)
+		reporting.CaptureError(context.Background(), err, map[string]string{"operation": "login", "challenge": "true"})
+	} else {
+		a.logger.Warn("Login still blocked by a challenge",
+			"reason", a.stats.ChallengeReason,
+			"retry_in", a.config.LoginChallengeCooldown)
+	}
+}
+
+// clearChallenge resumes automated logins. Callers must hold statsMutex.
+func (a *AuthService) clearChallenge() {
+	if a.stats.ChallengeAt.IsZero() {
+		return
+	}
+	a.stats.ChallengeAt = time.Time{}
+	a.stats.ChallengeReason = ""
+	loginChallengeActive.Set(0)
+	a.logger.Info("Login challenge cleared")
+}
@@ -0,0 +1,233 @@
+package mizito
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Dialog is a Mizito chat dialog
+type Dialog struct {
+	ID    string
+	Title string
+}
+
+// dialogListKeys are the fields that may wrap a dialog list in a response
+var dialogListKeys = []string{"data", "dialogs", "result", "items"}
+
+// ListDialogs fetches the dialogs of the account from a dialog list
+// endpoint. The response may be a JSON array of dialogs or an object wrapping
+// one; dialogs are read from their _id/id and title/name fields.
+func (m *MessageService) ListDialogs(ctx context.Context, url string) ([]Dialog, error) {
+	token, err := m.auth.GetToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JWT token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dialog list request: %w", err)
+	}
+	m.setHeaders(req)
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("x-token", token)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("dialog list request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dialog list request failed with status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dialog list: %w", err)
+	}
+
+	list, err := listItems(body, dialogListKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dialog list: %w", err)
+	}
+
+	var dialogs []Dialog
+	for _, obj := range list {
+		dialog := Dialog{
+			ID:    firstString(obj, "_id", "id"),
+			Title: firstString(obj, "title", "name", "subject"),
+		}
+		if dialog.ID != "" {
+			dialogs = append(dialogs, dialog)
+		}
+	}
+
+	return dialogs, nil
+}
+
+// listItems returns the objects of a list response: a JSON array, or an
+// object wrapping one in one of the given fields
+func listItems(body []byte, keys []string) ([]map[string]interface{}, error) {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	if obj, ok := data.(map[string]interface{}); ok {
+		for _, key := range keys {
+			if list, ok := obj[key].([]interface{}); ok {
+				data = list
+				break
+			}
+		}
+	}
+
+	list, ok := data.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("response contains no list")
+	}
+
+	items := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if obj, ok := item.(map[string]interface{}); ok {
+			items = append(items, obj)
+		}
+	}
+	return items, nil
+}
+
+// firstString returns the first of the given fields holding a string or number
+func firstString(obj map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch v := obj[key].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// dialogKeys are the fields that may wrap the dialog in a creation response
+var dialogKeys = []string{"data", "dialog", "result"}
+
+// CreateDialog creates a group dialog with the given title and members and
+// returns its ID, read from the _id/id field of the response or of the
+// dialog it wraps. Transient failures are retried; as a failed attempt may
+// have created the dialog all the same, e.g. when its response timed out,
+// a dialog with the title is looked up before each retry and used if found.
+func (m *MessageService) CreateDialog(ctx context.Context, title string, members []string) (string, error) {
+	config, _ := m.settings()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"title":   title,
+		"type":    "group",
+		"members": members,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal dialog request: %w", err)
+	}
+
+	var dialogID string
+	attempts := 0
+	err = m.retry(ctx, "dialog", func() error {
+		var err error
+		if attempts++; attempts > 1 {
+			// Creating it again without knowing would leave a duplicate group
+			if dialogID, err = m.findDialog(ctx, config.MizitoDialogListURL, title); err != nil {
+				return transient(fmt.Errorf("failed to look up dialog before retrying its creation: %w", err))
+			}
+			if dialogID != "" {
+				m.logger.WithContext(ctx).Info("Found dialog created by a failed attempt", "title", title, "dialog", dialogID)
+				return nil
+			}
+		}
+		dialogID, err = m.createDialogOnce(ctx, config.MizitoDialogCreateURL, body)
+		return err
+	})
+	return dialogID, err
+}
+
+// findDialog returns the ID of the first listed dialog titled title, or an
+// empty string when there is none
+func (m *MessageService) findDialog(ctx context.Context, url, title string) (string, error) {
+	dialogs, err := m.ListDialogs(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	for _, dialog := range dialogs {
+		if dialog.Title == title {
+			return dialog.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// createDialogOnce posts a dialog creation request with the current token
+func (m *MessageService) createDialogOnce(ctx context.Context, url string, body []byte) (string, error) {
+	token, err := m.auth.GetToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get JWT token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create dialog request: %w", err)
+	}
+	m.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("x-token", token)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", transient(fmt.Errorf("dialog request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", transient(fmt.Errorf("failed to read dialog response: %w", err))
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		if err := m.auth.RefreshToken(ctx); err != nil {
+			return "", fmt.Errorf("failed to refresh token on 401: %w", err)
+		}
+		return "", transient(fmt.Errorf("dialog creation failed with unauthorized status, token refreshed"))
+	}
+	if retryableStatus(resp.StatusCode) {
+		return "", transient(fmt.Errorf("dialog creation failed with status: %d, body: %s", resp.StatusCode, respBody))
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", rejected(fmt.Errorf("dialog creation failed with status: %d, body: %s", resp.StatusCode, respBody))
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(respBody, &obj); err != nil {
+		return "", fmt.Errorf("failed to parse dialog response: %w", err)
+	}
+	if status, ok := obj["status"].(float64); ok && status != 1 {
+		return "", rejected(fmt.Errorf("dialog creation failed with status: %g, message: %v", status, obj["message"]))
+	}
+
+	if id := firstString(obj, "_id", "id"); id != "" {
+		return id, nil
+	}
+	for _, key := range dialogKeys {
+		if inner, ok := obj[key].(map[string]interface{}); ok {
+			if id := firstString(inner, "_id", "id"); id != "" {
+				return id, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("dialog response contains no dialog ID")
+}
@@ -0,0 +1,38 @@
+package mizito
+
+import (
+	"net/http"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+)
+
+// SetHeaders sets the headers configured for requests to Mizito, such as
+// the User-Agent, on a request. The Accept, Content-Type and x-token
+// headers of the request are set separately.
+func SetHeaders(req *http.Request, config *config.Config) {
+	for name, value := range config.MizitoHeaders {
+		req.Header.Set(name, value)
+	}
+}
+
+// setHeaders sets the configured headers on a request of the service
+func (m *MessageService) setHeaders(req *http.Request) {
+	config, _ := m.settings()
+	SetHeaders(req, config)
+}
+
+// credentialHeaders are masked when the headers of a request are logged, so
+// the session token does not reach log files or remote log outputs
+var credentialHeaders = []string{"X-Token", "Authorization", "Cookie", "Proxy-Authorization"}
+
+// redactHeaders copies the headers of a request for logging, masking
+// credentials
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range credentialHeaders {
+		if redacted.Get(name) != "" {
+			redacted.Set(name, "[REDACTED]")
+		}
+	}
+	return redacted
+}
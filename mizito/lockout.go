@@ -0,0 +1,182 @@
+package mizito
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/reporting"
+)
+
+// ErrLoginRejected is returned by Login when Mizito rejects the credentials
+var ErrLoginRejected = errors.New("login rejected")
+
+// ErrLoginBackoff is returned by Login while waiting before the next attempt
+// after rejected credentials
+var ErrLoginBackoff = errors.New("login backing off")
+
+// ErrLoginLocked is returned by Login after LoginMaxFailures consecutive
+// rejections, until the lockout is reset through the admin API
+var ErrLoginLocked = errors.New("login locked")
+
+var (
+	loginLocked = metrics.NewGauge("mizito_login_locked",
+		"1 while automated logins are stopped after repeated rejected credentials.")
+	loginRejections = metrics.NewGauge("mizito_login_consecutive_rejections",
+		"Consecutive logins rejected by Mizito.")
+)
+
+// lockoutState is the persisted state of an open login lockout
+type lockoutState struct {
+	LockedAt  time.Time `json:"locked_at"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error"`
+}
+
+// loadLockout restores a lockout persisted by a previous run, so restarts
+// do not resume logging in with rejected credentials
+func (a *AuthService) loadLockout() {
+	data, err := os.ReadFile(a.config.LoginLockoutFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			a.logger.Warn("Failed to read login lockout state", "file", a.config.LoginLockoutFile, "error", err)
+		}
+		return
+	}
+
+	var state lockoutState
+	if err := json.Unmarshal(data, &state); err != nil {
+		a.logger.Warn("Failed to parse login lockout state", "file", a.config.LoginLockoutFile, "error", err)
+		return
+	}
+
+	a.stats.LockedAt = state.LockedAt
+	a.stats.ConsecutiveRejections = state.Failures
+	loginLocked.Set(1)
+	loginRejections.Set(float64(state.Failures))
+	a.logger.Error("Automated login is locked after repeated rejected credentials; fix MIZITO_USERNAME/MIZITO_PASSWORD and reset with POST /api/v1/auth/reset",
+		"locked_at", state.LockedAt.Format(time.RFC3339),
+		"failures", state.Failures,
+		"last_error", state.LastError)
+}
+
+// saveLockout persists an open lockout
+func (a *AuthService) saveLockout(lastError string) error {
+	data, err := json.MarshalIndent(lockoutState{
+		LockedAt:  a.stats.LockedAt,
+		Failures:  a.stats.ConsecutiveRejections,
+		LastError: lastError,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(a.config.LoginLockoutFile); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(a.config.LoginLockoutFile, data, 0600)
+}
+
+// loginBlocked returns how long until an automated login is allowed again
+// and the error Login fails with meanwhile; a nil error when logging in is
+// allowed. Callers must hold statsMutex.
+func (a *AuthService) loginBlocked() (time.Duration, error) {
+	if remaining := a.challengeRemaining(); remaining > 0 {
+		return remaining, fmt.Errorf("%w: automated login paused for %s after a CAPTCHA or anti-bot challenge; import a token from a browser session",
+			ErrLoginChallenge, remaining.Round(time.Second))
+	}
+
+	if !a.stats.LockedAt.IsZero() {
+		return a.config.LoginMaxBackoff, fmt.Errorf("%w after %d rejected attempts; fix the credentials and reset with POST /api/v1/auth/reset",
+			ErrLoginLocked, a.stats.ConsecutiveRejections)
+	}
+
+	if remaining := time.Until(a.stats.NextLoginAt); remaining > 0 {
+		return remaining, fmt.Errorf("%w: last login was rejected, next attempt in %s",
+			ErrLoginBackoff, remaining.Round(time.Second))
+	}
+
+	return 0, nil
+}
+
+// LoginPausedFor returns how long automated logins stay paused after a
+// challenge or rejected credentials; zero when logging in is allowed
+func (a *AuthService) LoginPausedFor() time.Duration {
+	a.statsMutex.Lock()
+	defer a.statsMutex.Unlock()
+
+	remaining, _ := a.loginBlocked()
+	return remaining
+}
+
+// recordRejection delays the next login exponentially and opens the lockout
+// after LoginMaxFailures consecutive rejections. Callers must hold statsMutex.
+func (a *AuthService) recordRejection(err error) {
+	a.stats.ConsecutiveRejections++
+	loginRejections.Set(float64(a.stats.ConsecutiveRejections))
+
+	if max := a.config.LoginMaxFailures; max > 0 && a.stats.ConsecutiveRejections >= max {
+		a.stats.LockedAt = time.Now()
+		a.stats.NextLoginAt = time.Time{}
+		loginLocked.Set(1)
+
+		if err := a.saveLockout(a.sanitizeError(err)); err != nil {
+			a.logger.Warn("Failed to persist login lockout state", "file", a.config.LoginLockoutFile, "error", err)
+		}
+
+		a.logger.Error("Automated login locked after repeated rejected credentials to protect the Mizito account; fix MIZITO_USERNAME/MIZITO_PASSWORD and reset with POST /api/v1/auth/reset",
+			"failures", a.stats.ConsecutiveRejections,
+			"error", a.sanitizeError(err))
+		reporting.CaptureError(context.Background(), err, map[string]string{"operation": "login", "lockout": "true"})
+		return
+	}
+
+	backoff := a.config.LoginBackoff
+	for i := 1; i < a.stats.ConsecutiveRejections && backoff < a.config.LoginMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > a.config.LoginMaxBackoff {
+		backoff = a.config.LoginMaxBackoff
+	}
+	a.stats.NextLoginAt = time.Now().Add(backoff)
+
+	a.logger.Warn("Mizito rejected the login, delaying the next attempt",
+		"failures", a.stats.ConsecutiveRejections,
+		"max_failures", a.config.LoginMaxFailures,
+		"retry_in", backoff)
+}
+
+// clearRejections forgets rejected logins after a successful one. Callers
+// must hold statsMutex.
+func (a *AuthService) clearRejections() {
+	a.stats.ConsecutiveRejections = 0
+	a.stats.NextLoginAt = time.Time{}
+	loginRejections.Set(0)
+}
+
+// ResetLogin closes the login lockout and clears any backoff or challenge
+// pause, so the next login is attempted immediately
+func (a *AuthService) ResetLogin() error {
+	a.statsMutex.Lock()
+	defer a.statsMutex.Unlock()
+
+	if err := os.Remove(a.config.LoginLockoutFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove login lockout state: %w", err)
+	}
+
+	wasLocked := !a.stats.LockedAt.IsZero()
+	a.stats.LockedAt = time.Time{}
+	loginLocked.Set(0)
+	a.clearRejections()
+	a.clearChallenge()
+
+	a.logger.Info("Login lockout reset", "was_locked", wasLocked)
+	return nil
+}
@@ -0,0 +1,118 @@
+package mizito
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// mediaKeys are the fields that may wrap the uploaded media in an upload
+// response
+var mediaKeys = []string{"data", "media", "file", "result"}
+
+// upload sends an attachment to the media endpoint and returns the media
+// object to attach to a message. The object is the JSON returned by the
+// endpoint, unwrapped from a data/media/file/result field when present.
+// Transient failures are retried.
+func (m *MessageService) upload(ctx context.Context, att render.Attachment) (interface{}, error) {
+	config, _ := m.settings()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, config.MizitoUploadField, att.Name))
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(att.Data)
+	}
+	header.Set("Content-Type", contentType)
+
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload request: %w", err)
+	}
+	part.Write(att.Data)
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("failed to create upload request: %w", err)
+	}
+
+	m.logger.WithContext(ctx).Info("Uploading attachment to Mizito",
+		"name", att.Name,
+		"content_type", contentType,
+		"size", len(att.Data))
+
+	var media interface{}
+	err = m.retry(ctx, "upload", func() error {
+		var err error
+		media, err = m.uploadOnce(ctx, config.MizitoUploadURL, form.FormDataContentType(), body.Bytes())
+		return err
+	})
+	return media, err
+}
+
+// uploadOnce posts an encoded upload form with the current token
+func (m *MessageService) uploadOnce(ctx context.Context, uploadURL, contentType string, form []byte) (interface{}, error) {
+	token, err := m.auth.GetToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JWT token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, bytes.NewReader(form))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload request: %w", err)
+	}
+	m.setHeaders(req)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("x-token", token)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, transient(fmt.Errorf("upload request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, transient(fmt.Errorf("failed to read upload response: %w", err))
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		if err := m.auth.RefreshToken(ctx); err != nil {
+			return nil, fmt.Errorf("failed to refresh token on 401: %w", err)
+		}
+		return nil, transient(fmt.Errorf("upload failed with unauthorized status, token refreshed"))
+	}
+	if retryableStatus(resp.StatusCode) {
+		return nil, transient(fmt.Errorf("upload failed with status: %d, body: %s", resp.StatusCode, respBody))
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, rejected(fmt.Errorf("upload failed with status: %d, body: %s", resp.StatusCode, respBody))
+	}
+
+	var media interface{}
+	if err := json.Unmarshal(respBody, &media); err != nil {
+		return nil, fmt.Errorf("failed to parse upload response: %w", err)
+	}
+
+	if obj, ok := media.(map[string]interface{}); ok {
+		if status, ok := obj["status"].(float64); ok && status != 1 {
+			return nil, rejected(fmt.Errorf("upload failed with status: %g, message: %v", status, obj["message"]))
+		}
+		for _, key := range mediaKeys {
+			if inner, ok := obj[key].(map[string]interface{}); ok {
+				return inner, nil
+			}
+		}
+	}
+
+	return media, nil
+}
@@ -2,15 +2,20 @@ package mizito
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
 	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/persian"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
 )
 
 // MessageRequest represents the message request structure based on the provided curl example
@@ -39,6 +44,9 @@ type MessageRequest struct {
 	NeedAvatar          bool                   `json:"needAvatar"`
 	NeedDate            bool                   `json:"needDate"`
 	Dir                 bool                   `json:"dir"`
+
+	// ReplyTo is the ID of the message this one answers
+	ReplyTo string `json:"reply_to,omitempty"`
 }
 
 // MessageResponse represents the message send response structure
@@ -47,43 +55,264 @@ type MessageResponse struct {
 	Message string `json:"message,omitempty"`
 }
 
+// messageKeys are the fields that may wrap the sent message in a response
+var messageKeys = []string{"data", "message", "result"}
+
+// Message is an outgoing Mizito chat message
+type Message struct {
+	Text     string
+	Priority int
+
+	// DialogID overrides priority-based dialog routing when set
+	DialogID string
+
+	// FromUserID overrides MizitoFromUserID as the sender when set
+	FromUserID string
+
+	// Attachments are uploaded to the media endpoint. The first is attached
+	// to the message, further ones follow as messages of their own.
+	Attachments []render.Attachment
+
+	// ReplyTo is the ID of a message this one answers, so it shows in the
+	// thread of that message
+	ReplyTo string
+
+	// ID is set by Send to the ID Mizito gave the message, when its
+	// response tells it
+	ID string
+}
+
 // BoolResponse represents a boolean response from Mizito API
 type BoolResponse bool
 
 // MessageService handles sending messages to Mizito chat API
 type MessageService struct {
-	config *config.Config
 	auth   *AuthService
 	logger *logger.Logger
 	client *http.Client
+
+	// mutex guards config and limiter, which are replaced on reload
+	mutex  sync.RWMutex
+	config *config.Config
+
+	// limiter caps the outgoing message rate; nil when unlimited
+	limiter *rateLimiter
+
+	// inflight counts sends in progress so Stop can wait for them
+	inflight inflight
+
+	// routeDialogs holds the dialogs created for routes
+	routeDialogs routeDialogs
+
+	// users is the user directory, synced with USER_DIRECTORY_SYNC
+	users Directory
+}
+
+// ErrStopped is returned by Send once the service has been stopped
+var ErrStopped = errors.New("message service is stopped")
+
+// inflight tracks sends in progress
+type inflight struct {
+	mutex   sync.Mutex
+	count   int
+	stopped bool
+	idle    chan struct{}
+}
+
+// begin registers a send, failing once the service is stopped
+func (f *inflight) begin() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.stopped {
+		return ErrStopped
+	}
+	f.count++
+	return nil
+}
+
+// end unregisters a send and wakes Stop after the last one
+func (f *inflight) end() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.count--
+	if f.count == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
 }
 
 // NewMessageService creates a new message service
 func NewMessageService(config *config.Config, auth *AuthService, logger *logger.Logger) *MessageService {
 	return &MessageService{
-		config: config,
-		auth:   auth,
-		logger: logger,
+		config:  config,
+		auth:    auth,
+		logger:  logger,
+		limiter: newLimiter(config),
 		client: &http.Client{
-			Timeout: 30 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConns:       10,
-				IdleConnTimeout:    90 * time.Second,
-				DisableCompression: false,
-			},
+			Timeout:   30 * time.Second,
+			Transport: newTransport(config),
 		},
 	}
 }
 
-// SendMessage sends a message to the specified dialog
+// newLimiter creates the outgoing rate limiter of a configuration, or nil
+// when the rate is unlimited
+func newLimiter(config *config.Config) *rateLimiter {
+	if config.MaxMessagesPerMinute <= 0 {
+		return nil
+	}
+	return newRateLimiter(config.MaxMessagesPerMinute, config.RateLimitBurst)
+}
+
+// Reload puts a new configuration into service for the messages sent from
+// now on, e.g. changed dialogs or rate limits. The rate limiter keeps its
+// state unless the limit changed. The proxy is not changed.
+func (m *MessageService) Reload(config *config.Config) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if config.MaxMessagesPerMinute != m.config.MaxMessagesPerMinute || config.RateLimitBurst != m.config.RateLimitBurst {
+		m.limiter = newLimiter(config)
+	}
+	m.config = config
+}
+
+// settings returns the configuration and rate limiter in service
+func (m *MessageService) settings() (*config.Config, *rateLimiter) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.config, m.limiter
+}
+
+// SendMessage sends a message to the default dialog
 func (m *MessageService) SendMessage(messageText string) error {
-	m.logger.Info("Sending message to Mizito chat", "message", messageText)
+	config, _ := m.settings()
+	return m.Send(context.Background(), &Message{
+		Text:     messageText,
+		DialogID: config.MizitoDialogID,
+	})
+}
+
+// DialogForPriority resolves the target dialog of a priority from the
+// dialog routing table, falling back to the default dialog
+func (m *MessageService) DialogForPriority(priority int) string {
+	config, _ := m.settings()
+	for _, route := range config.DialogRoutes {
+		if route.Matches(priority) {
+			return route.DialogID
+		}
+	}
+	return config.MizitoDialogID
+}
+
+// Stop rejects new sends and waits until the sends in progress have
+// finished, or ctx expires
+func (m *MessageService) Stop(ctx context.Context) error {
+	m.inflight.mutex.Lock()
+	m.inflight.stopped = true
+	if m.inflight.count == 0 {
+		m.inflight.mutex.Unlock()
+		return nil
+	}
+	if m.inflight.idle == nil {
+		m.inflight.idle = make(chan struct{})
+	}
+	idle := m.inflight.idle
+	pending := m.inflight.count
+	m.inflight.mutex.Unlock()
+
+	m.logger.Info("Waiting for messages in flight", "count", pending)
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Send sends a message to its dialog, resolved from its priority unless set
+// explicitly. Errors name the request ID of ctx, if any, so a failure reported
+// to a sender can be found in the logs.
+func (m *MessageService) Send(ctx context.Context, msg *Message) error {
+	err := m.send(ctx, msg)
+	if id := logger.RequestID(ctx); err != nil && id != "" {
+		return fmt.Errorf("%w (request %s)", err, id)
+	}
+	return err
+}
+
+// send delivers a message, uploading its attachments first
+func (m *MessageService) send(ctx context.Context, msg *Message) error {
+	dialogID := msg.DialogID
+	if dialogID == "" {
+		dialogID = m.DialogForPriority(msg.Priority)
+	}
+	messageText := msg.Text
+	fromUserID := msg.FromUserID
+	if fromUserID == "" {
+		config, _ := m.settings()
+		fromUserID = config.MizitoFromUserID
+	}
+	log := m.logger.WithContext(ctx)
+
+	if err := m.inflight.begin(); err != nil {
+		return err
+	}
+	defer m.inflight.end()
+
+	if err := m.throttle(ctx, log); err != nil {
+		return err
+	}
 
-	// Get JWT token
-	token, err := m.auth.GetToken()
+	log.Info("Sending message to Mizito chat", "dialog", dialogID, "from", fromUserID, "message", messageText)
+
+	// Upload all attachments before posting, so a failed upload does not
+	// leave a partially delivered message behind
+	var err error
+	media := make([]interface{}, len(msg.Attachments))
+	for i, att := range msg.Attachments {
+		if media[i], err = m.upload(ctx, att); err != nil {
+			return fmt.Errorf("failed to upload attachment %s: %w", att.Name, err)
+		}
+	}
+
+	var first interface{}
+	if len(media) > 0 {
+		first = media[0]
+
+		// A file sent without text is captioned with its name, like the others
+		if messageText == "" {
+			messageText = msg.Attachments[0].Name
+		}
+	}
+	id, err := m.post(ctx, dialogID, fromUserID, messageText, first, msg.ReplyTo)
 	if err != nil {
-		return fmt.Errorf("failed to get JWT token: %w", err)
+		return err
 	}
+	msg.ID = id
+
+	// Further attachments follow as messages captioned with their file name
+	for i := 1; i < len(media); i++ {
+		if err := m.throttle(ctx, log); err != nil {
+			return err
+		}
+		if _, err := m.post(ctx, dialogID, fromUserID, msg.Attachments[i].Name, media[i], ""); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// post sends a single chat message, with an uploaded media object or nil,
+// as a reply to the message replyTo when set, and returns the ID Mizito gave
+// it, if its response tells it. Transient failures are retried with the
+// same body, so Mizito sees the same random ID again.
+func (m *MessageService) post(ctx context.Context, dialogID, fromUserID, messageText string, media interface{}, replyTo string) (string, error) {
+	log := m.logger.WithContext(ctx)
 
 	// Generate current time in milliseconds
 	now := time.Now()
@@ -93,7 +322,7 @@ func (m *MessageService) SendMessage(messageText string) error {
 	randomID := rand.Float64()
 
 	// Create Persian date/time strings
-	persianYear, persianMonth, persianDay := m.calculatePersianDate(now)
+	persianYear, persianMonth, persianDay := persian.ToJalali(now)
 	persianDate := m.formatPersianDate(now)
 	persianTime := m.formatPersianTime(now)
 	persianFullDate := fmt.Sprintf("%s - %d/%02d/%02d", persianTime, persianYear, persianMonth, persianDay)
@@ -103,11 +332,11 @@ func (m *MessageService) SendMessage(messageText string) error {
 		Underscore:          "message",
 		ID:                  1,
 		Local:               1,
-		Dialog:              m.config.MizitoDialogID,
+		Dialog:              dialogID,
 		Out:                 true,
 		Message:             messageText,
-		Media:               nil,
-		From:                m.config.MizitoFromUserID,
+		Media:               media,
+		From:                fromUserID,
 		Date:                date,
 		SeenCount:           1,
 		RandomID:            randomID,
@@ -124,73 +353,116 @@ func (m *MessageService) SendMessage(messageText string) error {
 		NeedAvatar:          true,
 		NeedDate:            true,
 		Dir:                 true,
+
+		ReplyTo: replyTo,
 	}
 
 	// Marshal request to JSON
 	jsonData, err := json.Marshal(msgReq)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message request: %w", err)
+		return "", fmt.Errorf("failed to marshal message request: %w", err)
+	}
+
+	log.Debug("Message request body", "body", string(jsonData))
+
+	var id string
+	err = m.retry(ctx, "send", func() error {
+		req, err := m.newPostRequest(ctx, jsonData)
+		if err != nil {
+			return err
+		}
+		id, err = m.sendRequest(req)
+		return err
+	})
+	return id, err
+}
+
+// newPostRequest creates a chat send request with the current token
+func (m *MessageService) newPostRequest(ctx context.Context, jsonData []byte) (*http.Request, error) {
+	token, err := m.auth.GetToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JWT token: %w", err)
 	}
 
-	// Create request
-	req, err := http.NewRequest("POST", m.config.MizitoChatAPIURL, bytes.NewBuffer(jsonData))
+	config, _ := m.settings()
+	req, err := http.NewRequestWithContext(ctx, "POST", config.MizitoChatAPIURL, bytes.NewReader(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create message request: %w", err)
+		return nil, fmt.Errorf("failed to create message request: %w", err)
 	}
 
 	// Set headers
+	SetHeaders(req, config)
 	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
 	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.8")
-	req.Header.Set("Connection", "keep-alive")
-	req.Header.Set("Origin", "https://office.mizito.ir")
-	req.Header.Set("Referer", "https://office.mizito.ir/")
-	req.Header.Set("Sec-Fetch-Dest", "empty")
-	req.Header.Set("Sec-Fetch-Mode", "cors")
-	req.Header.Set("Sec-Fetch-Site", "same-site")
-	req.Header.Set("Sec-GPC", "1")
 	req.Header.Set("x-token", token)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36")
-	req.Header.Set("sec-ch-ua", "\"Chromium\";v=\"142\", \"Brave\";v=\"142\", \"Not_A Brand\";v=\"99\"")
-	req.Header.Set("sec-ch-ua-mobile", "?0")
-	req.Header.Set("sec-ch-ua-platform", "\"Windows\"")
 
-	m.logger.Debug("Message request headers", "headers", req.Header)
-	m.logger.Debug("Message request body", "body", string(jsonData))
+	m.logger.WithContext(ctx).Debug("Message request headers", "headers", redactHeaders(req.Header))
+	return req, nil
+}
 
-	// Make request
-	return m.sendRequest(req)
+// throttle enforces the outgoing rate limit, waiting for a free slot or
+// failing with ErrRateLimited depending on the configured mode
+func (m *MessageService) throttle(ctx context.Context, log *logger.Logger) error {
+	config, limiter := m.settings()
+	if limiter == nil {
+		return nil
+	}
+
+	if config.RateLimitMode == RateLimitReject {
+		if ok, _ := limiter.take(); !ok {
+			rateLimited.Inc(RateLimitReject)
+			log.Warn("Outgoing rate limit exceeded, rejecting message",
+				"max_per_minute", config.MaxMessagesPerMinute)
+			return ErrRateLimited
+		}
+		return nil
+	}
+
+	if ok, delay := limiter.take(); !ok {
+		rateLimited.Inc(RateLimitWait)
+		log.Info("Outgoing rate limit reached, delaying message", "wait", delay.Round(time.Millisecond))
+		if err := limiter.wait(ctx); err != nil {
+			return fmt.Errorf("waiting for rate limit: %w", err)
+		}
+	}
+	return nil
 }
 
-// sendRequest sends the HTTP request and handles 401 by refreshing token
-func (m *MessageService) sendRequest(req *http.Request) error {
+// sendRequest sends the HTTP request and handles 401 by refreshing token.
+// Failures another attempt may not hit are transient.
+func (m *MessageService) sendRequest(req *http.Request) (string, error) {
+	log := m.logger.WithContext(req.Context())
+
 	// Make request
 	resp, err := m.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("message request failed: %w", err)
+		return "", transient(fmt.Errorf("message request failed: %w", err))
 	}
 	defer resp.Body.Close()
 
 	// Read response
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read message response: %w", err)
+		return "", transient(fmt.Errorf("failed to read message response: %w", err))
 	}
 
-	m.logger.Debug("Message response status", "status", resp.StatusCode)
-	m.logger.Debug("Message response body", "body", string(body))
+	log.Debug("Message response status", "status", resp.StatusCode)
+	log.Debug("Message response body", "body", string(body))
 
 	// Check HTTP status
 	if resp.StatusCode == http.StatusUnauthorized {
-		m.logger.Warn("Unauthorized response, refreshing token")
-		if err := m.auth.RefreshToken(); err != nil {
-			return fmt.Errorf("failed to refresh token on 401: %w", err)
+		log.Warn("Unauthorized response, refreshing token")
+		if err := m.auth.RefreshToken(req.Context()); err != nil {
+			return "", fmt.Errorf("failed to refresh token on 401: %w", err)
 		}
-		return fmt.Errorf("message send failed with unauthorized status, token refreshed")
+		return "", transient(fmt.Errorf("message send failed with unauthorized status, token refreshed"))
 	}
 
+	if retryableStatus(resp.StatusCode) {
+		return "", transient(fmt.Errorf("message send failed with status: %d, body: %s", resp.StatusCode, string(body)))
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("message send failed with status: %d, body: %s", resp.StatusCode, string(body))
+		return "", rejected(fmt.Errorf("message send failed with status: %d, body: %s", resp.StatusCode, string(body)))
 	}
 
 	// Try to parse as boolean first
@@ -198,10 +470,10 @@ func (m *MessageService) sendRequest(req *http.Request) error {
 	if err := json.Unmarshal(body, &boolResp); err == nil {
 		// Response is a boolean
 		if bool(boolResp) {
-			m.logger.Debug("Message sent successfully (boolean response)")
-			return nil
+			log.Debug("Message sent successfully (boolean response)")
+			return "", nil
 		} else {
-			return fmt.Errorf("message send failed: received false response")
+			return "", rejected(fmt.Errorf("message send failed: received false response"))
 		}
 	}
 
@@ -210,100 +482,45 @@ func (m *MessageService) sendRequest(req *http.Request) error {
 	if err := json.Unmarshal(body, &msgResp); err == nil {
 		// Check response status
 		if msgResp.Status != 1 {
-			return fmt.Errorf("message send failed with status: %d, message: %s", msgResp.Status, msgResp.Message)
+			return "", rejected(fmt.Errorf("message send failed with status: %d, message: %s", msgResp.Status, msgResp.Message))
 		}
 	} else {
 		// Could not parse response at all
-		m.logger.Warn("Could not parse Mizito response", "response", string(body))
-		return fmt.Errorf("message send failed: unexpected response format")
+		log.Warn("Could not parse Mizito response", "response", string(body))
+		return "", fmt.Errorf("message send failed: unexpected response format")
 	}
 
-	m.logger.Info("Message sent successfully to Mizito chat")
-	return nil
+	log.Info("Message sent successfully to Mizito chat")
+	return messageID(body), nil
 }
 
-// formatPersianDate formats date in Persian
-func (m *MessageService) formatPersianDate(t time.Time) string {
-	weekdays := []string{"یکشنبه", "دوشنبه", "سه‌شنبه", "چهارشنبه", "پنج‌شنبه", "جمعه", "شنبه"}
-	months := []string{"", "فروردین", "اردیبهشت", "خرداد", "تیر", "مرداد", "شهریور", "مهر", "آبان", "آذر", "دی", "بهمن", "اسفند"}
-
-	// Calculate Persian date
-	_, persianMonth, persianDay := m.calculatePersianDate(t)
-
-	weekday := weekdays[t.Weekday()]
-
-	return fmt.Sprintf("%s %d %s", weekday, persianDay, months[persianMonth])
-}
-
-// formatPersianTime formats time in Persian
-func (m *MessageService) formatPersianTime(t time.Time) string {
-	hour := t.Hour()
-	minute := t.Minute()
-
-	// Convert to Persian numerals (simplified)
-	persianHour := m.toPersianNumeral(hour)
-	persianMinute := m.toPersianNumeral(minute)
-
-	return fmt.Sprintf("%s:%s", persianHour, persianMinute)
-}
-
-// calculatePersianDate calculates Persian year, month, day from Gregorian date
-func (m *MessageService) calculatePersianDate(t time.Time) (int, int, int) {
-	// Persian new year is approximately March 21
-	isLeap := t.Year()%4 == 0 && (t.Year()%100 != 0 || t.Year()%400 == 0)
-	newYearDay := 80 // March 21 is day 80 in non-leap year (Jan 31 + Feb 28 + Mar 21 = 80)
-	if isLeap {
-		newYearDay = 81 // Feb 29
+// messageID reads the ID of a sent message from the _id/id field of a JSON
+// response or of the message it wraps, empty when it holds none
+func messageID(body []byte) string {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return ""
 	}
-
-	dayOfYear := t.YearDay()
-
-	var persianDayOfYear int
-	var persianYear int
-
-	if dayOfYear >= newYearDay {
-		persianDayOfYear = dayOfYear - newYearDay
-		persianYear = t.Year() - 621
-	} else {
-		// Previous Persian year
-		prevYear := t.Year() - 1
-		prevIsLeap := prevYear%4 == 0 && (prevYear%100 != 0 || prevYear%400 == 0)
-		prevDays := 365
-		if prevIsLeap {
-			prevDays = 366
-		}
-		persianDayOfYear = prevDays - (newYearDay - dayOfYear) + 1
-		persianYear = prevYear - 621
+	if id := firstString(obj, "_id", "id"); id != "" {
+		return id
 	}
-
-	// Persian months: Farvardin to Esfand
-	persianMonths := []int{31, 31, 31, 31, 31, 30, 30, 30, 30, 30, 29}
-
-	cumulative := 0
-	for i, days := range persianMonths {
-		cumulative += days
-		if persianDayOfYear <= cumulative {
-			persianMonth := i + 1
-			persianDay := persianDayOfYear - (cumulative - days)
-			return persianYear, persianMonth, persianDay
+	for _, key := range messageKeys {
+		if inner, ok := obj[key].(map[string]interface{}); ok {
+			if id := firstString(inner, "_id", "id"); id != "" {
+				return id
+			}
 		}
 	}
-
-	// Should not reach here
-	return persianYear, 12, 29
+	return ""
 }
 
-// toPersianNumeral converts numbers to Persian numerals (simplified)
-// Always returns a 2-digit string for time formatting
-func (m *MessageService) toPersianNumeral(num int) string {
-	// This is a simplified implementation
-	// Persian numerals: ۰۱۲۳۴۵۶۷۸۹
-	persianDigits := []rune{'۰', '۱', '۲', '۳', '۴', '۵', '۶', '۷', '۸', '۹'}
-
-	// Extract tens and ones digits
-	tens := (num / 10) % 10
-	ones := num % 10
+// formatPersianDate formats date in Persian as weekday, Jalali day and month
+func (m *MessageService) formatPersianDate(t time.Time) string {
+	_, persianMonth, persianDay := persian.ToJalali(t)
+	return fmt.Sprintf("%s %d %s", persian.WeekdayName(t.Weekday()), persianDay, persian.MonthName(persianMonth))
+}
 
-	// Always return 2 digits
-	return string(persianDigits[tens]) + string(persianDigits[ones])
+// formatPersianTime formats time as zero-padded HH:MM in Persian digits
+func (m *MessageService) formatPersianTime(t time.Time) string {
+	return fmt.Sprintf("%s:%s", persian.Pad(t.Hour(), 2), persian.Pad(t.Minute(), 2))
 }
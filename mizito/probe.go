@@ -0,0 +1,164 @@
+package mizito
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+// Upstream health metrics, updated by the Prober of each account
+var (
+	probeUp = metrics.NewGauge("mizito_up",
+		"Whether the last probe of the Mizito API succeeded (1) or not (0).", "account")
+	probeTokenValid = metrics.NewGauge("mizito_token_valid",
+		"Whether a valid Mizito token was available at the last probe.", "account")
+	probeTokenExpiry = metrics.NewGauge("mizito_token_expiry_timestamp_seconds",
+		"Unix time the current Mizito token expires.", "account")
+	probeDuration = metrics.NewGauge("mizito_probe_duration_seconds",
+		"Response time of the last Mizito API probe request.", "account")
+	probeStatusCode = metrics.NewGauge("mizito_probe_http_status",
+		"HTTP status code of the last Mizito API probe request (0 on network errors).", "account")
+	probeLastSuccess = metrics.NewGauge("mizito_probe_last_success_timestamp_seconds",
+		"Unix time of the last successful Mizito API probe.", "account")
+	probeTotal = metrics.NewCounter("mizito_probes_total",
+		"Mizito API probes by account and result.", "account", "result")
+)
+
+// Prober periodically checks a Mizito account and its API independently of
+// notification traffic and exports the results as metrics labelled with
+// the account name
+type Prober struct {
+	name   string
+	config *config.Config
+	auth   *AuthService
+	logger *logger.Logger
+	client *http.Client
+}
+
+// NewProber creates a new upstream prober of an account
+func NewProber(account *Account, logger *logger.Logger) *Prober {
+	return &Prober{
+		name:   account.Name,
+		config: account.Config,
+		auth:   account.Auth,
+		logger: logger,
+		client: &http.Client{Timeout: account.Config.ProbeTimeout, Transport: newTransport(account.Config)},
+	}
+}
+
+// Run probes the Mizito API every ProbeInterval until ctx is cancelled
+func (p *Prober) Run(ctx context.Context) {
+	p.logger.Info("Mizito probe started", "interval", p.config.ProbeInterval, "url", p.config.MizitoProbeURL)
+	defer p.logger.Info("Mizito probe stopped")
+
+	ticker := time.NewTicker(p.config.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		p.Probe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Probe runs a single check: it makes sure a valid token is available, then
+// fetches the probe URL with it, {dialog} standing for the default dialog,
+// and records the outcome
+func (p *Prober) Probe(ctx context.Context) error {
+	err := p.probe(ctx)
+	if err != nil {
+		probeUp.Set(0, p.name)
+		probeTotal.Inc(p.name, "failure")
+		p.logger.Warn("Mizito probe failed", "error", p.auth.sanitizeError(err))
+		return err
+	}
+
+	probeUp.Set(1, p.name)
+	probeTotal.Inc(p.name, "success")
+	probeLastSuccess.Set(float64(time.Now().Unix()), p.name)
+	p.logger.Debug("Mizito probe succeeded", "duration", time.Duration(probeDuration.Value(p.name)*float64(time.Second)))
+	return nil
+}
+
+func (p *Prober) probe(ctx context.Context) error {
+	token, err := p.auth.GetToken(ctx)
+	probeTokenValid.Set(metrics.BoolValue(err == nil), p.name)
+	if _, expiresAt, ok := p.auth.TokenInfo(); ok {
+		probeTokenExpiry.Set(float64(expiresAt.Unix()), p.name)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.ProbeTimeout)
+	defer cancel()
+
+	probeURL := strings.ReplaceAll(p.config.MizitoProbeURL, "{dialog}", url.PathEscape(p.config.MizitoDialogID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create probe request: %w", err)
+	}
+	SetHeaders(req, p.config)
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("x-token", token)
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	probeDuration.Set(time.Since(start).Seconds(), p.name)
+	if err != nil {
+		probeStatusCode.Set(0, p.name)
+		return fmt.Errorf("probe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	probeStatusCode.Set(float64(resp.StatusCode), p.name)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		probeTokenValid.Set(0, p.name)
+		return fmt.Errorf("probe rejected the token: status %d", resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("probe returned status %d", resp.StatusCode)
+	}
+
+	// A web page, such as the login page a redirect ends on, means the
+	// token was not put to the test
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/html" {
+		return fmt.Errorf("probe got a web page instead of an API response; point MIZITO_PROBE_PATH at an authenticated endpoint")
+	}
+
+	return nil
+}
+
+// Ping checks that the Mizito host answers at all: any HTTP response to a
+// request of the base URL counts, only network errors fail. Unlike Probe it
+// needs no token.
+func (a *AuthService) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.config.MizitoBaseURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create ping request: %w", err)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ping request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	return nil
+}
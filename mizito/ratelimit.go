@@ -0,0 +1,84 @@
+package mizito
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+// Rate limit modes: wait for a free slot, or fail immediately
+const (
+	RateLimitWait   = "wait"
+	RateLimitReject = "reject"
+)
+
+// ErrRateLimited is returned by Send when the outgoing rate limit is
+// exceeded in reject mode
+var ErrRateLimited = errors.New("outgoing message rate limit exceeded")
+
+var rateLimited = metrics.NewCounter("mizito_messages_rate_limited_total",
+	"Messages delayed or rejected by the outgoing rate limit, by mode.", "mode")
+
+// rateLimiter is a token bucket: it holds up to burst tokens, refilled at
+// rate tokens per second, and every message takes one
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter allows perMinute messages per minute on average and bursts
+// of up to burst messages
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// take takes a token if one is available; otherwise it returns how long
+// until the next token is
+func (l *rateLimiter) take() (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// wait blocks until a token is available or ctx is cancelled
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		ok, delay := l.take()
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
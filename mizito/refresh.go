@@ -0,0 +1,94 @@
+package mizito
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RunRefresher renews the JWT token in the background before it expires, so
+// the first message after a long idle period does not wait for a login.
+// Refreshes happen TokenRefreshLead (minus up to TokenRefreshJitter) before
+// expiry; failed refreshes are retried with exponential backoff. It returns
+// when ctx is cancelled.
+func (a *AuthService) RunRefresher(ctx context.Context) {
+	a.logger.Info("Token refresher started",
+		"lead", a.config.TokenRefreshLead,
+		"jitter", a.config.TokenRefreshJitter)
+	defer a.logger.Info("Token refresher stopped")
+
+	backoff := a.config.TokenRefreshBackoff
+	for {
+		wait := a.nextRefresh()
+		if wait > 0 {
+			a.logger.Debug("Next token refresh scheduled", "in", wait.Round(time.Second))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		// The token may have been renewed in the meantime, e.g. after a 401
+		if a.nextRefresh() > 0 {
+			continue
+		}
+
+		err := a.Login(ctx)
+		if err == nil && a.nextRefresh() > 0 {
+			a.logger.Info("Token refreshed proactively")
+			backoff = a.config.TokenRefreshBackoff
+			continue
+		}
+
+		// Back off after a failure, and after a login whose token is due
+		// again at once, e.g. one expiring within TOKEN_REFRESH_LEAD, rather
+		// than logging in again right away
+		retryIn := backoff
+		if err != nil {
+			// Wait for the challenge cooldown instead of retrying sooner
+			if paused := a.LoginPausedFor(); paused > retryIn {
+				retryIn = paused
+			}
+			a.logger.Warn("Proactive token refresh failed, retrying",
+				"retry_in", retryIn,
+				"error", a.sanitizeError(err))
+		} else {
+			a.logger.Warn("Refreshed token is already due for refresh, retrying",
+				"retry_in", retryIn,
+				"lead", a.config.TokenRefreshLead)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryIn):
+		}
+
+		backoff *= 2
+		if backoff > a.config.TokenRefreshMaxBackoff {
+			backoff = a.config.TokenRefreshMaxBackoff
+		}
+	}
+}
+
+// nextRefresh returns how long to wait before the current token should be
+// refreshed; zero when there is no token or it is due
+func (a *AuthService) nextRefresh() time.Duration {
+	_, expiresAt, ok := a.TokenInfo()
+	if !ok {
+		return 0
+	}
+
+	refreshAt := expiresAt.Add(-a.config.TokenRefreshLead)
+	if jitter := a.config.TokenRefreshJitter; jitter > 0 {
+		refreshAt = refreshAt.Add(-time.Duration(rand.Int63n(int64(jitter))))
+	}
+
+	wait := time.Until(refreshAt)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
@@ -0,0 +1,115 @@
+package mizito
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var sendRetries = metrics.NewCounter("mizito_request_retries_total",
+	"Requests to Mizito retried after a transient failure, by operation: send, upload, dialog or users.", "operation")
+
+// transientError marks a failure that another attempt may not hit: a
+// network error, a 429 or 5xx response, or a 401 after the token was
+// refreshed
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// transient marks err as worth another attempt
+func transient(err error) error {
+	return &transientError{err: err}
+}
+
+// rejectedError marks a message Mizito answered and refused, such as one
+// for an unknown dialog. Sending it again fails the same way.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
+
+// rejected marks err as a refusal by Mizito
+func rejected(err error) error {
+	return &rejectedError{err: err}
+}
+
+// Rejected reports whether a send failed because Mizito refused the
+// message, rather than being unreachable, unavailable or rate limited.
+// Senders should not retry such messages.
+func Rejected(err error) bool {
+	var refusal *rejectedError
+	return errors.As(err, &refusal)
+}
+
+// retry calls attempt until it succeeds, fails with an error that is not
+// transient, or MIZITO_SEND_RETRIES retries are used up. Attempts are
+// spaced by an exponential backoff from MIZITO_SEND_BACKOFF up to
+// MIZITO_SEND_MAX_BACKOFF, with jitter so that senders failing together do
+// not retry together. attempt must build a new request each time, as the
+// body of a sent request is consumed.
+func (m *MessageService) retry(ctx context.Context, operation string, attempt func() error) error {
+	config, _ := m.settings()
+	log := m.logger.WithContext(ctx)
+
+	backoff := config.MizitoSendBackoff
+	for retries := 0; ; retries++ {
+		err := attempt()
+
+		var failure *transientError
+		if err == nil || !errors.As(err, &failure) || retries >= config.MizitoSendRetries {
+			return err
+		}
+
+		wait := jitter(backoff)
+		sendRetries.Inc(operation)
+		log.Warn("Mizito request failed, retrying",
+			"operation", operation,
+			"retry", retries+1,
+			"retry_in", wait.Round(time.Millisecond),
+			"error", m.auth.sanitizeError(err))
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > config.MizitoSendMaxBackoff {
+			backoff = config.MizitoSendMaxBackoff
+		}
+	}
+}
+
+// retryableStatus reports whether a response status is worth another
+// attempt: rate limited or a server error
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// jitter returns a random duration between half of d and d
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,112 @@
+package mizito
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var dialogsCreated = metrics.NewCounter("mizito_dialogs_created_total",
+	"Group dialogs created for routes, by result: success or failure.", "result")
+
+// routeDialogs holds the dialogs created for routes, persisted in
+// DIALOG_MAP_FILE so a route keeps its dialog across restarts
+type routeDialogs struct {
+	// mutex is held while a dialog is created, so concurrent first
+	// notifications of a route create one dialog
+	mutex   sync.Mutex
+	loaded  bool
+	dialogs map[string]string
+}
+
+// RouteDialog returns the dialog created for a route, creating a group
+// dialog named title on first use
+func (m *MessageService) RouteDialog(ctx context.Context, route, title string) (string, error) {
+	config, _ := m.settings()
+	log := m.logger.WithContext(ctx)
+
+	m.routeDialogs.mutex.Lock()
+	defer m.routeDialogs.mutex.Unlock()
+
+	if !m.routeDialogs.loaded {
+		dialogs, err := loadRouteDialogs(config.DialogMapFile)
+		if err != nil {
+			return "", err
+		}
+		m.routeDialogs.dialogs = dialogs
+		m.routeDialogs.loaded = true
+	}
+
+	if dialogID, ok := m.routeDialogs.dialogs[route]; ok {
+		return dialogID, nil
+	}
+
+	// Members may be given by email or username, resolved to their
+	// current IDs through the user directory
+	members := make([]string, len(config.DialogAutoMembers))
+	for i, member := range config.DialogAutoMembers {
+		members[i] = m.ResolveUser(member)
+	}
+
+	log.Info("Creating dialog for route", "route", route, "title", title, "members", len(members))
+	dialogID, err := m.CreateDialog(ctx, title, members)
+	if err != nil {
+		dialogsCreated.Inc("failure")
+		return "", fmt.Errorf("failed to create dialog for route %s: %w", route, err)
+	}
+	dialogsCreated.Inc("success")
+
+	m.routeDialogs.dialogs[route] = dialogID
+	if err := saveRouteDialogs(config.DialogMapFile, m.routeDialogs.dialogs); err != nil {
+		// The dialog is used until the next start, which creates another
+		log.Error("Failed to save dialog of route", "route", route, "dialog", dialogID, "file", config.DialogMapFile, "error", err)
+	}
+
+	log.Info("Created dialog for route", "route", route, "dialog", dialogID)
+	return dialogID, nil
+}
+
+// loadRouteDialogs reads the dialogs of routes from a map file, which may
+// not exist yet
+func loadRouteDialogs(path string) (map[string]string, error) {
+	dialogs := make(map[string]string)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return dialogs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dialog map: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &dialogs); err != nil {
+		return nil, fmt.Errorf("failed to parse dialog map %s: %w", path, err)
+	}
+	return dialogs, nil
+}
+
+// saveRouteDialogs writes the dialogs of routes to a map file, replacing it
+// atomically
+func saveRouteDialogs(path string, dialogs map[string]string) error {
+	data, err := json.MarshalIndent(dialogs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
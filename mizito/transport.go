@@ -0,0 +1,46 @@
+package mizito
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+)
+
+// newTransport creates the transport of the clients talking to Mizito. It
+// goes through MIZITO_PROXY_URL when set, and otherwise through the proxy of
+// the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables. Proxy
+// credentials are taken from the URL: they become the Proxy-Authorization
+// header for http(s) proxies and the username/password authentication of
+// socks5 proxies.
+func newTransport(config *config.Config) *http.Transport {
+	proxy := http.ProxyFromEnvironment
+	if config.MizitoProxyURL != "" {
+		// Validated when the configuration is loaded
+		if u, err := url.Parse(config.MizitoProxyURL); err == nil {
+			proxy = http.ProxyURL(u)
+		}
+	}
+
+	return &http.Transport{
+		Proxy:               proxy,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		DisableCompression:  false,
+	}
+}
+
+// ProxyDescription describes the proxy of Mizito traffic for logs, without
+// its credentials
+func ProxyDescription(config *config.Config) string {
+	if config.MizitoProxyURL == "" {
+		return "environment"
+	}
+	u, err := url.Parse(config.MizitoProxyURL)
+	if err != nil {
+		return "invalid"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,342 @@
+package mizito
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var (
+	directorySyncs = metrics.NewCounter("mizito_user_directory_syncs_total",
+		"Syncs of the user directory, by result: success or failure.", "result")
+	directoryUsers = metrics.NewGauge("mizito_user_directory_users",
+		"Users in the user directory.")
+)
+
+// User is a member of the Mizito organization
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+// userListKeys are the fields that may wrap a user list in a response
+var userListKeys = []string{"data", "users", "result", "items"}
+
+// Directory holds the users of the organization, looked up by email,
+// username or ID. It is replaced as a whole on every sync, so people who
+// left disappear and the current IDs of the others are used.
+type Directory struct {
+	mutex    sync.RWMutex
+	users    map[string]*User
+	syncedAt time.Time
+}
+
+// Lookup returns the user with the given email, username or ID, ignoring
+// case and a leading @
+func (d *Directory) Lookup(key string) (*User, bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	user, ok := d.users[directoryKey(key)]
+	return user, ok
+}
+
+// Len returns the number of users in the directory
+func (d *Directory) Len() int {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	ids := make(map[string]bool, len(d.users))
+	for _, user := range d.users {
+		ids[user.ID] = true
+	}
+	return len(ids)
+}
+
+// SyncedAt returns when the directory was last synced, zero before the
+// first sync
+func (d *Directory) SyncedAt() time.Time {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.syncedAt
+}
+
+// list returns the users of the directory ordered by ID
+func (d *Directory) list() []User {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	seen := make(map[string]bool, len(d.users))
+	var users []User
+	for _, user := range d.users {
+		if !seen[user.ID] {
+			seen[user.ID] = true
+			users = append(users, *user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// replace swaps the users of the directory
+func (d *Directory) replace(users []User, syncedAt time.Time) {
+	index := make(map[string]*User, len(users)*3)
+	for i := range users {
+		user := &users[i]
+		for _, key := range []string{user.ID, user.Username, user.Email} {
+			if key != "" {
+				index[directoryKey(key)] = user
+			}
+		}
+	}
+
+	d.mutex.Lock()
+	d.users = index
+	d.syncedAt = syncedAt
+	d.mutex.Unlock()
+
+	directoryUsers.Set(float64(len(users)))
+}
+
+// directoryKey normalizes an email, username or ID for lookups
+func directoryKey(key string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(key), "@"))
+}
+
+// Users returns the user directory of the account, empty unless
+// USER_DIRECTORY_SYNC is set
+func (m *MessageService) Users() *Directory {
+	return &m.users
+}
+
+// ResolveUser returns the ID of the user with the given email or username,
+// or key itself when the directory does not know it, such as for an ID
+func (m *MessageService) ResolveUser(key string) string {
+	if user, ok := m.users.Lookup(key); ok {
+		return user.ID
+	}
+	return key
+}
+
+// RunUserSync keeps the user directory up to date until ctx is done: the
+// users kept in USER_DIRECTORY_FILE are loaded, then fetched from Mizito
+// right away and every USER_DIRECTORY_INTERVAL
+func (m *MessageService) RunUserSync(ctx context.Context) {
+	config, _ := m.settings()
+	log := m.logger
+
+	if err := m.loadUsers(config.UserDirectoryFile); err != nil {
+		log.Warn("Failed to load user directory", "file", config.UserDirectoryFile, "error", err)
+	}
+
+	log.Info("User directory sync started", "interval", config.UserDirectoryInterval)
+	defer log.Info("User directory sync stopped")
+
+	ticker := time.NewTicker(config.UserDirectoryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.SyncUsers(ctx); err != nil && ctx.Err() == nil {
+			log.Error("Failed to sync user directory", "error", m.auth.sanitizeError(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncUsers fetches the users of the organization, replaces the directory
+// with them and saves it to USER_DIRECTORY_FILE
+func (m *MessageService) SyncUsers(ctx context.Context) error {
+	config, _ := m.settings()
+	log := m.logger.WithContext(ctx)
+
+	var users []User
+	err := m.retry(ctx, "users", func() error {
+		var err error
+		users, err = m.listUsersOnce(ctx, config.MizitoUserListURL)
+		return err
+	})
+	if err != nil {
+		directorySyncs.Inc("failure")
+		return err
+	}
+	directorySyncs.Inc("success")
+
+	joined, left, changed := m.users.diff(users)
+	m.users.replace(users, time.Now())
+	log.Info("User directory synced", "users", len(users), "joined", joined, "left", left, "changed", changed)
+
+	if err := saveUsers(config.UserDirectoryFile, users); err != nil {
+		log.Error("Failed to save user directory", "file", config.UserDirectoryFile, "error", err)
+	}
+	return nil
+}
+
+// diff counts the users that joined or left and those whose ID changed,
+// matched by email or username, compared to the current directory
+func (d *Directory) diff(users []User) (joined, left, changed int) {
+	current := d.list()
+	if len(current) == 0 {
+		return len(users), 0, 0
+	}
+
+	// ids holds the synced IDs and the replaced IDs of users who kept
+	// their email or username
+	ids := make(map[string]bool, len(users))
+	for _, user := range users {
+		ids[user.ID] = true
+		if old, ok := d.Lookup(user.ID); ok && old.ID == user.ID {
+			continue
+		}
+		if old, ok := d.lookupPerson(user); ok {
+			ids[old.ID] = true
+			changed++
+			continue
+		}
+		joined++
+	}
+	for _, user := range current {
+		if !ids[user.ID] {
+			left++
+		}
+	}
+	return joined, left, changed
+}
+
+// lookupPerson finds a user by email or username
+func (d *Directory) lookupPerson(user User) (*User, bool) {
+	for _, key := range []string{user.Email, user.Username} {
+		if key == "" {
+			continue
+		}
+		if old, ok := d.Lookup(key); ok {
+			return old, true
+		}
+	}
+	return nil, false
+}
+
+// listUsersOnce fetches the user list with the current token
+func (m *MessageService) listUsersOnce(ctx context.Context, url string) ([]User, error) {
+	token, err := m.auth.GetToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JWT token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user list request: %w", err)
+	}
+	m.setHeaders(req)
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	req.Header.Set("x-token", token)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, transient(fmt.Errorf("user list request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, transient(fmt.Errorf("failed to read user list: %w", err))
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		if err := m.auth.RefreshToken(ctx); err != nil {
+			return nil, fmt.Errorf("failed to refresh token on 401: %w", err)
+		}
+		return nil, transient(fmt.Errorf("user list request failed with unauthorized status, token refreshed"))
+	}
+	if retryableStatus(resp.StatusCode) {
+		return nil, transient(fmt.Errorf("user list request failed with status: %d", resp.StatusCode))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user list request failed with status: %d", resp.StatusCode)
+	}
+
+	list, err := listItems(body, userListKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user list: %w", err)
+	}
+
+	var users []User
+	for _, obj := range list {
+		user := User{
+			ID:       firstString(obj, "_id", "id", "user_id"),
+			Username: firstString(obj, "username", "user_name", "login"),
+			Email:    firstString(obj, "email", "mail"),
+			Name:     firstString(obj, "name", "full_name", "fullname", "display_name"),
+		}
+		if user.Name == "" {
+			user.Name = strings.TrimSpace(firstString(obj, "first_name", "firstname") + " " + firstString(obj, "last_name", "lastname"))
+		}
+		if user.ID != "" {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+// loadUsers fills the directory from a directory file, which may not exist
+// yet
+func (m *MessageService) loadUsers(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file struct {
+		SyncedAt time.Time `json:"synced_at"`
+		Users    []User    `json:"users"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse user directory %s: %w", path, err)
+	}
+
+	m.users.replace(file.Users, file.SyncedAt)
+	return nil
+}
+
+// saveUsers writes the users to a directory file, replacing it atomically
+func saveUsers(path string, users []User) error {
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"synced_at": time.Now(),
+		"users":     users,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
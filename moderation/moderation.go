@@ -0,0 +1,228 @@
+// Package moderation holds the rendered messages of moderated routes, such
+// as customer-facing announcement channels, until a moderator approves or
+// rejects them. Held messages are kept in a file so they survive restarts.
+package moderation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// Results of moderation, as counted in moderated_notifications_total
+const (
+	ResultHeld     = "held"
+	ResultApproved = "approved"
+	ResultRejected = "rejected"
+	ResultExpired  = "expired"
+)
+
+var (
+	moderated = metrics.NewCounter("moderated_notifications_total",
+		"Notifications of moderated routes by route and result: held, approved, rejected or expired.", "route", "result")
+	pending = metrics.NewGauge("moderation_pending_notifications",
+		"Notifications of moderated routes waiting for a moderator.")
+)
+
+// Message is a held message of a moderated route, rendered as it will be
+// delivered once approved
+type Message struct {
+	ID     string    `json:"id"`
+	HeldAt time.Time `json:"held_at"`
+
+	Route    string `json:"route"`
+	Account  string `json:"account"`
+	Title    string `json:"title,omitempty"`
+	Message  string `json:"message,omitempty"`
+	Priority int    `json:"priority"`
+
+	// DialogID and Text are the dialog and text of the message to deliver
+	DialogID   string `json:"dialog_id"`
+	Text       string `json:"text"`
+	FromUserID string `json:"from_user_id,omitempty"`
+
+	// RequestID is the ID of the request that sent the notification, for
+	// tracing its delivery in the logs
+	RequestID string `json:"request_id,omitempty"`
+
+	// Template is the variant of the route's template that rendered the
+	// text, empty for routes without a candidate template
+	Template string `json:"template,omitempty"`
+
+	// Attachments are stored base64-encoded in the moderation file
+	Attachments []render.Attachment `json:"attachments,omitempty"`
+}
+
+// Store keeps the held messages in a file. Messages not decided within the
+// timeout expire and are dropped.
+type Store struct {
+	path    string
+	timeout time.Duration
+
+	mutex    sync.Mutex
+	messages map[string]*Message
+}
+
+// NewStore creates a store kept in path, which may not exist yet
+func NewStore(path string, timeout time.Duration) (*Store, error) {
+	s := &Store{
+		path:     path,
+		timeout:  timeout,
+		messages: make(map[string]*Message),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read held messages: %w", err)
+	}
+
+	var messages []*Message
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("failed to parse held messages %s: %w", path, err)
+	}
+	for _, m := range messages {
+		s.messages[m.ID] = m
+	}
+	pending.Set(float64(len(s.messages)))
+	return s, nil
+}
+
+// Hold stores a message until it is decided; its time and, unless given,
+// its ID are set by the store
+func (s *Store) Hold(m *Message) error {
+	if m.ID == "" {
+		m.ID = newID()
+	}
+	m.HeldAt = time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.messages[m.ID] = m
+	if err := s.save(); err != nil {
+		delete(s.messages, m.ID)
+		return err
+	}
+	moderated.Inc(m.Route, ResultHeld)
+	pending.Set(float64(len(s.messages)))
+	return nil
+}
+
+// Pending returns the messages waiting for a moderator, the oldest first
+func (s *Store) Pending() []*Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire()
+	messages := make([]*Message, 0, len(s.messages))
+	for _, m := range s.messages {
+		messages = append(messages, m)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].HeldAt.Before(messages[j].HeldAt) })
+	return messages
+}
+
+// Get returns a message waiting for a moderator
+func (s *Store) Get(id string) (*Message, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire()
+	m, ok := s.messages[id]
+	return m, ok
+}
+
+// Decide takes a waiting message off the store, approved or rejected. ok
+// is false for unknown, expired or already decided messages, so a message
+// is delivered once however often it is approved.
+func (s *Store) Decide(id string, approved bool) (m *Message, ok bool, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire()
+	m, ok = s.messages[id]
+	if !ok {
+		return nil, false, nil
+	}
+
+	delete(s.messages, id)
+	if err := s.save(); err != nil {
+		s.messages[id] = m
+		return nil, false, err
+	}
+
+	result := ResultRejected
+	if approved {
+		result = ResultApproved
+	}
+	moderated.Inc(m.Route, result)
+	pending.Set(float64(len(s.messages)))
+	return m, true, nil
+}
+
+// expire drops the messages held longer than the timeout. The caller holds
+// the mutex.
+func (s *Store) expire() {
+	expired := false
+	for id, m := range s.messages {
+		if time.Since(m.HeldAt) > s.timeout {
+			delete(s.messages, id)
+			moderated.Inc(m.Route, ResultExpired)
+			expired = true
+		}
+	}
+	if !expired {
+		return
+	}
+
+	// Expired messages reappear after a restart if this fails, and expire
+	// again
+	s.save()
+	pending.Set(float64(len(s.messages)))
+}
+
+// save writes the moderation file, replacing it atomically. The caller
+// holds the mutex.
+func (s *Store) save() error {
+	messages := make([]*Message, 0, len(s.messages))
+	for _, m := range s.messages {
+		messages = append(messages, m)
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].HeldAt.Before(messages[j].HeldAt) })
+
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// newID returns a random ID for a held message
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,125 @@
+package persian
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Persian separators used when formatting numbers
+const (
+	ThousandsSeparator = '٬' // U+066C ARABIC THOUSANDS SEPARATOR
+	DecimalSeparator   = '٫' // U+066B ARABIC DECIMAL SEPARATOR
+)
+
+// persianDigits holds the Persian digits ۰ to ۹ indexed by value
+var persianDigits = []rune{'۰', '۱', '۲', '۳', '۴', '۵', '۶', '۷', '۸', '۹'}
+
+// Digits replaces every Latin digit in s with its Persian counterpart
+func Digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return persianDigits[r-'0']
+		}
+		return r
+	}, s)
+}
+
+// Pad formats a non-negative integer with Persian digits, zero-padded to width
+func Pad(n, width int) string {
+	return Digits(fmt.Sprintf("%0*d", width, n))
+}
+
+// FormatNumber formats a number with Persian digits, thousands separators
+// and decimal separator. Integers are formatted without a fraction, other
+// floats with up to two decimals.
+func FormatNumber(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	sign := ""
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+
+	formatted := strconv.FormatFloat(f, 'f', 2, 64)
+	intPart, fracPart, _ := strings.Cut(formatted, ".")
+	fracPart = strings.TrimRight(fracPart, "0")
+
+	result := sign + groupThousands(intPart)
+	if fracPart != "" {
+		result += string(DecimalSeparator) + fracPart
+	}
+
+	return Digits(result)
+}
+
+// groupThousands inserts the Persian thousands separator into a digit string
+func groupThousands(digits string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteRune(ThousandsSeparator)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+
+	return b.String()
+}
+
+// toFloat converts template arguments (numbers or numeric strings) to float64
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case float32:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(n), 64)
+	default:
+		return 0, fmt.Errorf("cannot format %T as a number", v)
+	}
+}
+
+// FuncMap returns the Persian helper functions available in message templates:
+//
+//	persianDigits  converts Latin digits in any value to Persian digits
+//	persianNumber  formats a number with Persian digits and separators
+//	jalaliDate     formats a time as a Jalali date, e.g. ۱۴۰۳/۰۱/۰۱
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"persianDigits": func(v interface{}) string {
+			return Digits(fmt.Sprint(v))
+		},
+		"persianNumber": func(v interface{}) (string, error) {
+			f, err := toFloat(v)
+			if err != nil {
+				return "", err
+			}
+			return FormatNumber(f), nil
+		},
+		"jalaliDate": FormatJalali,
+	}
+}
@@ -0,0 +1,33 @@
+package persian
+
+import "time"
+
+// hijriEpoch is the Julian Day Number of 1 Muharram 1 AH in the civil
+// (tabular) Islamic calendar
+const hijriEpoch = 1948440
+
+// ToHijri converts the calendar date of t, in its own location, to the
+// tabular Islamic (lunar Hijri) calendar. Official dates in Iran follow the
+// sighting of the moon and may differ from it by a day.
+func ToHijri(t time.Time) (year, month, day int) {
+	return dayToHijri(gregorianToDay(t.Year(), int(t.Month()), t.Day()))
+}
+
+// hijriToDay returns the Julian Day Number of a tabular Hijri date
+func hijriToDay(hy, hm, hd int) int {
+	return (11*hy+3)/30 + 354*hy + 30*hm - (hm-1)/2 + hd + hijriEpoch - 385
+}
+
+// dayToHijri returns the tabular Hijri date of a Julian Day Number
+func dayToHijri(jdn int) (hy, hm, hd int) {
+	hy = (30*(jdn-hijriEpoch) + 10646) / 10631
+
+	// Months alternate between 30 and 29 days, Dhu al-Hijjah has 30 in
+	// leap years
+	hm = 1
+	for hm < 12 && jdn >= hijriToDay(hy, hm+1, 1) {
+		hm++
+	}
+	hd = jdn - hijriToDay(hy, hm, 1) + 1
+	return hy, hm, hd
+}
@@ -0,0 +1,143 @@
+package persian
+
+import (
+	"fmt"
+	"time"
+)
+
+// monthNames holds the Jalali month names indexed by month (1-12)
+var monthNames = []string{"", "فروردین", "اردیبهشت", "خرداد", "تیر", "مرداد", "شهریور", "مهر", "آبان", "آذر", "دی", "بهمن", "اسفند"}
+
+// weekdayNames holds the Persian weekday names indexed by time.Weekday
+var weekdayNames = []string{"یکشنبه", "دوشنبه", "سه‌شنبه", "چهارشنبه", "پنج‌شنبه", "جمعه", "شنبه"}
+
+// jalaliBreaks are the Jalali years starting a new leap-year pattern. Leap
+// years follow the 33-year cycle between breaks, which keeps the calendar in
+// line with the astronomical vernal equinox used by the official calendar.
+// Conversion is valid between the first and last break.
+var jalaliBreaks = []int{-61, 9, 38, 199, 426, 686, 756, 818, 1111, 1181, 1210,
+	1635, 2060, 2097, 2192, 2262, 2324, 2394, 2456, 3178}
+
+// MonthName returns the Persian name of a Jalali month (1-12)
+func MonthName(month int) string {
+	if month < 1 || month > 12 {
+		return ""
+	}
+	return monthNames[month]
+}
+
+// WeekdayName returns the Persian name of a weekday
+func WeekdayName(day time.Weekday) string {
+	return weekdayNames[day]
+}
+
+// ToJalali converts the calendar date of t, in its own location, to the
+// Jalali (Solar Hijri) calendar
+func ToJalali(t time.Time) (year, month, day int) {
+	return dayToJalali(gregorianToDay(t.Year(), int(t.Month()), t.Day()))
+}
+
+// FromJalali returns midnight of a Jalali date in loc
+func FromJalali(year, month, day int, loc *time.Location) time.Time {
+	gy, gm, gd := dayToGregorian(jalaliToDay(year, month, day))
+	return time.Date(gy, time.Month(gm), gd, 0, 0, 0, 0, loc)
+}
+
+// IsJalaliLeap reports whether a Jalali year has 366 days, i.e. Esfand has 30
+func IsJalaliLeap(year int) bool {
+	leap, _, _ := jalaliCalendar(year)
+	return leap == 0
+}
+
+// FormatJalali formats the Jalali date of t as YYYY/MM/DD in Persian digits
+func FormatJalali(t time.Time) string {
+	year, month, day := ToJalali(t)
+	return Digits(fmt.Sprintf("%04d/%02d/%02d", year, month, day))
+}
+
+// jalaliCalendar returns for a Jalali year the number of years since the
+// last leap year (0 when it is a leap year), the Gregorian year in which it
+// starts and the day of March on which it starts
+func jalaliCalendar(jy int) (leap, gy, march int) {
+	gy = jy + 621
+	leapJ := -14
+	jp := jalaliBreaks[0]
+	jump := 0
+
+	for _, jm := range jalaliBreaks[1:] {
+		jump = jm - jp
+		if jy < jm {
+			break
+		}
+		leapJ += jump/33*8 + jump%33/4
+		jp = jm
+	}
+
+	n := jy - jp
+	leapJ += n/33*8 + (n%33+3)/4
+	if jump%33 == 4 && jump-n == 4 {
+		leapJ++
+	}
+
+	leapG := gy/4 - (gy/100+1)*3/4 - 150
+	march = 20 + leapJ - leapG
+
+	if jump-n < 6 {
+		n = n - jump + (jump+4)/33*33
+	}
+	leap = ((n+1)%33 - 1) % 4
+	if leap == -1 {
+		leap = 4
+	}
+
+	return leap, gy, march
+}
+
+// gregorianToDay returns the Julian Day Number of a Gregorian date
+func gregorianToDay(gy, gm, gd int) int {
+	d := (gy+(gm-8)/6+100100)*1461/4 + (153*((gm+9)%12)+2)/5 + gd - 34840408
+	return d - (gy+100100+(gm-8)/6)/100*3/4 + 752
+}
+
+// dayToGregorian returns the Gregorian date of a Julian Day Number
+func dayToGregorian(jdn int) (gy, gm, gd int) {
+	j := 4*jdn + 139361631
+	j += (4*jdn+183187720)/146097*3/4*4 - 3908
+	i := j%1461/4*5 + 308
+	gd = i%153/5 + 1
+	gm = i/153%12 + 1
+	gy = j/1461 - 100100 + (8-gm)/6
+	return gy, gm, gd
+}
+
+// jalaliToDay returns the Julian Day Number of a Jalali date
+func jalaliToDay(jy, jm, jd int) int {
+	_, gy, march := jalaliCalendar(jy)
+	return gregorianToDay(gy, 3, march) + (jm-1)*31 - jm/7*(jm-7) + jd - 1
+}
+
+// dayToJalali returns the Jalali date of a Julian Day Number
+func dayToJalali(jdn int) (jy, jm, jd int) {
+	gy, _, _ := dayToGregorian(jdn)
+	jy = gy - 621
+	leap, _, march := jalaliCalendar(jy)
+
+	// Days since 1 Farvardin of jy
+	k := jdn - gregorianToDay(gy, 3, march)
+	if k >= 0 {
+		if k <= 185 {
+			// The first six months have 31 days
+			return jy, 1 + k/31, k%31 + 1
+		}
+		k -= 186
+	} else {
+		// Before Nowruz: the last months of the previous year
+		jy--
+		k += 179
+		if leap == 1 {
+			k++
+		}
+	}
+
+	return jy, 7 + k/30, k%30 + 1
+}
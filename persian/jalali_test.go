@@ -0,0 +1,96 @@
+package persian
+
+import (
+	"testing"
+	"time"
+)
+
+// jalaliDates pairs Gregorian and Jalali dates around the turns of the
+// year, including the leap years 1399 and 1403
+var jalaliDates = []struct {
+	gregorian  string
+	year       int
+	month, day int
+}{
+	{"2021-03-20", 1399, 12, 30},
+	{"2021-03-21", 1400, 1, 1},
+	{"2022-03-20", 1400, 12, 29},
+	{"2022-03-21", 1401, 1, 1},
+	{"2023-03-20", 1401, 12, 29},
+	{"2023-03-21", 1402, 1, 1},
+	{"2024-03-19", 1402, 12, 29},
+	{"2024-03-20", 1403, 1, 1},
+	{"2024-09-21", 1403, 6, 31},
+	{"2024-09-22", 1403, 7, 1},
+	{"2024-12-31", 1403, 10, 11},
+	{"2025-01-01", 1403, 10, 12},
+	{"2025-03-19", 1403, 12, 29},
+	{"2025-03-20", 1403, 12, 30},
+	{"2025-03-21", 1404, 1, 1},
+	{"2026-03-20", 1404, 12, 29},
+	{"2026-03-21", 1405, 1, 1},
+}
+
+func TestToJalali(t *testing.T) {
+	for _, tt := range jalaliDates {
+		date, err := time.Parse("2006-01-02", tt.gregorian)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		year, month, day := ToJalali(date)
+		if year != tt.year || month != tt.month || day != tt.day {
+			t.Errorf("ToJalali(%s) = %d/%02d/%02d, want %d/%02d/%02d",
+				tt.gregorian, year, month, day, tt.year, tt.month, tt.day)
+		}
+	}
+}
+
+func TestFromJalali(t *testing.T) {
+	tehran := time.FixedZone("IRST", 3*3600+1800)
+	for _, tt := range jalaliDates {
+		got := FromJalali(tt.year, tt.month, tt.day, tehran)
+		if got.Format("2006-01-02") != tt.gregorian || got.Location() != tehran || got.Hour() != 0 {
+			t.Errorf("FromJalali(%d/%02d/%02d) = %s, want midnight of %s",
+				tt.year, tt.month, tt.day, got, tt.gregorian)
+		}
+	}
+}
+
+func TestToJalaliUsesLocation(t *testing.T) {
+	// Still 20 March in UTC, already Nowruz 1404 in Tehran
+	instant := time.Date(2025, 3, 20, 22, 0, 0, 0, time.UTC)
+
+	if year, month, day := ToJalali(instant); year != 1403 || month != 12 || day != 30 {
+		t.Errorf("ToJalali in UTC = %d/%02d/%02d, want 1403/12/30", year, month, day)
+	}
+
+	tehran := time.FixedZone("IRST", 3*3600+1800)
+	if year, month, day := ToJalali(instant.In(tehran)); year != 1404 || month != 1 || day != 1 {
+		t.Errorf("ToJalali in Tehran = %d/%02d/%02d, want 1404/01/01", year, month, day)
+	}
+}
+
+func TestIsJalaliLeap(t *testing.T) {
+	for year, leap := range map[int]bool{
+		1395: true,
+		1398: false,
+		1399: true,
+		1400: false,
+		1402: false,
+		1403: true,
+		1404: false,
+		1408: true,
+	} {
+		if got := IsJalaliLeap(year); got != leap {
+			t.Errorf("IsJalaliLeap(%d) = %v, want %v", year, got, leap)
+		}
+	}
+}
+
+func TestFormatJalali(t *testing.T) {
+	date := time.Date(2025, 3, 21, 12, 0, 0, 0, time.UTC)
+	if got, want := FormatJalali(date), "۱۴۰۴/۰۱/۰۱"; got != want {
+		t.Errorf("FormatJalali = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package policy
+
+import (
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// piiRules holds the built-in personal data rules by category
+var piiRules = map[string]rule{
+	"email": {
+		pattern:     regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+		replacement: "[EMAIL]",
+	},
+	"phone": {
+		// International numbers and 11-digit Iranian mobile and landline numbers
+		pattern:     regexp.MustCompile(`(?:\+|\b00)[1-9][0-9 -]{7,16}[0-9]\b|\b0[1-9][0-9](?:[ -]?[0-9]){8}\b`),
+		replacement: "[PHONE]",
+	},
+	"iban": {
+		pattern:     regexp.MustCompile(`\b[A-Z]{2}[0-9]{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,4})?\b`),
+		replacement: "[IBAN]",
+		valid:       validIBAN,
+	},
+	"national_id": {
+		// Iranian national code (کد ملی), optionally written as xxx-xxxxxx-x
+		pattern:     regexp.MustCompile(`\b[0-9]{3}-?[0-9]{6}-?[0-9]\b`),
+		replacement: "[NATIONAL_ID]",
+		valid:       validNationalID,
+	},
+}
+
+// piiCategories lists the categories enabled by "all", in masking order.
+// IBANs and national IDs go before phone numbers, which could match their digits.
+var piiCategories = []string{"email", "iban", "national_id", "phone"}
+
+// validIBAN checks the ISO 13616 mod-97 checksum
+func validIBAN(s string) bool {
+	iban := strings.ReplaceAll(s, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	// Move the country code and check digits to the end and convert
+	// letters to numbers (A=10 ... Z=35)
+	var digits strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case unicode.IsDigit(r):
+			digits.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			digits.WriteString(big.NewInt(int64(r - 'A' + 10)).String())
+		default:
+			return false
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return false
+	}
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// validNationalID checks the check digit of an Iranian national code
+func validNationalID(s string) bool {
+	code := strings.ReplaceAll(s, "-", "")
+	if len(code) != 10 || strings.Count(code, code[:1]) == 10 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(code[i]-'0') * (10 - i)
+	}
+	check := int(code[9] - '0')
+	remainder := sum % 11
+	if remainder < 2 {
+		return check == remainder
+	}
+	return check == 11-remainder
+}
@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var deadLettered = metrics.NewCounter("queue_messages_dead_lettered_total",
+	"Queued messages moved to the dead-letter store after exhausting their attempts.")
+
+// DeadLetter is a job that exhausted its delivery attempts. It keeps the
+// original job, including the last error, so it can be sent again.
+type DeadLetter struct {
+	Job
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// DeadLetterDir is the subdirectory of the queue directory holding dead
+// letters
+const DeadLetterDir = "deadletter"
+
+// DeadLetters returns all dead-lettered jobs, oldest failure first
+func (q *Queue) DeadLetters() ([]*DeadLetter, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	records, err := q.dead.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %w", err)
+	}
+
+	letters := make([]*DeadLetter, 0, len(records))
+	for _, record := range records {
+		var dead DeadLetter
+		if err := json.Unmarshal(record.Value, &dead); err != nil {
+			q.logger.Error("Skipping corrupt dead letter", "id", record.Key, "error", err)
+			continue
+		}
+		letters = append(letters, &dead)
+	}
+
+	sort.SliceStable(letters, func(i, j int) bool {
+		return letters[i].FailedAt.Before(letters[j].FailedAt)
+	})
+
+	return letters, nil
+}
+
+// DeadLetter returns a dead-lettered job. The error satisfies
+// os.IsNotExist for unknown jobs.
+func (q *Queue) DeadLetter(id string) (*DeadLetter, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.readDeadLetter(id)
+}
+
+// Resend moves a dead-lettered job back to the end of the queue with its
+// attempts reset. The job keeps its ID, so its status stays traceable.
+func (q *Queue) Resend(id string) (*Job, error) {
+	dead, err := q.DeadLetter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	job := dead.Job
+	job.Attempts = 0
+	job.LastError = ""
+	job.CreatedAt = time.Now()
+
+	if err := q.Enqueue(&job); err != nil {
+		return nil, err
+	}
+	if err := q.Purge(id); err != nil {
+		q.logger.Error("Failed to remove resent dead letter", "id", id, "error", err)
+	}
+
+	q.logger.Info("Dead letter queued again", "id", id)
+	return &job, nil
+}
+
+// Purge deletes a dead-lettered job. The error satisfies os.IsNotExist for
+// unknown jobs.
+func (q *Queue) Purge(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.dead.Delete(id)
+}
+
+// PurgeAll deletes all dead-lettered jobs and returns how many there were
+func (q *Queue) PurgeAll() (int, error) {
+	letters, err := q.DeadLetters()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, dead := range letters {
+		if err := q.Purge(dead.ID); err != nil && !os.IsNotExist(err) {
+			return purged, fmt.Errorf("failed to purge dead letter %s: %w", dead.ID, err)
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// bury moves a job that exhausted its attempts from the queue to the
+// dead-letter store
+func (q *Queue) bury(job *Job) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	dead := &DeadLetter{Job: *job, FailedAt: time.Now()}
+	if err := put(q.dead, job.ID, dead); err != nil {
+		return err
+	}
+	if err := q.jobs.Delete(job.ID); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	deadLettered.Inc()
+	return nil
+}
+
+// readDeadLetter reads a dead-lettered job; the caller holds the mutex
+func (q *Queue) readDeadLetter(id string) (*DeadLetter, error) {
+	data, err := q.dead.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var dead DeadLetter
+	if err := json.Unmarshal(data, &dead); err != nil {
+		return nil, fmt.Errorf("failed to parse dead letter: %w", err)
+	}
+
+	return &dead, nil
+}
@@ -0,0 +1,449 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
+)
+
+// Job is a queued outgoing message
+type Job struct {
+	ID        string    `json:"id"`
+	Text      string    `json:"text"`
+	Priority  int       `json:"priority"`
+	DialogID  string    `json:"dialog_id,omitempty"`
+	Route     string    `json:"route,omitempty"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// FromUserID is the sender identity of the route, empty for the default
+	FromUserID string `json:"from_user_id,omitempty"`
+
+	// Account names the Mizito account delivering the job, empty for the
+	// default account
+	Account string `json:"account,omitempty"`
+
+	// RequestID is the ID of the request that queued the job, for tracing
+	// its delivery in the logs
+	RequestID string `json:"request_id,omitempty"`
+
+	// Template is the variant of the route's template that rendered the
+	// text, empty for routes without a candidate template
+	Template string `json:"template,omitempty"`
+
+	// Attachments are stored base64-encoded in the job
+	Attachments []render.Attachment `json:"attachments,omitempty"`
+}
+
+// Sender delivers a job; a returned error keeps the job queued for retry,
+// unless it is marked with Permanent
+type Sender func(ctx context.Context, job *Job) error
+
+// permanentError marks a delivery failure that another attempt would hit
+// again, such as a message refused by Mizito
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error of a Sender as not worth retrying: the job is
+// dead-lettered right away instead of holding up the jobs behind it
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// isPermanent reports whether err was marked with Permanent
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Job states reported by Status
+const (
+	StateQueued    = "queued"
+	StateDelivered = "delivered"
+	StateDead      = "dead"
+)
+
+// maxDeliveredJobs is how many delivered jobs Status remembers
+const maxDeliveredJobs = 1000
+
+// JobStatus is the delivery state of a job
+type JobStatus struct {
+	ID          string     `json:"id"`
+	State       string     `json:"state"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	FailedAt    *time.Time `json:"failed_at,omitempty"`
+}
+
+// Queue is a durable FIFO of outgoing messages.
+// Every job is stored as a JSON record of a collection, a directory of files
+// unless the storage backend is durable, so queued messages survive restarts.
+// A background worker delivers jobs in order; while delivery fails the
+// worker backs off exponentially, from minBackoff up to maxBackoff.
+// A job failing permanently, or with maxAttempts set failing that many
+// times, is moved to the dead-letter store so the jobs behind it are not
+// held up forever.
+type Queue struct {
+	jobs        storage.Collection
+	dead        storage.Collection
+	send        Sender
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+	logger      *logger.Logger
+
+	mutex sync.Mutex
+	wake  chan struct{}
+
+	// delivered remembers the most recently delivered jobs, in delivery
+	// order, since their records are removed
+	delivered      map[string]*JobStatus
+	deliveredOrder []string
+
+	// flush asks the worker for a final delivery attempt before it exits;
+	// done is closed when the worker has exited
+	flush chan context.Context
+	done  chan struct{}
+}
+
+// New creates a queue storing its jobs in jobs and those that exhausted
+// their attempts in dead. maxAttempts of 0 retries jobs forever.
+func New(jobs, dead storage.Collection, send Sender, minBackoff, maxBackoff time.Duration, maxAttempts int, logger *logger.Logger) *Queue {
+	return &Queue{
+		jobs:        jobs,
+		dead:        dead,
+		send:        send,
+		minBackoff:  minBackoff,
+		maxBackoff:  maxBackoff,
+		maxAttempts: maxAttempts,
+		logger:      logger,
+		wake:        make(chan struct{}, 1),
+		delivered:   make(map[string]*JobStatus),
+		flush:       make(chan context.Context),
+		done:        make(chan struct{}),
+	}
+}
+
+// Enqueue durably stores a job and wakes the worker
+func (q *Queue) Enqueue(job *Job) error {
+	if job.ID == "" {
+		job.ID = newID()
+	}
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now()
+	}
+
+	if err := q.write(job); err != nil {
+		return err
+	}
+
+	q.logger.Debug("Message queued", "id", job.ID)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Len returns the number of queued jobs
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	n, err := q.jobs.Len()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Status returns the state of a job: queued, dead if it exhausted its
+// attempts, or delivered if it was one of the last delivered jobs since the
+// start. ok is false for unknown jobs.
+func (q *Queue) Status(id string) (status *JobStatus, ok bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if status, ok := q.delivered[id]; ok {
+		return status, true
+	}
+
+	data, err := q.jobs.Get(id)
+	if err != nil {
+		if dead, err := q.readDeadLetter(id); err == nil {
+			return &JobStatus{
+				ID:        dead.ID,
+				State:     StateDead,
+				Attempts:  dead.Attempts,
+				LastError: dead.LastError,
+				CreatedAt: dead.CreatedAt,
+				FailedAt:  &dead.FailedAt,
+			}, true
+		}
+		return nil, false
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, false
+	}
+
+	return &JobStatus{
+		ID:        job.ID,
+		State:     StateQueued,
+		Attempts:  job.Attempts,
+		LastError: job.LastError,
+		CreatedAt: job.CreatedAt,
+	}, true
+}
+
+// markDelivered remembers a delivered job for Status
+func (q *Queue) markDelivered(job *Job) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	q.delivered[job.ID] = &JobStatus{
+		ID:          job.ID,
+		State:       StateDelivered,
+		Attempts:    job.Attempts,
+		CreatedAt:   job.CreatedAt,
+		DeliveredAt: &now,
+	}
+	q.deliveredOrder = append(q.deliveredOrder, job.ID)
+
+	if len(q.deliveredOrder) > maxDeliveredJobs {
+		delete(q.delivered, q.deliveredOrder[0])
+		q.deliveredOrder = q.deliveredOrder[1:]
+	}
+}
+
+// Run delivers queued jobs until ctx is cancelled or Stop is called
+func (q *Queue) Run(ctx context.Context) {
+	q.logger.Info("Outbound queue worker started", "pending", q.Len())
+	defer func() {
+		q.logger.Info("Outbound queue worker stopped", "pending", q.Len())
+		close(q.done)
+	}()
+
+	backoff := q.minBackoff
+	for {
+		delivered, err := q.drain(ctx)
+		if err != nil {
+			q.logger.Warn("Outbound queue delivery failed, backing off",
+				"delivered", delivered,
+				"retry_in", backoff,
+				"error", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case flushCtx := <-q.flush:
+				q.finalDrain(flushCtx)
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > q.maxBackoff {
+				backoff = q.maxBackoff
+			}
+			continue
+		}
+
+		backoff = q.minBackoff
+
+		// Wait for new jobs
+		select {
+		case <-ctx.Done():
+			return
+		case flushCtx := <-q.flush:
+			q.finalDrain(flushCtx)
+			return
+		case <-q.wake:
+		}
+	}
+}
+
+// Stop makes the worker deliver what is still queued, then exit. Jobs that
+// cannot be delivered before ctx expires stay queued for the next start.
+func (q *Queue) Stop(ctx context.Context) error {
+	select {
+	case q.flush <- ctx:
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finalDrain makes one last delivery attempt on shutdown
+func (q *Queue) finalDrain(ctx context.Context) {
+	delivered, err := q.drain(ctx)
+	if err != nil {
+		q.logger.Warn("Could not deliver all queued messages before shutdown",
+			"delivered", delivered,
+			"error", err)
+		return
+	}
+	if delivered > 0 {
+		q.logger.Info("Flushed queued messages before shutdown", "delivered", delivered)
+	}
+}
+
+// drain delivers queued jobs in order, stopping at the first failure.
+// A job that fails permanently or fails its last allowed attempt is
+// dead-lettered instead and delivery continues with the next one. Jobs whose delivery is cut off by
+// ctx are left as they were.
+func (q *Queue) drain(ctx context.Context) (int, error) {
+	jobs, err := q.list()
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return delivered, nil
+		}
+
+		job.Attempts++
+		if err := q.send(ctx, job); err != nil {
+			// Interrupted by the shutdown rather than failed: the attempt does
+			// not count, so the job stays queued for the next start
+			if ctx.Err() != nil {
+				q.logger.Info("Delivery of queued message interrupted, keeping it queued", "id", job.ID)
+				return delivered, nil
+			}
+
+			job.LastError = err.Error()
+			permanent := isPermanent(err)
+			if permanent || (q.maxAttempts > 0 && job.Attempts >= q.maxAttempts) {
+				if buryErr := q.bury(job); buryErr != nil {
+					q.logger.Error("Failed to dead-letter message", "id", job.ID, "error", buryErr)
+					return delivered, fmt.Errorf("job %s: %w", job.ID, err)
+				}
+				reason := "Queued message dead-lettered after exhausting its attempts"
+				if permanent {
+					reason = "Queued message dead-lettered after a permanent failure"
+				}
+				q.logger.Error(reason,
+					"id", job.ID,
+					"attempts", job.Attempts,
+					"error", err)
+				continue
+			}
+			if writeErr := q.write(job); writeErr != nil {
+				q.logger.Error("Failed to update queued message", "id", job.ID, "error", writeErr)
+			}
+			return delivered, fmt.Errorf("job %s: %w", job.ID, err)
+		}
+
+		q.markDelivered(job)
+		if err := q.remove(job.ID); err != nil {
+			q.logger.Error("Failed to remove delivered message from queue", "id", job.ID, "error", err)
+		}
+
+		q.logger.Info("Queued message delivered", "id", job.ID, "attempts", job.Attempts)
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// list reads all queued jobs, oldest first
+func (q *Queue) list() ([]*Job, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	records, err := q.jobs.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue: %w", err)
+	}
+
+	jobs := make([]*Job, 0, len(records))
+	for _, record := range records {
+		var job Job
+		if err := json.Unmarshal(record.Value, &job); err != nil {
+			q.logger.Error("Skipping corrupt queued message", "id", record.Key, "error", err)
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+
+	return jobs, nil
+}
+
+// write durably stores a job
+func (q *Queue) write(job *Job) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return put(q.jobs, job.ID, job)
+}
+
+// put stores v as JSON under key in c
+func put(c storage.Collection, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued message: %w", err)
+	}
+
+	if err := c.Put(key, data); err != nil {
+		return fmt.Errorf("failed to store queued message: %w", err)
+	}
+	return nil
+}
+
+// remove deletes a job from the queue
+func (q *Queue) remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if err := q.jobs.Delete(id); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// newID generates a unique, time-ordered job ID
+func newID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
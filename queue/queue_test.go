@@ -0,0 +1,284 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
+)
+
+// recorder is a Sender recording the jobs it is asked to deliver and
+// failing them as told by fail
+type recorder struct {
+	mutex    sync.Mutex
+	attempts []string
+	times    []time.Time
+	fail     func(job *Job) error
+}
+
+func (r *recorder) send(ctx context.Context, job *Job) error {
+	r.mutex.Lock()
+	r.attempts = append(r.attempts, job.ID)
+	r.times = append(r.times, time.Now())
+	fail := r.fail
+	r.mutex.Unlock()
+
+	if fail != nil {
+		return fail(job)
+	}
+	return nil
+}
+
+func (r *recorder) sent() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.attempts...)
+}
+
+// newTestQueue creates a queue in memory
+func newTestQueue(t *testing.T, send Sender, maxAttempts int) *Queue {
+	t.Helper()
+	log, err := logger.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewMemory()
+	return New(store.Collection("queue"), store.Collection("deadletter"), send,
+		10*time.Millisecond, time.Second, maxAttempts, log)
+}
+
+// enqueue queues jobs created a second apart, in the given order
+func enqueue(t *testing.T, q *Queue, ids ...string) {
+	t.Helper()
+	start := time.Now()
+	for i, id := range ids {
+		if err := q.Enqueue(&Job{ID: id, Text: id, CreatedAt: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDrainOrder(t *testing.T) {
+	r := &recorder{}
+	q := newTestQueue(t, r.send, 0)
+	// IDs sort differently from the order the jobs were queued in
+	enqueue(t, q, "c", "a", "b")
+
+	delivered, err := q.drain(context.Background())
+	if err != nil || delivered != 3 {
+		t.Fatalf("drain = %d, %v, want 3, nil", delivered, err)
+	}
+	if got := r.sent(); !equal(got, []string{"c", "a", "b"}) {
+		t.Errorf("delivered %v, want the order of queueing", got)
+	}
+	if q.Len() != 0 {
+		t.Errorf("%d jobs left queued", q.Len())
+	}
+	if status, ok := q.Status("a"); !ok || status.State != StateDelivered || status.Attempts != 1 {
+		t.Errorf("Status(a) = %+v, %v", status, ok)
+	}
+}
+
+func TestDrainStopsAtFailure(t *testing.T) {
+	r := &recorder{fail: func(job *Job) error {
+		if job.ID == "b" {
+			return errors.New("mizito unreachable")
+		}
+		return nil
+	}}
+	q := newTestQueue(t, r.send, 0)
+	enqueue(t, q, "a", "b", "c")
+
+	delivered, err := q.drain(context.Background())
+	if err == nil || delivered != 1 {
+		t.Fatalf("drain = %d, %v, want 1 and an error", delivered, err)
+	}
+	if got := r.sent(); !equal(got, []string{"a", "b"}) {
+		t.Errorf("attempted %v, want the jobs behind the failure held back", got)
+	}
+
+	status, ok := q.Status("b")
+	if !ok || status.State != StateQueued || status.Attempts != 1 || status.LastError != "mizito unreachable" {
+		t.Errorf("Status(b) = %+v, %v", status, ok)
+	}
+	if q.Len() != 2 {
+		t.Errorf("%d jobs queued, want 2", q.Len())
+	}
+}
+
+func TestDrainDeadLettersPermanentFailure(t *testing.T) {
+	r := &recorder{fail: func(job *Job) error {
+		if job.ID == "a" {
+			return Permanent(errors.New("dialog not found"))
+		}
+		return nil
+	}}
+	// Retried forever but for permanent failures
+	q := newTestQueue(t, r.send, 0)
+	enqueue(t, q, "a", "b", "c")
+
+	delivered, err := q.drain(context.Background())
+	if err != nil || delivered != 2 {
+		t.Fatalf("drain = %d, %v, want 2, nil", delivered, err)
+	}
+	if got := r.sent(); !equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("attempted %v", got)
+	}
+
+	status, ok := q.Status("a")
+	if !ok || status.State != StateDead || status.LastError != "dialog not found" {
+		t.Errorf("Status(a) = %+v, %v", status, ok)
+	}
+	dead, err := q.DeadLetters()
+	if err != nil || len(dead) != 1 || dead[0].ID != "a" {
+		t.Errorf("DeadLetters = %v, %v", dead, err)
+	}
+}
+
+func TestDrainDeadLettersAfterMaxAttempts(t *testing.T) {
+	r := &recorder{fail: func(job *Job) error {
+		if job.ID == "a" {
+			return errors.New("mizito unavailable")
+		}
+		return nil
+	}}
+	q := newTestQueue(t, r.send, 2)
+	enqueue(t, q, "a", "b")
+
+	if delivered, err := q.drain(context.Background()); err == nil || delivered != 0 {
+		t.Fatalf("first drain = %d, %v, want 0 and an error", delivered, err)
+	}
+	if delivered, err := q.drain(context.Background()); err != nil || delivered != 1 {
+		t.Fatalf("second drain = %d, %v, want 1, nil", delivered, err)
+	}
+
+	status, ok := q.Status("a")
+	if !ok || status.State != StateDead || status.Attempts != 2 {
+		t.Errorf("Status(a) = %+v, %v", status, ok)
+	}
+	if status, ok := q.Status("b"); !ok || status.State != StateDelivered {
+		t.Errorf("Status(b) = %+v, %v", status, ok)
+	}
+}
+
+func TestDrainInterruptedByShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &recorder{fail: func(job *Job) error {
+		cancel()
+		return context.Canceled
+	}}
+	q := newTestQueue(t, r.send, 1)
+	enqueue(t, q, "a", "b")
+
+	delivered, err := q.drain(ctx)
+	if err != nil || delivered != 0 {
+		t.Fatalf("drain = %d, %v, want 0, nil", delivered, err)
+	}
+	if got := r.sent(); !equal(got, []string{"a"}) {
+		t.Errorf("attempted %v, want delivery to stop at the shutdown", got)
+	}
+
+	// The cut-off attempt does not count, even against QUEUE_MAX_ATTEMPTS
+	status, ok := q.Status("a")
+	if !ok || status.State != StateQueued || status.Attempts != 0 {
+		t.Errorf("Status(a) = %+v, %v, want queued without attempts", status, ok)
+	}
+}
+
+func TestRunResetsBackoff(t *testing.T) {
+	var mutex sync.Mutex
+	failures := map[string]int{"a": 4, "b": 1}
+	r := &recorder{fail: func(job *Job) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if failures[job.ID] > 0 {
+			failures[job.ID]--
+			return errors.New("mizito unavailable")
+		}
+		return nil
+	}}
+	q := newTestQueue(t, r.send, 0)
+	enqueue(t, q, "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	waitFor(t, func() bool {
+		status, ok := q.Status("a")
+		return ok && status.State == StateDelivered
+	})
+
+	// After four failures the backoff had grown to 160ms; the delivery of
+	// a resets it, so the retry of b follows after 10ms
+	enqueue(t, q, "b")
+	waitFor(t, func() bool {
+		status, ok := q.Status("b")
+		return ok && status.State == StateDelivered
+	})
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := len(r.times)
+	if gap := r.times[n-1].Sub(r.times[n-2]); gap > 100*time.Millisecond {
+		t.Errorf("retry of b after %s, want the backoff reset to 10ms", gap)
+	}
+}
+
+func TestStopKeepsInterruptedJobs(t *testing.T) {
+	r := &recorder{}
+	r.fail = func(job *Job) error { return errors.New("mizito unavailable") }
+	q := newTestQueue(t, r.send, 0)
+	// The worker backs off until the shutdown
+	q.minBackoff = time.Hour
+	enqueue(t, q, "a")
+
+	go q.Run(context.Background())
+	waitFor(t, func() bool { return len(r.sent()) > 0 })
+
+	// The final attempt of the shutdown is cut off by its deadline
+	r.mutex.Lock()
+	r.fail = func(job *Job) error {
+		time.Sleep(50 * time.Millisecond)
+		return context.DeadlineExceeded
+	}
+	r.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	q.Stop(ctx)
+	<-q.done
+
+	status, ok := q.Status("a")
+	if !ok || status.State != StateQueued || status.Attempts != 1 {
+		t.Errorf("Status(a) = %+v, %v, want queued with the failed attempt only", status, ok)
+	}
+}
+
+// waitFor polls cond for up to five seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,217 @@
+// Package quiet buffers the low-priority notifications sent to dialogs in
+// their quiet hours, and builds the digests delivering them once the quiet
+// hours end. Buffered notifications are kept in a file so they survive
+// restarts.
+package quiet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// RouteName is the route digests are delivered on, so the options of
+// ROUTE_DIGEST_* apply to them
+const RouteName = "digest"
+
+var buffered = metrics.NewGauge("quiet_hours_buffered_notifications",
+	"Notifications buffered during quiet hours, waiting for their digest.")
+
+// Message is a notification buffered during quiet hours, rendered for its
+// dialog
+type Message struct {
+	ID         string    `json:"id"`
+	BufferedAt time.Time `json:"buffered_at"`
+
+	// Window names the quiet hours the notification was buffered in
+	Window string `json:"window"`
+
+	Route      string `json:"route"`
+	Account    string `json:"account"`
+	DialogID   string `json:"dialog_id"`
+	Text       string `json:"text"`
+	Priority   int    `json:"priority"`
+	FromUserID string `json:"from_user_id,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+
+	// Attachments are stored base64-encoded in the buffer file
+	Attachments []render.Attachment `json:"attachments,omitempty"`
+}
+
+// Digest is the buffered notifications of a dialog, delivered together
+type Digest struct {
+	Window   string
+	Account  string
+	DialogID string
+	Messages []*Message
+}
+
+// Priority returns the highest priority of the digest's notifications
+func (d *Digest) Priority() int {
+	priority := 0
+	for _, m := range d.Messages {
+		if m.Priority > priority {
+			priority = m.Priority
+		}
+	}
+	return priority
+}
+
+// Attachments returns the attachments of the digest's notifications
+func (d *Digest) Attachments() []render.Attachment {
+	var attachments []render.Attachment
+	for _, m := range d.Messages {
+		attachments = append(attachments, m.Attachments...)
+	}
+	return attachments
+}
+
+// Text renders the digest: a heading and a line per notification, with
+// the time it was buffered in loc
+func (d *Digest) Text(loc *time.Location) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🌅 %d notification(s) during quiet hours", len(d.Messages))
+	for _, m := range d.Messages {
+		text := strings.ReplaceAll(strings.TrimSpace(m.Text), "\n", "\n   ")
+		fmt.Fprintf(&b, "\n• %s %s", m.BufferedAt.In(loc).Format("15:04"), text)
+	}
+	return b.String()
+}
+
+// Buffer keeps the notifications buffered during quiet hours in a file
+type Buffer struct {
+	path string
+
+	mutex    sync.Mutex
+	messages []*Message
+}
+
+// NewBuffer creates a buffer kept in path, which may not exist yet
+func NewBuffer(path string) (*Buffer, error) {
+	b := &Buffer{path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read buffered notifications: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &b.messages); err != nil {
+		return nil, fmt.Errorf("failed to parse buffered notifications %s: %w", path, err)
+	}
+	buffered.Set(float64(len(b.messages)))
+	return b, nil
+}
+
+// Add buffers a notification until its digest; its time and, unless given,
+// its ID are set by the buffer
+func (b *Buffer) Add(m *Message) error {
+	if m.ID == "" {
+		m.ID = newID()
+	}
+	m.BufferedAt = time.Now()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.messages = append(b.messages, m)
+	if err := b.save(); err != nil {
+		b.messages = b.messages[:len(b.messages)-1]
+		return err
+	}
+	buffered.Set(float64(len(b.messages)))
+	return nil
+}
+
+// List returns the buffered notifications, the oldest first
+func (b *Buffer) List() []*Message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return append([]*Message(nil), b.messages...)
+}
+
+// Take takes the notifications of the dialogs due for their digest off the
+// buffer and returns a digest per account and dialog. They are removed
+// before they are delivered, so a digest is never delivered twice.
+func (b *Buffer) Take(due func(dialogID string) bool) ([]*Digest, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var kept []*Message
+	var digests []*Digest
+	index := make(map[string]*Digest)
+	for _, m := range b.messages {
+		if !due(m.DialogID) {
+			kept = append(kept, m)
+			continue
+		}
+
+		key := m.Account + "\x00" + m.DialogID
+		d, ok := index[key]
+		if !ok {
+			d = &Digest{Window: m.Window, Account: m.Account, DialogID: m.DialogID}
+			index[key] = d
+			digests = append(digests, d)
+		}
+		d.Messages = append(d.Messages, m)
+	}
+	if len(digests) == 0 {
+		return nil, nil
+	}
+
+	previous := b.messages
+	b.messages = kept
+	if err := b.save(); err != nil {
+		b.messages = previous
+		return nil, err
+	}
+	buffered.Set(float64(len(b.messages)))
+	return digests, nil
+}
+
+// save writes the buffer file, replacing it atomically. The caller holds
+// the mutex.
+func (b *Buffer) save() error {
+	messages := b.messages
+	if messages == nil {
+		messages = []*Message{}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].BufferedAt.Before(messages[j].BufferedAt) })
+
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(b.path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// newID returns a random ID for a buffered notification
+func newID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
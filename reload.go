@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/audit"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/capture"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/correlation"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/deadman"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/dedup"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/handler"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/lifecycle"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/moderation"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/queue"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/quiet"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/reporting"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/schedule"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/sink"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/slo"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/snooze"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/tracking"
+	"github.com/gorilla/mux"
+)
+
+var configReloads = metrics.NewCounter("config_reloads_total",
+	"Configuration reloads by result: success or error.", "result")
+
+// generation is what is built from one configuration: the HTTP handler with
+// its routes, templates and sinks, and the router of each listener. A reload
+// replaces the generation as a whole; requests in flight finish with the
+// generation they started with.
+type generation struct {
+	config  *config.Config
+	handler *handler.Handler
+
+	// routers holds the router of the main listener, followed by that of
+	// the admin listener when ADMIN_PORT is set
+	routers []*mux.Router
+
+	// requests counts the requests in flight, so the sinks of a replaced
+	// generation are stopped once they are done
+	requests sync.WaitGroup
+}
+
+// reloader serves requests with the current generation and replaces it when
+// the configuration is reloaded on SIGHUP or POST /api/v1/admin/reload. The
+// components passed to NewHandler are shared by all generations.
+type reloader struct {
+	accounts map[string]*mizito.Account
+	captures *capture.Store
+	queue    *queue.Queue
+	messages *tracking.Store
+	audit    *audit.Log
+	deadman  *deadman.Switch
+	slo      *slo.Tracker
+	logger   *logger.Logger
+
+	// applications keeps the applications, read again on reload
+	applications storage.Collection
+
+	// duplicates outlives the generations, so a reload does not reopen
+	// the dedup windows
+	duplicates *dedup.Store
+
+	// snoozes outlives the generations, so a reload does not wake
+	// snoozed alerts
+	snoozes *snooze.Store
+
+	// alerts outlives the generations, so a reload does not forget the
+	// firing alerts
+	alerts *correlation.Store
+
+	// escalations outlives the generations, so a reload does not drop the
+	// phone calls waiting for acknowledgement
+	escalations *sink.Escalations
+
+	// scheduler outlives the generations, so a reload does not drop the
+	// scheduled messages
+	scheduler *schedule.Scheduler
+
+	// moderation outlives the generations, so a reload does not drop the
+	// held messages
+	moderation *moderation.Store
+
+	// quiet outlives the generations, so a reload does not drop the
+	// notifications buffered during quiet hours
+	quiet *quiet.Buffer
+
+	// reloading serializes reloads
+	reloading sync.Mutex
+
+	mutex   sync.RWMutex
+	current *generation
+}
+
+// newReloader builds the first generation from the startup configuration
+func newReloader(cfg *config.Config, applications storage.Collection, accounts map[string]*mizito.Account, captures *capture.Store, queue *queue.Queue, messages *tracking.Store, duplicates *dedup.Store, snoozes *snooze.Store, alerts *correlation.Store, escalations *sink.Escalations, scheduler *schedule.Scheduler, moderationStore *moderation.Store, quietBuffer *quiet.Buffer, auditLog *audit.Log, deadmanSwitch *deadman.Switch, deliverySLO *slo.Tracker, log *logger.Logger) (*reloader, error) {
+	r := &reloader{
+		applications: applications,
+		accounts:     accounts,
+		captures:     captures,
+		queue:        queue,
+		messages:     messages,
+		duplicates:   duplicates,
+		snoozes:      snoozes,
+		alerts:       alerts,
+		escalations:  escalations,
+		scheduler:    scheduler,
+		moderation:   moderationStore,
+		quiet:        quietBuffer,
+		audit:        auditLog,
+		deadman:      deadmanSwitch,
+		slo:          deliverySLO,
+		logger:       log,
+	}
+
+	current, err := r.build(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.current = current
+	return r, nil
+}
+
+// build creates the handler and routers of a configuration
+func (r *reloader) build(cfg *config.Config) (*generation, error) {
+	h, err := handler.NewHandler(cfg, r.accounts, r.captures, r.queue, r.messages, r.duplicates, r.snoozes, r.alerts, r.escalations, r.scheduler, r.moderation, r.quiet, r.audit, r.deadman, r.slo, r.logger)
+	if err != nil {
+		return nil, err
+	}
+	h.SetReloader(r.Reload)
+	h.SetCurrent(r.currentHandler)
+
+	// Register routes, under the base path when running behind a reverse proxy
+	router := newRouter(r.logger)
+	h.RegisterRoutes(withBasePath(router, cfg.BasePath))
+	routers := []*mux.Router{router}
+
+	// Admin routes go to a separate internal-only listener when configured
+	if cfg.AdminPort != "" {
+		adminRouter := newRouter(r.logger)
+		adminRoutes := withBasePath(adminRouter, cfg.BasePath)
+		h.RegisterHealthRoutes(adminRoutes)
+		h.RegisterAdminRoutes(adminRoutes)
+		routers = append(routers, adminRouter)
+	} else {
+		h.RegisterAdminRoutes(withBasePath(router, cfg.BasePath))
+	}
+
+	return &generation{config: cfg, handler: h, routers: routers}, nil
+}
+
+// newRouter creates a router with the logging and error reporting middleware
+func newRouter(log *logger.Logger) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(loggingMiddleware(log))
+	router.Use(reporting.Middleware(log))
+	return router
+}
+
+// acquire returns the current generation, counting a request in flight
+// until the caller calls requests.Done
+func (r *reloader) acquire() *generation {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	r.current.requests.Add(1)
+	return r.current
+}
+
+// currentHandler returns the handler of the current generation, counting a
+// request in flight until the returned function is called
+func (r *reloader) currentHandler() (*handler.Handler, func()) {
+	current := r.acquire()
+	return current.handler, current.requests.Done
+}
+
+// listener returns the handler of a listener: 0 for the main listener and
+// 1 for the admin listener
+func (r *reloader) listener(index int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		current := r.acquire()
+		defer current.requests.Done()
+
+		current.routers[index].ServeHTTP(w, req)
+	})
+}
+
+// routers returns the routers of the current generation
+func (r *reloader) routers() []*mux.Router {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.current.routers
+}
+
+// Submit passes a notification to the handler of the current generation
+func (r *reloader) Submit(ctx context.Context, n *render.Notification) error {
+	current := r.acquire()
+	defer current.requests.Done()
+
+	return current.handler.Submit(ctx, n)
+}
+
+// SubmitResponse passes a notification to the handler of the current
+// generation, returning the response it got
+func (r *reloader) SubmitResponse(ctx context.Context, n *render.Notification) (int, *handler.NotificationResponse, error) {
+	current := r.acquire()
+	defer current.requests.Done()
+
+	return current.handler.SubmitResponse(ctx, n)
+}
+
+// Authorize checks the app token of a request with the app tokens of the
+// current generation
+func (r *reloader) Authorize(req *http.Request) (int, bool) {
+	current := r.acquire()
+	defer current.requests.Done()
+
+	return current.handler.Authorize(req)
+}
+
+// MessageStatus returns the delivery state of a notification, kept by the
+// stores shared by all generations
+func (r *reloader) MessageStatus(id string) (*tracking.Status, bool) {
+	current := r.acquire()
+	defer current.requests.Done()
+
+	return current.handler.MessageStatus(id)
+}
+
+// RunDigests delivers the digests of quiet hours that ended, checking every
+// minute with the quiet hours of the current generation
+func (r *reloader) RunDigests(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		current := r.acquire()
+		current.handler.FlushDigests(ctx)
+		current.requests.Done()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// FailOverJob hands a queued notification Mizito failed to take to the
+// failover sinks of the current generation
+func (r *reloader) FailOverJob(ctx context.Context, job *queue.Job) {
+	current := r.acquire()
+	defer current.requests.Done()
+
+	current.handler.FailOverJob(ctx, job)
+}
+
+// RememberSentJob records the ID Mizito gave the message of a delivered
+// queued notification, for noting snoozes in its thread
+func (r *reloader) RememberSentJob(job *queue.Job, messageID string) {
+	current := r.acquire()
+	defer current.requests.Done()
+
+	current.handler.RememberSentJob(job, messageID)
+}
+
+// Reload loads the configuration again and puts it into service. Routes,
+// templates, schemas, policies, sinks, app tokens, severities, log levels
+// and the dialogs and rate limits of the accounts follow the new
+// configuration; listeners, the queue and other startup components keep
+// theirs until a restart. A configuration that fails to load or build keeps
+// the current one in service.
+func (r *reloader) Reload() error {
+	r.reloading.Lock()
+	defer r.reloading.Unlock()
+
+	cfg, err := config.Load()
+	if err == nil {
+		err = cfg.LoadApplications(r.applications)
+	}
+	if err == nil {
+		err = r.swap(cfg)
+	}
+	if err != nil {
+		configReloads.Inc("error")
+		r.logger.Error("Failed to reload configuration, keeping the current one", "error", err)
+		return err
+	}
+
+	configReloads.Inc("success")
+	r.logger.Info("Configuration reloaded", "config_file", cfg.ConfigFile, "routes", len(cfg.Routes), "log_level", cfg.LogLevel)
+	return nil
+}
+
+// swap builds a generation from cfg and replaces the current one with it
+func (r *reloader) swap(cfg *config.Config) error {
+	r.mutex.RLock()
+	previous := r.current
+	r.mutex.RUnlock()
+
+	if changed := restartSettings(previous.config, cfg); len(changed) > 0 {
+		r.logger.Warn("Changed settings take effect after a restart", "settings", strings.Join(changed, ","))
+	}
+
+	// The listeners were set up for the startup configuration
+	cfg.AdminPort = previous.config.AdminPort
+
+	next, err := r.build(cfg)
+	if err != nil {
+		return err
+	}
+	if err := severity.Default.Reset(cfg.SeverityMap); err != nil {
+		r.stopSinks(next, cfg.ShutdownTimeout)
+		return err
+	}
+
+	r.mutex.Lock()
+	r.current = next
+	r.mutex.Unlock()
+
+	for name, account := range r.accounts {
+		if accountConfig, ok := cfg.Account(name); ok {
+			account.Messages.Reload(accountConfig)
+		}
+	}
+	r.logger.SetLevel(cfg.LogLevel)
+	r.logger.SetDebugSampleRate(cfg.LogDebugSampleRate)
+
+	// The sinks of the previous generation take the notifications of the
+	// requests still in flight before they are stopped
+	go func() {
+		previous.requests.Wait()
+		r.stopSinks(previous, cfg.ShutdownTimeout)
+	}()
+
+	return nil
+}
+
+// Stop delivers the notifications accepted with async send and stops the
+// sinks of the current generation, implementing lifecycle.Stopper
+func (r *reloader) Stop(ctx context.Context) error {
+	r.mutex.RLock()
+	current := r.current
+	r.mutex.RUnlock()
+
+	// Notifications accepted with async send reach the sinks as well
+	r.logger.Info("Delivering notifications accepted with async send")
+	firstErr := current.handler.StopAsync(ctx)
+	for _, s := range current.handler.Sinks() {
+		if stopper, ok := s.(lifecycle.Stopper); ok {
+			r.logger.Info("Stopping sink", "sink", s.Name())
+			if err := stopper.Stop(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// stopSinks delivers the notifications accepted with async send and stops
+// the sinks of a generation that is no longer in service
+func (r *reloader) stopSinks(g *generation, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := g.handler.StopAsync(ctx); err != nil {
+		r.logger.Warn("Notifications accepted with async send by the previous configuration were not all delivered", "error", err)
+	}
+	for _, s := range g.handler.Sinks() {
+		if stopper, ok := s.(lifecycle.Stopper); ok {
+			if err := stopper.Stop(ctx); err != nil {
+				r.logger.Warn("Sink of the previous configuration did not stop cleanly", "sink", s.Name(), "error", err)
+			}
+		}
+	}
+}
+
+// restartSettings returns the settings that changed between two
+// configurations but are only read at startup
+func restartSettings(running, cfg *config.Config) []string {
+	var changed []string
+	check := func(name string, differs bool) {
+		if differs {
+			changed = append(changed, name)
+		}
+	}
+
+	check("SERVER_PORT", cfg.ServerPort != running.ServerPort)
+	check("ADMIN_PORT", cfg.AdminPort != running.AdminPort)
+	check("SERVER_TLS_CERT", cfg.ServerTLSCert != running.ServerTLSCert)
+	check("SERVER_TLS_KEY", cfg.ServerTLSKey != running.ServerTLSKey)
+	check("MIZITO_BASE_URL", cfg.MizitoBaseURL != running.MizitoBaseURL)
+	check("MIZITO_USERNAME", cfg.MizitoUsername != running.MizitoUsername)
+	check("MIZITO_PASSWORD", cfg.MizitoPassword != running.MizitoPassword)
+	check("MIZITO_PROXY_URL", cfg.MizitoProxyURL != running.MizitoProxyURL)
+	check("JWT_TOKEN_FILE", cfg.JWTTokenFile != running.JWTTokenFile)
+	check("QUEUE_ENABLED", cfg.QueueEnabled != running.QueueEnabled)
+	check("QUEUE_DIR", cfg.QueueDir != running.QueueDir)
+	check("AUDIT_LOG_FILE", cfg.AuditLogFile != running.AuditLogFile)
+	check("CAPTURE_DIR", cfg.CaptureDir != running.CaptureDir)
+	check("CAPTURE_MAX_RECORDS", cfg.CaptureMaxRecords != running.CaptureMaxRecords)
+	check("SCHEDULE_FILE", cfg.ScheduleFile != running.ScheduleFile)
+	check("MODERATION_FILE", cfg.ModerationFile != running.ModerationFile)
+	check("MODERATION_TIMEOUT", cfg.ModerationTimeout != running.ModerationTimeout)
+	check("QUIET_FILE", cfg.QuietFile != running.QuietFile)
+	check("SMTP_ENABLED", cfg.SMTPEnabled != running.SMTPEnabled)
+	check("SMTP_PORT", cfg.SMTPPort != running.SMTPPort)
+	check("SMTP_DOMAIN", cfg.SMTPDomain != running.SMTPDomain)
+	check("SMTP_USERNAME", cfg.SMTPUsername != running.SMTPUsername)
+	check("SMTP_PASSWORD", cfg.SMTPPassword != running.SMTPPassword)
+	check("SMTP_PRIORITY", cfg.SMTPPriority != running.SMTPPriority)
+	check("SYSLOG_ENABLED", cfg.SyslogEnabled != running.SyslogEnabled)
+	check("SYSLOG_PORT", cfg.SyslogPort != running.SyslogPort)
+	check("SYSLOG_PROTOCOLS", strings.Join(cfg.SyslogProtocols, ",") != strings.Join(running.SyslogProtocols, ","))
+	check("SYSLOG_SEVERITY", cfg.SyslogSeverity != running.SyslogSeverity)
+	check("SYSLOG_FACILITIES", strings.Join(cfg.SyslogFacilities, ",") != strings.Join(running.SyslogFacilities, ","))
+	check("SYSLOG_MAX_PER_MINUTE", cfg.SyslogMaxPerMinute != running.SyslogMaxPerMinute)
+	check("GRPC_ENABLED", cfg.GRPCEnabled != running.GRPCEnabled)
+	check("GRPC_PORT", cfg.GRPCPort != running.GRPCPort)
+	check("STORAGE_BACKEND", cfg.StorageBackend != running.StorageBackend)
+	check("STORAGE_URL", cfg.StorageURL != running.StorageURL)
+	check("APPLICATIONS_FILE", cfg.ApplicationsFile != running.ApplicationsFile)
+	check("SENTRY_DSN", cfg.SentryDSN != running.SentryDSN)
+	check("accounts", strings.Join(cfg.AccountNames(), ",") != strings.Join(running.AccountNames(), ","))
+	for name, account := range cfg.Accounts {
+		if current, ok := running.Accounts[name]; ok {
+			check("accounts."+name+".base_url", account.MizitoBaseURL != current.MizitoBaseURL)
+		}
+	}
+
+	return changed
+}
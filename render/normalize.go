@@ -0,0 +1,111 @@
+package render
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Unicode directional formatting characters
+const (
+	leftToRightIsolate    = '\u2066'
+	popDirectionalIsolate = '\u2069'
+)
+
+// Normalize prepares text for display in Mizito: it applies Unicode NFC,
+// unifies line endings, strips control and bidi override characters and,
+// when bidi is set, isolates left-to-right runs (English identifiers, URLs,
+// hostnames) inside right-to-left lines so mixed Persian/English text is not
+// rendered scrambled.
+func Normalize(text string, bidi bool) string {
+	text = norm.NFC.String(text)
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.Map(stripControl, text)
+
+	if !bidi {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = isolateLTR(line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// stripControl drops control characters except newlines and tabs, and
+// removes embedding/override characters that can reorder the whole message
+func stripControl(r rune) rune {
+	switch {
+	case r == '\n' || r == '\t':
+		return r
+	case r == '\r':
+		return '\n'
+	case unicode.IsControl(r):
+		return -1
+	case r >= '\u202a' && r <= '\u202e':
+		// LRE, RLE, PDF, LRO, RLO
+		return -1
+	default:
+		return r
+	}
+}
+
+// isolateLTR wraps every left-to-right run of a line that also contains
+// right-to-left text in LRI ... PDI, leaving pure LTR or RTL lines untouched
+func isolateLTR(line string) string {
+	runes := []rune(line)
+
+	hasRTL, hasLTR := false, false
+	for _, r := range runes {
+		switch {
+		case isRTL(r):
+			hasRTL = true
+		case isLTR(r):
+			hasLTR = true
+		}
+	}
+	if !hasRTL || !hasLTR {
+		return line
+	}
+
+	var b strings.Builder
+	b.Grow(len(line) + 16)
+
+	for i := 0; i < len(runes); {
+		if !isLTR(runes[i]) {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+
+		// Extend the run up to the next RTL character, then give trailing
+		// neutrals (spaces, punctuation) back to the surrounding RTL text
+		end := i
+		for j := i; j < len(runes) && !isRTL(runes[j]); j++ {
+			if isLTR(runes[j]) || unicode.IsDigit(runes[j]) {
+				end = j
+			}
+		}
+
+		b.WriteRune(leftToRightIsolate)
+		b.WriteString(string(runes[i : end+1]))
+		b.WriteRune(popDirectionalIsolate)
+		i = end + 1
+	}
+
+	return b.String()
+}
+
+// isRTL reports whether r is a strong right-to-left character
+func isRTL(r rune) bool {
+	return unicode.In(r, unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko) &&
+		unicode.IsLetter(r)
+}
+
+// isLTR reports whether r is a strong left-to-right character
+func isLTR(r rune) bool {
+	return unicode.IsLetter(r) && !isRTL(r)
+}
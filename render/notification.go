@@ -0,0 +1,85 @@
+package render
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/persian"
+)
+
+// Notification is an inbound notification on its way to Mizito.
+// It is also the data passed to message templates.
+type Notification struct {
+	Route    string
+	Title    string
+	Message  string
+	Priority int
+	Time     time.Time
+
+	// Source names the kind of sender, e.g. "gotify" or "alertmanager"
+	Source string
+
+	// Extras holds additional sender-specific fields, such as Gotify extras
+	Extras map[string]interface{}
+
+	// DialogID is the dialog requested by the sender; empty routes the
+	// notification by priority
+	DialogID string
+
+	// Attachments are files sent along, such as screenshots
+	Attachments []Attachment
+
+	// Fingerprints are the firing alerts of a notification of an alerting
+	// system, as tracked in the correlation store
+	Fingerprints []string
+
+	// Language is the LANGUAGE of the profile of the dialog the text is
+	// rendered for, in which Number writes numbers; empty for none
+	Language string
+}
+
+// Attachment is a file forwarded with a notification
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+// Severity returns a coarse severity derived from the Gotify priority scale:
+// 0-3 low, 4-7 normal, 8 and above high
+func (n *Notification) Severity() string {
+	switch {
+	case n.Priority >= 8:
+		return "high"
+	case n.Priority >= 4:
+		return "normal"
+	default:
+		return "low"
+	}
+}
+
+// Number formats a value rendered into the text in the language of the
+// dialog: with Persian digits for "fa", as is otherwise. Only the values
+// passed to it are converted, so URLs, addresses and IDs elsewhere in the
+// text keep their digits.
+func (n *Notification) Number(v interface{}) string {
+	if n.Language == "fa" {
+		return persian.Digits(fmt.Sprint(v))
+	}
+	return fmt.Sprint(v)
+}
+
+// Text combines title and message as "Title: Message"
+func (n *Notification) Text() string {
+	text := ""
+	if n.Title != "" {
+		text += n.Title
+		if n.Message != "" {
+			text += ": "
+		}
+	}
+	if n.Message != "" {
+		text += n.Message
+	}
+	return text
+}
@@ -0,0 +1,80 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/persian"
+)
+
+// ellipsis marks text shortened by Truncate
+const ellipsis = "…"
+
+// Parse compiles a message template with the helper functions available to
+// all templates: upper, lower, truncate, formatTime and the Persian number
+// helpers, and the given extra functions
+func Parse(name, text string, extra ...template.FuncMap) (*template.Template, error) {
+	funcs := template.FuncMap{
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"truncate":   func(n int, s string) string { return Truncate(s, n) },
+		"formatTime": func(layout string, t time.Time) string { return t.Format(layout) },
+	}
+	for name, fn := range persian.FuncMap() {
+		funcs[name] = fn
+	}
+	for _, more := range extra {
+		for name, fn := range more {
+			funcs[name] = fn
+		}
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// Execute renders a template with the given data
+func Execute(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render template %s: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// Summary renders the summary template as a single line of at most maxLen
+// characters. Mizito derives chat previews and push notifications from the
+// start of a message, so this line is placed above the full body.
+func Summary(tmpl *template.Template, n *Notification, maxLen int) (string, error) {
+	line, err := Execute(tmpl, n)
+	if err != nil {
+		return "", err
+	}
+
+	// Collapse newlines and repeated whitespace into single spaces
+	line = strings.Join(strings.Fields(line), " ")
+
+	return Truncate(line, maxLen), nil
+}
+
+// Truncate shortens s to at most n characters, marking the cut with an
+// ellipsis. A non-positive n disables truncation.
+func Truncate(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	runes := []rune(s)
+	if n <= 1 {
+		return string(runes[:n])
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + ellipsis
+}
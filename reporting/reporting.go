@@ -0,0 +1,112 @@
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	sentryhttp "github.com/getsentry/sentry-go/http"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// flushTimeout bounds how long shutdown waits for pending events
+const flushTimeout = 2 * time.Second
+
+// redacted replaces credentials in reported requests
+const redacted = "[REDACTED]"
+
+// Init enables Sentry error reporting when SENTRY_DSN is configured.
+// Until then the capture functions in this package do nothing.
+func Init(cfg *config.Config, log *logger.Logger) error {
+	if cfg.SentryDSN == "" {
+		return nil
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         cfg.SentryDSN,
+		Environment: cfg.SentryEnvironment,
+		Release:     cfg.SentryRelease,
+		ServerName:  "mizito-forwarder",
+		BeforeSend:  scrubEvent,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize Sentry: %w", err)
+	}
+
+	log.Info("Sentry error reporting enabled", "environment", cfg.SentryEnvironment)
+	return nil
+}
+
+// Flush waits for pending events to be sent
+func Flush() {
+	sentry.Flush(flushTimeout)
+}
+
+// Middleware recovers panics in handlers, reports them with the request
+// attached and responds with 500 Internal Server Error. It also binds a hub
+// to each request so errors captured later carry the request context.
+func Middleware(log *logger.Logger) func(http.Handler) http.Handler {
+	sentryHandler := sentryhttp.New(sentryhttp.Options{Repanic: true})
+
+	return func(next http.Handler) http.Handler {
+		reported := sentryHandler.Handle(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.WithContext(r.Context()).Error("Panic while handling request",
+						"method", r.Method,
+						"path", r.URL.Path,
+						"panic", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+
+			reported.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CaptureError reports an error, attaching the request of ctx when there is
+// one and the given tags
+func CaptureError(ctx context.Context, err error, tags map[string]string) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		hub = sentry.CurrentHub()
+	}
+
+	hub.WithScope(func(scope *sentry.Scope) {
+		for key, value := range tags {
+			scope.SetTag(key, value)
+		}
+		if id := logger.RequestID(ctx); id != "" {
+			scope.SetTag("request_id", id)
+		}
+		hub.CaptureException(err)
+	})
+}
+
+// scrubEvent removes app tokens from reported requests
+func scrubEvent(event *sentry.Event, hint *sentry.EventHint) *sentry.Event {
+	if event.Request == nil {
+		return event
+	}
+
+	for name := range event.Request.Headers {
+		if http.CanonicalHeaderKey(name) == "X-Gotify-Key" {
+			event.Request.Headers[name] = redacted
+		}
+	}
+
+	if query, err := url.ParseQuery(event.Request.QueryString); err == nil && query.Has("token") {
+		query.Set("token", redacted)
+		event.Request.QueryString = query.Encode()
+	}
+
+	return event
+}
@@ -0,0 +1,45 @@
+package rules
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket letting through up to count notifications per
+// period, refilled evenly over the period
+type limiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter allows count notifications per period
+func newLimiter(count int, period time.Duration) *limiter {
+	return &limiter{
+		rate:   float64(count) / period.Seconds(),
+		burst:  float64(count),
+		tokens: float64(count),
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token if one is available
+func (l *limiter) allow() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,340 @@
+// Package rules decides what becomes of inbound notifications: rules kept in
+// a file match notifications by route, title, priority and fields of their
+// JSON payload, and drop them, rate limit them, or pick their dialog and
+// template.
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule actions
+const (
+	ActionAllow = "allow"
+	ActionDrop  = "drop"
+)
+
+// Rule matches notifications and decides what becomes of them
+type Rule struct {
+	Name  string `yaml:"name"`
+	Match Match  `yaml:"match"`
+
+	// Action is ActionAllow (default) or ActionDrop
+	Action string `yaml:"action"`
+
+	// Dialog sends the matched notifications to a dialog instead of the
+	// dialog of their route or priority
+	Dialog string `yaml:"dialog"`
+
+	// Template renders the message text of the matched notifications
+	// instead of the templates of their route and dialog
+	Template string `yaml:"template"`
+
+	// RateLimit lets through at most a number of matched notifications per
+	// period, e.g. "10/m", and drops the others
+	RateLimit string `yaml:"rate_limit"`
+
+	// Continue evaluates the following rules after this one matched
+	Continue bool `yaml:"continue"`
+
+	title   *regexp.Regexp
+	fields  map[string]*regexp.Regexp
+	limiter *limiter
+}
+
+// Match holds the conditions of a rule; a rule matches notifications
+// meeting all of them
+type Match struct {
+	// Routes lists the routes the notifications arrived on, e.g. grafana
+	Routes []string `yaml:"routes"`
+
+	// Title is a regular expression the title must match
+	Title string `yaml:"title"`
+
+	// MinPriority and MaxPriority bound the priority
+	MinPriority *int `yaml:"min_priority"`
+	MaxPriority *int `yaml:"max_priority"`
+
+	// Fields maps dotted paths into the JSON payload, such as
+	// commonLabels.team or alerts.0.status, to regular expressions their
+	// values must match
+	Fields map[string]string `yaml:"fields"`
+}
+
+// Decision is the outcome of evaluating the rules for a notification
+type Decision struct {
+	// Rules names the rules that matched, in order
+	Rules []string
+
+	// Drop is set when the notification is dropped by the rule DroppedBy,
+	// RateLimited when by its rate limit
+	Drop        bool
+	DroppedBy   string
+	RateLimited bool
+
+	// Dialog and Template are set by the last matched rule setting them;
+	// TemplateRule names that rule
+	Dialog       string
+	Template     string
+	TemplateRule string
+}
+
+// Engine evaluates rules in the order of the rules file
+type Engine struct {
+	rules []*Rule
+}
+
+// Load reads and compiles a YAML or JSON rules file
+func Load(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var file struct {
+		Rules []*Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", path, err)
+	}
+
+	names := make(map[string]bool, len(file.Rules))
+	for i, rule := range file.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("%s: rule %d has no name", path, i+1)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%s: duplicate rule %s", path, rule.Name)
+		}
+		names[rule.Name] = true
+
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %w", path, rule.Name, err)
+		}
+	}
+
+	return &Engine{rules: file.Rules}, nil
+}
+
+// compile checks the rule and compiles its expressions and rate limit
+func (r *Rule) compile() error {
+	r.Action = strings.ToLower(r.Action)
+	switch r.Action {
+	case "":
+		r.Action = ActionAllow
+	case ActionAllow, ActionDrop:
+	default:
+		return fmt.Errorf("action must be allow or drop, got %q", r.Action)
+	}
+
+	for i, route := range r.Match.Routes {
+		r.Match.Routes[i] = strings.ToLower(route)
+	}
+
+	if min, max := r.Match.MinPriority, r.Match.MaxPriority; min != nil && max != nil && *min > *max {
+		return fmt.Errorf("min_priority %d exceeds max_priority %d", *min, *max)
+	}
+
+	if r.Match.Title != "" {
+		title, err := regexp.Compile(r.Match.Title)
+		if err != nil {
+			return fmt.Errorf("invalid title pattern: %w", err)
+		}
+		r.title = title
+	}
+
+	r.fields = make(map[string]*regexp.Regexp, len(r.Match.Fields))
+	for path, pattern := range r.Match.Fields {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern of field %s: %w", path, err)
+		}
+		r.fields[path] = re
+	}
+
+	if r.RateLimit != "" {
+		limit, err := parseRateLimit(r.RateLimit)
+		if err != nil {
+			return fmt.Errorf("invalid rate_limit: %w", err)
+		}
+		r.limiter = limit
+	}
+	return nil
+}
+
+// parseRateLimit parses "<count>/<period>", the period being s, m, h or a
+// duration such as 10m
+func parseRateLimit(value string) (*limiter, error) {
+	count, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return nil, fmt.Errorf("expected <count>/<period>, got %q", value)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("count must be a positive number, got %q", count)
+	}
+
+	period = strings.TrimSpace(period)
+	switch period {
+	case "s", "m", "h":
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("period must be s, m, h or a positive duration, got %q", period)
+	}
+
+	return newLimiter(n, d), nil
+}
+
+// Rules returns the number of rules
+func (e *Engine) Rules() int {
+	return len(e.rules)
+}
+
+// NeedsPayload reports whether any rule matches fields of the payload, which
+// must then be passed to Evaluate
+func (e *Engine) NeedsPayload() bool {
+	for _, rule := range e.rules {
+		if len(rule.fields) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate applies the rules to a notification with its raw payload, which
+// may be nil. Evaluation stops at the first matching rule unless it has
+// continue set, and always at a rule dropping the notification.
+func (e *Engine) Evaluate(n *render.Notification, payload []byte) Decision {
+	var d Decision
+	var document interface{}
+	decoded := false
+
+	for _, rule := range e.rules {
+		if len(rule.fields) > 0 && !decoded {
+			// Payloads that are not JSON, such as plain text, match no field
+			if json.Unmarshal(payload, &document) != nil {
+				document = nil
+			}
+			decoded = true
+		}
+		if !rule.matches(n, document) {
+			continue
+		}
+		d.Rules = append(d.Rules, rule.Name)
+
+		if rule.Action == ActionDrop {
+			d.Drop, d.DroppedBy = true, rule.Name
+			return d
+		}
+		if rule.limiter != nil && !rule.limiter.allow() {
+			d.Drop, d.DroppedBy, d.RateLimited = true, rule.Name, true
+			return d
+		}
+
+		if rule.Dialog != "" {
+			d.Dialog = rule.Dialog
+		}
+		if rule.Template != "" {
+			d.Template, d.TemplateRule = rule.Template, rule.Name
+		}
+		if !rule.Continue {
+			break
+		}
+	}
+	return d
+}
+
+// Templates returns the templates of the rules by rule name
+func (e *Engine) Templates() map[string]string {
+	templates := make(map[string]string)
+	for _, rule := range e.rules {
+		if rule.Template != "" {
+			templates[rule.Name] = rule.Template
+		}
+	}
+	return templates
+}
+
+// matches reports whether a notification meets all conditions of the rule
+func (r *Rule) matches(n *render.Notification, document interface{}) bool {
+	if len(r.Match.Routes) > 0 && !contains(r.Match.Routes, n.Route) {
+		return false
+	}
+	if r.title != nil && !r.title.MatchString(n.Title) {
+		return false
+	}
+	if r.Match.MinPriority != nil && n.Priority < *r.Match.MinPriority {
+		return false
+	}
+	if r.Match.MaxPriority != nil && n.Priority > *r.Match.MaxPriority {
+		return false
+	}
+	for path, re := range r.fields {
+		value, ok := lookup(document, path)
+		if !ok || !re.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the value at a dotted path of a JSON document as text;
+// numeric segments index arrays
+func lookup(document interface{}, path string) (string, bool) {
+	value := document
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			child, ok := v[key]
+			if !ok {
+				return "", false
+			}
+			value = child
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			value = v[i]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		// Objects and arrays match as JSON
+		data, _ := json.Marshal(v)
+		return string(data), true
+	}
+}
+
+// contains reports whether list holds value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
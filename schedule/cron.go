@@ -0,0 +1,184 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the shorthands of common schedules
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronWeekdays maps abbreviated weekday names to cron day-of-week numbers
+var cronWeekdays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// cronMonths maps abbreviated month names to cron month numbers
+var cronMonths = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// Cron is a parsed five-field cron expression: minute, hour, day of month,
+// month and day of week. Each field holds the matching values as bits.
+type Cron struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// domAny and dowAny are set for the fields given as *; when both day
+	// fields are restricted, a day matching either of them matches
+	domAny bool
+	dowAny bool
+}
+
+// ParseCron parses a cron expression such as "30 9 * * sat-wed", with lists,
+// ranges and steps, weekday and month names, and the shorthands @hourly,
+// @daily, @weekly, @monthly and @yearly. Day of week 7 is Sunday, like 0.
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+
+	var c Cron
+	var err error
+	if c.minute, _, err = parseCronField(fields[0], 0, 59, 0, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, _, err = parseCronField(fields[1], 0, 23, 0, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, c.domAny, err = parseCronField(fields[2], 1, 31, 0, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, _, err = parseCronField(fields[3], 1, 12, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, c.dowAny, err = parseCronField(fields[4], 0, 7, 7, cronWeekdays); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+
+	// Sunday is both 0 and 7
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return &c, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+// within min and max; any is set for *. Ranges ending before they start
+// wrap around after wrap values, or are invalid when wrap is 0.
+func parseCronField(field string, min, max, wrap int, names map[string]int) (bits uint64, any bool, err error) {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, false, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		var first, last int
+		switch {
+		case rangePart == "*":
+			first, last = min, max
+			any = any || !hasStep
+		case strings.Contains(rangePart, "-"):
+			from, to, _ := strings.Cut(rangePart, "-")
+			if first, err = parseCronValue(from, min, max, names); err != nil {
+				return 0, false, err
+			}
+			if last, err = parseCronValue(to, min, max, names); err != nil {
+				return 0, false, err
+			}
+			if first > last {
+				if wrap == 0 {
+					return 0, false, fmt.Errorf("range %q ends before it starts", rangePart)
+				}
+				// Ranges of weekdays and months wrap around, like sat-wed
+				last += wrap
+			}
+		default:
+			if first, err = parseCronValue(rangePart, min, max, names); err != nil {
+				return 0, false, err
+			}
+			last = first
+			if hasStep {
+				last = max
+			}
+		}
+
+		for v := first; v <= last; v += step {
+			value := v
+			if value > max {
+				value -= wrap
+			}
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, any, nil
+}
+
+// parseCronValue parses a number or name within min and max
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("invalid value %q, expected %d-%d", value, min, max)
+	}
+	return n, nil
+}
+
+// Next returns the first minute after t matching the expression, in the
+// location of t, or the zero time if none does within five years
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the day fields
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
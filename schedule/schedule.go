@@ -0,0 +1,421 @@
+// Package schedule holds notifications for later delivery: notifications
+// delayed by their sender, delivered once when due, and recurring messages
+// delivered on a cron schedule, such as a daily standup reminder. Both are
+// kept in a file so they survive restarts.
+package schedule
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// RouteName is the route of recurring messages that do not name one, e.g.
+// for ROUTE_SCHEDULE_TEMPLATE
+const RouteName = "schedule"
+
+// Kinds of scheduled messages, as reported in metrics
+const (
+	KindDelayed   = "delayed"
+	KindRecurring = "recurring"
+)
+
+// maxWait bounds how long the scheduler sleeps, so a changed system clock
+// delays no delivery for long
+const maxWait = time.Minute
+
+var (
+	scheduledMessages = metrics.NewGauge("scheduled_messages",
+		"Messages held for later delivery, by kind: delayed or recurring.", "kind")
+	scheduledDeliveries = metrics.NewCounter("scheduled_deliveries_total",
+		"Deliveries of scheduled messages by kind and result: success, failure or skipped on a holiday.", "kind", "result")
+)
+
+// Submitter delivers a due notification through the account named, or the
+// account of its route when empty, like handler.Handler.Submit
+type Submitter func(ctx context.Context, n *render.Notification, account string) error
+
+// Entry is a scheduled message: a delayed notification delivered once at
+// Next, or a recurring message delivered at every time matching Cron
+type Entry struct {
+	ID string `json:"id"`
+
+	// Cron is the schedule of a recurring message, empty for a delayed
+	// notification
+	Cron string `json:"cron,omitempty"`
+
+	// SkipHolidays skips the runs of a recurring message falling on a
+	// holiday
+	SkipHolidays bool `json:"skip_holidays,omitempty"`
+
+	// Next is when the message is delivered next
+	Next      time.Time `json:"next"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Route    string                 `json:"route"`
+	Title    string                 `json:"title,omitempty"`
+	Message  string                 `json:"message,omitempty"`
+	Priority int                    `json:"priority"`
+	DialogID string                 `json:"dialog_id,omitempty"`
+	Source   string                 `json:"source,omitempty"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+
+	// Account names the Mizito account delivering the message, empty for
+	// the account of the route
+	Account string `json:"account,omitempty"`
+
+	// RequestID is the ID of the request that scheduled the message, for
+	// tracing its delivery in the logs
+	RequestID string `json:"request_id,omitempty"`
+
+	// Attachments are stored base64-encoded in the schedule file
+	Attachments []render.Attachment `json:"attachments,omitempty"`
+
+	// cron is the parsed schedule of a recurring message
+	cron *Cron
+}
+
+// Kind returns KindRecurring or KindDelayed
+func (e *Entry) Kind() string {
+	if e.Cron != "" {
+		return KindRecurring
+	}
+	return KindDelayed
+}
+
+// notification builds the notification delivered at now
+func (e *Entry) notification(now time.Time) *render.Notification {
+	return &render.Notification{
+		Route:       e.Route,
+		Title:       e.Title,
+		Message:     e.Message,
+		Priority:    e.Priority,
+		Time:        now,
+		Source:      e.Source,
+		Extras:      e.Extras,
+		DialogID:    e.DialogID,
+		Attachments: e.Attachments,
+	}
+}
+
+// Scheduler keeps the scheduled messages in ScheduleFile and submits them
+// when due. Recurring messages follow TIMEZONE and skip the holidays of the
+// calendar when asked to. A message is removed from the file, or moved to
+// its next run, before it is submitted, so a restart never delivers it
+// twice; a failed delivery is not retried, unless the queue takes it.
+type Scheduler struct {
+	config *config.Config
+	submit Submitter
+	logger *logger.Logger
+
+	mutex   sync.Mutex
+	entries map[string]*Entry
+	wake    chan struct{}
+}
+
+// New creates a scheduler with the messages kept in ScheduleFile, which
+// may not exist yet
+func New(config *config.Config, submit Submitter, logger *logger.Logger) (*Scheduler, error) {
+	s := &Scheduler{
+		config:  config,
+		submit:  submit,
+		logger:  logger,
+		entries: make(map[string]*Entry),
+		wake:    make(chan struct{}, 1),
+	}
+
+	data, err := os.ReadFile(config.ScheduleFile)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule: %w", err)
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule %s: %w", config.ScheduleFile, err)
+	}
+	for _, e := range entries {
+		if e.Cron != "" {
+			if e.cron, err = ParseCron(e.Cron); err != nil {
+				return nil, fmt.Errorf("schedule %s: %w", e.ID, err)
+			}
+		}
+		s.entries[e.ID] = e
+	}
+	s.updateMetrics()
+	return s, nil
+}
+
+// Delay holds a notification until at. account names the account
+// delivering it, empty for the account of its route.
+func (s *Scheduler) Delay(ctx context.Context, n *render.Notification, account string, at time.Time) (*Entry, error) {
+	return s.add(&Entry{
+		Next:        at,
+		Route:       n.Route,
+		Title:       n.Title,
+		Message:     n.Message,
+		Priority:    n.Priority,
+		DialogID:    n.DialogID,
+		Source:      n.Source,
+		Extras:      n.Extras,
+		Account:     account,
+		RequestID:   logger.RequestID(ctx),
+		Attachments: n.Attachments,
+	})
+}
+
+// Add schedules a recurring message by its Cron expression, evaluated in
+// TIMEZONE. The ID, next run and creation time are set by the scheduler.
+func (s *Scheduler) Add(e *Entry) (*Entry, error) {
+	cron, err := ParseCron(e.Cron)
+	if err != nil {
+		return nil, err
+	}
+
+	e.cron = cron
+	e.Next = cron.Next(time.Now().In(s.config.Timezone))
+	if e.Next.IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", e.Cron)
+	}
+	if e.Route == "" {
+		e.Route = RouteName
+	}
+	if e.Source == "" {
+		e.Source = RouteName
+	}
+	return s.add(e)
+}
+
+// add stores a new entry and wakes the scheduler
+func (s *Scheduler) add(e *Entry) (*Entry, error) {
+	e.ID = newID()
+	e.CreatedAt = time.Now()
+
+	s.mutex.Lock()
+	s.entries[e.ID] = e
+	err := s.save()
+	if err != nil {
+		delete(s.entries, e.ID)
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	s.updateMetrics()
+	s.logger.Info("Message scheduled", "id", e.ID, "kind", e.Kind(), "route", e.Route, "next", e.Next)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return e, nil
+}
+
+// List returns the scheduled messages, the next due first
+func (s *Scheduler) List() []*Entry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := make([]*Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Next.Before(entries[j].Next) })
+	return entries
+}
+
+// Get returns a scheduled message
+func (s *Scheduler) Get(id string) (*Entry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.entries[id]
+	return e, ok
+}
+
+// Remove cancels a scheduled message; ok is false for unknown messages
+func (s *Scheduler) Remove(id string) (ok bool, err error) {
+	s.mutex.Lock()
+	e, ok := s.entries[id]
+	if ok {
+		delete(s.entries, id)
+		if err = s.save(); err != nil {
+			s.entries[id] = e
+		}
+	}
+	s.mutex.Unlock()
+
+	if ok && err == nil {
+		s.updateMetrics()
+		s.logger.Info("Scheduled message cancelled", "id", id, "kind", e.Kind())
+	}
+	return ok, err
+}
+
+// Run submits the messages as they fall due until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	s.logger.Info("Scheduler started", "file", s.config.ScheduleFile, "messages", len(s.List()))
+	defer s.logger.Info("Scheduler stopped")
+
+	for {
+		timer := time.NewTimer(s.untilNext(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		s.deliverDue(ctx, time.Now())
+	}
+}
+
+// untilNext returns how long to wait for the next due message, at most
+// maxWait
+func (s *Scheduler) untilNext(now time.Time) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	wait := maxWait
+	for _, e := range s.entries {
+		if until := e.Next.Sub(now); until < wait {
+			wait = until
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// deliverDue submits the messages due at now. Delayed notifications are
+// removed and recurring messages moved to their next run first. A recurring
+// message missed while the service was down is delivered once.
+func (s *Scheduler) deliverDue(ctx context.Context, now time.Time) {
+	type run struct {
+		entry   *Entry
+		holiday string
+	}
+
+	s.mutex.Lock()
+	var due []run
+	for id, e := range s.entries {
+		if e.Next.After(now) {
+			continue
+		}
+
+		r := run{entry: e}
+		if e.cron == nil {
+			delete(s.entries, id)
+		} else {
+			if e.SkipHolidays {
+				r.holiday, _ = s.config.Holidays.Holiday(e.Next)
+			}
+			next := *e
+			next.Next = e.cron.Next(now.In(s.config.Timezone))
+			if next.Next.IsZero() {
+				delete(s.entries, id)
+			} else {
+				s.entries[id] = &next
+			}
+		}
+		due = append(due, r)
+	}
+	if len(due) == 0 {
+		s.mutex.Unlock()
+		return
+	}
+	if err := s.save(); err != nil {
+		s.logger.Error("Failed to save schedule", "file", s.config.ScheduleFile, "error", err)
+	}
+	s.mutex.Unlock()
+	s.updateMetrics()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].entry.Next.Before(due[j].entry.Next) })
+	for _, r := range due {
+		e := r.entry
+		log := s.logger.With("id", e.ID, "kind", e.Kind(), "route", e.Route)
+
+		if r.holiday != "" {
+			scheduledDeliveries.Inc(e.Kind(), "skipped")
+			log.Info("Skipped scheduled message on holiday", "holiday", r.holiday)
+			continue
+		}
+
+		ctx := ctx
+		if e.RequestID != "" {
+			ctx = logger.WithRequestID(ctx, e.RequestID)
+		}
+		if err := s.submit(ctx, e.notification(now), e.Account); err != nil {
+			scheduledDeliveries.Inc(e.Kind(), "failure")
+			log.Error("Failed to deliver scheduled message", "error", err)
+			continue
+		}
+		scheduledDeliveries.Inc(e.Kind(), "success")
+		log.Info("Scheduled message delivered", "due", e.Next)
+	}
+}
+
+// updateMetrics reports the number of scheduled messages of each kind
+func (s *Scheduler) updateMetrics() {
+	counts := map[string]int{KindDelayed: 0, KindRecurring: 0}
+	for _, e := range s.List() {
+		counts[e.Kind()]++
+	}
+	for kind, count := range counts {
+		scheduledMessages.Set(float64(count), kind)
+	}
+}
+
+// save writes the schedule file, replacing it atomically. The caller holds
+// the mutex.
+func (s *Scheduler) save() error {
+	entries := make([]*Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt.Before(entries[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := s.config.ScheduleFile
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newID returns a random ID for a scheduled message
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,407 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Violation describes a single place where a document does not match the schema
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// maxDepth bounds how deeply schemas and documents are walked, so that
+// recursive schemas cannot exhaust the stack
+const maxDepth = 64
+
+// Schema is a compiled JSON Schema document.
+// It supports the commonly used subset of the specification: type, enum,
+// const, properties, required, additionalProperties, items, string/number/array
+// bounds, pattern, allOf/anyOf/oneOf/not and local $ref pointers.
+type Schema struct {
+	root     map[string]interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// Load reads and compiles a JSON Schema from a file
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema file: %w", err)
+	}
+
+	return Parse(data)
+}
+
+// Parse compiles a JSON Schema document
+func Parse(data []byte) (*Schema, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	s := &Schema{
+		root:     root,
+		patterns: make(map[string]*regexp.Regexp),
+	}
+
+	// Compile all patterns up front so broken schemas fail at startup
+	if err := s.compilePatterns(root); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Validate checks a decoded JSON document against the schema
+func (s *Schema) Validate(doc interface{}) []Violation {
+	var violations []Violation
+	s.validate(s.root, doc, "", nil, 0, &violations)
+	return violations
+}
+
+// Document returns the decoded schema document; it must not be modified
+func (s *Schema) Document() map[string]interface{} {
+	return s.root
+}
+
+// ValidateJSON decodes raw JSON and checks it against the schema
+func (s *Schema) ValidateJSON(data []byte) ([]Violation, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	return s.Validate(doc), nil
+}
+
+// compilePatterns walks the schema and compiles every "pattern" keyword
+func (s *Schema) compilePatterns(node interface{}) error {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if p, ok := n["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+			s.patterns[p] = re
+		}
+		for _, child := range n {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range n {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks value against a schema node, appending violations. refs
+// holds the $ref pointers followed for value so far, a repeated one being a
+// cycle, and depth the number of nodes walked to reach node.
+func (s *Schema) validate(node map[string]interface{}, value interface{}, path string, refs []string, depth int, violations *[]Violation) {
+	add := func(format string, args ...interface{}) {
+		p := path
+		if p == "" {
+			p = "/"
+		}
+		*violations = append(*violations, Violation{Path: p, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if depth > maxDepth {
+		add("schema or document nested deeper than %d levels", maxDepth)
+		return
+	}
+	depth++
+
+	// A $ref may lead to another one
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			break
+		}
+		for _, followed := range refs {
+			if followed == ref {
+				add("circular $ref %q", ref)
+				return
+			}
+		}
+		refs = append(refs[:len(refs):len(refs)], ref)
+
+		target, err := s.resolve(ref)
+		if err != nil {
+			add("%v", err)
+			return
+		}
+		node = target
+	}
+
+	if t, ok := node["type"]; ok && !matchesType(t, value) {
+		add("expected %s, got %s", describeType(t), typeName(value))
+		return
+	}
+
+	if enum, ok := node["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if reflect.DeepEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("value must be one of %s", compact(enum))
+		}
+	}
+
+	if c, ok := node["const"]; ok && !reflect.DeepEqual(c, value) {
+		add("value must be %s", compact(c))
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if min, ok := number(node["minLength"]); ok && float64(length) < min {
+			add("must be at least %v characters long", min)
+		}
+		if max, ok := number(node["maxLength"]); ok && float64(length) > max {
+			add("must be at most %v characters long", max)
+		}
+		if p, ok := node["pattern"].(string); ok && !s.patterns[p].MatchString(v) {
+			add("must match pattern %q", p)
+		}
+
+	case float64:
+		if min, ok := number(node["minimum"]); ok && v < min {
+			add("must be >= %v", min)
+		}
+		if max, ok := number(node["maximum"]); ok && v > max {
+			add("must be <= %v", max)
+		}
+		if min, ok := number(node["exclusiveMinimum"]); ok && v <= min {
+			add("must be > %v", min)
+		}
+		if max, ok := number(node["exclusiveMaximum"]); ok && v >= max {
+			add("must be < %v", max)
+		}
+
+	case []interface{}:
+		if min, ok := number(node["minItems"]); ok && float64(len(v)) < min {
+			add("must contain at least %v items", min)
+		}
+		if max, ok := number(node["maxItems"]); ok && float64(len(v)) > max {
+			add("must contain at most %v items", max)
+		}
+		if items, ok := node["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				s.validate(items, item, path+"/"+strconv.Itoa(i), nil, depth, violations)
+			}
+		}
+
+	case map[string]interface{}:
+		if required, ok := node["required"].([]interface{}); ok {
+			for _, r := range required {
+				name, _ := r.(string)
+				if _, exists := v[name]; !exists {
+					add("missing required property %q", name)
+				}
+			}
+		}
+
+		properties, _ := node["properties"].(map[string]interface{})
+		for _, name := range sortedKeys(v) {
+			childPath := path + "/" + escapePointer(name)
+			if prop, ok := properties[name].(map[string]interface{}); ok {
+				s.validate(prop, v[name], childPath, nil, depth, violations)
+				continue
+			}
+
+			switch additional := node["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					*violations = append(*violations, Violation{Path: childPath, Message: "additional property is not allowed"})
+				}
+			case map[string]interface{}:
+				s.validate(additional, v[name], childPath, nil, depth, violations)
+			}
+		}
+	}
+
+	if all, ok := node["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if subNode, ok := sub.(map[string]interface{}); ok {
+				s.validate(subNode, value, path, refs, depth, violations)
+			}
+		}
+	}
+
+	if anyOf, ok := node["anyOf"].([]interface{}); ok && s.countMatches(anyOf, value, path, refs, depth) == 0 {
+		add("must match at least one schema in anyOf")
+	}
+
+	if oneOf, ok := node["oneOf"].([]interface{}); ok {
+		if n := s.countMatches(oneOf, value, path, refs, depth); n != 1 {
+			add("must match exactly one schema in oneOf, matched %d", n)
+		}
+	}
+
+	if not, ok := node["not"].(map[string]interface{}); ok {
+		var sub []Violation
+		s.validate(not, value, path, refs, depth, &sub)
+		if len(sub) == 0 {
+			add("must not match the schema in not")
+		}
+	}
+}
+
+// countMatches returns how many of the given schemas value satisfies
+func (s *Schema) countMatches(schemas []interface{}, value interface{}, path string, refs []string, depth int) int {
+	matches := 0
+	for _, sub := range schemas {
+		subNode, ok := sub.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var subViolations []Violation
+		s.validate(subNode, value, path, refs, depth, &subViolations)
+		if len(subViolations) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// resolve follows a local "#/..." JSON pointer within the schema document
+func (s *Schema) resolve(ref string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q: only local references are supported", ref)
+	}
+
+	var node interface{} = s.root
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		if node, ok = m[part]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+
+	target, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %q", ref)
+	}
+	return target, nil
+}
+
+// matchesType reports whether value matches a "type" keyword (string or list)
+func matchesType(t interface{}, value interface{}) bool {
+	switch tt := t.(type) {
+	case string:
+		return matchesSingleType(tt, value)
+	case []interface{}:
+		for _, candidate := range tt {
+			if name, ok := candidate.(string); ok && matchesSingleType(name, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// matchesSingleType reports whether value is of the named JSON type
+func matchesSingleType(name string, value interface{}) bool {
+	switch name {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return typeName(value) == name
+	}
+}
+
+// typeName returns the JSON type name of a decoded value
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+// describeType renders a "type" keyword for error messages
+func describeType(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, item := range list {
+			names = append(names, fmt.Sprint(item))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+// number extracts a numeric keyword value
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+// compact renders a value as compact JSON for error messages
+func compact(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// sortedKeys returns the keys of an object in a stable order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer escapes a property name for use in a JSON pointer
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
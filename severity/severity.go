@@ -0,0 +1,223 @@
+// Package severity maps the severity scales of notification sources, such as
+// syslog levels or Zabbix severities, to the Gotify priority scale (0-10)
+// used for dialog routing and rendering. All source parsers look up
+// priorities here, so the mapping is configured in one place.
+package severity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Severity scales
+const (
+	// Gotify priorities 0-10, remapped as is by default
+	Gotify = "gotify"
+
+	// Ntfy priorities 1-5 and their names, as in priority headers
+	Ntfy = "ntfy"
+
+	// Urgency of RFC 9218 priority headers, 0 most urgent to 7 least
+	Urgency = "urgency"
+
+	// Syslog levels 0-7 and their names, also used for the Windows Event Log
+	Syslog = "syslog"
+
+	// Severity labels of Alertmanager and Grafana alerts
+	Alert = "alert"
+
+	// Zabbix trigger severities 0-5 and their names
+	Zabbix = "zabbix"
+
+	// PagerDuty incident priorities P1-P5 and event severities
+	PagerDuty = "pagerduty"
+
+	// Grafana alert states, for alerts without a severity label
+	Grafana = "grafana"
+
+	// Colors of Slack attachments
+	Slack = "slack"
+
+	// Jenkins build results
+	Jenkins = "jenkins"
+
+	// Uptime Kuma heartbeat statuses 0-3 and their names
+	UptimeKuma = "uptimekuma"
+)
+
+// MinPriority and MaxPriority bound the Gotify priority scale
+const (
+	MinPriority = 0
+	MaxPriority = 10
+)
+
+// defaults are the built-in mappings of each scale
+var defaults = map[string]map[string]int{
+	Gotify: {
+		"0": 0, "1": 1, "2": 2, "3": 3, "4": 4, "5": 5,
+		"6": 6, "7": 7, "8": 8, "9": 9, "10": 10,
+	},
+	Ntfy: {
+		"1": 1, "min": 1,
+		"2": 3, "low": 3,
+		"3": 5, "default": 5, "normal": 5,
+		"4": 8, "high": 8,
+		"5": 10, "max": 10, "urgent": 10,
+	},
+	Urgency: {
+		"0": 10, "1": 8, "2": 7, "3": 5, "4": 4, "5": 3, "6": 2, "7": 0,
+	},
+	Syslog: {
+		"0": 10, "emerg": 10, "emergency": 10, "panic": 10,
+		"1": 10, "alert": 10,
+		"2": 10, "crit": 10, "critical": 10,
+		"3": 8, "err": 8, "error": 8,
+		"4": 5, "warning": 5, "warn": 5,
+		"5": 4, "notice": 4,
+		"6": 2, "info": 2, "informational": 2,
+		"7": 1, "debug": 1,
+	},
+	Alert: {
+		"critical": 8,
+		"error":    7,
+		"warning":  5,
+		"info":     2,
+		"none":     0,
+	},
+	Zabbix: {
+		"0": 1, "not_classified": 1,
+		"1": 2, "information": 2,
+		"2": 5, "warning": 5,
+		"3": 6, "average": 6,
+		"4": 8, "high": 8,
+		"5": 10, "disaster": 10,
+	},
+	PagerDuty: {
+		"p1": 10, "p2": 8, "p3": 6, "p4": 4, "p5": 2,
+		"critical": 10, "error": 8, "warning": 5, "info": 2,
+	},
+	Grafana: {
+		"alerting": 8, "firing": 8, "no_data": 5, "pending": 4,
+		"paused": 2, "ok": 2, "resolved": 2,
+	},
+	Slack: {
+		"danger": 8, "warning": 5, "good": 2,
+	},
+	Jenkins: {
+		"success":   2,
+		"unstable":  5,
+		"failure":   8,
+		"aborted":   4,
+		"not_built": 3,
+	},
+	UptimeKuma: {
+		"0": 8, "down": 8,
+		"1": 2, "up": 2,
+		"2": 5, "pending": 5,
+		"3": 2, "maintenance": 2,
+	},
+}
+
+// Table maps severities of each scale to priorities. It is safe for
+// concurrent use.
+type Table struct {
+	mutex  sync.RWMutex
+	scales map[string]map[string]int
+}
+
+// Default is the table used by all source parsers
+var Default = New()
+
+// New creates a table with the built-in mappings
+func New() *Table {
+	scales := make(map[string]map[string]int, len(defaults))
+	for scale, mapping := range defaults {
+		scales[scale] = make(map[string]int, len(mapping))
+		for value, priority := range mapping {
+			scales[scale][value] = priority
+		}
+	}
+	return &Table{scales: scales}
+}
+
+// Lookup returns the priority of a severity of the given scale. Severities
+// are matched case-insensitively, with spaces and dashes read as
+// underscores. ok is false for unknown severities.
+func (t *Table) Lookup(scale, severity string) (priority int, ok bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	priority, ok = t.scales[scale][normalize(severity)]
+	return priority, ok
+}
+
+// Priority returns the priority of a severity of the given scale, or
+// fallback for unknown severities
+func (t *Table) Priority(scale, severity string, fallback int) int {
+	if priority, ok := t.Lookup(scale, severity); ok {
+		return priority
+	}
+	return fallback
+}
+
+// Set maps a severity of the given scale to a priority
+func (t *Table) Set(scale, severity string, priority int) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	mapping, ok := t.scales[scale]
+	if !ok {
+		return fmt.Errorf("unknown severity scale %q, expected one of %s", scale, strings.Join(Scales(), ", "))
+	}
+	if priority < MinPriority || priority > MaxPriority {
+		return fmt.Errorf("priority of %s severity %q must be %d-%d", scale, severity, MinPriority, MaxPriority)
+	}
+
+	mapping[normalize(severity)] = priority
+	return nil
+}
+
+// Override applies mappings keyed by scale and severity, as configured
+// with SEVERITY_MAP
+func (t *Table) Override(mappings map[string]map[string]int) error {
+	for scale, mapping := range mappings {
+		for severity, priority := range mapping {
+			if err := t.Set(scale, severity, priority); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Reset restores the built-in mappings and applies mappings over them, as
+// on a configuration reload. The table is left unchanged on error.
+func (t *Table) Reset(mappings map[string]map[string]int) error {
+	fresh := New()
+	if err := fresh.Override(mappings); err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.scales = fresh.scales
+	return nil
+}
+
+// Scales returns the names of the severity scales, sorted
+func Scales() []string {
+	scales := make([]string, 0, len(defaults))
+	for scale := range defaults {
+		scales = append(scales, scale)
+	}
+	sort.Strings(scales)
+	return scales
+}
+
+// normalize returns the lookup key of a severity
+func normalize(severity string) string {
+	severity = strings.ToLower(strings.TrimSpace(severity))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(severity)
+}
@@ -0,0 +1,182 @@
+package sink
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// Escalation is a notification waiting for acknowledgement before it is
+// escalated to a phone call
+type Escalation struct {
+	// ID is the request ID of the notification
+	ID       string    `json:"id"`
+	Route    string    `json:"route"`
+	Title    string    `json:"title,omitempty"`
+	Priority int       `json:"priority"`
+	Created  time.Time `json:"created"`
+	Due      time.Time `json:"due"`
+
+	// Fingerprints are the firing alerts of the notification; acknowledging
+	// any of them acknowledges the escalation
+	Fingerprints []string `json:"fingerprints,omitempty"`
+
+	msg   *Message
+	timer *time.Timer
+}
+
+// Escalations holds the notifications waiting for acknowledgement before
+// they escalate to a phone call. It outlives the phone sinks, which are
+// replaced on reload, so pending escalations keep their timers and are
+// called by the sink current when they are due. They are kept in memory
+// and dropped on restart.
+type Escalations struct {
+	logger *logger.Logger
+
+	mutex   sync.Mutex
+	pending map[string]*Escalation
+
+	// phone places the calls; nil while no phone sink is configured
+	phone *Phone
+}
+
+// NewEscalations creates an empty escalation store
+func NewEscalations(logger *logger.Logger) *Escalations {
+	return &Escalations{
+		logger:  logger,
+		pending: make(map[string]*Escalation),
+	}
+}
+
+// attach makes a phone sink place the calls of escalations that are due
+func (s *Escalations) attach(p *Phone) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.phone = p
+}
+
+// detach stops handing calls to a phone sink being stopped, unless it was
+// already replaced by the sink of a newer configuration
+func (s *Escalations) detach(p *Phone) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.phone == p {
+		s.phone = nil
+	}
+}
+
+// add schedules an escalation after delay. It reports false when the
+// notification is already pending, so a replay does not call twice.
+func (s *Escalations) add(e *Escalation, delay time.Duration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.pending[e.ID]; ok {
+		return false
+	}
+	s.pending[e.ID] = e
+	e.timer = time.AfterFunc(delay, func() { s.escalate(e.ID) })
+	return true
+}
+
+// escalate hands an escalation that is due to the current phone sink
+func (s *Escalations) escalate(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.pending[id]
+	if !ok {
+		return
+	}
+	delete(s.pending, id)
+
+	if s.phone == nil {
+		phoneEscalations.Inc("dropped")
+		s.logger.Warn("Phone escalation due without a phone sink, dropped", "id", id, "route", e.Route)
+		return
+	}
+	s.phone.call(e)
+}
+
+// Pending returns the escalations waiting for acknowledgement, the earliest
+// due first
+func (s *Escalations) Pending() []Escalation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pending := make([]Escalation, 0, len(s.pending))
+	for _, e := range s.pending {
+		pending = append(pending, Escalation{
+			ID:           e.ID,
+			Route:        e.Route,
+			Title:        e.Title,
+			Priority:     e.Priority,
+			Created:      e.Created,
+			Due:          e.Due,
+			Fingerprints: e.Fingerprints,
+		})
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Due.Before(pending[j].Due) })
+	return pending
+}
+
+// Acknowledge cancels the escalation of a notification. It reports whether
+// the escalation was pending.
+func (s *Escalations) Acknowledge(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.pending[id]
+	if !ok {
+		return false
+	}
+	s.cancel(e)
+	return true
+}
+
+// AcknowledgeAll cancels the escalations of a route, or all escalations
+// when route is empty, and returns their number
+func (s *Escalations) AcknowledgeAll(route string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, e := range s.pending {
+		if route == "" || e.Route == route {
+			s.cancel(e)
+			count++
+		}
+	}
+	return count
+}
+
+// AcknowledgeAlert cancels the escalations of the notifications an alert
+// fired in, and returns their number
+func (s *Escalations) AcknowledgeAlert(fingerprint string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, e := range s.pending {
+		for _, f := range e.Fingerprints {
+			if f == fingerprint {
+				s.cancel(e)
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// cancel removes a pending escalation; the caller holds the mutex
+func (s *Escalations) cancel(e *Escalation) {
+	e.timer.Stop()
+	delete(s.pending, e.ID)
+	phoneEscalations.Inc("acknowledged")
+	s.logger.Info("Phone escalation acknowledged", "id", e.ID, "route", e.Route)
+}
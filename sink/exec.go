@@ -0,0 +1,259 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var execRuns = metrics.NewCounter("exec_sink_runs_total",
+	"Commands run by the exec sink, by result: success, failure or timeout.", "result")
+
+// maxExecOutput caps the command output kept for the logs; the rest is
+// discarded as the command writes it
+const maxExecOutput = 1024
+
+// execBaseEnv are the variables of the forwarder's environment that every
+// command gets, so the shell finds programs and formats text as usual.
+// Credentials such as MIZITO_PASSWORD are only passed when named in
+// ExecConfig.Env.
+var execBaseEnv = []string{
+	"PATH", "HOME", "USER", "LANG", "LC_ALL", "TZ", "TMPDIR",
+	// Windows
+	"SYSTEMROOT", "WINDIR", "COMSPEC", "PATHEXT", "TEMP", "TMP",
+}
+
+// ExecConfig holds the exec sink settings
+type ExecConfig struct {
+	// Command is run through the shell for every notification; empty runs
+	// nothing for routes without a command of their own
+	Command string
+
+	// RouteCommands overrides Command for the named routes
+	RouteCommands map[string]string
+
+	// Timeout bounds each run; the command is killed when it expires
+	Timeout time.Duration
+
+	// Concurrency caps the commands running at once; notifications arriving
+	// while all slots are taken are dropped
+	Concurrency int
+
+	// Env names the variables of the forwarder's environment passed to
+	// commands besides execBaseEnv
+	Env []string
+}
+
+// Exec runs a command per notification with the message as JSON on stdin.
+// Commands run in the background, so slow scripts do not hold up delivery.
+type Exec struct {
+	config ExecConfig
+	slots  chan struct{}
+	wg     sync.WaitGroup
+	logger *logger.Logger
+}
+
+// NewExec creates an exec sink
+func NewExec(config ExecConfig, logger *logger.Logger) *Exec {
+	return &Exec{
+		config: config,
+		slots:  make(chan struct{}, config.Concurrency),
+		logger: logger,
+	}
+}
+
+// Name implements Sink
+func (e *Exec) Name() string {
+	return "exec"
+}
+
+// Send implements Sink. It starts the command of the message's route and
+// returns without waiting for it; failures of the command are logged.
+func (e *Exec) Send(ctx context.Context, msg *Message) error {
+	command := e.command(msg.Route)
+	if command == "" {
+		return nil
+	}
+
+	input, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	select {
+	case e.slots <- struct{}{}:
+	default:
+		return fmt.Errorf("%d commands already running, notification dropped", e.config.Concurrency)
+	}
+
+	// The command outlives the request, but keeps its ID for the logs
+	runCtx := context.Background()
+	if msg.RequestID != "" {
+		runCtx = logger.WithRequestID(runCtx, msg.RequestID)
+	}
+
+	e.wg.Add(1)
+	go func() {
+		defer func() {
+			<-e.slots
+			e.wg.Done()
+		}()
+		e.run(runCtx, command, msg, input)
+	}()
+
+	return nil
+}
+
+// Deliver implements Deliverer. It runs the command of the message's route
+// and waits for it.
+func (e *Exec) Deliver(ctx context.Context, msg *Message) error {
+	command := e.command(msg.Route)
+	if command == "" {
+		return ErrSkipped
+	}
+
+	input, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	select {
+	case e.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	e.wg.Add(1)
+	defer func() {
+		<-e.slots
+		e.wg.Done()
+	}()
+
+	return e.run(ctx, command, msg, input)
+}
+
+// Stop waits for running commands to finish
+func (e *Exec) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// command returns the command of a route
+func (e *Exec) command(route string) string {
+	if command, ok := e.config.RouteCommands[route]; ok {
+		return command
+	}
+	return e.config.Command
+}
+
+// run runs a command and logs its outcome
+func (e *Exec) run(ctx context.Context, command string, msg *Message, input []byte) error {
+	log := e.logger.WithContext(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(e.environ(),
+		"NOTIFICATION_ROUTE="+msg.Route,
+		"NOTIFICATION_PRIORITY="+strconv.Itoa(msg.Priority),
+		"NOTIFICATION_TITLE="+msg.Title,
+		"NOTIFICATION_DIALOG_ID="+msg.DialogID,
+	)
+	// Children that keep stdout open must not block the run forever
+	cmd.WaitDelay = time.Second
+
+	var output limitedBuffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	start := time.Now()
+	err := cmd.Run()
+	duration := time.Since(start)
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		execRuns.Inc("timeout")
+		log.Warn("Exec sink command timed out", "route", msg.Route, "timeout", e.config.Timeout, "output", truncate(output.String()))
+		return fmt.Errorf("command timed out after %s", e.config.Timeout)
+	case err != nil:
+		execRuns.Inc("failure")
+		log.Warn("Exec sink command failed", "route", msg.Route, "duration", duration, "error", err, "output", truncate(output.String()))
+		return err
+	default:
+		execRuns.Inc("success")
+		log.Debug("Exec sink command finished", "route", msg.Route, "duration", duration, "output", truncate(output.String()))
+		return nil
+	}
+}
+
+// environ returns the environment of commands: the variables of execBaseEnv
+// and ExecConfig.Env that are set
+func (e *Exec) environ() []string {
+	var env []string
+	for _, names := range [][]string{execBaseEnv, e.config.Env} {
+		for _, name := range names {
+			if value, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+value)
+			}
+		}
+	}
+	return env
+}
+
+// limitedBuffer keeps the first maxExecOutput+1 bytes written to it, enough
+// to tell whether the output was truncated, and discards the rest
+type limitedBuffer struct {
+	buf bytes.Buffer
+}
+
+// Write implements io.Writer, always reporting the whole of p as written so
+// the command is not cut off
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := maxExecOutput + 1 - b.buf.Len(); remaining > 0 {
+		b.buf.Write(p[:min(len(p), remaining)])
+	}
+	return len(p), nil
+}
+
+// String returns the kept output
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// shellCommand runs a command line through the system shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
+
+// truncate shortens command output for logging
+func truncate(output string) string {
+	if len(output) > maxExecOutput {
+		return output[:maxExecOutput] + "…"
+	}
+	return output
+}
@@ -0,0 +1,99 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// File formats
+const (
+	// FormatJSONL writes one JSON object per message and line
+	FormatJSONL = "jsonl"
+
+	// FormatText writes the message text, prefixed with its time, route and
+	// priority; continuation lines are indented with a tab
+	FormatText = "text"
+)
+
+// fileWriteTimeout bounds writes to a FIFO whose reader does not keep up
+const fileWriteTimeout = 5 * time.Second
+
+// File appends messages to a file or FIFO. The file is opened for every
+// message, so it can be rotated or the FIFO reader restarted at any time.
+// A FIFO without a reader fails the write instead of blocking.
+type File struct {
+	path   string
+	format string
+	mutex  sync.Mutex
+}
+
+// NewFile creates a sink appending to path in the given format
+func NewFile(path, format string) (*File, error) {
+	switch format {
+	case FormatJSONL, FormatText:
+	default:
+		return nil, fmt.Errorf("unknown file sink format %q, expected jsonl or text", format)
+	}
+
+	return &File{path: path, format: format}, nil
+}
+
+// Name implements Sink
+func (f *File) Name() string {
+	return "file"
+}
+
+// Send implements Sink
+func (f *File) Send(ctx context.Context, msg *Message) error {
+	data, err := f.encode(msg)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// O_NONBLOCK makes opening a FIFO without reader fail with ENXIO
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|syscall.O_NONBLOCK, 0600)
+	if errors.Is(err, syscall.ENXIO) {
+		return fmt.Errorf("no reader on FIFO %s", f.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", f.path, err)
+	}
+	defer file.Close()
+
+	// Deadlines only apply to FIFOs; regular files ignore them
+	deadline := time.Now().Add(fileWriteTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	file.SetWriteDeadline(deadline)
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.path, err)
+	}
+
+	return file.Close()
+}
+
+// encode formats a message as one record
+func (f *File) encode(msg *Message) ([]byte, error) {
+	if f.format == FormatJSONL {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal message: %w", err)
+		}
+		return append(data, '\n'), nil
+	}
+
+	text := strings.ReplaceAll(strings.TrimRight(msg.Text, "\n"), "\n", "\n\t")
+	return []byte(fmt.Sprintf("%s %s [%d] %s\n", msg.Time.Format(time.RFC3339), msg.Route, msg.Priority, text)), nil
+}
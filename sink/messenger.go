@@ -0,0 +1,339 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var messengerSent = metrics.NewCounter("messenger_sink_messages_total",
+	"Notifications sent to messengers, by platform and result: success, failure or dropped.", "platform", "result")
+
+// Messenger platforms
+const (
+	PlatformTelegram = "telegram"
+	PlatformBale     = "bale"
+	PlatformEitaa    = "eitaa"
+)
+
+// messengerAPIURLs holds the default bot API endpoint of each platform. Bale
+// implements the Telegram Bot API; Eitaa bots use the Eitaayar API.
+var messengerAPIURLs = map[string]string{
+	PlatformTelegram: "https://api.telegram.org",
+	PlatformBale:     "https://tapi.bale.ai",
+	PlatformEitaa:    "https://eitaayar.ir",
+}
+
+// messengerTitles holds the platform names used in logs and errors
+var messengerTitles = map[string]string{
+	PlatformTelegram: "Telegram",
+	PlatformBale:     "Bale",
+	PlatformEitaa:    "Eitaa",
+}
+
+// messengerBufferSize is the number of messages waiting to be sent
+const messengerBufferSize = 256
+
+// maxMessengerLength is the longest text sent in one message, the limit of
+// the Telegram Bot API
+const maxMessengerLength = 4096
+
+// maxMessengerResponse caps the bot API response read
+const maxMessengerResponse = 4096
+
+// maxMessengerRetryAfter caps the wait requested by a rate limited response
+const maxMessengerRetryAfter = 30 * time.Second
+
+// MessengerNone as the chat of a route stops its notifications from being
+// sent to the default chat
+const MessengerNone = "none"
+
+// MessengerConfig holds the settings of a messenger sink
+type MessengerConfig struct {
+	// Platform is PlatformTelegram, PlatformBale or PlatformEitaa
+	Platform string
+
+	// BotToken authenticates the bot with the bot API
+	BotToken string
+
+	// ChatID is the chat receiving notifications of routes without their
+	// own chat; empty sends only those routes
+	ChatID string
+
+	// RouteChats overrides ChatID for the named routes; MessengerNone skips
+	// the route
+	RouteChats map[string]string
+
+	// Failover sends only the notifications Mizito failed to take, instead
+	// of mirroring all of them
+	Failover bool
+
+	// Timeout bounds each request to the bot API
+	Timeout time.Duration
+
+	// APIURL replaces the bot API endpoint, e.g. for a local Bot API server
+	APIURL string
+}
+
+// messengerMessage is a message waiting for the bot API
+type messengerMessage struct {
+	chatID string
+	msg    *Message
+}
+
+// Messenger sends notifications to Telegram, Bale or Eitaa chats through a
+// bot, as a mirror of Mizito or as its failover. Messages are sent in the
+// background, so the messenger does not hold up delivery to Mizito.
+type Messenger struct {
+	config MessengerConfig
+	title  string
+	client *http.Client
+	logger *logger.Logger
+
+	messages chan messengerMessage
+	done     chan struct{}
+
+	// mutex guards messages against sends after Stop
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewMessenger creates a messenger sink and starts sending
+func NewMessenger(config MessengerConfig, logger *logger.Logger) (*Messenger, error) {
+	defaultURL, ok := messengerAPIURLs[config.Platform]
+	if !ok {
+		return nil, fmt.Errorf("unknown messenger %q", config.Platform)
+	}
+	if config.BotToken == "" {
+		return nil, errors.New("no bot token")
+	}
+	if config.APIURL == "" {
+		config.APIURL = defaultURL
+	}
+	config.APIURL = strings.TrimSuffix(config.APIURL, "/")
+
+	m := &Messenger{
+		config:   config,
+		title:    messengerTitles[config.Platform],
+		client:   &http.Client{Timeout: config.Timeout},
+		logger:   logger,
+		messages: make(chan messengerMessage, messengerBufferSize),
+		done:     make(chan struct{}),
+	}
+
+	go m.run()
+	return m, nil
+}
+
+// Name implements Sink
+func (m *Messenger) Name() string {
+	return m.config.Platform
+}
+
+// Failover implements Failover
+func (m *Messenger) Failover() bool {
+	return m.config.Failover
+}
+
+// Send implements Sink. Notifications of routes without a chat are ignored;
+// others are queued for sending.
+func (m *Messenger) Send(ctx context.Context, msg *Message) error {
+	chatID := m.chat(msg.Route)
+	if chatID == "" {
+		return nil
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if m.closed {
+		return fmt.Errorf("%s sink stopped", m.title)
+	}
+
+	select {
+	case m.messages <- messengerMessage{chatID: chatID, msg: msg}:
+		return nil
+	default:
+		messengerSent.Inc(m.config.Platform, "dropped")
+		return fmt.Errorf("%d messages waiting for %s, notification dropped", messengerBufferSize, m.title)
+	}
+}
+
+// chat returns the chat of a route, or "" when it is not sent
+func (m *Messenger) chat(route string) string {
+	chatID, ok := m.config.RouteChats[route]
+	if !ok {
+		chatID = m.config.ChatID
+	}
+	if chatID == MessengerNone {
+		return ""
+	}
+	return chatID
+}
+
+// Stop sends the queued messages
+func (m *Messenger) Stop(ctx context.Context) error {
+	m.mutex.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.messages)
+	}
+	m.mutex.Unlock()
+
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run sends queued messages
+func (m *Messenger) run() {
+	defer close(m.done)
+
+	for queued := range m.messages {
+		ctx := context.Background()
+		if queued.msg.RequestID != "" {
+			ctx = logger.WithRequestID(ctx, queued.msg.RequestID)
+		}
+		m.deliver(ctx, queued)
+	}
+}
+
+// Deliver implements Deliverer
+func (m *Messenger) Deliver(ctx context.Context, msg *Message) error {
+	chatID := m.chat(msg.Route)
+	if chatID == "" {
+		return ErrSkipped
+	}
+	return m.deliver(ctx, messengerMessage{chatID: chatID, msg: msg})
+}
+
+// deliver sends a message, logging and counting the outcome
+func (m *Messenger) deliver(ctx context.Context, queued messengerMessage) error {
+	log := m.logger.WithContext(ctx)
+
+	err := m.send(ctx, queued)
+	var limited *messengerRateLimit
+	if errors.As(err, &limited) {
+		// The bot API tells how long to back off; one more attempt follows
+		log.Info("Messenger rate limit reached, retrying", "messenger", m.config.Platform, "wait", limited.retryAfter)
+		select {
+		case <-time.After(limited.retryAfter):
+			err = m.send(ctx, queued)
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		messengerSent.Inc(m.config.Platform, "failure")
+		log.Warn("Failed to send notification to messenger", "messenger", m.config.Platform, "chat", queued.chatID, "route", queued.msg.Route, "error", err)
+		return err
+	}
+
+	messengerSent.Inc(m.config.Platform, "success")
+	log.Debug("Notification sent to messenger", "messenger", m.config.Platform, "chat", queued.chatID, "route", queued.msg.Route)
+	return nil
+}
+
+// messengerRateLimit is returned for a 429 response
+type messengerRateLimit struct {
+	retryAfter time.Duration
+}
+
+func (e *messengerRateLimit) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.retryAfter)
+}
+
+// send posts a message with the sendMessage method of the platform.
+// Notifications of low priority are sent silently.
+func (m *Messenger) send(ctx context.Context, queued messengerMessage) error {
+	text := queued.msg.Text
+	if runes := []rune(text); len(runes) > maxMessengerLength {
+		text = string(runes[:maxMessengerLength-1]) + "…"
+	}
+	silent := queued.msg.Priority < 4
+
+	req, err := m.request(ctx, queued.chatID, text, silent)
+	if err != nil {
+		return fmt.Errorf("invalid %s API URL", m.title)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		// The bot token is part of the URL
+		return fmt.Errorf("request to %s failed: %w", m.title, errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxMessengerResponse))
+	json.Unmarshal(data, &result)
+
+	if resp.StatusCode == http.StatusTooManyRequests && result.Parameters.RetryAfter > 0 {
+		retryAfter := time.Duration(result.Parameters.RetryAfter) * time.Second
+		if retryAfter > maxMessengerRetryAfter {
+			retryAfter = maxMessengerRetryAfter
+		}
+		return &messengerRateLimit{retryAfter: retryAfter}
+	}
+	if resp.StatusCode != http.StatusOK || !result.OK {
+		return fmt.Errorf("%s responded with status %d: %s", m.title, resp.StatusCode, result.Description)
+	}
+	return nil
+}
+
+// request builds the sendMessage request: a form post to the Eitaayar API
+// for Eitaa, and a JSON post to the Bot API for Telegram and Bale
+func (m *Messenger) request(ctx context.Context, chatID, text string, silent bool) (*http.Request, error) {
+	if m.config.Platform == PlatformEitaa {
+		form := url.Values{
+			"chat_id": {chatID},
+			"text":    {text},
+		}
+		if silent {
+			form.Set("notification_disable", "1")
+		}
+		endpoint := m.config.APIURL + "/api/" + m.config.BotToken + "/sendMessage"
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id":              chatID,
+		"text":                 text,
+		"disable_notification": silent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := m.config.APIURL + "/bot" + m.config.BotToken + "/sendMessage"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
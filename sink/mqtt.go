@@ -0,0 +1,497 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var mqttPublished = metrics.NewCounter("mqtt_sink_messages_total",
+	"Notifications published by the MQTT sink, by result: success, failure or dropped.", "result")
+
+// mqttBufferSize is the number of messages waiting to be published; messages
+// are dropped rather than delaying notifications when it is full
+const mqttBufferSize = 256
+
+// mqttKeepAlive is the keep alive interval announced to the broker; the
+// connection is pinged at half of it while idle
+const mqttKeepAlive = 60 * time.Second
+
+// MQTT control packet types (MQTT 3.1.1)
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttPubrec     = 5
+	mqttPubrel     = 6
+	mqttPubcomp    = 7
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+// mqttConnackErrors describes the return codes of a refused connection
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// MQTTConfig holds the MQTT sink settings
+type MQTTConfig struct {
+	// URL of the broker: mqtt://host:1883, or mqtts://host:8883 for TLS,
+	// with optional credentials
+	URL string
+
+	// Topic messages are published to; {route} is replaced by the route
+	Topic string
+
+	// QoS is the MQTT quality of service, 0, 1 or 2. With QoS 2 the broker
+	// keeps the session of ClientID across reconnects, so that a message
+	// interrupted by a lost connection is completed rather than sent again.
+	QoS int
+
+	// Retain asks the broker to keep the last message of each topic for
+	// new subscribers
+	Retain bool
+
+	// ClientID identifies the connection; a random one is used when empty
+	ClientID string
+
+	// Timeout bounds connecting and each acknowledgement of the broker
+	Timeout time.Duration
+}
+
+// MQTT publishes messages as JSON to an MQTT broker. Messages are published
+// in the background over one connection, which is reopened when it fails.
+type MQTT struct {
+	config   MQTTConfig
+	addr     string
+	tls      *tls.Config
+	username string
+	password *string
+	logger   *logger.Logger
+
+	messages chan *Message
+	done     chan struct{}
+
+	// mutex guards messages against sends after Stop
+	mutex  sync.RWMutex
+	closed bool
+
+	// The connection is only used by the publishing goroutine
+	conn     net.Conn
+	reader   *bufio.Reader
+	packetID uint16
+}
+
+// NewMQTT creates an MQTT sink and starts publishing
+func NewMQTT(config MQTTConfig, logger *logger.Logger) (*MQTT, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid MQTT broker URL %q", config.URL)
+	}
+	if config.QoS < 0 || config.QoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d, expected 0, 1 or 2", config.QoS)
+	}
+	if config.Topic == "" || strings.ContainsAny(config.Topic, "+#") {
+		return nil, fmt.Errorf("invalid MQTT topic %q", config.Topic)
+	}
+
+	m := &MQTT{
+		config:   config,
+		logger:   logger,
+		messages: make(chan *Message, mqttBufferSize),
+		done:     make(chan struct{}),
+	}
+
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl", "tls":
+		port = "8883"
+		m.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("unsupported MQTT broker URL scheme %q, expected mqtt or mqtts", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	m.addr = net.JoinHostPort(u.Hostname(), port)
+
+	if u.User != nil {
+		m.username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			m.password = &password
+		}
+	}
+
+	if m.config.ClientID == "" {
+		b := make([]byte, 6)
+		rand.Read(b)
+		m.config.ClientID = "mizito-forwarder-" + hex.EncodeToString(b)
+	}
+
+	go m.run()
+	return m, nil
+}
+
+// Name implements Sink
+func (m *MQTT) Name() string {
+	return "mqtt"
+}
+
+// Send implements Sink. It queues the message for publishing and returns
+// without waiting for the broker; publishing failures are logged.
+func (m *MQTT) Send(ctx context.Context, msg *Message) error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if m.closed {
+		return errors.New("MQTT sink stopped")
+	}
+
+	select {
+	case m.messages <- msg:
+		return nil
+	default:
+		mqttPublished.Inc("dropped")
+		return fmt.Errorf("%d messages waiting for the MQTT broker, notification dropped", mqttBufferSize)
+	}
+}
+
+// Stop publishes the queued messages and disconnects
+func (m *MQTT) Stop(ctx context.Context) error {
+	m.mutex.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.messages)
+	}
+	m.mutex.Unlock()
+
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run publishes queued messages and keeps the connection alive while idle
+func (m *MQTT) run() {
+	defer close(m.done)
+
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg, ok := <-m.messages:
+			if !ok {
+				m.disconnect()
+				return
+			}
+			m.publishMessage(msg)
+
+		case <-ping.C:
+			if m.conn == nil {
+				continue
+			}
+			if err := m.ping(); err != nil {
+				m.logger.Debug("MQTT broker did not answer ping, reconnecting", "broker", m.addr, "error", err)
+				m.close()
+			}
+		}
+	}
+}
+
+// publishMessage publishes a message, retrying once on a new connection
+func (m *MQTT) publishMessage(msg *Message) {
+	ctx := context.Background()
+	if msg.RequestID != "" {
+		ctx = logger.WithRequestID(ctx, msg.RequestID)
+	}
+	log := m.logger.WithContext(ctx)
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		mqttPublished.Inc("failure")
+		log.Warn("Failed to marshal message for MQTT", "error", err)
+		return
+	}
+	topic := strings.ReplaceAll(m.config.Topic, "{route}", msg.Route)
+
+	// The retry continues the same delivery: it keeps the packet ID and,
+	// for QoS 2, resumes with PUBREL once the broker has received it
+	p := &mqttDelivery{topic: topic, payload: payload}
+	if m.config.QoS > 0 {
+		m.packetID++
+		if m.packetID == 0 {
+			m.packetID = 1
+		}
+		p.id = m.packetID
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = m.publish(p); err == nil {
+			mqttPublished.Inc("success")
+			return
+		}
+		m.close()
+		p.retry = true
+	}
+
+	mqttPublished.Inc("failure")
+	log.Warn("Failed to publish notification to MQTT broker", "broker", m.addr, "topic", topic, "error", err)
+}
+
+// mqttDelivery is the state of a message being published
+type mqttDelivery struct {
+	topic   string
+	payload []byte
+	id      uint16
+
+	// retry marks a PUBLISH sent again, with the DUP flag
+	retry bool
+
+	// received is set once the broker acknowledged a QoS 2 message with
+	// PUBREC; it then owns the message and only PUBREL remains
+	received bool
+}
+
+// publish sends a PUBLISH packet and waits for its acknowledgement
+func (m *MQTT) publish(p *mqttDelivery) error {
+	if m.conn == nil {
+		if err := m.connect(); err != nil {
+			return err
+		}
+	}
+
+	if !p.received {
+		flags := byte(m.config.QoS << 1)
+		if m.config.Retain {
+			flags |= 1
+		}
+		if p.retry && m.config.QoS > 0 {
+			flags |= 0x08
+		}
+
+		body := mqttString(p.topic)
+		if m.config.QoS > 0 {
+			body = binary.BigEndian.AppendUint16(body, p.id)
+		}
+		body = append(body, p.payload...)
+
+		if err := m.write(mqttPublish<<4|flags, body); err != nil {
+			return err
+		}
+	}
+
+	switch m.config.QoS {
+	case 1:
+		return m.expect(mqttPuback, p.id)
+	case 2:
+		if !p.received {
+			if err := m.expect(mqttPubrec, p.id); err != nil {
+				return err
+			}
+			p.received = true
+		}
+		if err := m.write(mqttPubrel<<4|2, binary.BigEndian.AppendUint16(nil, p.id)); err != nil {
+			return err
+		}
+		return m.expect(mqttPubcomp, p.id)
+	}
+	return nil
+}
+
+// connect opens a connection and sends a CONNECT packet
+func (m *MQTT) connect() error {
+	dialer := &net.Dialer{Timeout: m.config.Timeout}
+	var conn net.Conn
+	var err error
+	if m.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.addr, m.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", m.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", m.addr, err)
+	}
+	m.conn = conn
+	m.reader = bufio.NewReader(conn)
+
+	// Nothing is subscribed, so only QoS 2 deliveries need the session kept
+	// to be completed after a reconnect; other sessions start clean
+	flags := byte(0)
+	if m.config.QoS < 2 {
+		flags |= 0x02
+	}
+	if m.username != "" {
+		flags |= 0x80
+	}
+	if m.password != nil {
+		flags |= 0x40
+	}
+
+	body := mqttString("MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = append(body, mqttString(m.config.ClientID)...)
+	if m.username != "" {
+		body = append(body, mqttString(m.username)...)
+	}
+	if m.password != nil {
+		body = append(body, mqttString(*m.password)...)
+	}
+
+	if err := m.write(mqttConnect<<4, body); err != nil {
+		m.close()
+		return err
+	}
+
+	packetType, ack, err := m.read()
+	if err == nil && (packetType != mqttConnack || len(ack) != 2) {
+		err = fmt.Errorf("unexpected packet type %d", packetType)
+	}
+	if err != nil {
+		m.close()
+		return fmt.Errorf("failed to connect to %s: %w", m.addr, err)
+	}
+	if code := ack[1]; code != 0 {
+		m.close()
+		reason, ok := mqttConnackErrors[code]
+		if !ok {
+			reason = fmt.Sprintf("return code %d", code)
+		}
+		return fmt.Errorf("MQTT broker %s refused connection: %s", m.addr, reason)
+	}
+
+	m.logger.Debug("Connected to MQTT broker", "broker", m.addr, "client_id", m.config.ClientID)
+	return nil
+}
+
+// ping sends a PINGREQ packet and waits for the response
+func (m *MQTT) ping() error {
+	if err := m.write(mqttPingreq<<4, nil); err != nil {
+		return err
+	}
+	packetType, _, err := m.read()
+	if err == nil && packetType != mqttPingresp {
+		err = fmt.Errorf("unexpected packet type %d", packetType)
+	}
+	return err
+}
+
+// disconnect sends a DISCONNECT packet and closes the connection
+func (m *MQTT) disconnect() {
+	if m.conn == nil {
+		return
+	}
+	m.write(mqttDisconnect<<4, nil)
+	m.close()
+}
+
+// close closes the connection
+func (m *MQTT) close() {
+	if m.conn != nil {
+		m.conn.Close()
+		m.conn = nil
+		m.reader = nil
+	}
+}
+
+// expect reads an acknowledgement of the given type for a packet ID
+func (m *MQTT) expect(want byte, id uint16) error {
+	packetType, body, err := m.read()
+	if err != nil {
+		return err
+	}
+	if packetType != want || len(body) < 2 || binary.BigEndian.Uint16(body) != id {
+		return fmt.Errorf("unexpected packet type %d waiting for acknowledgement", packetType)
+	}
+	return nil
+}
+
+// write sends a packet with its fixed header
+func (m *MQTT) write(header byte, body []byte) error {
+	packet := append([]byte{header}, mqttLength(len(body))...)
+	packet = append(packet, body...)
+
+	m.conn.SetWriteDeadline(time.Now().Add(m.config.Timeout))
+	if _, err := m.conn.Write(packet); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", m.addr, err)
+	}
+	return nil
+}
+
+// read receives a packet, returning its type and body
+func (m *MQTT) read() (byte, []byte, error) {
+	m.conn.SetReadDeadline(time.Now().Add(m.config.Timeout))
+
+	header, err := m.reader.ReadByte()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read from %s: %w", m.addr, err)
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := m.reader.ReadByte()
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read from %s: %w", m.addr, err)
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+		multiplier *= 128
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(m.reader, body); err != nil {
+		return 0, nil, fmt.Errorf("failed to read from %s: %w", m.addr, err)
+	}
+	return header >> 4, body, nil
+}
+
+// mqttLength encodes a remaining length as a variable byte integer
+func mqttLength(n int) []byte {
+	var b []byte
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+// mqttString encodes a length-prefixed UTF-8 string
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
@@ -0,0 +1,319 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+)
+
+// mqttSession scripts one connection of the test broker
+type mqttSession func(t *testing.T, r *bufio.Reader, conn net.Conn)
+
+// serveMQTT accepts one connection per session, in order, and closes the
+// returned channel once all of them are done
+func serveMQTT(t *testing.T, sessions ...mqttSession) (string, <-chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, session := range sessions {
+			conn, err := ln.Accept()
+			if err != nil {
+				t.Errorf("accept: %v", err)
+				return
+			}
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			session(t, bufio.NewReader(conn), conn)
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), done
+}
+
+// readMQTT reads a packet, returning its fixed header byte and body
+func readMQTT(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	header, err := r.ReadByte()
+	if err != nil {
+		t.Errorf("read header: %v", err)
+		return 0, nil
+	}
+	length, multiplier := 0, 1
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			t.Errorf("read length: %v", err)
+			return 0, nil
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Errorf("read body: %v", err)
+		return 0, nil
+	}
+	return header, body
+}
+
+func writeMQTT(t *testing.T, conn net.Conn, header byte, body ...byte) {
+	t.Helper()
+	packet := append([]byte{header}, mqttLength(len(body))...)
+	if _, err := conn.Write(append(packet, body...)); err != nil {
+		t.Errorf("write: %v", err)
+	}
+}
+
+// acceptConnect reads the CONNECT packet, checks its flags and accepts it
+func acceptConnect(t *testing.T, r *bufio.Reader, conn net.Conn, wantFlags byte) {
+	t.Helper()
+	header, body := readMQTT(t, r)
+	want := append(mqttString("MQTT"), 4, wantFlags, 0, 60)
+	want = append(want, mqttString("test-client")...)
+	want = append(want, mqttString("user")...)
+	want = append(want, mqttString("secret")...)
+	if header != mqttConnect<<4 || !bytes.Equal(body, want) {
+		t.Errorf("CONNECT = %#x % x, want %#x % x", header, body, mqttConnect<<4, want)
+	}
+	writeMQTT(t, conn, mqttConnack<<4, 0, 0)
+}
+
+// readPublish reads a PUBLISH packet, returning its flags, packet ID and
+// the decoded message
+func readPublish(t *testing.T, r *bufio.Reader, qos int) (byte, uint16, *Message) {
+	t.Helper()
+	header, body := readMQTT(t, r)
+	if header>>4 != mqttPublish {
+		t.Errorf("packet type = %d, want PUBLISH", header>>4)
+		return 0, 0, nil
+	}
+
+	topicLength := int(binary.BigEndian.Uint16(body))
+	if topic := string(body[2 : 2+topicLength]); topic != "alerts/ops" {
+		t.Errorf("topic = %q, want alerts/ops", topic)
+	}
+	body = body[2+topicLength:]
+
+	var id uint16
+	if qos > 0 {
+		id = binary.BigEndian.Uint16(body)
+		body = body[2:]
+	}
+
+	var msg Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Errorf("payload: %v", err)
+	}
+	return header & 0x0f, id, &msg
+}
+
+// expectPacket reads a packet and checks its fixed header and packet ID
+func expectPacket(t *testing.T, r *bufio.Reader, want byte, id uint16) {
+	t.Helper()
+	header, body := readMQTT(t, r)
+	if header != want || len(body) != 2 || binary.BigEndian.Uint16(body) != id {
+		t.Errorf("packet = %#x % x, want %#x with packet ID %d", header, body, want, id)
+	}
+}
+
+// expectDisconnect reads the DISCONNECT packet sent on Stop
+func expectDisconnect(t *testing.T, r *bufio.Reader) {
+	t.Helper()
+	if header, _ := readMQTT(t, r); header != mqttDisconnect<<4 {
+		t.Errorf("packet = %#x, want DISCONNECT", header)
+	}
+}
+
+func newTestMQTT(t *testing.T, addr string, qos int, retain bool) *MQTT {
+	t.Helper()
+	log, err := logger.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMQTT(MQTTConfig{
+		URL:      "mqtt://user:secret@" + addr,
+		Topic:    "alerts/{route}",
+		QoS:      qos,
+		Retain:   retain,
+		ClientID: "test-client",
+		Timeout:  2 * time.Second,
+	}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// sendAndStop publishes a message and waits for the sink and the broker
+func sendAndStop(t *testing.T, m *MQTT, done <-chan struct{}) {
+	t.Helper()
+	if err := m.Send(context.Background(), &Message{Route: "ops", Text: "disk full"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("broker sessions did not finish")
+	}
+}
+
+func TestMQTTPublishQoS0(t *testing.T) {
+	addr, done := serveMQTT(t, func(t *testing.T, r *bufio.Reader, conn net.Conn) {
+		acceptConnect(t, r, conn, 0xc2)
+		flags, _, msg := readPublish(t, r, 0)
+		if flags != 0x01 {
+			t.Errorf("PUBLISH flags = %#x, want retain only", flags)
+		}
+		if msg != nil && msg.Text != "disk full" {
+			t.Errorf("payload text = %q, want %q", msg.Text, "disk full")
+		}
+		expectDisconnect(t, r)
+	})
+
+	sendAndStop(t, newTestMQTT(t, addr, 0, true), done)
+}
+
+func TestMQTTPublishQoS1RetriesWithDup(t *testing.T) {
+	var first uint16
+	addr, done := serveMQTT(t,
+		func(t *testing.T, r *bufio.Reader, conn net.Conn) {
+			acceptConnect(t, r, conn, 0xc2)
+			flags, id, _ := readPublish(t, r, 1)
+			if flags != 0x02 || id == 0 {
+				t.Errorf("PUBLISH flags = %#x, packet ID %d, want QoS 1 with an ID", flags, id)
+			}
+			first = id
+			// The connection is lost before PUBACK
+		},
+		func(t *testing.T, r *bufio.Reader, conn net.Conn) {
+			acceptConnect(t, r, conn, 0xc2)
+			flags, id, _ := readPublish(t, r, 1)
+			if flags != 0x0a || id != first {
+				t.Errorf("retried PUBLISH flags = %#x, packet ID %d, want DUP and QoS 1 with ID %d", flags, id, first)
+			}
+			writeMQTT(t, conn, mqttPuback<<4, byte(id>>8), byte(id))
+			expectDisconnect(t, r)
+		})
+
+	sendAndStop(t, newTestMQTT(t, addr, 1, false), done)
+}
+
+func TestMQTTPublishQoS2(t *testing.T) {
+	addr, done := serveMQTT(t, func(t *testing.T, r *bufio.Reader, conn net.Conn) {
+		// QoS 2 keeps the session: the clean session flag is not set
+		acceptConnect(t, r, conn, 0xc0)
+		flags, id, _ := readPublish(t, r, 2)
+		if flags != 0x04 {
+			t.Errorf("PUBLISH flags = %#x, want QoS 2", flags)
+		}
+		writeMQTT(t, conn, mqttPubrec<<4, byte(id>>8), byte(id))
+		expectPacket(t, r, mqttPubrel<<4|2, id)
+		writeMQTT(t, conn, mqttPubcomp<<4, byte(id>>8), byte(id))
+		expectDisconnect(t, r)
+	})
+
+	sendAndStop(t, newTestMQTT(t, addr, 2, false), done)
+}
+
+func TestMQTTPublishQoS2ResumesWithPubrel(t *testing.T) {
+	var first uint16
+	addr, done := serveMQTT(t,
+		func(t *testing.T, r *bufio.Reader, conn net.Conn) {
+			acceptConnect(t, r, conn, 0xc0)
+			_, id, _ := readPublish(t, r, 2)
+			first = id
+			writeMQTT(t, conn, mqttPubrec<<4, byte(id>>8), byte(id))
+			expectPacket(t, r, mqttPubrel<<4|2, id)
+			// The connection is lost before PUBCOMP
+		},
+		func(t *testing.T, r *bufio.Reader, conn net.Conn) {
+			// The broker already has the message, which must not be
+			// published again: the delivery resumes with PUBREL
+			acceptConnect(t, r, conn, 0xc0)
+			expectPacket(t, r, mqttPubrel<<4|2, first)
+			writeMQTT(t, conn, mqttPubcomp<<4, byte(first>>8), byte(first))
+			expectDisconnect(t, r)
+		})
+
+	sendAndStop(t, newTestMQTT(t, addr, 2, false), done)
+}
+
+func TestMQTTConnectRefused(t *testing.T) {
+	addr, done := serveMQTT(t, func(t *testing.T, r *bufio.Reader, conn net.Conn) {
+		readMQTT(t, r)
+		writeMQTT(t, conn, mqttConnack<<4, 0, 4)
+	})
+
+	m := newTestMQTT(t, addr, 0, false)
+	if err := m.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The publishing goroutine has stopped, so connect can be called here
+	err := m.connect()
+	if err == nil || !strings.Contains(err.Error(), "bad user name or password") {
+		t.Errorf("connect error = %v, want a refused connection", err)
+	}
+	if m.conn != nil {
+		t.Error("refused connection was kept open")
+	}
+	<-done
+}
+
+func TestMQTTLength(t *testing.T) {
+	for length, want := range map[int][]byte{
+		0:         {0x00},
+		127:       {0x7f},
+		128:       {0x80, 0x01},
+		16383:     {0xff, 0x7f},
+		16384:     {0x80, 0x80, 0x01},
+		2097151:   {0xff, 0xff, 0x7f},
+		268435455: {0xff, 0xff, 0xff, 0x7f},
+	} {
+		if got := mqttLength(length); !bytes.Equal(got, want) {
+			t.Errorf("mqttLength(%d) = % x, want % x", length, got, want)
+		}
+	}
+}
+
+func TestNewMQTTInvalid(t *testing.T) {
+	log, err := logger.NewLogger("error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, config := range map[string]MQTTConfig{
+		"no host":  {URL: "mqtt://", Topic: "alerts"},
+		"scheme":   {URL: "http://broker", Topic: "alerts"},
+		"qos":      {URL: "mqtt://broker", Topic: "alerts", QoS: 3},
+		"wildcard": {URL: "mqtt://broker", Topic: "alerts/#"},
+		"no topic": {URL: "mqtt://broker"},
+	} {
+		if _, err := NewMQTT(config, log); err == nil {
+			t.Errorf("%s: NewMQTT succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var phoneEscalations = metrics.NewCounter("phone_escalations_total",
+	"Notifications escalated to phone calls, by result: success, failure, acknowledged or dropped.", "result")
+
+// Phone providers
+const (
+	ProviderAsterisk = "asterisk"
+)
+
+// phoneBufferSize is the number of escalations waiting to be called
+const phoneBufferSize = 64
+
+// maxPhoneResponse caps the provider response read for error messages
+const maxPhoneResponse = 4096
+
+// maxSpokenLength caps the text read out in a call, in characters
+const maxSpokenLength = 300
+
+// PhoneConfig holds the phone escalation settings
+type PhoneConfig struct {
+	// Provider is twilio or asterisk
+	Provider string
+
+	// Recipients are the phone numbers called through Twilio, or the
+	// endpoints dialled by Asterisk, e.g. PJSIP/100
+	Recipients []string
+
+	// CallerID is the number calls are placed from
+	CallerID string
+
+	// MinPriority is the lowest priority escalated to a call
+	MinPriority int
+
+	// Delay is the time a notification may stay unacknowledged before the
+	// call is placed; 0 calls right away
+	Delay time.Duration
+
+	// Language is the language of the Twilio text-to-speech voice, e.g.
+	// en-US; empty uses the default voice
+	Language string
+
+	// Timeout bounds each request to the provider
+	Timeout time.Duration
+
+	// APIURL is the Asterisk REST interface, e.g. http://pbx:8088, or
+	// replaces the Twilio API endpoint
+	APIURL string
+
+	// AccountSID and AuthToken are the Twilio credentials
+	AccountSID string
+	AuthToken  string
+
+	// Username and Password authenticate with the Asterisk REST interface
+	Username string
+	Password string
+
+	// Context and Extension are where Asterisk continues the answered
+	// call, reading the ALERT_TEXT channel variable with text-to-speech
+	Context   string
+	Extension string
+}
+
+// Phone calls the on-call recipients about high priority notifications
+// nobody acknowledged in time, as the last step of paging. The call reads
+// out the title of the notification. The escalations waiting for
+// acknowledgement are kept in an Escalations store shared with the sinks
+// replacing this one on reload.
+type Phone struct {
+	config      PhoneConfig
+	escalations *Escalations
+	client      *http.Client
+	logger      *logger.Logger
+
+	calls chan *Escalation
+	done  chan struct{}
+
+	// mutex guards calls against sends after Stop
+	mutex  sync.Mutex
+	closed bool
+}
+
+// NewPhone creates a phone escalation sink and starts calling the
+// escalations of the store that are due
+func NewPhone(config PhoneConfig, escalations *Escalations, logger *logger.Logger) (*Phone, error) {
+	switch config.Provider {
+	case ProviderTwilio:
+		if config.AccountSID == "" || config.AuthToken == "" || config.CallerID == "" {
+			return nil, errors.New("Twilio requires an account SID, auth token and caller ID")
+		}
+		if config.APIURL == "" {
+			config.APIURL = twilioAPIURL
+		}
+	case ProviderAsterisk:
+		if config.APIURL == "" || config.Username == "" || config.Password == "" {
+			return nil, errors.New("Asterisk requires the REST interface URL, username and password")
+		}
+	default:
+		return nil, fmt.Errorf("unknown phone provider %q, expected twilio or asterisk", config.Provider)
+	}
+	if len(config.Recipients) == 0 {
+		return nil, errors.New("no phone recipients")
+	}
+	config.APIURL = strings.TrimSuffix(config.APIURL, "/")
+
+	p := &Phone{
+		config:      config,
+		escalations: escalations,
+		client:      &http.Client{Timeout: config.Timeout},
+		logger:      logger,
+		calls:       make(chan *Escalation, phoneBufferSize),
+		done:        make(chan struct{}),
+	}
+
+	go p.run()
+	escalations.attach(p)
+	return p, nil
+}
+
+// Name implements Sink
+func (p *Phone) Name() string {
+	return "phone"
+}
+
+// Send implements Sink. Notifications below the minimum priority are
+// ignored; others are escalated unless acknowledged within the delay.
+func (p *Phone) Send(ctx context.Context, msg *Message) error {
+	if msg.Priority < p.config.MinPriority {
+		return nil
+	}
+
+	p.mutex.Lock()
+	closed := p.closed
+	p.mutex.Unlock()
+	if closed {
+		return errors.New("phone sink stopped")
+	}
+
+	id := msg.RequestID
+	if id == "" {
+		id = newEscalationID()
+	}
+
+	now := time.Now()
+	e := &Escalation{
+		ID:           id,
+		Route:        msg.Route,
+		Title:        msg.Title,
+		Priority:     msg.Priority,
+		Created:      now,
+		Due:          now.Add(p.config.Delay),
+		Fingerprints: msg.Fingerprints,
+		msg:          msg,
+	}
+	if !p.escalations.add(e, p.config.Delay) {
+		// A replayed notification does not call twice
+		return nil
+	}
+
+	p.logger.WithContext(ctx).Info("Notification escalates to a phone call unless acknowledged", "id", id, "route", msg.Route, "due", e.Due.Format(time.RFC3339))
+	return nil
+}
+
+// call queues the call of an escalation that is due
+func (p *Phone) call(e *Escalation) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		phoneEscalations.Inc("dropped")
+		p.logger.Warn("Phone sink stopped, escalation dropped", "id", e.ID, "route", e.Route)
+		return
+	}
+
+	select {
+	case p.calls <- e:
+	default:
+		phoneEscalations.Inc("dropped")
+		p.logger.Error("Too many phone calls waiting, escalation dropped", "id", e.ID, "route", e.Route)
+	}
+}
+
+// Stop hands the pending escalations over to the sink replacing this one,
+// if any, and waits for the calls being placed
+func (p *Phone) Stop(ctx context.Context) error {
+	p.escalations.detach(p)
+
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.calls)
+	}
+	p.mutex.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run places the calls of escalations that are due
+func (p *Phone) run() {
+	defer close(p.done)
+
+	for e := range p.calls {
+		ctx := logger.WithRequestID(context.Background(), e.ID)
+		log := p.logger.WithContext(ctx)
+
+		text := spokenText(e.msg)
+		var failed []string
+		for _, recipient := range p.config.Recipients {
+			var err error
+			if p.config.Provider == ProviderAsterisk {
+				err = p.callAsterisk(ctx, recipient, e.msg, text)
+			} else {
+				err = p.callTwilio(ctx, recipient, text)
+			}
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", recipient, err))
+			}
+		}
+
+		if len(failed) == len(p.config.Recipients) {
+			phoneEscalations.Inc("failure")
+			log.Error("Failed to place escalation calls", "provider", p.config.Provider, "route", e.Route, "error", strings.Join(failed, "; "))
+			continue
+		}
+		if len(failed) > 0 {
+			log.Warn("Failed to call some recipients", "provider", p.config.Provider, "route", e.Route, "error", strings.Join(failed, "; "))
+		}
+
+		phoneEscalations.Inc("success")
+		log.Warn("Unacknowledged notification escalated to a phone call", "provider", p.config.Provider, "route", e.Route, "recipients", len(p.config.Recipients)-len(failed))
+	}
+}
+
+// callTwilio calls a number through Twilio, reading out the text
+func (p *Phone) callTwilio(ctx context.Context, recipient, text string) error {
+	var twiml bytes.Buffer
+	twiml.WriteString(`<Response><Say loop="2"`)
+	if p.config.Language != "" {
+		twiml.WriteString(` language="`)
+		xml.EscapeText(&twiml, []byte(p.config.Language))
+		twiml.WriteString(`"`)
+	}
+	twiml.WriteString(">")
+	xml.EscapeText(&twiml, []byte(text))
+	twiml.WriteString("</Say></Response>")
+
+	form := url.Values{
+		"To":    {recipient},
+		"From":  {p.config.CallerID},
+		"Twiml": {twiml.String()},
+	}
+
+	endpoint := p.config.APIURL + "/2010-04-01/Accounts/" + url.PathEscape(p.config.AccountSID) + "/Calls.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.config.AccountSID, p.config.AuthToken)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var result struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxPhoneResponse))
+		json.Unmarshal(body, &result)
+		return fmt.Errorf("Twilio responded with status %d %s", resp.StatusCode, result.Message)
+	}
+	return nil
+}
+
+// callAsterisk originates a call to an endpoint through the Asterisk REST
+// interface. The answered call continues in the configured context and
+// extension, with the text in the ALERT_TEXT channel variable.
+func (p *Phone) callAsterisk(ctx context.Context, endpoint string, msg *Message, text string) error {
+	query := url.Values{
+		"endpoint":  {endpoint},
+		"context":   {p.config.Context},
+		"extension": {p.config.Extension},
+		"priority":  {"1"},
+	}
+	if p.config.CallerID != "" {
+		query.Set("callerId", p.config.CallerID)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"variables": map[string]string{
+			"ALERT_TEXT":     text,
+			"ALERT_ROUTE":    msg.Route,
+			"ALERT_PRIORITY": strconv.Itoa(msg.Priority),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.APIURL+"/ari/channels?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(p.config.Username, p.config.Password)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var result struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxPhoneResponse))
+		json.Unmarshal(body, &result)
+		return fmt.Errorf("Asterisk responded with status %d %s", resp.StatusCode, result.Message)
+	}
+	return nil
+}
+
+// spokenText renders a notification for text-to-speech: its priority,
+// route and title, or the first line of the message without a title
+func spokenText(msg *Message) string {
+	subject := msg.Title
+	if subject == "" {
+		subject = firstLine(msg.Message)
+	}
+	if subject == "" {
+		subject = firstLine(msg.Text)
+	}
+
+	text := "Unacknowledged alert from " + msg.Route + ", priority " + strconv.Itoa(msg.Priority) + ": " + subject
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxSpokenLength {
+		text = string(runes[:maxSpokenLength])
+	}
+	return text
+}
+
+// newEscalationID returns a random ID for notifications without a request ID
+func newEscalationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
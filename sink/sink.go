@@ -0,0 +1,60 @@
+// Package sink delivers rendered notifications to destinations besides
+// Mizito, such as a local file. Every notification that passes the
+// pipeline is handed to the configured sinks alongside its delivery to
+// Mizito; a failing sink does not fail the notification.
+package sink
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Message is a rendered notification as handed to sinks
+type Message struct {
+	Time      time.Time `json:"time"`
+	Route     string    `json:"route"`
+	Title     string    `json:"title,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Priority  int       `json:"priority"`
+	DialogID  string    `json:"dialog_id,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+
+	// Text is the message as forwarded to Mizito, after templates,
+	// enrichment and the content policy
+	Text string `json:"text"`
+
+	// Fingerprints are the firing alerts of a notification of an alerting
+	// system, as listed under /alerts
+	Fingerprints []string `json:"-"`
+}
+
+// Sink is a destination of notifications
+type Sink interface {
+	// Name identifies the sink in logs and metrics
+	Name() string
+
+	// Send delivers a message
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Failover is implemented by sinks that can stand in for Mizito. When
+// Failover returns true, the sink receives only the notifications Mizito
+// failed to take instead of every notification.
+type Failover interface {
+	Failover() bool
+}
+
+// Deliverer is implemented by sinks that send in the background but can
+// also send a message and wait for the outcome, as needed when trying the
+// targets of a delivery chain in turn. Sinks without it count as having
+// taken a message once Send returns.
+type Deliverer interface {
+	// Deliver sends a message, returning ErrSkipped when the sink does not
+	// take messages like it
+	Deliver(ctx context.Context, msg *Message) error
+}
+
+// ErrSkipped is returned by Deliver for a message the sink does not take,
+// e.g. of a route without a chat or below the minimum priority
+var ErrSkipped = errors.New("not taken by this sink")
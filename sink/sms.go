@@ -0,0 +1,366 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+var smsSent = metrics.NewCounter("sms_sink_messages_total",
+	"Notifications sent by the SMS sink, by result: success, failure, dropped or rate_limited.", "result")
+
+// SMS providers
+const (
+	ProviderKavenegar = "kavenegar"
+	ProviderTwilio    = "twilio"
+)
+
+// Default API endpoints of the SMS providers
+const (
+	kavenegarAPIURL = "https://api.kavenegar.com"
+	twilioAPIURL    = "https://api.twilio.com"
+)
+
+// smsBufferSize is the number of messages waiting to be sent
+const smsBufferSize = 64
+
+// maxSMSResponse caps the provider response read for error messages
+const maxSMSResponse = 4096
+
+// SMSConfig holds the SMS sink settings
+type SMSConfig struct {
+	// Provider is kavenegar or twilio
+	Provider string
+
+	// Recipients are the phone numbers receiving the messages
+	Recipients []string
+
+	// Sender is the sending line of Kavenegar or the From number of Twilio
+	Sender string
+
+	// MinPriority is the lowest priority sent as SMS
+	MinPriority int
+
+	// MaxLength caps the message length in characters
+	MaxLength int
+
+	// MaxPerHour caps the messages sent per hour, so an alert storm does
+	// not run up the bill; 0 means unlimited
+	MaxPerHour int
+
+	// Timeout bounds each request to the provider
+	Timeout time.Duration
+
+	// APIURL replaces the API endpoint of the provider, e.g. for a proxy
+	APIURL string
+
+	// APIKey is the Kavenegar API key
+	APIKey string
+
+	// AccountSID and AuthToken are the Twilio credentials
+	AccountSID string
+	AuthToken  string
+}
+
+// SMS sends a compact rendering of high priority notifications as SMS.
+// Messages are sent in the background, so a slow gateway does not hold up
+// delivery to Mizito.
+type SMS struct {
+	config SMSConfig
+	client *http.Client
+	logger *logger.Logger
+
+	messages chan *Message
+	done     chan struct{}
+
+	// mutex guards messages against sends after Stop
+	mutex  sync.RWMutex
+	closed bool
+
+	// sent holds the send times of the last hour, for MaxPerHour, guarded
+	// by sentMutex
+	sentMutex sync.Mutex
+	sent      []time.Time
+}
+
+// NewSMS creates an SMS sink and starts sending
+func NewSMS(config SMSConfig, logger *logger.Logger) (*SMS, error) {
+	switch config.Provider {
+	case ProviderKavenegar:
+		if config.APIKey == "" {
+			return nil, errors.New("Kavenegar requires an API key")
+		}
+		if config.APIURL == "" {
+			config.APIURL = kavenegarAPIURL
+		}
+	case ProviderTwilio:
+		if config.AccountSID == "" || config.AuthToken == "" || config.Sender == "" {
+			return nil, errors.New("Twilio requires an account SID, auth token and sender number")
+		}
+		if config.APIURL == "" {
+			config.APIURL = twilioAPIURL
+		}
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q, expected kavenegar or twilio", config.Provider)
+	}
+	if len(config.Recipients) == 0 {
+		return nil, errors.New("no SMS recipients")
+	}
+	config.APIURL = strings.TrimSuffix(config.APIURL, "/")
+
+	s := &SMS{
+		config:   config,
+		client:   &http.Client{Timeout: config.Timeout},
+		logger:   logger,
+		messages: make(chan *Message, smsBufferSize),
+		done:     make(chan struct{}),
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Name implements Sink
+func (s *SMS) Name() string {
+	return "sms"
+}
+
+// Send implements Sink. Messages below the minimum priority are ignored;
+// others are queued for sending.
+func (s *SMS) Send(ctx context.Context, msg *Message) error {
+	if msg.Priority < s.config.MinPriority {
+		return nil
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.closed {
+		return errors.New("SMS sink stopped")
+	}
+
+	select {
+	case s.messages <- msg:
+		return nil
+	default:
+		smsSent.Inc("dropped")
+		return fmt.Errorf("%d messages waiting for the SMS gateway, notification dropped", smsBufferSize)
+	}
+}
+
+// Stop sends the queued messages
+func (s *SMS) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.messages)
+	}
+	s.mutex.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run sends queued messages
+func (s *SMS) run() {
+	defer close(s.done)
+
+	for msg := range s.messages {
+		ctx := context.Background()
+		if msg.RequestID != "" {
+			ctx = logger.WithRequestID(ctx, msg.RequestID)
+		}
+		s.deliver(ctx, msg)
+	}
+}
+
+// Deliver implements Deliverer
+func (s *SMS) Deliver(ctx context.Context, msg *Message) error {
+	if msg.Priority < s.config.MinPriority {
+		return ErrSkipped
+	}
+	return s.deliver(ctx, msg)
+}
+
+// deliver sends a message within MaxPerHour, logging and counting the
+// outcome
+func (s *SMS) deliver(ctx context.Context, msg *Message) error {
+	log := s.logger.WithContext(ctx)
+
+	if !s.allow(time.Now()) {
+		smsSent.Inc("rate_limited")
+		log.Warn("SMS rate limit reached, notification not sent as SMS", "route", msg.Route, "max_per_hour", s.config.MaxPerHour)
+		return fmt.Errorf("SMS rate limit of %d per hour reached", s.config.MaxPerHour)
+	}
+
+	text := compactText(msg, s.config.MaxLength)
+	var err error
+	if s.config.Provider == ProviderKavenegar {
+		err = s.sendKavenegar(ctx, text)
+	} else {
+		err = s.sendTwilio(ctx, text)
+	}
+	if err != nil {
+		smsSent.Inc("failure")
+		log.Warn("Failed to send SMS", "provider", s.config.Provider, "route", msg.Route, "error", err)
+		return err
+	}
+
+	smsSent.Inc("success")
+	log.Info("Notification sent as SMS", "provider", s.config.Provider, "route", msg.Route, "recipients", len(s.config.Recipients))
+	return nil
+}
+
+// allow reports whether another message may be sent within MaxPerHour
+func (s *SMS) allow(now time.Time) bool {
+	if s.config.MaxPerHour <= 0 {
+		return true
+	}
+
+	s.sentMutex.Lock()
+	defer s.sentMutex.Unlock()
+
+	recent := s.sent[:0]
+	for _, t := range s.sent {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	s.sent = recent
+
+	if len(s.sent) >= s.config.MaxPerHour {
+		return false
+	}
+	s.sent = append(s.sent, now)
+	return true
+}
+
+// sendKavenegar sends a message to all recipients with one Kavenegar request
+func (s *SMS) sendKavenegar(ctx context.Context, text string) error {
+	form := url.Values{
+		"receptor": {strings.Join(s.config.Recipients, ",")},
+		"message":  {text},
+	}
+	if s.config.Sender != "" {
+		form.Set("sender", s.config.Sender)
+	}
+
+	endpoint := s.config.APIURL + "/v1/" + url.PathEscape(s.config.APIKey) + "/sms/send.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The API key is part of the URL
+		return fmt.Errorf("request to Kavenegar failed: %w", errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Return struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"return"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxSMSResponse))
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("Kavenegar responded with status %d", resp.StatusCode)
+	}
+	if result.Return.Status != http.StatusOK {
+		return fmt.Errorf("Kavenegar responded with status %d: %s", result.Return.Status, result.Return.Message)
+	}
+	return nil
+}
+
+// sendTwilio sends a message to each recipient through Twilio
+func (s *SMS) sendTwilio(ctx context.Context, text string) error {
+	endpoint := s.config.APIURL + "/2010-04-01/Accounts/" + url.PathEscape(s.config.AccountSID) + "/Messages.json"
+
+	var failed []string
+	for _, recipient := range s.config.Recipients {
+		form := url.Values{
+			"To":   {recipient},
+			"From": {s.config.Sender},
+			"Body": {text},
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(s.config.AccountSID, s.config.AuthToken)
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", recipient, err))
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxSMSResponse))
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			var result struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(body, &result)
+			failed = append(failed, fmt.Sprintf("%s: status %d %s", recipient, resp.StatusCode, result.Message))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Twilio rejected %d of %d messages: %s", len(failed), len(s.config.Recipients), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// compactText renders a message for SMS: the priority, route and title,
+// followed by the first line of the message, cut to maxLength characters
+func compactText(msg *Message, maxLength int) string {
+	title, body := msg.Title, firstLine(msg.Message)
+	if title == "" && body == "" {
+		body = firstLine(msg.Text)
+	}
+
+	text := "P" + strconv.Itoa(msg.Priority) + " " + msg.Route
+	switch {
+	case title != "" && body != "":
+		text += ": " + title + " - " + body
+	default:
+		text += ": " + title + body
+	}
+	text = strings.Join(strings.Fields(text), " ")
+
+	if runes := []rune(text); maxLength > 0 && len(runes) > maxLength {
+		text = string(runes[:maxLength-1]) + "…"
+	}
+	return text
+}
+
+// firstLine returns the first non-empty line of a text
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
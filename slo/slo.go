@@ -0,0 +1,248 @@
+// Package slo measures the delivery outcomes of each route against its
+// objectives, for the share of successful deliveries and of those delivered
+// in time, and announces routes burning their error budget too fast.
+package slo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+)
+
+// Service level indicators
+const (
+	// Success is the share of deliveries that succeeded
+	Success = "success"
+
+	// Latency is the share of successful deliveries faster than SLO_LATENCY
+	Latency = "latency"
+)
+
+var (
+	sloEvents = metrics.NewCounter("delivery_slo_events_total",
+		"Deliveries counted against the delivery objectives, by route, indicator and result: good or bad.", "route", "sli", "result")
+	sloRatio = metrics.NewGauge("delivery_slo_ratio",
+		"Share of good deliveries within SLO_WINDOW, by route and indicator.", "route", "sli")
+	sloObjective = metrics.NewGauge("delivery_slo_objective",
+		"Objective of the share of good deliveries, by route and indicator.", "route", "sli")
+	sloBurnRate = metrics.NewGauge("delivery_slo_burn_rate",
+		"Rate the error budget is spent within SLO_WINDOW, 1 spending it exactly over the window, by route and indicator.", "route", "sli")
+	sloViolating = metrics.NewGauge("delivery_slo_violating",
+		"Whether a route burns its error budget at SLO_ALERT_BURN_RATE or faster (1) or not (0), by route and indicator.", "route", "sli")
+)
+
+// bucket counts the deliveries of a route in one minute
+type bucket struct {
+	minute    int64
+	total     int
+	delivered int
+	slow      int
+}
+
+// Tracker counts the delivery outcomes of the routes with an objective
+// over the last SLO_WINDOW and announces violations of the objectives
+type Tracker struct {
+	config   *config.Config
+	messages *mizito.MessageService
+	logger   *logger.Logger
+
+	mutex     sync.Mutex
+	buckets   map[string][]bucket
+	violating map[string]bool
+}
+
+// New creates a tracker of the objectives of config
+func New(config *config.Config, messages *mizito.MessageService, logger *logger.Logger) *Tracker {
+	return &Tracker{
+		config:    config,
+		messages:  messages,
+		logger:    logger,
+		buckets:   make(map[string][]bucket),
+		violating: make(map[string]bool),
+	}
+}
+
+// Record counts the outcome of a delivery of a route, which took latency
+// from receiving the notification until it was delivered or given up
+func (t *Tracker) Record(route string, delivered bool, latency time.Duration) {
+	if t.config.RouteSLOTarget(route) <= 0 {
+		return
+	}
+
+	slow := delivered && latency > t.config.SLOLatency
+	sloEvents.Inc(route, Success, result(delivered))
+	if delivered {
+		sloEvents.Inc(route, Latency, result(!slow))
+	}
+
+	minute := time.Now().Unix() / 60
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	buckets := t.buckets[route]
+	if n := len(buckets); n == 0 || buckets[n-1].minute != minute {
+		buckets = append(buckets, bucket{minute: minute})
+	}
+	b := &buckets[len(buckets)-1]
+	b.total++
+	if delivered {
+		b.delivered++
+	}
+	if slow {
+		b.slow++
+	}
+	t.buckets[route] = buckets
+}
+
+// result names the result of an event
+func result(good bool) string {
+	if good {
+		return "good"
+	}
+	return "bad"
+}
+
+// Run checks the objectives every SLO_CHECK_INTERVAL until ctx is cancelled
+func (t *Tracker) Run(ctx context.Context) {
+	t.logger.Info("Delivery SLO tracking started", "target", t.config.SLOTarget, "window", t.config.SLOWindow)
+	defer t.logger.Info("Delivery SLO tracking stopped")
+
+	ticker := time.NewTicker(t.config.SLOCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		t.check(ctx, time.Now())
+	}
+}
+
+// window holds the counts of a route within the window
+type window struct {
+	total     int
+	delivered int
+	slow      int
+}
+
+// counts drops the buckets that left the window and sums up the others
+func (t *Tracker) counts(now time.Time) map[string]window {
+	oldest := now.Add(-t.config.SLOWindow).Unix() / 60
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	counts := make(map[string]window, len(t.buckets))
+	for route, buckets := range t.buckets {
+		for len(buckets) > 0 && buckets[0].minute <= oldest {
+			buckets = buckets[1:]
+		}
+		t.buckets[route] = buckets
+
+		var w window
+		for _, b := range buckets {
+			w.total += b.total
+			w.delivered += b.delivered
+			w.slow += b.slow
+		}
+		counts[route] = w
+	}
+	return counts
+}
+
+// check updates the metrics of the objectives and announces the routes that
+// started or stopped violating one
+func (t *Tracker) check(ctx context.Context, now time.Time) {
+	counts := t.counts(now)
+
+	routes := make([]string, 0, len(counts))
+	for route := range counts {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		w := counts[route]
+		t.evaluate(ctx, route, Success, w.total, w.total-w.delivered, t.config.RouteSLOTarget(route))
+		t.evaluate(ctx, route, Latency, w.delivered, w.slow, t.config.SLOLatencyTarget)
+	}
+}
+
+// evaluate compares the bad events of an indicator with its objective
+func (t *Tracker) evaluate(ctx context.Context, route, sli string, total, bad int, objective float64) {
+	if objective <= 0 {
+		return
+	}
+	sloObjective.Set(objective, route, sli)
+
+	// Without deliveries in the window, no budget is spent
+	ratio, burnRate := 1.0, 0.0
+	if total > 0 {
+		ratio = float64(total-bad) / float64(total)
+		burnRate = (1 - ratio) / (1 - objective)
+		sloRatio.Set(ratio, route, sli)
+	}
+	sloBurnRate.Set(burnRate, route, sli)
+
+	key := route + "/" + sli
+	t.mutex.Lock()
+	wasViolating := t.violating[key]
+	t.mutex.Unlock()
+
+	violating := burnRate >= t.config.SLOAlertBurnRate && total >= t.config.SLOMinDeliveries
+	if violating == wasViolating {
+		return
+	}
+	sloViolating.Set(metrics.BoolValue(violating), route, sli)
+
+	var text string
+	if violating {
+		t.logger.Warn("Delivery SLO violated", "route", route, "sli", sli, "ratio", ratio, "objective", objective, "burn_rate", burnRate)
+		text = fmt.Sprintf("📉 Delivery SLO of route %s violated: %s\nError budget burning %.1fx as fast as allowed",
+			route, describe(sli, ratio, total, objective, t.config), burnRate)
+	} else {
+		t.logger.Info("Delivery SLO met again", "route", route, "sli", sli, "ratio", ratio, "objective", objective, "burn_rate", burnRate)
+		text = fmt.Sprintf("📈 Delivery SLO of route %s met again: %s", route, describe(sli, ratio, total, objective, t.config))
+	}
+
+	err := t.messages.Send(ctx, &mizito.Message{
+		Text:     text,
+		Priority: t.config.SLOPriority,
+		DialogID: t.messages.DialogForPriority(t.config.SLOPriority),
+	})
+	if err != nil {
+		// Announced again on the next check
+		t.logger.Error("Failed to announce delivery SLO", "route", route, "sli", sli, "error", err)
+		return
+	}
+
+	t.mutex.Lock()
+	t.violating[key] = violating
+	t.mutex.Unlock()
+}
+
+// describe renders the state of an indicator, e.g. "91.2% of 34 deliveries
+// succeeded in the last 1h0m0s (objective 99%)"
+func describe(sli string, ratio float64, total int, objective float64, config *config.Config) string {
+	if total == 0 {
+		return fmt.Sprintf("no deliveries in the last %s (objective %g%%)", config.SLOWindow, objective*100)
+	}
+
+	what := "succeeded"
+	if sli == Latency {
+		what = fmt.Sprintf("took at most %s", config.SLOLatency)
+	}
+	return fmt.Sprintf("%.1f%% of %d deliveries %s in the last %s (objective %g%%)",
+		ratio*100, total, what, config.SLOWindow, objective*100)
+}
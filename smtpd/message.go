@@ -0,0 +1,271 @@
+package smtpd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// wordDecoder decodes RFC 2047 header words, such as Persian subjects, in
+// any charset known to browsers
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// charsetReader converts text in a charset, e.g. windows-1256, to UTF-8
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return input, nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// email is the content of a received email
+type email struct {
+	subject     string
+	from        string
+	messageID   string
+	priority    int
+	text        string
+	html        string
+	attachments []render.Attachment
+}
+
+// notification converts the email into a notification of RouteName, sent
+// to recipients. Emails without a plain text part are forwarded as the
+// text of their HTML part.
+func (e *email) notification(recipients []string) *render.Notification {
+	text := strings.TrimSpace(e.text)
+	if text == "" && e.html != "" {
+		text = htmlText(e.html)
+	}
+
+	return &render.Notification{
+		Route:       RouteName,
+		Title:       e.subject,
+		Message:     text,
+		Priority:    e.priority,
+		Time:        time.Now(),
+		Source:      RouteName,
+		Attachments: e.attachments,
+		Extras: map[string]interface{}{
+			"from":       e.from,
+			"to":         strings.Join(recipients, ", "),
+			"message_id": e.messageID,
+		},
+	}
+}
+
+// readEmail parses an email. priority is the priority of emails not
+// setting one with X-Priority or Importance; maxAttachments caps the total
+// size of the attached files in bytes.
+func readEmail(r io.Reader, priority, maxAttachments int) (*email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &email{
+		subject:   decodeHeader(msg.Header.Get("Subject")),
+		from:      decodeHeader(msg.Header.Get("From")),
+		messageID: strings.Trim(msg.Header.Get("Message-Id"), "<>"),
+		priority:  emailPriority(msg.Header, priority),
+	}
+
+	remaining := maxAttachments
+	if err := e.readPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", msg.Body, &remaining); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// readPart reads a part of an email: the text of text parts, the files of
+// attachments, and the parts of multipart content. remaining is the size
+// left for attachments.
+func (e *email) readPart(contentType, transferEncoding, disposition string, body io.Reader, remaining *int) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("invalid multipart body: %w", err)
+			}
+
+			err = e.readPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part.Header.Get("Content-Disposition"), part, remaining)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	body = decodeTransfer(transferEncoding, body)
+
+	// Attachments and parts other than text are forwarded as files
+	dispositionType, dispositionParams, _ := mime.ParseMediaType(disposition)
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if dispositionType == "attachment" || filename != "" || !strings.HasPrefix(mediaType, "text/") {
+		data, err := io.ReadAll(io.LimitReader(body, int64(*remaining)+1))
+		if err != nil {
+			return err
+		}
+		if len(data) > *remaining {
+			return errTooLarge
+		}
+		*remaining -= len(data)
+
+		if filename == "" {
+			filename = "attachment" + extension(mediaType)
+		}
+		e.attachments = append(e.attachments, render.Attachment{
+			Name:        decodeHeader(filename),
+			ContentType: mediaType,
+			Data:        data,
+		})
+		return nil
+	}
+
+	reader, err := charsetReader(params["charset"], body)
+	if err != nil {
+		return err
+	}
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	// The first plain text and HTML parts are the body; alternatives in
+	// later parts are skipped
+	switch {
+	case mediaType == "text/html" && e.html == "":
+		e.html = string(text)
+	case mediaType != "text/html" && e.text == "":
+		e.text = string(text)
+	}
+	return nil
+}
+
+// decodeTransfer decodes a part in a Content-Transfer-Encoding. Quoted
+// printable parts of multipart bodies are decoded by mime/multipart
+// already.
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// decodeHeader decodes the RFC 2047 words of a header value
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// extension returns the usual file extension of a media type
+func extension(mediaType string) string {
+	extensions, _ := mime.ExtensionsByType(mediaType)
+	if len(extensions) == 0 {
+		return ""
+	}
+	return extensions[0]
+}
+
+// emailPriority maps the X-Priority (1 highest to 5 lowest) or Importance
+// (high, normal, low) header of an email to a Gotify priority
+func emailPriority(header mail.Header, fallback int) int {
+	if value := strings.TrimSpace(header.Get("X-Priority")); value != "" {
+		// Values such as "1 (Highest)" carry a description
+		level, err := strconv.Atoi(strings.Fields(value)[0])
+		if err == nil {
+			switch {
+			case level <= 2:
+				return 8
+			case level >= 4:
+				return 2
+			default:
+				return fallback
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(header.Get("Importance"))) {
+	case "high":
+		return 8
+	case "low":
+		return 2
+	}
+	return fallback
+}
+
+var (
+	// htmlHidden matches the elements of HTML whose content is not text
+	htmlHidden = regexp.MustCompile(`(?is)<(style|script|head)[^>]*>.*?</(style|script|head)>`)
+	// htmlBreak matches the elements of HTML ending a line
+	htmlBreak = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/h[1-6]|/li)[^>]*>`)
+	// htmlTag matches any other tag
+	htmlTag = regexp.MustCompile(`<[^>]*>`)
+	// blankLines matches runs of blank lines
+	blankLines = regexp.MustCompile(`\n\s*\n\s*`)
+)
+
+// htmlText extracts the text of an HTML body, one line per paragraph
+func htmlText(body string) string {
+	body = htmlHidden.ReplaceAllString(body, "")
+	body = htmlBreak.ReplaceAllString(body, "\n")
+	body = html.UnescapeString(htmlTag.ReplaceAllString(body, ""))
+
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// readAll reads the content of DATA, failing with errTooLarge beyond limit
+// bytes; the rest of the content is drained so the session can go on
+func readAll(r io.Reader, limit int) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(r, int64(limit)+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() > limit {
+		io.Copy(io.Discard, r)
+		return nil, errTooLarge
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,518 @@
+// Package smtpd forwards emails to Mizito, so appliances that can only send
+// alerts by email reach the chat without a mail server in between. It
+// embeds a minimal SMTP server accepting mail for any recipient, with
+// optional AUTH PLAIN and LOGIN and STARTTLS when HTTPS is configured, and
+// submits the subject and body of each email as a notification.
+package smtpd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// RouteName is the route of forwarded emails, e.g. for ROUTE_SMTP_TEMPLATE
+const RouteName = "smtp"
+
+// Limits of a session
+const (
+	// commandTimeout closes sessions idle for longer
+	commandTimeout = 5 * time.Minute
+
+	// maxRecipients caps the recipients of an email
+	maxRecipients = 100
+
+	// maxLineLength caps command lines, as RFC 5321 does
+	maxLineLength = 512
+
+	// headerAllowance is the size allowed for the headers and text of an
+	// email on top of MAX_ATTACHMENT_SIZE, as for multipart uploads
+	headerAllowance = 1 << 20
+)
+
+// errTooLarge is returned for emails exceeding the size limit
+var errTooLarge = errors.New("message too large")
+
+var emails = metrics.NewCounter("smtp_messages_total",
+	"Emails received by the SMTP listener by result: forwarded, rejected or failed.", "result")
+
+// Submitter delivers a notification, like handler.Handler.Submit
+type Submitter func(ctx context.Context, n *render.Notification) error
+
+// Server is the SMTP listener
+type Server struct {
+	config    *config.Config
+	submit    Submitter
+	tlsConfig *tls.Config
+	logger    *logger.Logger
+	listener  net.Listener
+
+	mutex    sync.Mutex
+	sessions map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// New listens on SMTP_PORT. tlsConfig enables STARTTLS; it is nil without
+// HTTPS.
+func New(cfg *config.Config, submit Submitter, tlsConfig *tls.Config, logger *logger.Logger) (*Server, error) {
+	listener, err := net.Listen("tcp", cfg.SMTPPort)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		config:    cfg,
+		submit:    submit,
+		tlsConfig: tlsConfig,
+		logger:    logger.With("component", "smtp"),
+		listener:  listener,
+		sessions:  make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Run accepts connections until ctx is cancelled, then closes the open
+// sessions and waits for them to end
+func (s *Server) Run(ctx context.Context) {
+	s.logger.Info("SMTP listener started", "address", s.listener.Addr().String(), "auth", s.config.SMTPUsername != "", "starttls", s.tlsConfig != nil)
+	defer s.logger.Info("SMTP listener stopped")
+
+	go func() {
+		<-ctx.Done()
+		s.listener.Close()
+
+		s.mutex.Lock()
+		for conn := range s.sessions {
+			conn.Close()
+		}
+		s.mutex.Unlock()
+	}()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			s.logger.Error("Failed to accept SMTP connection", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		s.mutex.Lock()
+		s.sessions[conn] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(ctx, conn)
+
+			s.mutex.Lock()
+			delete(s.sessions, conn)
+			s.mutex.Unlock()
+		}()
+	}
+
+	s.wg.Wait()
+}
+
+// session is the state of an SMTP connection
+type session struct {
+	server *Server
+	conn   net.Conn
+	text   *textproto.Conn
+	log    *logger.Logger
+
+	helo          string
+	tls           bool
+	authenticated bool
+
+	// from and recipients are the envelope of the current email
+	from       string
+	recipients []string
+}
+
+// serve runs an SMTP session
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	sess := &session{
+		server: s,
+		conn:   conn,
+		text:   textproto.NewConn(conn),
+		log:    s.logger.With("remote", conn.RemoteAddr().String()),
+	}
+	sess.log.Debug("SMTP session opened")
+
+	sess.reply(220, "%s ESMTP Mizito Forwarder", s.config.SMTPDomain)
+	for {
+		conn.SetDeadline(time.Now().Add(commandTimeout))
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			sess.log.Debug("SMTP session closed", "error", err)
+			return
+		}
+		if len(line) > maxLineLength {
+			sess.reply(500, "5.5.2 Line too long")
+			continue
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		if !sess.handle(ctx, strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			return
+		}
+	}
+}
+
+// handle runs a command and reports whether the session goes on
+func (sess *session) handle(ctx context.Context, verb, arg string) bool {
+	switch verb {
+	case "EHLO", "HELO":
+		sess.hello(verb, arg)
+	case "STARTTLS":
+		return sess.startTLS()
+	case "AUTH":
+		sess.auth(arg)
+	case "MAIL":
+		sess.mail(arg)
+	case "RCPT":
+		sess.rcpt(arg)
+	case "DATA":
+		return sess.data(ctx)
+	case "RSET":
+		sess.reset()
+		sess.reply(250, "2.0.0 OK")
+	case "NOOP":
+		sess.reply(250, "2.0.0 OK")
+	case "VRFY":
+		sess.reply(252, "2.5.0 Cannot VRFY user, but will accept message")
+	case "HELP":
+		sess.reply(214, "2.0.0 See RFC 5321")
+	case "QUIT":
+		sess.reply(221, "2.0.0 Bye")
+		return false
+	default:
+		sess.reply(502, "5.5.2 Command not recognized")
+	}
+	return true
+}
+
+// hello answers EHLO and HELO, listing the extensions for EHLO
+func (sess *session) hello(verb, arg string) {
+	if arg == "" {
+		sess.reply(501, "5.5.4 Domain required")
+		return
+	}
+	sess.helo = arg
+	sess.reset()
+
+	domain := sess.server.config.SMTPDomain
+	if verb == "HELO" {
+		sess.reply(250, "%s", domain)
+		return
+	}
+
+	lines := []string{domain, "PIPELINING", "8BITMIME", "SIZE " + strconv.Itoa(sess.maxSize())}
+	if sess.server.tlsConfig != nil && !sess.tls {
+		lines = append(lines, "STARTTLS")
+	}
+	if sess.authRequired() && !sess.authenticated && sess.authAllowed() {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+	sess.replyLines(250, lines)
+}
+
+// startTLS upgrades the connection to TLS and restarts the session
+func (sess *session) startTLS() bool {
+	if sess.server.tlsConfig == nil || sess.tls {
+		sess.reply(502, "5.5.1 STARTTLS not available")
+		return true
+	}
+
+	sess.reply(220, "2.0.0 Ready to start TLS")
+	conn := tls.Server(sess.conn, sess.server.tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		sess.log.Warn("SMTP TLS handshake failed", "error", err)
+		return false
+	}
+
+	sess.conn = conn
+	sess.text = textproto.NewConn(conn)
+	sess.tls = true
+	sess.helo = ""
+	sess.reset()
+	return true
+}
+
+// authRequired reports whether clients must authenticate
+func (sess *session) authRequired() bool {
+	return sess.server.config.SMTPUsername != ""
+}
+
+// authAllowed reports whether credentials may be sent: over TLS, or in
+// plain text when the listener has no certificate
+func (sess *session) authAllowed() bool {
+	return sess.tls || sess.server.tlsConfig == nil
+}
+
+// auth answers AUTH PLAIN and AUTH LOGIN
+func (sess *session) auth(arg string) {
+	switch {
+	case !sess.authRequired():
+		sess.reply(503, "5.5.1 Authentication not enabled")
+		return
+	case sess.authenticated:
+		sess.reply(503, "5.5.1 Already authenticated")
+		return
+	case !sess.authAllowed():
+		sess.reply(538, "5.7.11 Encryption required, use STARTTLS first")
+		return
+	}
+
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		response, ok := sess.challenge("", initial)
+		if !ok {
+			return
+		}
+		// authorization identity, authentication identity and password
+		parts := strings.SplitN(response, "\x00", 3)
+		if len(parts) != 3 {
+			sess.reply(501, "5.5.2 Invalid PLAIN response")
+			return
+		}
+		username, password = parts[1], parts[2]
+	case "LOGIN":
+		var ok bool
+		if username, ok = sess.challenge("Username:", initial); !ok {
+			return
+		}
+		if password, ok = sess.challenge("Password:", ""); !ok {
+			return
+		}
+	default:
+		sess.reply(504, "5.5.4 Unrecognized authentication mechanism")
+		return
+	}
+
+	cfg := sess.server.config
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(cfg.SMTPUsername)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(cfg.SMTPPassword)) == 1
+	if !userOK || !passwordOK {
+		sess.log.Warn("SMTP authentication failed", "username", username)
+		sess.reply(535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+
+	sess.authenticated = true
+	sess.reply(235, "2.7.0 Authentication successful")
+}
+
+// challenge returns the decoded response to an AUTH challenge, or the
+// initial response given with the command
+func (sess *session) challenge(prompt, initial string) (string, bool) {
+	response := initial
+	if response == "" {
+		sess.reply(334, "%s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return "", false
+		}
+		response = line
+	}
+
+	if response == "*" {
+		sess.reply(501, "5.0.0 Authentication cancelled")
+		return "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		sess.reply(501, "5.5.2 Invalid base64 response")
+		return "", false
+	}
+	return string(decoded), true
+}
+
+// mail starts an email with MAIL FROM
+func (sess *session) mail(arg string) {
+	switch {
+	case sess.helo == "":
+		sess.reply(503, "5.5.1 Send EHLO first")
+		return
+	case sess.authRequired() && !sess.authenticated:
+		sess.reply(530, "5.7.0 Authentication required")
+		return
+	case sess.from != "":
+		sess.reply(503, "5.5.1 Nested MAIL command")
+		return
+	}
+
+	from, params, ok := pathArgument(arg, "FROM:")
+	if !ok {
+		sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(name, "SIZE") {
+			if size, err := strconv.Atoi(value); err == nil && size > sess.maxSize() {
+				emails.Inc("rejected")
+				sess.reply(552, "5.3.4 Message size exceeds %d bytes", sess.maxSize())
+				return
+			}
+		}
+	}
+
+	// The null sender <> of bounces is kept as such
+	if from == "" {
+		from = "<>"
+	}
+	sess.from = from
+	sess.reply(250, "2.1.0 OK")
+}
+
+// rcpt adds a recipient with RCPT TO. Any recipient is accepted.
+func (sess *session) rcpt(arg string) {
+	if sess.from == "" {
+		sess.reply(503, "5.5.1 Send MAIL first")
+		return
+	}
+	if len(sess.recipients) >= maxRecipients {
+		sess.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+
+	to, _, ok := pathArgument(arg, "TO:")
+	if !ok || to == "" {
+		sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	sess.recipients = append(sess.recipients, to)
+	sess.reply(250, "2.1.5 OK")
+}
+
+// data receives the content of an email and forwards it. Emails Mizito
+// cannot take now are refused with a temporary failure, so the sender
+// retries them.
+func (sess *session) data(ctx context.Context) bool {
+	if len(sess.recipients) == 0 {
+		sess.reply(503, "5.5.1 Send RCPT first")
+		return true
+	}
+
+	sess.reply(354, "Start mail input; end with <CRLF>.<CRLF>")
+	content, err := readAll(sess.text.DotReader(), sess.maxSize())
+	if errors.Is(err, errTooLarge) {
+		emails.Inc("rejected")
+		sess.reply(552, "5.3.4 Message size exceeds %d bytes", sess.maxSize())
+		sess.reset()
+		return true
+	}
+	if err != nil {
+		sess.log.Debug("SMTP session closed while receiving an email", "error", err)
+		return false
+	}
+	defer sess.reset()
+
+	cfg := sess.server.config
+	e, err := readEmail(bytes.NewReader(content), cfg.SMTPPriority, cfg.MaxAttachmentSize)
+	if err != nil {
+		emails.Inc("rejected")
+		sess.log.Warn("Rejected invalid email", "from", sess.from, "error", err)
+		if errors.Is(err, errTooLarge) {
+			sess.reply(552, "5.3.4 Attachments exceed %d bytes", cfg.MaxAttachmentSize)
+		} else {
+			sess.reply(554, "5.6.0 Invalid message: %v", err)
+		}
+		return true
+	}
+
+	ctx = logger.WithRequestID(ctx, newID())
+	log := sess.log.WithContext(ctx)
+	n := e.notification(sess.recipients)
+	if err := sess.server.submit(ctx, n); err != nil {
+		emails.Inc("failed")
+		log.Error("Failed to forward email", "from", sess.from, "subject", e.subject, "error", err)
+		sess.reply(451, "4.3.0 Failed to forward message, try again later")
+		return true
+	}
+
+	emails.Inc("forwarded")
+	log.Info("Email forwarded", "from", sess.from, "to", strings.Join(sess.recipients, ","), "subject", e.subject, "attachments", len(e.attachments))
+	sess.reply(250, "2.0.0 OK: forwarded")
+	return true
+}
+
+// reset clears the envelope of the current email
+func (sess *session) reset() {
+	sess.from = ""
+	sess.recipients = nil
+}
+
+// maxSize returns the largest email accepted in bytes
+func (sess *session) maxSize() int {
+	return sess.server.config.MaxAttachmentSize + headerAllowance
+}
+
+// reply sends a single-line reply
+func (sess *session) reply(code int, format string, args ...interface{}) {
+	sess.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+// replyLines sends a multi-line reply
+func (sess *session) replyLines(code int, lines []string) {
+	w := bufio.NewWriter(sess.conn)
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		fmt.Fprintf(w, "%d%s%s\r\n", code, separator, line)
+	}
+	w.Flush()
+}
+
+// pathArgument parses the argument of MAIL FROM and RCPT TO: an address in
+// angle brackets after prefix, followed by parameters
+func pathArgument(arg, prefix string) (address string, params []string, ok bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", nil, false
+	}
+
+	end := strings.Index(rest, ">")
+	if end < 0 {
+		return "", nil, false
+	}
+	return rest[1:end], strings.Fields(rest[end+1:]), true
+}
+
+// newID returns a random request ID for a forwarded email
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
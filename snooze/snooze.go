@@ -0,0 +1,113 @@
+// Package snooze holds back re-fires of an alert for a while after someone
+// snoozed one of its notifications, for one-off cases not worth a silence.
+package snooze
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultLimit is how many delivered notifications a store remembers by
+// default
+const DefaultLimit = 10000
+
+// Notification is a delivered notification that may be snoozed
+type Notification struct {
+	Route    string
+	Account  string
+	DialogID string
+	Title    string
+
+	// Key identifies the re-fires of the notification
+	Key string
+
+	// MessageID is the ID Mizito gave the delivered message, so a snooze
+	// can be noted in its thread; empty until it is known
+	MessageID string
+}
+
+// Store remembers the most recently delivered notifications and the alerts
+// snoozed through them, in memory. Once it remembers limit notifications,
+// the oldest is forgotten for each new one.
+type Store struct {
+	mutex         sync.Mutex
+	limit         int
+	notifications map[string]*Notification
+	order         []string
+	snoozed       map[string]time.Time
+}
+
+// NewStore creates a store remembering up to limit notifications
+func NewStore(limit int) *Store {
+	return &Store{
+		limit:         limit,
+		notifications: make(map[string]*Notification),
+		snoozed:       make(map[string]time.Time),
+	}
+}
+
+// Remember records a delivered notification under its message ID
+func (s *Store) Remember(id string, n Notification) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.notifications[id]; !ok {
+		s.order = append(s.order, id)
+	}
+	s.notifications[id] = &n
+
+	if len(s.order) > s.limit {
+		delete(s.notifications, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Sent records the ID Mizito gave the message of the notification with the
+// given message ID, once it is delivered
+func (s *Store) Sent(id, messageID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored, ok := s.notifications[id]; ok {
+		stored.MessageID = messageID
+	}
+}
+
+// Snooze holds back the re-fires of the notification with the given message
+// ID until the duration has passed. ok is false for unknown or forgotten
+// notifications.
+func (s *Store) Snooze(id string, duration time.Duration) (n Notification, until time.Time, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored, ok := s.notifications[id]
+	if !ok {
+		return Notification{}, time.Time{}, false
+	}
+
+	// Snoozes of alerts that never fired again expire here
+	now := time.Now()
+	for key, until := range s.snoozed {
+		if now.After(until) {
+			delete(s.snoozed, key)
+		}
+	}
+
+	until = now.Add(duration)
+	s.snoozed[stored.Key] = until
+	return *stored, until, true
+}
+
+// Snoozed reports until when the alert with the given key is snoozed; ok is
+// false when it is not
+func (s *Store) Snoozed(key string) (until time.Time, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	until, ok = s.snoozed[key]
+	if ok && time.Now().After(until) {
+		delete(s.snoozed, key)
+		return time.Time{}, false
+	}
+	return until, ok
+}
@@ -0,0 +1,476 @@
+// Package sqlite reads the rows of tables of a SQLite database file, such as
+// the database of a Gotify server.
+//
+// Only the parts of the SQLite file format needed to read tables are
+// implemented: the header, table b-trees with overflow pages, the record
+// format and the schema table. Indexes and UTF-16 databases are not
+// supported. WAL files are not read either, so databases with changes not
+// yet checkpointed into the main file are refused.
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// magic starts every SQLite database file
+var magic = []byte("SQLite format 3\x00")
+
+// headerSize is the size of the database header on the first page
+const headerSize = 100
+
+// maxDepth limits the depth of b-trees, guarding against corrupt files
+const maxDepth = 32
+
+// B-tree page types
+const (
+	pageInteriorTable = 0x05
+	pageLeafTable     = 0x0d
+)
+
+// ErrInvalidDatabase is returned for files that are not valid SQLite
+// databases
+var ErrInvalidDatabase = errors.New("invalid SQLite database")
+
+// ErrNoTable is returned for tables missing from the database
+var ErrNoTable = errors.New("no such table")
+
+// ErrUncheckpointed is returned for databases whose WAL file holds changes
+// not yet written to the database file
+var ErrUncheckpointed = errors.New("database has uncheckpointed WAL changes")
+
+// Row is a row of a table by column name. Values are int64, float64, string,
+// []byte or nil.
+type Row map[string]interface{}
+
+// Database is a SQLite database loaded into memory
+type Database struct {
+	buf      []byte
+	pageSize int
+	usable   int
+}
+
+// Open reads a SQLite database file. A database with a non-empty WAL file
+// is refused, as the file alone would miss its latest changes; stopping the
+// server writing it, or running PRAGMA wal_checkpoint(TRUNCATE), empties it.
+func Open(path string) (*Database, error) {
+	if info, err := os.Stat(path + "-wal"); err == nil && info.Size() > 0 {
+		return nil, fmt.Errorf("%w: %s-wal", ErrUncheckpointed, path)
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(buf)
+}
+
+// New parses a SQLite database from its contents
+func New(buf []byte) (*Database, error) {
+	if len(buf) < headerSize || !bytes.HasPrefix(buf, magic) {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidDatabase)
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(buf[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("%w: page size %d", ErrInvalidDatabase, pageSize)
+	}
+	if encoding := binary.BigEndian.Uint32(buf[56:]); encoding > 1 {
+		return nil, fmt.Errorf("%w: only UTF-8 databases are supported", ErrInvalidDatabase)
+	}
+
+	// The file format requires at least 480 usable bytes per page
+	usable := pageSize - int(buf[20])
+	if usable < 480 {
+		return nil, fmt.Errorf("%w: usable page size %d", ErrInvalidDatabase, usable)
+	}
+
+	return &Database{
+		buf:      buf,
+		pageSize: pageSize,
+		usable:   usable,
+	}, nil
+}
+
+// Rows returns the rows of a table in rowid order. Columns declared as
+// INTEGER PRIMARY KEY hold the rowid; columns added after a row was written
+// are nil.
+func (db *Database) Rows(table string) ([]Row, error) {
+	root, columns, rowid, err := db.table(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []Row
+	err = db.walk(root, func(id int64, payload []byte) error {
+		values, err := decodeRecord(payload)
+		if err != nil {
+			return err
+		}
+
+		row := make(Row, len(columns))
+		for i, column := range columns {
+			if i < len(values) {
+				row[column] = values[i]
+			} else {
+				row[column] = nil
+			}
+		}
+		if rowid >= 0 {
+			row[columns[rowid]] = id
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+// table finds a table in the schema table: its root page, its columns and
+// the index of the column aliasing the rowid, -1 if none
+func (db *Database) table(name string) (root int, columns []string, rowid int, err error) {
+	var sql string
+	err = db.walk(1, func(_ int64, payload []byte) error {
+		values, err := decodeRecord(payload)
+		if err != nil {
+			return err
+		}
+		// type, name, tbl_name, rootpage, sql
+		if len(values) < 5 || values[0] != "table" {
+			return nil
+		}
+		if tableName, _ := values[1].(string); strings.EqualFold(tableName, name) {
+			page, _ := values[3].(int64)
+			root = int(page)
+			sql, _ = values[4].(string)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, -1, err
+	}
+	if root == 0 {
+		return 0, nil, -1, fmt.Errorf("%w: %s", ErrNoTable, name)
+	}
+
+	columns, rowid = parseColumns(sql)
+	if len(columns) == 0 {
+		return 0, nil, -1, fmt.Errorf("%w: cannot parse the columns of %s", ErrInvalidDatabase, name)
+	}
+	return root, columns, rowid, nil
+}
+
+// page returns the contents of a page, numbered from 1
+func (db *Database) page(number int) ([]byte, error) {
+	start := (number - 1) * db.pageSize
+	if number < 1 || start+db.pageSize > len(db.buf) {
+		return nil, fmt.Errorf("%w: page %d out of range", ErrInvalidDatabase, number)
+	}
+	return db.buf[start : start+db.usable], nil
+}
+
+// walk calls fn with the rowid and payload of each cell of the table b-tree
+// rooted at page number
+func (db *Database) walk(number int, fn func(rowid int64, payload []byte) error) error {
+	return db.walkPage(number, 0, make(map[int]bool), fn)
+}
+
+// walkPage walks the b-tree below a page. A page met twice is part of a
+// cycle of a corrupt file.
+func (db *Database) walkPage(number, depth int, visited map[int]bool, fn func(rowid int64, payload []byte) error) error {
+	if depth > maxDepth {
+		return fmt.Errorf("%w: b-tree too deep", ErrInvalidDatabase)
+	}
+	if visited[number] {
+		return fmt.Errorf("%w: page %d is referenced twice", ErrInvalidDatabase, number)
+	}
+	visited[number] = true
+
+	page, err := db.page(number)
+	if err != nil {
+		return err
+	}
+	// The database header precedes the b-tree header of the first page
+	header := 0
+	if number == 1 {
+		header = headerSize
+	}
+	if len(page) < header+12 {
+		return fmt.Errorf("%w: page %d truncated", ErrInvalidDatabase, number)
+	}
+
+	kind := page[header]
+	if kind != pageInteriorTable && kind != pageLeafTable {
+		return fmt.Errorf("%w: page %d is not a table page", ErrInvalidDatabase, number)
+	}
+	cells := int(binary.BigEndian.Uint16(page[header+3:]))
+	pointers := header + 8
+	if kind == pageInteriorTable {
+		pointers = header + 12
+	}
+	if pointers+2*cells > len(page) {
+		return fmt.Errorf("%w: page %d has too many cells", ErrInvalidDatabase, number)
+	}
+
+	for i := 0; i < cells; i++ {
+		offset := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+		if offset >= len(page) {
+			return fmt.Errorf("%w: cell out of page %d", ErrInvalidDatabase, number)
+		}
+		cell := page[offset:]
+
+		switch kind {
+		case pageInteriorTable:
+			if len(cell) < 4 {
+				return fmt.Errorf("%w: cell out of page %d", ErrInvalidDatabase, number)
+			}
+			if err := db.walkPage(int(binary.BigEndian.Uint32(cell)), depth+1, visited, fn); err != nil {
+				return err
+			}
+		case pageLeafTable:
+			rowid, payload, err := db.leafCell(cell)
+			if err != nil {
+				return err
+			}
+			if err := fn(rowid, payload); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: page %d is not a table page", ErrInvalidDatabase, number)
+		}
+	}
+
+	if kind == pageInteriorTable {
+		return db.walkPage(int(binary.BigEndian.Uint32(page[header+8:])), depth+1, visited, fn)
+	}
+	return nil
+}
+
+// leafCell reads the rowid and payload of a table leaf cell, following its
+// overflow pages
+func (db *Database) leafCell(cell []byte) (int64, []byte, error) {
+	size, n := varint(cell)
+	if n == 0 {
+		return 0, nil, fmt.Errorf("%w: truncated cell", ErrInvalidDatabase)
+	}
+	rowid, m := varint(cell[n:])
+	if m == 0 {
+		return 0, nil, fmt.Errorf("%w: truncated cell", ErrInvalidDatabase)
+	}
+	cell = cell[n+m:]
+	if size > uint64(len(db.buf)) {
+		return 0, nil, fmt.Errorf("%w: payload exceeds file", ErrInvalidDatabase)
+	}
+
+	// The part of the payload kept in the cell, as defined by the file format
+	total := int(size)
+	local := total
+	if maxLocal := db.usable - 35; total > maxLocal {
+		minLocal := (db.usable-12)*32/255 - 23
+		local = minLocal + (total-minLocal)%(db.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if local > len(cell) || (local < total && local+4 > len(cell)) {
+		return 0, nil, fmt.Errorf("%w: truncated cell", ErrInvalidDatabase)
+	}
+
+	payload := make([]byte, 0, total)
+	payload = append(payload, cell[:local]...)
+	next := 0
+	if local < total {
+		next = int(binary.BigEndian.Uint32(cell[local:]))
+	}
+	for pages := 0; len(payload) < total; pages++ {
+		if next == 0 || pages > len(db.buf)/db.pageSize {
+			return 0, nil, fmt.Errorf("%w: broken overflow chain", ErrInvalidDatabase)
+		}
+		page, err := db.page(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		next = int(binary.BigEndian.Uint32(page))
+		chunk := page[4:]
+		if remaining := total - len(payload); len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+		payload = append(payload, chunk...)
+	}
+
+	return int64(rowid), payload, nil
+}
+
+// decodeRecord decodes the values of a record
+func decodeRecord(payload []byte) ([]interface{}, error) {
+	headerLength, n := varint(payload)
+	if n == 0 || headerLength < uint64(n) || headerLength > uint64(len(payload)) {
+		return nil, fmt.Errorf("%w: invalid record header", ErrInvalidDatabase)
+	}
+	header := payload[n:headerLength]
+	body := payload[headerLength:]
+
+	var values []interface{}
+	for len(header) > 0 {
+		serial, n := varint(header)
+		if n == 0 {
+			return nil, fmt.Errorf("%w: invalid record header", ErrInvalidDatabase)
+		}
+		header = header[n:]
+
+		size := serialSize(serial)
+		if size < 0 {
+			return nil, fmt.Errorf("%w: invalid serial type %d", ErrInvalidDatabase, serial)
+		}
+		if size > len(body) {
+			return nil, fmt.Errorf("%w: record exceeds payload", ErrInvalidDatabase)
+		}
+		values = append(values, serialValue(serial, body[:size]))
+		body = body[size:]
+	}
+	return values, nil
+}
+
+// serialSize returns the size of a value of a serial type, -1 for the
+// reserved types and sizes no payload can hold
+func serialSize(serial uint64) int {
+	switch {
+	case serial >= 12:
+		size := (serial - 12) / 2
+		if size > math.MaxInt32 {
+			return -1
+		}
+		return int(size)
+	case serial == 10 || serial == 11:
+		return -1
+	case serial == 7:
+		return 8
+	case serial == 5 || serial == 6:
+		return 6 + 2*int(serial-5)
+	case serial >= 1 && serial <= 4:
+		return int(serial)
+	default:
+		return 0
+	}
+}
+
+// serialValue decodes a value of a serial type
+func serialValue(serial uint64, data []byte) interface{} {
+	switch {
+	case serial == 0:
+		return nil
+	case serial == 7:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	case serial == 8:
+		return int64(0)
+	case serial == 9:
+		return int64(1)
+	case serial >= 12 && serial%2 == 0:
+		return append([]byte(nil), data...)
+	case serial >= 13:
+		return string(data)
+	case serial >= 1 && serial <= 6:
+		// Big-endian two's complement integers
+		var v int64
+		if len(data) > 0 && data[0]&0x80 != 0 {
+			v = -1
+		}
+		for _, b := range data {
+			v = v<<8 | int64(b)
+		}
+		return v
+	default:
+		return nil
+	}
+}
+
+// varint decodes a SQLite variable-length integer, returning 0 bytes read
+// for truncated input
+func varint(buf []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(buf); i++ {
+		if i == 8 {
+			return v<<8 | uint64(buf[i]), 9
+		}
+		v = v<<7 | uint64(buf[i]&0x7f)
+		if buf[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// tableConstraints start the definitions of CREATE TABLE that are not
+// columns
+var tableConstraints = []string{"constraint", "primary", "unique", "check", "foreign"}
+
+// parseColumns returns the column names of a CREATE TABLE statement and the
+// index of the INTEGER PRIMARY KEY column aliasing the rowid, -1 if none
+func parseColumns(sql string) ([]string, int) {
+	start, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return nil, -1
+	}
+
+	var columns []string
+	rowid := -1
+	for _, definition := range splitDefinitions(sql[start+1 : end]) {
+		fields := strings.Fields(definition)
+		if len(fields) == 0 {
+			continue
+		}
+
+		name := fields[0]
+		constraint := false
+		for _, keyword := range tableConstraints {
+			if strings.EqualFold(name, keyword) {
+				constraint = true
+			}
+		}
+		if constraint {
+			continue
+		}
+
+		lower := strings.ToLower(definition)
+		if len(fields) > 1 && strings.EqualFold(fields[1], "integer") && strings.Contains(lower, "primary key") {
+			rowid = len(columns)
+		}
+		columns = append(columns, strings.Trim(name, "\"`[]'"))
+	}
+	return columns, rowid
+}
+
+// splitDefinitions splits the definitions of CREATE TABLE at the commas
+// outside parentheses and quotes
+func splitDefinitions(s string) []string {
+	var definitions []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			definitions = append(definitions, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(definitions, s[start:])
+}
@@ -0,0 +1,190 @@
+package sqlite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The fixture is a Gotify applications table of 300 rows on 1 KiB pages,
+// spanning interior pages, with a 5000-byte description on overflow pages
+// and a column added by ALTER TABLE after the rows were written
+const fixture = "testdata/gotify.db"
+
+func TestRows(t *testing.T) {
+	db, err := Open(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Rows("applications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 300 {
+		t.Fatalf("got %d rows, want 300", len(rows))
+	}
+
+	first := rows[0]
+	if first["id"] != int64(1) || first["token"] != "A00000000000001" || first["name"] != "app-1" ||
+		first["internal"] != int64(1) || first["default_priority"] != int64(1) {
+		t.Errorf("first row = %v", first)
+	}
+	if value, ok := first["last_used"]; !ok || value != nil {
+		t.Errorf("last_used = %v, %v, want nil for rows written before the column was added", value, ok)
+	}
+	if _, ok := first["fk_users_applications"]; ok {
+		t.Errorf("table constraint read as a column: %v", first)
+	}
+
+	if description := rows[149]["description"]; description != strings.Repeat("x", 5000) {
+		t.Errorf("description of the row on overflow pages has %d bytes", len(description.(string)))
+	}
+	for i, row := range rows {
+		if row["id"] != int64(i+1) {
+			t.Fatalf("row %d has id %v, want rowid order", i, row["id"])
+		}
+	}
+}
+
+func TestRowsNoTable(t *testing.T) {
+	db, err := Open(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Rows("clients"); !errors.Is(err, ErrNoTable) {
+		t.Errorf("Rows of a missing table: %v, want ErrNoTable", err)
+	}
+}
+
+func TestOpenWAL(t *testing.T) {
+	buf, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "gotify.db")
+	if err := os.WriteFile(path, buf, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty WAL file holds no changes
+	if err := os.WriteFile(path+"-wal", nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err != nil {
+		t.Errorf("Open with an empty WAL file: %v", err)
+	}
+
+	if err := os.WriteFile(path+"-wal", []byte("frames"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); !errors.Is(err, ErrUncheckpointed) {
+		t.Errorf("Open with a non-empty WAL file: %v, want ErrUncheckpointed", err)
+	}
+}
+
+func TestDecodeRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []interface{}
+	}{
+		// Header of 5 bytes: its length, NULL, integer 1, 1-byte integer,
+		// 3-byte text
+		{"values", []byte{5, 0, 9, 1, 19, 0xfe, 'a', 'b', 'c'}, []interface{}{nil, int64(1), int64(-2), "abc"}},
+		{"empty", []byte{1}, nil},
+	}
+	for _, tt := range tests {
+		values, err := decodeRecord(tt.payload)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(values) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, values, tt.want)
+			continue
+		}
+		for i := range values {
+			if values[i] != tt.want[i] {
+				t.Errorf("%s: value %d = %#v, want %#v", tt.name, i, values[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestDecodeRecordCorrupt(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"empty payload", nil},
+		{"header length shorter than its varint", []byte{0, 1, 2}},
+		{"header length beyond payload", []byte{9, 1}},
+		{"truncated varint", []byte{0x81}},
+		{"value beyond payload", []byte{2, 6, 1, 2}},
+		{"reserved serial type", []byte{2, 10}},
+		{"oversized text", []byte{6, 0x8f, 0xff, 0xff, 0xff, 0x7f, 'a'}},
+	}
+	for _, tt := range tests {
+		if _, err := decodeRecord(tt.payload); !errors.Is(err, ErrInvalidDatabase) {
+			t.Errorf("%s: %v, want ErrInvalidDatabase", tt.name, err)
+		}
+	}
+}
+
+func TestCorruptDatabase(t *testing.T) {
+	valid, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		corrupt func(buf []byte) []byte
+	}{
+		{"not a database", func(buf []byte) []byte { return []byte("hello") }},
+		{"truncated", func(buf []byte) []byte { return buf[:1500] }},
+		{"invalid page size", func(buf []byte) []byte { buf[16], buf[17] = 0x03, 0x00; return buf }},
+		{"reserved bytes", func(buf []byte) []byte { buf[20] = 100; return buf }},
+		{"page type", func(buf []byte) []byte { buf[headerSize] = 0x42; return buf }},
+		{"cell count", func(buf []byte) []byte { buf[headerSize+3], buf[headerSize+4] = 0xff, 0xff; return buf }},
+		{"zeroed pages", func(buf []byte) []byte {
+			for i := 1024; i < len(buf); i++ {
+				buf[i] = 0
+			}
+			return buf
+		}},
+		{"garbled pages", func(buf []byte) []byte {
+			for i := 1024; i < len(buf); i++ {
+				buf[i] = byte(i * 7)
+			}
+			return buf
+		}},
+	}
+	for _, tt := range tests {
+		buf := tt.corrupt(append([]byte(nil), valid...))
+
+		db, err := New(buf)
+		if err == nil {
+			_, err = db.Rows("applications")
+		}
+		if !errors.Is(err, ErrInvalidDatabase) && !errors.Is(err, ErrNoTable) {
+			t.Errorf("%s: %v, want ErrInvalidDatabase", tt.name, err)
+		}
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	sql := `CREATE TABLE "users" ("id" integer primary key autoincrement,"name" varchar(180),` +
+		`"pass" blob,"admin" bool, CONSTRAINT uix UNIQUE ("name", "pass"))`
+	columns, rowid := parseColumns(sql)
+	if strings.Join(columns, ",") != "id,name,pass,admin" || rowid != 0 {
+		t.Errorf("parseColumns = %v, %d", columns, rowid)
+	}
+
+	if columns, rowid := parseColumns("CREATE TABLE t (a text, b int)"); len(columns) != 2 || rowid != -1 {
+		t.Errorf("parseColumns without rowid alias = %v, %d", columns, rowid)
+	}
+}
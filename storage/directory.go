@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Directory is a collection keeping each record in a JSON file of a
+// directory, named after its key. Records are written atomically, so they
+// survive crashes and restarts. It keeps the queue when the backend is not
+// durable.
+type Directory struct {
+	dir string
+}
+
+// NewDirectory creates a collection stored in dir, which is created when
+// the first record is stored
+func NewDirectory(dir string) *Directory {
+	return &Directory{dir: dir}
+}
+
+// Dir returns the directory of the collection
+func (d *Directory) Dir() string {
+	return d.dir
+}
+
+// Get reads the file of a key
+func (d *Directory) Get(key string) ([]byte, error) {
+	return os.ReadFile(d.path(key))
+}
+
+// Put atomically writes the file of a key: the data is synced to a
+// temporary file which is then renamed into place
+func (d *Directory) Put(key string, value []byte) error {
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(d.dir, ".record-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), d.path(key)); err != nil {
+		return fmt.Errorf("failed to store record: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the file of a key
+func (d *Directory) Delete(key string) error {
+	return os.Remove(d.path(key))
+}
+
+// List reads all files, ordered by key, which is the order they were
+// stored in for time-ordered keys such as job IDs. A missing directory
+// holds no records.
+func (d *Directory) List() ([]Record, error) {
+	names, err := d.names()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(names))
+	for _, name := range names {
+		value, err := os.ReadFile(filepath.Join(d.dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		records = append(records, Record{Key: strings.TrimSuffix(name, ".json"), Value: value})
+	}
+	return records, nil
+}
+
+// Len counts the files
+func (d *Directory) Len() (int, error) {
+	names, err := d.names()
+	return len(names), err
+}
+
+// Trim removes the files of the first keys beyond max
+func (d *Directory) Trim(max int) error {
+	names, err := d.names()
+	if err != nil {
+		return err
+	}
+
+	for len(names) > max {
+		if err := os.Remove(filepath.Join(d.dir, names[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// names returns the sorted names of the record files
+func (d *Directory) names() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".json" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// path returns the file of a key
+func (d *Directory) path(key string) string {
+	return filepath.Join(d.dir, strings.ReplaceAll(key, string(filepath.Separator), "_")+".json")
+}
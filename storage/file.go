@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File is a collection kept in a JSON file as an array of objects, keyed by
+// one of their fields, so it stays readable and editable by hand. Every
+// change rewrites the file atomically. It keeps the applications when the
+// backend is not durable.
+type File struct {
+	path  string
+	field string
+	mutex sync.Mutex
+}
+
+// NewFile creates a collection stored in path whose records are keyed by
+// field. A missing file holds no records.
+func NewFile(path, field string) *File {
+	return &File{path: path, field: field}
+}
+
+// Path returns the file of the collection
+func (f *File) Path() string {
+	return f.path
+}
+
+// Get returns the object of a key
+func (f *File) Get(key string) ([]byte, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	records, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Key == key {
+			return record.Value, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// Put stores the object of a key, which must hold the key in its field
+func (f *File) Put(key string, value []byte) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if k, err := f.key(value); err != nil || k != key {
+		return fmt.Errorf("record %q must be an object with %s %q", key, f.field, key)
+	}
+
+	records, err := f.read()
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		if record.Key == key {
+			records[i].Value = value
+			return f.write(records)
+		}
+	}
+	return f.write(append(records, Record{Key: key, Value: value}))
+}
+
+// Delete removes the object of a key
+func (f *File) Delete(key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	records, err := f.read()
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		if record.Key == key {
+			return f.write(append(records[:i], records[i+1:]...))
+		}
+	}
+	return os.ErrNotExist
+}
+
+// List returns the objects in the order of the file
+func (f *File) List() ([]Record, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.read()
+}
+
+// Len returns the number of objects
+func (f *File) Len() (int, error) {
+	records, err := f.List()
+	return len(records), err
+}
+
+// Trim removes the first objects beyond max
+func (f *File) Trim(max int) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	records, err := f.read()
+	if err != nil || len(records) <= max {
+		return err
+	}
+	return f.write(records[len(records)-max:])
+}
+
+// read parses the file
+func (f *File) read() ([]Record, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.path, err)
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", f.path, err)
+	}
+
+	records := make([]Record, 0, len(values))
+	for i, value := range values {
+		key, err := f.key(value)
+		if err != nil || key == "" {
+			return nil, fmt.Errorf("object %d in %s has no %s", i+1, f.path, f.field)
+		}
+		records = append(records, Record{Key: key, Value: value})
+	}
+	return records, nil
+}
+
+// write replaces the file with records
+func (f *File) write(records []Record) error {
+	values := make([]json.RawMessage, 0, len(records))
+	for _, record := range records {
+		values = append(values, record.Value)
+	}
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(f.path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// key returns the key field of an object
+func (f *File) key(value []byte) (string, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal(value, &object); err != nil {
+		return "", err
+	}
+	key, _ := object[f.field].(string)
+	return key, nil
+}
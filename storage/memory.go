@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// MemoryBackend is the name of the memory backend
+const MemoryBackend = "memory"
+
+func init() {
+	Register(MemoryBackend, func(string) (Storage, error) {
+		return NewMemory(), nil
+	})
+}
+
+// Memory keeps collections and counters in the process. It needs no
+// external service and loses its state on exit.
+type Memory struct {
+	mutex       sync.Mutex
+	collections map[string]*memoryCollection
+	counters    map[string]*memoryCounters
+}
+
+// NewMemory creates an empty memory backend
+func NewMemory() *Memory {
+	return &Memory{
+		collections: make(map[string]*memoryCollection),
+		counters:    make(map[string]*memoryCounters),
+	}
+}
+
+// Collection returns the collection of a name
+func (m *Memory) Collection(name string) Collection {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	c, ok := m.collections[name]
+	if !ok {
+		c = &memoryCollection{values: make(map[string][]byte)}
+		m.collections[name] = c
+	}
+	return c
+}
+
+// Counters returns the counters of a name
+func (m *Memory) Counters(name string) Counters {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	c, ok := m.counters[name]
+	if !ok {
+		c = &memoryCounters{counts: make(map[string]*memoryCount)}
+		m.counters[name] = c
+	}
+	return c
+}
+
+// Durable reports false: the state is lost on exit
+func (m *Memory) Durable() bool {
+	return false
+}
+
+// Close does nothing
+func (m *Memory) Close() error {
+	return nil
+}
+
+// memoryCollection keeps records in a map, with their keys in order
+type memoryCollection struct {
+	mutex  sync.Mutex
+	values map[string][]byte
+	order  []string
+}
+
+func (c *memoryCollection) Get(key string) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	value, ok := c.values[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (c *memoryCollection) Put(key string, value []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.values[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (c *memoryCollection) Delete(key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.values[key]; !ok {
+		return os.ErrNotExist
+	}
+	delete(c.values, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (c *memoryCollection) List() ([]Record, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	records := make([]Record, 0, len(c.order))
+	for _, key := range c.order {
+		records = append(records, Record{Key: key, Value: append([]byte(nil), c.values[key]...)})
+	}
+	return records, nil
+}
+
+func (c *memoryCollection) Len() (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.order), nil
+}
+
+func (c *memoryCollection) Trim(max int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if excess := len(c.order) - max; excess > 0 {
+		for _, key := range c.order[:excess] {
+			delete(c.values, key)
+		}
+		c.order = c.order[excess:]
+	}
+	return nil
+}
+
+// memoryCounters keeps counts in a map. Expired counts are dropped when
+// their key is counted or taken again.
+type memoryCounters struct {
+	mutex  sync.Mutex
+	counts map[string]*memoryCount
+}
+
+// memoryCount is the count of a key until it expires
+type memoryCount struct {
+	count   int
+	expires time.Time
+}
+
+func (c *memoryCounters) Increment(key string, ttl time.Duration) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	entry, ok := c.counts[key]
+	if !ok || !now.Before(entry.expires) {
+		entry = &memoryCount{expires: now.Add(ttl)}
+		c.counts[key] = entry
+	}
+	entry.count++
+	return entry.count, nil
+}
+
+func (c *memoryCounters) Take(key string) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.counts[key]
+	if !ok {
+		return 0, nil
+	}
+	delete(c.counts, key)
+	return entry.count, nil
+}
@@ -0,0 +1,137 @@
+// Package storage keeps the state of the forwarder: the queued and
+// dead-lettered messages, the delivery history, the deduplication windows
+// and the applications. It is kept in a backend selected by STORAGE_BACKEND.
+//
+// The memory backend, the default, keeps everything in the process, so small
+// deployments need nothing besides the forwarder. Since it does not outlive
+// the process, the queue and the applications then stay in their files.
+// Backends keeping the state in a database such as SQLite, Postgres or Redis
+// register themselves with Register, and instances sharing one share the
+// state.
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the collections and counters kept by the forwarder
+const (
+	Queue        = "queue"
+	DeadLetters  = "dead_letters"
+	History      = "history"
+	Applications = "applications"
+	Dedup        = "dedup"
+)
+
+// Storage is a backend keeping named collections and counters
+type Storage interface {
+	// Collection returns the collection of a name, created when first used
+	Collection(name string) Collection
+
+	// Counters returns the counters of a name, created when first used
+	Counters(name string) Counters
+
+	// Durable reports whether the state outlives the process
+	Durable() bool
+
+	// Close releases the resources of the backend
+	Close() error
+}
+
+// Record is a value stored in a collection under its key
+type Record struct {
+	Key   string
+	Value []byte
+}
+
+// Collection is a set of records in the order they were first stored. The
+// values are JSON documents.
+type Collection interface {
+	// Get returns the value of a key. The error satisfies os.IsNotExist for
+	// unknown keys.
+	Get(key string) ([]byte, error)
+
+	// Put stores the value of a key; a key already stored keeps its place
+	Put(key string, value []byte) error
+
+	// Delete removes a key. The error satisfies os.IsNotExist for unknown
+	// keys.
+	Delete(key string) error
+
+	// List returns all records, oldest first
+	List() ([]Record, error)
+
+	// Len returns the number of records
+	Len() (int, error)
+
+	// Trim removes the oldest records beyond max
+	Trim(max int) error
+}
+
+// Counters count occurrences of keys within a time window
+type Counters interface {
+	// Increment counts a key and returns its count. The count of a new key
+	// expires after ttl and is not extended by later increments.
+	Increment(key string, ttl time.Duration) (int, error)
+
+	// Take removes a key and returns its count, 0 for unknown keys
+	Take(key string) (int, error)
+}
+
+// Opener opens a backend; url locates it, as set in STORAGE_URL
+type Opener func(url string) (Storage, error)
+
+var (
+	backendsMutex sync.Mutex
+	backends      = map[string]Opener{}
+)
+
+// Register makes a backend available under a name, usually from the init
+// function of the package implementing it
+func Register(name string, open Opener) {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+
+	if _, ok := backends[name]; ok {
+		panic("storage: backend registered twice: " + name)
+	}
+	backends[name] = open
+}
+
+// Backends returns the names of the registered backends, sorted
+func Backends() []string {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the backend of a name
+func Open(backend, url string) (Storage, error) {
+	backendsMutex.Lock()
+	open, ok := backends[backend]
+	backendsMutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q, expected one of %s", backend, strings.Join(Backends(), ", "))
+	}
+	return open(url)
+}
+
+// Durable returns the collection of a name kept by store when it is
+// durable, and fallback otherwise
+func Durable(store Storage, name string, fallback Collection) Collection {
+	if store.Durable() {
+		return store.Collection(name)
+	}
+	return fallback
+}
@@ -0,0 +1,50 @@
+package syslogd
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter caps the forwarded messages: a token bucket allowing perMinute
+// messages per minute, in bursts of up to perMinute
+type limiter struct {
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+// newLimiter allows perMinute messages per minute
+func newLimiter(perMinute int) *limiter {
+	return &limiter{
+		rate:   float64(perMinute) / 60,
+		burst:  float64(perMinute),
+		tokens: float64(perMinute),
+		last:   time.Now(),
+	}
+}
+
+// allow takes a token if one is available. dropped counts the messages
+// refused since the last allowed one, including this one when refused.
+func (l *limiter) allow() (allowed bool, dropped int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		l.dropped++
+		return false, l.dropped
+	}
+
+	l.tokens--
+	dropped, l.dropped = l.dropped, 0
+	return true, dropped
+}
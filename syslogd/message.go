@@ -0,0 +1,215 @@
+package syslogd
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/severity"
+)
+
+// severities are the names of the syslog severities 0-7, most severe first
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// severityAliases are other names of severities, as in severity mappings
+var severityAliases = map[string]int{
+	"emergency": 0, "panic": 0, "critical": 2, "error": 3, "warn": 4, "informational": 6,
+}
+
+// severityIcons are the title icons of the severities, as for the Event Log
+var severityIcons = []string{"🔴", "🔴", "🔴", "🟠", "🟡", "🔵", "🔵", "⚪"}
+
+// facilities are the names of the syslog facilities 0-23
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// defaultPriority is the PRI of messages without one: user.notice
+const defaultPriority = 13
+
+// parseSeverity returns the severity of a name or number
+func parseSeverity(name string) (int, error) {
+	for i, s := range severities {
+		if name == s || name == strconv.Itoa(i) {
+			return i, nil
+		}
+	}
+	if value, ok := severityAliases[name]; ok {
+		return value, nil
+	}
+	return 0, fmt.Errorf("unknown severity %q, expected one of %s", name, strings.Join(severities, ", "))
+}
+
+// parseFacility returns the facility of a name or number
+func parseFacility(name string) (int, error) {
+	for i, f := range facilities {
+		if name == f || name == strconv.Itoa(i) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown facility %q, expected one of %s", name, strings.Join(facilities, ", "))
+}
+
+// Message is a syslog message
+type Message struct {
+	Facility int
+	Severity int
+	Time     time.Time
+	Hostname string
+	AppName  string
+	ProcID   string
+	MsgID    string
+	Text     string
+
+	// StructuredData holds the raw structured data of RFC 5424 messages
+	StructuredData string
+
+	// Remote is the address of the sender
+	Remote string
+}
+
+// notification converts the message into a notification of RouteName
+func (m *Message) notification() *render.Notification {
+	title := fmt.Sprintf("%s %s", severityIcons[m.Severity], m.Hostname)
+	if m.AppName != "" {
+		title = fmt.Sprintf("%s %s on %s", severityIcons[m.Severity], m.AppName, m.Hostname)
+	}
+
+	return &render.Notification{
+		Route:    RouteName,
+		Title:    title,
+		Message:  m.Text,
+		Priority: severity.Default.Priority(severity.Syslog, strconv.Itoa(m.Severity), 0),
+		Time:     m.Time,
+		Source:   RouteName,
+		Extras: map[string]interface{}{
+			"facility":       facilities[m.Facility],
+			"severity":       severities[m.Severity],
+			"hostname":       m.Hostname,
+			"appName":        m.AppName,
+			"procId":         m.ProcID,
+			"msgId":          m.MsgID,
+			"structuredData": m.StructuredData,
+			"remote":         m.Remote,
+		},
+	}
+}
+
+// bom starts the text of RFC 5424 messages encoded as UTF-8
+var bom = []byte("\xef\xbb\xbf")
+
+// tagPattern matches the tag of RFC 3164 messages, e.g. "sshd[42]:"
+var tagPattern = regexp.MustCompile(`^([A-Za-z][\w./-]{0,47})(?:\[([^\]]*)\])?:$`)
+
+// parse reads an RFC 5424 or RFC 3164 message received from remote at now.
+// Messages missing parts, as sent by many network devices, are read as far
+// as they go: the sender's address stands for a missing hostname and the
+// receipt time for a missing or RFC 3164 timestamp, which has no year.
+func parse(data []byte, remote string, now time.Time) *Message {
+	data = bytes.TrimRight(data, "\r\n\x00 ")
+	m := &Message{Time: now, Remote: remote}
+
+	pri, rest := defaultPriority, data
+	if end := bytes.IndexByte(data, '>'); len(data) > 0 && data[0] == '<' && end > 1 && end <= 4 {
+		if value, err := strconv.Atoi(string(data[1:end])); err == nil && value >= 0 && value < len(facilities)*8 {
+			pri, rest = value, data[end+1:]
+		}
+	}
+	m.Facility, m.Severity = pri/8, pri%8
+
+	if bytes.HasPrefix(rest, []byte("1 ")) {
+		m.parse5424(string(rest[2:]))
+	} else {
+		m.parse3164(string(rest))
+	}
+
+	if m.Hostname == "" || m.Hostname == "-" {
+		m.Hostname = remote
+	}
+	return m
+}
+
+// parse5424 reads the header, structured data and text of an RFC 5424
+// message after its version
+func (m *Message) parse5424(rest string) {
+	var timestamp string
+	for _, dst := range []*string{&timestamp, &m.Hostname, &m.AppName, &m.ProcID, &m.MsgID} {
+		*dst, rest, _ = strings.Cut(rest, " ")
+		if *dst == "-" {
+			*dst = ""
+		}
+	}
+	if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		m.Time = t
+	}
+
+	m.StructuredData, rest = structuredData(rest)
+	m.Text = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(rest, " "), string(bom)))
+}
+
+// structuredData splits the structured data of an RFC 5424 message, "-" or
+// elements such as [id param="value"], from the text following it
+func structuredData(rest string) (string, string) {
+	if strings.HasPrefix(rest, "-") {
+		return "", rest[1:]
+	}
+
+	inElement, inValue := false, false
+	for i := 0; i < len(rest); i++ {
+		switch c := rest[i]; {
+		case inValue && c == '\\':
+			i++
+		case inValue:
+			inValue = c != '"'
+		case inElement && c == '"':
+			inValue = true
+		case inElement && c == ']':
+			inElement = false
+		case !inElement && c == '[':
+			inElement = true
+		case !inElement:
+			return rest[:i], rest[i:]
+		}
+	}
+	return rest, ""
+}
+
+// parse3164 reads an RFC 3164 message: an optional timestamp, followed by
+// the hostname, an optional tag and the text
+func (m *Message) parse3164(rest string) {
+	rest = strings.TrimLeft(rest, " ")
+
+	timestamp := false
+	if len(rest) >= 15 {
+		if _, err := time.Parse(time.Stamp, rest[:15]); err == nil {
+			timestamp, rest = true, strings.TrimLeft(rest[15:], " ")
+		}
+	}
+	if !timestamp {
+		// Relays such as rsyslog may send RFC 3339 timestamps instead
+		field, after, _ := strings.Cut(rest, " ")
+		if t, err := time.Parse(time.RFC3339Nano, field); err == nil {
+			m.Time, timestamp, rest = t, true, after
+		}
+	}
+
+	// The hostname follows the timestamp, unless the tag does
+	if timestamp {
+		field, after, _ := strings.Cut(rest, " ")
+		if !tagPattern.MatchString(field) {
+			m.Hostname, rest = field, after
+		}
+	}
+
+	field, after, _ := strings.Cut(rest, " ")
+	if match := tagPattern.FindStringSubmatch(field); match != nil {
+		m.AppName, m.ProcID, rest = match[1], match[2], after
+	}
+	m.Text = strings.TrimSpace(rest)
+}
@@ -0,0 +1,313 @@
+// Package syslogd forwards syslog messages, so network devices that can
+// only log to a syslog server reach the chat. It listens on UDP and TCP for
+// RFC 5424 and RFC 3164 messages, keeps those of the configured severities
+// and facilities, and submits them as notifications within a rate cap.
+package syslogd
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/render"
+)
+
+// RouteName is the route of forwarded messages, e.g. for ROUTE_SYSLOG_TEMPLATE
+const RouteName = "syslog"
+
+// Limits of the listener
+const (
+	// maxMessageSize caps messages; longer ones are truncated
+	maxMessageSize = 64 * 1024
+
+	// queueSize is the number of messages waiting to be submitted; messages
+	// arriving while it is full are dropped
+	queueSize = 100
+)
+
+// errFrameTooLarge is returned for octet-counted TCP frames over
+// maxMessageSize, after which the stream cannot be followed
+var errFrameTooLarge = errors.New("frame too large")
+
+var received = metrics.NewCounter("syslog_messages_total",
+	"Syslog messages received by result: forwarded, filtered, rate_limited, overflow or failed.", "result")
+
+// Submitter delivers a notification, like handler.Handler.Submit
+type Submitter func(ctx context.Context, n *render.Notification) error
+
+// Server is the syslog listener
+type Server struct {
+	submit     Submitter
+	logger     *logger.Logger
+	threshold  int
+	facilities map[int]bool
+	limiter    *limiter
+
+	udp net.PacketConn
+	tcp net.Listener
+
+	queue chan *Message
+
+	mutex    sync.Mutex
+	sessions map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// New listens on SYSLOG_PORT with the configured protocols
+func New(cfg *config.Config, submit Submitter, logger *logger.Logger) (*Server, error) {
+	threshold, err := parseSeverity(cfg.SyslogSeverity)
+	if err != nil {
+		return nil, fmt.Errorf("SYSLOG_SEVERITY: %w", err)
+	}
+
+	s := &Server{
+		submit:    submit,
+		logger:    logger.With("component", "syslog"),
+		threshold: threshold,
+		queue:     make(chan *Message, queueSize),
+		sessions:  make(map[net.Conn]struct{}),
+	}
+	if len(cfg.SyslogFacilities) > 0 {
+		s.facilities = make(map[int]bool)
+		for _, name := range cfg.SyslogFacilities {
+			facility, err := parseFacility(name)
+			if err != nil {
+				return nil, fmt.Errorf("SYSLOG_FACILITIES: %w", err)
+			}
+			s.facilities[facility] = true
+		}
+	}
+	if cfg.SyslogMaxPerMinute > 0 {
+		s.limiter = newLimiter(cfg.SyslogMaxPerMinute)
+	}
+
+	if cfg.SyslogUDP() {
+		if s.udp, err = net.ListenPacket("udp", cfg.SyslogPort); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SyslogTCP() {
+		if s.tcp, err = net.Listen("tcp", cfg.SyslogPort); err != nil {
+			if s.udp != nil {
+				s.udp.Close()
+			}
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Run receives messages until ctx is cancelled, then closes the listeners
+// and connections and submits the messages already received
+func (s *Server) Run(ctx context.Context) {
+	s.logger.Info("Syslog listener started", "udp", s.udp != nil, "tcp", s.tcp != nil, "severity", severities[s.threshold])
+	defer s.logger.Info("Syslog listener stopped")
+
+	// Messages received before shutdown are still submitted
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range s.queue {
+			s.forward(context.WithoutCancel(ctx), m)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		if s.udp != nil {
+			s.udp.Close()
+		}
+		if s.tcp != nil {
+			s.tcp.Close()
+		}
+
+		s.mutex.Lock()
+		for conn := range s.sessions {
+			conn.Close()
+		}
+		s.mutex.Unlock()
+	}()
+
+	if s.udp != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveUDP(ctx)
+		}()
+	}
+	if s.tcp != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.acceptTCP(ctx)
+		}()
+	}
+
+	s.wg.Wait()
+	close(s.queue)
+	<-done
+}
+
+// serveUDP receives a message per datagram
+func (s *Server) serveUDP(ctx context.Context) {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Error("Failed to receive syslog datagram", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		s.receive(buf[:n], addr)
+	}
+}
+
+// acceptTCP accepts TCP connections
+func (s *Server) acceptTCP(ctx context.Context) {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Error("Failed to accept syslog connection", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		s.mutex.Lock()
+		s.sessions[conn] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveTCP(conn)
+
+			s.mutex.Lock()
+			delete(s.sessions, conn)
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+// serveTCP receives the messages of a connection, framed by octet counting
+// or by newlines (RFC 6587)
+func (s *Server) serveTCP(conn net.Conn) {
+	defer conn.Close()
+	log := s.logger.With("remote", conn.RemoteAddr().String())
+	log.Debug("Syslog connection opened")
+
+	r := bufio.NewReaderSize(conn, maxMessageSize)
+	for {
+		frame, err := readFrame(r)
+		if len(frame) > 0 {
+			s.receive(frame, conn.RemoteAddr())
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Debug("Syslog connection closed", "error", err)
+			}
+			return
+		}
+	}
+}
+
+// readFrame reads a message of a TCP stream: "<length> <message>" when it
+// starts with a digit, up to the next newline otherwise. Lines over
+// maxMessageSize are truncated.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '0' && first[0] <= '9' {
+		prefix, err := r.ReadString(' ')
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(prefix[:len(prefix)-1])
+		if err != nil || length > maxMessageSize {
+			return nil, errFrameTooLarge
+		}
+		frame := make([]byte, length)
+		_, err = io.ReadFull(r, frame)
+		return frame, err
+	}
+
+	line, err := r.ReadSlice('\n')
+	frame := append([]byte(nil), line...)
+	for err == bufio.ErrBufferFull {
+		_, err = r.ReadSlice('\n')
+	}
+	return frame, err
+}
+
+// receive filters a message and queues it for submission
+func (s *Server) receive(data []byte, addr net.Addr) {
+	remote := addr.String()
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+
+	m := parse(data, remote, time.Now())
+	if m.Severity > s.threshold || (s.facilities != nil && !s.facilities[m.Facility]) {
+		received.Inc("filtered")
+		return
+	}
+
+	if s.limiter != nil {
+		if allowed, dropped := s.limiter.allow(); !allowed {
+			if dropped == 1 {
+				s.logger.Warn("Syslog rate cap reached, dropping messages", "remote", remote)
+			}
+			received.Inc("rate_limited")
+			return
+		} else if dropped > 0 {
+			s.logger.Warn("Syslog messages were dropped by the rate cap", "dropped", dropped)
+		}
+	}
+
+	select {
+	case s.queue <- m:
+	default:
+		received.Inc("overflow")
+		s.logger.Warn("Syslog message dropped, forwarding is falling behind", "remote", remote)
+	}
+}
+
+// forward submits a message
+func (s *Server) forward(ctx context.Context, m *Message) {
+	ctx = logger.WithRequestID(ctx, newID())
+	log := s.logger.WithContext(ctx)
+
+	if err := s.submit(ctx, m.notification()); err != nil {
+		received.Inc("failed")
+		log.Error("Failed to forward syslog message", "hostname", m.Hostname, "app", m.AppName, "error", err)
+		return
+	}
+	received.Inc("forwarded")
+	log.Debug("Syslog message forwarded", "hostname", m.Hostname, "app", m.AppName, "severity", severities[m.Severity], "facility", facilities[m.Facility])
+}
+
+// newID returns a random request ID for a forwarded message
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
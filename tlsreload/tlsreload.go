@@ -0,0 +1,160 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/metrics"
+)
+
+// Certificate metrics, updated on every (re)load
+var (
+	certExpiry = metrics.NewGauge("server_tls_certificate_expiry_timestamp_seconds",
+		"Unix time the served TLS certificate expires.")
+	certReloads = metrics.NewCounter("server_tls_certificate_reloads_total",
+		"TLS certificate reloads by result.", "result")
+)
+
+// Reloader serves a TLS certificate from a certificate and key file and
+// picks up renewed files without a restart, e.g. after certbot or
+// cert-manager replaced them. A pair that fails to load keeps the previous
+// certificate in service.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   *logger.Logger
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// New loads the certificate pair and returns a reloader checking the files
+// for changes every interval
+func New(certFile, keyFile string, interval time.Duration, logger *logger.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		logger:   logger,
+	}
+
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(certMod, keyMod); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// TLSConfig returns a server TLS configuration serving the current certificate
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// GetCertificate returns the current certificate, for tls.Config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// Run reloads the certificate whenever one of the files changes, until ctx
+// is cancelled
+func (r *Reloader) Run(ctx context.Context) {
+	r.logger.Info("TLS certificate reloader started", "cert", r.certFile, "interval", r.interval)
+	defer r.logger.Info("TLS certificate reloader stopped")
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+// check reloads the certificate if the files changed since the last attempt
+func (r *Reloader) check() {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		r.logger.Warn("Failed to check TLS certificate files", "error", err)
+		return
+	}
+
+	r.mutex.RLock()
+	changed := !certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod)
+	r.mutex.RUnlock()
+	if !changed {
+		return
+	}
+
+	if err := r.load(certMod, keyMod); err != nil {
+		// The files may be mid-update; they are retried on the next change
+		certReloads.Inc("error")
+		r.logger.Error("Failed to reload TLS certificate, keeping the current one", "error", err)
+		return
+	}
+	certReloads.Inc("success")
+}
+
+// load reads the certificate pair and puts it into service. The modification
+// times are recorded even on failure, so a broken pair is not retried until
+// the files change again.
+func (r *Reloader) load(certMod, keyMod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.certMod, r.keyMod = certMod, keyMod
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse TLS certificate: %w", err)
+	}
+	cert.Leaf = leaf
+	r.cert = &cert
+
+	certExpiry.Set(float64(leaf.NotAfter.Unix()))
+	r.logger.Info("TLS certificate loaded",
+		"subject", leaf.Subject.CommonName,
+		"dns_names", leaf.DNSNames,
+		"expires", leaf.NotAfter.Format(time.RFC3339))
+
+	return nil
+}
+
+// modTimes returns the modification times of the certificate and key file
+func (r *Reloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to read TLS key: %w", err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
@@ -0,0 +1,131 @@
+// Package tracking remembers the delivery state of notifications by their
+// message ID, so senders can confirm their delivery.
+package tracking
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/storage"
+)
+
+// Message states
+const (
+	StateQueued = "queued"
+	StateSent   = "sent"
+	StateFailed = "failed"
+)
+
+// DefaultLimit is how many messages a store remembers by default
+const DefaultLimit = 10000
+
+// Status is the delivery state of a message
+type Status struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+
+	// Attempts counts the delivery attempts so far
+	Attempts int `json:"attempts"`
+
+	// Error describes the last failed attempt
+	Error string `json:"error,omitempty"`
+
+	AcceptedAt time.Time  `json:"accepted_at"`
+	SentAt     *time.Time `json:"sent_at,omitempty"`
+	FailedAt   *time.Time `json:"failed_at,omitempty"`
+}
+
+// Store holds the states of the most recently accepted messages in a
+// collection. Once it holds limit messages, the oldest is forgotten for each
+// new one. A state that cannot be stored is reported as unknown.
+type Store struct {
+	// mutex serializes the changes of states, which are read, changed and
+	// stored again
+	mutex    sync.Mutex
+	limit    int
+	messages storage.Collection
+}
+
+// NewStore creates a store remembering up to limit messages in messages
+func NewStore(messages storage.Collection, limit int) *Store {
+	return &Store{
+		limit:    limit,
+		messages: messages,
+	}
+}
+
+// Accept records a message accepted for delivery
+func (s *Store) Accept(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.put(&Status{ID: id, State: StateQueued, AcceptedAt: time.Now()}) == nil {
+		s.messages.Trim(s.limit)
+	}
+}
+
+// Sent records the delivery of a message
+func (s *Store) Sent(id string) {
+	s.update(id, func(status *Status) {
+		now := time.Now()
+		status.State = StateSent
+		status.Attempts++
+		status.Error = ""
+		status.SentAt = &now
+	})
+}
+
+// Failed records a failed delivery of a message
+func (s *Store) Failed(id string, err string) {
+	s.update(id, func(status *Status) {
+		now := time.Now()
+		status.State = StateFailed
+		status.Attempts++
+		status.Error = err
+		status.FailedAt = &now
+	})
+}
+
+// update changes the state of a message, if it is still remembered
+func (s *Store) update(id string, change func(status *Status)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if status, ok := s.get(id); ok {
+		change(status)
+		s.put(status)
+	}
+}
+
+// Get returns the state of a message; ok is false for unknown or
+// forgotten messages
+func (s *Store) Get(id string) (status *Status, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.get(id)
+}
+
+// get reads the state of a message; the caller holds the mutex
+func (s *Store) get(id string) (*Status, bool) {
+	data, err := s.messages.Get(id)
+	if err != nil {
+		return nil, false
+	}
+
+	var status Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, false
+	}
+	return &status, true
+}
+
+// put stores the state of a message; the caller holds the mutex
+func (s *Store) put(status *Status) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return s.messages.Put(status.ID, data)
+}
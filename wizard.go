@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebrahimkhodadadi/MizitoForwarder/config"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/jwt"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/logger"
+	"github.com/ebrahimkhodadadi/MizitoForwarder/mizito"
+	"github.com/joho/godotenv"
+	"golang.org/x/term"
+)
+
+// wizard asks questions on a terminal
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// errAborted is returned when the input ends before a question is
+// answered, e.g. on Ctrl-D
+var errAborted = errors.New("aborted")
+
+// ask prints a question and returns the answer, or def when it is empty.
+// It fails with errAborted when the input ends.
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+
+	answer, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		fmt.Fprintln(w.out)
+		if err == io.EOF {
+			return "", errAborted
+		}
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// require asks until a non-empty answer is given
+func (w *wizard) require(question, def string) (string, error) {
+	for {
+		answer, err := w.ask(question, def)
+		if err != nil || answer != "" {
+			return answer, err
+		}
+		fmt.Fprintln(w.out, "  A value is required.")
+	}
+}
+
+// secret asks for a value without echoing it when stdin is a terminal
+func (w *wizard) secret(question string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return w.require(question, "")
+	}
+
+	for {
+		fmt.Fprintf(w.out, "%s: ", question)
+		data, err := term.ReadPassword(fd)
+		fmt.Fprintln(w.out)
+		if err != nil {
+			if err == io.EOF {
+				return "", errAborted
+			}
+			return "", err
+		}
+		if len(data) > 0 {
+			return string(data), nil
+		}
+		fmt.Fprintln(w.out, "  A value is required.")
+	}
+}
+
+// confirm asks a yes/no question
+func (w *wizard) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	answer, err := w.ask(question+" ("+hint+")", "")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "":
+		return def, nil
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// initCommand interactively creates a starter configuration file: it asks
+// for the Mizito credentials, checks them with a test login, lets the user
+// pick a dialog and generates an app token
+func initCommand(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	output := fs.String("o", ".env", "configuration file to write")
+	force := fs.Bool("f", false, "overwrite an existing configuration file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s init [flags]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	w := &wizard{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	if err := runWizard(w, *output, *force); err != nil {
+		if err != errAborted {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// runWizard asks the questions of the init command and writes the
+// configuration to output. It fails with errAborted when the user declines
+// to go on or the input ends.
+func runWizard(w *wizard, output string, force bool) error {
+	if _, err := os.Stat(output); err == nil && !force {
+		overwrite, err := w.confirm(output+" exists. Overwrite it?", false)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			return errAborted
+		}
+	}
+
+	fmt.Fprintln(w.out, "This wizard creates a configuration for Mizito Forwarder.")
+	fmt.Fprintln(w.out)
+
+	cfg := config.DefaultConfig()
+	var err error
+	if cfg.JWTTokenFile, err = w.ask("Token file", cfg.JWTTokenFile); err != nil {
+		return err
+	}
+
+	// Quiet logger: the wizard reports progress itself
+	log, _ := logger.NewLogger("error")
+
+	var messageService *mizito.MessageService
+	for {
+		if cfg.MizitoBaseURL, err = w.require("Mizito URL", cfg.MizitoBaseURL); err != nil {
+			return err
+		}
+		if cfg.MizitoUsername, err = w.require("Mizito username", cfg.MizitoUsername); err != nil {
+			return err
+		}
+		if cfg.MizitoPassword, err = w.secret("Mizito password"); err != nil {
+			return err
+		}
+		cfg.MizitoLoginURL = config.ResolveURL(cfg.MizitoBaseURL, cfg.MizitoLoginPath)
+		cfg.MizitoChatAPIURL = config.ResolveURL(cfg.MizitoBaseURL, cfg.MizitoChatPath)
+
+		fmt.Fprintln(w.out, "Logging in...")
+		authService := mizito.NewAuthService(cfg, jwt.NewManager(cfg, log), log)
+		if err := authService.Login(context.Background()); err == nil {
+			fmt.Fprintln(w.out, "Login successful.")
+			messageService = mizito.NewMessageService(cfg, authService, log)
+			break
+		}
+
+		fmt.Fprintf(w.out, "Login failed: %s\n", authService.Stats().LastError)
+		retry, err := w.confirm("Try again?", true)
+		if err != nil {
+			return err
+		}
+		if !retry {
+			return errAborted
+		}
+	}
+	fmt.Fprintln(w.out)
+
+	if cfg.MizitoDialogID, err = chooseDialog(w, cfg, messageService); err != nil {
+		return err
+	}
+	if cfg.MizitoFromUserID, err = w.require("Your Mizito user ID (sender of forwarded messages)", ""); err != nil {
+		return err
+	}
+	serverPort, err := w.ask("Listen address", cfg.ServerPort)
+	if err != nil {
+		return err
+	}
+
+	token := make([]byte, 24)
+	rand.Read(token)
+	appToken := hex.EncodeToString(token)
+	rand.Read(token)
+	adminToken := hex.EncodeToString(token)
+
+	values := map[string]string{
+		"SERVER_PORT":         serverPort,
+		"MIZITO_BASE_URL":     cfg.MizitoBaseURL,
+		"MIZITO_USERNAME":     cfg.MizitoUsername,
+		"MIZITO_PASSWORD":     cfg.MizitoPassword,
+		"MIZITO_DIALOG_ID":    cfg.MizitoDialogID,
+		"MIZITO_FROM_USER_ID": cfg.MizitoFromUserID,
+		"JWT_TOKEN_FILE":      cfg.JWTTokenFile,
+		"APP_TOKEN":           appToken,
+		"ADMIN_TOKEN":         adminToken,
+		"LOG_LEVEL":           cfg.LogLevel,
+	}
+
+	content, err := godotenv.Marshal(values)
+	if err != nil {
+		return err
+	}
+	header := "# Generated by mizito-forwarder init on " + time.Now().Format("2006-01-02") +
+		"\n# See .env.example for all settings\n"
+
+	// The file holds the Mizito password and the tokens
+	if err := os.WriteFile(output, []byte(header+content+"\n"), 0600); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w.out)
+	fmt.Fprintf(w.out, "Configuration written to %s.\n", output)
+	fmt.Fprintf(w.out, "App token: %s\n", appToken)
+	fmt.Fprintf(w.out, "Admin token: %s\n", adminToken)
+	fmt.Fprintln(w.out, "Send a test notification with:")
+	fmt.Fprintf(w.out, "  curl -X POST -H 'Authorization: Bearer %s' -d '{\"title\":\"Hello\",\"message\":\"It works\"}' http://localhost%s/message\n",
+		appToken, values["SERVER_PORT"])
+	return nil
+}
+
+// chooseDialog lets the user pick the default dialog from the dialog list of
+// the account, or enter its ID when no list is available
+func chooseDialog(w *wizard, cfg *config.Config, messageService *mizito.MessageService) (string, error) {
+	path, err := w.ask("Dialog list endpoint path (empty to enter the dialog ID yourself)", "")
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return w.require("Dialog ID to forward notifications to", "")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dialogs, err := messageService.ListDialogs(ctx, config.ResolveURL(cfg.MizitoBaseURL, path))
+	if err != nil || len(dialogs) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no dialogs found")
+		}
+		fmt.Fprintf(w.out, "Could not list dialogs: %v\n", err)
+		return w.require("Dialog ID to forward notifications to", "")
+	}
+
+	fmt.Fprintln(w.out, "Dialogs:")
+	for i, dialog := range dialogs {
+		fmt.Fprintf(w.out, "  %2d) %s (%s)\n", i+1, dialog.Title, dialog.ID)
+	}
+
+	// The ID of a listed dialog is taken as is, even when it is numeric;
+	// other numbers in the range of the list pick a dialog by its position
+	answer, err := w.require("Dialog to forward notifications to (number in the list or ID)", "1")
+	if err != nil {
+		return "", err
+	}
+	for _, dialog := range dialogs {
+		if dialog.ID == answer {
+			return answer, nil
+		}
+	}
+	if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(dialogs) {
+		return dialogs[n-1].ID, nil
+	}
+	return answer, nil
+}